@@ -6,15 +6,32 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/natamm4/audit-tool/pkg/audit/runstats"
 	"github.com/natamm4/audit-tool/pkg/cmd/query"
 
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 
 	cmdutil "k8s.io/kubectl/pkg/cmd/util"
 
+	"github.com/natamm4/audit-tool/pkg/cmd/cache"
+	"github.com/natamm4/audit-tool/pkg/cmd/compact"
+	"github.com/natamm4/audit-tool/pkg/cmd/daemon"
+	"github.com/natamm4/audit-tool/pkg/cmd/export"
+	"github.com/natamm4/audit-tool/pkg/cmd/generate"
 	"github.com/natamm4/audit-tool/pkg/cmd/get"
+	"github.com/natamm4/audit-tool/pkg/cmd/history"
+	"github.com/natamm4/audit-tool/pkg/cmd/index"
+	"github.com/natamm4/audit-tool/pkg/cmd/mark"
+	"github.com/natamm4/audit-tool/pkg/cmd/prune"
+	"github.com/natamm4/audit-tool/pkg/cmd/release"
+	"github.com/natamm4/audit-tool/pkg/cmd/report"
+	"github.com/natamm4/audit-tool/pkg/cmd/sql"
+	"github.com/natamm4/audit-tool/pkg/cmd/verify"
+	"github.com/natamm4/audit-tool/pkg/cmd/workspace"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -37,13 +54,30 @@ func main() {
 
 	ctx := context.TODO()
 
+	// the jobs of the daemon write the counters of the events they read when they exit, also on a fatal error
+	cmdutil.BehaviorOnFatal(func(msg string, code int) {
+		writeRunStats()
+		if len(msg) > 0 {
+			fmt.Fprintln(os.Stderr, strings.TrimSuffix(msg, "\n"))
+		}
+		os.Exit(code)
+	})
+
 	command := NewAuditToolCommand(ctx)
-	if err := command.Execute(); err != nil {
+	err := command.Execute()
+	writeRunStats()
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 
 	}
 }
+
+func writeRunStats() {
+	if err := runstats.Write(); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to write the counters of the run: %v\n", err)
+	}
+}
 func NewAuditToolCommand(ctx context.Context) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "audit-tool",
@@ -63,6 +97,38 @@ func NewAuditToolCommand(ctx context.Context) *cobra.Command {
 
 	cmd.AddCommand(get.NewCommand(ctx, f, ioStreams))
 	cmd.AddCommand(query.NewCommand(ctx, f, ioStreams))
-
+	cmd.AddCommand(export.NewCommand(ctx, f, ioStreams))
+	cmd.AddCommand(compact.NewCommand(ctx, f, ioStreams))
+	cmd.AddCommand(index.NewCommand(ctx, f, ioStreams))
+	cmd.AddCommand(sql.NewCommand(ctx, f, ioStreams))
+	cmd.AddCommand(report.NewCommand(ctx, f, ioStreams))
+	cmd.AddCommand(workspace.NewCommand(ctx, f, ioStreams))
+	cmd.AddCommand(mark.NewCommand(ctx, f, ioStreams))
+	cmd.AddCommand(cache.NewCommand(ctx, f, ioStreams))
+	cmd.AddCommand(release.NewCommand(ctx, f, ioStreams))
+	cmd.AddCommand(daemon.NewCommand(ctx, f, ioStreams))
+	cmd.AddCommand(generate.NewCommand(ctx, f, ioStreams))
+	cmd.AddCommand(prune.NewCommand(ctx, f, ioStreams))
+	cmd.AddCommand(verify.NewCommand(ctx, f, ioStreams))
+	cmd.AddCommand(history.NewCommand(ctx, f, ioStreams))
+
+	if isKubectlPlugin() {
+		asKubectlPlugin(cmd)
+	}
 	return cmd
 }
+
+// isKubectlPlugin is true when the binary is installed as kubectl plugin, eg. by krew, and run by "kubectl audit".
+func isKubectlPlugin() bool {
+	return strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe") == release.PluginName
+}
+
+// asKubectlPlugin names the commands as they are run through kubectl in the help. The kubeconfig flags are passed
+// through by kubectl when they follow the plugin name, eg. "kubectl audit get --context prod".
+func asKubectlPlugin(cmd *cobra.Command) {
+	cmd.Use = strings.TrimPrefix(release.PluginName, "kubectl-")
+	usage := cmd.UsageTemplate()
+	usage = strings.ReplaceAll(usage, "{{.UseLine}}", "kubectl {{.UseLine}}")
+	usage = strings.ReplaceAll(usage, "{{.CommandPath}}", "kubectl {{.CommandPath}}")
+	cmd.SetUsageTemplate(usage)
+}
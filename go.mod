@@ -3,11 +3,15 @@ module github.com/natamm4/audit-tool
 go 1.16
 
 require (
+	github.com/itchyny/gojq v0.12.4
 	github.com/json-iterator/go v1.1.11
+	github.com/klauspost/compress v1.15.9
+	github.com/modern-go/reflect2 v1.0.1
 	github.com/pterm/pterm v0.12.42
 	github.com/sirupsen/logrus v1.8.1
 	github.com/spf13/cobra v1.2.1
 	github.com/spf13/pflag v1.0.5
+	github.com/xitongsys/parquet-go v1.6.2
 	k8s.io/api v0.22.1
 	k8s.io/apimachinery v0.22.1
 	k8s.io/apiserver v0.22.1
@@ -16,4 +20,5 @@ require (
 	k8s.io/component-base v0.22.1
 	k8s.io/klog/v2 v2.9.0
 	k8s.io/kubectl v0.22.1
+	sigs.k8s.io/yaml v1.2.0
 )
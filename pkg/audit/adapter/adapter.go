@@ -0,0 +1,135 @@
+// Package adapter converts the audit logs of managed Kubernetes services into Kubernetes audit events on the fly, so
+// that they are queried like the audit logs collected from the API servers:
+//
+//   - GKE: Cloud Logging entries of the k8s.io service exported as JSON lines (eg. by a Cloud Storage sink or
+//     'gcloud logging read --format=json | jq -c .[]'), see convertGKE.
+//   - EKS: CloudWatch Logs events, as delivered by subscription filters, exported to S3 or flattened from
+//     'aws logs filter-log-events' and Logs Insights results, see convertCloudWatch.
+//   - AKS: diagnostic setting records of the kube-audit and kube-audit-admin categories, written to a storage account
+//     or an event hub, see convertAKS.
+//
+// The format is detected from the first line of a file, files of Kubernetes audit events are passed through untouched.
+package adapter
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"regexp"
+)
+
+// Format is an audit log format converted by this package.
+type Format string
+
+const (
+	FormatGKE        Format = "gke"
+	FormatCloudWatch Format = "cloudwatch"
+	FormatAKS        Format = "aks"
+)
+
+const (
+	// detectSize is the size of the beginning of the first line the format is detected from, the distinctive fields
+	// are at the top of the records.
+	detectSize = 64 * 1024
+	// nativeDetectSize is the size of the beginning of the first line searched for the audit ID of a Kubernetes
+	// audit event, which precedes the request and response objects.
+	nativeDetectSize = 4 * 1024
+)
+
+// s3ExportRegexp matches the timestamp CloudWatch Logs prefixes the events exported to S3 with.
+var s3ExportRegexp = regexp.MustCompile(`^\d{4}-\d\d-\d\dT[0-9:.]+Z? \{`)
+
+// Detect returns the format of the audit log the line is the first line of, empty for Kubernetes audit events and
+// unknown formats.
+func Detect(line []byte) Format {
+	// the events exported to S3 are the only ones embedded as is
+	if s3ExportRegexp.Match(line) {
+		return FormatCloudWatch
+	}
+	native := line
+	if len(native) > nativeDetectSize {
+		native = native[:nativeDetectSize]
+	}
+	// the audit ID of the events embedded by the other formats is escaped
+	if bytes.Contains(native, []byte(`"auditID"`)) {
+		return ""
+	}
+	switch {
+	case bytes.Contains(line, []byte(`"protoPayload"`)):
+		return FormatGKE
+	case bytes.Contains(line, []byte(`"logEvents"`)), bytes.Contains(line, []byte(`"@message"`)),
+		bytes.Contains(line, []byte(`"message"`)) && (bytes.Contains(line, []byte(`"logStreamName"`)) || bytes.Contains(line, []byte(`"ingestionTime"`))):
+		return FormatCloudWatch
+	case bytes.Contains(line, []byte(`"category"`)) && (bytes.Contains(line, []byte(`"properties"`)) || bytes.Contains(line, []byte(`"records"`))):
+		return FormatAKS
+	}
+	return ""
+}
+
+// converters convert a line of an audit log format into the Kubernetes audit events it holds, as JSON.
+var converters = map[Format]func(line []byte) ([][]byte, error){
+	FormatGKE:        convertGKE,
+	FormatCloudWatch: convertCloudWatch,
+	FormatAKS:        convertAKS,
+}
+
+// NewReader returns the audit log lines of r as Kubernetes audit events, one per line. The lines are passed through
+// unchanged when r holds Kubernetes audit events already.
+func NewReader(r io.ReadCloser) io.ReadCloser {
+	br := bufio.NewReaderSize(r, detectSize)
+	// a short read is fine, small files are detected from what there is
+	peek, _ := br.Peek(detectSize)
+	if i := bytes.IndexByte(peek, '\n'); i >= 0 {
+		peek = peek[:i]
+	}
+	format := Detect(bytes.TrimSpace(peek))
+	if len(format) == 0 {
+		return &readCloser{Reader: br, Closer: r}
+	}
+	return &reader{lines: br, closer: r, convert: converters[format]}
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// reader converts the lines of r one at a time.
+type reader struct {
+	lines   *bufio.Reader
+	closer  io.Closer
+	convert func(line []byte) ([][]byte, error)
+
+	pending []byte
+	err     error
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		line, err := r.lines.ReadBytes('\n')
+		r.err = err
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		events, convertErr := r.convert(line)
+		if convertErr != nil {
+			// the line is passed on as is, the event decoder reports it as invalid
+			events = [][]byte{line}
+		}
+		for _, event := range events {
+			r.pending = append(r.pending, event...)
+			r.pending = append(r.pending, '\n')
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (r *reader) Close() error {
+	return r.closer.Close()
+}
@@ -0,0 +1,49 @@
+package adapter
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// aksAuditCategories are the diagnostic log categories holding the audit events of AKS, kube-audit-admin leaves out
+// the get and list requests.
+var aksAuditCategories = map[string]bool{
+	"kube-audit":       true,
+	"kube-audit-admin": true,
+}
+
+// aksRecord is a record of an AKS diagnostic setting, the log property of the audit categories is a Kubernetes audit
+// event.
+type aksRecord struct {
+	Category   string `json:"category"`
+	Properties struct {
+		Log string `json:"log"`
+	} `json:"properties"`
+}
+
+// convertAKS converts a line of the AKS diagnostic logs: a record written to a storage account, or a batch of records
+// sent to an event hub. The records of the other categories, eg. the API server logs, are skipped.
+func convertAKS(line []byte) ([][]byte, error) {
+	batch := struct {
+		aksRecord
+		Records []aksRecord `json:"records"`
+	}{}
+	if err := json.Unmarshal(line, &batch); err != nil {
+		return nil, err
+	}
+	records := batch.Records
+	if records == nil {
+		records = []aksRecord{batch.aksRecord}
+	}
+	events := [][]byte{}
+	for _, record := range records {
+		if !aksAuditCategories[record.Category] {
+			continue
+		}
+		if len(record.Properties.Log) == 0 {
+			return nil, fmt.Errorf("AKS %s record without log", record.Category)
+		}
+		events = append(events, []byte(record.Properties.Log))
+	}
+	return events, nil
+}
@@ -0,0 +1,69 @@
+package adapter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// cloudWatchEvent is a CloudWatch Logs event, the message of the events of the EKS audit log stream is a Kubernetes
+// audit event. Logs Insights results name the message @message.
+type cloudWatchEvent struct {
+	Message         *string `json:"message"`
+	InsightsMessage *string `json:"@message"`
+}
+
+// convertCloudWatch converts a line of an EKS audit log exported from CloudWatch Logs: a subscription filter record
+// holding a batch of log events, a single log event, or a line of an export to S3, which is the message prefixed with
+// its timestamp. The control plane log group holds the logs of the API server and the authenticator too, the events
+// of other streams than the audit log are skipped.
+func convertCloudWatch(line []byte) ([][]byte, error) {
+	if line[0] != '{' {
+		// the timestamp of an S3 export
+		i := bytes.IndexByte(line, '{')
+		if i < 0 {
+			return nil, fmt.Errorf("not a CloudWatch Logs event")
+		}
+		return auditMessages(line[i:]), nil
+	}
+
+	record := struct {
+		cloudWatchEvent
+		MessageType string            `json:"messageType"`
+		LogEvents   []cloudWatchEvent `json:"logEvents"`
+	}{}
+	if err := json.Unmarshal(line, &record); err != nil {
+		return nil, err
+	}
+	if len(record.MessageType) > 0 {
+		// subscription filters send control messages to check the destination is reachable, they hold no events
+		messages := [][]byte{}
+		for _, e := range record.LogEvents {
+			messages = append(messages, auditMessages(e.message())...)
+		}
+		return messages, nil
+	}
+	message := record.message()
+	if message == nil {
+		return nil, fmt.Errorf("CloudWatch Logs event without message")
+	}
+	return auditMessages(message), nil
+}
+
+// auditMessages returns the message when it is an audit event.
+func auditMessages(message []byte) [][]byte {
+	if !bytes.Contains(message, []byte(`"auditID"`)) {
+		return nil
+	}
+	return [][]byte{message}
+}
+
+func (e cloudWatchEvent) message() []byte {
+	switch {
+	case e.Message != nil:
+		return []byte(*e.Message)
+	case e.InsightsMessage != nil:
+		return []byte(*e.InsightsMessage)
+	}
+	return nil
+}
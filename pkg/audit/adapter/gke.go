@@ -0,0 +1,179 @@
+package adapter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+// gkeEntry is a Cloud Logging entry of the GKE audit logs, the protoPayload is a google.cloud.audit.AuditLog.
+type gkeEntry struct {
+	InsertID  string            `json:"insertId"`
+	Timestamp time.Time         `json:"timestamp"`
+	Labels    map[string]string `json:"labels"`
+	Operation *struct {
+		ID    string `json:"id"`
+		First bool   `json:"first"`
+		Last  bool   `json:"last"`
+	} `json:"operation"`
+	ProtoPayload *struct {
+		ServiceName        string `json:"serviceName"`
+		MethodName         string `json:"methodName"`
+		ResourceName       string `json:"resourceName"`
+		AuthenticationInfo struct {
+			PrincipalEmail string `json:"principalEmail"`
+		} `json:"authenticationInfo"`
+		RequestMetadata struct {
+			CallerIP                string `json:"callerIp"`
+			CallerSuppliedUserAgent string `json:"callerSuppliedUserAgent"`
+		} `json:"requestMetadata"`
+		Status *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"status"`
+		Request  map[string]json.RawMessage `json:"request"`
+		Response map[string]json.RawMessage `json:"response"`
+	} `json:"protoPayload"`
+}
+
+// gkeStatusCodes maps the google.rpc.Code of the audit log status to the HTTP status code of the response.
+var gkeStatusCodes = map[int]int32{
+	0:  http.StatusOK,
+	1:  499,
+	2:  http.StatusInternalServerError,
+	3:  http.StatusBadRequest,
+	4:  http.StatusGatewayTimeout,
+	5:  http.StatusNotFound,
+	6:  http.StatusConflict,
+	7:  http.StatusForbidden,
+	8:  http.StatusTooManyRequests,
+	9:  http.StatusBadRequest,
+	10: http.StatusConflict,
+	11: http.StatusBadRequest,
+	12: http.StatusNotImplemented,
+	13: http.StatusInternalServerError,
+	14: http.StatusServiceUnavailable,
+	15: http.StatusInternalServerError,
+	16: http.StatusUnauthorized,
+}
+
+// convertGKE converts a Cloud Logging entry of the GKE audit logs. The entries of other services than k8s.io, eg. the
+// GKE API itself, are skipped. Cloud Logging records a single timestamp and neither the groups of the user nor the
+// audit level, the level is derived from the recorded objects and the timestamp is used as both the time the request
+// was received and the time of the stage.
+func convertGKE(line []byte) ([][]byte, error) {
+	entry := gkeEntry{}
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return nil, err
+	}
+	payload := entry.ProtoPayload
+	if payload == nil {
+		return nil, fmt.Errorf("Cloud Logging entry without protoPayload")
+	}
+	if payload.ServiceName != "k8s.io" {
+		return nil, nil
+	}
+
+	e := &auditv1.Event{
+		TypeMeta:                 metav1.TypeMeta{Kind: "Event", APIVersion: auditv1.SchemeGroupVersion.String()},
+		Level:                    "Metadata",
+		AuditID:                  types.UID(entry.InsertID),
+		Stage:                    auditv1.StageResponseComplete,
+		Verb:                     payload.MethodName[strings.LastIndex(payload.MethodName, ".")+1:],
+		User:                     authenticationv1.UserInfo{Username: payload.AuthenticationInfo.PrincipalEmail},
+		UserAgent:                payload.RequestMetadata.CallerSuppliedUserAgent,
+		RequestReceivedTimestamp: metav1.NewMicroTime(entry.Timestamp),
+		StageTimestamp:           metav1.NewMicroTime(entry.Timestamp),
+		Annotations:              entry.Labels,
+	}
+	if entry.Operation != nil && len(entry.Operation.ID) > 0 {
+		// the entries of the stages of a long running request, eg. a watch, share the operation
+		e.AuditID = types.UID(entry.Operation.ID)
+		if entry.Operation.First && !entry.Operation.Last {
+			e.Stage = auditv1.StageRequestReceived
+		}
+	}
+	if len(payload.RequestMetadata.CallerIP) > 0 {
+		e.SourceIPs = []string{payload.RequestMetadata.CallerIP}
+	}
+	e.RequestURI, e.ObjectRef = gkeResource(payload.ResourceName)
+	if payload.Status != nil {
+		code, ok := gkeStatusCodes[payload.Status.Code]
+		if !ok {
+			code = http.StatusInternalServerError
+		}
+		e.ResponseStatus = &metav1.Status{Code: code, Message: payload.Status.Message}
+		if code >= 300 {
+			e.ResponseStatus.Status = metav1.StatusFailure
+		} else {
+			e.ResponseStatus.Status = metav1.StatusSuccess
+		}
+	} else if e.Stage == auditv1.StageResponseComplete {
+		e.ResponseStatus = &metav1.Status{Code: http.StatusOK, Status: metav1.StatusSuccess}
+	}
+	if payload.Request != nil {
+		e.Level = "Request"
+		e.RequestObject = gkeObject(payload.Request)
+	}
+	if payload.Response != nil {
+		e.Level = "RequestResponse"
+		e.ResponseObject = gkeObject(payload.Response)
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{data}, nil
+}
+
+// gkeResource returns the request URI and the object reference of a resource name like
+// core/v1/namespaces/default/pods/nginx/log or apps/v1/namespaces/default/deployments, the core group is named core.
+func gkeResource(name string) (string, *auditv1.ObjectReference) {
+	parts := strings.Split(strings.Trim(name, "/"), "/")
+	if len(parts) < 3 {
+		// a non-resource request, eg. readyz
+		return "/" + strings.Join(parts, "/"), nil
+	}
+	ref := &auditv1.ObjectReference{APIGroup: parts[0], APIVersion: parts[1]}
+	uri := "/apis/" + parts[0] + "/" + parts[1]
+	if parts[0] == "core" {
+		ref.APIGroup = ""
+		uri = "/api/" + parts[1]
+	}
+	uri += "/" + strings.Join(parts[2:], "/")
+	rest := parts[2:]
+	if len(rest) > 2 && rest[0] == "namespaces" {
+		ref.Namespace = rest[1]
+		rest = rest[2:]
+	}
+	ref.Resource = rest[0]
+	if len(rest) > 1 {
+		ref.Name = rest[1]
+	}
+	if len(rest) > 2 {
+		ref.Subresource = strings.Join(rest[2:], "/")
+	}
+	if ref.Resource == "namespaces" && len(ref.Name) > 0 {
+		ref.Namespace = ref.Name
+	}
+	return uri, ref
+}
+
+// gkeObject returns the object of a request or response without the @type field Cloud Logging adds.
+func gkeObject(object map[string]json.RawMessage) *runtime.Unknown {
+	delete(object, "@type")
+	data, err := json.Marshal(object)
+	if err != nil {
+		return nil
+	}
+	return &runtime.Unknown{Raw: data, ContentType: runtime.ContentTypeJSON}
+}
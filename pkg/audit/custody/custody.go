@@ -0,0 +1,160 @@
+// Package custody records the chain of custody of audit log dumps: every operation that collected or deleted audit
+// files is appended to an operations log in the directory, so that it can be told who fetched what and when. Every
+// entry holds the checksum of the previous one, editing or removing an entry breaks the chain.
+package custody
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileName is the name of the operations log in the audit directory, hidden so that it isn't checksummed in the
+// manifest or pruned.
+const FileName = ".audit-tool-operations.jsonl"
+
+// Operation is an entry of the operations log.
+type Operation struct {
+	Time time.Time `json:"time"`
+	// Operation is the command, eg. get or prune
+	Operation string `json:"operation"`
+	// User and Host are the local user who ran the command and where
+	User    string   `json:"user"`
+	Host    string   `json:"host,omitempty"`
+	Command []string `json:"command"`
+	// Cluster is the API server URL or the hosts the audit logs were collected from, ClusterID the OpenShift cluster ID
+	Cluster   string `json:"cluster,omitempty"`
+	ClusterID string `json:"clusterID,omitempty"`
+	// Files are the files collected or deleted, relative to the directory
+	Files []string `json:"files,omitempty"`
+	// Previous is the SHA-256 of the previous entry of the log
+	Previous string `json:"previous,omitempty"`
+
+	// Intact is false when the previous entry doesn't match the recorded checksum, the log was edited
+	Intact bool `json:"-"`
+}
+
+// Record appends the operation to the log of the directory, defaulting the time, user, host and command to the
+// running process.
+func Record(dir string, op Operation) error {
+	if op.Time.IsZero() {
+		op.Time = time.Now().UTC()
+	}
+	if len(op.User) == 0 {
+		op.User = currentUser()
+	}
+	if len(op.Host) == 0 {
+		op.Host, _ = os.Hostname()
+	}
+	if op.Command == nil {
+		op.Command = redact(os.Args)
+	}
+	last, err := lastLine(filepath.Join(dir, FileName))
+	if err != nil {
+		return err
+	}
+	if len(last) > 0 {
+		op.Previous = checksum(last)
+	}
+	data, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, FileName), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Read returns the operations of the log of the directory, oldest first, none when there is no log.
+func Read(dir string) ([]Operation, error) {
+	path := filepath.Join(dir, FileName)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	operations := []Operation{}
+	previous := []byte(nil)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		op := Operation{}
+		if err := json.Unmarshal(line, &op); err != nil {
+			return nil, fmt.Errorf("invalid entry %d of %s: %v", len(operations)+1, path, err)
+		}
+		if len(operations) == 0 {
+			op.Intact = len(op.Previous) == 0
+		} else {
+			op.Intact = op.Previous == checksum(previous)
+		}
+		previous = append(previous[:0], line...)
+		operations = append(operations, op)
+	}
+	return operations, scanner.Err()
+}
+
+func lastLine(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimRight(data, "\n")
+	return data[bytes.LastIndexByte(data, '\n')+1:], nil
+}
+
+func checksum(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+// secretFlags are the flags whose values are not recorded.
+var secretFlags = []string{"--token", "--password"}
+
+// redact replaces the values of the secret flags of the command line.
+func redact(args []string) []string {
+	result := make([]string, len(args))
+	copy(result, args)
+	for i, arg := range result {
+		for _, flag := range secretFlags {
+			switch {
+			case arg == flag && i+1 < len(result):
+				result[i+1] = "REDACTED"
+			case strings.HasPrefix(arg, flag+"="):
+				result[i] = flag + "=REDACTED"
+			}
+		}
+	}
+	return result
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
@@ -0,0 +1,98 @@
+// Package decompress opens audit log files regardless of how they were compressed. The format is detected by the
+// magic bytes rather than the file extension, as collection pipelines recompress logs without always renaming them.
+// Parquet files written by export parquet are read as audit logs too, and so are the audit logs of managed Kubernetes
+// services, which are converted by package adapter.
+package decompress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/natamm4/audit-tool/pkg/audit/adapter"
+	"github.com/natamm4/audit-tool/pkg/audit/parquet"
+)
+
+var (
+	gzipMagic           = []byte{0x1f, 0x8b}
+	bzip2Magic          = []byte("BZh")
+	zstdMagic           = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	zstdSkippableSuffix = []byte{0x2a, 0x4d, 0x18}
+	parquetMagic        = []byte("PAR1")
+)
+
+// zstdMaxWindowSize bounds the memory a single frame can ask for, the reference encoder uses at most 128MiB with --long.
+const zstdMaxWindowSize = 1 << 28
+
+// Extensions are the file extensions of the supported compression formats and of Parquet files.
+var Extensions = []string{".gz", ".bz2", ".zst", parquet.Extension}
+
+// NewReader returns the decompressed content of r, which is gzip, bzip2 or zstd compressed or not compressed at all.
+// The events of Parquet files are returned as audit log lines, the audit logs of managed Kubernetes services are
+// converted into Kubernetes audit events.
+func NewReader(r io.Reader) (io.ReadCloser, error) {
+	decompressed, err := newDecompressor(r)
+	if err != nil {
+		return nil, err
+	}
+	return adapter.NewReader(decompressed), nil
+}
+
+func newDecompressor(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	// a short read leaves less than the magic, which is fine for small uncompressed files
+	magic, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, bzip2Magic):
+		return io.NopCloser(bzip2.NewReader(br)), nil
+	case bytes.HasPrefix(magic, zstdMagic), len(magic) == 4 && magic[0]&0xf0 == 0x50 && bytes.Equal(magic[1:], zstdSkippableSuffix):
+		// decoding in the reading goroutine, the events are parsed as fast as the frames are decompressed anyway
+		decoder, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(zstdMaxWindowSize))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case bytes.HasPrefix(magic, parquetMagic):
+		return newParquetReader(r, br)
+	default:
+		return io.NopCloser(br), nil
+	}
+}
+
+// TrimExtension removes the extension of the compression format from the file name, if any.
+func TrimExtension(name string) string {
+	for _, extension := range Extensions {
+		if strings.HasSuffix(name, extension) {
+			return strings.TrimSuffix(name, extension)
+		}
+	}
+	return name
+}
+
+// newParquetReader reads the Parquet file directly when it is a local file, the metadata is at its end. Other sources
+// are read into memory first.
+func newParquetReader(r io.Reader, br *bufio.Reader) (io.ReadCloser, error) {
+	if f, ok := r.(interface {
+		io.ReaderAt
+		Stat() (os.FileInfo, error)
+	}); ok {
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		return parquet.NewEventReader(f, info.Size())
+	}
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	return parquet.NewEventReader(bytes.NewReader(data), int64(len(data)))
+}
@@ -0,0 +1,138 @@
+package decompress
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// The zstd frames in testdata were compressed by the zstd CLI (v1.5.6) from the inputs below:
+//
+//	zstd --fast=5 / -1 / -3 / -9 / -19 / --no-check audit.log   -> audit-*.zst
+//	zstd --target-compressed-block-size=2048 audit.log         -> audit-blocks.zst
+//	cat audit.log | zstd                                       -> audit-stream.zst (no content size)
+//	zstd --long=27 long.log                                    -> long.zst
+//	cat long.log | zstd --long=27                              -> long-stream.zst (128MiB window)
+//	zstd random.bin / zeros.bin                                -> random.zst, zeros.zst (raw and RLE blocks)
+
+// auditLog returns lines resembling audit events, compressible with repeated fields and random IDs.
+func auditLog() []byte {
+	random := rand.New(rand.NewSource(1))
+	verbs := []string{"get", "list", "watch", "update", "patch", "delete"}
+	resources := []string{"pods", "configmaps", "secrets", "leases", "nodes"}
+	b := &bytes.Buffer{}
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(b, `{"kind":"Event","apiVersion":"audit.k8s.io/v1","level":"Metadata","auditID":"%08x-%04x","stage":"ResponseComplete","requestURI":"/api/v1/namespaces/ns-%d/%s","verb":"%s","user":{"username":"user-%d"},"responseStatus":{"code":%d}}`+"\n",
+			random.Uint32(), random.Intn(1<<16), random.Intn(50), resources[random.Intn(len(resources))], verbs[random.Intn(len(verbs))], random.Intn(20), 200+random.Intn(3)*100)
+	}
+	return b.Bytes()
+}
+
+// longLog returns a random chunk repeated after 3MiB of filler, further back than the default window of the encoder.
+func longLog() []byte {
+	random := rand.New(rand.NewSource(2))
+	chunk := make([]byte, 64<<10)
+	for i := range chunk {
+		chunk[i] = 'a' + byte(random.Intn(26))
+	}
+	b := &bytes.Buffer{}
+	b.Write(chunk)
+	for b.Len() < 3<<20 {
+		b.WriteString(`{"kind":"Event","verb":"get","requestURI":"/healthz"}` + "\n")
+	}
+	b.Write(chunk)
+	return b.Bytes()
+}
+
+func randomBytes() []byte {
+	random := rand.New(rand.NewSource(3))
+	b := make([]byte, 32<<10)
+	random.Read(b)
+	return b
+}
+
+func zeros() []byte {
+	return make([]byte, 1<<20)
+}
+
+func fixture(t *testing.T, name string) []byte {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func decompress(input []byte) ([]byte, error) {
+	r, err := newDecompressor(bytes.NewReader(input))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func TestZstd(t *testing.T) {
+	// a skippable frame with 5 bytes of user data
+	skippable := []byte{0x5A, 0x2A, 0x4D, 0x18, 5, 0, 0, 0, 1, 2, 3, 4, 5}
+
+	tests := []struct {
+		name     string
+		frames   []string
+		prefix   []byte
+		expected []byte
+	}{
+		{name: "fast level 5", frames: []string{"audit-fast.zst"}, expected: auditLog()},
+		{name: "level 1", frames: []string{"audit-1.zst"}, expected: auditLog()},
+		{name: "level 3", frames: []string{"audit-3.zst"}, expected: auditLog()},
+		{name: "level 9", frames: []string{"audit-9.zst"}, expected: auditLog()},
+		{name: "level 19", frames: []string{"audit-19.zst"}, expected: auditLog()},
+		{name: "without checksum", frames: []string{"audit-nocheck.zst"}, expected: auditLog()},
+		{name: "small blocks", frames: []string{"audit-blocks.zst"}, expected: auditLog()},
+		{name: "without content size", frames: []string{"audit-stream.zst"}, expected: auditLog()},
+		{name: "long distance matching", frames: []string{"long.zst"}, expected: longLog()},
+		{name: "long window", frames: []string{"long-stream.zst"}, expected: longLog()},
+		{name: "raw blocks", frames: []string{"random.zst"}, expected: randomBytes()},
+		{name: "RLE blocks", frames: []string{"zeros.zst"}, expected: zeros()},
+		{name: "multiple frames", frames: []string{"audit-1.zst", "zeros.zst", "audit-19.zst"}, expected: bytes.Join([][]byte{auditLog(), zeros(), auditLog()}, nil)},
+		// detected by the magic of the skippable frame
+		{name: "skippable frame first", frames: []string{"audit-1.zst"}, prefix: skippable, expected: auditLog()},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			input := append([]byte{}, test.prefix...)
+			for _, frame := range test.frames {
+				input = append(input, fixture(t, frame)...)
+			}
+			out, err := decompress(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out, test.expected) {
+				t.Errorf("expected %d bytes, got %d different bytes", len(test.expected), len(out))
+			}
+		})
+	}
+}
+
+func TestZstdInvalidInput(t *testing.T) {
+	frame := fixture(t, "audit-3.zst")
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{name: "truncated block", input: frame[:len(frame)/2]},
+		{name: "corrupt checksum", input: append(append([]byte{}, frame[:len(frame)-1]...), frame[len(frame)-1]^0xff)},
+		{name: "window too large", input: []byte{0x28, 0xB5, 0x2F, 0xFD, 0x00, 0xF8, 0, 0, 0}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := decompress(test.input); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
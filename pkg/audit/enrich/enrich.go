@@ -0,0 +1,102 @@
+package enrich
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+// AnnotationSuffix is appended to the enricher name to form the prefix of the annotations holding its values, eg.
+// useragent.enrich.audit-tool.natamm4.io/client.
+const AnnotationSuffix = ".enrich.audit-tool.natamm4.io"
+
+// Enricher derives additional information from an audit event.
+type Enricher interface {
+	Enrich(e *auditv1.Event) map[string]string
+}
+
+// Factory creates an enricher, arg is the part of the --enrich value after the first colon (eg. the database path
+// of geoip:/path/to/db.csv), empty when not given.
+type Factory func(arg string) (Enricher, error)
+
+var factories = map[string]Factory{}
+
+// Register makes the enricher available to --enrich under the name. It is meant to be called from init functions
+// and panics when the name is registered twice.
+func Register(name string, factory Factory) {
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("enricher %q is already registered", name))
+	}
+	factories[name] = factory
+}
+
+// Names returns the sorted names of the registered enrichers.
+func Names() []string {
+	return sets.StringKeySet(factories).List()
+}
+
+type namedEnricher struct {
+	name string
+	Enricher
+}
+
+// Pipeline runs the enrichers in order and records their values as event annotations. It implements
+// filter.AuditFilter, so it is run on the events that passed the filters before it.
+type Pipeline []namedEnricher
+
+// NewPipeline creates the enrichers of the specs, each spec is a registered name optionally followed by a colon
+// and the argument of the enricher.
+func NewPipeline(specs []string) (Pipeline, error) {
+	pipeline := Pipeline{}
+	for _, spec := range specs {
+		name, arg := spec, ""
+		if i := strings.IndexByte(spec, ':'); i != -1 {
+			name, arg = spec[:i], spec[i+1:]
+		}
+		factory, ok := factories[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown enricher %q, must be one of %s", name, strings.Join(Names(), ", "))
+		}
+		enricher, err := factory(arg)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create enricher %q: %v", name, err)
+		}
+		pipeline = append(pipeline, namedEnricher{name: name, Enricher: enricher})
+	}
+	return pipeline, nil
+}
+
+func (p Pipeline) FilterEvents(events ...*auditv1.Event) []*auditv1.Event {
+	for _, e := range events {
+		for _, enricher := range p {
+			values := enricher.Enrich(e)
+			if len(values) == 0 {
+				continue
+			}
+			if e.Annotations == nil {
+				e.Annotations = make(map[string]string, len(values))
+			}
+			for key, value := range values {
+				e.Annotations[enricher.name+AnnotationSuffix+"/"+key] = value
+			}
+		}
+	}
+	return events
+}
+
+// Values returns the enrichment values recorded on the event as enricher.key=value pairs, sorted by key.
+func Values(e *auditv1.Event) []string {
+	values := []string{}
+	for key, value := range e.Annotations {
+		i := strings.Index(key, AnnotationSuffix+"/")
+		if i == -1 {
+			continue
+		}
+		values = append(values, key[:i]+"."+key[i+len(AnnotationSuffix)+1:]+"="+value)
+	}
+	sort.Strings(values)
+	return values
+}
@@ -0,0 +1,85 @@
+package enrich
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+func init() {
+	Register("exec", newExternal)
+}
+
+// external runs an external enricher, eg. exec:/usr/local/bin/enrich-audit. The command is started once, every event
+// is written to its stdin as a single JSON line and it must answer with a single line holding a JSON object of
+// string values (or an empty object). The command exits when its stdin is closed at the end of the audit-tool run.
+// Failures are reported once and disable the enricher for the rest of the run.
+type external struct {
+	command string
+
+	lock    sync.Mutex
+	stdin   io.WriteCloser
+	stdout  *bufio.Reader
+	encoder *json.Encoder
+	failed  bool
+}
+
+func newExternal(command string) (Enricher, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("command must be specified (eg. exec:/path/to/enricher)")
+	}
+	cmd := exec.Command(fields[0], fields[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &external{
+		command: command,
+		stdin:   stdin,
+		stdout:  bufio.NewReader(stdout),
+		encoder: json.NewEncoder(stdin),
+	}, nil
+}
+
+func (x *external) Enrich(e *auditv1.Event) map[string]string {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	if x.failed {
+		return nil
+	}
+	values := map[string]string{}
+	if err := x.exchange(e, values); err != nil {
+		fmt.Fprintf(os.Stderr, "enricher %q failed, disabling it: %v\n", x.command, err)
+		x.failed = true
+		x.stdin.Close()
+		return nil
+	}
+	return values
+}
+
+func (x *external) exchange(e *auditv1.Event, values map[string]string) error {
+	if err := x.encoder.Encode(e); err != nil {
+		return err
+	}
+	line, err := x.stdout.ReadBytes('\n')
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(line, &values)
+}
@@ -0,0 +1,130 @@
+package enrich
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+func init() {
+	Register("geoip", newGeoIP)
+}
+
+// privateNetworks are the RFC 1918 and RFC 4193 networks.
+var privateNetworks = []*net.IPNet{
+	mustParseCIDR("10.0.0.0/8"),
+	mustParseCIDR("172.16.0.0/12"),
+	mustParseCIDR("192.168.0.0/16"),
+	mustParseCIDR("fc00::/7"),
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+func isPrivate(ip net.IP) bool {
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+type geoIPNetwork struct {
+	network  *net.IPNet
+	location []string
+}
+
+// geoIP classifies the first source IP as loopback, private, link-local or public. Public IPs are located using an
+// optional CSV database with network,country[,city] records, eg. geoip:/path/to/networks.csv.
+type geoIP struct {
+	networks []geoIPNetwork
+}
+
+func newGeoIP(path string) (Enricher, error) {
+	g := &geoIP{}
+	if len(path) == 0 {
+		return g, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.Comment = '#'
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %q: %v", path, err)
+		}
+		if len(record) < 2 {
+			return nil, fmt.Errorf("invalid record %q in %q, expected network,country[,city]", strings.Join(record, ","), path)
+		}
+		_, network, err := net.ParseCIDR(strings.TrimSpace(record[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q in %q: %v", record[0], path, err)
+		}
+		g.networks = append(g.networks, geoIPNetwork{network: network, location: record[1:]})
+	}
+	return g, nil
+}
+
+func (g *geoIP) Enrich(e *auditv1.Event) map[string]string {
+	if len(e.SourceIPs) == 0 {
+		return nil
+	}
+	ip := net.ParseIP(e.SourceIPs[0])
+	if ip == nil {
+		return nil
+	}
+	values := map[string]string{}
+	switch {
+	case ip.IsLoopback():
+		values["scope"] = "loopback"
+	case isPrivate(ip):
+		values["scope"] = "private"
+	case ip.IsLinkLocalUnicast():
+		values["scope"] = "link-local"
+	default:
+		values["scope"] = "public"
+	}
+	// the most specific network wins
+	var match *geoIPNetwork
+	for i := range g.networks {
+		if !g.networks[i].network.Contains(ip) {
+			continue
+		}
+		if match == nil {
+			match = &g.networks[i]
+			continue
+		}
+		matchOnes, _ := match.network.Mask.Size()
+		ones, _ := g.networks[i].network.Mask.Size()
+		if ones > matchOnes {
+			match = &g.networks[i]
+		}
+	}
+	if match != nil {
+		values["country"] = strings.TrimSpace(match.location[0])
+		if len(match.location) > 1 {
+			values["city"] = strings.TrimSpace(match.location[1])
+		}
+	}
+	return values
+}
@@ -0,0 +1,60 @@
+package enrich
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+func init() {
+	Register("serviceaccount", func(arg string) (Enricher, error) {
+		if len(arg) > 0 {
+			return nil, fmt.Errorf("serviceaccount doesn't take an argument")
+		}
+		return serviceAccount{}, nil
+	})
+}
+
+const (
+	podNameExtra  = "authentication.kubernetes.io/pod-name"
+	nodeNameExtra = "authentication.kubernetes.io/node-name"
+)
+
+var (
+	// podSuffixRegexp matches the random suffix of pods created by a controller
+	podSuffixRegexp = regexp.MustCompile(`-[bcdfghjklmnpqrstvwxz2456789]{5}$`)
+	// templateHashRegexp matches the pod template hash of pods created by a ReplicaSet of a Deployment
+	templateHashRegexp = regexp.MustCompile(`-[bcdfghjklmnpqrstvwxz2456789]{6,10}$`)
+)
+
+// serviceAccount maps service account users to the namespace, service account and, when the token is bound to a
+// pod, the pod and the workload that owns it.
+type serviceAccount struct{}
+
+func (serviceAccount) Enrich(e *auditv1.Event) map[string]string {
+	if !strings.HasPrefix(e.User.Username, "system:serviceaccount:") {
+		return nil
+	}
+	parts := strings.SplitN(strings.TrimPrefix(e.User.Username, "system:serviceaccount:"), ":", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+	values := map[string]string{"namespace": parts[0], "name": parts[1]}
+	if nodes := e.User.Extra[nodeNameExtra]; len(nodes) > 0 {
+		values["node"] = nodes[0]
+	}
+	pods := e.User.Extra[podNameExtra]
+	if len(pods) == 0 {
+		return values
+	}
+	values["pod"] = pods[0]
+	// the workload name is guessed from the pod name: <deployment>-<template hash>-<suffix>, <owner>-<suffix>
+	workload := podSuffixRegexp.ReplaceAllString(pods[0], "")
+	if workload != pods[0] {
+		workload = templateHashRegexp.ReplaceAllString(workload, "")
+	}
+	values["workload"] = workload
+	return values
+}
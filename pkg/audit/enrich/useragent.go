@@ -0,0 +1,59 @@
+package enrich
+
+import (
+	"fmt"
+	"strings"
+
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+func init() {
+	Register("useragent", func(arg string) (Enricher, error) {
+		if len(arg) > 0 {
+			return nil, fmt.Errorf("useragent doesn't take an argument")
+		}
+		return userAgent{}, nil
+	})
+}
+
+// userAgent parses the user agent set by client-go, eg.
+// kube-controller-manager/v1.27.3 (linux/amd64) kubernetes/25b4e43/system:serviceaccount:kube-system:deployment-controller
+type userAgent struct{}
+
+func (userAgent) Enrich(e *auditv1.Event) map[string]string {
+	if len(e.UserAgent) == 0 {
+		return nil
+	}
+	values := map[string]string{}
+	product, rest := e.UserAgent, ""
+	if i := strings.IndexByte(e.UserAgent, ' '); i != -1 {
+		product, rest = e.UserAgent[:i], strings.TrimSpace(e.UserAgent[i+1:])
+	}
+	client, version := product, ""
+	if i := strings.IndexByte(product, '/'); i != -1 {
+		client, version = product[:i], product[i+1:]
+	}
+	values["client"] = client
+	if len(version) > 0 {
+		values["version"] = version
+	}
+
+	if strings.HasPrefix(rest, "(") {
+		if end := strings.IndexByte(rest, ')'); end != -1 {
+			platform := strings.SplitN(rest[1:end], "/", 2)
+			values["os"] = platform[0]
+			if len(platform) == 2 {
+				values["arch"] = platform[1]
+			}
+			rest = strings.TrimSpace(rest[end+1:])
+		}
+	}
+	// kubernetes/<commit>[/<controller>]
+	if parts := strings.SplitN(rest, "/", 3); len(parts) >= 2 && parts[0] == "kubernetes" {
+		values["commit"] = parts[1]
+		if len(parts) == 3 {
+			values["controller"] = parts[2]
+		}
+	}
+	return values
+}
@@ -0,0 +1,73 @@
+package falco
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+)
+
+// Source of the alerts, the one of the Kubernetes audit events in Falco.
+// See https://falco.org/docs/outputs/formatting/
+const Source = "k8s_audit"
+
+// Priorities of alerts, from the most to the least severe.
+const (
+	PriorityEmergency     = "Emergency"
+	PriorityAlert         = "Alert"
+	PriorityCritical      = "Critical"
+	PriorityError         = "Error"
+	PriorityWarning       = "Warning"
+	PriorityNotice        = "Notice"
+	PriorityInformational = "Informational"
+	PriorityDebug         = "Debug"
+)
+
+// Alert is a Falco alert as written with json_output enabled, which falcosidekick accepts too.
+type Alert struct {
+	Output       string                 `json:"output"`
+	Priority     string                 `json:"priority"`
+	Rule         string                 `json:"rule"`
+	Time         time.Time              `json:"time"`
+	OutputFields map[string]interface{} `json:"output_fields"`
+	Source       string                 `json:"source"`
+	Tags         []string               `json:"tags"`
+	Hostname     string                 `json:"hostname,omitempty"`
+}
+
+// Report collects the alerts of a single audit-tool run.
+type Report struct {
+	alerts []Alert
+}
+
+func NewReport() *Report {
+	return &Report{}
+}
+
+// AddAlert records a detection, the output is the human readable message and the fields are the values it is made of.
+func (r *Report) AddAlert(rule, priority, output string, fields map[string]interface{}, tags ...string) {
+	hostname, _ := os.Hostname()
+	now := time.Now().UTC()
+	r.alerts = append(r.alerts, Alert{
+		// Falco prefixes the output with the time and priority
+		Output:       now.Format("15:04:05.000000000") + ": " + priority + " " + output,
+		Priority:     priority,
+		Rule:         rule,
+		Time:         now,
+		OutputFields: fields,
+		Source:       Source,
+		Tags:         append([]string{"k8s", "audit-tool"}, tags...),
+		Hostname:     hostname,
+	})
+}
+
+// Write writes the alerts one JSON object per line, like Falco does.
+func (r *Report) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	for _, alert := range r.alerts {
+		if err := encoder.Encode(alert); err != nil {
+			return err
+		}
+	}
+	return nil
+}
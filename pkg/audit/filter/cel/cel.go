@@ -0,0 +1,105 @@
+// Package cel filters audit events by expressions in a subset of the Common Expression Language (CEL), the language
+// of Kubernetes validating admission policies and CRD validation rules.
+//
+// The event is available as the "event" variable with the field names of its JSON representation, eg.
+//
+//	event.verb == "delete" && event.objectRef.resource == "secrets"
+//	event.user.groups.exists(g, g == "system:masters") && event.responseStatus.code >= 400
+//	has(event.objectRef.subresource) && event.objectRef.subresource in ["exec", "attach"]
+//
+// The object the request read or wrote, decoded from the request or response object (see objects.Target), is available
+// as the "object" variable, eg.
+//
+//	object.metadata.labels.app == "foo" && object.spec.replicas > 3
+//
+// Referring to it for events without a recorded object is an error, like selecting a missing field.
+//
+// Supported are literals, lists, field selection and indexing, the operators ! - == != < <= > >= in && || ?:, the
+// has, exists, all and exists_one macros and the size, startsWith, endsWith, contains, matches, lowerAscii,
+// upperAscii, int, double and string functions. All numbers are doubles. Compile rejects undeclared variables and
+// functions and the fields the audit event doesn't have (eg. event.vreb), the fields of object aren't checked. Types
+// aren't checked, and selecting a field missing from the event is an error at evaluation, which makes the expression
+// not match unless absorbed by && or ||.
+package cel
+
+import (
+	"encoding/json"
+	"fmt"
+
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+
+	"github.com/natamm4/audit-tool/pkg/audit/objects"
+)
+
+// Program is a parsed expression.
+type Program struct {
+	expression string
+	root       node
+}
+
+func Compile(expression string) (*Program, error) {
+	root, err := parse(expression)
+	if err == nil {
+		_, err = check(root, map[string]*schema{"event": eventSchema, "object": anySchema})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CEL expression %q: %v", expression, err)
+	}
+	return &Program{expression: expression, root: root}, nil
+}
+
+// Matches evaluates the expression over the event, the expression must evaluate to a bool.
+func (p *Program) Matches(e *auditv1.Event) (bool, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return false, err
+	}
+	event := map[string]interface{}{}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return false, err
+	}
+	return evalBool(p.root, activation{"event": event, "object": newLazy(func() (interface{}, error) {
+		return targetObject(e)
+	})})
+}
+
+// targetObject returns the decoded object of the event as JSON values, the numbers of unstructured objects are int64.
+func targetObject(e *auditv1.Event) (interface{}, error) {
+	object, err := objects.Target(e)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(object.Unstructured.Object)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+type FilterByCEL struct {
+	Programs []*Program
+}
+
+// FilterEvents passes the events matching all the expressions, evaluation errors (eg. missing fields) don't match.
+func (f *FilterByCEL) FilterEvents(events ...*auditv1.Event) []*auditv1.Event {
+	ret := []*auditv1.Event{}
+	for i := range events {
+		event := events[i]
+		matched := true
+		for _, program := range f.Programs {
+			if ok, err := program.Matches(event); err != nil || !ok {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			ret = append(ret, event)
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,255 @@
+package cel
+
+import (
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+func testEvent() *auditv1.Event {
+	e := &auditv1.Event{
+		Level:          auditv1.LevelRequestResponse,
+		AuditID:        "a1",
+		Stage:          auditv1.StageResponseComplete,
+		RequestURI:     "/api/v1/namespaces/prod/pods/web-0/exec?command=sh",
+		Verb:           "create",
+		ObjectRef:      &auditv1.ObjectReference{Resource: "pods", Namespace: "prod", Name: "web-0", Subresource: "exec", APIVersion: "v1"},
+		ResponseStatus: &metav1.Status{Code: 403},
+		Annotations:    map[string]string{"authorization.k8s.io/decision": "forbid"},
+		ResponseObject: &runtime.Unknown{Raw: []byte(`{"kind":"Pod","apiVersion":"v1","metadata":{"name":"web-0","namespace":"prod","labels":{"app":"web"}},"spec":{"replicas":5}}`)},
+	}
+	e.User.Username = "alice"
+	e.User.Groups = []string{"developers", "system:authenticated"}
+	return e
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected   bool
+	}{
+		// literals and operators
+		{expression: `true`, expected: true},
+		{expression: `!false`, expected: true},
+		{expression: `1 < 2 && 2 <= 2 && 3 > 2 && 3 >= 3 && 1 != 2`, expected: true},
+		{expression: `-1 < 0 && 1.5e3 == 1500`, expected: true},
+		{expression: `"abc" < "abd" && 'single' == "single"`, expected: true},
+		{expression: `'it\'s' == "it's" && "a\tb" == 'a\tb'`, expected: true},
+		{expression: `[1, "a", true] == [1, "a", true] && [1] != [2]`, expected: true},
+		{expression: `null == null`, expected: true},
+
+		// precedence and associativity
+		{expression: `true || false && false`, expected: true},
+		{expression: `(true || false) && false`, expected: false},
+		{expression: `!true == false`, expected: true},
+		{expression: `!(true == false)`, expected: true},
+		{expression: `1 < 2 == true`, expected: true},
+		{expression: `--1 == 1`, expected: true},
+		{expression: `false ? false : true ? true : false`, expected: true},
+		{expression: `true ? false : true || true`, expected: false},
+		{expression: `1 < 2 ? 3 > 4 : true`, expected: false},
+
+		// field selection and indexing
+		{expression: `event.verb == "create" && event.objectRef.resource == "pods"`, expected: true},
+		{expression: `event.responseStatus.code >= 400 && event.responseStatus.code < 500`, expected: true},
+		{expression: `event.user.groups[0] == "developers" && event["verb"] == "create"`, expected: true},
+		{expression: `event.annotations["authorization.k8s.io/decision"] == "forbid"`, expected: true},
+		{expression: `object.metadata.labels.app == "web" && object.spec.replicas > 3`, expected: true},
+
+		// has()
+		{expression: `has(event.objectRef.subresource)`, expected: true},
+		{expression: `has(event.objectRef.subresource) && event.objectRef.subresource in ["exec", "attach"]`, expected: true},
+		{expression: `has(event.requestObject)`, expected: false},
+		{expression: `has(event.user.groups)`, expected: true},
+		{expression: `!has(object.metadata.annotations)`, expected: true},
+		{expression: `!has(event.impersonatedUser)`, expected: true},
+
+		// in
+		{expression: `"developers" in event.user.groups`, expected: true},
+		{expression: `"system:masters" in event.user.groups`, expected: false},
+		{expression: `403 in [401, 403]`, expected: true},
+		{expression: `"app" in object.metadata.labels`, expected: true},
+		{expression: `"tier" in object.metadata.labels`, expected: false},
+		{expression: `[] == [] && !(1 in [])`, expected: true},
+
+		// macros
+		{expression: `event.user.groups.exists(g, g.startsWith("system:"))`, expected: true},
+		{expression: `event.user.groups.all(g, g.startsWith("system:"))`, expected: false},
+		{expression: `event.user.groups.exists_one(g, g.contains("e"))`, expected: false},
+		{expression: `event.user.groups.exists_one(g, g == "developers")`, expected: true},
+		{expression: `object.metadata.labels.exists(key, key == "app")`, expected: true},
+		{expression: `[].all(x, x > 1) && ![].exists(x, true)`, expected: true},
+
+		// functions
+		{expression: `size(event.user.groups) == 2 && event.user.groups.size() == 2 && size("héllo") == 5`, expected: true},
+		{expression: `event.requestURI.endsWith("?command=sh") && event.requestURI.matches("^/api/v1/namespaces/[^/]+/pods")`, expected: true},
+		{expression: `event.user.username.upperAscii() == "ALICE" && "ALICE".lowerAscii() == "alice"`, expected: true},
+		{expression: `int("42") == 42 && int(3.9) == 3 && double("1.5") == 1.5 && string(403) == "403" && string(true) == "true"`, expected: true},
+
+		// errors absorbed by && and ||
+		{expression: `false && event.impersonatedUser.username == "bob"`, expected: false},
+		{expression: `event.impersonatedUser.username == "bob" && false`, expected: false},
+		{expression: `true || event.impersonatedUser.username == "bob"`, expected: true},
+		{expression: `event.impersonatedUser.username == "bob" || true`, expected: true},
+		{expression: `has(event.requestObject) && event.requestObject.kind == "Pod"`, expected: false},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			program, err := Compile(test.expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			matched, err := program.Matches(testEvent())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if matched != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, matched)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		err        string
+	}{
+		{expression: ``, err: "unexpected end of expression"},
+		{expression: `event.verb ==`, err: "unexpected end of expression"},
+		{expression: `(event.verb == "get"`, err: `expected ")" at 20`},
+		{expression: `[1, 2`, err: `expected "," at 5`},
+		{expression: `event.verb == "get" event.verb`, err: `unexpected "event" at 20`},
+		{expression: `event.`, err: "expected field or function name at 6"},
+		{expression: `event.verb = "get"`, err: `unexpected character '=' at 11`},
+		{expression: `true ? 1`, err: `expected ":" at 8`},
+		{expression: `"unterminated`, err: "unterminated string at 0"},
+		{expression: `1.2.3 == 1`, err: `invalid number "1.2.3" at 0`},
+		{expression: `event.user[]`, err: `unexpected "]" at 11`},
+
+		// undeclared variables, functions and fields of the event
+		{expression: `request.verb == "get"`, err: `undeclared reference to "request"`},
+		{expression: `event.vreb == "get"`, err: `undefined field "vreb" of Event`},
+		{expression: `event.objectRef.resources == "pods"`, err: `undefined field "resources" of ObjectReference`},
+		{expression: `event["vreb"] == "get"`, err: `undefined field "vreb" of Event`},
+		{expression: `has(event.missing.field)`, err: `undefined field "missing" of Event`},
+		{expression: `event.verb.missing == 1`, err: `cannot select field "missing" of a string`},
+		{expression: `has(event.verb.length)`, err: `cannot select field "length" of a string`},
+		{expression: `event.user.groups.name == "a"`, err: `cannot select field "name" of a list`},
+		{expression: `event.verb[0] == "c"`, err: "cannot index string"},
+		{expression: `event.user.groups.exists(g, g.name == "a")`, err: `cannot select field "name" of a string`},
+		{expression: `event.user.groups.exists(g, h == "a")`, err: `undeclared reference to "h"`},
+		{expression: `event.verb.trim() == "create"`, err: `undeclared reference to function "trim"`},
+		{expression: `false && event.missing == 1`, err: `undefined field "missing" of Event`},
+		{expression: `event.responseStatus.details.causes.exists(c, c.feild == "x")`, err: `undefined field "feild" of StatusCause`},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			_, err := Compile(test.expression)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected an error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestMatchesErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		err        string
+	}{
+		// the result must be a bool
+		{expression: `event.verb`, err: "expected bool, got string"},
+		{expression: `1`, err: "expected bool, got float64"},
+
+		// type errors
+		{expression: `event.verb > 1`, err: "cannot compare string with float64"},
+		{expression: `event.responseStatus.code < "500"`, err: "cannot compare number with string"},
+		{expression: `event.user < event.user`, err: "cannot compare map[string]interface {}"},
+		{expression: `!event.verb`, err: "expected bool, got string"},
+		{expression: `-event.verb == 1`, err: "cannot negate string"},
+		{expression: `1 && true`, err: "expected bool, got float64"},
+		{expression: `"create" in event.verb`, err: "'in' requires a list or map, got string"},
+		{expression: `event.verb ? true : false`, err: "expected bool, got string"},
+		{expression: `event.user.groups["0"] == "developers"`, err: "invalid list index 0"},
+		{expression: `event.user.groups[2] == "developers"`, err: "invalid list index 2"},
+		{expression: `event.user.groups[0.5] == "developers"`, err: "invalid list index 0.5"},
+		{expression: `event.user[0] == "alice"`, err: "map index must be a string"},
+		{expression: `size(1) == 1`, err: "size() is not defined for float64"},
+		{expression: `event.verb.startsWith(1)`, err: "startsWith() requires string arguments"},
+		{expression: `event.verb.lowerAscii(1) == "a"`, err: "lowerAscii() requires string arguments"},
+		{expression: `event.verb.startsWith() == "create"`, err: "found no matching overload for startsWith() with 1 argument(s)"},
+		{expression: `int("four") == 4`, err: `parsing "four"`},
+		{expression: `event.verb.matches("(") `, err: "missing closing )"},
+
+		// fields missing from the event
+		{expression: `event.impersonatedUser.username == "bob"`, err: "no such key: impersonatedUser"},
+		{expression: `event.annotations["missing"] == "x"`, err: "no such key: missing"},
+		{expression: `object.missing == 1`, err: "no such key: missing"},
+		{expression: `has(event.impersonatedUser.username)`, err: "no such key: impersonatedUser"},
+		{expression: `has(event)`, err: "has() requires a field selection"},
+		{expression: `event.impersonatedUser.username == "bob" && true`, err: "no such key: impersonatedUser"},
+		{expression: `event.impersonatedUser.username == "bob" || false`, err: "no such key: impersonatedUser"},
+
+		// macros
+		{expression: `event.user.groups.exists(1, true)`, err: "exists() requires a variable name as first argument"},
+		{expression: `event.user.groups.all(g)`, err: "all() requires a variable and a predicate"},
+		{expression: `event.verb.exists(c, true)`, err: "exists() is not defined for string"},
+		{expression: `event.user.groups.exists(g, g)`, err: "expected bool, got string"},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			program, err := Compile(test.expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = program.Matches(testEvent())
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected an error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestFilterByCEL(t *testing.T) {
+	compile := func(expressions ...string) []*Program {
+		programs := []*Program{}
+		for _, expression := range expressions {
+			program, err := Compile(expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			programs = append(programs, program)
+		}
+		return programs
+	}
+	get := testEvent()
+	get.Verb = "get"
+	get.ObjectRef.Subresource = ""
+	events := []*auditv1.Event{testEvent(), get}
+
+	tests := []struct {
+		name        string
+		expressions []string
+		expected    []string
+	}{
+		{name: "all expressions must match", expressions: []string{`event.objectRef.resource == "pods"`, `event.verb == "get"`}, expected: []string{"get"}},
+		{name: "errors don't match", expressions: []string{`event.objectRef.subresource == "exec"`}, expected: []string{"create"}},
+		{name: "no expressions", expected: []string{"create", "get"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter := &FilterByCEL{Programs: compile(test.expressions...)}
+			verbs := []string{}
+			for _, e := range filter.FilterEvents(events...) {
+				verbs = append(verbs, e.Verb)
+			}
+			if strings.Join(verbs, ",") != strings.Join(test.expected, ",") {
+				t.Errorf("expected %v, got %v", test.expected, verbs)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package cel
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+type schemaKind int
+
+const (
+	// schemaAny is a value of unknown shape, eg. the object of the event, anything can be selected from it
+	schemaAny schemaKind = iota
+	schemaScalar
+	schemaStruct
+	schemaMap
+	schemaList
+)
+
+// schema is the shape of the JSON representation of a Go type, the fields of a struct by their JSON name and the
+// elements of a map or a list.
+type schema struct {
+	kind    schemaKind
+	name    string
+	fields  map[string]*schema
+	element *schema
+}
+
+var (
+	anySchema   = &schema{kind: schemaAny}
+	eventSchema = newSchema(reflect.TypeOf(auditv1.Event{}))
+
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	unknownType   = reflect.TypeOf(runtime.Unknown{})
+)
+
+// functions are the macros and functions implemented by call.
+var functions = map[string]bool{
+	"has": true, "exists": true, "all": true, "exists_one": true,
+	"size": true, "startsWith": true, "endsWith": true, "contains": true, "matches": true,
+	"lowerAscii": true, "upperAscii": true, "int": true, "double": true, "string": true,
+}
+
+func newSchema(t reflect.Type) *schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == unknownType:
+		// the request and response objects are embedded as they were recorded
+		return anySchema
+	case t.Implements(marshalerType) || reflect.PtrTo(t).Implements(marshalerType):
+		// timestamps and quantities are written as strings
+		return &schema{kind: schemaScalar, name: t.Name()}
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		s := &schema{kind: schemaStruct, name: t.Name(), fields: map[string]*schema{}}
+		addFields(s, t)
+		return s
+	case reflect.Map:
+		return &schema{kind: schemaMap, element: newSchema(t.Elem())}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &schema{kind: schemaScalar, name: "bytes"}
+		}
+		return &schema{kind: schemaList, element: newSchema(t.Elem())}
+	case reflect.Interface:
+		return anySchema
+	}
+	return &schema{kind: schemaScalar, name: t.Kind().String()}
+}
+
+// addFields adds the fields of the struct by their JSON name, the fields of inlined structs like TypeMeta too.
+func addFields(s *schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if len(field.PkgPath) > 0 && !field.Anonymous {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if len(name) == 0 && field.Anonymous {
+			addFields(s, field.Type)
+			continue
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+		s.fields[name] = newSchema(field.Type)
+	}
+}
+
+// check rejects the references to undeclared variables, unknown functions and fields the audit event doesn't have, they
+// would never match. It returns the schema of the value of the node.
+func check(n node, scope map[string]*schema) (*schema, error) {
+	switch n := n.(type) {
+	case *identifier:
+		s, ok := scope[n.name]
+		if !ok {
+			return nil, fmt.Errorf("undeclared reference to %q", n.name)
+		}
+		return s, nil
+	case *selection:
+		operand, err := check(n.operand, scope)
+		if err != nil {
+			return nil, err
+		}
+		return operand.field(n.field)
+	case *indexing:
+		operand, err := check(n.operand, scope)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := check(n.index, scope); err != nil {
+			return nil, err
+		}
+		switch operand.kind {
+		case schemaStruct:
+			if key, ok := n.index.(*literal); ok {
+				if field, ok := key.value.(string); ok {
+					return operand.field(field)
+				}
+			}
+			return anySchema, nil
+		case schemaMap, schemaList:
+			return operand.element, nil
+		case schemaScalar:
+			return nil, fmt.Errorf("cannot index %s", operand.name)
+		}
+		return anySchema, nil
+	case *call:
+		return checkCall(n, scope)
+	}
+
+	var operands []node
+	switch n := n.(type) {
+	case *list:
+		operands = n.elements
+	case *not:
+		operands = []node{n.operand}
+	case *negate:
+		operands = []node{n.operand}
+	case *conditional:
+		operands = []node{n.condition, n.whenTrue, n.whenFalse}
+	case *logical:
+		operands = []node{n.left, n.right}
+	case *relation:
+		operands = []node{n.left, n.right}
+	}
+	for _, operand := range operands {
+		if _, err := check(operand, scope); err != nil {
+			return nil, err
+		}
+	}
+	return anySchema, nil
+}
+
+func checkCall(n *call, scope map[string]*schema) (*schema, error) {
+	if !functions[n.function] {
+		return nil, fmt.Errorf("undeclared reference to function %q", n.function)
+	}
+	var receiver *schema
+	if n.receiver != nil {
+		var err error
+		if receiver, err = check(n.receiver, scope); err != nil {
+			return nil, err
+		}
+	}
+	switch n.function {
+	case "exists", "all", "exists_one":
+		if receiver == nil || len(n.args) != 2 {
+			// reported when evaluated
+			return anySchema, nil
+		}
+		variable, ok := n.args[0].(*identifier)
+		if !ok {
+			return anySchema, nil
+		}
+		element := anySchema
+		switch receiver.kind {
+		case schemaList:
+			element = receiver.element
+		case schemaMap:
+			element = &schema{kind: schemaScalar, name: "string"}
+		}
+		comprehension := make(map[string]*schema, len(scope)+1)
+		for name, s := range scope {
+			comprehension[name] = s
+		}
+		comprehension[variable.name] = element
+		_, err := check(n.args[1], comprehension)
+		return anySchema, err
+	}
+	for _, arg := range n.args {
+		if _, err := check(arg, scope); err != nil {
+			return nil, err
+		}
+	}
+	return anySchema, nil
+}
+
+// field returns the schema of the field selected from a value of the schema.
+func (s *schema) field(name string) (*schema, error) {
+	switch s.kind {
+	case schemaStruct:
+		field, ok := s.fields[name]
+		if !ok {
+			return nil, fmt.Errorf("undefined field %q of %s", name, s.name)
+		}
+		return field, nil
+	case schemaMap:
+		return s.element, nil
+	case schemaAny:
+		return anySchema, nil
+	}
+	return nil, fmt.Errorf("cannot select field %q of a %s", name, s.kindName())
+}
+
+func (s *schema) kindName() string {
+	if s.kind == schemaList {
+		return "list"
+	}
+	return s.name
+}
@@ -0,0 +1,482 @@
+package cel
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// activation holds the variables an expression is evaluated with.
+type activation map[string]interface{}
+
+// lazy is a variable computed when the expression first refers to it, eg. decoding the object of the event.
+type lazy func() (interface{}, error)
+
+// newLazy returns a lazy variable computing its value with compute once.
+func newLazy(compute func() (interface{}, error)) lazy {
+	var value interface{}
+	var err error
+	computed := false
+	return func() (interface{}, error) {
+		if !computed {
+			value, err = compute()
+			computed = true
+		}
+		return value, err
+	}
+}
+
+// node is an expression evaluated over JSON values: nil, bool, float64, string, []interface{} and
+// map[string]interface{}.
+type node interface {
+	eval(vars activation) (interface{}, error)
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (n *literal) eval(activation) (interface{}, error) {
+	return n.value, nil
+}
+
+type identifier struct {
+	name string
+}
+
+func (n *identifier) eval(vars activation) (interface{}, error) {
+	value, ok := vars[n.name]
+	if !ok {
+		return nil, fmt.Errorf("undeclared reference to %q", n.name)
+	}
+	if compute, ok := value.(lazy); ok {
+		return compute()
+	}
+	return value, nil
+}
+
+type selection struct {
+	operand node
+	field   string
+}
+
+func (n *selection) eval(vars activation) (interface{}, error) {
+	operand, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	object, ok := operand.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("no such key: %s", n.field)
+	}
+	value, ok := object[n.field]
+	if !ok {
+		return nil, fmt.Errorf("no such key: %s", n.field)
+	}
+	return value, nil
+}
+
+type indexing struct {
+	operand node
+	index   node
+}
+
+func (n *indexing) eval(vars activation) (interface{}, error) {
+	operand, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	index, err := n.index.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch operand := operand.(type) {
+	case map[string]interface{}:
+		key, ok := index.(string)
+		if !ok {
+			return nil, fmt.Errorf("map index must be a string")
+		}
+		value, ok := operand[key]
+		if !ok {
+			return nil, fmt.Errorf("no such key: %s", key)
+		}
+		return value, nil
+	case []interface{}:
+		i, ok := index.(float64)
+		if !ok || i != float64(int(i)) || i < 0 || int(i) >= len(operand) {
+			return nil, fmt.Errorf("invalid list index %v", index)
+		}
+		return operand[int(i)], nil
+	}
+	return nil, fmt.Errorf("cannot index %T", operand)
+}
+
+type list struct {
+	elements []node
+}
+
+func (n *list) eval(vars activation) (interface{}, error) {
+	values := make([]interface{}, 0, len(n.elements))
+	for _, element := range n.elements {
+		value, err := element.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+type not struct {
+	operand node
+}
+
+func (n *not) eval(vars activation) (interface{}, error) {
+	value, err := evalBool(n.operand, vars)
+	if err != nil {
+		return nil, err
+	}
+	return !value, nil
+}
+
+type negate struct {
+	operand node
+}
+
+func (n *negate) eval(vars activation) (interface{}, error) {
+	value, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	number, ok := value.(float64)
+	if !ok {
+		return nil, fmt.Errorf("cannot negate %T", value)
+	}
+	return -number, nil
+}
+
+type conditional struct {
+	condition node
+	whenTrue  node
+	whenFalse node
+}
+
+func (n *conditional) eval(vars activation) (interface{}, error) {
+	condition, err := evalBool(n.condition, vars)
+	if err != nil {
+		return nil, err
+	}
+	if condition {
+		return n.whenTrue.eval(vars)
+	}
+	return n.whenFalse.eval(vars)
+}
+
+// logical implements && and || with the CEL semantics: an error on one side is absorbed when the other side
+// determines the result, eg. false && error is false.
+type logical struct {
+	and   bool
+	left  node
+	right node
+}
+
+func (n *logical) eval(vars activation) (interface{}, error) {
+	left, leftErr := evalBool(n.left, vars)
+	if leftErr == nil && left != n.and {
+		return left, nil
+	}
+	right, rightErr := evalBool(n.right, vars)
+	if rightErr == nil && right != n.and {
+		return right, nil
+	}
+	if leftErr != nil {
+		return nil, leftErr
+	}
+	if rightErr != nil {
+		return nil, rightErr
+	}
+	return n.and, nil
+}
+
+type relation struct {
+	operator string
+	left     node
+	right    node
+}
+
+func (n *relation) eval(vars activation) (interface{}, error) {
+	left, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch n.operator {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "in":
+		switch right := right.(type) {
+		case []interface{}:
+			for _, element := range right {
+				if equal(left, element) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]interface{}:
+			key, ok := left.(string)
+			if !ok {
+				return false, nil
+			}
+			_, ok = right[key]
+			return ok, nil
+		}
+		return nil, fmt.Errorf("'in' requires a list or map, got %T", right)
+	}
+
+	var compared int
+	switch left := left.(type) {
+	case float64:
+		number, ok := right.(float64)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare number with %T", right)
+		}
+		switch {
+		case left < number:
+			compared = -1
+		case left > number:
+			compared = 1
+		}
+	case string:
+		text, ok := right.(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare string with %T", right)
+		}
+		compared = strings.Compare(left, text)
+	default:
+		return nil, fmt.Errorf("cannot compare %T", left)
+	}
+	switch n.operator {
+	case "<":
+		return compared < 0, nil
+	case "<=":
+		return compared <= 0, nil
+	case ">":
+		return compared > 0, nil
+	default:
+		return compared >= 0, nil
+	}
+}
+
+func equal(left, right interface{}) bool {
+	return reflect.DeepEqual(left, right)
+}
+
+func evalBool(n node, vars activation) (bool, error) {
+	value, err := n.eval(vars)
+	if err != nil {
+		return false, err
+	}
+	b, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("expected bool, got %T", value)
+	}
+	return b, nil
+}
+
+// call implements the macros has, exists, all, exists_one and the functions size, startsWith, endsWith, contains,
+// matches, lowerAscii, upperAscii, int, double and string.
+type call struct {
+	receiver node
+	function string
+	args     []node
+
+	// patterns caches the compiled regular expressions of matches
+	patterns sync.Map
+}
+
+func (n *call) eval(vars activation) (interface{}, error) {
+	switch n.function {
+	case "has":
+		if n.receiver != nil || len(n.args) != 1 {
+			return nil, fmt.Errorf("has() requires a single field selection")
+		}
+		field, ok := n.args[0].(*selection)
+		if !ok {
+			return nil, fmt.Errorf("has() requires a field selection")
+		}
+		operand, err := field.operand.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		object, ok := operand.(map[string]interface{})
+		if !ok {
+			return false, nil
+		}
+		_, ok = object[field.field]
+		return ok, nil
+	case "exists", "all", "exists_one":
+		return n.comprehension(vars)
+	}
+
+	args := []interface{}{}
+	if n.receiver != nil {
+		receiver, err := n.receiver.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, receiver)
+	}
+	for _, arg := range n.args {
+		value, err := arg.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, value)
+	}
+
+	if len(args) == 1 {
+		switch n.function {
+		case "size":
+			switch value := args[0].(type) {
+			case string:
+				return float64(utf8.RuneCountInString(value)), nil
+			case []interface{}:
+				return float64(len(value)), nil
+			case map[string]interface{}:
+				return float64(len(value)), nil
+			}
+			return nil, fmt.Errorf("size() is not defined for %T", args[0])
+		case "int":
+			switch value := args[0].(type) {
+			case float64:
+				return float64(int64(value)), nil
+			case string:
+				i, err := strconv.ParseInt(value, 10, 64)
+				if err != nil {
+					return nil, err
+				}
+				return float64(i), nil
+			}
+			return nil, fmt.Errorf("int() is not defined for %T", args[0])
+		case "double":
+			switch value := args[0].(type) {
+			case float64:
+				return value, nil
+			case string:
+				return strconv.ParseFloat(value, 64)
+			}
+			return nil, fmt.Errorf("double() is not defined for %T", args[0])
+		case "string":
+			switch value := args[0].(type) {
+			case string:
+				return value, nil
+			case float64:
+				return strconv.FormatFloat(value, 'f', -1, 64), nil
+			case bool:
+				return strconv.FormatBool(value), nil
+			}
+			return nil, fmt.Errorf("string() is not defined for %T", args[0])
+		case "lowerAscii", "upperAscii":
+			value, ok := args[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("%s() is not defined for %T", n.function, args[0])
+			}
+			if n.function == "lowerAscii" {
+				return strings.ToLower(value), nil
+			}
+			return strings.ToUpper(value), nil
+		}
+	}
+
+	if len(args) == 2 {
+		value, ok := args[0].(string)
+		argument, argumentOk := args[1].(string)
+		if !ok || !argumentOk {
+			return nil, fmt.Errorf("%s() requires string arguments", n.function)
+		}
+		switch n.function {
+		case "startsWith":
+			return strings.HasPrefix(value, argument), nil
+		case "endsWith":
+			return strings.HasSuffix(value, argument), nil
+		case "contains":
+			return strings.Contains(value, argument), nil
+		case "matches":
+			if re, ok := n.patterns.Load(argument); ok {
+				return re.(*regexp.Regexp).MatchString(value), nil
+			}
+			re, err := regexp.Compile(argument)
+			if err != nil {
+				return nil, err
+			}
+			n.patterns.Store(argument, re)
+			return re.MatchString(value), nil
+		}
+	}
+	return nil, fmt.Errorf("found no matching overload for %s() with %d argument(s)", n.function, len(args))
+}
+
+// comprehension evaluates list.exists(x, predicate), list.all(x, predicate) and list.exists_one(x, predicate), for
+// maps the predicate is evaluated with the keys.
+func (n *call) comprehension(vars activation) (interface{}, error) {
+	if n.receiver == nil || len(n.args) != 2 {
+		return nil, fmt.Errorf("%s() requires a variable and a predicate, eg. list.%s(x, x == 1)", n.function, n.function)
+	}
+	variable, ok := n.args[0].(*identifier)
+	if !ok {
+		return nil, fmt.Errorf("%s() requires a variable name as first argument", n.function)
+	}
+	receiver, err := n.receiver.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	elements := []interface{}{}
+	switch receiver := receiver.(type) {
+	case []interface{}:
+		elements = receiver
+	case map[string]interface{}:
+		for key := range receiver {
+			elements = append(elements, key)
+		}
+	default:
+		return nil, fmt.Errorf("%s() is not defined for %T", n.function, receiver)
+	}
+
+	scope := make(activation, len(vars)+1)
+	for name, value := range vars {
+		scope[name] = value
+	}
+	matches := 0
+	for _, element := range elements {
+		scope[variable.name] = element
+		matched, err := evalBool(n.args[1], scope)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case matched && n.function == "exists":
+			return true, nil
+		case !matched && n.function == "all":
+			return false, nil
+		case matched:
+			matches++
+		}
+	}
+	switch n.function {
+	case "exists":
+		return false, nil
+	case "all":
+		return true, nil
+	default:
+		return matches == 1, nil
+	}
+}
@@ -0,0 +1,91 @@
+package cel
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value interface{}
+	pos   int
+}
+
+// operators are matched longest first.
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ".", ",", "-", "?", ":"}
+
+func tokenize(expression string) ([]token, error) {
+	tokens := []token{}
+	for i := 0; i < len(expression); {
+		c := rune(expression[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '_' || unicode.IsLetter(c):
+			start := i
+			for i < len(expression) && (expression[i] == '_' || unicode.IsLetter(rune(expression[i])) || unicode.IsDigit(rune(expression[i]))) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: expression[start:i], pos: start})
+		case unicode.IsDigit(c):
+			start := i
+			for i < len(expression) && (unicode.IsDigit(rune(expression[i])) || expression[i] == '.' || expression[i] == 'e' || expression[i] == 'E') {
+				i++
+			}
+			value, err := strconv.ParseFloat(expression[start:i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at %d", expression[start:i], start)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: expression[start:i], value: value, pos: start})
+		case c == '"' || c == '\'':
+			start := i
+			i++
+			for i < len(expression) && rune(expression[i]) != c {
+				if expression[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(expression) {
+				return nil, fmt.Errorf("unterminated string at %d", start)
+			}
+			i++
+			literal := expression[start:i]
+			if c == '\'' {
+				literal = `"` + strings.ReplaceAll(strings.ReplaceAll(literal[1:len(literal)-1], `\'`, `'`), `"`, `\"`) + `"`
+			}
+			value, err := strconv.Unquote(literal)
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s at %d", expression[start:i], start)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: expression[start:i], value: value, pos: start})
+		default:
+			matched := false
+			for _, operator := range operators {
+				if strings.HasPrefix(expression[i:], operator) {
+					tokens = append(tokens, token{kind: tokenOperator, text: operator, pos: i})
+					i += len(operator)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at %d", c, i)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(expression)}), nil
+}
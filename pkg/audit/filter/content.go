@@ -0,0 +1,89 @@
+package filter
+
+import (
+	"bytes"
+	"encoding/json"
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+// contentField returns whether a top level field of an object is content written by a request: the status for
+// requests of the status subresource, all but the metadata and the status otherwise.
+func contentField(e *auditv1.Event, field string) bool {
+	if e.ObjectRef != nil && e.ObjectRef.Subresource == "status" {
+		return field == "status"
+	}
+	return field != "metadata" && field != "status" && field != "apiVersion" && field != "kind"
+}
+
+// ContentDigest returns a digest of the content an update writes, to tell the updates that change an object from the
+// ones only rewriting it. The content is the status for updates of the status subresource, the spec or data and any
+// other top level field but the metadata and the status otherwise. False is returned when the request object isn't
+// recorded (below the Request level).
+func ContentDigest(e *auditv1.Event) (uint64, bool) {
+	if e.RequestObject == nil || len(e.RequestObject.Raw) == 0 {
+		return 0, false
+	}
+	object := map[string]json.RawMessage{}
+	if err := json.Unmarshal(e.RequestObject.Raw, &object); err != nil {
+		return 0, false
+	}
+	fields := make([]string, 0, len(object))
+	for field := range object {
+		if contentField(e, field) {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	hash := fnv.New64a()
+	for _, field := range fields {
+		hash.Write([]byte(field))
+		hash.Write([]byte{0})
+		hash.Write(object[field])
+		hash.Write([]byte{0})
+	}
+	return hash.Sum64(), true
+}
+
+// PatchChangesContent returns whether a patch modifies the content of the object (see ContentDigest), as opposed to
+// only its metadata, eg. labels, annotations or finalizers. The second value is false when the request object isn't
+// recorded or can't be parsed. Patches setting the content to what it already is are counted as changes.
+func PatchChangesContent(e *auditv1.Event) (bool, bool) {
+	if e.RequestObject == nil {
+		return false, false
+	}
+	raw := bytes.TrimSpace(e.RequestObject.Raw)
+	if len(raw) == 0 {
+		return false, false
+	}
+	if raw[0] == '[' {
+		operations := []struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		}{}
+		if err := json.Unmarshal(raw, &operations); err != nil {
+			return false, false
+		}
+		for _, operation := range operations {
+			field := strings.SplitN(strings.TrimPrefix(operation.Path, "/"), "/", 2)[0]
+			if operation.Op != "test" && contentField(e, field) {
+				return true, true
+			}
+		}
+		return false, true
+	}
+	object := map[string]json.RawMessage{}
+	if err := json.Unmarshal(raw, &object); err != nil {
+		return false, false
+	}
+	for field := range object {
+		// top level strategic merge directives don't change the content by themselves
+		if contentField(e, field) && !strings.HasPrefix(field, "$") {
+			return true, true
+		}
+	}
+	return false, true
+}
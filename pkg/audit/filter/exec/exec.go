@@ -0,0 +1,122 @@
+// Package exec implements filtering of audit events by an external program.
+//
+// The program is started once and reads batches of candidate events from its stdin. Every event of a batch is
+// written as a single line of JSON (NDJSON) and the batch is terminated by an empty line. The program answers every
+// batch on its stdout with the audit IDs of the matching events, one per line, terminated by an empty line as well.
+// All events of the batch with a returned audit ID pass the filter.
+//
+// The program exits when its stdin is closed at the end of the audit-tool run. It must flush its output after
+// every batch, its stderr is passed through.
+package exec
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	osexec "os/exec"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+type FilterByExec struct {
+	command string
+
+	lock   sync.Mutex
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	failed bool
+}
+
+// New starts the filter program, the command is split on white space into the program and its arguments.
+func New(command string) (*FilterByExec, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("filter command must be specified")
+	}
+	cmd := osexec.Command(fields[0], fields[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("unable to start filter %q: %v", command, err)
+	}
+	return &FilterByExec{command: command, stdin: stdin, stdout: bufio.NewReader(stdout)}, nil
+}
+
+// FilterEvents sends the events to the filter program as a single batch. When the program fails the error is
+// reported once and no event passes the filter from then on.
+func (f *FilterByExec) FilterEvents(events ...*auditv1.Event) []*auditv1.Event {
+	if len(events) == 0 {
+		return events
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.failed {
+		return nil
+	}
+
+	matching, err := f.exchange(events)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "filter %q failed: %v\n", f.command, err)
+		f.failed = true
+		f.stdin.Close()
+		return nil
+	}
+	ret := []*auditv1.Event{}
+	for i := range events {
+		event := events[i]
+		if matching.Has(string(event.AuditID)) {
+			ret = append(ret, event)
+		}
+	}
+
+	return ret
+}
+
+func (f *FilterByExec) exchange(events []*auditv1.Event) (sets.String, error) {
+	// the program might answer while it is still reading the batch, writing concurrently avoids filling both pipes
+	written := make(chan error, 1)
+	go func() {
+		w := bufio.NewWriter(f.stdin)
+		encoder := json.NewEncoder(w)
+		for _, e := range events {
+			if err := encoder.Encode(e); err != nil {
+				written <- err
+				return
+			}
+		}
+		if _, err := w.WriteString("\n"); err != nil {
+			written <- err
+			return
+		}
+		written <- w.Flush()
+	}()
+
+	matching := sets.NewString()
+	for {
+		line, err := f.stdout.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("reading matching audit IDs failed: %v", err)
+		}
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			break
+		}
+		matching.Insert(line)
+	}
+	if err := <-written; err != nil {
+		return nil, fmt.Errorf("writing events failed: %v", err)
+	}
+	return matching, nil
+}
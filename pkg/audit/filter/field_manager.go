@@ -0,0 +1,63 @@
+package filter
+
+import (
+	"encoding/json"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+// FieldManager returns the field manager of a mutating request. The fieldManager query parameter is what the client
+// set for this request, when it is missing the manager of the most recently updated managedFields entry of the
+// request object is used. Empty string is returned when neither is available, eg. below the Request level.
+func FieldManager(e *auditv1.Event) string {
+	if manager := ParseURIQuery(e.RequestURI).Values.Get("fieldManager"); len(manager) > 0 {
+		return manager
+	}
+
+	if e.RequestObject == nil || len(e.RequestObject.Raw) == 0 {
+		return ""
+	}
+	object := struct {
+		Metadata struct {
+			ManagedFields []metav1.ManagedFieldsEntry `json:"managedFields"`
+		} `json:"metadata"`
+	}{}
+	if err := json.Unmarshal(e.RequestObject.Raw, &object); err != nil {
+		return ""
+	}
+	manager := ""
+	var latest *metav1.Time
+	for _, entry := range object.Metadata.ManagedFields {
+		if len(entry.Manager) == 0 {
+			continue
+		}
+		if len(manager) == 0 || (entry.Time != nil && (latest == nil || latest.Before(entry.Time))) {
+			manager = entry.Manager
+			latest = entry.Time
+		}
+	}
+	return manager
+}
+
+type FilterByFieldManagers struct {
+	FieldManagers sets.String
+}
+
+func (f *FilterByFieldManagers) FilterEvents(events ...*auditv1.Event) []*auditv1.Event {
+	ret := []*auditv1.Event{}
+	for i := range events {
+		event := events[i]
+		manager := FieldManager(event)
+		if len(manager) == 0 {
+			continue
+		}
+
+		if AcceptString(f.FieldManagers, manager) {
+			ret = append(ret, event)
+		}
+	}
+
+	return ret
+}
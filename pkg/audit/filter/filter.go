@@ -13,6 +13,8 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/sets"
 	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+
+	"github.com/natamm4/audit-tool/pkg/audit/provenance"
 )
 
 type AuditFilter interface {
@@ -171,6 +173,23 @@ func (f *FilterByUser) FilterEvents(events ...*auditv1.Event) []*auditv1.Event {
 	return ret
 }
 
+type FilterByClusters struct {
+	Clusters sets.String
+}
+
+func (f *FilterByClusters) FilterEvents(events ...*auditv1.Event) []*auditv1.Event {
+	ret := []*auditv1.Event{}
+	for i := range events {
+		event := events[i]
+
+		if AcceptString(f.Clusters, provenance.Cluster(event)) {
+			ret = append(ret, event)
+		}
+	}
+
+	return ret
+}
+
 type FilterByVerbs struct {
 	Verbs sets.String
 }
@@ -255,7 +274,7 @@ func URIToParts(uri string) (string, schema.GroupVersionResource, string, string
 	}
 
 	// some request URL has query parameters like: /apis/image.openshift.io/v1/images?limit=500&resourceVersion=0
-	// we are not interested in the query parameters.
+	// the query parameters are parsed by ParseURIQuery.
 	uri = strings.Split(uri, "?")[0]
 	parts := strings.Split(uri, "/")
 	if len(parts) == 0 {
@@ -273,6 +292,9 @@ func URIToParts(uri string) (string, schema.GroupVersionResource, string, string
 		switch {
 		case parts[2] != "namespaces": // cluster scoped request that is not a namespace
 			gvr.Resource = parts[2]
+			if len(parts) >= 5 {
+				return ns, gvr, parts[3], strings.Join(parts[4:], "/")
+			}
 			if len(parts) >= 4 {
 				name = parts[3]
 				return ns, gvr, name, ""
@@ -332,6 +354,9 @@ func URIToParts(uri string) (string, schema.GroupVersionResource, string, string
 
 	if parts[3] != "namespaces" {
 		gvr.Resource = parts[3]
+		if len(parts) >= 6 {
+			return ns, gvr, parts[4], strings.Join(parts[5:], "/")
+		}
 		if len(parts) >= 5 {
 			name = parts[4]
 			return ns, gvr, name, ""
@@ -0,0 +1,91 @@
+package filter
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+// GarbageCollectorUser is the service account of the garbage collector controller in kube-controller-manager.
+const GarbageCollectorUser = "system:serviceaccount:kube-system:generic-garbage-collector"
+
+// metadataOnlyFields are the metadata fields a patch may carry besides finalizers and ownerReferences and still only
+// modify them: the optimistic concurrency preconditions, the identity of apply patches and the strategic merge
+// directives of the two lists.
+var metadataOnlyFields = map[string]bool{
+	"resourceVersion":                     true,
+	"uid":                                 true,
+	"name":                                true,
+	"namespace":                           true,
+	"$setElementOrder/finalizers":         true,
+	"$setElementOrder/ownerReferences":    true,
+	"$deleteFromPrimitiveList/finalizers": true,
+	"$retainKeys":                         true,
+}
+
+// FinalizerOwnerPatch classifies patches that only modify the finalizers and/or the ownerReferences of an object, the
+// patches the garbage collector and the controllers releasing their finalizers send. Both are false when the patch
+// modifies anything else, or when the request object isn't recorded (below the Request level). Updates send the whole
+// object, so what they change can't be told from the audit event.
+func FinalizerOwnerPatch(e *auditv1.Event) (finalizers, ownerReferences bool) {
+	if e.Verb != "patch" || e.RequestObject == nil {
+		return false, false
+	}
+	raw := bytes.TrimSpace(e.RequestObject.Raw)
+	if len(raw) == 0 {
+		return false, false
+	}
+
+	if raw[0] == '[' {
+		operations := []struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		}{}
+		if err := json.Unmarshal(raw, &operations); err != nil {
+			return false, false
+		}
+		for _, operation := range operations {
+			switch {
+			case operation.Op == "test":
+				// preconditions don't modify the object
+			case operation.Path == "/metadata/finalizers" || strings.HasPrefix(operation.Path, "/metadata/finalizers/"):
+				finalizers = true
+			case operation.Path == "/metadata/ownerReferences" || strings.HasPrefix(operation.Path, "/metadata/ownerReferences/"):
+				ownerReferences = true
+			default:
+				return false, false
+			}
+		}
+		return finalizers, ownerReferences
+	}
+
+	object := map[string]json.RawMessage{}
+	if err := json.Unmarshal(raw, &object); err != nil {
+		return false, false
+	}
+	metadata := map[string]json.RawMessage{}
+	for key, value := range object {
+		switch key {
+		case "apiVersion", "kind":
+		case "metadata":
+			if err := json.Unmarshal(value, &metadata); err != nil {
+				return false, false
+			}
+		default:
+			return false, false
+		}
+	}
+	for key := range metadata {
+		switch {
+		case key == "finalizers":
+			finalizers = true
+		case key == "ownerReferences":
+			ownerReferences = true
+		case !metadataOnlyFields[key]:
+			return false, false
+		}
+	}
+	return finalizers, ownerReferences
+}
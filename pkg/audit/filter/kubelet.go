@@ -0,0 +1,66 @@
+package filter
+
+import (
+	"strings"
+
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+// nodeUserPrefix prefixes the user name of the kubelets, followed by the node name.
+const nodeUserPrefix = "system:node:"
+
+// kubeletWrites are the writes kubelets send, by group/resource/subresource. They are the writes the NodeRestriction
+// admission plugin lets kubelets do, everything else a kubelet writes is unusual.
+var kubeletWrites = map[string]map[string]bool{
+	"/nodes/":                     {"create": true, "update": true, "patch": true},
+	"/nodes/status":               {"update": true, "patch": true},
+	"coordination.k8s.io/leases/": {"create": true, "update": true, "patch": true},
+	"/pods/":                      {"create": true, "delete": true},
+	"/pods/status":                {"update": true, "patch": true},
+	"/events/":                    {"create": true, "update": true, "patch": true},
+	"events.k8s.io/events/":       {"create": true, "update": true, "patch": true},
+	"certificates.k8s.io/certificatesigningrequests/": {"create": true},
+	"/serviceaccounts/token":                          {"create": true},
+	"/persistentvolumeclaims/status":                  {"update": true, "patch": true},
+	"authentication.k8s.io/tokenreviews/":             {"create": true},
+	"authorization.k8s.io/subjectaccessreviews/":      {"create": true},
+	"storage.k8s.io/csinodes/":                        {"create": true, "update": true, "patch": true, "delete": true},
+	"resource.k8s.io/resourceslices/":                 {"create": true, "update": true, "patch": true, "delete": true, "deletecollection": true},
+}
+
+// KubeletNode returns the name of the node of a kubelet request, empty when the request isn't sent by a kubelet.
+func KubeletNode(e *auditv1.Event) string {
+	if !strings.HasPrefix(e.User.Username, nodeUserPrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(e.User.Username, nodeUserPrefix)
+}
+
+// IsNodeStatusUpdate classifies the updates and patches of the status of a node, the node status heartbeat.
+func IsNodeStatusUpdate(e *auditv1.Event) bool {
+	return (e.Verb == "update" || e.Verb == "patch") && e.ObjectRef != nil && e.ObjectRef.Resource == "nodes" &&
+		len(e.ObjectRef.APIGroup) == 0 && e.ObjectRef.Subresource == "status"
+}
+
+// IsNodeLeaseRenewal classifies the updates of the heartbeat lease of a node, which kubelets renew every 10s by default.
+func IsNodeLeaseRenewal(e *auditv1.Event) bool {
+	return (e.Verb == "update" || e.Verb == "patch") && e.ObjectRef != nil && e.ObjectRef.Resource == "leases" &&
+		e.ObjectRef.APIGroup == "coordination.k8s.io" && e.ObjectRef.Namespace == nodeLeaseNamespace
+}
+
+// IsUnusualKubeletRequest classifies the requests of a kubelet it doesn't normally send: writes of resources other
+// than its node, lease, pods, events and the few others kubelets write, and requests forbidden by authorization or
+// the NodeRestriction admission plugin.
+func IsUnusualKubeletRequest(e *auditv1.Event) bool {
+	if e.ResponseStatus != nil && e.ResponseStatus.Code == 403 {
+		return true
+	}
+	switch e.Verb {
+	case "get", "list", "watch":
+		return false
+	}
+	if e.ObjectRef == nil {
+		return true
+	}
+	return !kubeletWrites[e.ObjectRef.APIGroup+"/"+e.ObjectRef.Resource+"/"+e.ObjectRef.Subresource][e.Verb]
+}
@@ -0,0 +1,100 @@
+package filter
+
+import (
+	"encoding/json"
+	"strings"
+
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+const (
+	// leaderAnnotation holds the leader election record of configmap and endpoints locks.
+	leaderAnnotation = "control-plane.alpha.kubernetes.io/leader"
+	// nodeLeaseNamespace holds the node heartbeat leases, which are not leader election.
+	nodeLeaseNamespace = "kube-node-lease"
+)
+
+// IsLeaderElection classifies requests for leader election locks: leases outside of the node heartbeat namespace, and
+// configmaps and endpoints carrying the leader annotation. Below the Request level the annotation isn't recorded, so
+// configmaps and endpoints are also classified by their name (eg. kube-scheduler-leader, cluster-version-lock).
+func IsLeaderElection(e *auditv1.Event) bool {
+	if e.ObjectRef == nil || len(e.ObjectRef.Name) == 0 {
+		return false
+	}
+	switch {
+	case e.ObjectRef.Resource == "leases" && e.ObjectRef.APIGroup == "coordination.k8s.io":
+		return e.ObjectRef.Namespace != nodeLeaseNamespace
+	case (e.ObjectRef.Resource == "configmaps" || e.ObjectRef.Resource == "endpoints") && len(e.ObjectRef.APIGroup) == 0:
+		if strings.Contains(e.ObjectRef.Name, "leader") || strings.HasSuffix(e.ObjectRef.Name, "-lock") {
+			return true
+		}
+		_, ok := leaderElectionRecord(e)
+		return ok
+	}
+	return false
+}
+
+// LeaderElectionLock returns the namespace and name of the leader election lock, which usually names the component.
+func LeaderElectionLock(e *auditv1.Event) string {
+	return e.ObjectRef.Namespace + "/" + e.ObjectRef.Name
+}
+
+// LeaderElectionHolder returns the identity of the leader the request records, empty when the request object isn't
+// recorded or doesn't name a holder.
+func LeaderElectionHolder(e *auditv1.Event) string {
+	record, _ := leaderElectionRecord(e)
+	return record.HolderIdentity
+}
+
+type leaderRecord struct {
+	HolderIdentity string `json:"holderIdentity"`
+}
+
+// leaderElectionRecord reads the holder from the spec of a lease or the leader annotation of a configmap or endpoints
+// request object.
+func leaderElectionRecord(e *auditv1.Event) (leaderRecord, bool) {
+	if e.RequestObject == nil || len(e.RequestObject.Raw) == 0 {
+		return leaderRecord{}, false
+	}
+	object := struct {
+		Metadata struct {
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+		Spec *leaderRecord `json:"spec"`
+	}{}
+	if err := json.Unmarshal(e.RequestObject.Raw, &object); err != nil {
+		return leaderRecord{}, false
+	}
+	if e.ObjectRef.Resource == "leases" {
+		if object.Spec == nil {
+			return leaderRecord{}, false
+		}
+		return *object.Spec, true
+	}
+	annotation, ok := object.Metadata.Annotations[leaderAnnotation]
+	if !ok {
+		return leaderRecord{}, false
+	}
+	record := leaderRecord{}
+	if err := json.Unmarshal([]byte(annotation), &record); err != nil {
+		return leaderRecord{}, false
+	}
+	return record, true
+}
+
+// FilterByLeaderElection passes only the leader election requests, or all others when Exclude is set.
+type FilterByLeaderElection struct {
+	Exclude bool
+}
+
+func (f *FilterByLeaderElection) FilterEvents(events ...*auditv1.Event) []*auditv1.Event {
+	ret := []*auditv1.Event{}
+	for i := range events {
+		event := events[i]
+		if IsLeaderElection(event) != f.Exclude {
+			ret = append(ret, event)
+		}
+	}
+
+	return ret
+}
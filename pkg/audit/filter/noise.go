@@ -0,0 +1,84 @@
+package filter
+
+import (
+	"strings"
+
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+// SystemNoisePaths are the non-resource endpoints polled by probes, load balancers and clients refreshing their
+// discovery caches. Together with discovery they usually make up most of the raw audit volume while hardly ever
+// being of interest. An entry ending with a slash matches all paths below it.
+var SystemNoisePaths = []string{
+	"/healthz",
+	"/healthz/",
+	"/readyz",
+	"/readyz/",
+	"/livez",
+	"/livez/",
+	"/version",
+	"/openapi/v2",
+	"/openapi/v3",
+	"/openapi/v3/",
+	"/swagger.json",
+	"/swaggerapi/",
+	"/.well-known/openid-configuration",
+	"/openid/v1/jwks",
+}
+
+// requestPath returns the path of the request URI without the query.
+func requestPath(uri string) string {
+	if i := strings.IndexByte(uri, '?'); i >= 0 {
+		return uri[:i]
+	}
+	return uri
+}
+
+// IsDiscovery is true for the API discovery endpoints: /api, /api/<version>, /apis, /apis/<group> and
+// /apis/<group>/<version>.
+func IsDiscovery(uri string) bool {
+	parts := strings.Split(strings.Trim(requestPath(uri), "/"), "/")
+	switch parts[0] {
+	case "api":
+		return len(parts) <= 2
+	case "apis":
+		return len(parts) <= 3
+	}
+	return false
+}
+
+// IsOpenAPI is true for the OpenAPI v2 and v3 endpoints.
+func IsOpenAPI(uri string) bool {
+	path := requestPath(uri)
+	return path == "/openapi/v2" || path == "/openapi/v3" || strings.HasPrefix(path, "/openapi/v3/") || path == "/swagger.json"
+}
+
+// IsSystemNoise is true for requests of the SystemNoisePaths and discovery.
+func IsSystemNoise(e *auditv1.Event) bool {
+	if e.ObjectRef != nil {
+		return false
+	}
+	path := requestPath(e.RequestURI)
+	for _, noise := range SystemNoisePaths {
+		if path == noise || (strings.HasSuffix(noise, "/") && strings.HasPrefix(path, noise)) {
+			return true
+		}
+	}
+	return IsDiscovery(path)
+}
+
+type FilterBySystemNoise struct {
+}
+
+// FilterEvents passes the events that are not system noise.
+func (f *FilterBySystemNoise) FilterEvents(events ...*auditv1.Event) []*auditv1.Event {
+	ret := []*auditv1.Event{}
+	for i := range events {
+		event := events[i]
+		if !IsSystemNoise(event) {
+			ret = append(ret, event)
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,31 @@
+package filter
+
+import (
+	"k8s.io/apimachinery/pkg/labels"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+
+	"github.com/natamm4/audit-tool/pkg/audit/objects"
+)
+
+// FilterByObjectLabels passes the events whose decoded object (see objects.Target) has labels matching the selector.
+// Events without a recorded object, below the Request level or with only a patch or a Status, don't match.
+type FilterByObjectLabels struct {
+	Selector labels.Selector
+}
+
+func (f *FilterByObjectLabels) FilterEvents(events ...*auditv1.Event) []*auditv1.Event {
+	ret := []*auditv1.Event{}
+	for i := range events {
+		event := events[i]
+		object, err := objects.Target(event)
+		if err != nil {
+			continue
+		}
+
+		if f.Selector.Matches(labels.Set(object.Unstructured.GetLabels())) {
+			ret = append(ret, event)
+		}
+	}
+
+	return ret
+}
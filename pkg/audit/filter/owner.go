@@ -0,0 +1,66 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+
+	"github.com/natamm4/audit-tool/pkg/audit/objects"
+)
+
+// Owner identifies the owners an object may reference, by kind (case insensitive) and, unless empty, name.
+type Owner struct {
+	Kind string
+	Name string
+}
+
+// ParseOwner parses an owner given as KIND/NAME, eg. ReplicaSet/web-5d8f7, or KIND for owners of any name.
+func ParseOwner(value string) (Owner, error) {
+	parts := strings.SplitN(value, "/", 2)
+	owner := Owner{Kind: parts[0]}
+	if len(parts) == 2 {
+		owner.Name = parts[1]
+		if len(owner.Name) == 0 {
+			return Owner{}, fmt.Errorf("invalid owner %q, the name must not be empty", value)
+		}
+	}
+	if len(owner.Kind) == 0 {
+		return Owner{}, fmt.Errorf("invalid owner %q, must be KIND/NAME or KIND", value)
+	}
+	return owner, nil
+}
+
+// FilterByOwners passes the events whose decoded object (see objects.Target) has an ownerReference to one of the
+// owners, eg. the pods created and updated for a ReplicaSet. Events without a recorded object don't match.
+type FilterByOwners struct {
+	Owners []Owner
+}
+
+func (f *FilterByOwners) FilterEvents(events ...*auditv1.Event) []*auditv1.Event {
+	ret := []*auditv1.Event{}
+	for i := range events {
+		event := events[i]
+		object, err := objects.Target(event)
+		if err != nil {
+			continue
+		}
+
+		if f.ownedBy(object) {
+			ret = append(ret, event)
+		}
+	}
+
+	return ret
+}
+
+func (f *FilterByOwners) ownedBy(object *objects.Object) bool {
+	for _, reference := range object.Unstructured.GetOwnerReferences() {
+		for _, owner := range f.Owners {
+			if strings.EqualFold(reference.Kind, owner.Kind) && (len(owner.Name) == 0 || reference.Name == owner.Name) {
+				return true
+			}
+		}
+	}
+	return false
+}
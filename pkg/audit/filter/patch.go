@@ -0,0 +1,101 @@
+package filter
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+// Patch types reported by PatchType.
+const (
+	PatchTypeJSON           = "json"
+	PatchTypeMerge          = "merge"
+	PatchTypeStrategicMerge = "strategic-merge"
+	PatchTypeApply          = "apply"
+	PatchTypeUnknown        = "unknown"
+)
+
+// patchContentTypes maps the patch content types to the patch types, audit policies and webhooks that record the
+// request content type do so in an annotation ending with "content-type".
+var patchContentTypes = map[string]string{
+	"application/json-patch+json":            PatchTypeJSON,
+	"application/merge-patch+json":           PatchTypeMerge,
+	"application/strategic-merge-patch+json": PatchTypeStrategicMerge,
+	"application/apply-patch+yaml":           PatchTypeApply,
+	"application/apply-patch+cbor":           PatchTypeApply,
+}
+
+// strategicMergeDirectives only appear in strategic merge patches.
+var strategicMergeDirectives = []string{`"$patch"`, `"$setElementOrder/`, `"$retainKeys"`, `"$deleteFromPrimitiveList/`}
+
+// PatchType returns the type of the patch of a patch request, or empty string for other verbs. The content type is
+// not part of the audit event, so unless annotated it is inferred from the request: the force parameter is only
+// accepted by server-side apply, JSON patches are lists of operations, apply configurations are complete objects
+// and strategic merge patches might contain directives. Without directives a strategic merge patch can't be told
+// from a JSON merge patch and is reported as merge. Events below the Request level are reported as unknown.
+func PatchType(e *auditv1.Event) string {
+	if e.Verb != "patch" {
+		return ""
+	}
+	for key, value := range e.Annotations {
+		if !strings.HasSuffix(strings.ToLower(key), "content-type") {
+			continue
+		}
+		if patchType, ok := patchContentTypes[strings.TrimSpace(strings.Split(value, ";")[0])]; ok {
+			return patchType
+		}
+	}
+	if _, ok := ParseURIQuery(e.RequestURI).Values["force"]; ok {
+		return PatchTypeApply
+	}
+
+	if e.RequestObject == nil {
+		return PatchTypeUnknown
+	}
+	raw := bytes.TrimSpace(e.RequestObject.Raw)
+	if len(raw) == 0 {
+		return PatchTypeUnknown
+	}
+	if raw[0] == '[' {
+		return PatchTypeJSON
+	}
+	for _, directive := range strategicMergeDirectives {
+		if bytes.Contains(raw, []byte(directive)) {
+			return PatchTypeStrategicMerge
+		}
+	}
+	object := map[string]json.RawMessage{}
+	if err := json.Unmarshal(raw, &object); err != nil {
+		return PatchTypeUnknown
+	}
+	_, hasAPIVersion := object["apiVersion"]
+	_, hasKind := object["kind"]
+	if hasAPIVersion && hasKind {
+		return PatchTypeApply
+	}
+	return PatchTypeMerge
+}
+
+type FilterByPatchTypes struct {
+	PatchTypes sets.String
+}
+
+func (f *FilterByPatchTypes) FilterEvents(events ...*auditv1.Event) []*auditv1.Event {
+	ret := []*auditv1.Event{}
+	for i := range events {
+		event := events[i]
+		patchType := PatchType(event)
+		if len(patchType) == 0 {
+			continue
+		}
+
+		if AcceptString(f.PatchTypes, patchType) {
+			ret = append(ret, event)
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,141 @@
+package filter
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+// URIQuery holds the query parameters of a request URI, the typed fields are the ones that determine how expensive
+// a list or watch request is for the API server.
+type URIQuery struct {
+	LabelSelector   string
+	FieldSelector   string
+	ResourceVersion string
+	Watch           bool
+	// Limit is 0 when the request is not paginated
+	Limit    int64
+	Continue string
+
+	Values url.Values
+}
+
+// ParseURIQuery parses the query string of the request URI, malformed parameters are ignored.
+func ParseURIQuery(uri string) URIQuery {
+	q := URIQuery{Values: url.Values{}}
+	i := strings.IndexByte(uri, '?')
+	if i == -1 {
+		return q
+	}
+	values, _ := url.ParseQuery(uri[i+1:])
+	if values != nil {
+		q.Values = values
+	}
+	q.LabelSelector = q.Values.Get("labelSelector")
+	q.FieldSelector = q.Values.Get("fieldSelector")
+	q.ResourceVersion = q.Values.Get("resourceVersion")
+	q.Watch, _ = strconv.ParseBool(q.Values.Get("watch"))
+	q.Limit, _ = strconv.ParseInt(q.Values.Get("limit"), 10, 64)
+	q.Continue = q.Values.Get("continue")
+	return q
+}
+
+// ResourceVersionZero is true when the request is served from the watch cache regardless of its freshness.
+func (q URIQuery) ResourceVersionZero() bool {
+	return q.ResourceVersion == "0"
+}
+
+// ServedFromWatchCache is true for the list and watch requests the API server serves from its watch cache: watches,
+// and lists with a resourceVersion unless they continue a paginated list or are paginated at an exact version. Lists
+// with an empty resourceVersion are consistent reads from etcd.
+func (q URIQuery) ServedFromWatchCache() bool {
+	if q.Watch || q.ResourceVersionZero() {
+		return true
+	}
+	return len(q.ResourceVersion) > 0 && len(q.Continue) == 0 && q.Limit <= 0
+}
+
+// FieldSelectorFields returns the fields the field selector matches on, eg. spec.nodeName for spec.nodeName=node-1.
+// Negated requirements are returned with the ! prefix as they can't be looked up in an index.
+func (q URIQuery) FieldSelectorFields() []string {
+	fields := []string{}
+	if len(q.FieldSelector) == 0 {
+		return fields
+	}
+	for _, requirement := range strings.Split(q.FieldSelector, ",") {
+		if i := strings.Index(requirement, "!="); i >= 0 {
+			fields = append(fields, "!"+strings.TrimSpace(requirement[:i]))
+			continue
+		}
+		if i := strings.IndexByte(requirement, '='); i >= 0 {
+			requirement = requirement[:i]
+		}
+		fields = append(fields, strings.TrimSpace(requirement))
+	}
+	return fields
+}
+
+// ContinueToken is the decoded continue parameter of a paginated list. All pages of a list share the resource version
+// of the first page, Start is the etcd key the page starts after.
+type ContinueToken struct {
+	ResourceVersion int64  `json:"rv"`
+	Start           string `json:"start"`
+}
+
+// ContinueToken decodes the continue parameter, which is opaque to clients but the same base64 encoded JSON for all
+// resources served from etcd.
+func (q URIQuery) ContinueToken() (ContinueToken, error) {
+	token := ContinueToken{}
+	if len(q.Continue) == 0 {
+		return token, fmt.Errorf("request has no continue token")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(q.Continue, "="))
+	if err != nil {
+		return token, fmt.Errorf("invalid continue token: %v", err)
+	}
+	if err := json.Unmarshal(data, &token); err != nil {
+		return token, fmt.Errorf("invalid continue token: %v", err)
+	}
+	return token, nil
+}
+
+type FilterBySelectorContains struct {
+	Substrings []string
+}
+
+func (f *FilterBySelectorContains) FilterEvents(events ...*auditv1.Event) []*auditv1.Event {
+	ret := []*auditv1.Event{}
+	for i := range events {
+		event := events[i]
+		query := ParseURIQuery(event.RequestURI)
+
+		for _, substring := range f.Substrings {
+			if strings.Contains(query.LabelSelector, substring) || strings.Contains(query.FieldSelector, substring) {
+				ret = append(ret, event)
+				break
+			}
+		}
+	}
+
+	return ret
+}
+
+type FilterByResourceVersionZero struct {
+}
+
+func (f *FilterByResourceVersionZero) FilterEvents(events ...*auditv1.Event) []*auditv1.Event {
+	ret := []*auditv1.Event{}
+	for i := range events {
+		event := events[i]
+		if ParseURIQuery(event.RequestURI).ResourceVersionZero() {
+			ret = append(ret, event)
+		}
+	}
+
+	return ret
+}
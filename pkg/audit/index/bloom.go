@@ -0,0 +1,61 @@
+package index
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// Bloom is a bloom filter over strings: MayContain is false for strings that were never added and true for the added
+// ones, but also for a small fraction of the others.
+type Bloom struct {
+	// K is the number of bits set per string
+	K    int    `json:"k"`
+	Bits []byte `json:"bits"`
+}
+
+// NewBloom returns a bloom filter sized for n strings with the false positive rate.
+func NewBloom(n int, falsePositiveRate float64) *Bloom {
+	if n < 1 {
+		n = 1
+	}
+	m := int(math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	// whole bytes, at least one
+	m = (m + 7) / 8 * 8
+	k := int(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &Bloom{K: k, Bits: make([]byte, m/8)}
+}
+
+// positions derives the bit positions of the string from two halves of its hash (Kirsch-Mitzenmacher).
+func (b *Bloom) positions(s string, visit func(bit uint64) bool) bool {
+	hash := fnv.New64a()
+	hash.Write([]byte(s))
+	sum := hash.Sum64()
+	h1, h2 := sum&math.MaxUint32, sum>>32|1
+	m := uint64(len(b.Bits)) * 8
+	for i := uint64(0); i < uint64(b.K); i++ {
+		if !visit((h1 + i*h2) % m) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *Bloom) Add(s string) {
+	b.positions(s, func(bit uint64) bool {
+		b.Bits[bit/8] |= 1 << (bit % 8)
+		return true
+	})
+}
+
+// MayContain is false when the string was certainly not added.
+func (b *Bloom) MayContain(s string) bool {
+	if b == nil || len(b.Bits) == 0 {
+		return true
+	}
+	return b.positions(s, func(bit uint64) bool {
+		return b.Bits[bit/8]&(1<<(bit%8)) != 0
+	})
+}
@@ -0,0 +1,233 @@
+// Package index describes the audit files of a directory by bloom filters of the values queries most often select
+// on, so that a query can skip the audit files that certainly don't contain any matching event without reading them.
+package index
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+
+	"github.com/natamm4/audit-tool/pkg/audit/filter"
+)
+
+const (
+	// FileName is the name of the index in the audit directory, hidden so it isn't part of the manifest.
+	FileName = ".audit-tool-index.json.gz"
+
+	// version is increased whenever the indexed values change, indexes of other versions are ignored
+	version = 1
+
+	// falsePositiveRate is the fraction of audit files read although they contain no matching event
+	falsePositiveRate = 0.01
+
+	// checkpointSlack is how much earlier than the stage timestamps of the events written after them the events written
+	// before a checkpoint may be
+	checkpointSlack = time.Minute
+)
+
+// Index maps the paths of the audit files, relative to the audit directory, to their index.
+type Index struct {
+	Version int              `json:"version"`
+	Files   map[string]*File `json:"files"`
+	// Ranges are the time ranges of the audit files, also recorded by queries selecting a time range
+	Ranges map[string]*TimeRange `json:"ranges,omitempty"`
+}
+
+// File holds the bloom filters of an audit file. The size and modification time tell whether the audit file changed
+// since it was indexed.
+type File struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Events  int       `json:"events"`
+
+	AuditIDs   *Bloom `json:"auditIDs"`
+	Users      *Bloom `json:"users"`
+	Namespaces *Bloom `json:"namespaces"`
+	// Resources holds the keys of ResourceKey and AnyGroupResourceKey
+	Resources *Bloom `json:"resources"`
+}
+
+// Current is true when the audit file didn't change since it was indexed.
+func (f *File) Current(size int64, modTime time.Time) bool {
+	return f.Size == size && f.ModTime.Equal(modTime)
+}
+
+// TimeRange bounds the request received times of the events of an audit file. The size and modification time tell
+// whether the audit file changed since its time range was read.
+type TimeRange struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	First   time.Time `json:"first"`
+	Last    time.Time `json:"last"`
+	// Checkpoints are lines of uncompressed audit files at regular offsets, ordered by offset
+	Checkpoints []Checkpoint `json:"checkpoints,omitempty"`
+}
+
+// Checkpoint locates a line of an uncompressed audit file, so that queries of a time range can start reading there
+// instead of at the beginning of the file.
+type Checkpoint struct {
+	// Time is the stage timestamp of the event on the line, the events before it were written earlier
+	Time   time.Time `json:"time"`
+	Offset int64     `json:"offset"`
+	// Line is the number of lines before the checkpoint
+	Line int `json:"line"`
+}
+
+// Start returns the last checkpoint before which no event can have been received at from or later, false when the
+// audit file has to be read from the beginning. The events of concurrent requests aren't written exactly in the order
+// of their stage timestamps, the checkpoint is at least checkpointSlack before from.
+func (r *TimeRange) Start(from time.Time) (Checkpoint, bool) {
+	before := from.Add(-checkpointSlack)
+	i := sort.Search(len(r.Checkpoints), func(i int) bool {
+		return !r.Checkpoints[i].Time.Before(before)
+	})
+	if i == 0 {
+		return Checkpoint{}, false
+	}
+	return r.Checkpoints[i-1], true
+}
+
+// Current is true when the audit file didn't change since its time range was read.
+func (r *TimeRange) Current(size int64, modTime time.Time) bool {
+	return r.Size == size && r.ModTime.Equal(modTime)
+}
+
+// Overlaps is true when events of the audit file may have been received between from and to, a zero time leaves that
+// side open. Audit files without events overlap no time range.
+func (r *TimeRange) Overlaps(from, to time.Time) bool {
+	if r.First.IsZero() {
+		return false
+	}
+	return (from.IsZero() || !r.Last.Before(from)) && (to.IsZero() || r.First.Before(to))
+}
+
+// ResourceKey is the key of the resource of a group in the Resources filter.
+func ResourceKey(gr schema.GroupResource) string {
+	return "gr:" + gr.String()
+}
+
+// AnyGroupResourceKey is the key of the resource regardless of its group in the Resources filter.
+func AnyGroupResourceKey(resource string) string {
+	return "r:" + resource
+}
+
+// New returns an empty index.
+func New() *Index {
+	return &Index{Version: version, Files: map[string]*File{}, Ranges: map[string]*TimeRange{}}
+}
+
+// Read returns the index of the audit directory, nil when there is none or it was written by another version.
+func Read(dir string) (*Index, error) {
+	f, err := os.Open(filepath.Join(dir, FileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid index %s: %v", filepath.Join(dir, FileName), err)
+	}
+	idx := &Index{}
+	if err := json.NewDecoder(r).Decode(idx); err != nil {
+		return nil, fmt.Errorf("invalid index %s: %v", filepath.Join(dir, FileName), err)
+	}
+	if idx.Version != version {
+		return nil, nil
+	}
+	if idx.Ranges == nil {
+		idx.Ranges = map[string]*TimeRange{}
+	}
+	return idx, nil
+}
+
+// Write stores the index in the audit directory.
+func Write(dir string, idx *Index) error {
+	tmp, err := os.CreateTemp(dir, FileName+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := gzip.NewWriter(tmp)
+	if err := json.NewEncoder(w).Encode(idx); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, FileName))
+}
+
+// FileBuilder collects the values of the events of an audit file. The distinct values are only known once all events
+// are added, the bloom filters are sized for them by Build.
+type FileBuilder struct {
+	events      int
+	first, last time.Time
+	auditIDs    sets.String
+	users       sets.String
+	namespaces  sets.String
+	resources   sets.String
+}
+
+func NewFileBuilder() *FileBuilder {
+	return &FileBuilder{auditIDs: sets.NewString(), users: sets.NewString(), namespaces: sets.NewString(), resources: sets.NewString()}
+}
+
+func (b *FileBuilder) Add(e *auditv1.Event) {
+	b.events++
+	if received := e.RequestReceivedTimestamp.Time; b.first.IsZero() || received.Before(b.first) {
+		b.first = received
+	}
+	if received := e.RequestReceivedTimestamp.Time; received.After(b.last) {
+		b.last = received
+	}
+	b.auditIDs.Insert(string(e.AuditID))
+	b.users.Insert(e.User.Username)
+	ns, gvr, _, _ := filter.URIToParts(e.RequestURI)
+	b.namespaces.Insert(ns)
+	if len(gvr.Resource) > 0 {
+		b.resources.Insert(ResourceKey(gvr.GroupResource()), AnyGroupResourceKey(gvr.Resource))
+	}
+}
+
+// Build returns the index of the audit file with the size and modification time it was read with.
+func (b *FileBuilder) Build(size int64, modTime time.Time) *File {
+	return &File{
+		Size:       size,
+		ModTime:    modTime,
+		Events:     b.events,
+		AuditIDs:   newBloom(b.auditIDs),
+		Users:      newBloom(b.users),
+		Namespaces: newBloom(b.namespaces),
+		Resources:  newBloom(b.resources),
+	}
+}
+
+// Range returns the time range of the audit file with the size and modification time it was read with.
+func (b *FileBuilder) Range(size int64, modTime time.Time) *TimeRange {
+	return &TimeRange{Size: size, ModTime: modTime, First: b.first, Last: b.last}
+}
+
+func newBloom(values sets.String) *Bloom {
+	bloom := NewBloom(values.Len(), falsePositiveRate)
+	for value := range values {
+		bloom.Add(value)
+	}
+	return bloom
+}
@@ -0,0 +1,94 @@
+// Package jq filters and transforms audit events by jq expressions, which many users already know from working with
+// audit logs on the command line. The expressions are evaluated by gojq, an implementation of the jq language in Go.
+//
+// The input of the expression is the JSON representation of the event, eg.
+//
+//	select(.verb == "delete" and .objectRef.resource == "secrets")
+//	select(.user.groups | any(. == "system:masters")) | {user: .user.username, uri: .requestURI}
+//	select(.responseStatus.code >= 400) | .objectRef | .namespace + "/" + .name
+//
+// The differences of gojq to jq are listed at https://github.com/itchyny/gojq#difference-to-jq, eg. the keys of
+// objects are always sorted. Modules and the input and inputs builtins are not available, env and $ENV are empty.
+package jq
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/itchyny/gojq"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+// Program is a compiled expression.
+type Program struct {
+	expression string
+	code       *gojq.Code
+}
+
+func Compile(expression string) (*Program, error) {
+	query, err := gojq.Parse(expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid jq expression %q: %v", expression, err)
+	}
+	code, err := gojq.Compile(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid jq expression %q: %v", expression, err)
+	}
+	return &Program{expression: expression, code: code}, nil
+}
+
+// Run evaluates the expression over the event and returns all its outputs.
+func (p *Program) Run(e *auditv1.Event) ([]interface{}, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	var event interface{}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, err
+	}
+	outputs := []interface{}{}
+	iter := p.code.Run(event)
+	for {
+		output, ok := iter.Next()
+		if !ok {
+			return outputs, nil
+		}
+		if err, ok := output.(error); ok {
+			return nil, err
+		}
+		outputs = append(outputs, output)
+	}
+}
+
+// Matches is true when the expression produces at least one output that is neither false nor null, so both
+// select(...) and plain conditions work as filters.
+func (p *Program) Matches(e *auditv1.Event) (bool, error) {
+	outputs, err := p.Run(e)
+	if err != nil {
+		return false, err
+	}
+	for _, output := range outputs {
+		if output != nil && output != false {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type FilterByJQ struct {
+	Program *Program
+}
+
+// FilterEvents passes the events the expression matches, evaluation errors don't match.
+func (f *FilterByJQ) FilterEvents(events ...*auditv1.Event) []*auditv1.Event {
+	ret := []*auditv1.Event{}
+	for i := range events {
+		event := events[i]
+		if ok, err := f.Program.Matches(event); err == nil && ok {
+			ret = append(ret, event)
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,259 @@
+package jq
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+func testEvent() *auditv1.Event {
+	e := &auditv1.Event{
+		Level:          auditv1.LevelMetadata,
+		AuditID:        "a1",
+		Stage:          auditv1.StageResponseComplete,
+		RequestURI:     "/api/v1/namespaces/prod/pods/web-0/exec?command=sh",
+		Verb:           "create",
+		ObjectRef:      &auditv1.ObjectReference{Resource: "pods", Namespace: "prod", Name: "web-0", Subresource: "exec", APIVersion: "v1"},
+		ResponseStatus: &metav1.Status{Code: 403},
+		Annotations:    map[string]string{"authorization.k8s.io/decision": "forbid"},
+	}
+	e.User.Username = "alice"
+	e.User.Groups = []string{"developers", "system:authenticated"}
+	return e
+}
+
+// The expected outputs were produced by jq 1.6 from the JSON of testEvent, except for the features it lacks (.a.[0]
+// and if without else). Objects are iterated in the order of their sorted keys.
+func TestRun(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected   []string
+	}{
+		// paths
+		{expression: `.verb`, expected: []string{`"create"`}},
+		{expression: `.objectRef.resource`, expected: []string{`"pods"`}},
+		{expression: `.objectRef."subresource"`, expected: []string{`"exec"`}},
+		{expression: `."verb"`, expected: []string{`"create"`}},
+		{expression: `.missing`, expected: []string{`null`}},
+		{expression: `.missing.deeper`, expected: []string{`null`}},
+		{expression: `.user.groups[0]`, expected: []string{`"developers"`}},
+		{expression: `.user.groups[-1]`, expected: []string{`"system:authenticated"`}},
+		{expression: `.user.groups[5]`, expected: []string{`null`}},
+		{expression: `.user.groups.[0]`, expected: []string{`"developers"`}},
+		{expression: `.user.groups[]`, expected: []string{`"developers"`, `"system:authenticated"`}},
+		{expression: `.user["username"]`, expected: []string{`"alice"`}},
+		{expression: `.annotations["authorization.k8s.io/decision"]`, expected: []string{`"forbid"`}},
+		{expression: `.missing?`, expected: []string{`null`}},
+		{expression: `.verb.foo?`, expected: []string{}},
+		{expression: `[.user[]?]`, expected: []string{`[["developers","system:authenticated"],"alice"]`}},
+
+		// pipes, comma and construction
+		{expression: `.user.groups | length`, expected: []string{`2`}},
+		{expression: `.user | keys`, expected: []string{`["groups","username"]`}},
+		{expression: `.objectRef | .namespace + "/" + .name`, expected: []string{`"prod/web-0"`}},
+		{expression: `.verb, .stage`, expected: []string{`"create"`, `"ResponseComplete"`}},
+		{expression: `[.verb, .stage]`, expected: []string{`["create","ResponseComplete"]`}},
+		{expression: `[.user.groups[] | ascii_upcase]`, expected: []string{`["DEVELOPERS","SYSTEM:AUTHENTICATED"]`}},
+		{expression: `{verb, uri: .requestURI}`, expected: []string{`{"uri":"/api/v1/namespaces/prod/pods/web-0/exec?command=sh","verb":"create"}`}},
+		{expression: `{(.verb): .stage}`, expected: []string{`{"create":"ResponseComplete"}`}},
+		{expression: `{"a-b": 1, c: (1, 2)}`, expected: []string{`{"a-b":1,"c":1}`, `{"a-b":1,"c":2}`}},
+
+		// select and map
+		{expression: `select(.verb == "create") | .objectRef.name`, expected: []string{`"web-0"`}},
+		{expression: `select(.verb == "get")`, expected: []string{}},
+		{expression: `select(.responseStatus.code >= 400 and .objectRef.resource == "pods") | .auditID`, expected: []string{`"a1"`}},
+		{expression: `[.user.groups[] | select(contains("auth"))]`, expected: []string{`["system:authenticated"]`}},
+		{expression: `.user.groups | map(length)`, expected: []string{`[10,20]`}},
+		{expression: `.user.groups | map(select(startswith("system:")))`, expected: []string{`["system:authenticated"]`}},
+		{expression: `.annotations | with_entries(select(.value == "forbid"))`, expected: []string{`{"authorization.k8s.io/decision":"forbid"}`}},
+		{expression: `.user.groups | any(. == "developers")`, expected: []string{`true`}},
+		{expression: `.user.groups | all(startswith("system:"))`, expected: []string{`false`}},
+
+		// operators and precedence
+		{expression: `1 + 2 * 3 - 4 / 2`, expected: []string{`5`}},
+		{expression: `(1 + 2) * 3`, expected: []string{`9`}},
+		{expression: `10 / 4`, expected: []string{`2.5`}},
+		{expression: `-.responseStatus.code`, expected: []string{`-403`}},
+		{expression: `"a" + "b"`, expected: []string{`"ab"`}},
+		{expression: `[1, 2] + [3]`, expected: []string{`[1,2,3]`}},
+		{expression: `{a: 1} + {b: 2}`, expected: []string{`{"a":1,"b":2}`}},
+		{expression: `null + 1`, expected: []string{`1`}},
+		{expression: `.missing // "default"`, expected: []string{`"default"`}},
+		{expression: `false // "fallback"`, expected: []string{`"fallback"`}},
+		{expression: `.verb // "default"`, expected: []string{`"create"`}},
+		{expression: `(false, null, 1) // 2`, expected: []string{`1`}},
+		{expression: `empty // 3`, expected: []string{`3`}},
+		{expression: `true and false or true`, expected: []string{`true`}},
+		{expression: `true or false and false`, expected: []string{`true`}},
+		{expression: `.verb | not`, expected: []string{`false`}},
+		{expression: `1 == 1.0`, expected: []string{`true`}},
+		{expression: `[1, 2] == [1, 2]`, expected: []string{`true`}},
+		{expression: `"abc" < "abd"`, expected: []string{`true`}},
+		{expression: `null < false`, expected: []string{`true`}},
+
+		// conditionals
+		{expression: `if .verb == "create" then "write" elif .verb == "get" then "read" else "other" end`, expected: []string{`"write"`}},
+		{expression: `if .verb == "get" then "read" end | .verb`, expected: []string{`"create"`}},
+
+		// builtins
+		{expression: `.user.groups | join(",")`, expected: []string{`"developers,system:authenticated"`}},
+		{expression: `.requestURI | split("/")`, expected: []string{`["","api","v1","namespaces","prod","pods","web-0","exec?command=sh"]`}},
+		{expression: `.requestURI | test("exec")`, expected: []string{`true`}},
+		{expression: `.requestURI | ltrimstr("/api/v1/") | rtrimstr("?command=sh")`, expected: []string{`"namespaces/prod/pods/web-0/exec"`}},
+		{expression: `.annotations | to_entries`, expected: []string{`[{"key":"authorization.k8s.io/decision","value":"forbid"}]`}},
+		{expression: `[3, 1, 2] | sort`, expected: []string{`[1,2,3]`}},
+		{expression: `[3, 1, 3] | unique`, expected: []string{`[1,3]`}},
+		{expression: `[3, 1, 2] | min, max`, expected: []string{`1`, `3`}},
+		{expression: `[{"a": 2}, {"a": 1}] | sort_by(.a)`, expected: []string{`[{"a":1},{"a":2}]`}},
+		{expression: `[1, 2, 3] | add`, expected: []string{`6`}},
+		{expression: `[] | add`, expected: []string{`null`}},
+		{expression: `.user.groups | add`, expected: []string{`"developerssystem:authenticated"`}},
+		{expression: `[1, 2, 3] | first, last`, expected: []string{`1`, `3`}},
+		{expression: `first(.user.groups[])`, expected: []string{`"developers"`}},
+		{expression: `.objectRef | has("name")`, expected: []string{`true`}},
+		{expression: `.user.groups | has(1)`, expected: []string{`true`}},
+		{expression: `.responseStatus.code | tostring`, expected: []string{`"403"`}},
+		{expression: `"42" | tonumber`, expected: []string{`42`}},
+		{expression: `.user | tojson`, expected: []string{`"{\"groups\":[\"developers\",\"system:authenticated\"],\"username\":\"alice\"}"`}},
+		{expression: `"{\"a\":1}" | fromjson`, expected: []string{`{"a":1}`}},
+		{expression: `.user | type`, expected: []string{`"object"`}},
+		{expression: `[.responseStatus.code, .missing] | map(values)`, expected: []string{`[403]`}},
+
+		// the rest of the language
+		{expression: `.user.groups[0:1]`, expected: []string{`["developers"]`}},
+		{expression: `.user.groups[] as $g | $g | length`, expected: []string{`10`, `20`}},
+		{expression: `reduce .user.groups[] as $g (0; . + 1)`, expected: []string{`2`}},
+		{expression: `.objectRef | "\(.namespace)/\(.name)"`, expected: []string{`"prod/web-0"`}},
+		{expression: `.verb |= ascii_upcase | .verb`, expected: []string{`"CREATE"`}},
+		{expression: `def kind: .objectRef.resource; kind`, expected: []string{`"pods"`}},
+		{expression: `[paths(type == "number")]`, expected: []string{`[["responseStatus","code"]]`}},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			program, err := Compile(test.expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			outputs, err := program.Run(testEvent())
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, output := range outputs {
+				data, err := json.Marshal(output)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, string(data))
+			}
+			if strings.Join(got, "\n") != strings.Join(test.expected, "\n") {
+				t.Errorf("expected %v, got %v", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		err        string
+	}{
+		{expression: `.verb |`, err: "unexpected token <EOF>"},
+		{expression: `.[`, err: "unexpected token <EOF>"},
+		{expression: `(1`, err: "unexpected token <EOF>"},
+		{expression: `{a`, err: "unexpected token <EOF>"},
+		{expression: `{(.verb)}`, err: `unexpected token "}"`},
+		{expression: `if true then 1`, err: "unexpected token <EOF>"},
+		{expression: `.a b`, err: `unexpected token "b"`},
+		{expression: `unknown`, err: "function not defined: unknown/0"},
+		{expression: `map`, err: "function not defined: map/0"},
+		{expression: `select(.a; .b)`, err: "function not defined: select/2"},
+		{expression: `$missing`, err: "variable not defined: $missing"},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			_, err := Compile(test.expression)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected an error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		err        string
+	}{
+		{expression: `.verb.foo`, err: `expected an object but got: string ("create")`},
+		{expression: `.user.groups.name`, err: "expected an object but got: array"},
+		{expression: `.user.groups["a"]`, err: "expected an object but got: array"},
+		{expression: `.verb + 1`, err: `cannot add: string ("create") and number (1)`},
+		{expression: `{} - 1`, err: "cannot subtract: object ({}) and number (1)"},
+		{expression: `1 / 0`, err: "cannot divide number (1) by: number (0)"},
+		{expression: `"abc" | tonumber`, err: `invalid number: "abc"`},
+		{expression: `.user | join(",")`, err: `cannot add: string ("") and array`},
+		{expression: `.verb, .verb.foo`, err: `expected an object but got: string ("create")`},
+		{expression: `.user.groups | map(.name)`, err: `expected an object but got: string ("developers")`},
+		{expression: `select(.verb.foo == 1)`, err: `expected an object but got: string ("create")`},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			program, err := Compile(test.expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = program.Run(testEvent())
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected an error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected   bool
+	}{
+		{expression: `select(.verb == "create")`, expected: true},
+		{expression: `select(.verb == "get")`, expected: false},
+		{expression: `.objectRef.subresource == "exec"`, expected: true},
+		{expression: `.missing`, expected: false},
+		{expression: `.verb`, expected: true},
+		{expression: `false, null, 0`, expected: true},
+		{expression: `false, null`, expected: false},
+		{expression: `empty`, expected: false},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			program, err := Compile(test.expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			matched, err := program.Matches(testEvent())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if matched != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, matched)
+			}
+		})
+	}
+
+	get := testEvent()
+	get.Verb = "get"
+	get.ObjectRef = nil
+	program, err := Compile(`select(.objectRef.name | startswith("web"))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// evaluation errors, startswith of the null name of the event without an objectRef, don't match
+	filtered := (&FilterByJQ{Program: program}).FilterEvents(testEvent(), get)
+	if len(filtered) != 1 || filtered[0].Verb != "create" {
+		t.Errorf("expected the create event, got %d events", len(filtered))
+	}
+}
@@ -0,0 +1,173 @@
+// Package manifest describes an audit log dump written by get: where and when it was collected and the checksums of
+// its files, so that query can show where a dump comes from and verify it wasn't modified or truncated. A manifest
+// signed with an Ed25519 key proves that the dump wasn't modified since it was collected.
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FileName is the name of the manifest in the dump directory.
+const FileName = "manifest.json"
+
+type Manifest struct {
+	// ClusterID is the OpenShift cluster ID, empty for other clusters
+	ClusterID     string      `json:"clusterID,omitempty"`
+	ServerVersion string      `json:"serverVersion,omitempty"`
+	CollectedAt   time.Time   `json:"collectedAt"`
+	Nodes         []string    `json:"nodes"`
+	APIServers    []APIServer `json:"apiServers,omitempty"`
+	Files         []File      `json:"files"`
+}
+
+// APIServer is a kube-apiserver the audit logs were collected from, the image identifies its version.
+type APIServer struct {
+	Pod   string `json:"pod"`
+	Node  string `json:"node"`
+	Image string `json:"image"`
+}
+
+// File is a file of the dump, the path is relative to the dump directory.
+type File struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Write records the files of the directory with their checksums in the manifest and stores it in the directory.
+func Write(dir string, m *Manifest) error {
+	files, err := checksums(dir)
+	if err != nil {
+		return err
+	}
+	m.Files = files
+	return m.Store(dir)
+}
+
+// Store stores the manifest in the directory as it is, eg. after files were removed from it.
+func (m *Manifest) Store(dir string) error {
+	sort.Strings(m.Nodes)
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, FileName), append(data, '\n'), 0644)
+}
+
+// Read returns the manifest of the directory, nil when there is none.
+func Read(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, FileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %v", filepath.Join(dir, FileName), err)
+	}
+	return m, nil
+}
+
+// Verify compares the files of the directory with the manifest and returns a description of every difference.
+func (m *Manifest) Verify(dir string) ([]string, error) {
+	actual, err := checksums(dir)
+	if err != nil {
+		return nil, err
+	}
+	byPath := map[string]File{}
+	for _, f := range actual {
+		byPath[f.Path] = f
+	}
+	problems := []string{}
+	for _, expected := range m.Files {
+		f, ok := byPath[expected.Path]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%s is missing", expected.Path))
+		case f.Size != expected.Size:
+			problems = append(problems, fmt.Sprintf("%s has %d bytes, expected %d", expected.Path, f.Size, expected.Size))
+		case f.SHA256 != expected.SHA256:
+			problems = append(problems, fmt.Sprintf("%s has checksum %s, expected %s", expected.Path, f.SHA256, expected.SHA256))
+		}
+		delete(byPath, expected.Path)
+	}
+	for _, f := range actual {
+		if _, ok := byPath[f.Path]; ok {
+			problems = append(problems, fmt.Sprintf("%s is not in the manifest", f.Path))
+		}
+	}
+	return problems, nil
+}
+
+// checksums returns the files of the directory except the manifest, its signature and the hidden files query writes
+// (eg. marks).
+func checksums(dir string) ([]File, error) {
+	files := []File{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || info.Name() == FileName || info.Name() == SignatureFileName || strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
+		relative, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		sum, err := sha256File(path)
+		if err != nil {
+			return err
+		}
+		files = append(files, File{Path: filepath.ToSlash(relative), Size: info.Size(), SHA256: sum})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files, nil
+}
+
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// UpdateLatest points the latest symlink next to the dump directory to it.
+func UpdateLatest(dir string) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	link := filepath.Join(filepath.Dir(abs), "latest")
+	if info, err := os.Lstat(link); err == nil {
+		if info.Mode()&os.ModeSymlink == 0 {
+			return fmt.Errorf("%s exists and is not a symlink", link)
+		}
+		if err := os.Remove(link); err != nil {
+			return err
+		}
+	}
+	return os.Symlink(filepath.Base(abs), link)
+}
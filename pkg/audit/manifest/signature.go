@@ -0,0 +1,128 @@
+package manifest
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SignatureFileName is the name of the signature of the manifest in the dump directory, the base64 encoded Ed25519
+// signature of the manifest file.
+const SignatureFileName = FileName + ".sig"
+
+// Sign signs the manifest file of the directory with the key, so that the manifest can't be rewritten to match
+// modified files without the key.
+func Sign(dir string, key ed25519.PrivateKey) error {
+	data, err := os.ReadFile(filepath.Join(dir, FileName))
+	if err != nil {
+		return err
+	}
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(key, data))
+	return os.WriteFile(filepath.Join(dir, SignatureFileName), []byte(signature+"\n"), 0644)
+}
+
+// Signed returns whether the manifest of the directory is signed.
+func Signed(dir string) (bool, error) {
+	_, err := os.Stat(filepath.Join(dir, SignatureFileName))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// VerifySignature fails when the manifest file of the directory isn't signed by the private key of the public key.
+func VerifySignature(dir string, key ed25519.PublicKey) error {
+	data, err := os.ReadFile(filepath.Join(dir, FileName))
+	if err != nil {
+		return err
+	}
+	encoded, err := os.ReadFile(filepath.Join(dir, SignatureFileName))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%s is not signed", FileName)
+	}
+	if err != nil {
+		return err
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", SignatureFileName, err)
+	}
+	if !ed25519.Verify(key, data, signature) {
+		return fmt.Errorf("%s doesn't match its signature, it was modified or signed by another key", FileName)
+	}
+	return nil
+}
+
+// LoadPrivateKey reads a PEM encoded PKCS #8 Ed25519 private key, eg. generated by
+// "openssl genpkey -algorithm ed25519 -out key.pem".
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	der, err := readPEM(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key %s: %v", path, err)
+	}
+	ed25519Key, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key %s is not an Ed25519 key", path)
+	}
+	return ed25519Key, nil
+}
+
+// LoadPublicKey reads a PEM encoded Ed25519 public key, eg. extracted by
+// "openssl pkey -in key.pem -pubout -out key.pub". The public key of a private key file is returned too.
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEMBlock(path)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type == "PRIVATE KEY" {
+		key, err := LoadPrivateKey(path)
+		if err != nil {
+			return nil, err
+		}
+		return key.Public().(ed25519.PublicKey), nil
+	}
+	if block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("%s is not a PEM encoded public key", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key %s: %v", path, err)
+	}
+	ed25519Key, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key %s is not an Ed25519 key", path)
+	}
+	return ed25519Key, nil
+}
+
+func readPEM(path, blockType string) ([]byte, error) {
+	block, err := readPEMBlock(path)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type != blockType {
+		return nil, fmt.Errorf("%s is not a PEM encoded %s", path, strings.ToLower(blockType))
+	}
+	return block.Bytes, nil
+}
+
+func readPEMBlock(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not PEM encoded", path)
+	}
+	return block, nil
+}
@@ -0,0 +1,80 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"strings"
+)
+
+// maxFindings is the number of findings included in a notification, the full report is linked by the evidence.
+const maxFindings = 5
+
+// Notification summarizes the findings of a run. It is posted as JSON, the text field makes it a Slack message too.
+type Notification struct {
+	// Text is the human readable summary, as Slack incoming webhooks expect it.
+	Text     string    `json:"text"`
+	Command  string    `json:"command"`
+	Findings []Finding `json:"findings"`
+	// Total is the number of findings, which may be more than the ones included.
+	Total int `json:"total"`
+	// Evidence is the link or path to the evidence of the findings.
+	Evidence string `json:"evidence,omitempty"`
+}
+
+type Finding struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// New returns the notification of the findings of the command, with the top findings only.
+func New(command, summary string, findings []Finding, evidence string) Notification {
+	n := Notification{Command: command, Findings: findings, Total: len(findings), Evidence: evidence}
+	if len(n.Findings) > maxFindings {
+		n.Findings = n.Findings[:maxFindings]
+	}
+	text := strings.Builder{}
+	fmt.Fprintf(&text, "audit-tool %s: %s\n", command, summary)
+	for _, finding := range n.Findings {
+		fmt.Fprintf(&text, "• %s: %s\n", finding.Rule, finding.Message)
+	}
+	if n.Total > len(n.Findings) {
+		fmt.Fprintf(&text, "… and %d more\n", n.Total-len(n.Findings))
+	}
+	if len(evidence) > 0 {
+		fmt.Fprintf(&text, "Evidence: %s\n", evidence)
+	}
+	n.Text = strings.TrimSuffix(text.String(), "\n")
+	return n
+}
+
+// Send posts the notification to the webhook URL.
+func Send(ctx context.Context, client *http.Client, url string, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if urlErr, ok := err.(*neturl.Error); ok {
+		// webhook URLs hold secrets, they are left out of the error
+		err = urlErr.Err
+	}
+	if err != nil {
+		return fmt.Errorf("sending the notification failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("sending the notification failed: %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
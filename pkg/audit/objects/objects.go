@@ -0,0 +1,123 @@
+// Package objects decodes the request and response objects the Request and RequestResponse audit levels record. Kinds
+// of the Kubernetes scheme are decoded into their Go types, from JSON as well as protobuf, and every JSON object,
+// including custom resources, into unstructured fields.
+package objects
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// ErrNotRecorded is returned for events without the object, below the Request level or for requests without a body.
+var ErrNotRecorded = errors.New("object not recorded")
+
+// Object is a decoded request or response object.
+type Object struct {
+	// Typed is the object as its Go type, nil for custom resources and other kinds the scheme doesn't know
+	Typed runtime.Object
+	// Unstructured holds the fields of the object, it is set for all objects
+	Unstructured *unstructured.Unstructured
+}
+
+// Kind returns the kind of the object, eg. Pod.
+func (o *Object) Kind() string {
+	return o.Unstructured.GetKind()
+}
+
+// Decoder decodes the objects of audit events.
+type Decoder struct {
+	// Strict fails the objects of kinds the scheme doesn't know, eg. custom resources, instead of decoding them only
+	// as unstructured
+	Strict bool
+}
+
+// Decode decodes the object with the default decoder, tolerating kinds unknown to the scheme.
+func Decode(u *runtime.Unknown) (*Object, error) {
+	return Decoder{}.Decode(u)
+}
+
+// Decode decodes the object. Protobuf objects can only be decoded when their kind is in the scheme.
+func (d Decoder) Decode(u *runtime.Unknown) (*Object, error) {
+	if u == nil || len(bytes.TrimSpace(u.Raw)) == 0 {
+		return nil, ErrNotRecorded
+	}
+	typed, gvk, err := scheme.Codecs.UniversalDeserializer().Decode(u.Raw, nil, nil)
+	if err != nil && (d.Strict || !runtime.IsNotRegisteredError(err) && !runtime.IsMissingKind(err)) {
+		return nil, fmt.Errorf("unable to decode the %s object: %v", contentType(u), err)
+	}
+
+	fields := map[string]interface{}{}
+	if raw := bytes.TrimSpace(u.Raw); raw[0] == '{' {
+		// the fields are taken from the JSON instead of the typed object to keep the ones unknown to the Go type
+		if err := utiljson.Unmarshal(raw, &fields); err != nil {
+			return nil, fmt.Errorf("unable to decode the object: %v", err)
+		}
+	} else if typed != nil {
+		if fields, err = runtime.DefaultUnstructuredConverter.ToUnstructured(typed); err != nil {
+			return nil, fmt.Errorf("unable to convert the %s object: %v", gvk.Kind, err)
+		}
+	} else {
+		return nil, fmt.Errorf("unable to decode the %s object of a kind unknown to the scheme", contentType(u))
+	}
+
+	object := &Object{Unstructured: &unstructured.Unstructured{Object: fields}}
+	if typed != nil {
+		object.Typed = typed
+		object.Unstructured.SetGroupVersionKind(*gvk)
+	}
+	return object, nil
+}
+
+func contentType(u *runtime.Unknown) string {
+	if len(u.ContentType) == 0 {
+		return runtime.ContentTypeJSON
+	}
+	return u.ContentType
+}
+
+// Request decodes the request object of the event.
+func Request(e *auditv1.Event) (*Object, error) {
+	return Decode(e.RequestObject)
+}
+
+// Response decodes the response object of the event.
+func Response(e *auditv1.Event) (*Object, error) {
+	return Decode(e.ResponseObject)
+}
+
+// Target returns the object the request read or wrote: the response object when it is one, otherwise the request
+// object of creates and updates. The bodies of patches, Status responses, DeleteOptions and lists aren't objects of
+// the resource and are skipped, ErrNotRecorded is returned when no object is left.
+func Target(e *auditv1.Event) (*Object, error) {
+	candidates := []*runtime.Unknown{e.ResponseObject}
+	if e.Verb != "patch" {
+		candidates = append(candidates, e.RequestObject)
+	}
+	var decodeErr error
+	for _, u := range candidates {
+		object, err := Decode(u)
+		if err == ErrNotRecorded {
+			continue
+		}
+		if err != nil {
+			decodeErr = err
+			continue
+		}
+		if kind := object.Kind(); kind == "Status" || kind == "DeleteOptions" || strings.HasSuffix(kind, "List") {
+			continue
+		}
+		return object, nil
+	}
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+	return nil, ErrNotRecorded
+}
@@ -0,0 +1,565 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xitongsys/parquet-go/common"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+
+	"github.com/natamm4/audit-tool/pkg/audit/provenance"
+)
+
+// testEvents returns n events, every third one without an objectRef or a response status, with a padding of the
+// given size in their request objects.
+func testEvents(n, padding int) []*auditv1.Event {
+	received := time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC)
+	object := []byte(fmt.Sprintf(`{"kind":"ConfigMap","apiVersion":"v1","data":{"padding":%q}}`, strings.Repeat("x", padding)))
+	events := []*auditv1.Event{}
+	for i := 0; i < n; i++ {
+		e := &auditv1.Event{
+			TypeMeta:                 metav1.TypeMeta{Kind: "Event", APIVersion: "audit.k8s.io/v1"},
+			Level:                    auditv1.LevelRequestResponse,
+			AuditID:                  types.UID(fmt.Sprintf("id-%d", i)),
+			Stage:                    auditv1.StageResponseComplete,
+			RequestURI:               fmt.Sprintf("/api/v1/namespaces/ns-%d/configmaps/cm-%d", i%7, i),
+			Verb:                     "update",
+			UserAgent:                "kubectl/v1.21.0",
+			RequestReceivedTimestamp: metav1.NewMicroTime(received.Add(time.Duration(i) * time.Millisecond)),
+			StageTimestamp:           metav1.NewMicroTime(received.Add(time.Duration(i)*time.Millisecond + 1500*time.Microsecond)),
+			RequestObject:            &runtime.Unknown{Raw: object, ContentType: runtime.ContentTypeJSON},
+		}
+		e.User.Username = fmt.Sprintf("user-%d", i%3)
+		if i%3 != 0 {
+			e.ObjectRef = &auditv1.ObjectReference{Resource: "configmaps", Namespace: fmt.Sprintf("ns-%d", i%7), Name: fmt.Sprintf("cm-%d", i), Subresource: "status"}
+			e.ResponseStatus = &metav1.Status{Code: int32(200 + i%2*209)}
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func writeEvents(t *testing.T, events []*auditv1.Event) []byte {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	for _, e := range events {
+		if err := w.WriteEvent(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func readEvents(t *testing.T, file []byte) []string {
+	r, err := NewEventReader(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) == 0 {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		events    int
+		padding   int
+		rowGroups int
+	}{
+		{name: "no events", events: 0, rowGroups: 0},
+		{name: "single event", events: 1, rowGroups: 1},
+		{name: "single page", events: 500, padding: 100, rowGroups: 1},
+		// the event column spans several data pages of pageSize
+		{name: "several pages", events: 2000, padding: 2000, rowGroups: 1},
+		// the buffered rows exceed rowGroupSize twice
+		{name: "several row groups", events: 1400, padding: 100 << 10, rowGroups: 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if testing.Short() && test.rowGroups > 1 {
+				t.Skip("writes more than 128MiB of events")
+			}
+			events := testEvents(test.events, test.padding)
+			file := writeEvents(t, events)
+
+			metadata, err := readFileMetaData(bytes.NewReader(file), int64(len(file)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(metadata.rowGroups) != test.rowGroups {
+				t.Errorf("expected %d row groups, got %d", test.rowGroups, len(metadata.rowGroups))
+			}
+
+			lines := readEvents(t, file)
+			if len(lines) != len(events) {
+				t.Fatalf("expected %d events, got %d", len(events), len(lines))
+			}
+			for i, line := range lines {
+				decoded := &auditv1.Event{}
+				if err := json.Unmarshal([]byte(line), decoded); err != nil {
+					t.Fatalf("event %d: %v", i, err)
+				}
+				expected := &auditv1.Event{}
+				data, _ := json.Marshal(events[i])
+				if err := json.Unmarshal(data, expected); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(decoded, expected) {
+					t.Fatalf("event %d: expected\n%s\ngot\n%s", i, data, line)
+				}
+			}
+		})
+	}
+}
+
+// columnValues decodes all values of a column of a file of the writer, with PLAIN encoded gzip compressed v1 data
+// pages only.
+func columnValues(t *testing.T, file []byte, name string) []interface{} {
+	metadata, err := readFileMetaData(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := []interface{}{}
+	for _, chunks := range metadata.rowGroups {
+		var chunk *columnChunkMetaData
+		for i := range chunks {
+			if reflect.DeepEqual(chunks[i].path, []string{name}) {
+				chunk = &chunks[i]
+			}
+		}
+		if chunk == nil {
+			t.Fatalf("no column chunk of %s", name)
+		}
+		if chunk.codec != codecGzip {
+			t.Fatalf("column %s: expected gzip compression, got %d", name, chunk.codec)
+		}
+		pages := bytes.NewReader(file[chunk.dataPageOffset : chunk.dataPageOffset+chunk.compressedBytes])
+		decoded := int64(0)
+		for decoded < chunk.values {
+			header, err := readPageHeader(newThriftReader(pages))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.typ != pageTypeData || header.encoding != encodingPlain {
+				t.Fatalf("column %s: expected PLAIN data pages, got page type %d encoding %d", name, header.typ, header.encoding)
+			}
+			compressed := make([]byte, header.compressedSize)
+			if _, err := io.ReadFull(pages, compressed); err != nil {
+				t.Fatal(err)
+			}
+			gz, err := gzip.NewReader(bytes.NewReader(compressed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			page, err := ioutil.ReadAll(gz)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page) != int(header.uncompressedSize) {
+				t.Errorf("column %s: expected %d uncompressed bytes, got %d", name, header.uncompressedSize, len(page))
+			}
+			for i := 0; i < int(header.values); i++ {
+				switch chunk.typ {
+				case typeInt32:
+					values = append(values, int32(binary.LittleEndian.Uint32(page)))
+					page = page[4:]
+				case typeInt64:
+					values = append(values, int64(binary.LittleEndian.Uint64(page)))
+					page = page[8:]
+				case typeByteArray:
+					length := binary.LittleEndian.Uint32(page)
+					values = append(values, string(page[4:4+length]))
+					page = page[4+length:]
+				}
+			}
+			if len(page) != 0 {
+				t.Errorf("column %s: %d bytes left after the values of the page", name, len(page))
+			}
+			decoded += int64(header.values)
+		}
+		if pages.Len() != 0 {
+			t.Errorf("column %s: %d bytes left after the pages of the chunk", name, pages.Len())
+		}
+	}
+	return values
+}
+
+// expectedColumns returns the values of every column for the events, as decoded by columnValues.
+func expectedColumns(events []*auditv1.Event) map[string][]interface{} {
+	expected := map[string][]interface{}{}
+	for _, e := range events {
+		row := map[string]interface{}{
+			"requestReceivedTimestamp": e.RequestReceivedTimestamp.UnixNano() / 1000,
+			"stageTimestamp":           e.StageTimestamp.UnixNano() / 1000,
+			"auditID":                  string(e.AuditID),
+			"cluster":                  provenance.Cluster(e),
+			"stage":                    string(e.Stage),
+			"verb":                     e.Verb,
+			"code":                     int32(0),
+			"user":                     e.User.Username,
+			"userAgent":                e.UserAgent,
+			"namespace":                "",
+			"apiGroup":                 "",
+			"resource":                 "",
+			"subresource":              "",
+			"name":                     "",
+			"requestURI":               e.RequestURI,
+		}
+		if e.ResponseStatus != nil {
+			row["code"] = e.ResponseStatus.Code
+		}
+		if e.ObjectRef != nil {
+			row["namespace"] = e.ObjectRef.Namespace
+			row["resource"] = e.ObjectRef.Resource
+			row["subresource"] = e.ObjectRef.Subresource
+			row["name"] = e.ObjectRef.Name
+		}
+		data, _ := json.Marshal(e)
+		row[EventColumn] = string(data)
+		for name, value := range row {
+			expected[name] = append(expected[name], value)
+		}
+	}
+	return expected
+}
+
+func TestWriterColumns(t *testing.T) {
+	events := testEvents(10, 10)
+	provenance.Set(events[1], provenance.Provenance{Cluster: "prod", Node: "master-0"})
+	defer provenance.Forget(events[1])
+	file := writeEvents(t, events)
+
+	metadata, err := readFileMetaData(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, element := range metadata.schema[1:] {
+		names = append(names, element.name)
+		if element.repetition != repetitionRequired {
+			t.Errorf("column %s: expected a required column, got repetition %d", element.name, element.repetition)
+		}
+	}
+	if expected := "requestReceivedTimestamp,stageTimestamp,auditID,cluster,stage,verb,code,user,userAgent,namespace,apiGroup,resource,subresource,name,requestURI,event"; strings.Join(names, ",") != expected {
+		t.Errorf("expected the columns %s, got %s", expected, strings.Join(names, ","))
+	}
+
+	expected := expectedColumns(events)
+	for _, name := range names {
+		if got := columnValues(t, file, name); !reflect.DeepEqual(got, expected[name]) {
+			t.Errorf("column %s: expected %v, got %v", name, expected[name], got)
+		}
+	}
+}
+
+// bufferFile is a read-only source.ParquetFile of parquet-go, every column is read through a file opened again.
+type bufferFile struct {
+	*bytes.Reader
+	data []byte
+}
+
+func newBufferFile(data []byte) source.ParquetFile {
+	return bufferFile{Reader: bytes.NewReader(data), data: data}
+}
+
+func (f bufferFile) Open(string) (source.ParquetFile, error) {
+	return newBufferFile(f.data), nil
+}
+
+func (f bufferFile) Create(string) (source.ParquetFile, error) {
+	return nil, fmt.Errorf("read-only file")
+}
+
+func (f bufferFile) Write([]byte) (int, error) {
+	return 0, fmt.Errorf("read-only file")
+}
+
+func (f bufferFile) Close() error {
+	return nil
+}
+
+// TestParquetGoReader reads the files of the writer with parquet-go, an implementation of the format independent of
+// readFileMetaData and columnValues.
+func TestParquetGoReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		events  int
+		padding int
+	}{
+		{name: "single event", events: 1},
+		{name: "single page", events: 500, padding: 100},
+		{name: "several pages", events: 2000, padding: 2000},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			events := testEvents(test.events, test.padding)
+			provenance.Set(events[0], provenance.Provenance{Cluster: "prod", Node: "master-0"})
+			defer provenance.Forget(events[0])
+			file := writeEvents(t, events)
+
+			r, err := reader.NewParquetColumnReader(newBufferFile(file), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.ReadStop()
+			if rows := r.GetNumRows(); rows != int64(len(events)) {
+				t.Errorf("expected %d rows, got %d", len(events), rows)
+			}
+			root := r.SchemaHandler.GetRootExName()
+			for name, expected := range expectedColumns(events) {
+				values, _, _, err := r.ReadColumnByPath(root+common.PAR_GO_PATH_DELIMITER+name, int64(len(events)))
+				if err != nil {
+					t.Fatalf("column %s: %v", name, err)
+				}
+				if !reflect.DeepEqual(values, expected) {
+					t.Errorf("column %s: expected %d values %.200v, got %d values %.200v", name, len(expected), expected, len(values), values)
+				}
+			}
+		})
+	}
+}
+
+// handWrittenFile returns a Parquet file of a single event column and row group, with the pages of its chunk.
+func handWrittenFile(repetition, codec int32, rows int64, dictionaryPage []byte, dataPages ...[]byte) []byte {
+	file := append([]byte{}, magic...)
+	dictionaryOffset := int64(0)
+	if dictionaryPage != nil {
+		dictionaryOffset = int64(len(file))
+		file = append(file, dictionaryPage...)
+	}
+	dataOffset := int64(len(file))
+	for _, page := range dataPages {
+		file = append(file, page...)
+	}
+	size := int64(len(file)) - 4
+
+	t := newThriftWriter()
+	t.structBegin()
+	t.i32Field(1, fileMetaDataVersion)
+	t.listField(2, thriftStruct, 2)
+	t.structBegin()
+	t.binaryField(4, []byte("schema"))
+	t.i32Field(5, 1)
+	t.structEnd()
+	t.structBegin()
+	t.i32Field(1, typeByteArray)
+	t.i32Field(3, repetition)
+	t.binaryField(4, []byte(EventColumn))
+	t.structEnd()
+	t.i64Field(3, rows)
+	t.listField(4, thriftStruct, 1)
+	t.structBegin()
+	t.listField(1, thriftStruct, 1)
+	t.structBegin()
+	t.i64Field(2, 4)
+	t.structField(3)
+	t.i32Field(1, typeByteArray)
+	t.listField(2, thriftI32, 1)
+	t.i32(encodingPlain)
+	t.listField(3, thriftBinary, 1)
+	t.binary([]byte(EventColumn))
+	t.i32Field(4, codec)
+	t.i64Field(5, rows)
+	t.i64Field(6, size)
+	t.i64Field(7, size)
+	t.i64Field(9, dataOffset)
+	if dictionaryPage != nil {
+		t.i64Field(11, dictionaryOffset)
+	}
+	t.structEnd()
+	t.structEnd()
+	t.i64Field(2, size)
+	t.i64Field(3, rows)
+	t.structEnd()
+	t.structEnd()
+
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(t.buf)))
+	file = append(file, t.buf...)
+	file = append(file, length[:]...)
+	return append(file, magic...)
+}
+
+// page returns a page of the type with the header struct of the field written by header.
+func page(typ int32, data []byte, uncompressedSize int, headerField int16, header func(t *thriftWriter)) []byte {
+	t := newThriftWriter()
+	t.structBegin()
+	t.i32Field(1, typ)
+	t.i32Field(2, int32(uncompressedSize))
+	t.i32Field(3, int32(len(data)))
+	t.structField(headerField)
+	header(t)
+	t.structEnd()
+	t.structEnd()
+	return append(t.buf, data...)
+}
+
+func plain(values ...string) []byte {
+	buf := &bytes.Buffer{}
+	for _, value := range values {
+		writeByteArray(buf, []byte(value))
+	}
+	return buf.Bytes()
+}
+
+// TestEventReaderOtherWriters reads files as other tools write them when rewriting the files of export parquet.
+func TestEventReaderOtherWriters(t *testing.T) {
+	first := `{"kind":"Event","auditID":"a1","verb":"get"}`
+	second := `{"kind":"Event","auditID":"a2","verb":"list"}`
+	firstLength := len(plain(first))
+
+	// a snappy literal of the first value followed by a copy of it
+	snappy := []byte{byte(2 * firstLength)}
+	snappy = append(snappy, byte(firstLength-1)<<2)
+	snappy = append(snappy, plain(first)...)
+	snappy = append(snappy, byte(firstLength-1)<<2|0x02, byte(firstLength), 0)
+
+	dictionary := page(pageTypeDictionary, plain(first, second), len(plain(first, second)), 7, func(t *thriftWriter) {
+		t.i32Field(1, 2)
+		t.i32Field(2, encodingPlain)
+	})
+
+	tests := []struct {
+		name     string
+		file     []byte
+		expected []string
+	}{
+		{
+			name: "uncompressed",
+			file: handWrittenFile(repetitionRequired, codecUncompressed, 2, nil, page(pageTypeData, plain(first, second), len(plain(first, second)), 5, func(t *thriftWriter) {
+				t.i32Field(1, 2)
+				t.i32Field(2, encodingPlain)
+			})),
+			expected: []string{first, second},
+		},
+		{
+			name: "snappy",
+			file: handWrittenFile(repetitionRequired, codecSnappy, 2, nil, page(pageTypeData, snappy, 2*firstLength, 5, func(t *thriftWriter) {
+				t.i32Field(1, 2)
+				t.i32Field(2, encodingPlain)
+			})),
+			expected: []string{first, first},
+		},
+		{
+			name: "dictionary",
+			// bit width 1, a bit-packed group of the indexes 1, 0, 1
+			file: handWrittenFile(repetitionRequired, codecUncompressed, 3, dictionary, page(pageTypeData, []byte{1, 3, 0x05}, 3, 5, func(t *thriftWriter) {
+				t.i32Field(1, 3)
+				t.i32Field(2, encodingRLEDictionary)
+			})),
+			expected: []string{second, first, second},
+		},
+		{
+			name: "optional v1",
+			// 1 byte of definition levels 1, 0, 1 prefixed by their length, then the two defined values
+			file: handWrittenFile(repetitionOptional, codecUncompressed, 3, nil, page(pageTypeData, append([]byte{2, 0, 0, 0, 3, 0x05}, plain(first, second)...), 6+len(plain(first, second)), 5, func(t *thriftWriter) {
+				t.i32Field(1, 3)
+				t.i32Field(2, encodingPlain)
+			})),
+			expected: []string{first, second},
+		},
+		{
+			name: "optional v2 dictionary",
+			// uncompressed definition levels 1, 0, 1, 1 as a bit-packed group and the dictionary indexes 1, 0, 1
+			file: handWrittenFile(repetitionOptional, codecUncompressed, 4, dictionary, page(pageTypeDataV2, []byte{3, 0x0d, 1, 3, 0x05}, 5, 8, func(t *thriftWriter) {
+				t.i32Field(1, 4)
+				t.i32Field(2, 1)
+				t.i32Field(3, 4)
+				t.i32Field(4, encodingRLEDictionary)
+				t.i32Field(5, 2)
+				t.i32Field(6, 0)
+				t.boolField(7, false)
+			})),
+			expected: []string{second, first, second},
+		},
+		{
+			name: "RLE run of dictionary indexes",
+			// bit width 1, a run of 3 times the index 1
+			file: handWrittenFile(repetitionRequired, codecUncompressed, 3, dictionary, page(pageTypeData, []byte{1, 6, 1}, 3, 5, func(t *thriftWriter) {
+				t.i32Field(1, 3)
+				t.i32Field(2, encodingRLEDictionary)
+			})),
+			expected: []string{second, second, second},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := readEvents(t, test.file); !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestEventReaderInvalidFiles(t *testing.T) {
+	file := writeEvents(t, testEvents(20, 10))
+	corruptMetadata := append([]byte{}, file...)
+	binary.LittleEndian.PutUint32(corruptMetadata[len(corruptMetadata)-8:], uint32(len(file)))
+	otherColumn := bytes.Replace(handWrittenFile(repetitionRequired, codecUncompressed, 0, nil), []byte(EventColumn), []byte("other"), -1)
+
+	tests := []struct {
+		name string
+		file []byte
+		err  string
+	}{
+		{name: "empty", file: []byte{}, err: "it is too short"},
+		{name: "audit log", file: []byte(`{"kind":"Event","apiVersion":"audit.k8s.io/v1","auditID":"a1"}` + "\n"), err: "the footer is missing"},
+		{name: "truncated", file: file[:len(file)/2], err: "the footer is missing"},
+		{name: "metadata length", file: corruptMetadata, err: "invalid Parquet file metadata length"},
+		{name: "no event column", file: otherColumn, err: `no "event" column`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewEventReader(bytes.NewReader(test.file), int64(len(test.file)))
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected an error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+// TestEventReaderCorruptPages flips bytes of the pages of a file, reading it must fail or return events, but never
+// panic.
+func TestEventReaderCorruptPages(t *testing.T) {
+	file := writeEvents(t, testEvents(20, 10))
+	pagesEnd := len(file) - 8 - int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	for offset := len(magic); offset < pagesEnd; offset++ {
+		corrupt := append([]byte{}, file...)
+		corrupt[offset] ^= 0xff
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("flipping byte %d: panic: %v", offset, r)
+				}
+			}()
+			r, err := NewEventReader(bytes.NewReader(corrupt), int64(len(corrupt)))
+			if err != nil {
+				return
+			}
+			_, _ = ioutil.ReadAll(r)
+		}()
+	}
+}
@@ -0,0 +1,509 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// maxMetaDataSize limits the footer read into memory, corrupt lengths would otherwise allocate gigabytes.
+const maxMetaDataSize = 64 << 20
+
+type fileMetaData struct {
+	schema    []schemaElement
+	rowGroups [][]columnChunkMetaData
+}
+
+type schemaElement struct {
+	name        string
+	typ         int32
+	repetition  int32
+	numChildren int32
+}
+
+type columnChunkMetaData struct {
+	path                 []string
+	typ                  int32
+	codec                int32
+	values               int64
+	compressedBytes      int64
+	dataPageOffset       int64
+	dictionaryPageOffset int64
+}
+
+type pageHeader struct {
+	typ              int32
+	uncompressedSize int32
+	compressedSize   int32
+
+	values   int32
+	encoding int32
+	// the levels of v2 data pages precede the values and are never compressed
+	levelsV2     int32
+	compressedV2 bool
+}
+
+// EventReader returns the raw events of a Parquet file as audit log lines, one JSON event per line, so that the file
+// is read like any other audit log. Row groups are read one at a time, the file is never read into memory as a whole.
+type EventReader struct {
+	r        io.ReaderAt
+	metadata *fileMetaData
+	// optional is set when the event column has definition levels, null events are skipped
+	optional bool
+
+	rowGroup int
+	events   [][]byte
+	buf      bytes.Buffer
+}
+
+// NewEventReader returns the reader of the events of the Parquet file of the given size.
+func NewEventReader(r io.ReaderAt, size int64) (*EventReader, error) {
+	metadata, err := readFileMetaData(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := &EventReader{r: r, metadata: metadata}
+	eventColumn := false
+	for i, children := 1, metadata.schema[0].numChildren; i < len(metadata.schema) && children > 0; i, children = i+1, children-1 {
+		element := metadata.schema[i]
+		if element.name != EventColumn {
+			// skip the nested columns of other top level fields
+			for nested := element.numChildren; nested > 0; nested-- {
+				i++
+				if i >= len(metadata.schema) {
+					break
+				}
+				nested += metadata.schema[i].numChildren
+			}
+			continue
+		}
+		if element.numChildren > 0 || element.typ != typeByteArray || element.repetition > repetitionOptional {
+			return nil, fmt.Errorf("the %q column of the Parquet file is not a string column", EventColumn)
+		}
+		reader.optional = element.repetition == repetitionOptional
+		eventColumn = true
+		break
+	}
+	if !eventColumn {
+		return nil, fmt.Errorf("the Parquet file has no %q column with the raw events, it wasn't written by export parquet", EventColumn)
+	}
+	return reader, nil
+}
+
+func (r *EventReader) Read(p []byte) (int, error) {
+	for r.buf.Len() == 0 {
+		if len(r.events) == 0 {
+			if r.rowGroup >= len(r.metadata.rowGroups) {
+				return 0, io.EOF
+			}
+			events, err := r.readRowGroup(r.metadata.rowGroups[r.rowGroup])
+			if err != nil {
+				return 0, fmt.Errorf("row group %d: %v", r.rowGroup, err)
+			}
+			r.events = events
+			r.rowGroup++
+			continue
+		}
+		if r.events[0] != nil {
+			r.buf.Write(r.events[0])
+			r.buf.WriteByte('\n')
+		}
+		r.events = r.events[1:]
+	}
+	return r.buf.Read(p)
+}
+
+func (r *EventReader) Close() error {
+	return nil
+}
+
+func readFileMetaData(r io.ReaderAt, size int64) (*fileMetaData, error) {
+	footer := make([]byte, 8)
+	if size < int64(len(magic)+len(footer)) {
+		return nil, fmt.Errorf("not a Parquet file, it is too short")
+	}
+	if _, err := r.ReadAt(footer, size-8); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(footer[4:], magic) {
+		return nil, fmt.Errorf("not a Parquet file, the footer is missing")
+	}
+	length := int64(binary.LittleEndian.Uint32(footer[:4]))
+	if length > maxMetaDataSize || length > size-8-int64(len(magic)) {
+		return nil, fmt.Errorf("invalid Parquet file metadata length %d", length)
+	}
+	data := make([]byte, length)
+	if _, err := r.ReadAt(data, size-8-length); err != nil {
+		return nil, err
+	}
+
+	metadata := &fileMetaData{}
+	t := newThriftReader(bytes.NewReader(data))
+	err := t.readStruct(func(id int16, typ byte) error {
+		switch {
+		case id == 2 && typ == thriftList:
+			return readList(t, func() error {
+				element := schemaElement{}
+				err := t.readStruct(func(id int16, typ byte) error {
+					var err error
+					switch {
+					case id == 1 && typ == thriftI32:
+						element.typ, err = t.i32()
+					case id == 3 && typ == thriftI32:
+						element.repetition, err = t.i32()
+					case id == 4 && typ == thriftBinary:
+						var name []byte
+						name, err = t.binary()
+						element.name = string(name)
+					case id == 5 && typ == thriftI32:
+						element.numChildren, err = t.i32()
+					default:
+						err = t.skip(typ)
+					}
+					return err
+				})
+				metadata.schema = append(metadata.schema, element)
+				return err
+			})
+		case id == 4 && typ == thriftList:
+			return readList(t, func() error {
+				chunks, err := readRowGroup(t)
+				metadata.rowGroups = append(metadata.rowGroups, chunks)
+				return err
+			})
+		default:
+			return t.skip(typ)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid Parquet file metadata: %v", err)
+	}
+	if len(metadata.schema) == 0 {
+		return nil, fmt.Errorf("invalid Parquet file metadata: no schema")
+	}
+	return metadata, nil
+}
+
+// readList calls read for every element of the list that follows.
+func readList(t *thriftReader, read func() error) error {
+	_, n, err := t.list()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if err := read(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readRowGroup(t *thriftReader) ([]columnChunkMetaData, error) {
+	chunks := []columnChunkMetaData{}
+	err := t.readStruct(func(id int16, typ byte) error {
+		if id != 1 || typ != thriftList {
+			return t.skip(typ)
+		}
+		return readList(t, func() error {
+			chunk := columnChunkMetaData{}
+			err := t.readStruct(func(id int16, typ byte) error {
+				if id != 3 || typ != thriftStruct {
+					return t.skip(typ)
+				}
+				return t.readStruct(func(id int16, typ byte) error {
+					var err error
+					switch {
+					case id == 1 && typ == thriftI32:
+						chunk.typ, err = t.i32()
+					case id == 3 && typ == thriftList:
+						err = readList(t, func() error {
+							element, err := t.binary()
+							chunk.path = append(chunk.path, string(element))
+							return err
+						})
+					case id == 4 && typ == thriftI32:
+						chunk.codec, err = t.i32()
+					case id == 5 && typ == thriftI64:
+						chunk.values, err = t.i64()
+					case id == 7 && typ == thriftI64:
+						chunk.compressedBytes, err = t.i64()
+					case id == 9 && typ == thriftI64:
+						chunk.dataPageOffset, err = t.i64()
+					case id == 11 && typ == thriftI64:
+						chunk.dictionaryPageOffset, err = t.i64()
+					default:
+						err = t.skip(typ)
+					}
+					return err
+				})
+			})
+			chunks = append(chunks, chunk)
+			return err
+		})
+	})
+	return chunks, err
+}
+
+func readPageHeader(t *thriftReader) (*pageHeader, error) {
+	header := &pageHeader{compressedV2: true}
+	err := t.readStruct(func(id int16, typ byte) error {
+		var err error
+		switch {
+		case id == 1 && typ == thriftI32:
+			header.typ, err = t.i32()
+		case id == 2 && typ == thriftI32:
+			header.uncompressedSize, err = t.i32()
+		case id == 3 && typ == thriftI32:
+			header.compressedSize, err = t.i32()
+		// data page, dictionary page and v2 data page headers
+		case (id == 5 || id == 7 || id == 8) && typ == thriftStruct:
+			v2 := id == 8
+			err = t.readStruct(func(field int16, typ byte) error {
+				var err error
+				switch {
+				case field == 1 && typ == thriftI32:
+					header.values, err = t.i32()
+				case field == 2 && typ == thriftI32 && !v2, field == 4 && typ == thriftI32 && v2:
+					header.encoding, err = t.i32()
+				case (field == 5 || field == 6) && typ == thriftI32 && v2:
+					var length int32
+					length, err = t.i32()
+					header.levelsV2 += length
+				case field == 7 && v2 && (typ == thriftTrue || typ == thriftFalse):
+					header.compressedV2 = typ == thriftTrue
+				default:
+					err = t.skip(typ)
+				}
+				return err
+			})
+		default:
+			err = t.skip(typ)
+		}
+		return err
+	})
+	if err == nil && (header.compressedSize < 0 || header.uncompressedSize < 0 || header.levelsV2 < 0 || header.levelsV2 > header.compressedSize) {
+		err = fmt.Errorf("invalid page sizes")
+	}
+	return header, err
+}
+
+// readRowGroup returns the events of the row group, nil for null events.
+func (r *EventReader) readRowGroup(chunks []columnChunkMetaData) ([][]byte, error) {
+	var chunk *columnChunkMetaData
+	for i := range chunks {
+		if len(chunks[i].path) == 1 && chunks[i].path[0] == EventColumn {
+			chunk = &chunks[i]
+		}
+	}
+	if chunk == nil {
+		return nil, fmt.Errorf("no %q column chunk", EventColumn)
+	}
+	offset := chunk.dataPageOffset
+	if chunk.dictionaryPageOffset > 0 && chunk.dictionaryPageOffset < offset {
+		offset = chunk.dictionaryPageOffset
+	}
+	if chunk.compressedBytes < 0 || chunk.compressedBytes > 1<<31 {
+		return nil, fmt.Errorf("invalid column chunk size %d", chunk.compressedBytes)
+	}
+	data := make([]byte, chunk.compressedBytes)
+	if _, err := r.r.ReadAt(data, offset); err != nil {
+		return nil, err
+	}
+
+	events := make([][]byte, 0, chunk.values)
+	var dictionary [][]byte
+	pages := bytes.NewReader(data)
+	for int64(len(events)) < chunk.values {
+		header, err := readPageHeader(newThriftReader(pages))
+		if err != nil {
+			return nil, fmt.Errorf("invalid page header: %v", err)
+		}
+		page := make([]byte, header.compressedSize)
+		if _, err := io.ReadFull(pages, page); err != nil {
+			return nil, err
+		}
+		if header.typ != pageTypeData && header.typ != pageTypeDictionary && header.typ != pageTypeDataV2 {
+			continue
+		}
+
+		var levels []byte
+		if header.typ == pageTypeDataV2 {
+			levels, page = page[:header.levelsV2], page[header.levelsV2:]
+		}
+		if header.typ != pageTypeDataV2 || header.compressedV2 {
+			if page, err = decompressPage(chunk.codec, page, int(header.uncompressedSize)-len(levels)); err != nil {
+				return nil, err
+			}
+		}
+
+		if header.typ == pageTypeDictionary {
+			if dictionary, err = plainByteArrays(page, int(header.values)); err != nil {
+				return nil, fmt.Errorf("invalid dictionary page: %v", err)
+			}
+			continue
+		}
+
+		defined := int(header.values)
+		var definitions []uint32
+		if r.optional {
+			if header.typ == pageTypeData {
+				// v1 data pages prefix the levels with their length
+				if len(page) < 4 {
+					return nil, fmt.Errorf("invalid definition levels")
+				}
+				length := binary.LittleEndian.Uint32(page)
+				if int64(length) > int64(len(page)-4) {
+					return nil, fmt.Errorf("invalid definition levels")
+				}
+				levels, page = page[4:4+length], page[4+length:]
+			}
+			if definitions, err = decodeHybrid(levels, 1, int(header.values)); err != nil {
+				return nil, fmt.Errorf("invalid definition levels: %v", err)
+			}
+			defined = 0
+			for _, level := range definitions {
+				defined += int(level)
+			}
+		}
+
+		var values [][]byte
+		switch header.encoding {
+		case encodingPlain:
+			values, err = plainByteArrays(page, defined)
+		case encodingPlainDict, encodingRLEDictionary:
+			values, err = dictionaryValues(page, dictionary, defined)
+		default:
+			err = fmt.Errorf("unsupported encoding %d", header.encoding)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid data page: %v", err)
+		}
+
+		if definitions == nil {
+			events = append(events, values...)
+			continue
+		}
+		for _, level := range definitions {
+			if level == 0 {
+				events = append(events, nil)
+				continue
+			}
+			events = append(events, values[0])
+			values = values[1:]
+		}
+	}
+	return events, nil
+}
+
+func decompressPage(codec int32, page []byte, uncompressedSize int) ([]byte, error) {
+	switch codec {
+	case codecUncompressed:
+		return page, nil
+	case codecGzip:
+		r, err := gzip.NewReader(bytes.NewReader(page))
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, 0, uncompressedSize)
+		buf := bytes.NewBuffer(data)
+		_, err = io.Copy(buf, r)
+		return buf.Bytes(), err
+	case codecSnappy:
+		return snappyDecode(page)
+	}
+	return nil, fmt.Errorf("unsupported compression codec %d, only uncompressed, snappy and gzip pages can be read", codec)
+}
+
+// plainByteArrays decodes n PLAIN encoded byte arrays, each prefixed by its length.
+func plainByteArrays(data []byte, n int) ([][]byte, error) {
+	values := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		if len(data) < 4 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		length := binary.LittleEndian.Uint32(data)
+		if int64(length) > int64(len(data)-4) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		values = append(values, data[4:4+length])
+		data = data[4+length:]
+	}
+	return values, nil
+}
+
+// dictionaryValues decodes n dictionary indexes, prefixed by their bit width.
+func dictionaryValues(data []byte, dictionary [][]byte, n int) ([][]byte, error) {
+	if len(data) == 0 {
+		if n == 0 {
+			return nil, nil
+		}
+		return nil, io.ErrUnexpectedEOF
+	}
+	indexes, err := decodeHybrid(data[1:], int(data[0]), n)
+	if err != nil {
+		return nil, err
+	}
+	values := make([][]byte, n)
+	for i, index := range indexes {
+		if int(index) >= len(dictionary) {
+			return nil, fmt.Errorf("dictionary index %d out of range", index)
+		}
+		values[i] = dictionary[index]
+	}
+	return values, nil
+}
+
+// decodeHybrid decodes n values of the RLE / bit-packed hybrid encoding of the given bit width.
+func decodeHybrid(data []byte, bitWidth int, n int) ([]uint32, error) {
+	if bitWidth < 0 || bitWidth > 32 {
+		return nil, fmt.Errorf("invalid bit width %d", bitWidth)
+	}
+	values := make([]uint32, 0, n)
+	byteWidth := (bitWidth + 7) / 8
+	for len(values) < n {
+		header, length := binary.Uvarint(data)
+		if length <= 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		data = data[length:]
+		if header&1 == 0 {
+			// run of a repeated value
+			count := header >> 1
+			if len(data) < byteWidth {
+				return nil, io.ErrUnexpectedEOF
+			}
+			var value uint32
+			for i := 0; i < byteWidth; i++ {
+				value |= uint32(data[i]) << (8 * i)
+			}
+			data = data[byteWidth:]
+			for ; count > 0 && len(values) < n; count-- {
+				values = append(values, value)
+			}
+			continue
+		}
+		// groups of 8 bit-packed values, least significant bit first
+		groups := header >> 1
+		if groups > uint64(len(data)) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		size := int(groups) * bitWidth
+		if len(data) < size {
+			return nil, io.ErrUnexpectedEOF
+		}
+		for bit := 0; bit+bitWidth <= size*8 && len(values) < n; bit += bitWidth {
+			var value uint32
+			for i := 0; i < bitWidth; i++ {
+				if data[(bit+i)/8]&(1<<uint((bit+i)%8)) != 0 {
+					value |= 1 << uint(i)
+				}
+			}
+			values = append(values, value)
+		}
+		data = data[size:]
+	}
+	return values, nil
+}
@@ -0,0 +1,80 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// snappyDecode decodes a snappy block, the default compression of most Parquet writers.
+func snappyDecode(src []byte) ([]byte, error) {
+	size, n := binary.Uvarint(src)
+	if n <= 0 || size > 1<<31 {
+		return nil, fmt.Errorf("invalid snappy block length")
+	}
+	src = src[n:]
+	dst := make([]byte, 0, size)
+	for len(src) > 0 {
+		tag := src[0]
+		if tag&0x03 == 0x00 {
+			// literal
+			literal := int(tag >> 2)
+			src = src[1:]
+			if literal >= 60 {
+				width := literal - 59
+				if len(src) < width {
+					return nil, fmt.Errorf("corrupt snappy literal")
+				}
+				literal = 0
+				for i := 0; i < width; i++ {
+					literal |= int(src[i]) << (8 * i)
+				}
+				src = src[width:]
+			}
+			literal++
+			if literal <= 0 || len(src) < literal {
+				return nil, fmt.Errorf("corrupt snappy literal")
+			}
+			dst = append(dst, src[:literal]...)
+			src = src[literal:]
+			continue
+		}
+
+		// copy of previous output
+		var length, offset int
+		switch tag & 0x03 {
+		case 0x01:
+			if len(src) < 2 {
+				return nil, fmt.Errorf("corrupt snappy copy")
+			}
+			length = 4 + int(tag>>2)&0x07
+			offset = int(tag&0xe0)<<3 | int(src[1])
+			src = src[2:]
+		case 0x02:
+			if len(src) < 3 {
+				return nil, fmt.Errorf("corrupt snappy copy")
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[1:]))
+			src = src[3:]
+		case 0x03:
+			if len(src) < 5 {
+				return nil, fmt.Errorf("corrupt snappy copy")
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[1:]))
+			src = src[5:]
+		}
+		if offset <= 0 || offset > len(dst) || uint64(len(dst)+length) > size {
+			return nil, fmt.Errorf("corrupt snappy copy")
+		}
+		// the copy may overlap the bytes it appends
+		start := len(dst) - offset
+		for i := 0; i < length; i++ {
+			dst = append(dst, dst[start+i])
+		}
+	}
+	if uint64(len(dst)) != size {
+		return nil, fmt.Errorf("corrupt snappy block, decoded %d of %d bytes", len(dst), size)
+	}
+	return dst, nil
+}
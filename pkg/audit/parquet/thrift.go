@@ -0,0 +1,305 @@
+package parquet
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// The Parquet metadata is serialized with the Thrift compact protocol, these are its type codes.
+const (
+	thriftStop      = 0
+	thriftTrue      = 1
+	thriftFalse     = 2
+	thriftByte      = 3
+	thriftI16       = 4
+	thriftI32       = 5
+	thriftI64       = 6
+	thriftDouble    = 7
+	thriftBinary    = 8
+	thriftList      = 9
+	thriftSet       = 10
+	thriftMap       = 11
+	thriftStruct    = 12
+	maxThriftNested = 64
+)
+
+// thriftWriter writes Thrift compact protocol structs. Fields must be written in ascending id order within a struct.
+type thriftWriter struct {
+	buf []byte
+	// lastField holds the id of the last field written of every open struct
+	lastField []int16
+}
+
+func newThriftWriter() *thriftWriter {
+	return &thriftWriter{lastField: []int16{0}}
+}
+
+func (w *thriftWriter) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf = append(w.buf, b[:binary.PutUvarint(b[:], v)]...)
+}
+
+func (w *thriftWriter) field(id int16, typ byte) {
+	last := &w.lastField[len(w.lastField)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		w.buf = append(w.buf, byte(delta)<<4|typ)
+	} else {
+		w.buf = append(w.buf, typ)
+		w.varint(zigzag(int64(id)))
+	}
+	*last = id
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func (w *thriftWriter) i32Field(id int16, v int32) {
+	w.field(id, thriftI32)
+	w.varint(zigzag(int64(v)))
+}
+
+func (w *thriftWriter) i64Field(id int16, v int64) {
+	w.field(id, thriftI64)
+	w.varint(zigzag(v))
+}
+
+func (w *thriftWriter) boolField(id int16, v bool) {
+	if v {
+		w.field(id, thriftTrue)
+	} else {
+		w.field(id, thriftFalse)
+	}
+}
+
+func (w *thriftWriter) binaryField(id int16, v []byte) {
+	w.field(id, thriftBinary)
+	w.binary(v)
+}
+
+func (w *thriftWriter) binary(v []byte) {
+	w.varint(uint64(len(v)))
+	w.buf = append(w.buf, v...)
+}
+
+func (w *thriftWriter) i32(v int32) {
+	w.varint(zigzag(int64(v)))
+}
+
+// listField starts a list of n elements of the type, the elements are written right after.
+func (w *thriftWriter) listField(id int16, elemType byte, n int) {
+	w.field(id, thriftList)
+	if n < 15 {
+		w.buf = append(w.buf, byte(n)<<4|elemType)
+		return
+	}
+	w.buf = append(w.buf, 0xf0|elemType)
+	w.varint(uint64(n))
+}
+
+// structField starts a struct field, its fields are written until structEnd.
+func (w *thriftWriter) structField(id int16) {
+	w.field(id, thriftStruct)
+	w.structBegin()
+}
+
+// structBegin starts a struct that is a list element or the top level struct.
+func (w *thriftWriter) structBegin() {
+	w.lastField = append(w.lastField, 0)
+}
+
+func (w *thriftWriter) structEnd() {
+	w.buf = append(w.buf, thriftStop)
+	w.lastField = w.lastField[:len(w.lastField)-1]
+}
+
+// thriftReader reads Thrift compact protocol structs.
+type thriftReader struct {
+	r         io.ByteReader
+	lastField []int16
+}
+
+func newThriftReader(r io.Reader) *thriftReader {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &thriftReader{r: br, lastField: []int16{0}}
+}
+
+func (r *thriftReader) varint() (uint64, error) {
+	return binary.ReadUvarint(r.r)
+}
+
+func (r *thriftReader) zigzag() (int64, error) {
+	v, err := r.varint()
+	return int64(v>>1) ^ -int64(v&1), err
+}
+
+// field returns the id and type of the next field of the struct, thriftStop after the last one.
+func (r *thriftReader) field() (int16, byte, error) {
+	b, err := r.r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	typ := b & 0x0f
+	if typ == thriftStop {
+		return 0, thriftStop, nil
+	}
+	last := &r.lastField[len(r.lastField)-1]
+	if delta := int16(b >> 4); delta != 0 {
+		*last += delta
+	} else {
+		id, err := r.zigzag()
+		if err != nil {
+			return 0, 0, err
+		}
+		*last = int16(id)
+	}
+	return *last, typ, nil
+}
+
+func (r *thriftReader) structBegin() error {
+	if len(r.lastField) > maxThriftNested {
+		return fmt.Errorf("thrift structs nested too deep")
+	}
+	r.lastField = append(r.lastField, 0)
+	return nil
+}
+
+func (r *thriftReader) structEnd() {
+	r.lastField = r.lastField[:len(r.lastField)-1]
+}
+
+// readStruct reads the fields of a struct, read is called for every field and must consume its value.
+func (r *thriftReader) readStruct(read func(id int16, typ byte) error) error {
+	if err := r.structBegin(); err != nil {
+		return err
+	}
+	defer r.structEnd()
+	for {
+		id, typ, err := r.field()
+		if err != nil {
+			return err
+		}
+		if typ == thriftStop {
+			return nil
+		}
+		if err := read(id, typ); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *thriftReader) i32() (int32, error) {
+	v, err := r.zigzag()
+	return int32(v), err
+}
+
+func (r *thriftReader) i64() (int64, error) {
+	return r.zigzag()
+}
+
+func (r *thriftReader) binary() ([]byte, error) {
+	n, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	if n > 1<<30 {
+		return nil, fmt.Errorf("thrift binary of %d bytes is too large", n)
+	}
+	b := make([]byte, n)
+	for i := range b {
+		if b[i], err = r.r.ReadByte(); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// list returns the element type and size of the list that follows.
+func (r *thriftReader) list() (byte, int, error) {
+	b, err := r.r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	n := int(b >> 4)
+	if n == 15 {
+		size, err := r.varint()
+		if err != nil {
+			return 0, 0, err
+		}
+		if size > 1<<24 {
+			return 0, 0, fmt.Errorf("thrift list of %d elements is too large", size)
+		}
+		n = int(size)
+	}
+	return b & 0x0f, n, nil
+}
+
+// skip consumes a value of the type.
+func (r *thriftReader) skip(typ byte) error {
+	switch typ {
+	case thriftTrue, thriftFalse:
+		return nil
+	case thriftByte:
+		_, err := r.r.ReadByte()
+		return err
+	case thriftI16, thriftI32, thriftI64:
+		_, err := r.varint()
+		return err
+	case thriftDouble:
+		for i := 0; i < 8; i++ {
+			if _, err := r.r.ReadByte(); err != nil {
+				return err
+			}
+		}
+		return nil
+	case thriftBinary:
+		_, err := r.binary()
+		return err
+	case thriftList, thriftSet:
+		elemType, n, err := r.list()
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			// booleans in lists are a byte each
+			if elemType == thriftTrue || elemType == thriftFalse {
+				elemType = thriftByte
+			}
+			if err := r.skip(elemType); err != nil {
+				return err
+			}
+		}
+		return nil
+	case thriftMap:
+		n, err := r.varint()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		types, err := r.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			if err := r.skip(types >> 4); err != nil {
+				return err
+			}
+			if err := r.skip(types & 0x0f); err != nil {
+				return err
+			}
+		}
+		return nil
+	case thriftStruct:
+		return r.readStruct(func(id int16, typ byte) error {
+			return r.skip(typ)
+		})
+	}
+	return fmt.Errorf("unknown thrift type %d", typ)
+}
@@ -0,0 +1,383 @@
+// Package parquet writes audit events as Parquet files and reads them back. The files have a flat schema of the key
+// fields of the events, so they can be scanned by columnar engines (DuckDB, Spark, pandas) without parsing JSON, and
+// the raw event as JSON in the EventColumn, which is what audit-tool reads back.
+//
+// Only the subset of the format needed for that is implemented: the writer uses PLAIN encoding and gzip compressed
+// v1 data pages, the reader additionally supports snappy compression, dictionary encoding, v2 data pages and an
+// optional event column as written by other tools when rewriting the files.
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+
+	"github.com/natamm4/audit-tool/pkg/audit/provenance"
+)
+
+const (
+	// Extension is the file extension of Parquet files.
+	Extension = ".parquet"
+
+	// EventColumn is the column holding the raw event as JSON.
+	EventColumn = "event"
+
+	createdBy = "audit-tool"
+
+	// pageSize is the uncompressed size of the values of a data page, rowGroupSize the uncompressed size of the
+	// values of a row group, which is buffered in memory until it is complete.
+	pageSize     = 1 << 20
+	rowGroupSize = 64 << 20
+)
+
+var magic = []byte("PAR1")
+
+// Physical types, encodings, codecs and annotations of the Parquet format.
+const (
+	typeInt32     = 1
+	typeInt64     = 2
+	typeByteArray = 6
+
+	repetitionRequired = 0
+	repetitionOptional = 1
+
+	convertedUTF8            = 0
+	convertedTimestampMicros = 10
+
+	encodingPlain         = 0
+	encodingPlainDict     = 2
+	encodingRLE           = 3
+	encodingRLEDictionary = 8
+	codecUncompressed     = 0
+	codecSnappy           = 1
+	codecGzip             = 2
+	pageTypeData          = 0
+	pageTypeDictionary    = 2
+	pageTypeDataV2        = 3
+	noConvertedType       = -1
+	fileMetaDataVersion   = 1
+)
+
+// column is a top level column of the schema, its values are taken from the event by exactly one of the functions.
+type column struct {
+	name      string
+	typ       int32
+	converted int32
+
+	int32Value  func(e *auditv1.Event) int32
+	int64Value  func(e *auditv1.Event) int64
+	stringValue func(e *auditv1.Event) string
+}
+
+func objectRef(e *auditv1.Event) *auditv1.ObjectReference {
+	if e.ObjectRef == nil {
+		return &auditv1.ObjectReference{}
+	}
+	return e.ObjectRef
+}
+
+// columns are the columns of the files, in order. The code is 0 for events without a response status.
+var columns = []column{
+	{name: "requestReceivedTimestamp", typ: typeInt64, converted: convertedTimestampMicros, int64Value: func(e *auditv1.Event) int64 {
+		return e.RequestReceivedTimestamp.UnixNano() / 1000
+	}},
+	{name: "stageTimestamp", typ: typeInt64, converted: convertedTimestampMicros, int64Value: func(e *auditv1.Event) int64 {
+		return e.StageTimestamp.UnixNano() / 1000
+	}},
+	{name: "auditID", typ: typeByteArray, converted: convertedUTF8, stringValue: func(e *auditv1.Event) string { return string(e.AuditID) }},
+	{name: "cluster", typ: typeByteArray, converted: convertedUTF8, stringValue: provenance.Cluster},
+	{name: "stage", typ: typeByteArray, converted: convertedUTF8, stringValue: func(e *auditv1.Event) string { return string(e.Stage) }},
+	{name: "verb", typ: typeByteArray, converted: convertedUTF8, stringValue: func(e *auditv1.Event) string { return e.Verb }},
+	{name: "code", typ: typeInt32, converted: noConvertedType, int32Value: func(e *auditv1.Event) int32 {
+		if e.ResponseStatus == nil {
+			return 0
+		}
+		return e.ResponseStatus.Code
+	}},
+	{name: "user", typ: typeByteArray, converted: convertedUTF8, stringValue: func(e *auditv1.Event) string { return e.User.Username }},
+	{name: "userAgent", typ: typeByteArray, converted: convertedUTF8, stringValue: func(e *auditv1.Event) string { return e.UserAgent }},
+	{name: "namespace", typ: typeByteArray, converted: convertedUTF8, stringValue: func(e *auditv1.Event) string { return objectRef(e).Namespace }},
+	{name: "apiGroup", typ: typeByteArray, converted: convertedUTF8, stringValue: func(e *auditv1.Event) string { return objectRef(e).APIGroup }},
+	{name: "resource", typ: typeByteArray, converted: convertedUTF8, stringValue: func(e *auditv1.Event) string { return objectRef(e).Resource }},
+	{name: "subresource", typ: typeByteArray, converted: convertedUTF8, stringValue: func(e *auditv1.Event) string { return objectRef(e).Subresource }},
+	{name: "name", typ: typeByteArray, converted: convertedUTF8, stringValue: func(e *auditv1.Event) string { return objectRef(e).Name }},
+	{name: "requestURI", typ: typeByteArray, converted: convertedUTF8, stringValue: func(e *auditv1.Event) string { return e.RequestURI }},
+	{name: EventColumn, typ: typeByteArray, converted: convertedUTF8},
+}
+
+// columnChunk buffers the pages of a column until the row group is written.
+type columnChunk struct {
+	page       bytes.Buffer
+	pageValues int
+
+	pages             bytes.Buffer
+	values            int64
+	uncompressedBytes int64
+
+	// min and max of the int64 columns, written as statistics so that engines can skip row groups by time
+	min, max int64
+}
+
+type rowGroup struct {
+	rows    int64
+	offset  int64
+	columns []columnMetaData
+}
+
+type columnMetaData struct {
+	column            *column
+	values            int64
+	offset            int64
+	uncompressedBytes int64
+	compressedBytes   int64
+	min, max          int64
+}
+
+// Writer writes events to a Parquet file. Close must be called to write the file footer, the file is invalid without.
+type Writer struct {
+	out    io.Writer
+	offset int64
+	err    error
+
+	chunks    []*columnChunk
+	rows      int64
+	groupSize int
+	rowGroups []rowGroup
+
+	compressed bytes.Buffer
+	gzip       *gzip.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	writer := &Writer{out: w}
+	writer.gzip = gzip.NewWriter(&writer.compressed)
+	writer.resetChunks()
+	writer.write(magic)
+	return writer
+}
+
+func (w *Writer) resetChunks() {
+	w.chunks = make([]*columnChunk, len(columns))
+	for i := range w.chunks {
+		w.chunks[i] = &columnChunk{}
+	}
+	w.rows = 0
+	w.groupSize = 0
+}
+
+func (w *Writer) write(data []byte) {
+	if w.err != nil {
+		return
+	}
+	n, err := w.out.Write(data)
+	w.offset += int64(n)
+	w.err = err
+}
+
+// WriteEvent appends the event as a row, the event is not retained.
+func (w *Writer) WriteEvent(e *auditv1.Event) error {
+	if w.err != nil {
+		return w.err
+	}
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	var scratch [8]byte
+	for i, c := range columns {
+		chunk := w.chunks[i]
+		before := chunk.page.Len()
+		switch {
+		case c.int32Value != nil:
+			binary.LittleEndian.PutUint32(scratch[:4], uint32(c.int32Value(e)))
+			chunk.page.Write(scratch[:4])
+		case c.int64Value != nil:
+			v := c.int64Value(e)
+			if chunk.values == 0 && chunk.pageValues == 0 || v < chunk.min {
+				chunk.min = v
+			}
+			if chunk.values == 0 && chunk.pageValues == 0 || v > chunk.max {
+				chunk.max = v
+			}
+			binary.LittleEndian.PutUint64(scratch[:8], uint64(v))
+			chunk.page.Write(scratch[:8])
+		case c.stringValue != nil:
+			writeByteArray(&chunk.page, []byte(c.stringValue(e)))
+		default:
+			writeByteArray(&chunk.page, raw)
+		}
+		chunk.pageValues++
+		w.groupSize += chunk.page.Len() - before
+		if chunk.page.Len() >= pageSize {
+			if err := w.flushPage(chunk); err != nil {
+				return err
+			}
+		}
+	}
+	w.rows++
+	if w.groupSize >= rowGroupSize {
+		return w.flushRowGroup()
+	}
+	return nil
+}
+
+func writeByteArray(buf *bytes.Buffer, value []byte) {
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(value)))
+	buf.Write(length[:])
+	buf.Write(value)
+}
+
+// flushPage compresses the buffered values of the column into a data page.
+func (w *Writer) flushPage(chunk *columnChunk) error {
+	if chunk.pageValues == 0 {
+		return nil
+	}
+	w.compressed.Reset()
+	w.gzip.Reset(&w.compressed)
+	if _, err := w.gzip.Write(chunk.page.Bytes()); err != nil {
+		return err
+	}
+	if err := w.gzip.Close(); err != nil {
+		return err
+	}
+
+	header := newThriftWriter()
+	header.structBegin()
+	header.i32Field(1, pageTypeData)
+	header.i32Field(2, int32(chunk.page.Len()))
+	header.i32Field(3, int32(w.compressed.Len()))
+	header.structField(5)
+	header.i32Field(1, int32(chunk.pageValues))
+	header.i32Field(2, encodingPlain)
+	header.i32Field(3, encodingRLE)
+	header.i32Field(4, encodingRLE)
+	header.structEnd()
+	header.structEnd()
+
+	chunk.pages.Write(header.buf)
+	chunk.pages.Write(w.compressed.Bytes())
+	chunk.uncompressedBytes += int64(len(header.buf) + chunk.page.Len())
+	chunk.values += int64(chunk.pageValues)
+	chunk.page.Reset()
+	chunk.pageValues = 0
+	return nil
+}
+
+// flushRowGroup writes the column chunks of the buffered rows.
+func (w *Writer) flushRowGroup() error {
+	if w.rows == 0 {
+		return w.err
+	}
+	group := rowGroup{rows: w.rows, offset: w.offset}
+	for i, chunk := range w.chunks {
+		if err := w.flushPage(chunk); err != nil {
+			return err
+		}
+		group.columns = append(group.columns, columnMetaData{
+			column:            &columns[i],
+			values:            chunk.values,
+			offset:            w.offset,
+			uncompressedBytes: chunk.uncompressedBytes,
+			compressedBytes:   int64(chunk.pages.Len()),
+			min:               chunk.min,
+			max:               chunk.max,
+		})
+		w.write(chunk.pages.Bytes())
+	}
+	w.rowGroups = append(w.rowGroups, group)
+	w.resetChunks()
+	return w.err
+}
+
+// Close writes the remaining rows and the file footer. It doesn't close the underlying writer.
+func (w *Writer) Close() error {
+	if err := w.flushRowGroup(); err != nil {
+		return err
+	}
+	metadata := w.fileMetaData()
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(metadata)))
+	w.write(metadata)
+	w.write(length[:])
+	w.write(magic)
+	return w.err
+}
+
+func (w *Writer) fileMetaData() []byte {
+	var rows int64
+	for _, group := range w.rowGroups {
+		rows += group.rows
+	}
+
+	t := newThriftWriter()
+	t.structBegin()
+	t.i32Field(1, fileMetaDataVersion)
+	t.listField(2, thriftStruct, len(columns)+1)
+	t.structBegin()
+	t.binaryField(4, []byte("schema"))
+	t.i32Field(5, int32(len(columns)))
+	t.structEnd()
+	for _, c := range columns {
+		t.structBegin()
+		t.i32Field(1, c.typ)
+		t.i32Field(3, repetitionRequired)
+		t.binaryField(4, []byte(c.name))
+		if c.converted != noConvertedType {
+			t.i32Field(6, c.converted)
+		}
+		t.structEnd()
+	}
+	t.i64Field(3, rows)
+	t.listField(4, thriftStruct, len(w.rowGroups))
+	for _, group := range w.rowGroups {
+		var uncompressed, compressed int64
+		for _, c := range group.columns {
+			uncompressed += c.uncompressedBytes
+			compressed += c.compressedBytes
+		}
+		t.structBegin()
+		t.listField(1, thriftStruct, len(group.columns))
+		for _, c := range group.columns {
+			t.structBegin()
+			t.i64Field(2, c.offset)
+			t.structField(3)
+			t.i32Field(1, c.column.typ)
+			t.listField(2, thriftI32, 1)
+			t.i32(encodingPlain)
+			t.listField(3, thriftBinary, 1)
+			t.binary([]byte(c.column.name))
+			t.i32Field(4, codecGzip)
+			t.i64Field(5, c.values)
+			t.i64Field(6, c.uncompressedBytes)
+			t.i64Field(7, c.compressedBytes)
+			t.i64Field(9, c.offset)
+			if c.column.typ == typeInt64 {
+				var min, max [8]byte
+				binary.LittleEndian.PutUint64(min[:], uint64(c.min))
+				binary.LittleEndian.PutUint64(max[:], uint64(c.max))
+				t.structField(12)
+				t.binaryField(1, max[:])
+				t.binaryField(2, min[:])
+				t.i64Field(3, 0)
+				t.binaryField(5, max[:])
+				t.binaryField(6, min[:])
+				t.structEnd()
+			}
+			t.structEnd()
+			t.structEnd()
+		}
+		t.i64Field(2, uncompressed)
+		t.i64Field(3, group.rows)
+		t.i64Field(5, group.offset)
+		t.i64Field(6, compressed)
+		t.structEnd()
+	}
+	t.binaryField(6, []byte(createdBy))
+	t.structEnd()
+	return t.buf
+}
@@ -0,0 +1,79 @@
+package provenance
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+var (
+	// origins holds the provenance of the decoded events next to them instead of on the events, so the events printed
+	// and exported stay the events of the audit files
+	lock    sync.RWMutex
+	origins = map[*auditv1.Event]Provenance{}
+)
+
+// Provenance locates an event in the raw audit files, eg. for evidence preservation. Cluster is only set when events
+// of several clusters are queried together.
+type Provenance struct {
+	Cluster   string
+	Node      string
+	File      string
+	Line      int
+	Component string
+}
+
+func (p Provenance) String() string {
+	return fmt.Sprintf("%s:%d", p.File, p.Line)
+}
+
+// Set records the provenance of the event.
+func Set(e *auditv1.Event, p Provenance) {
+	lock.Lock()
+	defer lock.Unlock()
+	origins[e] = p
+}
+
+// Forget drops the provenance of the event, before it is reused for another event or discarded.
+func Forget(e *auditv1.Event) {
+	lock.Lock()
+	defer lock.Unlock()
+	delete(origins, e)
+}
+
+// Get returns the provenance recorded for the event, if any.
+func Get(e *auditv1.Event) (Provenance, bool) {
+	lock.RLock()
+	defer lock.RUnlock()
+	p, ok := origins[e]
+	return p, ok
+}
+
+// Cluster returns the cluster the event was decoded from, empty when only a single cluster is queried.
+func Cluster(e *auditv1.Event) string {
+	p, _ := Get(e)
+	return p.Cluster
+}
+
+// Node returns the node the event was decoded from, qualified with the cluster (eg. prod/master-0) when several
+// clusters are queried, as node names repeat across clusters.
+func Node(e *auditv1.Event) string {
+	p, _ := Get(e)
+	if len(p.Cluster) > 0 {
+		return p.Cluster + "/" + p.Node
+	}
+	return p.Node
+}
+
+// ComponentFromPath guesses the API server that wrote the audit file from its path, as must-gather and get store
+// the logs of every API server in a directory named after it.
+func ComponentFromPath(path string) string {
+	for _, component := range []string{"openshift-apiserver", "oauth-apiserver"} {
+		if strings.Contains(path, component) {
+			return component
+		}
+	}
+	return "kube-apiserver"
+}
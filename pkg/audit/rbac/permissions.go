@@ -0,0 +1,95 @@
+// Package rbac relates the permissions exercised by the audited requests to the RBAC rules granting them.
+package rbac
+
+import (
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+// Permission is a permission a user exercised: a verb on a resource of a namespace or on a non-resource URL.
+type Permission struct {
+	Verb      string
+	APIGroup  string
+	Resource  string
+	Namespace string
+	// NonResourceURL is set for the requests to paths like /healthz, the resource fields are empty then
+	NonResourceURL string
+}
+
+// ResourceName returns the resource as kubectl names it, eg. deployments.apps or pods/exec.
+func (p Permission) ResourceName() string {
+	if len(p.NonResourceURL) > 0 {
+		return p.NonResourceURL
+	}
+	resource := p.Resource
+	if len(p.APIGroup) > 0 {
+		if i := strings.Index(resource, "/"); i >= 0 {
+			return resource[:i] + "." + p.APIGroup + resource[i:]
+		}
+		return resource + "." + p.APIGroup
+	}
+	return resource
+}
+
+// Usage counts the requests exercising a permission, every stage of a request counts once.
+type Usage struct {
+	Requests    sets.String
+	First, Last time.Time
+}
+
+func (u *Usage) Add(e *auditv1.Event) {
+	u.Requests.Insert(string(e.AuditID))
+	received := e.RequestReceivedTimestamp.Time
+	if u.First.IsZero() || received.Before(u.First) {
+		u.First = received
+	}
+	if received.After(u.Last) {
+		u.Last = received
+	}
+}
+
+// Usages are the permissions a user exercised.
+type Usages map[Permission]*Usage
+
+func (u Usages) Add(p Permission, e *auditv1.Event) {
+	usage, ok := u[p]
+	if !ok {
+		usage = &Usage{Requests: sets.NewString()}
+		u[p] = usage
+	}
+	usage.Add(e)
+}
+
+// Exercised returns the permission the request exercised, false when it was denied or not authorized at all, like
+// the requests failing authentication.
+func Exercised(e *auditv1.Event) (Permission, bool) {
+	switch e.Annotations["authorization.k8s.io/decision"] {
+	case "allow":
+	case "":
+		if e.ResponseStatus != nil && (e.ResponseStatus.Code == 401 || e.ResponseStatus.Code == 403) {
+			return Permission{}, false
+		}
+	default:
+		return Permission{}, false
+	}
+	if e.ObjectRef == nil {
+		path := e.RequestURI
+		if i := strings.Index(path, "?"); i >= 0 {
+			path = path[:i]
+		}
+		return Permission{Verb: e.Verb, NonResourceURL: path}, true
+	}
+	resource := e.ObjectRef.Resource
+	if len(e.ObjectRef.Subresource) > 0 {
+		resource += "/" + e.ObjectRef.Subresource
+	}
+	return Permission{Verb: e.Verb, APIGroup: e.ObjectRef.APIGroup, Resource: resource, Namespace: e.ObjectRef.Namespace}, true
+}
+
+// IsHumanUser returns whether the user is a person, not a service account, node or control plane component.
+func IsHumanUser(username string) bool {
+	return len(username) > 0 && !strings.HasPrefix(username, "system:")
+}
@@ -0,0 +1,194 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// BoundRule is a rule of a role granted to the subjects of a binding.
+type BoundRule struct {
+	// Namespace is the namespace of a RoleBinding, the rule applies in it only. It is empty for ClusterRoleBindings.
+	Namespace string
+	Binding   string
+	Role      string
+	Subjects  []rbacv1.Subject
+	rbacv1.PolicyRule
+}
+
+// Scope returns the namespace the rule applies in, or <cluster> for the rules of ClusterRoleBindings.
+func (r BoundRule) Scope() string {
+	if len(r.Namespace) == 0 {
+		return "<cluster>"
+	}
+	return r.Namespace
+}
+
+// Covers returns whether the rule grants the permission. The resource names of the rules are not taken into account,
+// the audit events are not aggregated by name.
+func (r BoundRule) Covers(p Permission) bool {
+	if len(r.Namespace) > 0 && (len(p.NonResourceURL) > 0 || r.Namespace != p.Namespace) {
+		return false
+	}
+	return ruleCovers(r.PolicyRule, p)
+}
+
+func ruleCovers(rule rbacv1.PolicyRule, p Permission) bool {
+	if !ruleMatches(rule.Verbs, p.Verb) {
+		return false
+	}
+	if len(p.NonResourceURL) > 0 {
+		for _, url := range rule.NonResourceURLs {
+			if url == rbacv1.NonResourceAll || url == p.NonResourceURL || strings.HasSuffix(url, "*") && strings.HasPrefix(p.NonResourceURL, strings.TrimSuffix(url, "*")) {
+				return true
+			}
+		}
+		return false
+	}
+	if !ruleMatches(rule.APIGroups, p.APIGroup) {
+		return false
+	}
+	for _, resource := range rule.Resources {
+		switch {
+		case resource == rbacv1.ResourceAll, resource == p.Resource:
+			return true
+		case strings.HasPrefix(resource, "*/") && strings.Contains(p.Resource, "/"):
+			if resource[1:] == p.Resource[strings.Index(p.Resource, "/"):] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func ruleMatches(values []string, value string) bool {
+	for _, v := range values {
+		if v == "*" || v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// BoundTo returns whether the rule is granted to the user, directly or through one of its groups.
+func (r BoundRule) BoundTo(username string, groups []string) bool {
+	for _, s := range r.Subjects {
+		switch s.Kind {
+		case rbacv1.UserKind:
+			if s.Name == username {
+				return true
+			}
+		case rbacv1.ServiceAccountKind:
+			if ServiceAccountUsername(s.Namespace, s.Name) == username {
+				return true
+			}
+		case rbacv1.GroupKind:
+			for _, group := range groups {
+				if s.Name == group {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// ServiceAccountUsername returns the username service accounts authenticate as.
+func ServiceAccountUsername(namespace, name string) string {
+	return "system:serviceaccount:" + namespace + ":" + name
+}
+
+// ServiceAccountGroups returns the groups every token of a service account is a member of.
+func ServiceAccountGroups(namespace string) []string {
+	return []string{"system:serviceaccounts", "system:serviceaccounts:" + namespace, "system:authenticated"}
+}
+
+// FetchBoundRules returns the rules of all bindings of the cluster, the bindings to roles that do not exist are left
+// out like the authorizer does.
+func FetchBoundRules(ctx context.Context, client kubernetes.Interface) ([]BoundRule, error) {
+	clusterRoles, err := client.RbacV1().ClusterRoles().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cluster roles: %v", err)
+	}
+	roles, err := client.RbacV1().Roles(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list roles: %v", err)
+	}
+	clusterRoleBindings, err := client.RbacV1().ClusterRoleBindings().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cluster role bindings: %v", err)
+	}
+	roleBindings, err := client.RbacV1().RoleBindings(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list role bindings: %v", err)
+	}
+
+	clusterRoleRules := map[string][]rbacv1.PolicyRule{}
+	for _, role := range clusterRoles.Items {
+		clusterRoleRules[role.Name] = role.Rules
+	}
+	roleRules := map[string][]rbacv1.PolicyRule{}
+	for _, role := range roles.Items {
+		roleRules[role.Namespace+"/"+role.Name] = role.Rules
+	}
+
+	rules := []BoundRule{}
+	for _, binding := range clusterRoleBindings.Items {
+		for _, rule := range clusterRoleRules[binding.RoleRef.Name] {
+			rules = append(rules, BoundRule{
+				Binding:    "ClusterRoleBinding/" + binding.Name,
+				Role:       "ClusterRole/" + binding.RoleRef.Name,
+				Subjects:   binding.Subjects,
+				PolicyRule: rule,
+			})
+		}
+	}
+	for _, binding := range roleBindings.Items {
+		var policyRules []rbacv1.PolicyRule
+		if binding.RoleRef.Kind == "ClusterRole" {
+			policyRules = clusterRoleRules[binding.RoleRef.Name]
+		} else {
+			policyRules = roleRules[binding.Namespace+"/"+binding.RoleRef.Name]
+		}
+		for _, rule := range policyRules {
+			rules = append(rules, BoundRule{
+				Namespace:  binding.Namespace,
+				Binding:    "RoleBinding/" + binding.Name,
+				Role:       binding.RoleRef.Kind + "/" + binding.RoleRef.Name,
+				Subjects:   binding.Subjects,
+				PolicyRule: rule,
+			})
+		}
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].Namespace < rules[j].Namespace
+	})
+	return rules, nil
+}
+
+// DescribeRule returns the resources or non-resource URLs of a rule, eg. deployments,replicasets.apps.
+func DescribeRule(rule rbacv1.PolicyRule) string {
+	if len(rule.NonResourceURLs) > 0 {
+		return strings.Join(rule.NonResourceURLs, ",")
+	}
+	resources := strings.Join(rule.Resources, ",")
+	if len(rule.ResourceNames) > 0 {
+		resources += "[" + strings.Join(rule.ResourceNames, ",") + "]"
+	}
+	if len(rule.APIGroups) == 1 && rule.APIGroups[0] == "" {
+		return resources
+	}
+	groups := []string{}
+	for _, group := range rule.APIGroups {
+		if len(group) == 0 {
+			group = "core"
+		}
+		groups = append(groups, group)
+	}
+	return resources + "." + strings.Join(groups, ",")
+}
@@ -0,0 +1,61 @@
+package runstats
+
+import (
+	"encoding/json"
+	"os"
+	"sync/atomic"
+)
+
+// Env names the file the counters of a run are written to when it exits. The daemon sets it for the jobs it runs to
+// collect their counters into its own metrics.
+const Env = "AUDIT_TOOL_RUN_STATS"
+
+var decodedEvents, decodeErrors int64
+
+// Stats are the counters of the audit events read by a run.
+type Stats struct {
+	DecodedEvents int64 `json:"decodedEvents"`
+	// DecodeErrors are the lines of the audit files that couldn't be decoded and were skipped
+	DecodeErrors int64 `json:"decodeErrors"`
+}
+
+// AddDecoded counts events decoded from the audit files.
+func AddDecoded(n int) {
+	atomic.AddInt64(&decodedEvents, int64(n))
+}
+
+// AddDecodeError counts a line of an audit file that couldn't be decoded.
+func AddDecodeError() {
+	atomic.AddInt64(&decodeErrors, 1)
+}
+
+// Current returns the counters of the run so far.
+func Current() Stats {
+	return Stats{
+		DecodedEvents: atomic.LoadInt64(&decodedEvents),
+		DecodeErrors:  atomic.LoadInt64(&decodeErrors),
+	}
+}
+
+// Write writes the counters of the run to the file named by Env, it does nothing when Env isn't set.
+func Write() error {
+	path := os.Getenv(Env)
+	if len(path) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(Current())
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Read reads the counters a run wrote to path.
+func Read(path string) (Stats, error) {
+	stats := Stats{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return stats, err
+	}
+	return stats, json.Unmarshal(data, &stats)
+}
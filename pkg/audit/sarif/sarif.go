@@ -0,0 +1,156 @@
+package sarif
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+)
+
+// Version and Schema of the SARIF format produced.
+// See https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html
+const (
+	Version = "2.1.0"
+	Schema  = "https://json.schemastore.org/sarif-2.1.0.json"
+)
+
+// Levels of results.
+const (
+	LevelError   = "error"
+	LevelWarning = "warning"
+	LevelNote    = "note"
+)
+
+type Log struct {
+	Schema  string `json:"$schema"`
+	Version string `json:"version"`
+	Runs    []Run  `json:"runs"`
+}
+
+type Run struct {
+	Tool    Tool     `json:"tool"`
+	Results []Result `json:"results"`
+}
+
+type Tool struct {
+	Driver Driver `json:"driver"`
+}
+
+type Driver struct {
+	Name           string `json:"name"`
+	InformationURI string `json:"informationUri,omitempty"`
+	Rules          []Rule `json:"rules"`
+}
+
+type Rule struct {
+	ID                   string                 `json:"id"`
+	Name                 string                 `json:"name,omitempty"`
+	ShortDescription     Message                `json:"shortDescription"`
+	FullDescription      *Message               `json:"fullDescription,omitempty"`
+	DefaultConfiguration *Configuration         `json:"defaultConfiguration,omitempty"`
+	Properties           map[string]interface{} `json:"properties,omitempty"`
+}
+
+type Configuration struct {
+	Level string `json:"level"`
+}
+
+type Message struct {
+	Text string `json:"text"`
+}
+
+type Result struct {
+	RuleID     string                 `json:"ruleId"`
+	Level      string                 `json:"level"`
+	Message    Message                `json:"message"`
+	Locations  []Location             `json:"locations,omitempty"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+type Location struct {
+	PhysicalLocation PhysicalLocation `json:"physicalLocation"`
+}
+
+type PhysicalLocation struct {
+	ArtifactLocation ArtifactLocation `json:"artifactLocation"`
+	Region           *Region          `json:"region,omitempty"`
+}
+
+type ArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type Region struct {
+	StartLine int `json:"startLine"`
+}
+
+// Report collects rules and results of a single audit-tool run.
+type Report struct {
+	rules   []Rule
+	ruleIDs map[string]bool
+	results []Result
+}
+
+func NewReport() *Report {
+	return &Report{ruleIDs: map[string]bool{}}
+}
+
+// AddRule registers the rule metadata, adding the same rule again is a no-op.
+// The security severity (0.0-10.0) is used by code scanning dashboards to rank the results.
+func (r *Report) AddRule(id, description, level string, securitySeverity float64) {
+	if r.ruleIDs[id] {
+		return
+	}
+	r.ruleIDs[id] = true
+	r.rules = append(r.rules, Rule{
+		ID:                   id,
+		Name:                 id,
+		ShortDescription:     Message{Text: description},
+		DefaultConfiguration: &Configuration{Level: level},
+		Properties: map[string]interface{}{
+			"security-severity": formatSeverity(securitySeverity),
+		},
+	})
+}
+
+// AddResult records a detection, locations point to the audit files and lines of the events involved.
+func (r *Report) AddResult(ruleID, level, message string, locations ...Location) {
+	r.results = append(r.results, Result{
+		RuleID:    ruleID,
+		Level:     level,
+		Message:   Message{Text: message},
+		Locations: locations,
+	})
+}
+
+// FileLocation returns the location of the line in the file, line is ignored when not positive.
+func FileLocation(uri string, line int) Location {
+	location := Location{PhysicalLocation: PhysicalLocation{ArtifactLocation: ArtifactLocation{URI: uri}}}
+	if line > 0 {
+		location.PhysicalLocation.Region = &Region{StartLine: line}
+	}
+	return location
+}
+
+func (r *Report) Write(w io.Writer) error {
+	log := Log{
+		Schema:  Schema,
+		Version: Version,
+		Runs: []Run{
+			{
+				Tool: Tool{Driver: Driver{
+					Name:           "audit-tool",
+					InformationURI: "https://github.com/natamm4/audit-tool",
+					Rules:          append([]Rule{}, r.rules...),
+				}},
+				Results: append([]Result{}, r.results...),
+			},
+		},
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(log)
+}
+
+func formatSeverity(severity float64) string {
+	return strconv.FormatFloat(severity, 'f', 1, 64)
+}
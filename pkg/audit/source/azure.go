@@ -0,0 +1,61 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const azureAuthorityHost = "https://login.microsoftonline.com/"
+
+// azureAccessToken returns an Azure AD access token for the resource. It is obtained for the service principal of the
+// standard AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_CLIENT_SECRET environment variables, else printed by the Azure
+// CLI for its logged in account.
+func azureAccessToken(ctx context.Context, client *http.Client, resource string) (string, error) {
+	tenant, clientID, secret := os.Getenv("AZURE_TENANT_ID"), os.Getenv("AZURE_CLIENT_ID"), os.Getenv("AZURE_CLIENT_SECRET")
+	if len(tenant) == 0 || len(clientID) == 0 || len(secret) == 0 {
+		out, err := exec.CommandContext(ctx, "az", "account", "get-access-token", "--resource", resource, "--query", "accessToken", "--output", "tsv").Output()
+		if err != nil {
+			return "", fmt.Errorf("reading Log Analytics requires Azure credentials, set AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_CLIENT_SECRET or log in with az: %v", err)
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+
+	authority := os.Getenv("AZURE_AUTHORITY_HOST")
+	if len(authority) == 0 {
+		authority = azureAuthorityHost
+	}
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", clientID)
+	form.Set("client_secret", secret)
+	form.Set("scope", strings.TrimSuffix(resource, "/")+"/.default")
+	tokenURL := strings.TrimSuffix(authority, "/") + "/" + url.PathEscape(tenant) + "/oauth2/v2.0/token"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("unable to get an Azure access token: %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	token := struct {
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("unable to decode the Azure access token: %v", err)
+	}
+	return token.AccessToken, nil
+}
@@ -0,0 +1,219 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	cloudLoggingEndpoint = "https://logging.googleapis.com/"
+	// cloudLoggingPageSize is the largest page of entries Cloud Logging returns.
+	cloudLoggingPageSize = 1000
+)
+
+// CloudLogging reads the audit events of a GKE cluster from Cloud Logging (cloudlogging://PROJECT/LOCATION/CLUSTER).
+// The admin activity and data access audit logs of the cluster are listed as a single audit file named after the
+// cluster, opening it runs a Cloud Logging filter for the entries of the query time range matching the query filters.
+// The entries are converted into audit events when they are read, like exported GKE audit logs. The access token is
+// obtained from the standard Google credentials or gcloud, CLOUDSDK_API_ENDPOINT_OVERRIDES_LOGGING overrides the
+// endpoint.
+type CloudLogging struct {
+	Project  string
+	Location string
+	Cluster  string
+	Endpoint string
+
+	Query  Query
+	Client *http.Client
+
+	token string
+}
+
+func NewCloudLogging(location string) (*CloudLogging, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Cloud Logging location %q: %v", location, err)
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(u.Host) == 0 || len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return nil, fmt.Errorf("Cloud Logging location %q must include the project, location and name of the cluster (cloudlogging://PROJECT/LOCATION/CLUSTER)", location)
+	}
+	endpoint := os.Getenv("CLOUDSDK_API_ENDPOINT_OVERRIDES_LOGGING")
+	if len(endpoint) == 0 {
+		endpoint = cloudLoggingEndpoint
+	}
+	return &CloudLogging{
+		Project:  u.Host,
+		Location: parts[0],
+		Cluster:  parts[1],
+		Endpoint: endpoint,
+		Client:   http.DefaultClient,
+	}, nil
+}
+
+func (c *CloudLogging) SetQuery(query Query) {
+	c.Query = query
+}
+
+func (c *CloudLogging) List(ctx context.Context) ([]File, error) {
+	return []File{{
+		Name:    c.Cluster + "-audit.log",
+		Path:    fmt.Sprintf("projects/%s/locations/%s/clusters/%s", c.Project, c.Location, c.Cluster),
+		ModTime: time.Now(),
+		Size:    -1,
+	}}, nil
+}
+
+type listLogEntriesResult struct {
+	Entries       []json.RawMessage `json:"entries"`
+	NextPageToken string            `json:"nextPageToken"`
+}
+
+// Open returns the log entries matching the query, one per line and oldest first. They are downloaded a page at a
+// time while the content is read.
+func (c *CloudLogging) Open(ctx context.Context, file File) (io.ReadCloser, error) {
+	request := map[string]interface{}{
+		"resourceNames": []string{"projects/" + c.Project},
+		"filter":        c.filter(),
+		"orderBy":       "timestamp asc",
+		"pageSize":      cloudLoggingPageSize,
+	}
+
+	// the first page is fetched upfront, so that errors like a missing permission are returned by Open
+	result := listLogEntriesResult{}
+	if err := c.call(ctx, "entries:list", request, &result); err != nil {
+		return nil, err
+	}
+	r, w := io.Pipe()
+	go func() {
+		line := bytes.Buffer{}
+		for {
+			for _, entry := range result.Entries {
+				line.Reset()
+				if err := json.Compact(&line, entry); err != nil {
+					w.CloseWithError(err)
+					return
+				}
+				line.WriteByte('\n')
+				if _, err := w.Write(line.Bytes()); err != nil {
+					// the reader was closed
+					return
+				}
+			}
+			if len(result.NextPageToken) == 0 {
+				w.Close()
+				return
+			}
+			request["pageToken"] = result.NextPageToken
+			result = listLogEntriesResult{}
+			if err := c.call(ctx, "entries:list", request, &result); err != nil {
+				w.CloseWithError(err)
+				return
+			}
+		}
+	}()
+	return r, nil
+}
+
+// call sends a request of the Cloud Logging API and decodes the response into result, throttled requests are retried
+// with a backoff.
+func (c *CloudLogging) call(ctx context.Context, method string, request, result interface{}) error {
+	if len(c.token) == 0 {
+		token, err := googleAccessToken(ctx, c.Client)
+		if err != nil {
+			return err
+		}
+		c.token = token
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	backoff := 2 * time.Second
+	for retry := 0; ; retry++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(c.Endpoint, "/")+"/v2/"+method, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		resp, err := c.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode == http.StatusOK {
+			err = json.NewDecoder(resp.Body).Decode(result)
+			resp.Body.Close()
+			if err != nil {
+				return fmt.Errorf("unable to decode %s response of project %q: %v", method, c.Project, err)
+			}
+			return nil
+		}
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		if retry < throttledRetries && resp.StatusCode == http.StatusTooManyRequests {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			continue
+		}
+		return fmt.Errorf("Cloud Logging %s of project %q failed: %s: %s", method, c.Project, resp.Status, strings.TrimSpace(string(message)))
+	}
+}
+
+// filter returns the Cloud Logging filter selecting the audit log entries of the cluster matching the query. The
+// filters of the query are matched against the method and resource names GKE records instead of the audit event.
+func (c *CloudLogging) filter() string {
+	terms := []string{
+		`resource.type="k8s_cluster"`,
+		"resource.labels.location=" + strconv.Quote(c.Location),
+		"resource.labels.cluster_name=" + strconv.Quote(c.Cluster),
+		`protoPayload.serviceName="k8s.io"`,
+		fmt.Sprintf(`logName=("projects/%[1]s/logs/cloudaudit.googleapis.com%%2Factivity" OR "projects/%[1]s/logs/cloudaudit.googleapis.com%%2Fdata_access")`, c.Project),
+	}
+	if !c.Query.From.IsZero() {
+		terms = append(terms, "timestamp>="+strconv.Quote(c.Query.From.UTC().Format(time.RFC3339Nano)))
+	}
+	if !c.Query.To.IsZero() {
+		terms = append(terms, "timestamp<"+strconv.Quote(c.Query.To.Add(requestTimeout).UTC().Format(time.RFC3339Nano)))
+	}
+	for _, f := range []struct {
+		field string
+		// pattern matches the field for the value in place of %s, a trailing * of the value matches wildcard
+		pattern  string
+		wildcard string
+		values   []string
+	}{
+		{"protoPayload.methodName", `\.%s$`, `[^.]*`, c.Query.Verbs},
+		{"protoPayload.authenticationInfo.principalEmail", `^%s$`, `.*`, c.Query.Users},
+		{"protoPayload.resourceName", `^[^/]+/[^/]+/namespaces/%s(/|$)`, `[^/]*`, c.Query.Namespaces},
+		{"protoPayload.resourceName", `^[^/]+/[^/]+/(namespaces/[^/]+/)?[^/]+/%s(/|$)`, `[^/]*`, c.Query.Names},
+	} {
+		conditions := []string{}
+		for _, value := range acceptedValues(f.values) {
+			valueRegexp := regexp.QuoteMeta(value)
+			if strings.HasSuffix(value, "*") {
+				valueRegexp = regexp.QuoteMeta(strings.TrimSuffix(value, "*")) + f.wildcard
+			}
+			conditions = append(conditions, f.field+"=~"+strconv.Quote(fmt.Sprintf(f.pattern, valueRegexp)))
+		}
+		if len(conditions) > 0 {
+			terms = append(terms, "("+strings.Join(conditions, " OR ")+")")
+		}
+	}
+	return strings.Join(terms, " AND ")
+}
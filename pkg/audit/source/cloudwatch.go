@@ -0,0 +1,278 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// cloudWatchAuditStreamPrefix prefixes the audit log streams of the EKS control plane log group, followed by the
+	// ID of the API server instance.
+	cloudWatchAuditStreamPrefix = "kube-apiserver-audit-"
+	// cloudWatchMaxFilterPattern is the longest filter pattern CloudWatch Logs accepts.
+	cloudWatchMaxFilterPattern = 1024
+)
+
+// CloudWatch reads the audit events of an EKS cluster from its control plane log group in CloudWatch Logs, either
+// cloudwatch://CLUSTER for the /aws/eks/CLUSTER/cluster log group or cloudwatch:///LOG/GROUP. Every audit log stream
+// is listed as the audit file of its API server instance, opening it downloads the events of the query time range
+// matching the query filters, translated into a CloudWatch Logs filter pattern. The credentials are read from the
+// standard AWS environment variables like for S3, AWS_ENDPOINT_URL_CLOUDWATCH_LOGS or AWS_ENDPOINT_URL override the
+// endpoint.
+type CloudWatch struct {
+	LogGroup string
+	Region   string
+	Endpoint string
+
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	Query  Query
+	Client *http.Client
+}
+
+func NewCloudWatch(location string) (*CloudWatch, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CloudWatch Logs location %q: %v", location, err)
+	}
+	logGroup := u.Path
+	if len(u.Host) > 0 {
+		logGroup = "/aws/eks/" + u.Host + "/cluster"
+	}
+	if len(logGroup) == 0 {
+		return nil, fmt.Errorf("CloudWatch Logs location %q must include the cluster name (cloudwatch://CLUSTER) or the log group (cloudwatch:///LOG/GROUP)", location)
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_CLOUDWATCH_LOGS")
+	if len(endpoint) == 0 {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	c := &CloudWatch{
+		LogGroup:        logGroup,
+		Region:          awsRegion(),
+		Endpoint:        endpoint,
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		Client:          http.DefaultClient,
+	}
+	if len(c.AccessKeyID) == 0 || len(c.SecretAccessKey) == 0 {
+		return nil, fmt.Errorf("reading CloudWatch Logs requires AWS credentials, set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return c, nil
+}
+
+func (c *CloudWatch) SetQuery(query Query) {
+	c.Query = query
+}
+
+type describeLogStreamsResult struct {
+	LogStreams []struct {
+		LogStreamName       string `json:"logStreamName"`
+		FirstEventTimestamp int64  `json:"firstEventTimestamp"`
+		LastIngestionTime   int64  `json:"lastIngestionTime"`
+	} `json:"logStreams"`
+	NextToken string `json:"nextToken"`
+}
+
+// List returns the audit log streams with events in the query time range. Events are ingested after they are logged,
+// a stream last ingested before the time range holds no events of it.
+func (c *CloudWatch) List(ctx context.Context) ([]File, error) {
+	files := []File{}
+	from, to := c.timeRange()
+	request := map[string]interface{}{
+		"logGroupName":        c.LogGroup,
+		"logStreamNamePrefix": cloudWatchAuditStreamPrefix,
+	}
+	for {
+		result := describeLogStreamsResult{}
+		if err := c.call(ctx, "DescribeLogStreams", request, &result); err != nil {
+			return nil, err
+		}
+		for _, stream := range result.LogStreams {
+			if (from > 0 && stream.LastIngestionTime < from) || (to > 0 && stream.FirstEventTimestamp > to) {
+				continue
+			}
+			// the ID of the API server instance names the node
+			instance := strings.TrimPrefix(stream.LogStreamName, cloudWatchAuditStreamPrefix)
+			files = append(files, File{
+				Name:    "kube-apiserver-" + instance + "-audit.log",
+				Path:    stream.LogStreamName,
+				ModTime: time.Unix(0, stream.LastIngestionTime*int64(time.Millisecond)),
+				Size:    -1,
+			})
+		}
+		if len(result.NextToken) == 0 {
+			return files, nil
+		}
+		request["nextToken"] = result.NextToken
+	}
+}
+
+type filterLogEventsResult struct {
+	Events []struct {
+		Message string `json:"message"`
+	} `json:"events"`
+	NextToken string `json:"nextToken"`
+}
+
+// Open returns the events of the log stream matching the query, one per line. They are downloaded a page at a time
+// while the content is read.
+func (c *CloudWatch) Open(ctx context.Context, file File) (io.ReadCloser, error) {
+	request := map[string]interface{}{
+		"logGroupName":   c.LogGroup,
+		"logStreamNames": []string{file.Path},
+	}
+	from, to := c.timeRange()
+	if from > 0 {
+		request["startTime"] = from
+	}
+	if to > 0 {
+		request["endTime"] = to
+	}
+	if pattern := cloudWatchFilterPattern(c.Query); len(pattern) > 0 {
+		request["filterPattern"] = pattern
+	}
+
+	// the first page is fetched upfront, so that errors like a missing permission are returned by Open
+	result := filterLogEventsResult{}
+	if err := c.call(ctx, "FilterLogEvents", request, &result); err != nil {
+		return nil, err
+	}
+	r, w := io.Pipe()
+	go func() {
+		for {
+			for _, e := range result.Events {
+				if _, err := io.WriteString(w, strings.TrimSpace(e.Message)+"\n"); err != nil {
+					// the reader was closed
+					return
+				}
+			}
+			if len(result.NextToken) == 0 {
+				w.Close()
+				return
+			}
+			request["nextToken"] = result.NextToken
+			result = filterLogEventsResult{}
+			if err := c.call(ctx, "FilterLogEvents", request, &result); err != nil {
+				w.CloseWithError(err)
+				return
+			}
+		}
+	}()
+	return r, nil
+}
+
+// timeRange returns the query time range in milliseconds since the epoch, zero when unbounded.
+func (c *CloudWatch) timeRange() (int64, int64) {
+	from, to := int64(0), int64(0)
+	if !c.Query.From.IsZero() {
+		from = c.Query.From.UnixNano() / int64(time.Millisecond)
+	}
+	if !c.Query.To.IsZero() {
+		to = c.Query.To.Add(requestTimeout).UnixNano() / int64(time.Millisecond)
+	}
+	return from, to
+}
+
+// call sends a request of the CloudWatch Logs JSON API and decodes the response into result, throttled requests are
+// retried with a backoff.
+func (c *CloudWatch) call(ctx context.Context, action string, request, result interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	payloadHash := sha256.Sum256(body)
+	u := &url.URL{Scheme: "https", Host: fmt.Sprintf("logs.%s.amazonaws.com", c.Region), Path: "/"}
+	if len(c.Endpoint) > 0 {
+		if endpoint, err := url.Parse(c.Endpoint); err == nil {
+			u.Scheme = endpoint.Scheme
+			u.Host = endpoint.Host
+		}
+	}
+	credentials := awsCredentials{AccessKeyID: c.AccessKeyID, SecretAccessKey: c.SecretAccessKey, SessionToken: c.SessionToken}
+
+	backoff := time.Second
+	for retry := 0; ; retry++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+		req.Header.Set("X-Amz-Target", "Logs_20140328."+action)
+		credentials.sign(req, u, c.Region, "logs", hex.EncodeToString(payloadHash[:]), time.Now().UTC())
+		resp, err := c.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode == http.StatusOK {
+			err = json.NewDecoder(resp.Body).Decode(result)
+			resp.Body.Close()
+			if err != nil {
+				return fmt.Errorf("unable to decode %s response of %q: %v", action, c.LogGroup, err)
+			}
+			return nil
+		}
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		if retry < throttledRetries && bytes.Contains(message, []byte("ThrottlingException")) {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			continue
+		}
+		return fmt.Errorf("%s of CloudWatch Logs group %q failed: %s: %s", action, c.LogGroup, resp.Status, strings.TrimSpace(string(message)))
+	}
+}
+
+// cloudWatchFilterPattern translates the filters of the query into a JSON filter pattern matching the audit events
+// accepted by any of the values of every filter. Filters are left out when the pattern would get too long.
+func cloudWatchFilterPattern(query Query) string {
+	terms := []string{}
+	for _, f := range []struct {
+		selector string
+		values   []string
+	}{
+		{"$.verb", query.Verbs},
+		{"$.user.username", query.Users},
+		{"$.objectRef.namespace", query.Namespaces},
+		{"$.objectRef.name", query.Names},
+	} {
+		conditions := []string{}
+		for _, value := range acceptedValues(f.values) {
+			if strings.ContainsAny(value, `"\`) {
+				// the value cannot be quoted, the filter is applied to the downloaded events only
+				conditions = nil
+				break
+			}
+			// a trailing * matches a prefix in filter patterns too
+			conditions = append(conditions, fmt.Sprintf(`%s = "%s"`, f.selector, value))
+		}
+		if len(conditions) == 0 {
+			continue
+		}
+		term := "(" + strings.Join(conditions, " || ") + ")"
+		if len("{ "+strings.Join(append(terms, term), " && ")+" }") > cloudWatchMaxFilterPattern {
+			continue
+		}
+		terms = append(terms, term)
+	}
+	if len(terms) == 0 {
+		return ""
+	}
+	return "{ " + strings.Join(terms, " && ") + " }"
+}
@@ -0,0 +1,95 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FilesLocationPrefix marks the locations that are shell-style glob patterns of individual audit files instead of
+// directories, eg. files:dump/*/kube-apiserver/*.log.gz.
+const FilesLocationPrefix = "files:"
+
+// LocalFiles reads the audit files matching glob patterns on the local filesystem as a single source, eg. the audit
+// logs spread over the directories of the nodes of a dump.
+type LocalFiles struct {
+	Patterns []string
+}
+
+func NewLocalFiles(patterns []string) (*LocalFiles, error) {
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid file pattern %q: %v", pattern, err)
+		}
+	}
+	return &LocalFiles{Patterns: patterns}, nil
+}
+
+// List returns the files matching the patterns, a file matching several of them once. The node of every file is
+// attributed as it is for directories, by the name before -audit, and otherwise by the first directory of its path
+// matched by a wildcard, eg. master-0 of dump/master-0/kube-apiserver/audit.log for dump/*/kube-apiserver/*.log.
+func (l *LocalFiles) List(ctx context.Context) ([]File, error) {
+	files := []File{}
+	seen := map[string]bool{}
+	for _, pattern := range l.Patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid file pattern %q: %v", pattern, err)
+		}
+		matched := false
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if info.IsDir() {
+				continue
+			}
+			matched = true
+			if seen[match] {
+				continue
+			}
+			seen[match] = true
+			files = append(files, File{
+				Name:    info.Name(),
+				Path:    match,
+				Node:    fileNode(pattern, match),
+				ModTime: info.ModTime(),
+				Size:    info.Size(),
+			})
+		}
+		if !matched {
+			return nil, fmt.Errorf("no audit files match %q", pattern)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files, nil
+}
+
+func (l *LocalFiles) Open(ctx context.Context, file File) (io.ReadCloser, error) {
+	return os.Open(file.Path)
+}
+
+// fileNode returns the node that wrote the audit file at the path matching the pattern.
+func fileNode(pattern, path string) string {
+	name := filepath.Base(path)
+	if i := strings.Index(name, "-audit"); i > 0 {
+		return name[:i]
+	}
+	patternDirs := strings.Split(filepath.Dir(filepath.Clean(pattern)), string(filepath.Separator))
+	pathDirs := strings.Split(filepath.Dir(filepath.Clean(path)), string(filepath.Separator))
+	if len(patternDirs) == len(pathDirs) {
+		for i, dir := range patternDirs {
+			if strings.ContainsAny(dir, "*?[") {
+				return pathDirs[i]
+			}
+		}
+	}
+	return filepath.Base(filepath.Dir(path))
+}
@@ -0,0 +1,150 @@
+package source
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	googleTokenURL = "https://oauth2.googleapis.com/token"
+	// googleLoggingReadScope allows to read the logs, including the data access audit logs.
+	googleLoggingReadScope = "https://www.googleapis.com/auth/logging.read"
+)
+
+// googleCredentials is the subset of a service account key or of the application default credentials of a user
+// needed to get an access token.
+type googleCredentials struct {
+	Type string `json:"type"`
+	// service_account
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+	// authorized_user
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// googleAccessToken returns an OAuth2 access token allowed to read the logs. It is read from the
+// CLOUDSDK_AUTH_ACCESS_TOKEN or GOOGLE_OAUTH_ACCESS_TOKEN environment variables, else obtained for the service account
+// key or user credentials of GOOGLE_APPLICATION_CREDENTIALS, else printed by gcloud for its active account.
+func googleAccessToken(ctx context.Context, client *http.Client) (string, error) {
+	for _, env := range []string{"CLOUDSDK_AUTH_ACCESS_TOKEN", "GOOGLE_OAUTH_ACCESS_TOKEN"} {
+		if token := os.Getenv(env); len(token) > 0 {
+			return token, nil
+		}
+	}
+	if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); len(path) > 0 {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		credentials := googleCredentials{}
+		if err := json.Unmarshal(data, &credentials); err != nil {
+			return "", fmt.Errorf("invalid credentials %q: %v", path, err)
+		}
+		return credentials.accessToken(ctx, client)
+	}
+	out, err := exec.CommandContext(ctx, "gcloud", "auth", "print-access-token").Output()
+	if err != nil {
+		return "", fmt.Errorf("reading Cloud Logging requires Google credentials, set GOOGLE_APPLICATION_CREDENTIALS or log in with gcloud: %v", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (c googleCredentials) accessToken(ctx context.Context, client *http.Client) (string, error) {
+	form := url.Values{}
+	tokenURL := googleTokenURL
+	switch c.Type {
+	case "service_account":
+		assertion, err := c.assertion(time.Now())
+		if err != nil {
+			return "", err
+		}
+		if len(c.TokenURI) > 0 {
+			tokenURL = c.TokenURI
+		}
+		form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+		form.Set("assertion", assertion)
+	case "authorized_user":
+		form.Set("grant_type", "refresh_token")
+		form.Set("client_id", c.ClientID)
+		form.Set("client_secret", c.ClientSecret)
+		form.Set("refresh_token", c.RefreshToken)
+	default:
+		return "", fmt.Errorf("unsupported credentials type %q, expected service_account or authorized_user", c.Type)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("unable to get a Google access token: %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	token := struct {
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("unable to decode the Google access token: %v", err)
+	}
+	return token.AccessToken, nil
+}
+
+// assertion returns the JWT signed by the service account key, exchanged for an access token.
+// See https://developers.google.com/identity/protocols/oauth2/service-account#authorizingrequests
+func (c googleCredentials) assertion(now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(c.PrivateKey))
+	if block == nil {
+		return "", fmt.Errorf("invalid private key of service account %q", c.ClientEmail)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("invalid private key of service account %q: %v", c.ClientEmail, err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return "", fmt.Errorf("private key of service account %q is not an RSA key", c.ClientEmail)
+	}
+	aud := c.TokenURI
+	if len(aud) == 0 {
+		aud = googleTokenURL
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   c.ClientEmail,
+		"scope": googleLoggingReadScope,
+		"aud":   aud,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,92 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/natamm4/audit-tool/pkg/audit/decompress"
+)
+
+var hrefRegexp = regexp.MustCompile(`href="([^"]+\.(?:gz|bz2|zst))"`)
+
+// HTTP reads audit files served over HTTP(S). The URL either points to a single .gz, .bz2 or .zst file or to a
+// directory index page that links to such files.
+type HTTP struct {
+	URL    *url.URL
+	Client *http.Client
+}
+
+func NewHTTP(location string) (*HTTP, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %q: %v", location, err)
+	}
+	return &HTTP{URL: u, Client: http.DefaultClient}, nil
+}
+
+func (h *HTTP) List(ctx context.Context) ([]File, error) {
+	if decompress.TrimExtension(h.URL.Path) != h.URL.Path {
+		// a single compressed audit file
+		return []File{h.fileFor(h.URL)}, nil
+	}
+
+	body, err := h.get(ctx, h.URL.String())
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	index, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	base := *h.URL
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+	files := []File{}
+	for _, match := range hrefRegexp.FindAllSubmatch(index, -1) {
+		ref, err := url.Parse(string(match[1]))
+		if err != nil {
+			continue
+		}
+		files = append(files, h.fileFor(base.ResolveReference(ref)))
+	}
+	return files, nil
+}
+
+func (h *HTTP) Open(ctx context.Context, file File) (io.ReadCloser, error) {
+	return h.get(ctx, file.Path)
+}
+
+func (h *HTTP) fileFor(u *url.URL) File {
+	return File{
+		Name:    path.Base(u.Path),
+		Path:    u.String(),
+		ModTime: time.Now(),
+		Size:    -1,
+	}
+}
+
+func (h *HTTP) get(ctx context.Context, location string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s failed: %s", location, resp.Status)
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,51 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// LocalDirectory reads audit files from a directory on the local filesystem.
+type LocalDirectory struct {
+	Dir string
+}
+
+func NewLocalDirectory(dir string) (*LocalDirectory, error) {
+	dirStat, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !dirStat.IsDir() {
+		return nil, fmt.Errorf("not a directory %q", dir)
+	}
+	return &LocalDirectory{Dir: dir}, nil
+}
+
+func (d *LocalDirectory) List(ctx context.Context) ([]File, error) {
+	files := []File{}
+	if err := filepath.Walk(d.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		files = append(files, File{
+			Name:    info.Name(),
+			Path:    path,
+			ModTime: info.ModTime(),
+			Size:    info.Size(),
+		})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func (d *LocalDirectory) Open(ctx context.Context, file File) (io.ReadCloser, error) {
+	return os.Open(file.Path)
+}
@@ -0,0 +1,251 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	logAnalyticsEndpoint = "https://api.loganalytics.io/"
+	// logAnalyticsPageSize is the number of events queried at once, the results of a query are limited in size.
+	logAnalyticsPageSize = 10000
+	// logAnalyticsTimeFormat is the format of the datetime literals of the queries.
+	logAnalyticsTimeFormat = "2006-01-02T15:04:05.0000000Z"
+)
+
+// logAnalyticsEvents are the expressions turning the rows of the tables AKS writes the audit events to into the
+// events. The resource specific tables hold the fields of the events as columns, the timestamps of the events have
+// to be formatted with microseconds.
+var logAnalyticsEvents = map[string]string{
+	"AzureDiagnostics": `where Category in ("kube-audit", "kube-audit-admin") | extend Event = parse_json(log_s)`,
+	"AKSAudit":         `extend Event = ` + logAnalyticsPackEvent,
+	"AKSAuditAdmin":    `extend Event = ` + logAnalyticsPackEvent,
+}
+
+const logAnalyticsPackEvent = `pack("kind", "Event", "apiVersion", "audit.k8s.io/v1", "level", Level, "auditID", AuditId, ` +
+	`"stage", Stage, "requestURI", RequestUri, "verb", Verb, "user", User, "impersonatedUser", ImpersonatedUser, ` +
+	`"sourceIPs", SourceIps, "userAgent", UserAgent, "objectRef", ObjectRef, "responseStatus", ResponseStatus, ` +
+	`"requestObject", RequestObject, "responseObject", ResponseObject, "annotations", Annotations, ` +
+	`"requestReceivedTimestamp", strcat(format_datetime(RequestReceivedTime, "yyyy-MM-dd"), "T", format_datetime(RequestReceivedTime, "HH:mm:ss.ffffff"), "Z"), ` +
+	`"stageTimestamp", strcat(format_datetime(StageReceivedTime, "yyyy-MM-dd"), "T", format_datetime(StageReceivedTime, "HH:mm:ss.ffffff"), "Z"))`
+
+// LogAnalytics reads the audit events of AKS clusters from a Log Analytics workspace
+// (loganalytics://WORKSPACE-ID?cluster=NAME&table=AKSAudit). The table defaults to AzureDiagnostics, which the
+// diagnostic settings write to unless they are set to resource specific tables, the cluster to every cluster of the
+// workspace. The events are listed as a single audit file named after the cluster, opening it runs a generated KQL
+// query for the events of the query time range matching the query filters. The access token is obtained from the
+// standard Azure environment variables or the Azure CLI, AZURE_LOG_ANALYTICS_ENDPOINT overrides the endpoint.
+type LogAnalytics struct {
+	Workspace string
+	Table     string
+	Cluster   string
+	Endpoint  string
+
+	Query  Query
+	Client *http.Client
+
+	token string
+}
+
+func NewLogAnalytics(location string) (*LogAnalytics, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Log Analytics location %q: %v", location, err)
+	}
+	if len(u.Host) == 0 {
+		return nil, fmt.Errorf("Log Analytics location %q must include the workspace ID (loganalytics://WORKSPACE-ID)", location)
+	}
+	l := &LogAnalytics{
+		Workspace: u.Host,
+		Table:     u.Query().Get("table"),
+		Cluster:   u.Query().Get("cluster"),
+		Endpoint:  os.Getenv("AZURE_LOG_ANALYTICS_ENDPOINT"),
+		Client:    http.DefaultClient,
+	}
+	if len(l.Table) == 0 {
+		l.Table = "AzureDiagnostics"
+	}
+	if _, ok := logAnalyticsEvents[l.Table]; !ok {
+		return nil, fmt.Errorf("unsupported Log Analytics table %q, expected AzureDiagnostics, AKSAudit or AKSAuditAdmin", l.Table)
+	}
+	if len(l.Endpoint) == 0 {
+		l.Endpoint = logAnalyticsEndpoint
+	}
+	return l, nil
+}
+
+func (l *LogAnalytics) SetQuery(query Query) {
+	l.Query = query
+}
+
+func (l *LogAnalytics) List(ctx context.Context) ([]File, error) {
+	name := l.Cluster
+	if len(name) == 0 {
+		name = l.Workspace
+	}
+	return []File{{
+		Name:    name + "-audit.log",
+		Path:    l.Workspace + "/" + l.Table,
+		ModTime: time.Now(),
+		Size:    -1,
+	}}, nil
+}
+
+// logAnalyticsPosition is the last row of a page, the next page starts after it. Rows are ordered by the time they
+// were ingested at and their item ID, which is unique.
+type logAnalyticsPosition struct {
+	timeGenerated string
+	itemID        string
+}
+
+type logAnalyticsResult struct {
+	Tables []struct {
+		Rows [][]string `json:"rows"`
+	} `json:"tables"`
+}
+
+// Open returns the events matching the query, one per line and oldest first. They are queried a page at a time while
+// the content is read.
+func (l *LogAnalytics) Open(ctx context.Context, file File) (io.ReadCloser, error) {
+	// the first page is queried upfront, so that errors like a missing permission are returned by Open
+	rows, err := l.page(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	r, w := io.Pipe()
+	go func() {
+		for {
+			for _, row := range rows {
+				if _, err := io.WriteString(w, row[2]+"\n"); err != nil {
+					// the reader was closed
+					return
+				}
+			}
+			if len(rows) < logAnalyticsPageSize {
+				w.Close()
+				return
+			}
+			last := rows[len(rows)-1]
+			if rows, err = l.page(ctx, &logAnalyticsPosition{timeGenerated: last[0], itemID: last[1]}); err != nil {
+				w.CloseWithError(err)
+				return
+			}
+		}
+	}()
+	return r, nil
+}
+
+// page returns the rows of TimeGenerated, _ItemId and the event following the position.
+func (l *LogAnalytics) page(ctx context.Context, after *logAnalyticsPosition) ([][]string, error) {
+	if len(l.token) == 0 {
+		token, err := azureAccessToken(ctx, l.Client, logAnalyticsEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		l.token = token
+	}
+	body, err := json.Marshal(map[string]string{"query": l.kql(after)})
+	if err != nil {
+		return nil, err
+	}
+
+	backoff := time.Second
+	for retry := 0; ; retry++ {
+		u := strings.TrimSuffix(l.Endpoint, "/") + "/v1/workspaces/" + url.PathEscape(l.Workspace) + "/query"
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+l.token)
+		resp, err := l.Client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusOK {
+			result := logAnalyticsResult{}
+			err = json.NewDecoder(resp.Body).Decode(&result)
+			resp.Body.Close()
+			if err != nil {
+				return nil, fmt.Errorf("unable to decode the query results of workspace %q: %v", l.Workspace, err)
+			}
+			if len(result.Tables) == 0 {
+				return nil, nil
+			}
+			for _, row := range result.Tables[0].Rows {
+				if len(row) != 3 {
+					return nil, fmt.Errorf("unexpected query results of workspace %q: %d columns", l.Workspace, len(row))
+				}
+			}
+			return result.Tables[0].Rows, nil
+		}
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		if retry < throttledRetries && resp.StatusCode == http.StatusTooManyRequests {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			continue
+		}
+		return nil, fmt.Errorf("query of Log Analytics workspace %q failed: %s: %s", l.Workspace, resp.Status, strings.TrimSpace(string(message)))
+	}
+}
+
+// kql returns the query of the page of events following the position, the first page when nil. The filters of the
+// query are matched against the events, a value with a trailing * matches a prefix.
+func (l *LogAnalytics) kql(after *logAnalyticsPosition) string {
+	stages := []string{l.Table}
+	if !l.Query.From.IsZero() {
+		stages = append(stages, fmt.Sprintf("where TimeGenerated >= datetime(%s)", l.Query.From.UTC().Format(logAnalyticsTimeFormat)))
+	}
+	if !l.Query.To.IsZero() {
+		stages = append(stages, fmt.Sprintf("where TimeGenerated < datetime(%s)", l.Query.To.Add(requestTimeout).UTC().Format(logAnalyticsTimeFormat)))
+	}
+	if len(l.Cluster) > 0 {
+		stages = append(stages, "where _ResourceId endswith "+strconv.Quote("/managedclusters/"+l.Cluster))
+	}
+	stages = append(stages, logAnalyticsEvents[l.Table])
+	for _, f := range []struct {
+		field  string
+		values []string
+	}{
+		{"Event.verb", l.Query.Verbs},
+		{"Event.user.username", l.Query.Users},
+		{"Event.objectRef.namespace", l.Query.Namespaces},
+		{"Event.objectRef.name", l.Query.Names},
+	} {
+		conditions := []string{}
+		for _, value := range acceptedValues(f.values) {
+			if strings.HasSuffix(value, "*") {
+				conditions = append(conditions, fmt.Sprintf("tostring(%s) startswith_cs %s", f.field, strconv.Quote(strings.TrimSuffix(value, "*"))))
+				continue
+			}
+			conditions = append(conditions, fmt.Sprintf("tostring(%s) == %s", f.field, strconv.Quote(value)))
+		}
+		if len(conditions) > 0 {
+			stages = append(stages, "where "+strings.Join(conditions, " or "))
+		}
+	}
+	if after != nil {
+		stages = append(stages, fmt.Sprintf("where TimeGenerated > datetime(%[1]s) or (TimeGenerated == datetime(%[1]s) and strcmp(_ItemId, %[2]s) > 0)",
+			after.timeGenerated, strconv.Quote(after.itemID)))
+	}
+	stages = append(stages,
+		"order by TimeGenerated asc, _ItemId asc",
+		fmt.Sprintf("take %d", logAnalyticsPageSize),
+		"project TimeGenerated, _ItemId, Event = tostring(Event)",
+	)
+	return strings.Join(stages, "\n| ")
+}
@@ -0,0 +1,50 @@
+package source
+
+import (
+	"strings"
+	"time"
+)
+
+// Query narrows down the events a QuerySource downloads to those of a time range which may match the filters. The
+// filter values are those of the query flags: a trailing * matches a prefix and a leading - excludes the value.
+type Query struct {
+	// From and To bound the time the requests were received at, unbounded when zero.
+	From, To time.Time
+
+	Verbs      []string
+	Namespaces []string
+	Names      []string
+	Users      []string
+}
+
+// QuerySource is an EventSource querying a logging service rather than listing files. The query is pushed down to the
+// service, the files of the source hold the events of the query time range only.
+type QuerySource interface {
+	EventSource
+	// SetQuery narrows down the events of the files opened afterwards. The events are a superset of the ones matching
+	// the query, the filters still have to be applied to them.
+	SetQuery(query Query)
+}
+
+// throttledRetries is how often a request throttled by a logging service is retried, their read quotas are low.
+const throttledRetries = 5
+
+// requestTimeout is the longest time a request is served for, long running requests like watches are logged when
+// they end, up to an hour after they were received. The end of the time range pushed down is extended by it.
+const requestTimeout = time.Hour
+
+// acceptedValues returns the values of a filter an event has to match one of, excluded values are left out as the
+// filters exclude them anyway. Nothing is returned when every value is accepted, including events without the field.
+func acceptedValues(values []string) []string {
+	accepted := []string{}
+	for _, value := range values {
+		switch {
+		case len(value) == 0, value == "*":
+			return nil
+		case strings.HasPrefix(value, "-"):
+			continue
+		}
+		accepted = append(accepted, value)
+	}
+	return accepted
+}
@@ -0,0 +1,188 @@
+package source
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// emptyPayloadHash is the SHA256 of an empty body, all requests we send to S3 are bodiless GETs.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3 reads audit files from an S3 bucket (s3://bucket/prefix). Credentials are read from the standard
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables, when they are
+// not set the requests are sent anonymously. AWS_ENDPOINT_URL allows to use S3 compatible storage.
+type S3 struct {
+	Bucket   string
+	Prefix   string
+	Region   string
+	Endpoint string
+
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	Client *http.Client
+}
+
+func NewS3(location string) (*S3, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 location %q: %v", location, err)
+	}
+	if len(u.Host) == 0 {
+		return nil, fmt.Errorf("S3 location %q must include bucket name (s3://bucket/prefix)", location)
+	}
+	return &S3{
+		Bucket:          u.Host,
+		Prefix:          strings.TrimPrefix(u.Path, "/"),
+		Region:          awsRegion(),
+		Endpoint:        os.Getenv("AWS_ENDPOINT_URL"),
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		Client:          http.DefaultClient,
+	}, nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3) List(ctx context.Context) ([]File, error) {
+	files := []File{}
+	continuationToken := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		if len(s.Prefix) > 0 {
+			query.Set("prefix", s.Prefix)
+		}
+		if len(continuationToken) > 0 {
+			query.Set("continuation-token", continuationToken)
+		}
+		body, err := s.get(ctx, "", query)
+		if err != nil {
+			return nil, err
+		}
+		result := listBucketResult{}
+		err = xml.NewDecoder(body).Decode(&result)
+		body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode bucket listing of %q: %v", s.Bucket, err)
+		}
+		for _, object := range result.Contents {
+			if strings.HasSuffix(object.Key, "/") {
+				continue
+			}
+			files = append(files, File{
+				Name:    path.Base(object.Key),
+				Path:    object.Key,
+				ModTime: object.LastModified,
+				Size:    object.Size,
+			})
+		}
+		if !result.IsTruncated || len(result.NextContinuationToken) == 0 {
+			return files, nil
+		}
+		continuationToken = result.NextContinuationToken
+	}
+}
+
+func (s *S3) Open(ctx context.Context, file File) (io.ReadCloser, error) {
+	return s.get(ctx, file.Path, nil)
+}
+
+func (s *S3) objectURL(key string, query url.Values) *url.URL {
+	u := &url.URL{Scheme: "https", Host: fmt.Sprintf("%s.s3.%s.amazonaws.com", s.Bucket, s.Region), Path: "/" + key}
+	if len(s.Endpoint) > 0 {
+		// custom endpoints (eg. minio) usually only support path style addressing
+		endpoint, err := url.Parse(s.Endpoint)
+		if err == nil {
+			u.Scheme = endpoint.Scheme
+			u.Host = endpoint.Host
+			u.Path = path.Join("/", endpoint.Path, s.Bucket, key)
+		}
+	}
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+	return u
+}
+
+func (s *S3) get(ctx context.Context, key string, query url.Values) (io.ReadCloser, error) {
+	u := s.objectURL(key, query)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.AccessKeyID) > 0 {
+		s.sign(req, u, time.Now().UTC())
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("GET s3://%s/%s failed: %s: %s", s.Bucket, key, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return resp.Body, nil
+}
+
+func (s *S3) sign(req *http.Request, u *url.URL, now time.Time) {
+	credentials := awsCredentials{AccessKeyID: s.AccessKeyID, SecretAccessKey: s.SecretAccessKey, SessionToken: s.SessionToken}
+	credentials.sign(req, u, s.Region, "s3", emptyPayloadHash, now)
+}
+
+// canonicalQuery encodes the query the way SigV4 expects it: sorted by key and with spaces encoded as %20.
+func canonicalQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	keys := []string{}
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := []string{}
+	for _, k := range keys {
+		values := append([]string{}, query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode escapes everything except the unreserved characters, slashes are kept unless encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	b := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
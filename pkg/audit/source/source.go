@@ -0,0 +1,53 @@
+package source
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// File describes a single audit log file available in an EventSource.
+type File struct {
+	// Name is the base name of the file (eg. master-0-audit-2021-09-01T10-00-00.000.log.gz).
+	Name string
+	// Path is the source specific location of the file, passed back to Open.
+	Path string
+	// Node is the node that wrote the file when the source knows it, otherwise the node is the part of the name
+	// before -audit and files without it are not audit files.
+	Node    string
+	ModTime time.Time
+	Size    int64
+}
+
+// EventSource provides access to audit log files regardless of where they are stored.
+type EventSource interface {
+	// List returns all files available in the source.
+	List(ctx context.Context) ([]File, error)
+	// Open returns the raw content of the file. The caller is responsible for closing the reader.
+	Open(ctx context.Context, file File) (io.ReadCloser, error)
+}
+
+// New returns the EventSource for the given location. Supported locations are local directories,
+// s3://bucket/prefix, http(s):// URLs, the CloudWatch Logs group of an EKS cluster (cloudwatch://CLUSTER), the Cloud
+// Logging entries of a GKE cluster (cloudlogging://PROJECT/LOCATION/CLUSTER), the Log Analytics workspace of AKS
+// clusters (loganalytics://WORKSPACE-ID) and - for the standard input.
+func New(location string) (EventSource, error) {
+	switch {
+	case location == StdinLocation:
+		return NewStdin(os.Stdin), nil
+	case strings.HasPrefix(location, "s3://"):
+		return NewS3(location)
+	case strings.HasPrefix(location, "cloudwatch://"):
+		return NewCloudWatch(location)
+	case strings.HasPrefix(location, "cloudlogging://"):
+		return NewCloudLogging(location)
+	case strings.HasPrefix(location, "loganalytics://"):
+		return NewLogAnalytics(location)
+	case strings.HasPrefix(location, "http://"), strings.HasPrefix(location, "https://"):
+		return NewHTTP(location)
+	default:
+		return NewLocalDirectory(location)
+	}
+}
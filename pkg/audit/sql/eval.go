@@ -0,0 +1,507 @@
+package sql
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+// evalContext is the input of an expression: the event while the events are read, the values of the group keys and
+// aggregates once a group is complete.
+type evalContext struct {
+	event      *auditv1.Event
+	keys       []interface{}
+	aggregates []interface{}
+}
+
+// node is an expression. Values are nil (NULL), bool, float64, string and time.Time.
+type node interface {
+	eval(ctx *evalContext) (interface{}, error)
+	// String returns the canonical text of the expression, equal expressions have equal texts
+	String() string
+	// children returns the sub-expressions, so that they can be rewritten in place
+	children() []*node
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (n *literal) eval(*evalContext) (interface{}, error) {
+	return n.value, nil
+}
+
+func (n *literal) String() string {
+	switch v := n.value.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	}
+	return FormatValue(n.value)
+}
+
+func (n *literal) children() []*node {
+	return nil
+}
+
+type columnRef struct {
+	name   string
+	column *column
+}
+
+func (n *columnRef) eval(ctx *evalContext) (interface{}, error) {
+	if ctx.event == nil {
+		return nil, fmt.Errorf("column %q must appear in the GROUP BY clause or be used in an aggregate function", n.name)
+	}
+	return n.column.value(ctx.event), nil
+}
+
+func (n *columnRef) String() string {
+	if n.column != nil {
+		return n.column.name
+	}
+	return n.name
+}
+
+func (n *columnRef) children() []*node {
+	return nil
+}
+
+// groupKey is a GROUP BY expression used in the select list or ORDER BY of a grouped query.
+type groupKey struct {
+	index int
+	text  string
+}
+
+func (n *groupKey) eval(ctx *evalContext) (interface{}, error) {
+	return ctx.keys[n.index], nil
+}
+
+func (n *groupKey) String() string {
+	return n.text
+}
+
+func (n *groupKey) children() []*node {
+	return nil
+}
+
+type negation struct {
+	operand node
+}
+
+func (n *negation) eval(ctx *evalContext) (interface{}, error) {
+	v, err := n.operand.eval(ctx)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return nil, fmt.Errorf("NOT of %s", typeName(v))
+	}
+	return !b, nil
+}
+
+func (n *negation) String() string {
+	return "(NOT " + n.operand.String() + ")"
+}
+
+func (n *negation) children() []*node {
+	return []*node{&n.operand}
+}
+
+type binary struct {
+	operator    string
+	left, right node
+}
+
+func (n *binary) eval(ctx *evalContext) (interface{}, error) {
+	left, err := n.left.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// AND and OR follow the three-valued logic of SQL
+	switch n.operator {
+	case "and", "or":
+		l, err := toBool(left)
+		if err != nil {
+			return nil, err
+		}
+		if l != nil && *l == (n.operator == "or") {
+			return *l, nil
+		}
+		right, err := n.right.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		r, err := toBool(right)
+		if err != nil {
+			return nil, err
+		}
+		if r != nil && *r == (n.operator == "or") {
+			return *r, nil
+		}
+		if l == nil || r == nil {
+			return nil, nil
+		}
+		return *l, nil
+	}
+
+	right, err := n.right.eval(ctx)
+	if err != nil || left == nil || right == nil {
+		return nil, err
+	}
+	c, ok := compare(left, right)
+	if !ok {
+		return nil, fmt.Errorf("cannot compare %s and %s", typeName(left), typeName(right))
+	}
+	switch n.operator {
+	case "=":
+		return c == 0, nil
+	case "<>", "!=":
+		return c != 0, nil
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	}
+	return c >= 0, nil
+}
+
+func (n *binary) String() string {
+	return "(" + n.left.String() + " " + strings.ToUpper(n.operator) + " " + n.right.String() + ")"
+}
+
+func (n *binary) children() []*node {
+	return []*node{&n.left, &n.right}
+}
+
+// like matches the LIKE patterns, % matches any text and _ a single character.
+type like struct {
+	target, pattern node
+	not             bool
+
+	// patterns caches the compiled patterns, usually there is only the literal one
+	patterns map[string]*regexp.Regexp
+}
+
+func (n *like) eval(ctx *evalContext) (interface{}, error) {
+	target, err := n.target.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := n.pattern.eval(ctx)
+	if err != nil || target == nil || pattern == nil {
+		return nil, err
+	}
+	p, ok := pattern.(string)
+	if !ok {
+		return nil, fmt.Errorf("LIKE pattern must be a string, got %s", typeName(pattern))
+	}
+	re, ok := n.patterns[p]
+	if !ok {
+		expression := &strings.Builder{}
+		expression.WriteString("(?s)^")
+		for _, r := range p {
+			switch r {
+			case '%':
+				expression.WriteString(".*")
+			case '_':
+				expression.WriteString(".")
+			default:
+				expression.WriteString(regexp.QuoteMeta(string(r)))
+			}
+		}
+		expression.WriteString("$")
+		re = regexp.MustCompile(expression.String())
+		if n.patterns == nil {
+			n.patterns = map[string]*regexp.Regexp{}
+		}
+		n.patterns[p] = re
+	}
+	return re.MatchString(FormatValue(target)) != n.not, nil
+}
+
+func (n *like) String() string {
+	operator := " LIKE "
+	if n.not {
+		operator = " NOT" + operator
+	}
+	return "(" + n.target.String() + operator + n.pattern.String() + ")"
+}
+
+func (n *like) children() []*node {
+	return []*node{&n.target, &n.pattern}
+}
+
+type in struct {
+	target node
+	list   []node
+	not    bool
+}
+
+func (n *in) eval(ctx *evalContext) (interface{}, error) {
+	target, err := n.target.eval(ctx)
+	if err != nil || target == nil {
+		return nil, err
+	}
+	null := false
+	for _, element := range n.list {
+		v, err := element.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			null = true
+			continue
+		}
+		if c, ok := compare(target, v); ok && c == 0 {
+			return !n.not, nil
+		}
+	}
+	if null {
+		return nil, nil
+	}
+	return n.not, nil
+}
+
+func (n *in) String() string {
+	list := make([]string, len(n.list))
+	for i, element := range n.list {
+		list[i] = element.String()
+	}
+	operator := " IN "
+	if n.not {
+		operator = " NOT IN "
+	}
+	return "(" + n.target.String() + operator + "(" + strings.Join(list, ", ") + "))"
+}
+
+func (n *in) children() []*node {
+	children := []*node{&n.target}
+	for i := range n.list {
+		children = append(children, &n.list[i])
+	}
+	return children
+}
+
+type isNull struct {
+	target node
+	not    bool
+}
+
+func (n *isNull) eval(ctx *evalContext) (interface{}, error) {
+	v, err := n.target.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return (v == nil) != n.not, nil
+}
+
+func (n *isNull) String() string {
+	if n.not {
+		return "(" + n.target.String() + " IS NOT NULL)"
+	}
+	return "(" + n.target.String() + " IS NULL)"
+}
+
+func (n *isNull) children() []*node {
+	return []*node{&n.target}
+}
+
+// aggregate is an aggregate function call. Its argument is evaluated for every event of the group, the aggregate
+// itself evaluates to the result of the group.
+type aggregate struct {
+	name  string
+	arg   node
+	star  bool
+	index int
+}
+
+func (n *aggregate) eval(ctx *evalContext) (interface{}, error) {
+	if ctx.aggregates == nil {
+		return nil, fmt.Errorf("aggregate function %s is not allowed here", n.name)
+	}
+	return ctx.aggregates[n.index], nil
+}
+
+func (n *aggregate) String() string {
+	if n.star {
+		return n.name + "(*)"
+	}
+	return n.name + "(" + n.arg.String() + ")"
+}
+
+// children doesn't return the argument, it is evaluated on the events and never rewritten.
+func (n *aggregate) children() []*node {
+	return nil
+}
+
+// walk calls visit for the node and all its sub-expressions, aggregate arguments included.
+func walk(n node, visit func(n node) error) error {
+	if err := visit(n); err != nil {
+		return err
+	}
+	if a, ok := n.(*aggregate); ok && a.arg != nil {
+		return walk(a.arg, visit)
+	}
+	for _, child := range n.children() {
+		if err := walk(*child, visit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "NULL"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case time.Time:
+		return "timestamp"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func toBool(v interface{}) (*bool, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		return &v, nil
+	}
+	return nil, fmt.Errorf("expected a boolean, got %s %s", typeName(v), FormatValue(v))
+}
+
+func toNumber(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		if number, err := strconv.ParseFloat(v, 64); err == nil {
+			return number, nil
+		}
+	}
+	return 0, fmt.Errorf("expected a number, got %s %s", typeName(v), FormatValue(v))
+}
+
+// timeLayouts are the layouts strings are compared with timestamps in.
+var timeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", "2006-01-02 15:04", "2006-01-02"}
+
+func toTime(s string) (time.Time, bool) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// compare returns -1, 0 or 1 when a is less than, equal to or greater than b. Strings are converted to the type of
+// the other value, so that code = '403' and requestReceivedTimestamp > '2021-09-01 10:00' work.
+func compare(a, b interface{}) (int, bool) {
+	switch a := a.(type) {
+	case float64:
+		switch b := b.(type) {
+		case float64:
+			return compareOrdered(a, b), true
+		case string:
+			if number, err := strconv.ParseFloat(b, 64); err == nil {
+				return compareOrdered(a, number), true
+			}
+		}
+	case string:
+		switch b := b.(type) {
+		case string:
+			return strings.Compare(a, b), true
+		case float64, time.Time:
+			c, ok := compare(b, a)
+			return -c, ok
+		}
+	case time.Time:
+		switch b := b.(type) {
+		case time.Time:
+			return compareOrdered(float64(a.Sub(b)), 0), true
+		case string:
+			if t, ok := toTime(b); ok {
+				return compareOrdered(float64(a.Sub(t)), 0), true
+			}
+		}
+	case bool:
+		if b, ok := b.(bool); ok {
+			switch {
+			case a == b:
+				return 0, true
+			case !a:
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, false
+}
+
+func compareOrdered(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// sortCompare orders any two values: NULL after all other values and values that can't be compared by their type.
+func sortCompare(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	if c, ok := compare(a, b); ok {
+		return c
+	}
+	return strings.Compare(typeName(a), typeName(b))
+}
+
+// FormatValue returns the text of a result value, NULL is the empty string.
+func FormatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+			return strconv.FormatInt(int64(v), 10)
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return v
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
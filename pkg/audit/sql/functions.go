@@ -0,0 +1,156 @@
+package sql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+
+	"github.com/natamm4/audit-tool/pkg/audit/provenance"
+)
+
+// column is a column of the events table.
+type column struct {
+	name  string
+	value func(e *auditv1.Event) interface{}
+}
+
+func objectRefValue(value func(ref *auditv1.ObjectReference) string) func(e *auditv1.Event) interface{} {
+	return func(e *auditv1.Event) interface{} {
+		if e.ObjectRef == nil {
+			return nil
+		}
+		return value(e.ObjectRef)
+	}
+}
+
+// columns are the columns of the events table, in the order of SELECT *. The object reference columns are NULL for
+// non-resource requests, the code is NULL for events without a response status.
+var columns = []column{
+	{"requestReceivedTimestamp", func(e *auditv1.Event) interface{} { return e.RequestReceivedTimestamp.Time.UTC() }},
+	{"stageTimestamp", func(e *auditv1.Event) interface{} { return e.StageTimestamp.Time.UTC() }},
+	{"latency", func(e *auditv1.Event) interface{} {
+		return float64(e.StageTimestamp.Sub(e.RequestReceivedTimestamp.Time)) / float64(time.Millisecond)
+	}},
+	{"auditID", func(e *auditv1.Event) interface{} { return string(e.AuditID) }},
+	{"cluster", func(e *auditv1.Event) interface{} { return provenance.Cluster(e) }},
+	{"node", func(e *auditv1.Event) interface{} {
+		origin, _ := provenance.Get(e)
+		return origin.Node
+	}},
+	{"level", func(e *auditv1.Event) interface{} { return string(e.Level) }},
+	{"stage", func(e *auditv1.Event) interface{} { return string(e.Stage) }},
+	{"verb", func(e *auditv1.Event) interface{} { return e.Verb }},
+	{"code", func(e *auditv1.Event) interface{} {
+		if e.ResponseStatus == nil {
+			return nil
+		}
+		return float64(e.ResponseStatus.Code)
+	}},
+	{"user", func(e *auditv1.Event) interface{} { return e.User.Username }},
+	{"userAgent", func(e *auditv1.Event) interface{} { return e.UserAgent }},
+	{"sourceIP", func(e *auditv1.Event) interface{} {
+		if len(e.SourceIPs) == 0 {
+			return nil
+		}
+		return e.SourceIPs[0]
+	}},
+	{"namespace", objectRefValue(func(ref *auditv1.ObjectReference) string { return ref.Namespace })},
+	{"apiGroup", objectRefValue(func(ref *auditv1.ObjectReference) string { return ref.APIGroup })},
+	{"apiVersion", objectRefValue(func(ref *auditv1.ObjectReference) string { return ref.APIVersion })},
+	{"resource", objectRefValue(func(ref *auditv1.ObjectReference) string { return ref.Resource })},
+	{"subresource", objectRefValue(func(ref *auditv1.ObjectReference) string { return ref.Subresource })},
+	{"name", objectRefValue(func(ref *auditv1.ObjectReference) string { return ref.Name })},
+	{"requestURI", func(e *auditv1.Event) interface{} { return e.RequestURI }},
+}
+
+// Columns returns the names of the columns of the events table.
+func Columns() []string {
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.name
+	}
+	return names
+}
+
+func findColumn(name string) *column {
+	for i := range columns {
+		if strings.EqualFold(columns[i].name, name) {
+			return &columns[i]
+		}
+	}
+	return nil
+}
+
+// aggregates are the aggregate functions.
+var aggregates = map[string]bool{"count": true, "sum": true, "avg": true, "min": true, "max": true}
+
+// Functions returns the names of the aggregate functions.
+func Functions() []string {
+	names := []string{}
+	for name := range aggregates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// aggregateState accumulates the values of an aggregate for a group.
+type aggregateState struct {
+	aggregate *aggregate
+	count     int
+	sum       float64
+	extreme   interface{}
+}
+
+func newAggregateState(a *aggregate) *aggregateState {
+	return &aggregateState{aggregate: a}
+}
+
+func (s *aggregateState) add(v interface{}) error {
+	if v == nil {
+		return nil
+	}
+	s.count++
+	switch s.aggregate.name {
+	case "sum", "avg":
+		number, err := toNumber(v)
+		if err != nil {
+			return fmt.Errorf("%s: %v", s.aggregate.name, err)
+		}
+		s.sum += number
+	case "min", "max":
+		if s.extreme == nil {
+			s.extreme = v
+			return nil
+		}
+		c, ok := compare(v, s.extreme)
+		if !ok {
+			return fmt.Errorf("%s: cannot compare %s and %s", s.aggregate.name, typeName(v), typeName(s.extreme))
+		}
+		if c < 0 && s.aggregate.name == "min" || c > 0 && s.aggregate.name == "max" {
+			s.extreme = v
+		}
+	}
+	return nil
+}
+
+func (s *aggregateState) result() interface{} {
+	switch s.aggregate.name {
+	case "count":
+		return float64(s.count)
+	case "sum":
+		if s.count == 0 {
+			return nil
+		}
+		return s.sum
+	case "avg":
+		if s.count == 0 {
+			return nil
+		}
+		return s.sum / float64(s.count)
+	}
+	return s.extreme
+}
@@ -0,0 +1,101 @@
+package sql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenQuotedIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value interface{}
+	pos   int
+}
+
+// operators are matched longest first.
+var operators = []string{"<>", "!=", "<=", ">=", "||", "=", "<", ">", "+", "-", "*", "/", "%", "(", ")", ",", ";"}
+
+func isIdentRune(r rune, first bool) bool {
+	return r == '_' || unicode.IsLetter(r) || (!first && unicode.IsDigit(r))
+}
+
+func tokenize(statement string) ([]token, error) {
+	tokens := []token{}
+	for i := 0; i < len(statement); {
+		c := rune(statement[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case strings.HasPrefix(statement[i:], "--"):
+			for i < len(statement) && statement[i] != '\n' {
+				i++
+			}
+		case isIdentRune(c, true):
+			start := i
+			for i < len(statement) && isIdentRune(rune(statement[i]), false) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: statement[start:i], pos: start})
+		case unicode.IsDigit(c) || c == '.' && i+1 < len(statement) && unicode.IsDigit(rune(statement[i+1])):
+			start := i
+			for i < len(statement) && (unicode.IsDigit(rune(statement[i])) || statement[i] == '.' || statement[i] == 'e' || statement[i] == 'E') {
+				i++
+			}
+			value, err := strconv.ParseFloat(statement[start:i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at %d", statement[start:i], start)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: statement[start:i], value: value, pos: start})
+		case c == '\'' || c == '"':
+			// 'string' and "identifier", the quote is escaped by doubling it
+			start := i
+			value := &strings.Builder{}
+			for i++; ; i++ {
+				if i >= len(statement) {
+					return nil, fmt.Errorf("unterminated %c at %d", c, start)
+				}
+				if rune(statement[i]) == c {
+					if i+1 < len(statement) && rune(statement[i+1]) == c {
+						i++
+					} else {
+						break
+					}
+				}
+				value.WriteByte(statement[i])
+			}
+			i++
+			kind := tokenString
+			if c == '"' {
+				kind = tokenQuotedIdent
+			}
+			tokens = append(tokens, token{kind: kind, text: statement[start:i], value: value.String(), pos: start})
+		default:
+			matched := false
+			for _, operator := range operators {
+				if strings.HasPrefix(statement[i:], operator) {
+					tokens = append(tokens, token{kind: tokenOperator, text: operator, pos: i})
+					i += len(operator)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at %d", c, i)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(statement)}), nil
+}
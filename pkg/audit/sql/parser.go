@@ -0,0 +1,388 @@
+package sql
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// reserved are the keywords that can't be used as column names or aliases without quoting them, also those of the
+// clauses outside of the supported subset.
+var reserved = map[string]bool{
+	"select": true, "distinct": true, "from": true, "where": true, "group": true, "by": true, "having": true,
+	"order": true, "asc": true, "desc": true, "limit": true, "as": true, "and": true, "or": true, "not": true,
+	"like": true, "ilike": true, "in": true, "is": true, "null": true, "true": true, "false": true, "between": true,
+	"case": true, "when": true, "then": true, "else": true, "end": true,
+}
+
+type selectItem struct {
+	expression node
+	name       string
+}
+
+type orderItem struct {
+	expression node
+	descending bool
+	// output is the index of the select item ordered by, when ordered by its position or alias, -1 otherwise
+	output int
+}
+
+// statement is a parsed SELECT statement.
+type statement struct {
+	// star is set for SELECT *, the items are all columns then
+	star    bool
+	items   []selectItem
+	where   node
+	groupBy []node
+	orderBy []orderItem
+	limit   int
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	// aggregates are the aggregate calls in the order they are parsed
+	aggregates []*aggregate
+}
+
+func parse(text string) (*statement, []*aggregate, error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := &parser{tokens: tokens}
+	s, err := p.statement()
+	if err != nil {
+		return nil, nil, err
+	}
+	p.accept(";")
+	if next := p.peek(); next.kind != tokenEOF {
+		return nil, nil, fmt.Errorf("unexpected %q at %d", next.text, next.pos)
+	}
+	return s, p.aggregates, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// isKeyword is true when the token is the operator or the keyword, keywords are case insensitive.
+func isKeyword(t token, text string) bool {
+	return t.kind == tokenOperator && t.text == text || t.kind == tokenIdent && strings.EqualFold(t.text, text)
+}
+
+// accept consumes the next token when it is the operator or keyword.
+func (p *parser) accept(text string) bool {
+	if isKeyword(p.peek(), text) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		t := p.peek()
+		if t.kind == tokenEOF {
+			return fmt.Errorf("expected %s at the end", strings.ToUpper(text))
+		}
+		return fmt.Errorf("expected %s at %d, got %q", strings.ToUpper(text), t.pos, t.text)
+	}
+	return nil
+}
+
+// identifier returns the next token when it is an identifier that isn't a keyword or a quoted identifier.
+func (p *parser) identifier() (string, bool) {
+	t := p.peek()
+	switch {
+	case t.kind == tokenQuotedIdent:
+		p.pos++
+		return t.value.(string), true
+	case t.kind == tokenIdent && !reserved[strings.ToLower(t.text)]:
+		p.pos++
+		return t.text, true
+	}
+	return "", false
+}
+
+func (p *parser) statement() (*statement, error) {
+	if err := p.expect("select"); err != nil {
+		return nil, err
+	}
+	s := &statement{limit: -1}
+	if p.accept("*") {
+		s.star = true
+	} else {
+		for {
+			expression, err := p.selectExpression()
+			if err != nil {
+				return nil, err
+			}
+			item := selectItem{expression: expression, name: expression.String()}
+			if c, ok := expression.(*columnRef); ok {
+				item.name = c.name
+			}
+			if p.accept("as") {
+				name, ok := p.identifier()
+				if !ok {
+					return nil, fmt.Errorf("expected an alias after AS at %d", p.peek().pos)
+				}
+				item.name = name
+			} else if name, ok := p.identifier(); ok {
+				item.name = name
+			}
+			s.items = append(s.items, item)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+
+	if err := p.expect("from"); err != nil {
+		return nil, err
+	}
+	table, ok := p.identifier()
+	if !ok || !strings.EqualFold(table, "events") {
+		return nil, fmt.Errorf("only the events table can be queried, got %q", p.tokens[p.pos-1].text)
+	}
+
+	var err error
+	if p.accept("where") {
+		if s.where, err = p.expression(); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept("group") {
+		if err := p.expect("by"); err != nil {
+			return nil, err
+		}
+		for {
+			expression, err := p.operand()
+			if err != nil {
+				return nil, err
+			}
+			s.groupBy = append(s.groupBy, expression)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	if p.accept("order") {
+		if err := p.expect("by"); err != nil {
+			return nil, err
+		}
+		for {
+			expression, err := p.operand()
+			if err != nil {
+				return nil, err
+			}
+			item := orderItem{expression: expression, output: -1}
+			if p.accept("desc") {
+				item.descending = true
+			} else {
+				p.accept("asc")
+			}
+			s.orderBy = append(s.orderBy, item)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	if p.accept("limit") {
+		t := p.next()
+		limit, ok := t.value.(float64)
+		if t.kind != tokenNumber || !ok || limit < 0 || limit != math.Trunc(limit) {
+			return nil, fmt.Errorf("LIMIT must be a positive integer, got %q", t.text)
+		}
+		s.limit = int(limit)
+	}
+	return s, nil
+}
+
+// selectExpression parses a column or an aggregate function of columns.
+func (p *parser) selectExpression() (node, error) {
+	t := p.peek()
+	if t.kind != tokenIdent || !isKeyword(p.tokens[p.pos+1], "(") {
+		return p.operand()
+	}
+	p.pos += 2
+	name := strings.ToLower(t.text)
+	if !aggregates[name] {
+		return nil, fmt.Errorf("unknown function %s at %d, the functions are %s", name, t.pos, strings.Join(Functions(), ", "))
+	}
+	a := &aggregate{name: name}
+	if name == "count" && p.accept("*") {
+		a.star = true
+	} else {
+		pos := p.peek().pos
+		arg, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := arg.(*columnRef); !ok {
+			return nil, fmt.Errorf("expected a column as argument of %s at %d", name, pos)
+		}
+		a.arg = arg
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	a.index = len(p.aggregates)
+	p.aggregates = append(p.aggregates, a)
+	return a, nil
+}
+
+func (p *parser) expression() (node, error) {
+	return p.or()
+}
+
+func (p *parser) or() (node, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("or") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = &binary{operator: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) and() (node, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("and") {
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = &binary{operator: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) not() (node, error) {
+	if p.accept("not") {
+		operand, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return &negation{operand: operand}, nil
+	}
+	return p.predicate()
+}
+
+// predicate parses a condition in parentheses or a comparison of a column or a literal with a literal or a column,
+// [NOT] LIKE, [NOT] IN or IS [NOT] NULL.
+func (p *parser) predicate() (node, error) {
+	if p.accept("(") {
+		expression, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		return expression, p.expect(")")
+	}
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	for _, operator := range []string{"=", "<>", "!=", "<=", ">=", "<", ">"} {
+		if p.accept(operator) {
+			right, err := p.operand()
+			if err != nil {
+				return nil, err
+			}
+			return &binary{operator: operator, left: left, right: right}, nil
+		}
+	}
+
+	if p.accept("is") {
+		not := p.accept("not")
+		if err := p.expect("null"); err != nil {
+			return nil, err
+		}
+		return &isNull{target: left, not: not}, nil
+	}
+	not := p.accept("not")
+	switch {
+	case p.accept("like"):
+		pattern, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		return &like{target: left, pattern: pattern, not: not}, nil
+	case p.accept("in"):
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		list := []node{}
+		for {
+			element, err := p.operand()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, element)
+			if !p.accept(",") {
+				break
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return &in{target: left, list: list, not: not}, nil
+	case not:
+		return nil, fmt.Errorf("expected LIKE or IN after NOT at %d", p.peek().pos)
+	}
+	t := p.peek()
+	if t.kind == tokenEOF {
+		return nil, fmt.Errorf("expected a comparison at the end")
+	}
+	return nil, fmt.Errorf("expected a comparison at %d, got %q", t.pos, t.text)
+}
+
+// operand parses a literal or a column, a minus sign is only allowed before a number.
+func (p *parser) operand() (node, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokenNumber, t.kind == tokenString:
+		p.pos++
+		return &literal{value: t.value}, nil
+	case p.accept("-"):
+		number := p.next()
+		if value, ok := number.value.(float64); ok && number.kind == tokenNumber {
+			return &literal{value: -value}, nil
+		}
+		return nil, fmt.Errorf("expected a number after - at %d", t.pos)
+	case p.accept("null"):
+		return &literal{}, nil
+	case p.accept("true"):
+		return &literal{value: true}, nil
+	case p.accept("false"):
+		return &literal{value: false}, nil
+	}
+
+	name, ok := p.identifier()
+	if !ok {
+		if t.kind == tokenEOF {
+			return nil, fmt.Errorf("unexpected end of the statement")
+		}
+		return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+	}
+	if isKeyword(p.peek(), "(") {
+		return nil, fmt.Errorf("unexpected function %s at %d, aggregate functions are only allowed in the select list", name, t.pos)
+	}
+	return &columnRef{name: name}, nil
+}
@@ -0,0 +1,343 @@
+// Package sql runs SELECT statements over audit events, for users who think in SQL rather than in filter flags. The
+// events are a single table named events, with a column per key field of the event (see Columns), eg.
+//
+//	SELECT user, count(*) FROM events WHERE code = 403 GROUP BY 1 ORDER BY 2 DESC
+//	SELECT resource, verb, max(latency) AS slowest FROM events WHERE namespace LIKE 'openshift-%' GROUP BY resource, verb
+//	SELECT requestReceivedTimestamp, user, name FROM events WHERE verb = 'delete' AND resource IN ('secrets', 'configmaps')
+//
+// The statements are a subset of SQL:
+//
+//	SELECT * | item [[AS] alias], ... FROM events [WHERE condition] [GROUP BY operand, ...]
+//	  [ORDER BY operand [ASC | DESC], ...] [LIMIT n]
+//
+// The items are columns and the aggregate functions count(*) and count, sum, avg, min and max of a column. The
+// conditions are comparisons (= <> != < <= > >=) of columns and literals, [NOT] LIKE, [NOT] IN (operand, ...) and
+// IS [NOT] NULL, combined by AND, OR, NOT and parentheses. GROUP BY and ORDER BY take columns, aliases or positions of
+// the select list. Anything else, eg. DISTINCT, HAVING, arithmetic, scalar functions, joins and subqueries, is a parse
+// error. The events are streamed through the statement, only the groups of grouped statements and the selected rows
+// are kept in memory.
+package sql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+// Query is a compiled statement and the rows it selected from the events added so far.
+type Query struct {
+	statement *statement
+	// grouped is set when the statement groups or aggregates the events
+	grouped    bool
+	aggregates []*aggregate
+
+	rows   []*row
+	groups map[string]*group
+	// order holds the groups in the order they were seen, so that unordered results are stable
+	order []*group
+}
+
+// row is a selected row and the values it is ordered by.
+type row struct {
+	values    []interface{}
+	orderKeys []interface{}
+}
+
+type group struct {
+	keys   []interface{}
+	states []*aggregateState
+}
+
+// Compile parses the statement and checks its columns, functions and grouping.
+func Compile(text string) (*Query, error) {
+	s, aggregates, err := parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SQL statement: %v", err)
+	}
+	q := &Query{statement: s, aggregates: aggregates, groups: map[string]*group{}}
+	if err := q.compile(); err != nil {
+		return nil, fmt.Errorf("invalid SQL statement: %v", err)
+	}
+	return q, nil
+}
+
+func (q *Query) compile() error {
+	s := q.statement
+	if s.star {
+		for i := range columns {
+			s.items = append(s.items, selectItem{expression: &columnRef{name: columns[i].name}, name: columns[i].name})
+		}
+	}
+
+	// resolve the columns, GROUP BY and ORDER BY may refer to the select items by alias
+	aliases := map[string]int{}
+	for i, item := range s.items {
+		aliases[strings.ToLower(item.name)] = i
+	}
+	resolve := func(n node) error {
+		return walk(n, func(n node) error {
+			if c, ok := n.(*columnRef); ok && c.column == nil {
+				if c.column = findColumn(c.name); c.column == nil {
+					return fmt.Errorf("unknown column %q, the columns are %s", c.name, strings.Join(Columns(), ", "))
+				}
+			}
+			return nil
+		})
+	}
+	// selectItemRef returns the index of the select item a GROUP BY or ORDER BY expression refers to by position or
+	// alias, -1 otherwise. Columns take precedence over aliases of the same name.
+	selectItemRef := func(n node) (int, error) {
+		switch n := n.(type) {
+		case *literal:
+			if position, ok := n.value.(float64); ok {
+				if position < 1 || int(position) > len(s.items) || position != float64(int(position)) {
+					return 0, fmt.Errorf("position %s is not in the select list", FormatValue(position))
+				}
+				return int(position) - 1, nil
+			}
+		case *columnRef:
+			if i, ok := aliases[strings.ToLower(n.name)]; ok && findColumn(n.name) == nil {
+				return i, nil
+			}
+		}
+		return -1, nil
+	}
+
+	for _, item := range s.items {
+		if err := resolve(item.expression); err != nil {
+			return err
+		}
+	}
+	if s.where != nil {
+		if err := resolve(s.where); err != nil {
+			return err
+		}
+	}
+	for i, n := range s.groupBy {
+		ref, err := selectItemRef(n)
+		if err != nil {
+			return err
+		}
+		if ref >= 0 {
+			s.groupBy[i] = s.items[ref].expression
+		} else if err := resolve(n); err != nil {
+			return err
+		}
+	}
+	for i := range s.orderBy {
+		ref, err := selectItemRef(s.orderBy[i].expression)
+		if err != nil {
+			return err
+		}
+		if s.orderBy[i].output = ref; ref < 0 {
+			if err := resolve(s.orderBy[i].expression); err != nil {
+				return err
+			}
+		}
+	}
+
+	q.grouped = len(s.groupBy) > 0 || len(q.aggregates) > 0
+	if !q.grouped {
+		return nil
+	}
+	if s.star {
+		return fmt.Errorf("SELECT * can't be grouped")
+	}
+
+	// the expressions evaluated per group may only use the GROUP BY expressions outside of aggregates
+	keys := map[string]int{}
+	for i, n := range s.groupBy {
+		keys[n.String()] = i
+	}
+	for i := range s.items {
+		if err := groupExpression(&s.items[i].expression, keys); err != nil {
+			return err
+		}
+	}
+	for i := range s.orderBy {
+		if s.orderBy[i].output < 0 {
+			if err := groupExpression(&s.orderBy[i].expression, keys); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// groupExpression replaces the GROUP BY expressions within the expression by their group key, any column left
+// outside of an aggregate isn't grouped by.
+func groupExpression(n *node, keys map[string]int) error {
+	text := (*n).String()
+	if i, ok := keys[text]; ok {
+		*n = &groupKey{index: i, text: text}
+		return nil
+	}
+	if c, ok := (*n).(*columnRef); ok {
+		return fmt.Errorf("column %q must appear in the GROUP BY clause or be used in an aggregate function", c.name)
+	}
+	for _, child := range (*n).children() {
+		if err := groupExpression(child, keys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Columns returns the names of the result columns.
+func (q *Query) Columns() []string {
+	names := make([]string, len(q.statement.items))
+	for i, item := range q.statement.items {
+		names[i] = item.name
+	}
+	return names
+}
+
+// Add runs the statement over the event. The event is not retained.
+func (q *Query) Add(e *auditv1.Event) error {
+	s := q.statement
+	ctx := &evalContext{event: e}
+	if s.where != nil {
+		v, err := s.where.eval(ctx)
+		if err != nil {
+			return err
+		}
+		accepted, err := toBool(v)
+		if err != nil {
+			return fmt.Errorf("WHERE: %v", err)
+		}
+		if accepted == nil || !*accepted {
+			return nil
+		}
+	}
+
+	if !q.grouped {
+		// without ordering the first rows are the result
+		if len(s.orderBy) == 0 && s.limit >= 0 && len(q.rows) >= s.limit {
+			return nil
+		}
+		r, err := q.row(ctx)
+		if err != nil || r == nil {
+			return err
+		}
+		q.rows = append(q.rows, r)
+		return nil
+	}
+
+	keys := make([]interface{}, len(s.groupBy))
+	groupID := &strings.Builder{}
+	for i, n := range s.groupBy {
+		v, err := n.eval(ctx)
+		if err != nil {
+			return err
+		}
+		keys[i] = v
+		groupID.WriteString(typeName(v))
+		groupID.WriteByte(':')
+		groupID.WriteString(FormatValue(v))
+		groupID.WriteByte(0)
+	}
+	g, ok := q.groups[groupID.String()]
+	if !ok {
+		g = q.newGroup(keys)
+		q.groups[groupID.String()] = g
+	}
+	for _, state := range g.states {
+		a := state.aggregate
+		if a.star {
+			state.add(true)
+			continue
+		}
+		v, err := a.arg.eval(ctx)
+		if err != nil {
+			return err
+		}
+		if err := state.add(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q *Query) newGroup(keys []interface{}) *group {
+	g := &group{keys: keys}
+	for _, a := range q.aggregates {
+		g.states = append(g.states, newAggregateState(a))
+	}
+	q.order = append(q.order, g)
+	return g
+}
+
+// row evaluates the select list and the ORDER BY expressions.
+func (q *Query) row(ctx *evalContext) (*row, error) {
+	r := &row{values: make([]interface{}, len(q.statement.items))}
+	for i, item := range q.statement.items {
+		v, err := item.expression.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		r.values[i] = v
+	}
+	for _, item := range q.statement.orderBy {
+		if item.output >= 0 {
+			r.orderKeys = append(r.orderKeys, r.values[item.output])
+			continue
+		}
+		v, err := item.expression.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		r.orderKeys = append(r.orderKeys, v)
+	}
+	return r, nil
+}
+
+// Result returns the selected rows, once all events are added.
+func (q *Query) Result() ([][]interface{}, error) {
+	s := q.statement
+	rows := q.rows
+	if q.grouped {
+		// without GROUP BY all events are a single group, even when there are none
+		if len(s.groupBy) == 0 && len(q.order) == 0 {
+			q.newGroup(nil)
+		}
+		rows = nil
+		for _, g := range q.order {
+			ctx := &evalContext{keys: g.keys, aggregates: make([]interface{}, len(g.states))}
+			for i, state := range g.states {
+				ctx.aggregates[i] = state.result()
+			}
+			r, err := q.row(ctx)
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, r)
+		}
+	}
+
+	if len(s.orderBy) > 0 {
+		sort.SliceStable(rows, func(i, j int) bool {
+			for k, item := range s.orderBy {
+				c := sortCompare(rows[i].orderKeys[k], rows[j].orderKeys[k])
+				if c == 0 {
+					continue
+				}
+				if item.descending {
+					return c > 0
+				}
+				return c < 0
+			}
+			return false
+		})
+	}
+	if s.limit >= 0 && len(rows) > s.limit {
+		rows = rows[:s.limit]
+	}
+
+	result := make([][]interface{}, len(rows))
+	for i, r := range rows {
+		result[i] = r.values
+	}
+	return result, nil
+}
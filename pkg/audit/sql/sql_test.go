@@ -0,0 +1,245 @@
+package sql
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+// testEvents returns the events of the queries, the request of dave is a non-resource request without a response
+// status and nodes are cluster scoped.
+func testEvents() []*auditv1.Event {
+	start := time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC)
+	rows := []struct {
+		id, user, verb, resource, namespace string
+		code                                int32
+		latency, received                   time.Duration
+	}{
+		{"e01", "alice", "get", "pods", "prod", 200, 5 * time.Millisecond, 0},
+		{"e02", "alice", "list", "pods", "prod", 200, 40 * time.Millisecond, 10 * time.Second},
+		{"e03", "alice", "delete", "secrets", "prod", 403, 3 * time.Millisecond, 20 * time.Second},
+		{"e04", "bob", "get", "pods", "dev", 200, 7 * time.Millisecond, 65 * time.Second},
+		{"e05", "bob", "get", "pods", "dev", 404, 2 * time.Millisecond, 70 * time.Second},
+		{"e06", "bob", "update", "configmaps", "dev", 409, 12 * time.Millisecond, 130 * time.Second},
+		{"e07", "carol", "get", "secrets", "prod", 403, 1 * time.Millisecond, 135 * time.Second},
+		{"e08", "carol", "watch", "nodes", "", 200, 900 * time.Millisecond, 140 * time.Second},
+		{"e09", "system:kube-scheduler", "create", "bindings", "kube-system", 201, 6 * time.Millisecond, 185 * time.Second},
+		{"e10", "system:kube-scheduler", "get", "leases", "kube-system", 200, 4 * time.Millisecond, 190 * time.Second},
+		{"e11", "system:kube-scheduler", "update", "leases", "kube-system", 500, 25 * time.Millisecond, 245 * time.Second},
+		{"e12", "dave", "get", "", "", 0, 0, 250 * time.Second},
+	}
+	events := []*auditv1.Event{}
+	for _, row := range rows {
+		e := &auditv1.Event{
+			AuditID:                  types.UID(row.id),
+			Stage:                    auditv1.StageResponseComplete,
+			Verb:                     row.verb,
+			RequestURI:               "/healthz",
+			RequestReceivedTimestamp: metav1.NewMicroTime(start.Add(row.received)),
+			StageTimestamp:           metav1.NewMicroTime(start.Add(row.received + row.latency)),
+		}
+		e.User.Username = row.user
+		if len(row.resource) > 0 {
+			e.ObjectRef = &auditv1.ObjectReference{Resource: row.resource, Namespace: row.namespace}
+			e.RequestURI = "/api/v1/" + row.resource
+		}
+		if row.code != 0 {
+			e.ResponseStatus = &metav1.Status{Code: row.code}
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+// run runs the statement over the test events and returns the rows as the values separated by | .
+func run(t *testing.T, text string) ([]string, error) {
+	q, err := Compile(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range testEvents() {
+		if err := q.Add(e); err != nil {
+			return nil, err
+		}
+	}
+	rows, err := q.Result()
+	if err != nil {
+		return nil, err
+	}
+	result := []string{}
+	for _, row := range rows {
+		values := []string{}
+		for _, v := range row {
+			values = append(values, FormatValue(v))
+		}
+		result = append(result, strings.Join(values, "|"))
+	}
+	return result, nil
+}
+
+// The expected rows of the standard SQL statements match SQLite's over the same table, except that NULL sorts like in
+// PostgreSQL, last and first when descending.
+func TestQuery(t *testing.T) {
+	tests := []struct {
+		statement string
+		expected  []string
+	}{
+		// GROUP BY and aggregates
+		{
+			statement: `SELECT user, count(*) FROM events GROUP BY 1 ORDER BY 2 DESC, 1`,
+			expected:  []string{"alice|3", "bob|3", "system:kube-scheduler|3", "carol|2", "dave|1"},
+		},
+		{
+			statement: `SELECT resource, count(*) AS n, sum(latency), avg(latency), min(latency), max(latency) FROM events WHERE resource IS NOT NULL GROUP BY resource ORDER BY n DESC, resource`,
+			expected:  []string{"pods|4|54|13.5|2|40", "leases|2|29|14.5|4|25", "secrets|2|4|2|1|3", "bindings|1|6|6|6|6", "configmaps|1|12|12|12|12", "nodes|1|900|900|900|900"},
+		},
+		{
+			statement: `SELECT count(*), count(code), count(namespace) FROM events`,
+			expected:  []string{"12|11|11"},
+		},
+		{
+			statement: `SELECT namespace, verb, count(*) FROM events GROUP BY namespace, verb ORDER BY 3 DESC, 1, 2 LIMIT 3`,
+			expected:  []string{"dev|get|2", "prod|get|2", "|watch|1"},
+		},
+		{
+			// the maximum code of dave is NULL
+			statement: `SELECT user, max(code) AS highest FROM events GROUP BY user ORDER BY highest DESC, user LIMIT 2`,
+			expected:  []string{"dave|", "system:kube-scheduler|500"},
+		},
+		// aggregates without GROUP BY are a single row, even without events, grouped statements have no rows then
+		{statement: `SELECT count(*), sum(latency), max(user) FROM events WHERE verb = 'patch'`, expected: []string{"0||"}},
+		{statement: `SELECT user, count(*) FROM events WHERE verb = 'patch' GROUP BY user`, expected: []string{}},
+
+		// ORDER BY
+		{statement: `SELECT code, count(*) FROM events GROUP BY code ORDER BY code`, expected: []string{"200|5", "201|1", "403|2", "404|1", "409|1", "500|1", "|1"}},
+		{statement: `SELECT code FROM events ORDER BY code DESC LIMIT 3`, expected: []string{"", "500", "409"}},
+		{statement: `SELECT verb, sum(latency) FROM events GROUP BY verb ORDER BY 2 DESC LIMIT 2`, expected: []string{"watch|900", "list|40"}},
+		{statement: `SELECT user, verb FROM events WHERE code >= 400 AND code < 500 ORDER BY latency DESC`, expected: []string{"bob|update", "alice|delete", "bob|get", "carol|get"}},
+		{statement: `SELECT auditID FROM events WHERE latency >= 5 AND latency <= 12 ORDER BY latency, auditID`, expected: []string{"e01", "e09", "e04", "e06"}},
+		{statement: `SELECT auditID, user FROM events ORDER BY user DESC, 1 LIMIT 3`, expected: []string{"e09|system:kube-scheduler", "e10|system:kube-scheduler", "e11|system:kube-scheduler"}},
+		// unordered rows are in the order of the events, and of their groups
+		{statement: `SELECT auditID FROM events LIMIT 2`, expected: []string{"e01", "e02"}},
+		{statement: `SELECT verb FROM events GROUP BY verb`, expected: []string{"get", "list", "delete", "update", "watch", "create"}},
+
+		// WHERE
+		{statement: `SELECT auditID FROM events WHERE user LIKE 'system:%' AND verb IN ('get', 'update') ORDER BY auditID`, expected: []string{"e10", "e11"}},
+		{statement: `SELECT auditID FROM events WHERE verb NOT IN ('get', 'list', 'watch') AND resource NOT LIKE 'config%' ORDER BY auditID`, expected: []string{"e03", "e09", "e11"}},
+		{statement: `SELECT auditID FROM events WHERE NOT (code = 200 OR code IS NULL) AND latency > 3 ORDER BY auditID`, expected: []string{"e06", "e09", "e11"}},
+		{statement: `SELECT auditID FROM events WHERE code = 200 OR code = 403 AND user = 'carol' ORDER BY auditID`, expected: []string{"e01", "e02", "e04", "e07", "e08", "e10"}},
+		{statement: `SELECT count(*) FROM events WHERE namespace = NULL`, expected: []string{"0"}},
+		{statement: `SELECT auditID FROM events WHERE user LIKE 'ali_e' AND verb <> 'get'`, expected: []string{"e02", "e03"}},
+		{statement: `SELECT auditID FROM events WHERE 400 <= code AND code != -1 AND requestReceivedTimestamp > '2026-10-01 10:02:00' ORDER BY 1`, expected: []string{"e06", "e07", "e11"}},
+		{statement: `SELECT count(*) FROM events;`, expected: []string{"12"}},
+		{statement: `SELECT 'literal', 42, user FROM events WHERE auditID = 'e06'`, expected: []string{"literal|42|bob"}},
+	}
+	for _, test := range tests {
+		t.Run(test.statement, func(t *testing.T) {
+			rows, err := run(t, test.statement)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(rows, "\n") != strings.Join(test.expected, "\n") {
+				t.Errorf("expected\n%s\ngot\n%s", strings.Join(test.expected, "\n"), strings.Join(rows, "\n"))
+			}
+		})
+	}
+}
+
+func TestQueryColumns(t *testing.T) {
+	tests := []struct {
+		statement string
+		expected  []string
+	}{
+		{statement: `SELECT user, count(*) AS n, count(verb), max(latency) slowest FROM events GROUP BY user`, expected: []string{"user", "n", "count(verb)", "slowest"}},
+		{statement: `SELECT * FROM events`, expected: Columns()},
+	}
+	for _, test := range tests {
+		q, err := Compile(test.statement)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(q.Columns(), ",") != strings.Join(test.expected, ",") {
+			t.Errorf("%s: expected the columns %v, got %v", test.statement, test.expected, q.Columns())
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		statement string
+		err       string
+	}{
+		{statement: `SELECT user, count(*) FROM events`, err: `column "user" must appear in the GROUP BY clause`},
+		{statement: `SELECT user, verb, count(*) FROM events GROUP BY user`, err: `column "verb" must appear in the GROUP BY clause`},
+		{statement: `SELECT user FROM events GROUP BY user ORDER BY latency`, err: `column "latency" must appear in the GROUP BY clause`},
+		{statement: `SELECT user FROM events WHERE count(*) > 1`, err: "unexpected function count at 30, aggregate functions are only allowed in the select list"},
+		{statement: `SELECT count(*) FROM events GROUP BY count(*)`, err: "unexpected function count at 37"},
+		{statement: `SELECT sum(count(*)) FROM events`, err: "unexpected function count at 11, aggregate functions are only allowed in the select list"},
+		{statement: `SELECT * FROM events GROUP BY user`, err: "SELECT * can't be grouped"},
+		{statement: `SELECT user FROM events ORDER BY 2`, err: "position 2 is not in the select list"},
+		{statement: `SELECT user, count(*) FROM events GROUP BY 0`, err: "position 0 is not in the select list"},
+		{statement: `SELECT users FROM events`, err: `unknown column "users"`},
+		{statement: `SELECT user FROM pods`, err: `only the events table can be queried, got "pods"`},
+		{statement: `SELECT trim(user) FROM events`, err: "unknown function trim at 7, the functions are avg, count, max, min, sum"},
+		{statement: `SELECT user FROM events LIMIT -1`, err: "LIMIT must be a positive integer"},
+		{statement: `SELECT user FROM events WHERE user NOT = 'bob'`, err: "expected LIKE or IN after NOT"},
+		{statement: `SELECT user FROM events WHERE`, err: "unexpected end of the statement"},
+		{statement: `SELECT user FROM events WHERE user`, err: "expected a comparison at the end"},
+		{statement: `SELECT user FROM events WHERE NOT user ORDER BY 1`, err: `expected a comparison at 39, got "ORDER"`},
+		{statement: `SELECT user FROM events WHERE user = 'bob`, err: "unterminated ' at 37"},
+		{statement: `SELECT user FROM events extra`, err: `unexpected "extra"`},
+		{statement: `SELECT user FROM events; SELECT verb FROM events`, err: `unexpected "SELECT" at 25`},
+
+		// outside of the supported subset of SQL
+		{statement: `SELECT DISTINCT user FROM events`, err: `unexpected "DISTINCT" at 7`},
+		{statement: `SELECT count(DISTINCT user) FROM events`, err: `unexpected "DISTINCT" at 13`},
+		{statement: `SELECT count(1) FROM events`, err: "expected a column as argument of count at 13"},
+		{statement: `SELECT user, count(*) FROM events GROUP BY user HAVING count(*) > 2`, err: `unexpected "HAVING" at 48`},
+		{statement: `SELECT CASE WHEN code >= 500 THEN 'server' END FROM events`, err: `unexpected "CASE" at 7`},
+		{statement: `SELECT user FROM events WHERE latency BETWEEN 5 AND 12`, err: `expected a comparison at 38, got "BETWEEN"`},
+		{statement: `SELECT user FROM events WHERE user ILIKE 'ALICE'`, err: `expected a comparison at 35, got "ILIKE"`},
+		{statement: `SELECT latency * 2 FROM events`, err: `expected FROM at 15, got "*"`},
+		{statement: `SELECT user || verb FROM events`, err: `expected FROM at 12, got "||"`},
+		{statement: `SELECT user FROM events WHERE latency + 1 > 2`, err: `expected a comparison at 38, got "+"`},
+		{statement: `SELECT user FROM events WHERE -latency < 0`, err: "expected a number after - at 30"},
+		{statement: `SELECT lower(user) FROM events`, err: "unknown function lower at 7"},
+		{statement: `SELECT quantile(latency, 0.9) FROM events`, err: "unknown function quantile at 7"},
+		{statement: `SELECT max(latency, 1) FROM events`, err: `expected ) at 18, got ","`},
+		{statement: `SELECT user FROM events WHERE lower(user) = 'bob'`, err: "unexpected function lower at 30"},
+		{statement: `SELECT user FROM events WHERE user IN (SELECT user FROM events)`, err: `unexpected "SELECT" at 39`},
+		{statement: `SELECT user FROM events JOIN events`, err: `unexpected "JOIN" at 24`},
+		{statement: `SELECT user FROM events ORDER BY latency * 2`, err: `unexpected "*" at 41`},
+	}
+	for _, test := range tests {
+		t.Run(test.statement, func(t *testing.T) {
+			_, err := Compile(test.statement)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected an error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	tests := []struct {
+		statement string
+		err       string
+	}{
+		{statement: `SELECT user FROM events WHERE user > 1`, err: "cannot compare string and number"},
+		{statement: `SELECT sum(user) FROM events`, err: "sum: expected a number, got string alice"},
+		{statement: `SELECT user FROM events WHERE code < requestReceivedTimestamp`, err: "cannot compare number and timestamp"},
+		{statement: `SELECT user FROM events WHERE user LIKE code`, err: "LIKE pattern must be a string"},
+	}
+	for _, test := range tests {
+		t.Run(test.statement, func(t *testing.T) {
+			_, err := run(t, test.statement)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected an error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package topk
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// Item is a key with its (estimated) number of occurrences.
+type Item struct {
+	Key   string
+	Count int64
+	// Error is the maximum overestimation of Count, it is always zero for exact counters.
+	Error int64
+}
+
+// Counter counts occurrences of keys and reports the most frequent ones.
+type Counter interface {
+	Add(key string)
+	// Top returns the k most frequent keys, sorted by count.
+	Top(k int) []Item
+	// Total returns the number of keys added.
+	Total() int64
+	// Get returns the count of the key, false when it isn't tracked.
+	Get(key string) (Item, bool)
+}
+
+// Exact counts every distinct key, memory grows with the number of distinct keys.
+type Exact struct {
+	counts map[string]int64
+	total  int64
+}
+
+func NewExact() *Exact {
+	return &Exact{counts: map[string]int64{}}
+}
+
+func (c *Exact) Add(key string) {
+	c.counts[key]++
+	c.total++
+}
+
+func (c *Exact) Total() int64 {
+	return c.total
+}
+
+func (c *Exact) Get(key string) (Item, bool) {
+	count, ok := c.counts[key]
+	return Item{Key: key, Count: count}, ok
+}
+
+func (c *Exact) Top(k int) []Item {
+	items := make([]Item, 0, len(c.counts))
+	for key, count := range c.counts {
+		items = append(items, Item{Key: key, Count: count})
+	}
+	return topOf(items, k)
+}
+
+// SpaceSaving estimates the heavy hitters in a single pass with memory bounded by capacity, using the Space-Saving
+// algorithm (Metwally et al.). Any key occurring more than Total()/capacity times is guaranteed to be reported and
+// the reported count overestimates the real one by at most Item.Error.
+type SpaceSaving struct {
+	capacity int
+	index    map[string]*spaceSavingEntry
+	entries  spaceSavingHeap
+	total    int64
+}
+
+type spaceSavingEntry struct {
+	Item
+	position int
+}
+
+func NewSpaceSaving(capacity int) *SpaceSaving {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &SpaceSaving{
+		capacity: capacity,
+		index:    make(map[string]*spaceSavingEntry, capacity),
+		entries:  make(spaceSavingHeap, 0, capacity),
+	}
+}
+
+func (c *SpaceSaving) Add(key string) {
+	c.total++
+	if entry, ok := c.index[key]; ok {
+		entry.Count++
+		heap.Fix(&c.entries, entry.position)
+		return
+	}
+	if len(c.entries) < c.capacity {
+		entry := &spaceSavingEntry{Item: Item{Key: key, Count: 1}}
+		heap.Push(&c.entries, entry)
+		c.index[key] = entry
+		return
+	}
+
+	// replace the least frequent key, the new key inherits its count as the error bound
+	min := c.entries[0]
+	delete(c.index, min.Key)
+	min.Key = key
+	min.Error = min.Count
+	min.Count++
+	c.index[key] = min
+	heap.Fix(&c.entries, 0)
+}
+
+func (c *SpaceSaving) Total() int64 {
+	return c.total
+}
+
+// Get returns the estimated count of the key, false when it isn't tracked. A key that isn't tracked occurred at most
+// Saturated() times.
+func (c *SpaceSaving) Get(key string) (Item, bool) {
+	entry, ok := c.index[key]
+	if !ok {
+		return Item{Key: key}, false
+	}
+	return entry.Item, true
+}
+
+// Saturated returns the count of the least frequent tracked key once all capacity is used, keys were evicted then.
+// It is zero while the counts are exact.
+func (c *SpaceSaving) Saturated() int64 {
+	if len(c.entries) < c.capacity {
+		return 0
+	}
+	return c.entries[0].Count
+}
+
+func (c *SpaceSaving) Top(k int) []Item {
+	items := make([]Item, 0, len(c.entries))
+	for _, entry := range c.entries {
+		items = append(items, entry.Item)
+	}
+	return topOf(items, k)
+}
+
+func topOf(items []Item, k int) []Item {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count == items[j].Count {
+			return items[i].Key < items[j].Key
+		}
+		return items[i].Count > items[j].Count
+	})
+	if k > 0 && len(items) > k {
+		items = items[:k]
+	}
+	return items
+}
+
+// spaceSavingHeap is a min-heap of entries ordered by count.
+type spaceSavingHeap []*spaceSavingEntry
+
+func (h spaceSavingHeap) Len() int           { return len(h) }
+func (h spaceSavingHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+func (h spaceSavingHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].position = i
+	h[j].position = j
+}
+
+func (h *spaceSavingHeap) Push(x interface{}) {
+	entry := x.(*spaceSavingEntry)
+	entry.position = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *spaceSavingHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	*h = old[:n-1]
+	return entry
+}
@@ -0,0 +1,122 @@
+package topk
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func add(c Counter, keys ...string) Counter {
+	for _, key := range keys {
+		c.Add(key)
+	}
+	return c
+}
+
+func TestTop(t *testing.T) {
+	keys := []string{"b", "a", "c", "a", "b", "a", "d"}
+	tests := []struct {
+		name     string
+		counter  Counter
+		k        int
+		expected []Item
+	}{
+		// ties are sorted by key
+		{name: "exact", counter: NewExact(), k: 3, expected: []Item{{Key: "a", Count: 3}, {Key: "b", Count: 2}, {Key: "c", Count: 1}}},
+		{name: "exact all", counter: NewExact(), k: 0, expected: []Item{{Key: "a", Count: 3}, {Key: "b", Count: 2}, {Key: "c", Count: 1}, {Key: "d", Count: 1}}},
+		{name: "exact more than the keys", counter: NewExact(), k: 10, expected: []Item{{Key: "a", Count: 3}, {Key: "b", Count: 2}, {Key: "c", Count: 1}, {Key: "d", Count: 1}}},
+		{name: "space-saving within capacity", counter: NewSpaceSaving(4), k: 3, expected: []Item{{Key: "a", Count: 3}, {Key: "b", Count: 2}, {Key: "c", Count: 1}}},
+		// d replaces c, the least frequent key, and inherits its count as error
+		{name: "space-saving evicting", counter: NewSpaceSaving(3), k: 0, expected: []Item{{Key: "a", Count: 3}, {Key: "b", Count: 2}, {Key: "d", Count: 2, Error: 1}}},
+		{name: "space-saving capacity of one", counter: NewSpaceSaving(0), k: 0, expected: []Item{{Key: "d", Count: 7, Error: 6}}},
+	}
+	for _, test := range tests {
+		c := add(test.counter, keys...)
+		if actual := c.Top(test.k); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, actual)
+		}
+		if c.Total() != int64(len(keys)) {
+			t.Errorf("%s: expected a total of %d, got %d", test.name, len(keys), c.Total())
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	exact := add(NewExact(), "a", "a", "b")
+	spaceSaving := add(NewSpaceSaving(2), "a", "a", "b", "c")
+	tests := []struct {
+		name     string
+		counter  Counter
+		key      string
+		expected Item
+		tracked  bool
+	}{
+		{name: "exact", counter: exact, key: "a", expected: Item{Key: "a", Count: 2}, tracked: true},
+		{name: "exact missing", counter: exact, key: "c", expected: Item{Key: "c"}},
+		{name: "space-saving", counter: spaceSaving, key: "a", expected: Item{Key: "a", Count: 2}, tracked: true},
+		{name: "space-saving replaced", counter: spaceSaving, key: "c", expected: Item{Key: "c", Count: 2, Error: 1}, tracked: true},
+		{name: "space-saving evicted", counter: spaceSaving, key: "b", expected: Item{Key: "b"}},
+	}
+	for _, test := range tests {
+		actual, tracked := test.counter.Get(test.key)
+		if actual != test.expected || tracked != test.tracked {
+			t.Errorf("%s: expected %v tracked %v, got %v tracked %v", test.name, test.expected, test.tracked, actual, tracked)
+		}
+	}
+}
+
+func TestSaturated(t *testing.T) {
+	c := NewSpaceSaving(3)
+	add(c, "a", "a", "b")
+	if saturated := c.Saturated(); saturated != 0 {
+		t.Errorf("expected exact counts below the capacity, got %d", saturated)
+	}
+	add(c, "c", "c", "d")
+	// d replaced b, the least frequent tracked key counted 2
+	if saturated := c.Saturated(); saturated != 2 {
+		t.Errorf("expected the least frequent key to count 2, got %d", saturated)
+	}
+}
+
+// TestSpaceSavingGuarantees checks the bounds of the estimation on a skewed stream: every key occurring more than
+// total/capacity times is tracked, and the counts overestimate by at most their error.
+func TestSpaceSavingGuarantees(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for _, capacity := range []int{10, 50, 200} {
+		exact := map[string]int64{}
+		c := NewSpaceSaving(capacity)
+		for i := 0; i < 20000; i++ {
+			// a few heavy hitters and a long tail of rare keys
+			key := fmt.Sprintf("user-%d", random.Intn(5))
+			if random.Intn(3) == 0 {
+				key = fmt.Sprintf("tail-%d", random.Intn(5000))
+			}
+			exact[key]++
+			c.Add(key)
+		}
+		for key, count := range exact {
+			item, tracked := c.Get(key)
+			if !tracked {
+				if count > c.Total()/int64(capacity) {
+					t.Errorf("capacity %d: expected %s occurring %d times to be tracked", capacity, key, count)
+				}
+				if count > c.Saturated() {
+					t.Errorf("capacity %d: %s occurred %d times, more than the least frequent tracked key %d", capacity, key, count, c.Saturated())
+				}
+				continue
+			}
+			if item.Count < count || item.Count-item.Error > count {
+				t.Errorf("capacity %d: %s occurred %d times, estimated %d with an error of %d", capacity, key, count, item.Count, item.Error)
+			}
+		}
+		top := c.Top(5)
+		for _, item := range top {
+			if !strings.HasPrefix(item.Key, "user-") {
+				t.Errorf("capacity %d: expected the users to be the top keys, got %v", capacity, top)
+				break
+			}
+		}
+	}
+}
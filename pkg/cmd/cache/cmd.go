@@ -0,0 +1,43 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/cmd/get"
+	"github.com/natamm4/audit-tool/pkg/querycache"
+)
+
+func NewCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Manage the cached query results",
+		Long: "Manage the cached query results. Running a query caches which events its filters accepted, keyed by the\n" +
+			"audit files and the filter flags, so repeating it with other output options only decodes these events. The\n" +
+			"cache is stored in the user cache directory unless AUDIT_TOOL_CACHE_DIR is set.",
+		Run: func(cmd *cobra.Command, args []string) {
+			dir, err := querycache.Dir()
+			cmdutil.CheckErr(err)
+			fmt.Fprintf(streams.Out, "Query results are cached in %s\n", dir)
+		},
+	}
+
+	cmd.AddCommand(newCleanCommand(streams))
+	return cmd
+}
+
+func newCleanCommand(streams genericclioptions.IOStreams) *cobra.Command {
+	return &cobra.Command{
+		Use:   "clean",
+		Short: "Remove all cached query results",
+		Run: func(cmd *cobra.Command, args []string) {
+			removed, size, err := querycache.Clean()
+			cmdutil.CheckErr(err)
+			fmt.Fprintf(streams.Out, "Removed %d cached queries (%s)\n", removed, get.FormatSize(size))
+		},
+	}
+}
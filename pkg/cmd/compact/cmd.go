@@ -0,0 +1,298 @@
+package compact
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/klauspost/compress/zstd"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/decompress"
+	"github.com/natamm4/audit-tool/pkg/audit/provenance"
+	"github.com/natamm4/audit-tool/pkg/cmd/get"
+	"github.com/natamm4/audit-tool/pkg/cmd/query"
+)
+
+type Options struct {
+	outputDirectory string
+
+	// queryOptions selects the audit files to compact
+	queryOptions query.Options
+
+	genericclioptions.IOStreams
+}
+
+func NewCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &Options{IOStreams: streams, queryOptions: query.Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "compact --dir DIR --output-dir DIR",
+		Short: "Merge many small rotated audit files into per-node, per-hour chunks sorted by timestamp",
+		Long: "Merge many small rotated audit files into per-node, per-hour chunks sorted by timestamp. The chunks are\n" +
+			"compressed with zstd and named like rotated audit files after their latest event\n" +
+			"(eg. master-0-audit-2021-09-01T10-59-59.998.log.zst), so query reads a compacted directory like any other.\n" +
+			"The chunks of several clusters are written to a subdirectory per cluster. Events of one hour are sorted in memory.",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	options.queryOptions.AddTimeRangeFlags(cmd.Flags(), "compact audit files")
+	options.queryOptions.AddNodeFlag(cmd.Flags(), "compact the audit files")
+	options.queryOptions.AddMaxEventSizeFlag(cmd.Flags())
+	cmd.Flags().StringVar(&options.outputDirectory, "output-dir", "", "Directory to write the compacted chunks to, must differ from the directories read.")
+
+	return cmd
+}
+
+func (o *Options) Validate() error {
+	if len(o.queryOptions.TargetDirectories()) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	if len(o.outputDirectory) == 0 {
+		return fmt.Errorf("output directory must be specified (--output-dir)")
+	}
+	output, err := filepath.Abs(o.outputDirectory)
+	if err != nil {
+		return err
+	}
+	for _, dir := range o.queryOptions.TargetDirectories() {
+		input, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		if input == output {
+			return fmt.Errorf("output directory %q must differ from the directories read", o.outputDirectory)
+		}
+	}
+	return nil
+}
+
+// compactLine is an audit log line with the timestamps it is sorted by.
+type compactLine struct {
+	received metav1.MicroTime
+	stage    metav1.MicroTime
+	data     []byte
+}
+
+// compactChunk collects the lines of one node and hour in an uncompressed temporary file until they are sorted.
+type compactChunk struct {
+	cluster string
+	node    string
+	hour    time.Time
+	path    string
+}
+
+func (c *compactChunk) name() string {
+	return fmt.Sprintf("%s/%s/%s", c.cluster, c.node, c.hour.Format(time.RFC3339))
+}
+
+func (o *Options) Run(ctx context.Context) error {
+	tmpDir, err := os.MkdirTemp("", "audit-tool-compact-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	chunks := map[string]*compactChunk{}
+	files, lines, skipped := 0, 0, 0
+	if err := o.queryOptions.ForEachAuditFile(ctx, func(origin provenance.Provenance, r io.Reader) error {
+		files++
+		read, invalid, err := o.splitByHour(r, origin, tmpDir, chunks)
+		lines += read
+		skipped += invalid
+		return err
+	}); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(o.outputDirectory, os.ModePerm); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(chunks))
+	for name := range chunks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var written int64
+	for _, name := range names {
+		size, err := o.writeChunk(chunks[name])
+		if err != nil {
+			return err
+		}
+		written += size
+	}
+
+	if skipped > 0 {
+		log.Printf("skipped %d audit log lines without a valid timestamp", skipped)
+	}
+	fmt.Fprintf(o.Out, "Compacted %d events of %d audit files into %d chunks (%s) in %s\n", lines-skipped, files, len(chunks), get.FormatSize(written), o.outputDirectory)
+	return nil
+}
+
+// splitByHour appends the lines of an audit file to the temporary file of their node and hour. Rotated files are
+// written in order, so a file usually touches one or two hours and the temporary files are closed after every file.
+func (o *Options) splitByHour(r io.Reader, origin provenance.Provenance, tmpDir string, chunks map[string]*compactChunk) (int, int, error) {
+	auditReader, err := decompress.NewReader(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer auditReader.Close()
+
+	writers := map[string]*bufio.Writer{}
+	openFiles := []*os.File{}
+	defer func() {
+		for _, f := range openFiles {
+			f.Close()
+		}
+	}()
+
+	scanner := bufio.NewScanner(auditReader)
+	scanner.Buffer(make([]byte, query.InitialScanBufferSize), o.queryOptions.MaxEventSize())
+	lines, skipped := 0, 0
+	for scanner.Scan() {
+		lines++
+		line := scanner.Bytes()
+		received, _, err := compactTimestamps(line)
+		if err != nil {
+			skipped++
+			continue
+		}
+		chunk := &compactChunk{cluster: origin.Cluster, node: origin.Node, hour: received.UTC().Truncate(time.Hour)}
+		name := chunk.name()
+		if existing, ok := chunks[name]; ok {
+			chunk = existing
+		} else {
+			chunk.path = filepath.Join(tmpDir, fmt.Sprintf("%d", len(chunks)))
+			chunks[name] = chunk
+		}
+		w, ok := writers[name]
+		if !ok {
+			f, err := os.OpenFile(chunk.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+			if err != nil {
+				return lines, skipped, err
+			}
+			openFiles = append(openFiles, f)
+			w = bufio.NewWriter(f)
+			writers[name] = w
+		}
+		if _, err := w.Write(line); err != nil {
+			return lines, skipped, err
+		}
+		if err := w.WriteByte('\n'); err != nil {
+			return lines, skipped, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return lines, skipped, err
+	}
+	for _, w := range writers {
+		if err := w.Flush(); err != nil {
+			return lines, skipped, err
+		}
+	}
+	return lines, skipped, nil
+}
+
+// writeChunk sorts the lines of a chunk by timestamp and writes them compressed with zstd next to the chunks of the same cluster,
+// returning the compressed size.
+func (o *Options) writeChunk(chunk *compactChunk) (int64, error) {
+	in, err := os.Open(chunk.path)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	lines := []compactLine{}
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, query.InitialScanBufferSize), o.queryOptions.MaxEventSize())
+	for scanner.Scan() {
+		received, stage, err := compactTimestamps(scanner.Bytes())
+		if err != nil {
+			continue
+		}
+		lines = append(lines, compactLine{received: received, stage: stage, data: append([]byte(nil), scanner.Bytes()...)})
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	if len(lines) == 0 {
+		return 0, nil
+	}
+	sort.SliceStable(lines, func(i, j int) bool {
+		if !lines[i].received.Equal(&lines[j].received) {
+			return lines[i].received.Before(&lines[j].received)
+		}
+		return lines[i].stage.Before(&lines[j].stage)
+	})
+
+	// like rotated audit files, the chunk is named after the time its last event was written
+	latest := lines[0].stage.Time
+	for _, line := range lines {
+		if line.stage.After(latest) {
+			latest = line.stage.Time
+		}
+	}
+	dir := filepath.Join(o.outputDirectory, chunk.cluster)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return 0, err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s-audit-%s.log.zst", chunk.node, latest.UTC().Format("2006-01-02T15-04-05.000")))
+	out, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+	zstdWriter, err := zstd.NewWriter(out)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range lines {
+		if _, err := zstdWriter.Write(line.data); err != nil {
+			return 0, err
+		}
+		if _, err := zstdWriter.Write([]byte{'\n'}); err != nil {
+			return 0, err
+		}
+	}
+	if err := zstdWriter.Close(); err != nil {
+		return 0, err
+	}
+	info, err := out.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// compactTimestamps decodes only the timestamps of an audit log line.
+func compactTimestamps(line []byte) (metav1.MicroTime, metav1.MicroTime, error) {
+	var timestamps struct {
+		RequestReceivedTimestamp metav1.MicroTime `json:"requestReceivedTimestamp"`
+		StageTimestamp           metav1.MicroTime `json:"stageTimestamp"`
+	}
+	if err := jsoniter.ConfigDefault.Unmarshal(line, &timestamps); err != nil {
+		return metav1.MicroTime{}, metav1.MicroTime{}, err
+	}
+	if timestamps.RequestReceivedTimestamp.IsZero() {
+		return metav1.MicroTime{}, metav1.MicroTime{}, fmt.Errorf("missing requestReceivedTimestamp")
+	}
+	if timestamps.StageTimestamp.IsZero() {
+		timestamps.StageTimestamp = timestamps.RequestReceivedTimestamp
+	}
+	return timestamps.RequestReceivedTimestamp, timestamps.StageTimestamp, nil
+}
@@ -0,0 +1,216 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/klog/v2"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/runstats"
+)
+
+// reportTimeFormat names the report of a run after the time it started at.
+const reportTimeFormat = "2006-01-02T15-04-05"
+
+type Options struct {
+	configFile  string
+	once        bool
+	debugListen string
+
+	// executable is the audit-tool binary running the jobs
+	executable string
+	config     *Config
+	// metrics of the daemon served on --debug-listen, nil when not set
+	metrics *selfMetrics
+
+	genericclioptions.IOStreams
+}
+
+func NewCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &Options{IOStreams: streams}
+	cmd := &cobra.Command{
+		Use:   "daemon --config FILE",
+		Short: "Run get, queries, checks and exports on a schedule as a continuous audit service",
+		Long: "Run get, queries, checks and exports on a schedule as a continuous audit service. The jobs of the\n" +
+			"configuration file are audit-tool commands with a cron-like schedule, eg. a get refreshing the audit logs\n" +
+			"every 15 minutes and a nightly check posting its violations to a webhook. The jobs due at the same time run\n" +
+			"one after another in the order of the configuration, so that the logs are downloaded before they are queried.\n" +
+			"The output of every run is written to the report directory and the success and duration of the runs, and\n" +
+			"optionally their OpenMetrics output, are pushed to a Prometheus Pushgateway.",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmdutil.CheckErr(options.Validate())
+			cmdutil.CheckErr(options.Complete())
+			cmdutil.CheckErr(options.Run(ctx))
+		},
+	}
+
+	cmd.Flags().StringVar(&options.configFile, "config", "", "Configuration file with the jobs to run (YAML).")
+	cmd.Flags().BoolVar(&options.once, "once", false, "Run every job once and exit instead of following the schedules, eg. to test the configuration.")
+	cmd.Flags().StringVar(&options.debugListen, "debug-listen", "", "Address to serve the pprof profiles (/debug/pprof/) and the Prometheus metrics of the daemon (/metrics) on, eg. localhost:6060: the runs of the jobs, the audit events they decoded and failed to decode, and the memory of the daemon.")
+
+	return cmd
+}
+
+func (o *Options) Validate() error {
+	if len(o.configFile) == 0 {
+		return fmt.Errorf("configuration file must be specified (--config)")
+	}
+	return nil
+}
+
+func (o *Options) Complete() error {
+	var err error
+	if o.config, err = loadConfig(o.configFile); err != nil {
+		return err
+	}
+	if o.executable, err = os.Executable(); err != nil {
+		return fmt.Errorf("unable to locate the audit-tool binary running the jobs: %v", err)
+	}
+	if len(o.debugListen) > 0 {
+		o.metrics = newSelfMetrics()
+	}
+	return nil
+}
+
+// Run runs the jobs when they are due until the daemon is interrupted.
+func (o *Options) Run(ctx context.Context) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if o.metrics != nil {
+		if err := serveDebug(ctx, o.debugListen, o.metrics); err != nil {
+			return err
+		}
+	}
+
+	if o.once {
+		failed := 0
+		for _, job := range o.config.Jobs {
+			if !o.runJob(ctx, job) {
+				failed++
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d job(s) failed", failed, len(o.config.Jobs))
+		}
+		return nil
+	}
+
+	next := make([]time.Time, len(o.config.Jobs))
+	for i, job := range o.config.Jobs {
+		next[i] = job.schedule.next(time.Now())
+	}
+	for {
+		earliest := next[0]
+		for _, t := range next[1:] {
+			if t.Before(earliest) {
+				earliest = t
+			}
+		}
+		klog.V(2).Infof("Next job runs at %s", earliest.Format(time.RFC3339))
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Until(earliest)):
+		}
+		for i, job := range o.config.Jobs {
+			if next[i].After(time.Now()) {
+				continue
+			}
+			o.runJob(ctx, job)
+			if ctx.Err() != nil {
+				return nil
+			}
+			next[i] = job.schedule.next(time.Now())
+		}
+	}
+}
+
+// runJob runs the command of the job, writes its output to the report and pushes the metrics of the run. Failures
+// are logged, the daemon keeps running the jobs.
+func (o *Options) runJob(ctx context.Context, job Job) bool {
+	started := time.Now()
+	var out io.Writer = o.Out
+	if len(o.config.ReportDir) > 0 {
+		report, err := o.createReport(job, started)
+		if err != nil {
+			klog.Errorf("Job %q not run: %v", job.Name, err)
+			return false
+		}
+		defer report.Close()
+		out = report
+	}
+	output := &bytes.Buffer{}
+	if job.PushOutput {
+		out = io.MultiWriter(out, output)
+	}
+
+	cmd := exec.CommandContext(ctx, o.executable, job.Args...)
+	cmd.Stdout = out
+	cmd.Stderr = o.ErrOut
+	statsFile := ""
+	if o.metrics != nil {
+		var err error
+		if statsFile, err = createStatsFile(); err != nil {
+			klog.Errorf("Job %q: unable to collect the counters of the run: %v", job.Name, err)
+		} else {
+			defer os.Remove(statsFile)
+			cmd.Env = append(os.Environ(), runstats.Env+"="+statsFile)
+		}
+	}
+	err := cmd.Run()
+	duration := time.Since(started)
+	if err != nil {
+		klog.Errorf("Job %q failed after %s: %v", job.Name, duration.Round(time.Millisecond), err)
+	} else {
+		klog.Infof("Job %q succeeded in %s", job.Name, duration.Round(time.Millisecond))
+	}
+
+	if o.metrics != nil {
+		stats := runstats.Stats{}
+		if len(statsFile) > 0 {
+			// a job killed before it exited doesn't write them
+			var readErr error
+			if stats, readErr = runstats.Read(statsFile); readErr != nil {
+				klog.V(2).Infof("Job %q didn't write the counters of the run: %v", job.Name, readErr)
+			}
+		}
+		o.metrics.record(job, duration, err == nil, stats)
+	}
+
+	if len(o.config.Pushgateway) > 0 {
+		if pushErr := pushMetrics(ctx, o.config.Pushgateway, job, started, duration, err == nil, output.Bytes()); pushErr != nil {
+			klog.Errorf("Job %q: %v", job.Name, pushErr)
+		}
+	}
+	return err == nil
+}
+
+// createStatsFile creates the file a job writes the counters of its run to.
+func createStatsFile() (string, error) {
+	f, err := os.CreateTemp("", "audit-tool-run-*.json")
+	if err != nil {
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+// createReport creates the file the output of a run of the job is written to, REPORTDIR/JOB/TIME.out.
+func (o *Options) createReport(job Job, started time.Time) (*os.File, error) {
+	dir := filepath.Join(o.config.ReportDir, job.Name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(filepath.Join(dir, started.UTC().Format(reportTimeFormat)+".out"))
+}
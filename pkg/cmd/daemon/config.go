@@ -0,0 +1,83 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/yaml"
+)
+
+// Config is the daemon configuration file, eg.:
+//
+//	reportDir: /var/lib/audit-tool/reports
+//	pushgateway: http://pushgateway:9091
+//	jobs:
+//	- name: download
+//	  schedule: "@every 15m"
+//	  args: [get, -o, /var/lib/audit-tool/audit, --incremental]
+//	- name: error-rate
+//	  schedule: "0 2 * * *"
+//	  args: [query, check, -d, /var/lib/audit-tool/audit, --max-error-rate, "1", --notify-url, https://hooks.example.com/audit]
+//	- name: requests
+//	  schedule: "*/5 * * * *"
+//	  args: [query, -d, /var/lib/audit-tool/audit, -o, openmetricsCount]
+//	  pushOutput: true
+type Config struct {
+	// ReportDir is the directory the output of every run is written to, as JOB/TIME.out. The output is written to the
+	// standard output of the daemon when not set.
+	ReportDir string `json:"reportDir,omitempty"`
+	// Pushgateway is the URL of the Prometheus Pushgateway the metrics of the runs are pushed to.
+	Pushgateway string `json:"pushgateway,omitempty"`
+	Jobs        []Job  `json:"jobs"`
+}
+
+// Job is an audit-tool command run on a schedule.
+type Job struct {
+	Name string `json:"name"`
+	// Schedule is a cron expression, eg. "0 2 * * *", a shortcut like @daily or @every DURATION.
+	Schedule string `json:"schedule"`
+	// Args are the arguments of audit-tool, eg. [query, check, -d, DIR, --max-error-rate, "1"].
+	Args []string `json:"args"`
+	// PushOutput pushes the output of the job to the Pushgateway together with the metrics of the run, for commands
+	// writing OpenMetrics like query -o openmetricsCount.
+	PushOutput bool `json:"pushOutput,omitempty"`
+
+	schedule schedule
+}
+
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("invalid daemon configuration %q: %v", path, err)
+	}
+	if len(config.Jobs) == 0 {
+		return nil, fmt.Errorf("invalid daemon configuration %q: no jobs", path)
+	}
+	names := sets.NewString()
+	for i := range config.Jobs {
+		job := &config.Jobs[i]
+		if len(job.Name) == 0 || names.Has(job.Name) {
+			return nil, fmt.Errorf("invalid daemon configuration %q: every job needs a unique name, got %q", path, job.Name)
+		}
+		names.Insert(job.Name)
+		if len(job.Args) == 0 || job.Args[0] == "daemon" {
+			return nil, fmt.Errorf("invalid daemon configuration %q: job %q needs the arguments of an audit-tool command other than daemon", path, job.Name)
+		}
+		if job.PushOutput && len(config.Pushgateway) == 0 {
+			return nil, fmt.Errorf("invalid daemon configuration %q: job %q pushes its output but no pushgateway is set", path, job.Name)
+		}
+		if job.schedule, err = parseSchedule(job.Schedule); err != nil {
+			return nil, fmt.Errorf("invalid daemon configuration %q: job %q: %v", path, job.Name, err)
+		}
+		if job.schedule.next(time.Now()).IsZero() {
+			return nil, fmt.Errorf("invalid daemon configuration %q: job %q never runs on schedule %q", path, job.Name, job.Schedule)
+		}
+	}
+	return config, nil
+}
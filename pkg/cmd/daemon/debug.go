@@ -0,0 +1,138 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/natamm4/audit-tool/pkg/audit/runstats"
+)
+
+// selfMetrics are the metrics of the daemon served on --debug-listen. The events are read by the jobs, which run as
+// separate processes and write their counters to the file of runstats.Env when they exit.
+type selfMetrics struct {
+	lock    sync.Mutex
+	started time.Time
+	jobs    map[string]*jobMetrics
+}
+
+type jobMetrics struct {
+	succeeded, failed int64
+	lastDuration      time.Duration
+	runstats.Stats
+}
+
+func newSelfMetrics() *selfMetrics {
+	return &selfMetrics{started: time.Now(), jobs: map[string]*jobMetrics{}}
+}
+
+// record adds a run of the job and the counters it wrote.
+func (m *selfMetrics) record(job Job, duration time.Duration, success bool, stats runstats.Stats) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	metrics, ok := m.jobs[job.Name]
+	if !ok {
+		metrics = &jobMetrics{}
+		m.jobs[job.Name] = metrics
+	}
+	if success {
+		metrics.succeeded++
+	} else {
+		metrics.failed++
+	}
+	metrics.lastDuration = duration
+	metrics.DecodedEvents += stats.DecodedEvents
+	metrics.DecodeErrors += stats.DecodeErrors
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format. The events processed per second are the rate of
+// audit_tool_daemon_decoded_events_total.
+func (m *selfMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	memory := runtime.MemStats{}
+	runtime.ReadMemStats(&memory)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	names := make([]string, 0, len(m.jobs))
+	for name := range m.jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP audit_tool_daemon_job_runs_total Runs of the job by result.")
+	fmt.Fprintln(w, "# TYPE audit_tool_daemon_job_runs_total counter")
+	for _, name := range names {
+		fmt.Fprintf(w, "audit_tool_daemon_job_runs_total{job=\"%s\",result=\"success\"} %d\n", escapeLabel(name), m.jobs[name].succeeded)
+		fmt.Fprintf(w, "audit_tool_daemon_job_runs_total{job=\"%s\",result=\"failure\"} %d\n", escapeLabel(name), m.jobs[name].failed)
+	}
+	fmt.Fprintln(w, "# HELP audit_tool_daemon_job_duration_seconds Duration of the last run of the job.")
+	fmt.Fprintln(w, "# TYPE audit_tool_daemon_job_duration_seconds gauge")
+	for _, name := range names {
+		fmt.Fprintf(w, "audit_tool_daemon_job_duration_seconds{job=\"%s\"} %g\n", escapeLabel(name), m.jobs[name].lastDuration.Seconds())
+	}
+	fmt.Fprintln(w, "# HELP audit_tool_daemon_decoded_events_total Audit events decoded by the runs of the job.")
+	fmt.Fprintln(w, "# TYPE audit_tool_daemon_decoded_events_total counter")
+	for _, name := range names {
+		fmt.Fprintf(w, "audit_tool_daemon_decoded_events_total{job=\"%s\"} %d\n", escapeLabel(name), m.jobs[name].DecodedEvents)
+	}
+	fmt.Fprintln(w, "# HELP audit_tool_daemon_decode_errors_total Lines of the audit files the runs of the job could not decode.")
+	fmt.Fprintln(w, "# TYPE audit_tool_daemon_decode_errors_total counter")
+	for _, name := range names {
+		fmt.Fprintf(w, "audit_tool_daemon_decode_errors_total{job=\"%s\"} %d\n", escapeLabel(name), m.jobs[name].DecodeErrors)
+	}
+	fmt.Fprintln(w, "# HELP process_start_time_seconds Start time of the daemon since the Unix epoch.")
+	fmt.Fprintln(w, "# TYPE process_start_time_seconds gauge")
+	fmt.Fprintf(w, "process_start_time_seconds %d\n", m.started.Unix())
+	fmt.Fprintln(w, "# HELP go_goroutines Number of goroutines of the daemon.")
+	fmt.Fprintln(w, "# TYPE go_goroutines gauge")
+	fmt.Fprintf(w, "go_goroutines %d\n", runtime.NumGoroutine())
+	fmt.Fprintln(w, "# HELP go_memstats_heap_alloc_bytes Heap bytes allocated and still in use by the daemon.")
+	fmt.Fprintln(w, "# TYPE go_memstats_heap_alloc_bytes gauge")
+	fmt.Fprintf(w, "go_memstats_heap_alloc_bytes %d\n", memory.HeapAlloc)
+	fmt.Fprintln(w, "# HELP go_memstats_sys_bytes Bytes of memory the daemon obtained from the OS.")
+	fmt.Fprintln(w, "# TYPE go_memstats_sys_bytes gauge")
+	fmt.Fprintf(w, "go_memstats_sys_bytes %d\n", memory.Sys)
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// serveDebug serves the pprof profiles under /debug/pprof/ and the metrics under /metrics on the address until the
+// context is done.
+func serveDebug(ctx context.Context, address string, metrics *selfMetrics) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("unable to listen on --debug-listen %s: %v", address, err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/metrics", metrics)
+	server := &http.Server{Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			klog.Errorf("Serving the debug endpoints failed: %v", err)
+		}
+	}()
+	klog.Infof("Serving pprof on http://%s/debug/pprof/ and the metrics on http://%s/metrics", listener.Addr(), listener.Addr())
+	return nil
+}
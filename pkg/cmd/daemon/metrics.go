@@ -0,0 +1,59 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// runMetrics are the metrics of a run of a job pushed to the Pushgateway, grouped by the name of the job.
+const runMetrics = `# HELP audit_tool_job_success Whether the last run of the job succeeded.
+# TYPE audit_tool_job_success gauge
+audit_tool_job_success %d
+# HELP audit_tool_job_duration_seconds Duration of the last run of the job.
+# TYPE audit_tool_job_duration_seconds gauge
+audit_tool_job_duration_seconds %g
+# HELP audit_tool_job_last_run_timestamp_seconds Time the last run of the job started at.
+# TYPE audit_tool_job_last_run_timestamp_seconds gauge
+audit_tool_job_last_run_timestamp_seconds %d
+`
+
+// pushMetrics replaces the metrics of the job in the Pushgateway with the ones of the run and the output of the job,
+// which is in the OpenMetrics or Prometheus text format.
+func pushMetrics(ctx context.Context, pushgateway string, job Job, started time.Time, duration time.Duration, success bool, output []byte) error {
+	body := &bytes.Buffer{}
+	successValue := 0
+	if success {
+		successValue = 1
+	}
+	fmt.Fprintf(body, runMetrics, successValue, duration.Seconds(), started.Unix())
+	for _, line := range strings.SplitAfter(string(output), "\n") {
+		// the Pushgateway doesn't accept the end of an OpenMetrics exposition
+		if strings.TrimSpace(line) == "# EOF" {
+			continue
+		}
+		body.WriteString(line)
+	}
+
+	u := strings.TrimSuffix(pushgateway, "/") + "/metrics/job/audit-tool/audit_tool_job/" + url.PathEscape(job.Name)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("pushing the metrics of job %q failed: %s: %s", job.Name, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package daemon
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedule returns the next time a job runs after the given time.
+type schedule interface {
+	next(after time.Time) time.Time
+}
+
+// every runs a job at a fixed interval.
+type every time.Duration
+
+func (e every) next(after time.Time) time.Time {
+	return after.Add(time.Duration(e))
+}
+
+// cronSchedule runs a job at the minutes matching all fields of a cron expression, in local time.
+type cronSchedule struct {
+	minutes, hours, days, months, weekdays uint64
+	// anyDay and anyWeekday are set when the field is *, a job runs on the days matching either field otherwise
+	anyDay, anyWeekday bool
+}
+
+// scheduleShortcuts are the cron shortcuts for the common schedules.
+var scheduleShortcuts = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// parseSchedule parses a cron expression of five fields (minute hour day-of-month month day-of-week) supporting
+// *, lists, ranges and steps, one of the @hourly, @daily, @weekly and @monthly shortcuts or @every DURATION.
+func parseSchedule(s string) (schedule, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(s, "@every ")))
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("invalid schedule %q, @every needs a duration of at least 1s", s)
+		}
+		return every(d), nil
+	}
+	if shortcut, ok := scheduleShortcuts[s]; ok {
+		s = shortcut
+	}
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q, expected five fields (minute hour day-of-month month day-of-week)", s)
+	}
+	c := &cronSchedule{anyDay: fields[2] == "*", anyWeekday: fields[4] == "*"}
+	var err error
+	for _, f := range []struct {
+		bits     *uint64
+		min, max int
+	}{
+		{&c.minutes, 0, 59},
+		{&c.hours, 0, 23},
+		{&c.days, 1, 31},
+		{&c.months, 1, 12},
+		{&c.weekdays, 0, 7},
+	} {
+		if *f.bits, err = parseCronField(fields[0], f.min, f.max); err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %v", s, err)
+		}
+		fields = fields[1:]
+	}
+	// 7 is Sunday too
+	if c.weekdays&(1<<7) != 0 {
+		c.weekdays |= 1
+	}
+	return c, nil
+}
+
+// parseCronField returns the values of a comma separated list of *, values and ranges with an optional step as bits.
+func parseCronField(field string, min, max int) (uint64, error) {
+	bits := uint64(0)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			part = part[:i]
+		}
+		first, last := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if first, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			last = first
+			if len(bounds) == 2 {
+				if last, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid range %q", part)
+				}
+			} else if step > 1 {
+				// a value with a step starts a range up to the maximum, like 5/15
+				last = max
+			}
+			if first < min || last > max || first > last {
+				return 0, fmt.Errorf("%q is out of the range %d-%d", part, min, max)
+			}
+		}
+		for v := first; v <= last; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (c *cronSchedule) next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	// the fields repeat every 4 years at the latest, except for impossible days like February 30
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.months&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hours&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minutes&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	// never, the job is effectively disabled
+	return time.Time{}
+}
+
+func (c *cronSchedule) matchesDay(t time.Time) bool {
+	day := c.days&(1<<uint(t.Day())) != 0
+	weekday := c.weekdays&(1<<uint(t.Weekday())) != 0
+	if c.anyDay || c.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
+
+// ValidateSchedule returns an error when s is not a schedule the daemon runs jobs on.
+func ValidateSchedule(s string) error {
+	schedule, err := parseSchedule(s)
+	if err != nil {
+		return err
+	}
+	if schedule.next(time.Now()).IsZero() {
+		return fmt.Errorf("schedule %q never runs", s)
+	}
+	return nil
+}
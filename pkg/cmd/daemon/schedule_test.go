@@ -0,0 +1,91 @@
+package daemon
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	// a Thursday
+	after := time.Date(2026, 10, 1, 10, 7, 30, 0, time.UTC)
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		schedule string
+		after    time.Time
+		expected time.Time
+	}{
+		{schedule: "* * * * *", after: after, expected: at(10, 1, 10, 8)},
+		{schedule: "*/15 * * * *", after: after, expected: at(10, 1, 10, 15)},
+		// a value with a step runs up to the maximum
+		{schedule: "5/20 * * * *", after: after, expected: at(10, 1, 10, 25)},
+		{schedule: "0,30 9-17 * * *", after: after, expected: at(10, 1, 10, 30)},
+		{schedule: "@hourly", after: after, expected: at(10, 1, 11, 0)},
+		// strictly after a time matching the schedule
+		{schedule: "@hourly", after: at(10, 1, 11, 0), expected: at(10, 1, 12, 0)},
+		{schedule: "@daily", after: after, expected: at(10, 2, 0, 0)},
+		{schedule: "@weekly", after: after, expected: at(10, 4, 0, 0)},
+		{schedule: "0 0 * * 7", after: after, expected: at(10, 4, 0, 0)},
+		{schedule: "@monthly", after: after, expected: at(11, 1, 0, 0)},
+		{schedule: "30 2 * * 1-5", after: at(10, 2, 3, 0), expected: at(10, 5, 2, 30)},
+		{schedule: "0 0 13 * *", after: after, expected: at(10, 13, 0, 0)},
+		// either the day of the month or the day of the week when both are restricted
+		{schedule: "0 0 13 * 5", after: after, expected: at(10, 2, 0, 0)},
+		{schedule: "0 0 13 * 5", after: at(10, 2, 0, 0), expected: at(10, 9, 0, 0)},
+		// both when one of them is *
+		{schedule: "0 0 * 11 5", after: after, expected: at(11, 6, 0, 0)},
+		{schedule: "0 0 29 2 *", after: after, expected: time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{schedule: "0 0 30 2 *", after: after, expected: time.Time{}},
+		{schedule: "@every 90s", after: after, expected: after.Add(90 * time.Second)},
+		{schedule: " @every 1h ", after: after, expected: after.Add(time.Hour)},
+	}
+	for _, test := range tests {
+		s, err := parseSchedule(test.schedule)
+		if err != nil {
+			t.Errorf("%s: %v", test.schedule, err)
+			continue
+		}
+		if actual := s.next(test.after); !actual.Equal(test.expected) {
+			t.Errorf("%s after %v: expected %v, got %v", test.schedule, test.after, test.expected, actual)
+		}
+	}
+}
+
+func TestParseScheduleInvalid(t *testing.T) {
+	tests := []struct {
+		schedule string
+		err      string
+	}{
+		{schedule: "", err: "expected five fields"},
+		{schedule: "* * * *", err: "expected five fields"},
+		{schedule: "@yearly", err: "expected five fields"},
+		{schedule: "60 * * * *", err: `"60" is out of the range 0-59`},
+		{schedule: "* 24 * * *", err: `"24" is out of the range 0-23`},
+		{schedule: "* * 0 * *", err: `"0" is out of the range 1-31`},
+		{schedule: "* * * 13 *", err: `"13" is out of the range 1-12`},
+		{schedule: "* * * * 8", err: `"8" is out of the range 0-7`},
+		{schedule: "5-1 * * * *", err: `"5-1" is out of the range 0-59`},
+		{schedule: "*/0 * * * *", err: `invalid step in "*/0"`},
+		{schedule: "a * * * *", err: `invalid value "a"`},
+		{schedule: "1-a * * * *", err: `invalid range "1-a"`},
+		{schedule: "@every 500ms", err: "at least 1s"},
+		{schedule: "@every daily", err: "at least 1s"},
+	}
+	for _, test := range tests {
+		if _, err := parseSchedule(test.schedule); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: expected an error containing %q, got %v", test.schedule, test.err, err)
+		}
+	}
+}
+
+func TestValidateSchedule(t *testing.T) {
+	if err := ValidateSchedule("0 0 30 2 *"); err == nil || !strings.Contains(err.Error(), "never runs") {
+		t.Errorf("expected a schedule on February 30 to never run, got %v", err)
+	}
+	if err := ValidateSchedule("@daily"); err != nil {
+		t.Errorf("expected @daily to be valid, got %v", err)
+	}
+}
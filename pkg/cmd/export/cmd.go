@@ -0,0 +1,198 @@
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+	"sigs.k8s.io/yaml"
+
+	"github.com/natamm4/audit-tool/pkg/cmd/query"
+)
+
+func NewCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export audit events and aggregated metrics for other tools",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+
+	cmd.AddCommand(NewGrafanaCommand(ctx, f, streams))
+	cmd.AddCommand(NewEventsCommand(ctx, f, streams))
+	cmd.AddCommand(NewParquetCommand(ctx, f, streams))
+	return cmd
+}
+
+type GrafanaOptions struct {
+	outputDirectory string
+
+	// queryOptions selects and filters the events to export
+	queryOptions query.Options
+
+	genericclioptions.IOStreams
+}
+
+func NewGrafanaCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &GrafanaOptions{IOStreams: streams, queryOptions: query.Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "grafana --dir DIR --output-dir DIR",
+		Short: "Write OpenMetrics counters, Prometheus recording rules and a ready-to-import Grafana dashboard",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	options.queryOptions.AddTimeRangeFlags(cmd.Flags(), "export events")
+	options.queryOptions.AddMaxEventSizeFlag(cmd.Flags())
+	cmd.Flags().StringVar(&options.outputDirectory, "output-dir", "", "Directory to write the metrics, recording rules and dashboard to.")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *GrafanaOptions) Validate() error {
+	if len(o.queryOptions.TargetDirectories()) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	if len(o.outputDirectory) == 0 {
+		return fmt.Errorf("output directory must be specified (--output-dir)")
+	}
+	return nil
+}
+
+const (
+	grafanaMetricsFile   = "audit-metrics.prom"
+	grafanaRulesFile     = "audit-recording-rules.yaml"
+	grafanaDashboardFile = "audit-dashboard.json"
+)
+
+func (o *GrafanaOptions) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(o.outputDirectory, os.ModePerm); err != nil {
+		return err
+	}
+
+	metrics, err := os.Create(filepath.Join(o.outputDirectory, grafanaMetricsFile))
+	if err != nil {
+		return err
+	}
+	defer metrics.Close()
+	events, err := o.queryOptions.WriteEvents(ctx, filters, query.NewOpenMetricsCountWriter(metrics), 0)
+	if err != nil {
+		return err
+	}
+
+	rules, err := yaml.Marshal(grafanaRecordingRules())
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(o.outputDirectory, grafanaRulesFile), rules, 0644); err != nil {
+		return err
+	}
+
+	dashboard, err := json.MarshalIndent(grafanaDashboard(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(o.outputDirectory, grafanaDashboardFile), dashboard, 0644); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(o.Out, "Exported %d events to %s (%s, %s, %s)\n", events, o.outputDirectory, grafanaMetricsFile, grafanaRulesFile, grafanaDashboardFile)
+	return nil
+}
+
+type recordingRule struct {
+	Record string `json:"record"`
+	Expr   string `json:"expr"`
+}
+
+// grafanaRecordingRules returns the Prometheus rules aggregating the audit_event_total series written by the
+// openmetricsCount output. The dashboard only queries these recorded series.
+func grafanaRecordingRules() map[string]interface{} {
+	rules := []recordingRule{}
+	for _, label := range []string{"user", "verb", "code"} {
+		rules = append(rules,
+			recordingRule{
+				Record: "audit:audit_event_total:sum_by_" + label,
+				Expr:   fmt.Sprintf("sum by (%s) (audit_event_total)", label),
+			},
+			recordingRule{
+				Record: "audit:audit_event_total:rate5m_by_" + label,
+				Expr:   fmt.Sprintf("sum by (%s) (rate(audit_event_total[5m]))", label),
+			},
+		)
+	}
+	rules = append(rules, recordingRule{
+		Record: "audit:audit_event_errors_total:sum_by_user",
+		Expr:   `sum by (user) (audit_event_total{code=~"5.."})`,
+	})
+	return map[string]interface{}{
+		"groups": []interface{}{
+			map[string]interface{}{
+				"name":  "audit-tool",
+				"rules": rules,
+			},
+		},
+	}
+}
+
+func grafanaPanel(id int, title, panelType, expr, legend string, x, y int) map[string]interface{} {
+	return map[string]interface{}{
+		"id":         id,
+		"title":      title,
+		"type":       panelType,
+		"datasource": map[string]string{"type": "prometheus", "uid": "${DS_PROMETHEUS}"},
+		"gridPos":    map[string]int{"h": 8, "w": 12, "x": x, "y": y},
+		"targets": []map[string]interface{}{
+			{
+				"refId":        "A",
+				"expr":         expr,
+				"legendFormat": legend,
+				"datasource":   map[string]string{"type": "prometheus", "uid": "${DS_PROMETHEUS}"},
+			},
+		},
+	}
+}
+
+// grafanaDashboard returns a dashboard in the Grafana import format, the Prometheus datasource is selected on import.
+func grafanaDashboard() map[string]interface{} {
+	return map[string]interface{}{
+		"__inputs": []map[string]string{
+			{
+				"name":     "DS_PROMETHEUS",
+				"label":    "Prometheus",
+				"type":     "datasource",
+				"pluginId": "prometheus",
+			},
+		},
+		"title":         "Kubernetes API Audit",
+		"uid":           "audit-tool",
+		"schemaVersion": 36,
+		"time":          map[string]string{"from": "now-24h", "to": "now"},
+		"tags":          []string{"audit-tool", "kubernetes", "audit"},
+		"panels": []map[string]interface{}{
+			grafanaPanel(1, "Request rate by user", "timeseries", "topk(10, audit:audit_event_total:rate5m_by_user)", "{{user}}", 0, 0),
+			grafanaPanel(2, "Request rate by verb", "timeseries", "audit:audit_event_total:rate5m_by_verb", "{{verb}}", 12, 0),
+			grafanaPanel(3, "Request rate by status code", "timeseries", "audit:audit_event_total:rate5m_by_code", "{{code}}", 0, 8),
+			grafanaPanel(4, "Top users by server errors", "bargauge", "topk(10, audit:audit_event_errors_total:sum_by_user)", "{{user}}", 12, 8),
+			grafanaPanel(5, "Top users by requests", "bargauge", "topk(10, audit:audit_event_total:sum_by_user)", "{{user}}", 0, 16),
+			grafanaPanel(6, "Requests by verb", "piechart", "audit:audit_event_total:sum_by_verb", "{{verb}}", 12, 16),
+		},
+	}
+}
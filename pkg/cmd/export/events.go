@@ -0,0 +1,254 @@
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/spf13/cobra"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/cmd/query"
+)
+
+type EventsOptions struct {
+	outputDirectory string
+	format          string
+	partitionBy     string
+	nameTemplate    string
+
+	// queryOptions selects and filters the events to export
+	queryOptions query.Options
+
+	genericclioptions.IOStreams
+}
+
+const (
+	// FormatAuditLog and FormatJSON are the formats of the files written by export events and report subject
+	FormatAuditLog = "auditlog"
+	FormatJSON     = "json"
+
+	// unpartitioned is the partition of all events when --partition-by isn't set
+	unpartitioned = "all"
+	// clusterScoped is the namespace partition of the events of cluster scoped resources and non-resource URLs
+	clusterScoped = "_cluster"
+)
+
+// exportPartitionFuncs returns the partition of an event and the time it starts at, for the --partition-by values.
+var exportPartitionFuncs = map[string]func(e *auditv1.Event) (string, time.Time){
+	"hour": func(e *auditv1.Event) (string, time.Time) {
+		start := e.RequestReceivedTimestamp.UTC().Truncate(time.Hour)
+		return start.Format("2006-01-02T15"), start
+	},
+	"day": func(e *auditv1.Event) (string, time.Time) {
+		received := e.RequestReceivedTimestamp.UTC()
+		start := time.Date(received.Year(), received.Month(), received.Day(), 0, 0, 0, 0, time.UTC)
+		return start.Format("2006-01-02"), start
+	},
+	"namespace": func(e *auditv1.Event) (string, time.Time) {
+		if e.ObjectRef == nil || len(e.ObjectRef.Namespace) == 0 {
+			return clusterScoped, time.Time{}
+		}
+		return e.ObjectRef.Namespace, time.Time{}
+	},
+}
+
+func exportPartitions() []string {
+	partitions := make([]string, 0, len(exportPartitionFuncs))
+	for partition := range exportPartitionFuncs {
+		partitions = append(partitions, partition)
+	}
+	sort.Strings(partitions)
+	return partitions
+}
+
+func NewEventsCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &EventsOptions{IOStreams: streams, queryOptions: query.Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "events --dir DIR --output-dir DIR",
+		Short: "Write the filtered events back out as audit logs or JSON, optionally split into files per hour, day or namespace",
+		Long: "Write the filtered events back out as audit logs or JSON, optionally split into files per hour, day or namespace.\n\n" +
+			"The file names are given by --name-template, a Go template executed with .Partition (the hour as 2006-01-02T15,\n" +
+			"the day as 2006-01-02, the namespace or _cluster for cluster scoped requests, \"all\" when not partitioned) and\n" +
+			".Start (the time the hour or day starts at). Names may contain directories, eg.\n" +
+			"'{{.Start.Format \"2006/01/02\"}}/audit-{{.Partition}}'. The extension of the format is appended. Audit logs\n" +
+			"are written gzipped and can be queried again.",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	options.queryOptions.AddTimeRangeFlags(cmd.Flags(), "export events")
+	options.queryOptions.AddNodeFlag(cmd.Flags(), "export the events")
+	options.queryOptions.AddMaxEventSizeFlag(cmd.Flags())
+	cmd.Flags().StringVar(&options.outputDirectory, "output-dir", "", "Directory to write the events to.")
+	cmd.Flags().StringVar(&options.format, "format", FormatAuditLog, "Format of the written files: auditlog (one event per line, gzipped) or json (an EventList).")
+	cmd.Flags().StringVar(&options.partitionBy, "partition-by", "", "Split the events into a file per "+strings.Join(exportPartitions(), ", ")+". All events are written to a single file when not set.")
+	cmd.Flags().StringVar(&options.nameTemplate, "name-template", "audit-{{.Partition}}", "Go template of the file names, see the command help for the available fields.")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *EventsOptions) Validate() error {
+	if len(o.queryOptions.TargetDirectories()) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	if len(o.outputDirectory) == 0 {
+		return fmt.Errorf("output directory must be specified (--output-dir)")
+	}
+	if o.format != FormatAuditLog && o.format != FormatJSON {
+		return fmt.Errorf("invalid --format %q, must be %s or %s", o.format, FormatAuditLog, FormatJSON)
+	}
+	if _, ok := exportPartitionFuncs[o.partitionBy]; len(o.partitionBy) > 0 && !ok {
+		return fmt.Errorf("invalid --partition-by %q, must be one of %s", o.partitionBy, strings.Join(exportPartitions(), ", "))
+	}
+	if _, err := template.New("name").Parse(o.nameTemplate); err != nil {
+		return fmt.Errorf("invalid --name-template: %v", err)
+	}
+	return nil
+}
+
+// exportPartition is the data the --name-template is executed with.
+type exportPartition struct {
+	Partition string
+	Start     time.Time
+}
+
+func (o *EventsOptions) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+	nameTemplate, err := template.New("name").Option("missingkey=error").Parse(o.nameTemplate)
+	if err != nil {
+		return err
+	}
+	partitionFunc := exportPartitionFuncs[o.partitionBy]
+
+	files := map[string]*File{}
+	// partitions maps the file names to their partition, different partitions must not end up in the same file
+	partitions := map[string]string{}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	var writeErr error
+	events := 0
+	if err := o.queryOptions.MultiNodeEventVisitor(ctx, filters, true, func(batch []*auditv1.Event) {
+		for _, e := range batch {
+			if writeErr != nil {
+				return
+			}
+			partition, start := unpartitioned, time.Time{}
+			if partitionFunc != nil {
+				partition, start = partitionFunc(e)
+			}
+			f, ok := files[partition]
+			if !ok {
+				name, err := o.fileName(nameTemplate, exportPartition{Partition: partition, Start: start})
+				if err != nil {
+					writeErr = err
+					return
+				}
+				if other, ok := partitions[name]; ok {
+					writeErr = fmt.Errorf("--name-template writes the partitions %q and %q to the same file %q", other, partition, name)
+					return
+				}
+				partitions[name] = partition
+				if f, writeErr = NewFile(filepath.Join(o.outputDirectory, name), o.format); writeErr != nil {
+					return
+				}
+				files[partition] = f
+			}
+			writeErr = f.Write(e)
+			events++
+		}
+	}); err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+
+	for partition, f := range files {
+		delete(files, partition)
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(o.Out, "Exported %d events to %d files in %s\n", events, len(partitions), o.outputDirectory)
+	return nil
+}
+
+func (o *EventsOptions) fileName(nameTemplate *template.Template, partition exportPartition) (string, error) {
+	name := &bytes.Buffer{}
+	if err := nameTemplate.Execute(name, partition); err != nil {
+		return "", fmt.Errorf("invalid --name-template: %v", err)
+	}
+	if len(strings.TrimSpace(name.String())) == 0 {
+		return "", fmt.Errorf("--name-template results in an empty file name for partition %q", partition.Partition)
+	}
+	if o.format == FormatJSON {
+		return name.String() + ".json", nil
+	}
+	return name.String() + ".log.gz", nil
+}
+
+// File writes events either as gzipped audit log lines or as the items of an EventList, export events writes one per
+// partition.
+type File struct {
+	file       *os.File
+	compressed *gzip.Writer
+	events     query.EventWriter
+}
+
+func NewFile(path, format string) (*File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{file: file}
+	if format == FormatJSON {
+		f.events = query.NewEventListWriter(file)
+	} else {
+		f.compressed = gzip.NewWriter(file)
+		f.events = query.NewAuditLogWriter(f.compressed)
+	}
+	return f, nil
+}
+
+func (f *File) Write(e *auditv1.Event) error {
+	return f.events.WriteEvent(e)
+}
+
+func (f *File) Close() error {
+	err := f.events.Flush()
+	if f.compressed != nil {
+		if closeErr := f.compressed.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
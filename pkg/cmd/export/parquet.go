@@ -0,0 +1,141 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/parquet"
+	"github.com/natamm4/audit-tool/pkg/audit/provenance"
+	"github.com/natamm4/audit-tool/pkg/cmd/get"
+	"github.com/natamm4/audit-tool/pkg/cmd/query"
+)
+
+type ParquetOptions struct {
+	outputDirectory string
+
+	// queryOptions selects and filters the events to export
+	queryOptions query.Options
+
+	genericclioptions.IOStreams
+}
+
+func NewParquetCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &ParquetOptions{IOStreams: streams, queryOptions: query.Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "parquet --dir DIR --output-dir DIR",
+		Short: "Write the filtered events as Parquet files for columnar engines, which can be queried again",
+		Long: "Write the filtered events as Parquet files, one per node named <node>-audit.parquet (below a directory per\n" +
+			"cluster when several clusters are read). The files have a column per key field of the events (timestamps,\n" +
+			"audit ID, verb, code, user, user agent, namespace, API group, resource, subresource, name, request URI) for\n" +
+			"engines like DuckDB, Spark or pandas, and the raw event as JSON in the \"" + parquet.EventColumn + "\" column.\n\n" +
+			"The output directory can be queried again with --dir, the events are read from the raw event column.",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	options.queryOptions.AddTimeRangeFlags(cmd.Flags(), "export events")
+	options.queryOptions.AddNodeFlag(cmd.Flags(), "export the events")
+	options.queryOptions.AddMaxEventSizeFlag(cmd.Flags())
+	cmd.Flags().StringVar(&options.outputDirectory, "output-dir", "", "Directory to write the Parquet files to.")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *ParquetOptions) Validate() error {
+	if len(o.queryOptions.TargetDirectories()) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	if len(o.outputDirectory) == 0 {
+		return fmt.Errorf("output directory must be specified (--output-dir)")
+	}
+	return nil
+}
+
+// parquetFile writes the events of one node.
+type parquetFile struct {
+	file   *os.File
+	events *parquet.Writer
+}
+
+func (f *parquetFile) close() (int64, error) {
+	err := f.events.Close()
+	info, statErr := f.file.Stat()
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && statErr == nil {
+		return info.Size(), nil
+	}
+	return 0, err
+}
+
+func (o *ParquetOptions) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+
+	files := map[string]*parquetFile{}
+	defer func() {
+		for _, f := range files {
+			f.close()
+		}
+	}()
+
+	var writeErr error
+	events := 0
+	if err := o.queryOptions.MultiNodeEventVisitor(ctx, filters, true, func(batch []*auditv1.Event) {
+		for _, e := range batch {
+			if writeErr != nil {
+				return
+			}
+			origin, _ := provenance.Get(e)
+			path := filepath.Join(o.outputDirectory, origin.Cluster, origin.Node+"-audit"+parquet.Extension)
+			f, ok := files[path]
+			if !ok {
+				if writeErr = os.MkdirAll(filepath.Dir(path), os.ModePerm); writeErr != nil {
+					return
+				}
+				file, err := os.Create(path)
+				if err != nil {
+					writeErr = err
+					return
+				}
+				f = &parquetFile{file: file, events: parquet.NewWriter(file)}
+				files[path] = f
+			}
+			writeErr = f.events.WriteEvent(e)
+			events++
+		}
+	}); err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+
+	written, exported := int64(0), len(files)
+	for path, f := range files {
+		delete(files, path)
+		size, err := f.close()
+		if err != nil {
+			return err
+		}
+		written += size
+	}
+	fmt.Fprintf(o.Out, "Exported %d events to %d Parquet files (%s) in %s\n", events, exported, get.FormatSize(written), o.outputDirectory)
+	return nil
+}
@@ -0,0 +1,285 @@
+package generate
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/spf13/cobra"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+	"sigs.k8s.io/yaml"
+
+	"github.com/natamm4/audit-tool/pkg/cmd/daemon"
+)
+
+const (
+	appName = "audit-tool"
+
+	// dataDir is where the volume holding the audit logs and the reports is mounted in the daemon container.
+	dataDir   = "/var/lib/audit-tool"
+	configDir = "/etc/audit-tool"
+	// configFile is the key of the daemon configuration in the ConfigMap.
+	configFile = "config.yaml"
+)
+
+type ManifestsOptions struct {
+	namespace       string
+	createNamespace bool
+	image           string
+	platform        string
+	schedule        string
+	pushgateway     string
+
+	// storageSize and storageClass size the PersistentVolumeClaim of the audit logs, existingClaim replaces it by a
+	// claim created beforehand and ephemeral by an emptyDir lost with the pod.
+	storageSize   string
+	storageClass  string
+	existingClaim string
+	ephemeral     bool
+
+	outputFile string
+
+	genericclioptions.IOStreams
+}
+
+func NewManifestsCommand(streams genericclioptions.IOStreams) *cobra.Command {
+	options := &ManifestsOptions{
+		IOStreams:       streams,
+		namespace:       appName,
+		createNamespace: true,
+		platform:        "openshift",
+		schedule:        "@every 15m",
+		storageSize:     "10Gi",
+	}
+	cmd := &cobra.Command{
+		Use:   "manifests --image IMAGE",
+		Short: "Generate the manifests running the daemon in-cluster to collect the audit logs continuously",
+		Long: "Generate the manifests running the daemon in-cluster to collect the audit logs continuously, to be applied\n" +
+			"with 'kubectl apply -f'. The daemon runs in a Deployment with a ServiceAccount allowed to read the audit logs\n" +
+			"through the kubelet node logs API (get --backend node-logs), so that no exec into the kube-apiserver pods is\n" +
+			"needed. Its configuration is a ConfigMap with a job downloading the audit logs on --schedule, add the queries\n" +
+			"and checks to run to it. The audit logs and the reports of the jobs are kept on a PersistentVolumeClaim.",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmdutil.CheckErr(options.Validate())
+			cmdutil.CheckErr(options.Run())
+		},
+	}
+
+	cmd.Flags().StringVarP(&options.namespace, "namespace", "n", options.namespace, "Namespace the daemon is deployed to.")
+	cmd.Flags().BoolVar(&options.createNamespace, "create-namespace", options.createNamespace, "Include the namespace in the manifests.")
+	cmd.Flags().StringVar(&options.image, "image", "", "Container image with the audit-tool binary in the PATH.")
+	cmd.Flags().StringVar(&options.platform, "platform", options.platform, "Kubernetes distribution of the cluster, passed to get to locate the kube-apiserver pods and audit logs.")
+	cmd.Flags().StringVar(&options.schedule, "schedule", options.schedule, "Schedule of the download of the audit logs, a cron expression, @hourly, @daily or @every DURATION.")
+	cmd.Flags().StringVar(&options.pushgateway, "pushgateway", "", "URL of the Prometheus Pushgateway the metrics of the jobs are pushed to.")
+	cmd.Flags().StringVar(&options.storageSize, "storage-size", options.storageSize, "Size of the PersistentVolumeClaim of the audit logs and reports.")
+	cmd.Flags().StringVar(&options.storageClass, "storage-class", "", "Storage class of the PersistentVolumeClaim, the default storage class of the cluster when not set.")
+	cmd.Flags().StringVar(&options.existingClaim, "existing-claim", "", "Use this existing PersistentVolumeClaim of the namespace instead of creating one.")
+	cmd.Flags().BoolVar(&options.ephemeral, "ephemeral", false, "Keep the audit logs and reports in an emptyDir, they are lost when the pod is deleted.")
+	cmd.Flags().StringVar(&options.outputFile, "output-file", "", "File to write the manifests to, they are written to stdout when not set.")
+
+	return cmd
+}
+
+func (o *ManifestsOptions) Validate() error {
+	if len(o.image) == 0 {
+		return fmt.Errorf("image of the daemon must be specified (--image)")
+	}
+	if len(o.namespace) == 0 {
+		return fmt.Errorf("--namespace must not be empty")
+	}
+	if err := daemon.ValidateSchedule(o.schedule); err != nil {
+		return fmt.Errorf("invalid --schedule: %v", err)
+	}
+	if o.ephemeral && len(o.existingClaim) > 0 {
+		return fmt.Errorf("--ephemeral and --existing-claim are mutually exclusive")
+	}
+	if _, err := resource.ParseQuantity(o.storageSize); err != nil {
+		return fmt.Errorf("invalid --storage-size %q: %v", o.storageSize, err)
+	}
+	return nil
+}
+
+func (o *ManifestsOptions) Run() error {
+	objects, err := o.manifests()
+	if err != nil {
+		return err
+	}
+	out := &bytes.Buffer{}
+	for _, object := range objects {
+		data, err := yaml.Marshal(object)
+		if err != nil {
+			return err
+		}
+		out.WriteString("---\n")
+		out.Write(data)
+	}
+	if len(o.outputFile) > 0 {
+		return os.WriteFile(o.outputFile, out.Bytes(), 0644)
+	}
+	_, err = io.Copy(o.Out, out)
+	return err
+}
+
+// manifests returns the objects deploying the daemon, in the order they can be applied in.
+func (o *ManifestsOptions) manifests() ([]interface{}, error) {
+	config, err := yaml.Marshal(o.daemonConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	objects := []interface{}{}
+	if o.createNamespace {
+		objects = append(objects, &corev1.Namespace{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+			ObjectMeta: metav1.ObjectMeta{Name: o.namespace, Labels: labels()},
+		})
+	}
+	objects = append(objects,
+		&corev1.ServiceAccount{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+			ObjectMeta: o.objectMeta(appName),
+		},
+		&rbacv1.ClusterRole{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+			ObjectMeta: metav1.ObjectMeta{Name: o.clusterRoleName(), Labels: labels()},
+			Rules:      o.rules(),
+		},
+		&rbacv1.ClusterRoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRoleBinding"},
+			ObjectMeta: metav1.ObjectMeta{Name: o.clusterRoleName(), Labels: labels()},
+			RoleRef:    rbacv1.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "ClusterRole", Name: o.clusterRoleName()},
+			Subjects:   []rbacv1.Subject{{Kind: "ServiceAccount", Name: appName, Namespace: o.namespace}},
+		},
+		&corev1.ConfigMap{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: o.objectMeta(appName),
+			Data:       map[string]string{configFile: string(config)},
+		},
+	)
+
+	data := corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}
+	if !o.ephemeral {
+		claim := o.existingClaim
+		if len(claim) == 0 {
+			claim = appName
+			objects = append(objects, o.persistentVolumeClaim())
+		}
+		data = corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim}}
+	}
+	return append(objects, o.deployment(data)), nil
+}
+
+// daemonConfig returns the configuration of the daemon, a job downloading the new audit logs through the node logs API.
+func (o *ManifestsOptions) daemonConfig() *daemon.Config {
+	return &daemon.Config{
+		ReportDir:   path.Join(dataDir, "reports"),
+		Pushgateway: o.pushgateway,
+		Jobs: []daemon.Job{{
+			Name:     "download",
+			Schedule: o.schedule,
+			Args:     []string{"get", "--backend", "node-logs", "--platform", o.platform, "--incremental", "-o", path.Join(dataDir, "audit")},
+		}},
+	}
+}
+
+// clusterRoleName is unique per namespace, so that the daemon can be deployed to several namespaces.
+func (o *ManifestsOptions) clusterRoleName() string {
+	if o.namespace == appName {
+		return appName
+	}
+	return appName + "-" + o.namespace
+}
+
+// rules allow get to find the kube-apiserver pods and read the audit logs of their nodes.
+func (o *ManifestsOptions) rules() []rbacv1.PolicyRule {
+	rules := []rbacv1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get", "list"}},
+		{APIGroups: []string{""}, Resources: []string{"nodes"}, Verbs: []string{"get", "list"}},
+		{APIGroups: []string{""}, Resources: []string{"nodes/proxy"}, Verbs: []string{"get"}},
+	}
+	if o.platform == "openshift" {
+		// the cluster ID recorded in the manifest of the downloads
+		rules = append(rules, rbacv1.PolicyRule{APIGroups: []string{"config.openshift.io"}, Resources: []string{"clusterversions"}, Verbs: []string{"get"}})
+	}
+	return rules
+}
+
+func (o *ManifestsOptions) persistentVolumeClaim() *corev1.PersistentVolumeClaim {
+	claim := &corev1.PersistentVolumeClaim{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "PersistentVolumeClaim"},
+		ObjectMeta: o.objectMeta(appName),
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(o.storageSize)},
+			},
+		},
+	}
+	if len(o.storageClass) > 0 {
+		claim.Spec.StorageClassName = &o.storageClass
+	}
+	return claim
+}
+
+func (o *ManifestsOptions) deployment(data corev1.VolumeSource) *appsv1.Deployment {
+	replicas := int32(1)
+	noEscalation := false
+	readOnly := true
+	return &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: o.objectMeta(appName),
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels()},
+			// a single daemon at a time, the volume is read-write once
+			Strategy: appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels()},
+				Spec: corev1.PodSpec{
+					ServiceAccountName: appName,
+					Containers: []corev1.Container{{
+						Name:    appName,
+						Image:   o.image,
+						Command: []string{"audit-tool"},
+						Args:    []string{"daemon", "--config", path.Join(configDir, configFile)},
+						Env: []corev1.EnvVar{
+							{Name: "AUDIT_TOOL_HOME", Value: dataDir},
+							{Name: "AUDIT_TOOL_CACHE_DIR", Value: path.Join(dataDir, "cache")},
+						},
+						VolumeMounts: []corev1.VolumeMount{
+							{Name: "config", MountPath: configDir, ReadOnly: true},
+							{Name: "data", MountPath: dataDir},
+						},
+						SecurityContext: &corev1.SecurityContext{
+							AllowPrivilegeEscalation: &noEscalation,
+							ReadOnlyRootFilesystem:   &readOnly,
+							Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+						},
+					}},
+					Volumes: []corev1.Volume{
+						{Name: "config", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+							LocalObjectReference: corev1.LocalObjectReference{Name: appName},
+						}}},
+						{Name: "data", VolumeSource: data},
+					},
+				},
+			},
+		},
+	}
+}
+
+func (o *ManifestsOptions) objectMeta(name string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{Name: name, Namespace: o.namespace, Labels: labels()}
+}
+
+func labels() map[string]string {
+	return map[string]string{"app.kubernetes.io/name": appName}
+}
@@ -2,10 +2,12 @@ package get
 
 import (
 	"context"
+	"crypto/ed25519"
 	"fmt"
 	"io"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 
@@ -22,19 +24,64 @@ import (
 	"k8s.io/kubectl/pkg/util/interrupt"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	restclient "k8s.io/client-go/rest"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	"k8s.io/client-go/tools/remotecommand"
 	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/manifest"
+)
+
+const (
+	// defaultNamespace is where the kube-apiserver static pods of OpenShift run. On Hypershift the pods of a hosted
+	// control plane run in its namespace on the management cluster (eg. clusters-<name>).
+	defaultNamespace = "openshift-kube-apiserver"
+
+	defaultContainer    = "kube-apiserver"
+	defaultAuditLogPath = "/var/log/kube-apiserver/audit.log"
 )
 
 type Options struct {
-	Config *restclient.Config
-	client kubernetes.Interface
+	Config  *restclient.Config
+	client  kubernetes.Interface
+	dynamic dynamic.Interface
 
 	targetDirectory string
 
+	// namespace and podSelector locate the kube-apiserver pods, without a selector the pods are recognized by name.
+	// The platform sets the defaults of all of them.
+	platform     string
+	namespace    string
+	podSelector  string
+	container    string
+	auditLogPath string
+
+	// backend is how the audit logs are transferred, exec into the kube-apiserver pods or the kubelet node logs API
+	backend string
+
+	// incremental keeps the audit logs of earlier runs under stable names and downloads only the new rotated ones and
+	// the current one, for the scheduled runs of the daemon
+	incremental bool
+
+	// sshHosts are the control plane hosts to download the audit logs from over ssh instead of the API
+	sshHosts []string
+	sshKey   string
+
+	// contexts are the kubeconfig contexts to download the audit logs of, each into its own subdirectory
+	contexts    []string
+	allContexts bool
+	rawConfig   clientcmdapi.Config
+	// skipLatest is set for the subdirectories of the contexts, the latest symlink points to the output directory
+	skipLatest bool
+
+	// signKey signs the manifest, so that a modification of the audit logs after the collection can be proven
+	signKeyFile string
+	signKey     ed25519.PrivateKey
+
 	Executor *DefaultRemoteExecutor
 	StreamOptions
 
@@ -67,19 +114,30 @@ func (*DefaultRemoteExecutor) Execute(method string, url *url.URL, config *restc
 	})
 }
 
-func NewCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
-	options := &Options{
+// newOptions returns the options locating the kube-apiserver pods of OpenShift.
+func newOptions(streams genericclioptions.IOStreams) *Options {
+	return &Options{
 		StreamOptions: StreamOptions{
 			IOStreams: streams,
 		},
 
-		Executor: &DefaultRemoteExecutor{},
+		Executor:     &DefaultRemoteExecutor{},
+		platform:     "openshift",
+		backend:      backendExec,
+		namespace:    defaultNamespace,
+		container:    defaultContainer,
+		auditLogPath: defaultAuditLogPath,
 	}
+}
+
+func NewCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := newOptions(streams)
 	cmd := &cobra.Command{
 		Use:   "get",
 		Short: "Get the audit logs from the remote masters",
 		Run: func(cmd *cobra.Command, args []string) {
 			argsLenAtDash := cmd.ArgsLenAtDash()
+			cmdutil.CheckErr(options.applyPlatform(cmd))
 			cmdutil.CheckErr(options.Validate())
 			cmdutil.CheckErr(options.Complete(f, cmd, args, argsLenAtDash))
 			cmdutil.CheckErr(options.Run(ctx))
@@ -87,11 +145,58 @@ func NewCommand(ctx context.Context, f cmdutil.Factory, streams genericclioption
 	}
 
 	cmd.Flags().StringVarP(&options.targetDirectory, "output", "o", "", "Output directory to store the log")
+	options.addPodFlags(cmd.Flags())
+	cmd.Flags().StringVar(&options.backend, "backend", options.backend, "How to download the audit logs: '"+backendExec+"' runs tar in the kube-apiserver pods, '"+backendNodeLogs+"' reads them through the kubelet node logs API (GET /api/v1/nodes/<node>/proxy/logs/), which works when exec is blocked by policy.")
+	cmd.Flags().BoolVar(&options.incremental, "incremental", false, "Download into an output directory of earlier runs: every audit log is stored as <node>/<node>-<file>.gz, the rotated audit logs downloaded before are skipped and the copy of the current audit log is replaced. Without it every run of the exec backend stores all audit logs again in new files, which scheduled queries of the directory would count once per run.")
+	cmd.Flags().StringSliceVar(&options.sshHosts, "ssh", options.sshHosts, "Download the audit logs over ssh from these control plane hosts (eg. core@master-0,core@master-1:2222), when the API is down and only node access remains. The ssh client must authenticate non-interactively.")
+	cmd.Flags().StringVar(&options.sshKey, "ssh-key", options.sshKey, "With --ssh, the private key to authenticate with. Defaults to the keys of the ssh agent and configuration.")
+	cmd.Flags().StringSliceVar(&options.contexts, "contexts", options.contexts, "Download the audit logs of these kubeconfig contexts, each into a subdirectory of the output directory named after the context (query them with --dir 'OUTPUT/*').")
+	cmd.Flags().BoolVar(&options.allContexts, "all-contexts", false, "Download the audit logs of all kubeconfig contexts, each into a subdirectory of the output directory named after the context.")
+	cmd.Flags().StringVar(&options.signKeyFile, "sign-key", "", "Sign the manifest of the checksums of the downloaded files with this Ed25519 private key (PEM), so that 'audit-tool verify' detects any later modification.")
+
+	cmd.AddCommand(NewStatusCommand(ctx, f, streams))
 
 	return cmd
 }
 
+// addPodFlags adds the flags locating the kube-apiserver pods and the audit logs in them.
+func (o *Options) addPodFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&o.platform, "platform", o.platform, "Kubernetes distribution defaulting the kube-apiserver pod and audit log flags ("+strings.Join(platformNames(), ", ")+"). The kube-apiserver container must have a shell and tar, otherwise point --container to a sidecar mounting the audit logs.")
+	flags.StringVar(&o.namespace, "apiserver-namespace", o.namespace, "Namespace of the kube-apiserver pods, eg. clusters-<name> for a Hypershift hosted control plane on the management cluster.")
+	flags.StringVarP(&o.podSelector, "selector", "l", o.podSelector, "Label selector of the kube-apiserver pods (eg. 'app=kube-apiserver'). When empty, pods named kube-apiserver-* are used.")
+	flags.StringVar(&o.container, "container", o.container, "Container of the kube-apiserver pods to read the audit logs in.")
+	flags.StringVar(&o.auditLogPath, "audit-log-path", o.auditLogPath, "Path of the audit log in the container, as set by --audit-log-path of the kube-apiserver. The rotated logs are expected next to it.")
+}
+
 func (o *Options) Complete(f cmdutil.Factory, cmd *cobra.Command, argsIn []string, argsLenAtDash int) error {
+	if len(o.signKeyFile) > 0 {
+		key, err := manifest.LoadPrivateKey(o.signKeyFile)
+		if err != nil {
+			return err
+		}
+		o.signKey = key
+	}
+
+	switch {
+	case len(o.sshHosts) > 0:
+		// the logs are read from the hosts, the API is not needed
+	case len(o.contexts) > 0 || o.allContexts:
+		if err := o.completeContexts(f); err != nil {
+			return err
+		}
+	default:
+		if err := o.completeClient(f); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(o.targetDirectory, os.ModePerm); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (o *Options) completeClient(f cmdutil.Factory) error {
 	var err error
 	o.Config, err = f.ToRESTConfig()
 	if err != nil {
@@ -102,30 +207,40 @@ func (o *Options) Complete(f cmdutil.Factory, cmd *cobra.Command, argsIn []strin
 		return err
 	}
 	o.client = clientset
-
-	if err := os.MkdirAll(o.targetDirectory, os.ModePerm); err != nil {
-		return err
-	}
-	return nil
+	o.dynamic, err = f.DynamicClient()
+	return err
 }
 
 func (o *Options) findAPIServerPods(ctx context.Context) ([]string, error) {
-	pods, err := o.client.CoreV1().Pods("openshift-kube-apiserver").List(ctx, metav1.ListOptions{})
+	pods, err := o.listAPIServerPods(ctx)
 	if err != nil {
 		return nil, err
 	}
 	result := []string{}
+	for _, p := range pods {
+		result = append(result, p.Name)
+	}
+	return result, nil
+}
+
+// listAPIServerPods returns the running and ready kube-apiserver pods.
+func (o *Options) listAPIServerPods(ctx context.Context) ([]corev1.Pod, error) {
+	pods, err := o.client.CoreV1().Pods(o.namespace).List(ctx, metav1.ListOptions{LabelSelector: o.podSelector})
+	if err != nil {
+		return nil, err
+	}
+	result := []corev1.Pod{}
 	for _, p := range pods.Items {
 		// skip installer and pruner pods
-		if !strings.HasPrefix(p.Name, "kube-apiserver-") {
+		if len(o.podSelector) == 0 && !strings.HasPrefix(p.Name, "kube-apiserver-") {
 			continue
 		}
 		for _, c := range p.Status.ContainerStatuses {
-			if c.Name != "kube-apiserver" {
+			if c.Name != o.container {
 				continue
 			}
 			if c.State.Running != nil && c.Ready {
-				result = append(result, p.Name)
+				result = append(result, p)
 			}
 		}
 	}
@@ -146,13 +261,13 @@ func (o *Options) getAPIServerLogs(apiserverName string) ([]string, error) {
 	rotatedRequest := restClient.Post().
 		Resource("pods").
 		Name(apiserverName).
-		Namespace("openshift-kube-apiserver").
+		Namespace(o.namespace).
 		SubResource("exec")
 	rotatedRequest.VersionedParams(&corev1.PodExecOptions{
-		Container: "kube-apiserver",
+		Container: o.container,
 		TTY:       t.Raw,
 		Stdout:    true,
-		Command:   []string{"/bin/bash", "-c", "cd /var/log/kube-apiserver && tar -czO audit-*"},
+		Command:   []string{"/bin/sh", "-c", o.rotatedAuditLogsCommand()},
 	}, scheme.ParameterCodec)
 
 	apiServerTargetDirectory := filepath.Join(o.targetDirectory, apiserverName)
@@ -179,13 +294,13 @@ func (o *Options) getAPIServerLogs(apiserverName string) ([]string, error) {
 	liveRequest := restClient.Post().
 		Resource("pods").
 		Name(apiserverName).
-		Namespace("openshift-kube-apiserver").
+		Namespace(o.namespace).
 		SubResource("exec")
 	liveRequest.VersionedParams(&corev1.PodExecOptions{
-		Container: "kube-apiserver",
+		Container: o.container,
 		TTY:       t.Raw,
 		Stdout:    true,
-		Command:   []string{"/bin/bash", "-c", "cd /tmp && cp --remove-destination /var/log/kube-apiserver/audit.log audit.log && tar -czO audit.log && rm -f audit.log"},
+		Command:   []string{"/bin/sh", "-c", o.liveAuditLogCommand()},
 	}, scheme.ParameterCodec)
 
 	liveAuditFile, err := os.CreateTemp(apiServerTargetDirectory, "audit-log")
@@ -201,7 +316,35 @@ func (o *Options) getAPIServerLogs(apiserverName string) ([]string, error) {
 	return files, nil
 }
 
+// Run downloads the audit logs, records them in the manifest of the output directory and points the latest symlink
+// next to it to the output directory.
 func (o *Options) Run(ctx context.Context) error {
+	var err error
+	switch {
+	case len(o.sshHosts) > 0:
+		err = o.runSSH(ctx)
+	case len(o.contexts) > 0:
+		// every context writes the manifest of its subdirectory
+		if err := o.runContexts(ctx); err != nil {
+			return err
+		}
+		return o.updateLatest()
+	case o.backend == backendNodeLogs:
+		err = o.runNodeLogs(ctx)
+	default:
+		err = o.runExec(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	if err := o.writeManifest(ctx); err != nil {
+		return fmt.Errorf("failed to write the manifest: %v", err)
+	}
+	return o.updateLatest()
+}
+
+// runExec downloads the audit logs by running tar in the kube-apiserver pods.
+func (o *Options) runExec(ctx context.Context) error {
 	pods, err := o.findAPIServerPods(ctx)
 	if err != nil {
 		return err
@@ -210,6 +353,12 @@ func (o *Options) Run(ctx context.Context) error {
 
 	for _, p := range pods {
 		klog.V(4).Infof("Getting audit logs for %s ...", p)
+		if o.incremental {
+			if err := o.getAPIServerLogsIncremental(p); err != nil {
+				return err
+			}
+			continue
+		}
 		_, err := o.getAPIServerLogs(p)
 		if err != nil {
 			return err
@@ -224,5 +373,23 @@ func (o *Options) Validate() error {
 	if len(o.targetDirectory) == 0 {
 		return fmt.Errorf("output directory must be set")
 	}
+	if len(o.namespace) == 0 {
+		return fmt.Errorf("--apiserver-namespace must not be empty")
+	}
+	if o.backend != backendExec && o.backend != backendNodeLogs {
+		return fmt.Errorf("invalid --backend %q, must be %s or %s", o.backend, backendExec, backendNodeLogs)
+	}
+	if len(o.container) == 0 {
+		return fmt.Errorf("--container must not be empty")
+	}
+	if !path.IsAbs(o.auditLogPath) {
+		return fmt.Errorf("--audit-log-path must be an absolute path, got %q", o.auditLogPath)
+	}
+	if len(o.sshHosts) > 0 && (len(o.contexts) > 0 || o.allContexts) {
+		return fmt.Errorf("--ssh can't be combined with --contexts and --all-contexts")
+	}
+	if len(o.contexts) > 0 && o.allContexts {
+		return fmt.Errorf("only one of --contexts and --all-contexts can be specified")
+	}
 	return nil
 }
@@ -0,0 +1,105 @@
+package get
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/klog/v2"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+)
+
+var unsafeDirectoryRunes = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// contextDirectory returns the subdirectory the audit logs of a kubeconfig context are stored in. Context names often
+// contain slashes and colons (eg. default/api-cluster:6443/admin), which are replaced.
+func contextDirectory(name string) string {
+	return strings.Trim(unsafeDirectoryRunes.ReplaceAllString(name, "_"), "_")
+}
+
+// completeContexts resolves the --contexts and --all-contexts flags against the kubeconfig.
+func (o *Options) completeContexts(f cmdutil.Factory) error {
+	rawConfig, err := f.ToRawKubeConfigLoader().RawConfig()
+	if err != nil {
+		return err
+	}
+	if o.allContexts {
+		o.contexts = []string{}
+		for name := range rawConfig.Contexts {
+			o.contexts = append(o.contexts, name)
+		}
+		sort.Strings(o.contexts)
+	}
+	if len(o.contexts) == 0 {
+		return fmt.Errorf("no contexts found in the kubeconfig")
+	}
+	directories := map[string]string{}
+	for _, name := range o.contexts {
+		if _, ok := rawConfig.Contexts[name]; !ok {
+			return fmt.Errorf("context %q not found in the kubeconfig", name)
+		}
+		dir := contextDirectory(name)
+		if other, ok := directories[dir]; ok {
+			return fmt.Errorf("contexts %q and %q would be stored in the same directory %q", other, name, dir)
+		}
+		directories[dir] = name
+	}
+	o.rawConfig = rawConfig
+	return nil
+}
+
+// forContext returns a copy of the options downloading the audit logs of the kubeconfig context into its own
+// subdirectory of the output directory.
+func (o *Options) forContext(name string) (*Options, error) {
+	config, err := clientcmd.NewNonInteractiveClientConfig(o.rawConfig, name, &clientcmd.ConfigOverrides{}, nil).ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	contextOptions := *o
+	contextOptions.Config = config
+	contextOptions.client = clientset
+	contextOptions.dynamic = dynamicClient
+	contextOptions.skipLatest = true
+	contextOptions.contexts = nil
+	contextOptions.allContexts = false
+	contextOptions.rawConfig = clientcmdapi.Config{}
+	contextOptions.targetDirectory = filepath.Join(o.targetDirectory, contextDirectory(name))
+	if err := os.MkdirAll(contextOptions.targetDirectory, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &contextOptions, nil
+}
+
+// runContexts downloads the audit logs of every context. A cluster failing doesn't stop the others, the errors are
+// reported once all clusters were tried.
+func (o *Options) runContexts(ctx context.Context) error {
+	errs := []error{}
+	for _, name := range o.contexts {
+		klog.V(2).Infof("Getting audit logs of context %s ...", name)
+		contextOptions, err := o.forContext(name)
+		if err == nil {
+			err = contextOptions.Run(ctx)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("context %s: %v", name, err))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
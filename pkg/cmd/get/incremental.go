@@ -0,0 +1,81 @@
+package get
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// downloadedBefore returns whether an earlier incremental run stored the rotated audit log in the directory, also
+// under the name it had before the host compressed it. The current audit log is always downloaded again.
+func (o *Options) downloadedBefore(directory, node, file string) bool {
+	if file == path.Base(o.auditLogPath) {
+		return false
+	}
+	names := []string{downloadedAuditLogName(node, file)}
+	if isCompressedAuditLog(file) {
+		names = append(names, downloadedAuditLogName(node, strings.TrimSuffix(file, path.Ext(file))))
+	}
+	for _, name := range names {
+		if _, err := os.Stat(filepath.Join(directory, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// replaceFile writes the file through a temporary file renamed over it, so that an interrupted download neither
+// truncates the copy of an earlier run nor leaves a partial file the next incremental run takes as downloaded.
+func replaceFile(target string, write func(w io.Writer) error) error {
+	out, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".part")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+	if err := write(out); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), target)
+}
+
+// getAPIServerLogsIncremental downloads the audit logs of the pod one by one as <pod>/<pod>-<file>.gz like the node
+// logs backend does, skipping the rotated ones downloaded by earlier runs.
+func (o *Options) getAPIServerLogsIncremental(pod string) error {
+	dir, _ := o.auditLogDir()
+	listing := &bytes.Buffer{}
+	if err := o.execInPod(pod, "ls -1 "+shellQuote(dir), listing); err != nil {
+		return fmt.Errorf("failed to list audit logs of %s: %v", pod, err)
+	}
+
+	podDirectory := filepath.Join(o.targetDirectory, pod)
+	if err := os.MkdirAll(podDirectory, os.ModePerm); err != nil {
+		return err
+	}
+	for _, file := range strings.Split(listing.String(), "\n") {
+		file = strings.TrimSpace(file)
+		if !o.isAuditLogFile(file) || o.downloadedBefore(podDirectory, pod, file) {
+			continue
+		}
+		klog.V(4).Infof("Getting %s of %s ...", file, pod)
+		command := "gzip -c "
+		if isCompressedAuditLog(file) {
+			command = "cat "
+		}
+		if err := replaceFile(filepath.Join(podDirectory, downloadedAuditLogName(pod, file)), func(w io.Writer) error {
+			return o.execInPod(pod, command+shellQuote(path.Join(dir, file)), w)
+		}); err != nil {
+			return fmt.Errorf("failed to get %s of %s: %v", file, pod, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package get
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func TestDownloadedBefore(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"master-0-audit.log.gz", "master-0-audit-2026-10-01T10-00-00.000.log.gz", "master-0-audit-2026-10-02T10-00-00.000.log.zst"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	o := newOptions(genericclioptions.NewTestIOStreamsDiscard())
+	o.auditLogPath = "/var/log/kube-apiserver/audit.log"
+
+	tests := []struct {
+		file     string
+		expected bool
+	}{
+		// the current audit log grows, it is downloaded on every run
+		{file: "audit.log", expected: false},
+		{file: "audit-2026-10-01T10-00-00.000.log", expected: true},
+		// compressed by the host since the last run
+		{file: "audit-2026-10-01T10-00-00.000.log.gz", expected: true},
+		{file: "audit-2026-10-02T10-00-00.000.log.zst", expected: true},
+		{file: "audit-2026-10-03T10-00-00.000.log", expected: false},
+		{file: "audit-2026-10-03T10-00-00.000.log.gz", expected: false},
+	}
+	for _, test := range tests {
+		if actual := o.downloadedBefore(dir, "master-0", test.file); actual != test.expected {
+			t.Errorf("%s: expected downloaded before to be %v, got %v", test.file, test.expected, actual)
+		}
+	}
+}
+
+func TestReplaceFile(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "master-0-audit.log.gz")
+	if err := os.WriteFile(target, []byte("previous run"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// a failed download keeps the copy of the previous run and leaves no partial file
+	if err := replaceFile(target, func(w io.Writer) error {
+		fmt.Fprint(w, "partial")
+		return fmt.Errorf("connection reset")
+	}); err == nil {
+		t.Fatal("expected the error of the download")
+	}
+	assertFiles(t, dir, target, "previous run")
+
+	if err := replaceFile(target, func(w io.Writer) error {
+		_, err := fmt.Fprint(w, "this run")
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	assertFiles(t, dir, target, "this run")
+}
+
+// assertFiles checks that the target is the only file of the directory and holds the content.
+func assertFiles(t *testing.T, dir, target, content string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only %s, got %d files", filepath.Base(target), len(entries))
+	}
+	data, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != content {
+		t.Errorf("expected %q, got %q", content, data)
+	}
+}
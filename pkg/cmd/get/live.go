@@ -0,0 +1,86 @@
+package get
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+	"k8s.io/kubectl/pkg/scheme"
+
+	"github.com/natamm4/audit-tool/pkg/audit/source"
+)
+
+// LiveSource is an EventSource that reads the recent audit events directly from the running API server pods.
+// Only the lines whose requestReceivedTimestamp falls into the last Since minutes are transferred.
+type LiveSource struct {
+	options *Options
+	Since   time.Duration
+}
+
+func NewLiveSource(f cmdutil.Factory, streams genericclioptions.IOStreams, since time.Duration) (*LiveSource, error) {
+	options := newOptions(streams)
+	if err := options.completeClient(f); err != nil {
+		return nil, err
+	}
+	return &LiveSource{options: options, Since: since}, nil
+}
+
+func (s *LiveSource) List(ctx context.Context) ([]source.File, error) {
+	pods, err := s.options.findAPIServerPods(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	files := []source.File{}
+	for _, p := range pods {
+		files = append(files, source.File{
+			Name:    p + "-audit.log.gz",
+			Path:    p,
+			ModTime: now,
+			Size:    -1,
+		})
+	}
+	return files, nil
+}
+
+func (s *LiveSource) Open(ctx context.Context, file source.File) (io.ReadCloser, error) {
+	request := s.options.client.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Name(file.Path).
+		Namespace(s.options.namespace).
+		SubResource("exec")
+	request.VersionedParams(&corev1.PodExecOptions{
+		Container: s.options.container,
+		Stdout:    true,
+		Command:   []string{"/bin/sh", "-c", liveAuditCommand(s.options.auditLogPath, time.Now().UTC(), s.Since)},
+	}, scheme.ParameterCodec)
+
+	reader, writer := io.Pipe()
+	go func() {
+		err := s.options.Executor.Execute("POST", request.URL(), s.options.Config, nil, writer, s.options.ErrOut, false, nil)
+		if err != nil {
+			err = fmt.Errorf("failed to get live audit logs for %s: %v", file.Path, err)
+		}
+		writer.CloseWithError(err)
+	}()
+	return reader, nil
+}
+
+// liveAuditCommand returns shell command that prints gzipped audit events received in the last since duration.
+// Matching is done by the minute prefix of the requestReceivedTimestamp so the remote side only needs grep.
+func liveAuditCommand(auditLogPath string, now time.Time, since time.Duration) string {
+	patterns := []string{}
+	for t := now.Add(-since).Truncate(time.Minute); !t.After(now); t = t.Add(time.Minute) {
+		patterns = append(patterns, fmt.Sprintf("-e '\"requestReceivedTimestamp\":\"%s'", t.Format("2006-01-02T15:04")))
+	}
+	minutes := int(since/time.Minute) + 1
+	base := path.Base(auditLogPath)
+	return fmt.Sprintf("find %s -name %s -mmin -%d -print0 | xargs -0 -r grep -h -F %s | gzip -c",
+		shellQuote(path.Dir(auditLogPath)), shellQuote(strings.TrimSuffix(base, path.Ext(base))+"*"+path.Ext(base)), minutes, strings.Join(patterns, " "))
+}
@@ -0,0 +1,96 @@
+package get
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+
+	"github.com/natamm4/audit-tool/pkg/audit/custody"
+	"github.com/natamm4/audit-tool/pkg/audit/manifest"
+)
+
+var clusterVersionResource = schema.GroupVersionResource{Group: "config.openshift.io", Version: "v1", Resource: "clusterversions"}
+
+// writeManifest records where and when the audit logs were collected and the checksums of the downloaded files.
+func (o *Options) writeManifest(ctx context.Context) error {
+	m := &manifest.Manifest{CollectedAt: time.Now().UTC()}
+	if len(o.sshHosts) > 0 {
+		for _, destination := range o.sshHosts {
+			m.Nodes = append(m.Nodes, sshHost(destination))
+		}
+		return o.storeManifest(m)
+	}
+
+	if version, err := o.client.Discovery().ServerVersion(); err == nil {
+		m.ServerVersion = version.GitVersion
+	}
+	m.ClusterID = o.clusterID(ctx)
+	pods, err := o.listAPIServerPods(ctx)
+	if err != nil {
+		return err
+	}
+	nodes := sets.NewString()
+	for _, p := range pods {
+		nodes.Insert(p.Spec.NodeName)
+		apiServer := manifest.APIServer{Pod: p.Name, Node: p.Spec.NodeName}
+		for _, c := range p.Spec.Containers {
+			if c.Name == o.container {
+				apiServer.Image = c.Image
+			}
+		}
+		m.APIServers = append(m.APIServers, apiServer)
+	}
+	m.Nodes = nodes.List()
+	return o.storeManifest(m)
+}
+
+// storeManifest writes the manifest to the output directory, signs it with --sign-key and records the download in the
+// operations log.
+func (o *Options) storeManifest(m *manifest.Manifest) error {
+	if err := manifest.Write(o.targetDirectory, m); err != nil {
+		return err
+	}
+	if o.signKey != nil {
+		if err := manifest.Sign(o.targetDirectory, o.signKey); err != nil {
+			return err
+		}
+	}
+	op := custody.Operation{Operation: "get", ClusterID: m.ClusterID}
+	switch {
+	case len(o.sshHosts) > 0:
+		op.Cluster = strings.Join(o.sshHosts, ",")
+	case o.Config != nil:
+		op.Cluster = o.Config.Host
+	}
+	for _, f := range m.Files {
+		op.Files = append(op.Files, f.Path)
+	}
+	return custody.Record(o.targetDirectory, op)
+}
+
+// clusterID returns the ID of an OpenShift cluster, empty for other clusters.
+func (o *Options) clusterID(ctx context.Context) string {
+	if o.dynamic == nil {
+		return ""
+	}
+	version, err := o.dynamic.Resource(clusterVersionResource).Get(ctx, "version", metav1.GetOptions{})
+	if err != nil {
+		klog.V(4).Infof("No cluster ID: %v", err)
+		return ""
+	}
+	id, _, _ := unstructured.NestedString(version.Object, "spec", "clusterID")
+	return id
+}
+
+func (o *Options) updateLatest() error {
+	if o.skipLatest {
+		return nil
+	}
+	return manifest.UpdateLatest(o.targetDirectory)
+}
@@ -0,0 +1,158 @@
+package get
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+const (
+	backendExec     = "exec"
+	backendNodeLogs = "node-logs"
+
+	// nodeLogsRoot is the directory the kubelet serves under /api/v1/nodes/<node>/proxy/logs/
+	nodeLogsRoot = "/var/log"
+)
+
+// nodeLogsLink matches the file links of the directory listing served by the kubelet.
+var nodeLogsLink = regexp.MustCompile(`href="([^"?/]+)"`)
+
+// nodeLogsDir returns the directory of the audit logs relative to the node logs API root.
+func (o *Options) nodeLogsDir() (string, error) {
+	dir, _ := o.auditLogDir()
+	relative := strings.TrimPrefix(dir, nodeLogsRoot+"/")
+	if relative == dir {
+		return "", fmt.Errorf("--audit-log-path %q is not under %s, it can't be read through the node logs API", o.auditLogPath, nodeLogsRoot)
+	}
+	return relative, nil
+}
+
+// runNodeLogs downloads the audit logs of the nodes running the kube-apiserver pods through the kubelet node logs API,
+// without exec'ing into the pods. The files are stored gzipped as <node>/<node>-<file>.gz so that query maps them to
+// the node.
+func (o *Options) runNodeLogs(ctx context.Context) error {
+	dir, err := o.nodeLogsDir()
+	if err != nil {
+		return err
+	}
+	pods, err := o.listAPIServerPods(ctx)
+	if err != nil {
+		return err
+	}
+	nodes := sets.NewString()
+	for _, p := range pods {
+		if len(p.Spec.NodeName) > 0 {
+			nodes.Insert(p.Spec.NodeName)
+		}
+	}
+	klog.V(4).Infof("Got Kubernetes API server nodes: %s", strings.Join(nodes.List(), ","))
+
+	for _, node := range nodes.List() {
+		files, err := o.listNodeAuditLogs(ctx, node, dir)
+		if err != nil {
+			return fmt.Errorf("failed to list audit logs of node %s: %v", node, err)
+		}
+		nodeDirectory := filepath.Join(o.targetDirectory, node)
+		if err := os.MkdirAll(nodeDirectory, os.ModePerm); err != nil {
+			return err
+		}
+		for _, file := range files {
+			if o.incremental && o.downloadedBefore(nodeDirectory, node, file) {
+				continue
+			}
+			klog.V(4).Infof("Getting %s of node %s ...", file, node)
+			if err := o.downloadNodeAuditLog(ctx, node, path.Join(dir, file), filepath.Join(nodeDirectory, downloadedAuditLogName(node, file))); err != nil {
+				return fmt.Errorf("failed to get %s of node %s: %v", file, node, err)
+			}
+		}
+	}
+
+	klog.Infof("Audit logs successfully downloaded to %s", o.targetDirectory)
+	return nil
+}
+
+// listNodeAuditLogs returns the names of the current and rotated audit logs in the directory listing of the node.
+func (o *Options) listNodeAuditLogs(ctx context.Context, node, dir string) ([]string, error) {
+	listing, err := o.client.CoreV1().RESTClient().Get().
+		// a single segment keeps the trailing slash, which makes the kubelet serve the directory listing
+		AbsPath(fmt.Sprintf("/api/v1/nodes/%s/proxy/logs/%s/", node, dir)).
+		DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, match := range nodeLogsLink.FindAllStringSubmatch(string(listing), -1) {
+		if o.isAuditLogFile(match[1]) {
+			files = append(files, match[1])
+		}
+	}
+	return files, nil
+}
+
+// compressedAuditLogExtensions are the extensions of the rotated audit logs compressed on the host, eg. by logrotate.
+var compressedAuditLogExtensions = []string{".gz", ".bz2", ".zst"}
+
+func isCompressedAuditLog(name string) bool {
+	for _, extension := range compressedAuditLogExtensions {
+		if strings.HasSuffix(name, extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAuditLogFile is true for the name of the audit log and the rotated audit logs next to it, compressed or not.
+func (o *Options) isAuditLogFile(name string) bool {
+	_, prefix := o.auditLogDir()
+	current := path.Base(o.auditLogPath)
+	if name == current {
+		return true
+	}
+	if !strings.HasPrefix(name, prefix+"-") {
+		return false
+	}
+	if isCompressedAuditLog(name) {
+		name = strings.TrimSuffix(name, path.Ext(name))
+	}
+	return path.Ext(name) == path.Ext(current)
+}
+
+// downloadedAuditLogName returns the name an audit log of a node is stored as, gzipped unless it already is compressed.
+func downloadedAuditLogName(node, file string) string {
+	if isCompressedAuditLog(file) {
+		return node + "-" + file
+	}
+	return node + "-" + file + ".gz"
+}
+
+// downloadNodeAuditLog streams the file from the node and stores it gzipped, unless it already is compressed.
+func (o *Options) downloadNodeAuditLog(ctx context.Context, node, file, target string) error {
+	stream, err := o.client.CoreV1().RESTClient().Get().
+		AbsPath("/api/v1/nodes", node, "proxy", "logs", file).
+		Stream(ctx)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	return replaceFile(target, func(out io.Writer) error {
+		if isCompressedAuditLog(file) {
+			_, err := io.Copy(out, stream)
+			return err
+		}
+		gzipWriter := gzip.NewWriter(out)
+		if _, err := io.Copy(gzipWriter, stream); err != nil {
+			return err
+		}
+		return gzipWriter.Close()
+	})
+}
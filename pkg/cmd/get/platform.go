@@ -0,0 +1,86 @@
+package get
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// platform holds where the kube-apiserver pods of a Kubernetes distribution run and where they write the audit log.
+type platform struct {
+	namespace    string
+	podSelector  string
+	container    string
+	auditLogPath string
+}
+
+var platforms = map[string]platform{
+	"openshift": {
+		namespace:    defaultNamespace,
+		container:    defaultContainer,
+		auditLogPath: defaultAuditLogPath,
+	},
+	// kubeadm static pods, the audit log path is whatever --audit-log-path of the kube-apiserver is set to, this is the
+	// one of the kubeadm documentation
+	"kubernetes": {
+		namespace:    "kube-system",
+		podSelector:  "component=kube-apiserver",
+		container:    "kube-apiserver",
+		auditLogPath: "/var/log/kubernetes/audit/audit.log",
+	},
+}
+
+func platformNames() []string {
+	return sets.StringKeySet(platforms).List()
+}
+
+// applyPlatform defaults the pod discovery and audit log flags not set on the command line to the ones of the platform.
+func (o *Options) applyPlatform(cmd *cobra.Command) error {
+	p, ok := platforms[o.platform]
+	if !ok {
+		return fmt.Errorf("invalid --platform %q, must be one of %s", o.platform, strings.Join(platformNames(), ", "))
+	}
+	for _, d := range []struct {
+		flag   string
+		target *string
+		value  string
+	}{
+		{"apiserver-namespace", &o.namespace, p.namespace},
+		{"selector", &o.podSelector, p.podSelector},
+		{"container", &o.container, p.container},
+		{"audit-log-path", &o.auditLogPath, p.auditLogPath},
+	} {
+		if !cmd.Flags().Changed(d.flag) {
+			*d.target = d.value
+		}
+	}
+	return nil
+}
+
+// shellQuote quotes s for the remote shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// auditLogDir returns the directory of the audit log and the prefix of the rotated audit logs next to it, the
+// kube-apiserver rotates audit.log to audit-<timestamp>.log.
+func (o *Options) auditLogDir() (string, string) {
+	base := path.Base(o.auditLogPath)
+	return path.Dir(o.auditLogPath), strings.TrimSuffix(base, path.Ext(base))
+}
+
+// rotatedAuditLogsCommand prints the rotated audit logs as a gzipped tar.
+func (o *Options) rotatedAuditLogsCommand() string {
+	dir, prefix := o.auditLogDir()
+	return fmt.Sprintf("cd %s && tar -czO %s-*", shellQuote(dir), shellQuote(prefix))
+}
+
+// liveAuditLogCommand prints a copy of the audit log currently written to as a gzipped tar.
+func (o *Options) liveAuditLogCommand() string {
+	base := path.Base(o.auditLogPath)
+	return fmt.Sprintf("cd /tmp && cp --remove-destination %s %s && tar -czO %s && rm -f %s",
+		shellQuote(o.auditLogPath), shellQuote(base), shellQuote(base), shellQuote(base))
+}
@@ -0,0 +1,105 @@
+package get
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	osexec "os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// splitSSHDestination splits an ssh destination (eg. core@master-0:22 or ssh://core@master-0:22) into the user and
+// host, and the port, empty when not given. The ssh client rejects a port after the host, it is passed with -p.
+func splitSSHDestination(destination string) (string, string) {
+	userHost := strings.TrimPrefix(destination, "ssh://")
+	user, host := "", userHost
+	if i := strings.LastIndex(userHost, "@"); i != -1 {
+		user, host = userHost[:i+1], userHost[i+1:]
+	}
+	h, port, err := net.SplitHostPort(host)
+	if err != nil {
+		// no port, or an IPv6 address without brackets
+		return userHost, ""
+	}
+	return user + h, port
+}
+
+// sshHost returns the host name of an ssh destination, used as node name.
+func sshHost(destination string) string {
+	host, _ := splitSSHDestination(destination)
+	if i := strings.LastIndex(host, "@"); i != -1 {
+		host = host[i+1:]
+	}
+	return host
+}
+
+// sshCommand runs the ssh client non-interactively, authentication has to succeed with the key or the ssh agent.
+func (o *Options) sshCommand(ctx context.Context, destination, command string) *osexec.Cmd {
+	args := []string{"-o", "BatchMode=yes"}
+	if len(o.sshKey) > 0 {
+		args = append(args, "-i", o.sshKey)
+	}
+	destination, port := splitSSHDestination(destination)
+	if len(port) > 0 {
+		args = append(args, "-p", port)
+	}
+	// a destination starting with - must not be taken for an option
+	args = append(args, "--", destination, command)
+	cmd := osexec.CommandContext(ctx, "ssh", args...)
+	cmd.Stderr = o.ErrOut
+	return cmd
+}
+
+// runSSH downloads the audit logs from the control plane hosts over ssh, for clusters where the API is down and only
+// node access remains. The files are stored gzipped as <host>/<host>-<file>.gz like the node logs backend does, the
+// ones compressed on the host as they are.
+func (o *Options) runSSH(ctx context.Context) error {
+	dir, _ := o.auditLogDir()
+	for _, destination := range o.sshHosts {
+		host := sshHost(destination)
+		listing := &bytes.Buffer{}
+		list := o.sshCommand(ctx, destination, "ls -1 "+shellQuote(dir))
+		list.Stdout = listing
+		if err := list.Run(); err != nil {
+			return fmt.Errorf("failed to list audit logs on %s: %v", destination, err)
+		}
+
+		hostDirectory := filepath.Join(o.targetDirectory, host)
+		if err := os.MkdirAll(hostDirectory, os.ModePerm); err != nil {
+			return err
+		}
+		for _, file := range strings.Split(listing.String(), "\n") {
+			file = strings.TrimSpace(file)
+			if !o.isAuditLogFile(file) || (o.incremental && o.downloadedBefore(hostDirectory, host, file)) {
+				continue
+			}
+			klog.V(4).Infof("Getting %s of %s ...", file, destination)
+			if err := o.downloadSSHAuditLog(ctx, destination, path.Join(dir, file), filepath.Join(hostDirectory, downloadedAuditLogName(host, file))); err != nil {
+				return fmt.Errorf("failed to get %s of %s: %v", file, destination, err)
+			}
+		}
+	}
+
+	klog.Infof("Audit logs successfully downloaded to %s", o.targetDirectory)
+	return nil
+}
+
+// downloadSSHAuditLog compresses the file on the host, unless it already is, and stores it.
+func (o *Options) downloadSSHAuditLog(ctx context.Context, destination, file, target string) error {
+	command := "gzip -c "
+	if isCompressedAuditLog(file) {
+		command = "cat "
+	}
+	return replaceFile(target, func(out io.Writer) error {
+		download := o.sshCommand(ctx, destination, command+shellQuote(file))
+		download.Stdout = out
+		return download.Run()
+	})
+}
@@ -0,0 +1,60 @@
+package get
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func TestSplitSSHDestination(t *testing.T) {
+	tests := []struct {
+		destination string
+		userHost    string
+		port        string
+		host        string
+	}{
+		{destination: "master-0", userHost: "master-0", host: "master-0"},
+		{destination: "core@master-0", userHost: "core@master-0", host: "master-0"},
+		{destination: "core@master-0:2222", userHost: "core@master-0", port: "2222", host: "master-0"},
+		{destination: "ssh://core@master-0:22", userHost: "core@master-0", port: "22", host: "master-0"},
+		{destination: "ssh://master-0", userHost: "master-0", host: "master-0"},
+		{destination: "10.0.1.23:22", userHost: "10.0.1.23", port: "22", host: "10.0.1.23"},
+		{destination: "core@[2001:db8::1]:22", userHost: "core@2001:db8::1", port: "22", host: "2001:db8::1"},
+		// an IPv6 address without brackets has no port
+		{destination: "core@2001:db8::1", userHost: "core@2001:db8::1", host: "2001:db8::1"},
+		// the host follows the last @
+		{destination: "user@example.com@master-0:22", userHost: "user@example.com@master-0", port: "22", host: "master-0"},
+	}
+	for _, test := range tests {
+		userHost, port := splitSSHDestination(test.destination)
+		if userHost != test.userHost || port != test.port {
+			t.Errorf("%s: expected %q and port %q, got %q and port %q", test.destination, test.userHost, test.port, userHost, port)
+		}
+		if host := sshHost(test.destination); host != test.host {
+			t.Errorf("%s: expected the host %q, got %q", test.destination, test.host, host)
+		}
+	}
+}
+
+func TestSSHCommand(t *testing.T) {
+	o := newOptions(genericclioptions.NewTestIOStreamsDiscard())
+	o.sshKey = "/keys/id_ed25519"
+
+	tests := []struct {
+		destination string
+		expected    string
+	}{
+		{destination: "core@master-0", expected: "ssh -o BatchMode=yes -i /keys/id_ed25519 -- core@master-0 ls"},
+		{destination: "ssh://core@master-0:2222", expected: "ssh -o BatchMode=yes -i /keys/id_ed25519 -p 2222 -- core@master-0 ls"},
+		// not taken for an option
+		{destination: "-oProxyCommand=x", expected: "ssh -o BatchMode=yes -i /keys/id_ed25519 -- -oProxyCommand=x ls"},
+	}
+	for _, test := range tests {
+		cmd := o.sshCommand(context.Background(), test.destination, "ls")
+		if actual := strings.Join(cmd.Args, " "); actual != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.destination, test.expected, actual)
+		}
+	}
+}
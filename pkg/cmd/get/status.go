@@ -0,0 +1,193 @@
+package get
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+	"k8s.io/kubectl/pkg/scheme"
+)
+
+// auditLogCompressionRatio is roughly how much gzip shrinks audit logs, they are highly repetitive JSON.
+const auditLogCompressionRatio = 10
+
+var apiServerConfigResource = schema.GroupVersionResource{Group: "config.openshift.io", Version: "v1", Resource: "apiservers"}
+
+type StatusOptions struct {
+	options *Options
+
+	genericclioptions.IOStreams
+}
+
+func NewStatusCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	o := &StatusOptions{options: newOptions(streams), IOStreams: streams}
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Check the audit logging of the cluster and the audit files available before downloading them",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmdutil.CheckErr(o.options.applyPlatform(cmd))
+			cmdutil.CheckErr(o.Complete(f))
+			cmdutil.CheckErr(o.Run(ctx))
+		},
+	}
+
+	o.options.addPodFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *StatusOptions) Complete(f cmdutil.Factory) error {
+	return o.options.completeClient(f)
+}
+
+// auditProfile returns the audit policy profile of OpenShift, empty when the cluster is not OpenShift.
+func (o *StatusOptions) auditProfile(ctx context.Context) (string, error) {
+	config, err := o.options.dynamic.Resource(apiServerConfigResource).Get(ctx, "cluster", metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	profile, _, err := unstructured.NestedString(config.Object, "spec", "audit", "profile")
+	if err != nil {
+		return "", err
+	}
+	if len(profile) == 0 {
+		profile = "Default"
+	}
+	return profile, nil
+}
+
+// auditFileStatus is an audit log file present in a kube-apiserver pod.
+type auditFileStatus struct {
+	name string
+	size int64
+}
+
+// listAuditFiles returns the audit logs next to the audit log path of the pod with their sizes.
+func (o *StatusOptions) listAuditFiles(pod string) ([]auditFileStatus, error) {
+	dir, _ := o.options.auditLogDir()
+	listing := &bytes.Buffer{}
+	if err := o.options.execInPod(pod, "ls -ln "+shellQuote(dir), listing); err != nil {
+		return nil, err
+	}
+	files := []auditFileStatus{}
+	for _, line := range strings.Split(listing.String(), "\n") {
+		// -rw-------. 1 0 0 104857412 Oct 16 05:13 audit-2026-10-16T05-13-10.123.log
+		fields := strings.Fields(line)
+		if len(fields) < 9 || !o.options.isAuditLogFile(fields[len(fields)-1]) {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			continue
+		}
+		files = append(files, auditFileStatus{name: fields[len(fields)-1], size: size})
+	}
+	return files, nil
+}
+
+func (o *StatusOptions) Run(ctx context.Context) error {
+	profile, err := o.auditProfile(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get the audit policy profile: %v", err)
+	}
+	pods, err := o.options.listAPIServerPods(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pods) == 0 {
+		return fmt.Errorf("no running kube-apiserver pods found in namespace %s", o.options.namespace)
+	}
+
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tPOD\tFILE\tSIZE")
+	var total int64
+	files, current := 0, 0
+	for _, pod := range pods {
+		podFiles, err := o.listAuditFiles(pod.Name)
+		if err != nil {
+			return fmt.Errorf("failed to list the audit logs of %s: %v", pod.Name, err)
+		}
+		for _, f := range podFiles {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", podNode(pod), pod.Name, f.name, FormatSize(f.size))
+			total += f.size
+			files++
+			if f.name == path.Base(o.options.auditLogPath) {
+				current++
+			}
+		}
+	}
+	w.Flush()
+	fmt.Fprintln(o.Out)
+
+	switch {
+	case len(profile) == 0:
+		fmt.Fprintln(o.Out, "Audit policy profile: unknown (not an OpenShift cluster)")
+	default:
+		fmt.Fprintf(o.Out, "Audit policy profile: %s\n", profile)
+	}
+	switch {
+	case profile == "None":
+		fmt.Fprintln(o.Out, "Audit logging: disabled by the audit policy profile None")
+	case current == 0:
+		fmt.Fprintf(o.Out, "Audit logging: disabled, no kube-apiserver pod writes %s\n", o.options.auditLogPath)
+	case current < len(pods):
+		fmt.Fprintf(o.Out, "Audit logging: enabled on %d of %d kube-apiserver pods\n", current, len(pods))
+	default:
+		fmt.Fprintln(o.Out, "Audit logging: enabled")
+	}
+	fmt.Fprintf(o.Out, "Audit files: %d, %s, estimated download %s gzipped\n", files, FormatSize(total), FormatSize(total/auditLogCompressionRatio))
+	return nil
+}
+
+func podNode(pod corev1.Pod) string {
+	if len(pod.Spec.NodeName) == 0 {
+		return "<none>"
+	}
+	return pod.Spec.NodeName
+}
+
+// FormatSize prints the size in bytes with a binary unit, eg. 1.5GiB.
+func FormatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// execInPod runs the shell command in the kube-apiserver container of the pod and writes its output to stdout.
+func (o *Options) execInPod(pod, command string, stdout io.Writer) error {
+	request := o.client.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Name(pod).
+		Namespace(o.namespace).
+		SubResource("exec")
+	request.VersionedParams(&corev1.PodExecOptions{
+		Container: o.container,
+		Stdout:    true,
+		Stderr:    true,
+		Command:   []string{"/bin/sh", "-c", command},
+	}, scheme.ParameterCodec)
+	return o.Executor.Execute("POST", request.URL(), o.Config, nil, stdout, o.ErrOut, false, nil)
+}
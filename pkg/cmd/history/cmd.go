@@ -0,0 +1,137 @@
+package history
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/custody"
+	"github.com/natamm4/audit-tool/pkg/workspace"
+)
+
+type Options struct {
+	targetDirectory string
+	showFiles       bool
+
+	genericclioptions.IOStreams
+}
+
+func NewCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &Options{IOStreams: streams}
+	cmd := &cobra.Command{
+		Use:   "history [--dir DIR]",
+		Short: "Show the chain of custody of the audit files: who collected and deleted them, when and where",
+		Long: "Show the chain of custody of the audit files: who collected and deleted them, when and where. get and prune\n" +
+			"append every operation to the operations log of the directory, the logs of its subdirectories (eg. of\n" +
+			"--contexts) are shown too. An entry not matching the checksum its successor recorded was edited afterwards\n" +
+			"and fails the command.",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			cmdutil.CheckErr(options.Complete())
+			cmdutil.CheckErr(options.Run())
+		},
+	}
+
+	cmd.Flags().StringVarP(&options.targetDirectory, "dir", "d", "", "Directory with the audit files. Defaults to the active workspace.")
+	cmd.Flags().BoolVar(&options.showFiles, "files", false, "List the files collected or deleted by every operation.")
+
+	return cmd
+}
+
+func (o *Options) Complete() error {
+	if len(o.targetDirectory) > 0 {
+		return nil
+	}
+	ws, err := workspace.Active()
+	if err != nil {
+		return err
+	}
+	if ws == nil {
+		return fmt.Errorf("no workspace is active, specify the audit directory (--dir/-d)")
+	}
+	o.targetDirectory = ws.Directory
+	return nil
+}
+
+// entry is an operation of the log of a directory.
+type entry struct {
+	dir string
+	custody.Operation
+}
+
+func (o *Options) Run() error {
+	root, err := filepath.EvalSymlinks(o.targetDirectory)
+	if err != nil {
+		return err
+	}
+	entries := []entry{}
+	edited := []string{}
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || info.Name() != custody.FileName {
+			return nil
+		}
+		dir := filepath.Dir(path)
+		operations, err := custody.Read(dir)
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(root, dir)
+		if err != nil {
+			return err
+		}
+		for i, op := range operations {
+			entries = append(entries, entry{dir: relative, Operation: op})
+			if !op.Intact {
+				edited = append(edited, fmt.Sprintf("%s: the entry before entry %d was edited or removed", filepath.Join(dir, custody.FileName), i+1))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("no operations recorded in %s", o.targetDirectory)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tOPERATION\tDIRECTORY\tUSER\tCLUSTER\tFILES\tCOMMAND")
+	for _, e := range entries {
+		user := e.User
+		if len(e.Host) > 0 {
+			user += "@" + e.Host
+		}
+		cluster := e.Cluster
+		if len(e.ClusterID) > 0 {
+			cluster += " (" + e.ClusterID + ")"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", e.Time.Local().Format(time.RFC3339), e.Operation.Operation, e.dir, user, cluster, len(e.Files), strings.Join(e.Command, " "))
+		if o.showFiles {
+			for _, f := range e.Files {
+				fmt.Fprintf(w, "\t\t%s\n", f)
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if len(edited) > 0 {
+		return fmt.Errorf("the chain of custody is broken:\n  %s", strings.Join(edited, "\n  "))
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package index
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/index"
+	"github.com/natamm4/audit-tool/pkg/cmd/query"
+)
+
+type Options struct {
+	force bool
+
+	// queryOptions selects the audit directories to index
+	queryOptions query.Options
+
+	genericclioptions.IOStreams
+}
+
+func NewCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &Options{IOStreams: streams, queryOptions: query.Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "index --dir DIR",
+		Short: "Index the audit files so that selective queries skip the files without matching events",
+		Long: "Index the audit files of the directories by bloom filters of their audit IDs, users, namespaces and resources.\n" +
+			"Queries selecting on exact values of --uid, --user, --namespace or --resource skip the audit files that\n" +
+			"certainly contain none of them without reading them. The index is stored in the directory as " + index.FileName + ",\n" +
+			"audit files added or changed since they were indexed are always read until the directory is indexed again.\n" +
+			"The time range of the events of every audit file is recorded as well, queries with --from or --to skip the\n" +
+			"audit files entirely outside of it. Queries record the time ranges of the files that aren't indexed yet.\n" +
+			"Uncompressed audit files get a checkpoint every 8 MiB as well, queries with --from start reading them at the\n" +
+			"last checkpoint before it instead of at their beginning.",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	options.queryOptions.AddMaxEventSizeFlag(cmd.Flags())
+	cmd.Flags().BoolVar(&options.force, "force", false, "Index all audit files again, not only the ones added or changed since the last index.")
+
+	return cmd
+}
+
+func (o *Options) Validate() error {
+	if len(o.queryOptions.TargetDirectories()) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	return nil
+}
+
+func (o *Options) Run(ctx context.Context) error {
+	localFiles, err := o.queryOptions.LocalAuditFiles()
+	if err != nil {
+		return err
+	}
+	for dir, auditFiles := range localFiles {
+		idx, err := index.Read(dir)
+		if err != nil {
+			return err
+		}
+		if idx == nil || o.force {
+			idx = index.New()
+		}
+
+		indexed, current, events := 0, 0, 0
+		files := map[string]struct{}{}
+		for _, f := range auditFiles {
+			files[f.Path] = struct{}{}
+			if entry, ok := idx.Files[f.Path]; ok && entry.Current(f.Size, f.ModTime) {
+				current++
+				continue
+			}
+			entry, timeRange, err := o.queryOptions.IndexAuditFile(ctx, f)
+			if err != nil {
+				return fmt.Errorf("indexing audit file %q failed: %v", f.Name, err)
+			}
+			idx.Files[f.Path] = entry
+			idx.Ranges[f.Path] = timeRange
+			indexed++
+			events += entry.Events
+		}
+		// audit files removed from the directory
+		for path := range idx.Files {
+			if _, ok := files[path]; !ok {
+				delete(idx.Files, path)
+			}
+		}
+		for path := range idx.Ranges {
+			if _, ok := files[path]; !ok {
+				delete(idx.Ranges, path)
+			}
+		}
+
+		if err := index.Write(dir, idx); err != nil {
+			return err
+		}
+		fmt.Fprintf(o.Out, "Indexed %d audit files (%d events) in %s, %d were up to date\n", indexed, events, dir, current)
+	}
+	return nil
+}
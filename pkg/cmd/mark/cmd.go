@@ -0,0 +1,106 @@
+package mark
+
+import (
+	"context"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/workspace"
+)
+
+type Options struct {
+	targetDirectory string
+	uids            []string
+	note            string
+
+	genericclioptions.IOStreams
+}
+
+func NewCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &Options{IOStreams: streams}
+	cmd := &cobra.Command{
+		Use:   "mark --uid AUDIT_ID [--note TEXT]",
+		Short: "Bookmark audit events and annotate them with notes",
+		Long: "Bookmark audit events and annotate them with notes. The marks are stored in the active workspace, or in the\n" +
+			"audit directory when --dir is given. Marked events are highlighted in the query output.",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmdutil.CheckErr(options.Validate())
+			cmdutil.CheckErr(options.Run())
+		},
+	}
+
+	cmd.Flags().StringVarP(&options.targetDirectory, "dir", "d", "", "Directory with the audit files to store the marks in. Defaults to the active workspace.")
+	cmd.Flags().StringSliceVar(&options.uids, "uid", options.uids, "Audit IDs of the events to mark.")
+	cmd.Flags().StringVar(&options.note, "note", "", "Note to attach to the marked events.")
+
+	cmd.AddCommand(newListCommand(streams))
+	return cmd
+}
+
+func (o *Options) Validate() error {
+	if len(o.uids) == 0 {
+		return fmt.Errorf("audit ID of the event to mark must be specified (--uid)")
+	}
+	return nil
+}
+
+func (o *Options) Run() error {
+	if len(o.targetDirectory) > 0 {
+		for _, uid := range o.uids {
+			if err := workspace.AddDirectoryMark(o.targetDirectory, uid, o.note); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	ws, err := workspace.Active()
+	if err != nil {
+		return err
+	}
+	if ws == nil {
+		return fmt.Errorf("no workspace is active, specify the audit directory to store the marks in (--dir/-d)")
+	}
+	for _, uid := range o.uids {
+		ws.AddBookmark(uid, o.note)
+	}
+	return ws.Save()
+}
+
+func newListCommand(streams genericclioptions.IOStreams) *cobra.Command {
+	targetDirectory := ""
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the marked audit events",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			dir := targetDirectory
+			if len(dir) == 0 {
+				ws, err := workspace.Active()
+				cmdutil.CheckErr(err)
+				if ws == nil {
+					cmdutil.CheckErr(fmt.Errorf("no workspace is active, specify the audit directory (--dir/-d)"))
+				}
+				dir = ws.Directory
+			}
+			marks, err := workspace.LoadMarks(dir)
+			cmdutil.CheckErr(err)
+
+			w := tabwriter.NewWriter(streams.Out, 0, 0, 2, ' ', 0)
+			defer w.Flush()
+			fmt.Fprintln(w, "AUDIT ID\tMARKED\tNOTE")
+			for _, auditID := range sets.StringKeySet(marks).List() {
+				mark := marks[auditID]
+				fmt.Fprintf(w, "%s\t%s\t%s\n", mark.AuditID, mark.Time.Format(time.RFC3339), mark.Note)
+			}
+		},
+	}
+	cmd.Flags().StringVarP(&targetDirectory, "dir", "d", "", "Directory with the audit files. Defaults to the active workspace.")
+	return cmd
+}
@@ -0,0 +1,370 @@
+package prune
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/custody"
+	"github.com/natamm4/audit-tool/pkg/audit/manifest"
+	"github.com/natamm4/audit-tool/pkg/cmd/get"
+)
+
+type Options struct {
+	targetDirectory string
+	keep            string
+	maxSize         string
+	dryRun          bool
+	signKeyFile     string
+
+	keepDuration time.Duration
+	maxBytes     int64
+	signKey      ed25519.PrivateKey
+
+	genericclioptions.IOStreams
+}
+
+func NewCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &Options{IOStreams: streams}
+	cmd := &cobra.Command{
+		Use:   "prune --dir DIR [--keep 14d] [--max-size 50G]",
+		Short: "Delete the oldest downloaded and compacted audit files according to a retention policy",
+		Long: "Delete the oldest downloaded and compacted audit files according to a retention policy, so that scheduled\n" +
+			"downloads don't fill the disk. The audit files under the directory last modified longer than --keep ago are\n" +
+			"deleted, then the oldest remaining ones until they total at most --max-size. Only the files named like audit\n" +
+			"logs are pruned (eg. master-0-audit.log, master-0-audit-2021-09-01T10-00-00.000.log.gz), compressed or not, the\n" +
+			"other files under the directory are left alone. The deleted files are removed from the manifests of their\n" +
+			"dumps, so that the remaining files still verify, and the directories left empty are removed.",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmdutil.CheckErr(options.Validate())
+			cmdutil.CheckErr(options.Run())
+		},
+	}
+
+	cmd.Flags().StringVarP(&options.targetDirectory, "dir", "d", "", "Directory with the downloaded or compacted audit files, eg. the output directory of the scheduled downloads.")
+	cmd.Flags().StringVar(&options.keep, "keep", "", "Delete the files last modified longer ago than this, eg. 14d, 2w or 12h.")
+	cmd.Flags().StringVar(&options.maxSize, "max-size", "", "Delete the oldest files until the remaining ones total at most this size, eg. 50G or 500Mi.")
+	cmd.Flags().BoolVar(&options.dryRun, "dry-run", false, "Only print the files that would be deleted.")
+	cmd.Flags().StringVar(&options.signKeyFile, "sign-key", "", "Sign the pruned manifests again with this Ed25519 private key (PEM), the key get signed them with. Required to prune a part of a signed dump.")
+
+	return cmd
+}
+
+func (o *Options) Validate() error {
+	if len(o.targetDirectory) == 0 {
+		return fmt.Errorf("directory to prune must be specified (--dir/-d)")
+	}
+	if len(o.keep) == 0 && len(o.maxSize) == 0 {
+		return fmt.Errorf("a retention policy must be specified (--keep and/or --max-size)")
+	}
+	if len(o.keep) > 0 {
+		d, err := parseRetention(o.keep)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid --keep %q, expected a positive duration like 14d, 2w or 12h", o.keep)
+		}
+		o.keepDuration = d
+	}
+	if len(o.maxSize) > 0 {
+		quantity, err := resource.ParseQuantity(o.maxSize)
+		if err != nil || quantity.Sign() < 0 {
+			return fmt.Errorf("invalid --max-size %q, expected a size like 50G or 500Mi", o.maxSize)
+		}
+		o.maxBytes = quantity.Value()
+	}
+	if len(o.signKeyFile) > 0 {
+		key, err := manifest.LoadPrivateKey(o.signKeyFile)
+		if err != nil {
+			return err
+		}
+		o.signKey = key
+	}
+	return nil
+}
+
+// parseRetention parses a Go duration, or a number of days or weeks like 14d or 2w.
+func parseRetention(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if strings.HasSuffix(s, suffix) {
+			n, err := strconv.Atoi(strings.TrimSuffix(s, suffix))
+			if err != nil {
+				return 0, err
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+type auditFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func (o *Options) Run() error {
+	// a latest symlink is pruned as the dump it points to
+	root, err := filepath.EvalSymlinks(o.targetDirectory)
+	if err != nil {
+		return err
+	}
+	files, err := listFiles(root)
+	if err != nil {
+		return err
+	}
+	pruned, kept := o.retain(files, time.Now())
+	manifests, deleted, err := prunedManifests(root, pruned)
+	if err != nil {
+		return err
+	}
+	if err := o.checkSignatures(manifests, deleted); err != nil {
+		return err
+	}
+
+	removedSize, keptSize := int64(0), int64(0)
+	for _, f := range kept {
+		keptSize += f.size
+	}
+	verb := "Removed"
+	if o.dryRun {
+		verb = "Would remove"
+	}
+	for _, f := range pruned {
+		fmt.Fprintf(o.Out, "%s (%s, modified %s)\n", f.path, get.FormatSize(f.size), f.modTime.Format(time.RFC3339))
+		removedSize += f.size
+		if o.dryRun {
+			continue
+		}
+		if err := os.Remove(f.path); err != nil {
+			return err
+		}
+	}
+	if !o.dryRun && len(pruned) > 0 {
+		if err := o.updateManifests(manifests, deleted); err != nil {
+			return err
+		}
+		if err := removeEmptyDirectories(root); err != nil {
+			return err
+		}
+		if err := recordPrune(root, pruned); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(o.Out, "%s %d file(s) (%s), %d file(s) (%s) kept\n", verb, len(pruned), get.FormatSize(removedSize), len(kept), get.FormatSize(keptSize))
+	return nil
+}
+
+// retain splits the files, oldest first, into the ones to delete and the ones to keep.
+func (o *Options) retain(files []auditFile, now time.Time) ([]auditFile, []auditFile) {
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	total := int64(0)
+	for _, f := range files {
+		total += f.size
+	}
+	i := 0
+	for ; i < len(files); i++ {
+		expired := o.keepDuration > 0 && now.Sub(files[i].modTime) > o.keepDuration
+		tooLarge := len(o.maxSize) > 0 && total > o.maxBytes
+		if !expired && !tooLarge {
+			break
+		}
+		total -= files[i].size
+	}
+	return files[:i], files[i:]
+}
+
+// auditFileName matches the names of the audit files get and compact write, without their compression extension: the
+// active and rotated logs of a node (eg. master-0-audit.log, master-0-audit-2021-09-01T10-00-00.000.log), the compacted
+// chunks named like the rotated logs, and the rotated-audit-logs* and audit-log* files of the exec backend.
+var auditFileName = regexp.MustCompile(`^(.+-audit(-[0-9]{4}-[0-9]{2}-[0-9]{2}T[0-9]{2}-[0-9]{2}-[0-9]{2}\.[0-9]{3})?\.log|(rotated-audit-logs|audit-log)[0-9]+)$`)
+
+// compressionExtensions are the extensions of the compressed audit files.
+var compressionExtensions = []string{".gz", ".bz2", ".zst"}
+
+// isAuditFile returns whether the file is an audit file, optionally compressed.
+func isAuditFile(name string) bool {
+	for _, extension := range compressionExtensions {
+		if strings.HasSuffix(name, extension) {
+			name = strings.TrimSuffix(name, extension)
+			break
+		}
+	}
+	return auditFileName.MatchString(name)
+}
+
+// listFiles returns the audit files under the directory. The manifests, their signatures, the indexes and the files of
+// other tools stored next to the audit files are never pruned.
+func listFiles(dir string) ([]auditFile, error) {
+	files := []auditFile{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || !isAuditFile(info.Name()) {
+			return nil
+		}
+		files = append(files, auditFile{path: path, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	return files, err
+}
+
+// prunedManifests returns the manifests of the dumps of the pruned files, the manifest in the closest parent directory,
+// and the paths of the pruned files relative to it by directory.
+func prunedManifests(root string, pruned []auditFile) (map[string]*manifest.Manifest, map[string]map[string]bool, error) {
+	manifests := map[string]*manifest.Manifest{}
+	deleted := map[string]map[string]bool{}
+	for _, f := range pruned {
+		for dir := filepath.Dir(f.path); ; dir = filepath.Dir(dir) {
+			m, ok := manifests[dir]
+			if !ok {
+				var err error
+				if m, err = manifest.Read(dir); err != nil {
+					return nil, nil, err
+				}
+				manifests[dir] = m
+			}
+			if m != nil {
+				relative, err := filepath.Rel(dir, f.path)
+				if err != nil {
+					return nil, nil, err
+				}
+				if deleted[dir] == nil {
+					deleted[dir] = map[string]bool{}
+				}
+				deleted[dir][filepath.ToSlash(relative)] = true
+				break
+			}
+			if rel, err := filepath.Rel(root, dir); err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+				break
+			}
+		}
+	}
+	return manifests, deleted, nil
+}
+
+// remainingFiles returns the files of the manifest that are not deleted.
+func remainingFiles(m *manifest.Manifest, deleted map[string]bool) []manifest.File {
+	remaining := []manifest.File{}
+	for _, f := range m.Files {
+		if !deleted[f.Path] {
+			remaining = append(remaining, f)
+		}
+	}
+	return remaining
+}
+
+// checkSignatures refuses to prune a part of a signed dump without --sign-key, its pruned manifest would no longer
+// match the signature. The files of a dump pruned entirely are deleted with its manifest and signature.
+func (o *Options) checkSignatures(manifests map[string]*manifest.Manifest, deleted map[string]map[string]bool) error {
+	if o.signKey != nil {
+		return nil
+	}
+	for dir, paths := range deleted {
+		if len(remainingFiles(manifests[dir], paths)) == 0 {
+			continue
+		}
+		signed, err := manifest.Signed(dir)
+		if err != nil {
+			return err
+		}
+		if signed {
+			return fmt.Errorf("the manifest of %s is signed, prune it with --sign-key to sign the pruned manifest again", dir)
+		}
+	}
+	return nil
+}
+
+// updateManifests removes the deleted files from the manifests of their dumps. A manifest without any remaining file is
+// deleted, a signed manifest is signed again with --sign-key.
+func (o *Options) updateManifests(manifests map[string]*manifest.Manifest, deleted map[string]map[string]bool) error {
+	for dir, paths := range deleted {
+		m := manifests[dir]
+		remaining := remainingFiles(m, paths)
+		if len(remaining) == 0 {
+			for _, name := range []string{manifest.FileName, manifest.SignatureFileName} {
+				if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+			}
+			continue
+		}
+		m.Files = remaining
+		if err := m.Store(dir); err != nil {
+			return err
+		}
+		if o.signKey != nil {
+			if err := manifest.Sign(dir, o.signKey); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// recordPrune records the deleted files in the operations log of the directory.
+func recordPrune(root string, pruned []auditFile) error {
+	op := custody.Operation{Operation: "prune"}
+	for _, f := range pruned {
+		relative, err := filepath.Rel(root, f.path)
+		if err != nil {
+			return err
+		}
+		op.Files = append(op.Files, filepath.ToSlash(relative))
+	}
+	return custody.Record(root, op)
+}
+
+// removeEmptyDirectories removes the directories under root left empty and the latest symlinks to removed dumps.
+func removeEmptyDirectories(root string) error {
+	dirs := []string{}
+	links := []string{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		switch {
+		case info.IsDir() && path != root:
+			dirs = append(dirs, path)
+		case info.Mode()&os.ModeSymlink != 0 && info.Name() == "latest":
+			links = append(links, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// deepest first, so that a parent is empty once its empty subdirectories are removed
+	for i := len(dirs) - 1; i >= 0; i-- {
+		entries, err := os.ReadDir(dirs[i])
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			if err := os.Remove(dirs[i]); err != nil {
+				return err
+			}
+		}
+	}
+	for _, link := range links {
+		if _, err := os.Stat(link); os.IsNotExist(err) {
+			if err := os.Remove(link); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
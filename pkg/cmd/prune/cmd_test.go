@@ -0,0 +1,174 @@
+package prune
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/natamm4/audit-tool/pkg/audit/manifest"
+)
+
+// signedDump writes a dump of two audit files modified a day apart, with its manifest signed by the key.
+func signedDump(t *testing.T, key ed25519.PrivateKey) string {
+	dir := t.TempDir()
+	now := time.Now()
+	for i, name := range []string{"master-0-audit-2026-10-01T10-00-00.000.log.gz", "master-0-audit.log.gz"} {
+		path := filepath.Join(dir, "master-0", name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modified := now.Add(time.Duration(i-1) * 24 * time.Hour)
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := manifest.Write(dir, &manifest.Manifest{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := manifest.Sign(dir, key); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestPruneSignedDump(t *testing.T) {
+	public, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := signedDump(t, key)
+	o := &Options{targetDirectory: dir, keep: "12h", keepDuration: 12 * time.Hour, IOStreams: genericclioptions.NewTestIOStreamsDiscard()}
+	if err := o.Run(); err == nil || !strings.Contains(err.Error(), "--sign-key") {
+		t.Fatalf("expected pruning a signed dump without --sign-key to fail, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "master-0", "master-0-audit-2026-10-01T10-00-00.000.log.gz")); err != nil {
+		t.Errorf("expected the refused prune to keep the files: %v", err)
+	}
+
+	o.signKey = key
+	if err := o.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := manifest.VerifySignature(dir, public); err != nil {
+		t.Errorf("expected the pruned manifest to be signed again: %v", err)
+	}
+	m, err := manifest.Read(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Files) != 1 || m.Files[0].Path != "master-0/master-0-audit.log.gz" {
+		t.Errorf("expected only the current audit log to remain in the manifest, got %v", m.Files)
+	}
+}
+
+func TestParseRetention(t *testing.T) {
+	tests := []struct {
+		keep     string
+		expected time.Duration
+		err      bool
+	}{
+		{keep: "14d", expected: 14 * 24 * time.Hour},
+		{keep: "2w", expected: 14 * 24 * time.Hour},
+		{keep: "12h", expected: 12 * time.Hour},
+		{keep: "90m", expected: 90 * time.Minute},
+		{keep: "1.5d", err: true},
+		{keep: "d", err: true},
+		{keep: "14", err: true},
+	}
+	for _, test := range tests {
+		actual, err := parseRetention(test.keep)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %v", test.keep, actual)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.keep, err)
+		} else if actual != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.keep, test.expected, actual)
+		}
+	}
+}
+
+func TestRetain(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	// ten files of 100 bytes modified a day apart, the newest one a day ago
+	files := func() []auditFile {
+		files := []auditFile{}
+		for i := 1; i <= 10; i++ {
+			files = append(files, auditFile{path: fmt.Sprintf("day-%d", i), size: 100, modTime: now.Add(-time.Duration(i) * 24 * time.Hour)})
+		}
+		return files
+	}
+
+	tests := []struct {
+		name     string
+		keep     time.Duration
+		maxSize  string
+		maxBytes int64
+		pruned   []string
+	}{
+		{name: "nothing expired", keep: 30 * 24 * time.Hour, pruned: []string{}},
+		{name: "older than keep", keep: 8*24*time.Hour + time.Hour, pruned: []string{"day-10", "day-9"}},
+		{name: "exactly keep old", keep: 9 * 24 * time.Hour, pruned: []string{"day-10"}},
+		{name: "too large", maxSize: "750", maxBytes: 750, pruned: []string{"day-10", "day-9", "day-8"}},
+		{name: "within the size", maxSize: "1k", maxBytes: 1000, pruned: []string{}},
+		{name: "zero size", maxSize: "0", maxBytes: 0, pruned: []string{"day-10", "day-9", "day-8", "day-7", "day-6", "day-5", "day-4", "day-3", "day-2", "day-1"}},
+		// whichever policy deletes more
+		{name: "keep and size", keep: 8*24*time.Hour + time.Hour, maxSize: "500", maxBytes: 500, pruned: []string{"day-10", "day-9", "day-8", "day-7", "day-6"}},
+		{name: "size and keep", keep: 5*24*time.Hour + time.Hour, maxSize: "900", maxBytes: 900, pruned: []string{"day-10", "day-9", "day-8", "day-7", "day-6"}},
+	}
+	for _, test := range tests {
+		o := &Options{keepDuration: test.keep, maxSize: test.maxSize, maxBytes: test.maxBytes}
+		pruned, kept := o.retain(files(), now)
+		names := []string{}
+		for _, f := range pruned {
+			names = append(names, f.path)
+		}
+		if strings.Join(names, ",") != strings.Join(test.pruned, ",") {
+			t.Errorf("%s: expected to prune %v, got %v", test.name, test.pruned, names)
+		}
+		if len(pruned)+len(kept) != 10 {
+			t.Errorf("%s: expected 10 files pruned or kept, got %d and %d", test.name, len(pruned), len(kept))
+		}
+	}
+}
+
+func TestIsAuditFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{name: "master-0-audit.log", expected: true},
+		{name: "master-0-audit.log.gz", expected: true},
+		{name: "master-0-audit-2021-09-01T10-00-00.000.log", expected: true},
+		{name: "master-0-audit-2021-09-01T10-00-00.000.log.zst", expected: true},
+		{name: "ip-10-0-1-23-audit-2021-09-01T10-00-00.000.log.bz2", expected: true},
+		{name: "rotated-audit-logs1.gz", expected: true},
+		{name: "audit-log0", expected: true},
+		{name: "audit.log", expected: false},
+		{name: "master-0-audit.log.gz.sig", expected: false},
+		{name: "master-0-audit-2021-09-01.log", expected: false},
+		{name: "master-0-audit.log.tar", expected: false},
+		{name: "manifest.json", expected: false},
+		{name: "manifest.json.sig", expected: false},
+		{name: "index.json", expected: false},
+		{name: "notes.txt.gz", expected: false},
+	}
+	for _, test := range tests {
+		if actual := isAuditFile(test.name); actual != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, actual)
+		}
+	}
+}
@@ -1,52 +1,57 @@
 package query
 
 import (
-	"fmt"
-	"os"
-	"path/filepath"
+	"context"
+	"io"
 	"sort"
 	"strings"
 	"time"
+
+	"github.com/natamm4/audit-tool/pkg/audit/decompress"
+	"github.com/natamm4/audit-tool/pkg/audit/source"
 )
 
+// AuditDirReader maps the audit files of one or more clusters to the nodes that wrote them. When several clusters are
+// read, the node names are qualified with the cluster name (eg. prod/master-0), as node names repeat across clusters.
 type AuditDirReader struct {
-	files map[string][]auditFile
+	sources map[string]source.EventSource
+	files   map[string][]auditFile
 }
 
 type auditFile struct {
 	name      string
-	filePath  string
+	cluster   string
+	node      string
+	file      source.File
 	timestamp time.Time
 }
 
-func NewAuditDirReader(dir string) (*AuditDirReader, error) {
-	dirStat, err := os.Stat(dir)
-	if os.IsNotExist(err) {
-		return nil, err
-	}
-	if !dirStat.IsDir() {
-		return nil, fmt.Errorf("not a directory %q", dir)
-	}
+func NewAuditDirReader(ctx context.Context, src source.EventSource) (*AuditDirReader, error) {
+	return NewClusterAuditDirReader(ctx, map[string]source.EventSource{"": src}, nil)
+}
 
+// NewClusterAuditDirReader reads the audit files of the sources keyed by cluster name, an empty cluster name leaves
+// the node names unqualified. The nodes of the files are derived by the namer, nil for the default rules.
+func NewClusterAuditDirReader(ctx context.Context, sources map[string]source.EventSource, namer *nodeNamer) (*AuditDirReader, error) {
 	auditFiles := []auditFile{}
-
-	if err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		// TODO: make this recursive
-		if info.IsDir() {
-			return nil
+	for cluster, src := range sources {
+		sourceFiles, err := src.List(ctx)
+		if err != nil {
+			return nil, err
 		}
-		// audit files only
-		if !strings.Contains(info.Name(), "-audit") {
-			return nil
+		for _, f := range sourceFiles {
+			node, ok := namer.node(f)
+			if !ok {
+				continue
+			}
+			auditFiles = append(auditFiles, auditFile{
+				name:      f.Name,
+				cluster:   cluster,
+				node:      node,
+				file:      f,
+				timestamp: parseTimeFromRotatedAuditFile(f.Name, f.ModTime),
+			})
 		}
-		auditFiles = append(auditFiles, auditFile{
-			name:      info.Name(),
-			filePath:  filepath.Join(dir, info.Name()),
-			timestamp: parseTimeFromRotatedAuditFile(info.Name(), info.ModTime()),
-		})
-		return nil
-	}); err != nil {
-		return nil, err
 	}
 
 	sort.Slice(auditFiles, func(i, j int) bool {
@@ -56,16 +61,36 @@ func NewAuditDirReader(dir string) (*AuditDirReader, error) {
 	// now map the audit files to nodes
 	files := map[string][]auditFile{}
 	for _, f := range auditFiles {
-		parts := strings.Split(f.name, "-audit")
-		nodeName := parts[0]
-		if _, ok := files[nodeName]; !ok {
-			files[nodeName] = []auditFile{f}
-		} else {
-			files[nodeName] = append(files[nodeName], f)
+		nodeName := f.node
+		if len(f.cluster) > 0 {
+			nodeName = f.cluster + "/" + f.node
 		}
+		files[nodeName] = append(files[nodeName], f)
 	}
 
-	return &AuditDirReader{files: files}, nil
+	return &AuditDirReader{sources: sources, files: files}, nil
+}
+
+// Open returns the raw content of the given audit file.
+func (r *AuditDirReader) Open(ctx context.Context, f auditFile) (io.ReadCloser, error) {
+	return r.sources[f.cluster].Open(ctx, f.file)
+}
+
+// queried returns whether the audit file is the result of a query of a logging service, holding the events of the
+// query time range only.
+func (r *AuditDirReader) queried(f auditFile) bool {
+	_, ok := r.sources[f.cluster].(source.QuerySource)
+	return ok
+}
+
+// queriesLogServices returns whether any of the sources queries a logging service.
+func (r *AuditDirReader) queriesLogServices() bool {
+	for _, src := range r.sources {
+		if _, ok := src.(source.QuerySource); ok {
+			return true
+		}
+	}
+	return false
 }
 
 func parseTimeFromRotatedAuditFile(name string, modTime time.Time) time.Time {
@@ -77,7 +102,7 @@ func parseTimeFromRotatedAuditFile(name string, modTime time.Time) time.Time {
 	if len(parts) != 2 {
 		return modTime.In(utcTime)
 	}
-	timeString := strings.TrimSuffix(parts[1], ".log.gz")
+	timeString := strings.TrimSuffix(decompress.TrimExtension(parts[1]), ".log")
 	timeT, err := time.Parse("2006-01-02T15-04-05.000", timeString)
 	if err != nil {
 		return modTime.In(utcTime)
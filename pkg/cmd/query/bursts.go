@@ -0,0 +1,153 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+)
+
+// burstTimeFormat shows the milliseconds, bursts within a second are common.
+const burstTimeFormat = "2006-01-02 15:04:05.000"
+
+type BurstsOptions struct {
+	by     string
+	window time.Duration
+	limit  int
+
+	// queryOptions selects and filters the events to analyze
+	queryOptions Options
+
+	genericclioptions.IOStreams
+}
+
+func NewBurstsCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &BurstsOptions{IOStreams: streams, queryOptions: Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "bursts --dir DIR",
+		Short: "Report the clients with the highest request rate within a sliding window and when their burst happened",
+		Long: "Report the clients with the highest request rate within a sliding window and when their burst happened, to\n" +
+			"correlate client bursts with API server latency incidents. For every client the window of --window duration\n" +
+			"with the most requests is found, the window slides over the exact times the requests were received. Only\n" +
+			"the ResponseComplete events are counted, so every request is counted once. The receive times of the\n" +
+			"requests are kept in memory, 8 bytes per request.",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	cmd.Flags().StringVar(&options.queryOptions.from, "from", "", "Only analyze events starting at this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().StringVar(&options.queryOptions.to, "to", "", "Only analyze events before this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().IntVar(&options.queryOptions.maxEventSize, "max-event-size", defaultMaxEventSize, "Maximum size in bytes of a single audit event.")
+	cmd.Flags().StringVar(&options.by, "by", "user", "Client to detect the bursts of, one of "+fmt.Sprint(topDimensions())+".")
+	cmd.Flags().DurationVar(&options.window, "window", time.Second, "Duration of the sliding window the requests are counted in.")
+	cmd.Flags().IntVar(&options.limit, "limit", 20, "Number of clients with the highest bursts to display.")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *BurstsOptions) Validate() error {
+	if len(o.queryOptions.targetDirectories) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	if o.window <= 0 {
+		return fmt.Errorf("--window must be positive")
+	}
+	return validateTopBy(o.by)
+}
+
+// burst is the window with the most requests of a client.
+type burst struct {
+	client   string
+	requests int
+	// start and end are the times the first and the last request of the window were received
+	start, end  time.Time
+	total       int
+	first, last time.Time
+}
+
+// maxBurst returns the window with the most requests, received are the sorted receive times in nanoseconds.
+func maxBurst(received []int64, window time.Duration) (int, int) {
+	best, bestStart := 0, 0
+	start := 0
+	for end := range received {
+		for received[end]-received[start] >= int64(window) {
+			start++
+		}
+		if count := end - start + 1; count > best {
+			best, bestStart = count, start
+		}
+	}
+	return best, bestStart
+}
+
+func (o *BurstsOptions) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+
+	keyFunc := topKeyFuncs[o.by]
+	received := map[string][]int64{}
+	if err := o.queryOptions.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			if e.Stage != auditv1.StageResponseComplete {
+				continue
+			}
+			key := keyFunc(e)
+			received[key] = append(received[key], e.RequestReceivedTimestamp.UnixNano())
+		}
+	}); err != nil {
+		return err
+	}
+
+	bursts := make([]burst, 0, len(received))
+	for client, times := range received {
+		sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+		requests, start := maxBurst(times, o.window)
+		bursts = append(bursts, burst{
+			client:   client,
+			requests: requests,
+			start:    time.Unix(0, times[start]).UTC(),
+			end:      time.Unix(0, times[start+requests-1]).UTC(),
+			total:    len(times),
+			first:    time.Unix(0, times[0]).UTC(),
+			last:     time.Unix(0, times[len(times)-1]).UTC(),
+		})
+	}
+	sort.Slice(bursts, func(i, j int) bool {
+		if bursts[i].requests != bursts[j].requests {
+			return bursts[i].requests > bursts[j].requests
+		}
+		return bursts[i].client < bursts[j].client
+	})
+
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "%s\tPEAK REQUESTS/%s\tPEAK RPS\tAVG RPS\tREQUESTS\tBURST START\tBURST END\n", strings.ToUpper(o.by), o.window)
+	for i, b := range bursts {
+		if o.limit > 0 && i >= o.limit {
+			break
+		}
+		// the average over the time the client was active puts the peak into perspective
+		avg := "-"
+		if active := b.last.Sub(b.first); active >= o.window {
+			avg = fmt.Sprintf("%.2f", float64(b.total)/active.Seconds())
+		}
+		fmt.Fprintf(w, "%s\t%d\t%.2f\t%s\t%d\t%s\t%s\n", MatrixKey(b.client), b.requests, float64(b.requests)/o.window.Seconds(), avg, b.total,
+			b.start.Format(burstTimeFormat), b.end.Format(burstTimeFormat))
+	}
+	return nil
+}
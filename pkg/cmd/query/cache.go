@@ -0,0 +1,130 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+
+	"github.com/natamm4/audit-tool/pkg/audit/filter"
+	"github.com/natamm4/audit-tool/pkg/audit/provenance"
+	"github.com/natamm4/audit-tool/pkg/querycache"
+)
+
+// queryCache replays the events a previous run of the same query accepted from the same audit files: only the
+// recorded lines are decoded and the filters are skipped. Audit files read for the first time are filtered and their
+// accepted lines recorded.
+type queryCache struct {
+	key   string
+	entry *querycache.Entry
+	dirty bool
+}
+
+// cacheable reports whether the results of the query can be cached. Sampled events differ between runs, live events,
+// the standard input and the logging services change all the time, enrichers have to annotate the events every time
+// and without filters every event is accepted anyway.
+func (o Options) cacheable(filters filter.AuditFilters) bool {
+	return !o.noCache && !o.live && !o.readsStdin() && !o.auditFiles.queriesLogServices() && o.sampleRate == 0 && o.everyNth == 0 && len(o.enrichers) == 0 && len(filters) > 0
+}
+
+// newQueryCache returns the cache of the query, keyed by the audit files and the fingerprint of the filter flags.
+func (o Options) newQueryCache() (*queryCache, error) {
+	key := querycache.Key(o.auditFilesFingerprint(), o.filterFingerprint())
+	entry, err := querycache.Load(key)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		entry = &querycache.Entry{Created: time.Now().UTC(), Files: map[string][]int{}}
+	}
+	return &queryCache{key: key, entry: entry}, nil
+}
+
+// auditFilesFingerprint identifies the content of the audit files by their location, size and modification time.
+func (o Options) auditFilesFingerprint() string {
+	fingerprint := []string{o.sourceLocation}
+	fingerprint = append(fingerprint, o.targetDirectories...)
+	for _, node := range o.nodeNames.List() {
+		for _, f := range o.auditFiles.files[node] {
+			fingerprint = append(fingerprint, f.cluster, f.file.Path, strconv.FormatInt(f.file.Size, 10), strconv.FormatInt(f.file.ModTime.UnixNano(), 10))
+		}
+	}
+	return querycache.Key(fingerprint...)
+}
+
+// filterFingerprint identifies the events selected by the filter flags. Every flag changing which events are read or
+// accepted must be part of it.
+func (o Options) filterFingerprint() string {
+	data, _ := json.Marshal(map[string]interface{}{
+		"nodes":              o.nodes,
+		"from":               o.from,
+		"to":                 o.to,
+		"maxEventSize":       o.maxEventSize,
+		"uids":               o.uids,
+		"verbs":              o.verbs,
+		"resources":          o.resources,
+		"subresources":       o.subresources,
+		"namespaces":         o.namespaces,
+		"names":              o.names,
+		"users":              o.users,
+		"clusters":           o.clusters,
+		"failedOnly":         o.failedOnly,
+		"httpStatusCodes":    o.httpStatusCodes,
+		"stages":             o.stages,
+		"patchTypes":         o.patchTypes,
+		"fieldManagers":      o.fieldManagers,
+		"selectors":          o.selectors,
+		"objectSelector":     o.objectSelector,
+		"owners":             o.owners,
+		"rvZeroOnly":         o.rvZeroOnly,
+		"leaderElection":     o.leaderElection,
+		"excludeLeader":      o.excludeLeader,
+		"excludeNoise":       o.excludeNoise,
+		"celExpressions":     o.celExpressions,
+		"jqExpression":       o.jqExpression,
+		"filterExec":         o.filterExec,
+		"duration":           o.duration,
+		"fingerprintVersion": 1,
+	})
+	return string(data)
+}
+
+// lines returns the recorded lines of the audit file, false when the file wasn't read by a previous run.
+func (c *queryCache) lines(file string) ([]int, bool) {
+	lines, ok := c.entry.Files[file]
+	return lines, ok
+}
+
+// record returns a visit function recording the lines of the events accepted from the audit file before passing them
+// on to visit.
+func (c *queryCache) record(file string, visit func([]*auditv1.Event)) func([]*auditv1.Event) {
+	c.entry.Files[file] = []int{}
+	c.dirty = true
+	return func(events []*auditv1.Event) {
+		for _, e := range events {
+			if origin, ok := provenance.Get(e); ok {
+				c.entry.Files[file] = append(c.entry.Files[file], origin.Line)
+			}
+		}
+		visit(events)
+	}
+}
+
+// forget drops the recorded lines of the audit file, it is read again by the next run.
+func (c *queryCache) forget(file string) {
+	delete(c.entry.Files, file)
+}
+
+// save stores the lines recorded since the cache was loaded, once all audit files were read.
+func (c *queryCache) save() error {
+	if !c.dirty {
+		return nil
+	}
+	if err := querycache.Save(c.key, c.entry); err != nil {
+		return fmt.Errorf("unable to cache the query results (use --no-cache to disable the cache): %v", err)
+	}
+	c.dirty = false
+	return nil
+}
@@ -0,0 +1,277 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/sets"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/falco"
+	"github.com/natamm4/audit-tool/pkg/audit/notify"
+	"github.com/natamm4/audit-tool/pkg/audit/sarif"
+)
+
+type CheckOptions struct {
+	maxErrorRate    float64
+	maxLatencies    map[string]string
+	maxStatusCounts map[string]int64
+	output          string
+	notifyURL       string
+	notifyEvidence  string
+
+	// queryOptions selects and filters the events the checks are evaluated over
+	queryOptions Options
+
+	genericclioptions.IOStreams
+}
+
+// CheckReport is the machine-readable result of the checks.
+type CheckReport struct {
+	Passed     bool             `json:"passed"`
+	Events     int64            `json:"events"`
+	Violations []CheckViolation `json:"violations"`
+}
+
+type CheckViolation struct {
+	Check     string `json:"check"`
+	Threshold string `json:"threshold"`
+	Actual    string `json:"actual"`
+}
+
+func NewCheckCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &CheckOptions{IOStreams: streams, queryOptions: Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "Evaluate error rate, latency and status code thresholds over the filtered events",
+		Long: "Evaluate error rate, latency and status code thresholds over the filtered events. The violations are printed\n" +
+			"as JSON report and the command exits with non-zero code when any threshold is exceeded. Every request is\n" +
+			"counted once, by its ResponseComplete event or its Panic event, which counts as a server error.",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	cmd.Flags().StringVar(&options.queryOptions.from, "from", "", "Only check events starting at this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().StringVar(&options.queryOptions.to, "to", "", "Only check events before this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().IntVar(&options.queryOptions.maxEventSize, "max-event-size", defaultMaxEventSize, "Maximum size in bytes of a single audit event.")
+	cmd.Flags().StringVarP(&options.output, "output", "o", "json", "Format of the violations report ('json', 'sarif' or 'falco', one Falco alert per line for falcosidekick).")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	cmd.Flags().Float64Var(&options.maxErrorRate, "max-error-rate", -1, "Maximum percentage of requests failing with 5xx status code (eg. 1 for 1%).")
+	cmd.Flags().StringToStringVar(&options.maxLatencies, "max-latency", map[string]string{}, "Maximum latency per percentile (eg. p99=2s,p50=200ms).")
+	cmd.Flags().StringToInt64Var(&options.maxStatusCounts, "max-status-count", map[string]int64{}, "Maximum number of requests per HTTP status code (eg. 429=100).")
+	cmd.Flags().StringVar(&options.notifyURL, "notify-url", "", "Webhook or Slack incoming webhook URL to post a summary of the violations to when any threshold is exceeded.")
+	cmd.Flags().StringVar(&options.notifyEvidence, "notify-evidence", "", "Link or path to the evidence included in the notification, eg. the saved report. Defaults to the checked directories.")
+
+	return cmd
+}
+
+func (o *CheckOptions) Validate() error {
+	if len(o.queryOptions.targetDirectories) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	if o.maxErrorRate < 0 && len(o.maxLatencies) == 0 && len(o.maxStatusCounts) == 0 {
+		return fmt.Errorf("at least one threshold must be specified (--max-error-rate, --max-latency, --max-status-count)")
+	}
+	for percentile, latency := range o.maxLatencies {
+		if _, err := parsePercentile(percentile); err != nil {
+			return err
+		}
+		if _, err := time.ParseDuration(latency); err != nil {
+			return fmt.Errorf("invalid latency %q for %s: %v", latency, percentile, err)
+		}
+	}
+	for code := range o.maxStatusCounts {
+		if _, err := strconv.Atoi(code); err != nil {
+			return fmt.Errorf("invalid HTTP status code %q", code)
+		}
+	}
+	if o.output != "json" && o.output != "sarif" && o.output != "falco" {
+		return fmt.Errorf("invalid output format %q, must be 'json', 'sarif' or 'falco'", o.output)
+	}
+	return nil
+}
+
+func parsePercentile(s string) (float64, error) {
+	p, err := strconv.ParseFloat(strings.TrimPrefix(strings.ToLower(s), "p"), 64)
+	if err != nil || p <= 0 || p > 100 {
+		return 0, fmt.Errorf("invalid percentile %q, use eg. p99 or p99.9", s)
+	}
+	return p, nil
+}
+
+func (o *CheckOptions) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+
+	var total, serverErrors int64
+	latencies := []time.Duration{}
+	statusCounts := map[int32]int64{}
+	if err := o.queryOptions.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			// the events of the earlier stages would count the requests again, with a latency of about 0
+			if !completedRequest(e) {
+				continue
+			}
+			total++
+			latencies = append(latencies, e.StageTimestamp.Sub(e.RequestReceivedTimestamp.Time))
+			if e.Stage == auditv1.StagePanic || (e.ResponseStatus != nil && e.ResponseStatus.Code >= 500) {
+				serverErrors++
+			}
+			if e.ResponseStatus != nil {
+				statusCounts[e.ResponseStatus.Code]++
+			}
+		}
+	}); err != nil {
+		return err
+	}
+	sort.Slice(latencies, func(i, j int) bool {
+		return latencies[i] < latencies[j]
+	})
+
+	report := CheckReport{Events: total, Violations: []CheckViolation{}}
+	if o.maxErrorRate >= 0 && total > 0 {
+		errorRate := 100 * float64(serverErrors) / float64(total)
+		if errorRate > o.maxErrorRate {
+			report.Violations = append(report.Violations, CheckViolation{
+				Check:     "error-rate",
+				Threshold: fmt.Sprintf("%g%%", o.maxErrorRate),
+				Actual:    fmt.Sprintf("%.2f%%", errorRate),
+			})
+		}
+	}
+	for _, percentile := range sets.StringKeySet(o.maxLatencies).List() {
+		p, _ := parsePercentile(percentile)
+		maxLatency, _ := time.ParseDuration(o.maxLatencies[percentile])
+		if len(latencies) == 0 {
+			continue
+		}
+		actual := latencies[int(math.Ceil(p/100*float64(len(latencies))))-1]
+		if actual > maxLatency {
+			report.Violations = append(report.Violations, CheckViolation{
+				Check:     percentile + "-latency",
+				Threshold: maxLatency.String(),
+				Actual:    actual.String(),
+			})
+		}
+	}
+	for _, code := range sets.StringKeySet(o.maxStatusCounts).List() {
+		codeValue, _ := strconv.Atoi(code)
+		if actual := statusCounts[int32(codeValue)]; actual > o.maxStatusCounts[code] {
+			report.Violations = append(report.Violations, CheckViolation{
+				Check:     code + "-count",
+				Threshold: strconv.FormatInt(o.maxStatusCounts[code], 10),
+				Actual:    strconv.FormatInt(actual, 10),
+			})
+		}
+	}
+	report.Passed = len(report.Violations) == 0
+
+	switch o.output {
+	case "sarif":
+		if err := o.sarifReport(report).Write(o.Out); err != nil {
+			return err
+		}
+	case "falco":
+		if err := o.falcoReport(report).Write(o.Out); err != nil {
+			return err
+		}
+	default:
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(o.Out, string(data))
+	}
+	if !report.Passed {
+		if len(o.notifyURL) > 0 {
+			if err := notify.Send(ctx, http.DefaultClient, o.notifyURL, o.notification(report)); err != nil {
+				return fmt.Errorf("%d audit check(s) failed, %v", len(report.Violations), err)
+			}
+		}
+		return fmt.Errorf("%d audit check(s) failed", len(report.Violations))
+	}
+	return nil
+}
+
+// notification summarizes the violations, the evidence defaults to the checked directories.
+func (o *CheckOptions) notification(report CheckReport) notify.Notification {
+	findings := []notify.Finding{}
+	for _, violation := range report.Violations {
+		findings = append(findings, notify.Finding{
+			Rule:    violation.Check,
+			Message: fmt.Sprintf("%s, threshold is %s", violation.Actual, violation.Threshold),
+		})
+	}
+	evidence := o.notifyEvidence
+	if len(evidence) == 0 {
+		evidence = strings.Join(o.queryOptions.targetDirectories, ", ")
+	}
+	summary := fmt.Sprintf("%d threshold(s) exceeded over %d events", len(report.Violations), report.Events)
+	return notify.New("check", summary, findings, evidence)
+}
+
+// sarifReport converts the violations to SARIF results located at the checked audit directory.
+func (o *CheckOptions) sarifReport(report CheckReport) *sarif.Report {
+	result := sarif.NewReport()
+	result.AddRule("error-rate", "Percentage of requests failing with 5xx status code exceeds the threshold", sarif.LevelError, 5)
+	result.AddRule("latency", "Request latency percentile exceeds the threshold", sarif.LevelWarning, 3)
+	result.AddRule("status-count", "Number of requests with HTTP status code exceeds the threshold", sarif.LevelWarning, 3)
+
+	// the checks aggregate over all directories, the results are located at the first one
+	location := sarif.FileLocation(o.queryOptions.targetDirectories[0], 0)
+	for _, violation := range report.Violations {
+		ruleID, level := "error-rate", sarif.LevelError
+		switch {
+		case strings.HasSuffix(violation.Check, "-latency"):
+			ruleID, level = "latency", sarif.LevelWarning
+		case strings.HasSuffix(violation.Check, "-count"):
+			ruleID, level = "status-count", sarif.LevelWarning
+		}
+		result.AddResult(ruleID, level, fmt.Sprintf("%s is %s, threshold is %s (%d events checked)", violation.Check, violation.Actual, violation.Threshold, report.Events), location)
+	}
+	return result
+}
+
+// falcoReport converts the violations to Falco alerts, the output fields hold the values of the violation and the
+// checked audit directories.
+func (o *CheckOptions) falcoReport(report CheckReport) *falco.Report {
+	result := falco.NewReport()
+	for _, violation := range report.Violations {
+		rule, priority := "Audit error rate exceeded", falco.PriorityError
+		switch {
+		case strings.HasSuffix(violation.Check, "-latency"):
+			rule, priority = "Audit latency exceeded", falco.PriorityWarning
+		case strings.HasSuffix(violation.Check, "-count"):
+			rule, priority = "Audit status count exceeded", falco.PriorityWarning
+		}
+		directories := strings.Join(o.queryOptions.targetDirectories, ",")
+		result.AddAlert(rule, priority, fmt.Sprintf("%s is %s, threshold is %s (events=%d directories=%s)",
+			violation.Check, violation.Actual, violation.Threshold, report.Events, directories),
+			map[string]interface{}{
+				"audit.check":       violation.Check,
+				"audit.actual":      violation.Actual,
+				"audit.threshold":   violation.Threshold,
+				"audit.events":      report.Events,
+				"audit.directories": directories,
+			})
+	}
+	return result
+}
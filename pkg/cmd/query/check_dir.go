@@ -0,0 +1,129 @@
+package query
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/natamm4/audit-tool/pkg/audit/decompress"
+)
+
+// sniffLines is the number of lines of an audit file checked by --check-dir, problems are usually visible on the first
+// line already and reading further would decompress the whole file.
+const sniffLines = 10
+
+// klogLine matches the header of the klog lines of the API server logs, eg. I0102 15:04:05.000000 ...
+var klogLine = regexp.MustCompile(`^[IWEF][0-9]{4} [0-9]{2}:[0-9]{2}:[0-9]{2}`)
+
+// fileProblem is why an audit file can't be queried.
+type fileProblem struct {
+	file   string
+	node   string
+	reason string
+}
+
+// runCheckDir reads the first lines of every audit file of the requested nodes and reports the files that can't be
+// queried, eg. empty files or the logs of another component in the audit directory, instead of failing to decode them
+// in the middle of a query.
+func (o Options) runCheckDir(ctx context.Context) error {
+	requestNodes := sets.NewString(o.nodes...)
+	problems := []fileProblem{}
+	checked := 0
+	for _, n := range o.nodeNames.List() {
+		if requestNodes.Len() > 0 && !requestNodes.Has(n) {
+			continue
+		}
+		for _, f := range o.auditFiles.files[n] {
+			checked++
+			reason := ""
+			r, err := o.auditFiles.Open(ctx, f)
+			if err != nil {
+				reason = fmt.Sprintf("unable to open: %v", err)
+			} else {
+				reason = sniffAuditFile(r, o.maxEventSize)
+				r.Close()
+			}
+			if len(reason) > 0 {
+				problems = append(problems, fileProblem{file: f.file.Path, node: n, reason: reason})
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		fmt.Fprintf(o.Out, "All %d audit files are usable.\n", checked)
+		return nil
+	}
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tNODE\tPROBLEM")
+	for _, p := range problems {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", p.file, p.node, p.reason)
+	}
+	w.Flush()
+	return fmt.Errorf("%d of %d audit files are unusable", len(problems), checked)
+}
+
+// sniffAuditFile returns why the audit file can't be queried, empty when its first lines are audit events.
+func sniffAuditFile(r io.Reader, maxEventSize int) string {
+	auditReader, err := decompress.NewReader(r)
+	if err != nil {
+		return fmt.Sprintf("unable to decompress: %v", err)
+	}
+	defer auditReader.Close()
+	if maxEventSize <= 0 {
+		maxEventSize = defaultMaxEventSize
+	}
+	scanner := bufio.NewScanner(auditReader)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxEventSize)
+
+	lines := 0
+	for scanner.Scan() && lines < sniffLines {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		lines++
+		if reason := sniffLine(line); len(reason) > 0 {
+			return fmt.Sprintf("line %d: %s", lines, reason)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if err == bufio.ErrTooLong {
+			return fmt.Sprintf("an event is larger than --max-event-size %d", maxEventSize)
+		}
+		return fmt.Sprintf("unable to read: %v", err)
+	}
+	if lines == 0 {
+		return "empty file"
+	}
+	return ""
+}
+
+// sniffLine returns why the line isn't an audit event, naming the component that likely wrote it.
+func sniffLine(line string) string {
+	if klogLine.MatchString(line) {
+		return "not audit JSON, looks like the klog logs of a component"
+	}
+	object := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(line), &object); err != nil {
+		return "not audit JSON"
+	}
+	kind, _ := object["kind"].(string)
+	apiVersion, _ := object["apiVersion"].(string)
+	switch {
+	case kind == "Event" && strings.HasPrefix(apiVersion, "audit.k8s.io/"):
+		return ""
+	case len(kind) > 0:
+		return fmt.Sprintf("JSON of kind %s %s, not an audit event", kind, apiVersion)
+	case object["msg"] != nil && (object["ts"] != nil || object["level"] != nil):
+		return "JSON logs of a component (eg. etcd), not audit events"
+	default:
+		return "JSON without kind, not an audit event"
+	}
+}
@@ -2,9 +2,13 @@ package query
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,22 +16,51 @@ import (
 
 	"github.com/pterm/pterm"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/sets"
 	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	cmdutil "k8s.io/kubectl/pkg/cmd/util"
 
+	"github.com/natamm4/audit-tool/pkg/audit/enrich"
 	"github.com/natamm4/audit-tool/pkg/audit/filter"
+	"github.com/natamm4/audit-tool/pkg/audit/filter/cel"
+	"github.com/natamm4/audit-tool/pkg/audit/filter/exec"
+	"github.com/natamm4/audit-tool/pkg/audit/jq"
+	"github.com/natamm4/audit-tool/pkg/audit/provenance"
+	"github.com/natamm4/audit-tool/pkg/audit/runstats"
+	"github.com/natamm4/audit-tool/pkg/audit/source"
+	"github.com/natamm4/audit-tool/pkg/cmd/get"
+	"github.com/natamm4/audit-tool/pkg/workspace"
 )
 
 type Options struct {
-	targetDirectory string
-	nodes           []string
-	from, to        string
-	limit           int64
+	targetDirectories []string
+	sourceLocation    string
+	live              bool
+	liveSince         time.Duration
+	savedQuery        string
+	nodes             []string
+	nodePattern       string
+	nodeMap           string
+	from, to          string
+	limit             int64
+	maxEventSize      int
 
 	nodeNames  sets.String
 	auditFiles *AuditDirReader
+	marks      workspace.Marks
+	// localDirectories are the local directories read by cluster, for their marks and manifests
+	localDirectories map[string]string
+	// cache replays the results of previous runs of the query, nil when the results are not cached
+	cache *queryCache
+	// index skips the audit files without events matching the filters, nil when the directories aren't indexed
+	index *auditIndex
+	// timeRanges skips the audit files outside of --from and --to, nil without them
+	timeRanges *fileTimeRanges
+	// status counts the events matching the query for its exit code, nil until Complete
+	status *queryStatus
 
 	verbs           []string
 	resources       []string
@@ -35,63 +68,488 @@ type Options struct {
 	namespaces      []string
 	names           []string
 	users           []string
+	clusters        []string
 	uids            []string
 	filenames       []string
 	failedOnly      bool
 	httpStatusCodes []int32
 	output          string
+	outputFlags     map[string]string
+	noCache         bool
 	topBy           string
+	groupBy         []string
+	showRates       bool
+	sparkline       bool
+	sparklineBucket time.Duration
+	partialBuckets  string
+	topExact        bool
+	topCapacity     int
+	matrixRows      string
+	matrixCols      string
 	stages          []string
+	patchTypes      []string
+	fieldManagers   []string
+	selectors       []string
+	objectSelector  string
+	owners          []string
+	rvZeroOnly      bool
+	leaderElection  bool
+	excludeLeader   bool
+	excludeNoise    bool
+	filterExec      string
+	celExpressions  []string
+	jqExpression    string
+	sampleRate      float64
+	everyNth        int
 	duration        string
 
-	stats bool
+	stats          bool
+	checkDir       bool
+	showProvenance bool
+	verify         bool
+	enrichers      []string
+	errorFormat    string
+	profileQuery   bool
+	maxWidth       int
+	noTruncate     bool
+
+	genericclioptions.IOStreams
 }
 
 func NewCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
-	options := &Options{}
+	options := &Options{IOStreams: streams}
 	cmd := &cobra.Command{
 		Use:   "query",
 		Short: "Run queries against downloaded audit log files",
 		Run: func(cmd *cobra.Command, args []string) {
-			cmdutil.CheckErr(options.Validate())
-			cmdutil.CheckErr(options.Complete())
-			cmdutil.CheckErr(options.Run(ctx))
+			options.CheckUsage(options.completeWorkspace(cmd))
+			options.CheckUsage(options.Validate())
+			options.CheckErr(options.Complete(ctx, f))
+			options.Exit(options.Run(ctx))
 		},
 	}
 
-	cmd.Flags().StringVarP(&options.targetDirectory, "dir", "d", "", "Directory to read the audit files from.")
-	cmd.Flags().StringSliceVar(&options.nodes, "nodes", []string{}, "Specify nodes to query audit events. Empty means all nodes.")
+	options.AddDirectoryFlags(cmd.Flags())
+	cmd.Flags().StringVar(&options.sourceLocation, "source", "", "Location to read the audit files from: a local directory, an S3 bucket (s3://bucket/prefix), an HTTP(S) URL of a .log.gz, .log.bz2 or .log.zst file or directory listing, or a logging service queried for the --from/--to time range: the CloudWatch Logs group of an EKS cluster (cloudwatch://CLUSTER), the Cloud Logging entries of a GKE cluster (cloudlogging://PROJECT/LOCATION/CLUSTER) or the Log Analytics workspace of AKS clusters (loganalytics://WORKSPACE-ID?cluster=NAME).")
+	cmd.Flags().StringVar(&options.savedQuery, "saved", "", "Run the query saved in the active workspace under this name. Flags given on the command line take precedence.")
+	cmd.Flags().BoolVar(&options.live, "live", false, "Query the audit logs directly on the running API server pods instead of downloaded files.")
+	cmd.Flags().DurationVar(&options.liveSince, "since", 15*time.Minute, "With --live, only fetch audit events received within this duration.")
 	cmd.Flags().BoolVarP(&options.stats, "stats", "", false, "Display stats from provided directory (e.g. start/end times, nodes, etc.).")
+	cmd.Flags().BoolVar(&options.checkDir, "check-dir", false, "Check the first lines of every audit file and list the files that can't be queried and why (empty, not audit JSON, logs of another component) instead of querying them.")
 	cmd.Flags().Int64VarP(&options.limit, "limit", "", 0, "Limit the amount of events to display.")
+	cmd.Flags().IntVar(&options.maxEventSize, "max-event-size", defaultMaxEventSize, "Maximum size in bytes of a single audit event. RequestResponse level events might need more than the default.")
 
 	cmd.Flags().StringVar(&options.from, "from", "", "Only query events starting at this time (eg: '2006-01-02 15:03:04').")
 	cmd.Flags().StringVar(&options.to, "to", "", "Only query events before this time (eg: '2006-01-02 15:03:04').")
 
-	cmd.Flags().StringSliceVar(&options.uids, "uid", options.uids, "Only match specific UIDs.")
-	cmd.Flags().StringSliceVar(&options.verbs, "verb", options.verbs, "Filter result of search to only contain the specified verb (eg. 'update', 'get', etc.).")
-	cmd.Flags().StringSliceVar(&options.resources, "resource", options.resources, "Filter result of search to only contain the specified resource.")
-	cmd.Flags().StringSliceVar(&options.subresources, "subresource", options.subresources, "Filter result of search to only contain the specified subresources. \"-*\" means no subresource.")
-	cmd.Flags().StringSliceVarP(&options.namespaces, "namespace", "n", options.namespaces, "Filter result of search to only contain the specified namespace.")
-	cmd.Flags().StringSliceVar(&options.names, "name", options.names, "Filter result of search to only contain the specified name.")
-	cmd.Flags().StringSliceVar(&options.users, "user", options.users, "Filter result of search to only contain the specified user.")
-	cmd.Flags().StringVar(&options.topBy, "by", options.topBy, "Switch the top output format (eg. -o top -by [verb,user,resource,httpstatus,namespace]).")
-	cmd.Flags().StringVarP(&options.output, "output", "o", options.output, "Specify the output format (e.g. 'openmetricsTime', 'openmetricsCount', 'default').")
-	cmd.Flags().BoolVar(&options.failedOnly, "failed-only", false, "Filter result of search to only contain http failures.")
-	cmd.Flags().Int32SliceVar(&options.httpStatusCodes, "http-status-code", options.httpStatusCodes, "Filter result of search to only certain http status codes (200,429).")
-	cmd.Flags().StringSliceVarP(&options.stages, "stage", "s", options.stages, "Filter result by event stage (eg. 'RequestReceived', 'ResponseComplete'). If omitted all stages will be included.")
-	cmd.Flags().StringVar(&options.duration, "duration", options.duration, "Filter all requests that didn't take longer than the specified timeout to complete. Keep in mind that requests usually don't take exactly the specified time. Adding a second or two should give you what you want.")
+	cmd.Flags().BoolVar(&options.verify, "verify", false, "Verify the audit files against the checksums of the manifest written by get before querying them.")
+	cmd.Flags().BoolVar(&options.showProvenance, "show-provenance", false, "Print the audit file and line number every event was read from.")
+	cmd.Flags().StringSliceVar(&options.enrichers, "enrich", options.enrichers, "Enrich the events with the values derived by these enrichers ("+strings.Join(enrich.Names(), ", ")+"), eg. useragent,geoip:/path/to/networks.csv,exec:/path/to/enricher.")
+	cmd.Flags().StringVar(&options.topBy, "by", options.topBy, "Switch the top output format (eg. -o top -by ["+strings.Join(topDimensions(), ",")+"]). With -o firstlast, comma separated dimensions of the grouping key (eg. user,verb,resource).")
+	cmd.Flags().StringSliceVar(&options.groupBy, "group-by", options.groupBy, "With -o top and -o firstlast, group the events by the combination of these dimensions instead of --by (eg. user,verb,resource). With -o top, --output-flags layout=nested prints the combinations as a tree instead of a row each.")
+	cmd.Flags().BoolVar(&options.showRates, "show-rates", false, "With -o top and -o firstlast, add the share of all requests, the requests per second over the queried window (--from to --to, or the first to the last event) and the share of failed (4xx and 5xx) requests of every row. Requests are counted by their ResponseComplete or Panic event.")
+	cmd.Flags().BoolVar(&options.sparkline, "sparkline", false, "With -o top and -o firstlast, add a TIMELINE column with a sparkline of the requests of every row over the queried window, scaled to the busiest part of the row.")
+	cmd.Flags().DurationVar(&options.sparklineBucket, "bucket", 0, "With --sparkline, duration of a character of the sparklines (eg. 30s, 5m, 1h), aligned to the wall-clock boundaries in UTC. Defaults to the smallest of 1s, 5s, 10s, 30s, 1m, 5m, 10m, 30m, 1h, ... keeping the sparklines at most "+strconv.Itoa(sparklineWidth)+" characters wide. The queried window may span at most "+strconv.Itoa(maxSparklineBuckets)+" buckets.")
+	cmd.Flags().StringVar(&options.partialBuckets, "partial-buckets", partialBucketsScale, "With --sparkline, how to show the buckets the queried window covers partially at its edges: scale extrapolates their requests to the whole bucket, keep shows them as is and drop leaves them blank.")
+	cmd.Flags().BoolVar(&options.topExact, "exact", false, "With -o top, count every distinct key exactly instead of estimating the heavy hitters with bounded memory.")
+	cmd.Flags().IntVar(&options.topCapacity, "top-capacity", defaultTopCapacity, "With -o top, number of distinct keys tracked by the heavy hitters estimation. Higher values are more accurate but use more memory.")
+	cmd.Flags().StringVar(&options.matrixRows, "rows", "user", "With -o matrix, dimension of the rows ("+strings.Join(topDimensions(), ", ")+").")
+	cmd.Flags().StringVar(&options.matrixCols, "cols", "verb", "With -o matrix, dimension of the columns ("+strings.Join(topDimensions(), ", ")+").")
+	cmd.Flags().StringVarP(&options.output, "output", "o", options.output, "Specify the output format ("+strings.Join(PrinterNames(), ", ")+"). wide adds the stage, audit ID, object and source IPs of every event to the default line. The csv, json, auditlog, ndjson and openmetrics outputs are streamed without loading all events into memory, ndjson writes every event as soon as it is matched for pipelines (--output-flags envelope=true wraps it with the node and file it was read from).")
+	options.AddErrorFormatFlag(cmd)
+	cmd.Flags().BoolVar(&options.profileQuery, "profile-query", false, "Print to stderr how many audit files the query considered, skipped by the time range, the index or the cache and decoded, the time spent opening, decoding, filtering, printing and sorting, and the peak memory.")
+	cmd.Flags().IntVar(&options.maxWidth, "max-width", 0, "With the default and wide outputs, width the lines are fitted to by truncating the request URIs and long usernames with an ellipsis. Defaults to the width of the terminal, the lines written to a file or a pipe aren't truncated.")
+	cmd.Flags().BoolVar(&options.noTruncate, "no-truncate", false, "With the default and wide outputs, print the request URIs and usernames in full even on a terminal.")
+	cmd.Flags().BoolVar(&options.noCache, "no-cache", false, "Filter all audit events instead of replaying the events accepted by a previous run of the same query over the same audit files (see 'audit-tool cache').")
+	cmd.Flags().StringToStringVar(&options.outputFlags, "output-flags", options.outputFlags, "Options of the output format as KEY=VALUE pairs (eg. -o csv --output-flags header=false).")
+
+	options.AddFilterFlags(cmd.Flags())
+
+	cmd.AddCommand(NewDiffCommand(ctx, f, streams))
+	cmd.AddCommand(NewCheckCommand(ctx, f, streams))
+	cmd.AddCommand(NewCorrelateCommand(ctx, f, streams))
+	cmd.AddCommand(NewWatchesCommand(ctx, f, streams))
+	cmd.AddCommand(NewPatchesCommand(ctx, f, streams))
+	cmd.AddCommand(NewPaginationCommand(ctx, f, streams))
+	cmd.AddCommand(NewExecCommand(ctx, f, streams))
+	cmd.AddCommand(NewSecretsAccessCommand(ctx, f, streams))
+	cmd.AddCommand(NewCredentialsCommand(ctx, f, streams))
+	cmd.AddCommand(NewDistinctCommand(ctx, f, streams))
+	cmd.AddCommand(NewStagesCommand(ctx, f, streams))
+	cmd.AddCommand(NewNamesCommand(ctx, f, streams))
+	cmd.AddCommand(NewConflictsCommand(ctx, f, streams))
+	cmd.AddCommand(NewNotFoundCommand(ctx, f, streams))
+	cmd.AddCommand(NewLeaderElectionCommand(ctx, f, streams))
+	cmd.AddCommand(NewDiscoveryCommand(ctx, f, streams))
+	cmd.AddCommand(NewSelectorsCommand(ctx, f, streams))
+	cmd.AddCommand(NewListChainsCommand(ctx, f, streams))
+	cmd.AddCommand(NewResponseSizesCommand(ctx, f, streams))
+	cmd.AddCommand(NewBurstsCommand(ctx, f, streams))
+	cmd.AddCommand(NewGCCommand(ctx, f, streams))
+	cmd.AddCommand(NewNamespaceLifecycleCommand(ctx, f, streams))
+	cmd.AddCommand(NewKubeletsCommand(ctx, f, streams))
+	cmd.AddCommand(NewReconcileLoopsCommand(ctx, f, streams))
+	cmd.AddCommand(NewShowCommand(ctx, f, streams))
 	return cmd
 }
 
+// AddDirectoryFlags adds the --dir flag selecting the audit files of one or more clusters, and --stdin reading the
+// events from the standard input like --dir -.
+func (o *Options) AddDirectoryFlags(flags *pflag.FlagSet) {
+	flags.VarP(&directoryFlag{dirs: &o.targetDirectories}, "dir", "d", "Directory to read the audit files from. Can be specified multiple times to query several clusters, each labeled by the directory name or by CLUSTER=DIR. A glob pattern (eg. 'fleet/*') reads a directory of clusters, - reads the events from the standard input.")
+	stdin := flags.VarPF(&stdinFlag{dirs: &o.targetDirectories}, "stdin", "", "Read the audit events from the standard input as JSON lines, optionally compressed, like --dir -.")
+	stdin.NoOptDefVal = "true"
+	flags.StringSliceVar(&o.nodes, "nodes", o.nodes, "Specify nodes to query audit events. Empty means all nodes.")
+	flags.StringVar(&o.nodePattern, "node-pattern", o.nodePattern, "Regular expression matched against the path of every audit file, its first capture group (or the group named node) is the node that wrote the file (eg. '/(master-[0-9]+)/'). Files it doesn't match are named by the part of their name before -audit.")
+	flags.StringVar(&o.nodeMap, "node-map", o.nodeMap, "File assigning audit files to nodes, a line per glob pattern of their name or path and the node name (eg. 'ip-10-0-1-23*.log master-0'). It takes precedence over --node-pattern.")
+	flags.Var(&fileFlag{dirs: &o.targetDirectories}, "file", "Shell-style glob pattern of individual audit files to read (eg. 'dump/*/kube-apiserver/*.log.gz'). Can be specified multiple times, the files of all patterns are read as one source. Files are attributed to the node before -audit in their name, otherwise to the directory matched by the first wildcard of the pattern.")
+}
+
+// AddTimeRangeFlags adds the --from and --to flags of the commands outside of query, the selection describes what
+// they select (eg. "export events").
+func (o *Options) AddTimeRangeFlags(flags *pflag.FlagSet, selection string) {
+	flags.StringVar(&o.from, "from", "", fmt.Sprintf("Only %s starting at this time (eg: '2006-01-02 15:03:04').", selection))
+	flags.StringVar(&o.to, "to", "", fmt.Sprintf("Only %s before this time (eg: '2006-01-02 15:03:04').", selection))
+}
+
+// AddNodeFlag adds the --node flag of the commands outside of query, the selection describes what it selects
+// (eg. "export the events").
+func (o *Options) AddNodeFlag(flags *pflag.FlagSet, selection string) {
+	flags.StringSliceVar(&o.nodes, "node", o.nodes, fmt.Sprintf("Only %s of the specified nodes.", selection))
+}
+
+func (o *Options) AddMaxEventSizeFlag(flags *pflag.FlagSet) {
+	flags.IntVar(&o.maxEventSize, "max-event-size", defaultMaxEventSize, "Maximum size in bytes of a single audit event.")
+}
+
+// TargetDirectories returns the directories, globs and locations given by --dir, --stdin and --file.
+func (o Options) TargetDirectories() []string {
+	return o.targetDirectories
+}
+
+// TimeRange returns the values of --from and --to, empty when not set.
+func (o Options) TimeRange() (string, string) {
+	return o.from, o.to
+}
+
+func (o Options) MaxEventSize() int {
+	return o.maxEventSize
+}
+
+// fileFlag adds every --file pattern to the directories, marked with source.FilesLocationPrefix.
+type fileFlag struct {
+	dirs *[]string
+}
+
+func (f *fileFlag) Set(value string) error {
+	*f.dirs = append(*f.dirs, source.FilesLocationPrefix+value)
+	return nil
+}
+
+func (f *fileFlag) String() string {
+	return ""
+}
+
+func (f *fileFlag) Type() string {
+	return "stringArray"
+}
+
+// directoryFlag appends every --dir to the directories. Unlike a string array flag it doesn't replace the
+// directories on the first --dir, which would drop the standard input added by a preceding --stdin.
+type directoryFlag struct {
+	dirs *[]string
+}
+
+func (f *directoryFlag) Set(value string) error {
+	*f.dirs = append(*f.dirs, value)
+	return nil
+}
+
+func (f *directoryFlag) String() string {
+	// empty rather than [], which the help would print as the default
+	if len(*f.dirs) == 0 {
+		return ""
+	}
+	return "[" + strings.Join(*f.dirs, ",") + "]"
+}
+
+func (f *directoryFlag) Type() string {
+	return "stringArray"
+}
+
+// stdinFlag adds the standard input to the directories when set.
+type stdinFlag struct {
+	dirs *[]string
+	set  bool
+}
+
+func (f *stdinFlag) Set(value string) error {
+	set, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	if set && !f.set {
+		*f.dirs = append(*f.dirs, source.StdinLocation)
+	}
+	f.set = set
+	return nil
+}
+
+func (f *stdinFlag) String() string {
+	return strconv.FormatBool(f.set)
+}
+
+func (f *stdinFlag) Type() string {
+	return "bool"
+}
+
+// readsStdin returns whether the events are read from the standard input, which can't be cached.
+func (o Options) readsStdin() bool {
+	if o.sourceLocation == source.StdinLocation {
+		return true
+	}
+	for _, dir := range o.targetDirectories {
+		if dir == source.StdinLocation || strings.HasSuffix(dir, "="+source.StdinLocation) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddFilterFlags adds the flags that setup the event filters.
+func (o *Options) AddFilterFlags(flags *pflag.FlagSet) {
+	flags.StringSliceVar(&o.uids, "uid", o.uids, "Only match specific UIDs.")
+	flags.StringSliceVar(&o.verbs, "verb", o.verbs, "Filter result of search to only contain the specified verb (eg. 'update', 'get', etc.).")
+	flags.StringSliceVar(&o.resources, "resource", o.resources, "Filter result of search to only contain the specified resource.")
+	flags.StringSliceVar(&o.subresources, "subresource", o.subresources, "Filter result of search to only contain the specified subresources. \"-*\" means no subresource.")
+	flags.StringSliceVarP(&o.namespaces, "namespace", "n", o.namespaces, "Filter result of search to only contain the specified namespace.")
+	flags.StringSliceVar(&o.names, "name", o.names, "Filter result of search to only contain the specified name.")
+	flags.StringSliceVar(&o.users, "user", o.users, "Filter result of search to only contain the specified user.")
+	flags.StringSliceVar(&o.clusters, "cluster", o.clusters, "Filter result of search to only contain the events of the specified cluster, when several clusters are queried.")
+	flags.BoolVar(&o.failedOnly, "failed-only", false, "Filter result of search to only contain http failures.")
+	flags.Int32SliceVar(&o.httpStatusCodes, "http-status-code", o.httpStatusCodes, "Filter result of search to only certain http status codes (200,429).")
+	flags.StringSliceVarP(&o.stages, "stage", "s", o.stages, "Filter result by event stage (eg. 'RequestReceived', 'ResponseComplete'). If omitted all stages will be included.")
+	flags.StringSliceVar(&o.patchTypes, "patch-type", o.patchTypes, "Filter result of search to only contain patch requests of the specified type (json, merge, strategic-merge, apply, unknown).")
+	flags.StringSliceVar(&o.fieldManagers, "field-manager", o.fieldManagers, "Filter result of search to only contain requests of the specified field manager (eg. 'kubectl', 'kube-controller-manager').")
+	flags.StringSliceVar(&o.selectors, "selector-contains", o.selectors, "Filter result of search to only contain requests whose label or field selector contains the specified string (eg. 'app=web').")
+	flags.StringVar(&o.objectSelector, "object-label-selector", o.objectSelector, "Filter result of search to only contain requests whose decoded request or response object has labels matching the label selector (eg. 'app=foo,tier!=db'). Requires the Request or RequestResponse audit level.")
+	flags.StringSliceVar(&o.owners, "owner", o.owners, "Filter result of search to only contain requests whose decoded request or response object has an ownerReference to the specified KIND/NAME or KIND (eg. 'ReplicaSet/web-5d8f7'). Requires the Request or RequestResponse audit level.")
+	flags.BoolVar(&o.rvZeroOnly, "rv-zero-only", false, "Filter result of search to only contain requests with resourceVersion=0, served from the watch cache.")
+	flags.BoolVar(&o.leaderElection, "leader-election-only", false, "Filter result of search to only contain leader election requests (leases outside of kube-node-lease, configmap and endpoints locks).")
+	flags.BoolVar(&o.excludeNoise, "exclude-system-noise", false, "Filter health checks (/healthz, /readyz, /livez), /version, discovery (/api, /apis) and OpenAPI requests out of the result of search, see filter.SystemNoisePaths for the full list.")
+	flags.BoolVar(&o.excludeLeader, "exclude-leader-election", false, "Filter leader election requests (leases outside of kube-node-lease, configmap and endpoints locks) out of the result of search.")
+	flags.StringArrayVar(&o.celExpressions, "cel", o.celExpressions, "Filter result of search by a CEL expression over the event (eg. 'event.verb == \"delete\" && event.objectRef.resource == \"secrets\"'). The decoded object the request read or wrote is available as object (eg. 'object.metadata.labels.app == \"foo\"'). Can be specified multiple times, all expressions must match.")
+	flags.StringVar(&o.jqExpression, "jq", o.jqExpression, "Filter result of search by a jq expression over the event (eg. 'select(.verb == \"delete\") | {user: .user.username, uri: .requestURI}'). Events for which it produces no output, or only null and false, are filtered out. With the default output format, the outputs are printed as JSON instead of the event.")
+	flags.StringVar(&o.filterExec, "filter-exec", o.filterExec, "Filter result of search by an external program reading the events as NDJSON batches and answering with the matching audit IDs (see pkg/audit/filter/exec for the protocol).")
+	flags.Float64Var(&o.sampleRate, "sample-rate", o.sampleRate, "Only decode a random fraction of the audit events (eg. 0.01 for 1%), to get representative results from large archives fast. The other filters apply to the sampled events.")
+	flags.IntVar(&o.everyNth, "every-nth", o.everyNth, "Only decode every nth audit event (eg. 100), to get representative results from large archives fast. Combined with --sample-rate, the rate applies to every nth event.")
+	flags.StringVar(&o.duration, "duration", o.duration, "Filter all requests that didn't take longer than the specified timeout to complete. Keep in mind that requests usually don't take exactly the specified time. Adding a second or two should give you what you want.")
+}
+
+// completeWorkspace defaults the directory to the one of the active workspace and applies the saved query flags.
+func (o *Options) completeWorkspace(cmd *cobra.Command) error {
+	ws, err := workspace.Active()
+	if err != nil {
+		return err
+	}
+	if ws == nil {
+		if len(o.savedQuery) > 0 {
+			return fmt.Errorf("--saved requires an active workspace")
+		}
+		return nil
+	}
+
+	if len(o.savedQuery) > 0 {
+		args, ok := ws.Queries[o.savedQuery]
+		if !ok {
+			return fmt.Errorf("query %q is not saved in workspace %q", o.savedQuery, ws.Name)
+		}
+		// only the flags that were not set on the command line are parsed from the saved query, the others
+		// are skipped as unknown flags
+		saved := pflag.NewFlagSet("saved", pflag.ContinueOnError)
+		saved.ParseErrorsWhitelist.UnknownFlags = true
+		cmd.Flags().VisitAll(func(f *pflag.Flag) {
+			if !f.Changed {
+				saved.AddFlag(f)
+			}
+		})
+		if err := saved.Parse(args); err != nil {
+			return fmt.Errorf("invalid saved query %q: %v", o.savedQuery, err)
+		}
+	}
+
+	if len(o.targetDirectories) == 0 && len(o.sourceLocation) == 0 && !o.live {
+		o.targetDirectories = []string{ws.Directory}
+	}
+	return nil
+}
+
 func (o Options) Validate() error {
-	if len(o.targetDirectory) == 0 {
-		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	specified := 0
+	for _, set := range []bool{len(o.targetDirectories) > 0, len(o.sourceLocation) > 0, o.live} {
+		if set {
+			specified++
+		}
+	}
+	if specified == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d, --file, --source or --live)")
+	}
+	if specified > 1 {
+		return fmt.Errorf("only one of --dir/-d or --file, --source and --live can be specified")
+	}
+	if err := validateTimeRange(o.from, o.to); err != nil {
+		return err
+	}
+	if o.maxWidth < 0 {
+		return fmt.Errorf("--max-width must not be negative")
+	}
+	if o.maxWidth > 0 && o.noTruncate {
+		return fmt.Errorf("--max-width and --no-truncate are mutually exclusive")
+	}
+	if o.live && o.liveSince <= 0 {
+		return fmt.Errorf("--since must be a positive duration")
+	}
+	if _, err := newSampler(o.sampleRate, o.everyNth); err != nil {
+		return err
+	}
+	for _, expression := range o.celExpressions {
+		if _, err := cel.Compile(expression); err != nil {
+			return err
+		}
+	}
+	if len(o.jqExpression) > 0 {
+		if _, err := jq.Compile(o.jqExpression); err != nil {
+			return err
+		}
+	}
+	if _, err := o.newPrinter(); err != nil {
+		return err
 	}
 	return nil
 }
 
-func (o *Options) Complete() error {
-	files, err := NewAuditDirReader(o.targetDirectory)
+// clusterDirectory is a directory with the audit files of a single cluster.
+type clusterDirectory struct {
+	cluster string
+	dir     string
+	// patterns are the --file patterns read instead of the directory
+	patterns []string
+}
+
+// clusterDirectories resolves the --dir and --file values. A single directory is not labeled, several directories are
+// labeled by their base name unless given as CLUSTER=DIR, and glob patterns expand to a cluster per matching directory.
+// The --file patterns are read as a single directory, labeled files next to other directories.
+func (o Options) clusterDirectories() ([]clusterDirectory, error) {
+	dirs := []clusterDirectory{}
+	files := -1
+	for _, value := range o.targetDirectories {
+		if strings.HasPrefix(value, source.FilesLocationPrefix) {
+			pattern := strings.TrimPrefix(value, source.FilesLocationPrefix)
+			if files < 0 {
+				files = len(dirs)
+				dirs = append(dirs, clusterDirectory{dir: value})
+			}
+			dirs[files].patterns = append(dirs[files].patterns, pattern)
+			continue
+		}
+		cluster, dir := "", value
+		if i := strings.Index(value, "="); i > 0 {
+			cluster, dir = value[:i], value[i+1:]
+		}
+		if dir == source.StdinLocation {
+			for _, d := range dirs {
+				if d.dir == source.StdinLocation {
+					return nil, fmt.Errorf("the standard input can only be read once")
+				}
+			}
+		}
+		if !strings.ContainsAny(dir, "*?[") {
+			dirs = append(dirs, clusterDirectory{cluster: cluster, dir: dir})
+			continue
+		}
+		matches, err := filepath.Glob(dir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --dir pattern %q: %v", dir, err)
+		}
+		for _, match := range matches {
+			if info, err := os.Stat(match); err != nil || !info.IsDir() {
+				continue
+			}
+			dirs = append(dirs, clusterDirectory{dir: match})
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no cluster directories match %q", dir)
+		}
+	}
+
+	if len(dirs) == 1 {
+		return dirs, nil
+	}
+	seen := sets.NewString()
+	for i := range dirs {
+		if len(dirs[i].cluster) == 0 && dirs[i].dir == source.StdinLocation {
+			dirs[i].cluster = "stdin"
+		} else if len(dirs[i].cluster) == 0 && len(dirs[i].patterns) > 0 {
+			dirs[i].cluster = "files"
+		} else if len(dirs[i].cluster) == 0 {
+			dirs[i].cluster = filepath.Base(filepath.Clean(dirs[i].dir))
+		}
+		if seen.Has(dirs[i].cluster) {
+			return nil, fmt.Errorf("cluster %q is specified more than once, label the directories with CLUSTER=DIR", dirs[i].cluster)
+		}
+		seen.Insert(dirs[i].cluster)
+	}
+	return dirs, nil
+}
+
+func (o *Options) Complete(ctx context.Context, f cmdutil.Factory) error {
+	o.status = &queryStatus{}
+	sources := map[string]source.EventSource{}
+	switch {
+	case o.live:
+		src, err := get.NewLiveSource(f, o.IOStreams, o.liveSince)
+		if err != nil {
+			return err
+		}
+		sources[""] = src
+	case len(o.sourceLocation) > 0:
+		src, err := source.New(o.sourceLocation)
+		if err != nil {
+			return err
+		}
+		sources[""] = src
+	default:
+		dirs, err := o.clusterDirectories()
+		if err != nil {
+			return err
+		}
+		for _, dir := range dirs {
+			var src source.EventSource
+			if len(dir.patterns) > 0 {
+				src, err = source.NewLocalFiles(dir.patterns)
+			} else {
+				src, err = source.New(dir.dir)
+			}
+			if err != nil {
+				return err
+			}
+			sources[dir.cluster] = src
+		}
+	}
+	query, err := o.sourceQuery()
+	if err != nil {
+		return err
+	}
+	for _, src := range sources {
+		if queried, ok := src.(source.QuerySource); ok {
+			queried.SetQuery(query)
+		}
+	}
+	namer, err := newNodeNamer(o.nodePattern, o.nodeMap)
+	if err != nil {
+		return err
+	}
+	files, err := NewClusterAuditDirReader(ctx, sources, namer)
 	if err != nil {
 		return err
 	}
@@ -104,27 +562,92 @@ func (o *Options) Complete() error {
 		return fmt.Errorf("invalid nodes: %s, valid node names are: %s", strings.Join(requestNodes.List(), ","), strings.Join(o.nodeNames.List(), ","))
 	}
 	o.auditFiles = files
+
+	o.marks = workspace.Marks{}
+	o.localDirectories = map[string]string{}
+	for cluster, src := range sources {
+		local, ok := src.(*source.LocalDirectory)
+		if !ok {
+			continue
+		}
+		o.localDirectories[cluster] = local.Dir
+		marks, err := workspace.LoadMarks(local.Dir)
+		if err != nil {
+			return err
+		}
+		for auditID, mark := range marks {
+			o.marks[auditID] = mark
+		}
+	}
+	if o.index, err = o.loadIndex(); err != nil {
+		return err
+	}
+	if o.timeRanges, err = o.loadTimeRanges(); err != nil {
+		return err
+	}
 	return nil
 }
 
-const timeDefaultFormat = "2006-01-02 15:04:05"
+// sourceQuery returns the time range and filters pushed down to the sources querying a logging service.
+func (o Options) sourceQuery() (source.Query, error) {
+	query := source.Query{
+		Verbs:      o.verbs,
+		Namespaces: o.namespaces,
+		Names:      o.names,
+		Users:      o.users,
+	}
+	var err error
+	if len(o.from) > 0 {
+		if query.From, err = time.Parse(TimeDefaultFormat, o.from); err != nil {
+			return query, err
+		}
+	}
+	if len(o.to) > 0 {
+		if query.To, err = time.Parse(TimeDefaultFormat, o.to); err != nil {
+			return query, err
+		}
+	}
+	return query, nil
+}
+
+const TimeDefaultFormat = "2006-01-02 15:04:05"
+
+// validateTimeRange checks --from and --to before any audit file is read, the filters and the outputs parse them with
+// ParseTime afterwards.
+func validateTimeRange(from, to string) error {
+	var fromTime, toTime time.Time
+	var err error
+	if len(from) > 0 {
+		if fromTime, err = time.Parse(TimeDefaultFormat, from); err != nil {
+			return fmt.Errorf("invalid --from %q, use %q", from, TimeDefaultFormat)
+		}
+	}
+	if len(to) > 0 {
+		if toTime, err = time.Parse(TimeDefaultFormat, to); err != nil {
+			return fmt.Errorf("invalid --to %q, use %q", to, TimeDefaultFormat)
+		}
+	}
+	if len(from) > 0 && len(to) > 0 && toTime.Before(fromTime) {
+		return fmt.Errorf("--from %q must not be after --to %q", from, to)
+	}
+	return nil
+}
 
-func parseTime(s string) time.Time {
-	t, err := time.Parse(timeDefaultFormat, s)
+func ParseTime(s string) time.Time {
+	t, err := time.Parse(TimeDefaultFormat, s)
 	if err != nil {
-		log.Fatalf("invalid time format: %q, use %q", s, timeDefaultFormat)
+		log.Fatalf("invalid time format: %q, use %q", s, TimeDefaultFormat)
 	}
 	return t
 }
 
 func (o Options) runStats() error {
-	nodes := []string{}
-	for nodeName := range o.auditFiles.files {
-		nodes = append(nodes, nodeName)
-	}
-
 	list := []pterm.BulletListItem{}
-	for _, n := range nodes {
+	for _, n := range o.queriedNodes() {
+		var size int64
+		for _, f := range o.auditFiles.files[n] {
+			size += f.file.Size
+		}
 		list = append(list, pterm.BulletListItem{
 			Level: 0,
 			Text:  pterm.NewStyle(pterm.BgBlack, pterm.FgLightWhite).Sprintf(n),
@@ -133,55 +656,186 @@ func (o Options) runStats() error {
 			Level: 1,
 			Text:  fmt.Sprintf("from: %s | to: %s", printTime(o.auditFiles.files[n][len(o.auditFiles.files[n])-1].timestamp), printTime(o.auditFiles.files[n][0].timestamp)),
 		})
+		list = append(list, pterm.BulletListItem{
+			Level: 1,
+			Text:  fmt.Sprintf("files: %d | size: %s", len(o.auditFiles.files[n]), formatBytes(uint64(size))),
+		})
 	}
 
 	err := pterm.DefaultBulletList.WithItems(list).Render()
 	if err != nil {
 		return err
 	}
-	return nil
+	return o.printManifests()
 }
 
 func isInTimeRange(from, to string, timestamp time.Time) bool {
 	var fromTime, toTime time.Time
 	fromTime = time.Now().Add(-365 * 24 * time.Hour) // one year is default
 	if len(from) != 0 {
-		fromTime = parseTime(from)
+		fromTime = ParseTime(from)
 	}
 	toTime = time.Now()
 	if len(to) != 0 {
-		toTime = parseTime(to)
+		toTime = ParseTime(to)
 	}
 	return timestamp.After(fromTime) && timestamp.Before(toTime)
 }
 
-func (o Options) multiNodeEventDecoder(filters filter.AuditFilters) ([]*auditv1.Event, error) {
-	requestNodes := sets.NewString(o.nodes...)
+func (o Options) multiNodeEventDecoder(ctx context.Context, filters filter.AuditFilters) ([]*auditv1.Event, error) {
+	sample, err := newSampler(o.sampleRate, o.everyNth)
+	if err != nil {
+		return nil, err
+	}
 	result := []*auditv1.Event{}
+	err = o.ForEachAuditFile(ctx, func(origin provenance.Provenance, r io.Reader) error {
+		if o.cache != nil {
+			events := []*auditv1.Event{}
+			if err := o.scanCachedAuditEvents(r, origin, filters, false, func(batch []*auditv1.Event) {
+				events = append(events, batch...)
+			}); err != nil {
+				return err
+			}
+			sortNewestFirst(events)
+			o.status.add(events)
+			result = append(result, events...)
+			return nil
+		}
+		events, err := decodeAuditEvents(r, o.maxEventSize, origin, sample, filters)
+		if err != nil {
+			return err
+		}
+		o.status.add(events)
+		result = append(result, events...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if o.cache != nil {
+		if err := o.cache.save(); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// MultiNodeEventVisitor calls visit for the filtered events of every requested node, one batch at a time.
+// See scanAuditEvents for the meaning of recycle.
+func (o Options) MultiNodeEventVisitor(ctx context.Context, filters filter.AuditFilters, recycle bool, visit func([]*auditv1.Event)) error {
+	sample, err := newSampler(o.sampleRate, o.everyNth)
+	if err != nil {
+		return err
+	}
+	visit = o.status.count(visit)
+	if o.cache == nil {
+		return o.ForEachAuditFile(ctx, func(origin provenance.Provenance, r io.Reader) error {
+			return scanAuditEvents(r, o.maxEventSize, origin, sample, []filter.AuditFilters{filters}, recycle, visit)
+		})
+	}
+	if err := o.ForEachAuditFile(ctx, func(origin provenance.Provenance, r io.Reader) error {
+		return o.scanCachedAuditEvents(r, origin, filters, recycle, visit)
+	}); err != nil {
+		return err
+	}
+	return o.cache.save()
+}
+
+// scanCachedAuditEvents only decodes the lines of the audit file accepted by a previous run of the query, or filters
+// all of them and records the accepted lines when the audit file wasn't read before.
+func (o Options) scanCachedAuditEvents(r io.Reader, origin provenance.Provenance, filters filter.AuditFilters, recycle bool, visit func([]*auditv1.Event)) error {
+	lines, ok := o.cache.lines(origin.File)
+	if !ok {
+		skipped := runstats.Current().DecodeErrors
+		if err := scanAuditEvents(r, o.maxEventSize, origin, nil, []filter.AuditFilters{filters}, recycle, o.cache.record(origin.File, visit)); err != nil {
+			return err
+		}
+		// the replays wouldn't report the lines that couldn't be decoded
+		if runstats.Current().DecodeErrors > skipped {
+			o.cache.forget(origin.File)
+		}
+		return nil
+	}
+	if len(lines) == 0 {
+		activeProfile.cached()
+		return nil
+	}
+	return scanAuditEvents(r, o.maxEventSize, origin, newLineSampler(lines), nil, recycle, visit)
+}
+
+// ForEachAuditFile opens every audit file of the requested nodes within the requested time range and passes it to read
+// together with the provenance of the events in it.
+func (o Options) ForEachAuditFile(ctx context.Context, read func(origin provenance.Provenance, r io.Reader) error) error {
+	requestNodes := sets.NewString(o.nodes...)
 	processedFiles := 0
 	for _, n := range o.nodeNames.List() {
 		if requestNodes.Len() > 0 && !requestNodes.Has(n) {
 			continue
 		}
 		for _, nodeAuditFile := range o.auditFiles.files[n] {
-			if !isInTimeRange(o.from, o.to, nodeAuditFile.timestamp) {
+			// the time range of the standard input isn't known upfront and logging services are queried for the time
+			// range, the time filters apply to their events
+			inTimeRange := nodeAuditFile.file.Path == source.StdinLocation || o.auditFiles.queried(nodeAuditFile) || o.inTimeRange(nodeAuditFile)
+			if !inTimeRange {
+				activeProfile.file(true, false)
+				continue
+			}
+			if o.index.skip(nodeAuditFile) {
+				activeProfile.file(false, true)
 				continue
 			}
+			activeProfile.file(false, false)
 			//log.Printf("decoding %q (%s) ...", nodeAuditFile.name, nodeAuditFile.timestamp)
-			events, err := decodeAuditEvents(nodeAuditFile.filePath, filters)
+			opened := activeProfile.now()
+			r, err := o.auditFiles.Open(ctx, nodeAuditFile)
+			if err != nil {
+				return fmt.Errorf("opening audit file %q failed: %v", nodeAuditFile.name, err)
+			}
+			origin := provenance.Provenance{
+				Cluster:   nodeAuditFile.cluster,
+				Node:      nodeAuditFile.node,
+				File:      nodeAuditFile.file.Path,
+				Component: provenance.ComponentFromPath(nodeAuditFile.file.Path),
+			}
+			// skip the part of indexed audit files written before --from, the file is read from the beginning when
+			// it can't seek
+			if checkpoint, ok := o.timeRanges.start(nodeAuditFile); ok {
+				if seeker, ok := r.(io.Seeker); ok {
+					if _, err := seeker.Seek(checkpoint.Offset, io.SeekStart); err == nil {
+						origin.Line = checkpoint.Line
+					}
+				}
+			}
+			activeProfile.since(phaseOpen, opened)
+			err = read(origin, r)
+			r.Close()
 			if err != nil {
-				return nil, fmt.Errorf("reading audit file %q failed: %v", nodeAuditFile.name, err)
+				return fmt.Errorf("reading audit file %q failed: %v", nodeAuditFile.name, err)
 			}
+			activeProfile.sampleMemory()
 			processedFiles++
-			result = append(result, events...)
 		}
 	}
 	//log.Printf("processed %d audit files", processedFiles)
-	return result, nil
+	o.timeRanges.save()
+	return nil
+}
+
+// inTimeRange returns whether the audit file may contain events within --from and --to, by the time range of its
+// events when it is known and by the time in its name or its modification time otherwise.
+func (o Options) inTimeRange(f auditFile) bool {
+	if inTimeRange, ok := o.timeRanges.inTimeRange(f); ok {
+		return inTimeRange
+	}
+	return isInTimeRange(o.from, o.to, f.timestamp)
 }
 
-func (o Options) setupFilters() (filter.AuditFilters, error) {
+func (o Options) SetupFilters() (filter.AuditFilters, error) {
 	filters := filter.AuditFilters{}
+	// the noise is most of the volume, filtering it first spares the other filters most of the events
+	if o.excludeNoise {
+		filters = append(filters, &filter.FilterBySystemNoise{})
+	}
 	if len(o.uids) > 0 {
 		filters = append(filters, &filter.FilterByUIDs{UIDs: sets.NewString(o.uids...)})
 	}
@@ -195,14 +849,14 @@ func (o Options) setupFilters() (filter.AuditFilters, error) {
 		filters = append(filters, &filter.FilterByStage{Stages: sets.NewString(o.stages...)})
 	}
 	if len(o.to) > 0 {
-		t, err := time.Parse(timeDefaultFormat, o.to)
+		t, err := time.Parse(TimeDefaultFormat, o.to)
 		if err != nil {
 			return nil, err
 		}
 		filters = append(filters, &filter.FilterByBefore{Before: t})
 	}
 	if len(o.from) > 0 {
-		t, err := time.Parse(timeDefaultFormat, o.from)
+		t, err := time.Parse(TimeDefaultFormat, o.from)
 		if err != nil {
 			return nil, err
 		}
@@ -228,6 +882,9 @@ func (o Options) setupFilters() (filter.AuditFilters, error) {
 	if len(o.users) > 0 {
 		filters = append(filters, &filter.FilterByUser{Users: sets.NewString(o.users...)})
 	}
+	if len(o.clusters) > 0 {
+		filters = append(filters, &filter.FilterByClusters{Clusters: sets.NewString(o.clusters...)})
+	}
 	if len(o.verbs) > 0 {
 		filters = append(filters, &filter.FilterByVerbs{Verbs: sets.NewString(o.verbs...)})
 	}
@@ -237,6 +894,42 @@ func (o Options) setupFilters() (filter.AuditFilters, error) {
 	if o.failedOnly {
 		filters = append(filters, &filter.FilterByFailures{})
 	}
+	if len(o.patchTypes) > 0 {
+		filters = append(filters, &filter.FilterByPatchTypes{PatchTypes: sets.NewString(o.patchTypes...)})
+	}
+	if len(o.fieldManagers) > 0 {
+		filters = append(filters, &filter.FilterByFieldManagers{FieldManagers: sets.NewString(o.fieldManagers...)})
+	}
+	if len(o.selectors) > 0 {
+		filters = append(filters, &filter.FilterBySelectorContains{Substrings: o.selectors})
+	}
+	if len(o.objectSelector) > 0 {
+		selector, err := labels.Parse(o.objectSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --object-label-selector %q: %v", o.objectSelector, err)
+		}
+		filters = append(filters, &filter.FilterByObjectLabels{Selector: selector})
+	}
+	if len(o.owners) > 0 {
+		owners := []filter.Owner{}
+		for _, value := range o.owners {
+			owner, err := filter.ParseOwner(value)
+			if err != nil {
+				return nil, err
+			}
+			owners = append(owners, owner)
+		}
+		filters = append(filters, &filter.FilterByOwners{Owners: owners})
+	}
+	if o.rvZeroOnly {
+		filters = append(filters, &filter.FilterByResourceVersionZero{})
+	}
+	if o.leaderElection && o.excludeLeader {
+		return nil, fmt.Errorf("--leader-election-only and --exclude-leader-election are mutually exclusive")
+	}
+	if o.leaderElection || o.excludeLeader {
+		filters = append(filters, &filter.FilterByLeaderElection{Exclude: o.excludeLeader})
+	}
 	if len(o.duration) > 0 {
 		d, err := time.ParseDuration(o.duration)
 		if err != nil {
@@ -244,36 +937,173 @@ func (o Options) setupFilters() (filter.AuditFilters, error) {
 		}
 		filters = append(filters, &filter.FilterByDuration{Duration: d})
 	}
+	if len(o.celExpressions) > 0 {
+		programs := []*cel.Program{}
+		for _, expression := range o.celExpressions {
+			program, err := cel.Compile(expression)
+			if err != nil {
+				return nil, err
+			}
+			programs = append(programs, program)
+		}
+		filters = append(filters, &cel.FilterByCEL{Programs: programs})
+	}
+	if len(o.jqExpression) > 0 {
+		program, err := jq.Compile(o.jqExpression)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, &jq.FilterByJQ{Program: program})
+	}
+	// the external filter is the most expensive one, it only gets the events that passed the other filters
+	if len(o.filterExec) > 0 {
+		execFilter, err := exec.New(o.filterExec)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, execFilter)
+	}
+	// enrichers run last, only on the events that passed the filters
+	if len(o.enrichers) > 0 {
+		pipeline, err := enrich.NewPipeline(o.enrichers)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, pipeline)
+	}
 
 	return filters, nil
 }
 
 func (o Options) Run(ctx context.Context) error {
+	if o.verify {
+		if err := o.verifyManifests(); err != nil {
+			return err
+		}
+	}
 	if o.stats {
 		return o.runStats()
 	}
+	if o.checkDir {
+		return o.runCheckDir(ctx)
+	}
+	if o.profileQuery {
+		activeProfile = newQueryProfile()
+		defer activeProfile.print(o.ErrOut)
+	}
 
-	filters, err := o.setupFilters()
+	filters, err := o.SetupFilters()
 	if err != nil {
 		return err
 	}
 
-	events, err := o.multiNodeEventDecoder(filters)
+	printer, err := o.newPrinter()
 	if err != nil {
 		return err
 	}
+	if o.cacheable(filters) {
+		if o.cache, err = o.newQueryCache(); err != nil {
+			return err
+		}
+	}
+	return printer.Print(ctx, &Events{query: o, filters: filters})
+}
 
-	switch o.output {
-	case "openmetricsCount":
-		return printOpenMetricsCounts(events, os.Stdout)
-	case "openmetricsTime":
-		return printOpenMetricsTimestamps(events, os.Stdout)
-	default:
-		for i, e := range events {
-			if o.limit > 0 && i > int(o.limit) {
-				break
+// printEvents prints the events one per line with the details of the output format, or the outputs of the --jq
+// expression. The lines are fitted to --max-width or to the width of the terminal.
+func (o Options) printEvents(ctx context.Context, filters filter.AuditFilters, details func(e *auditv1.Event) string) error {
+	events, err := o.multiNodeEventDecoder(ctx, filters)
+	if err != nil {
+		return err
+	}
+
+	if len(o.jqExpression) > 0 {
+		return o.printJQ(events)
+	}
+	if o.limit > 0 && len(events) > int(o.limit) {
+		events = events[:o.limit]
+	}
+	line := func(e *auditv1.Event, layout lineLayout) string {
+		detail := ""
+		if details != nil {
+			detail = details(e)
+		}
+		line := printEventLine(e, detail, o.marks, layout) + printEnrichment(e)
+		if o.showProvenance {
+			line += printProvenance(e)
+		}
+		return line
+	}
+	layout := lineLayout{}
+	if width := o.lineWidth(); width > 0 {
+		layout = newLineLayout(events, width, func(e *auditv1.Event) string {
+			return line(e, lineLayout{})
+		})
+	}
+	for _, e := range events {
+		pterm.Println(line(e, layout))
+	}
+	return nil
+}
+
+// lineWidth returns the width the event lines are fitted to: --max-width, or the width of the terminal when the
+// output is one. 0 leaves them as is, the lines written to a file or a pipe aren't truncated.
+func (o Options) lineWidth() int {
+	if o.noTruncate {
+		return 0
+	}
+	if o.maxWidth > 0 {
+		return o.maxWidth
+	}
+	width, _, err := pterm.GetTerminalSize()
+	if err != nil {
+		return 0
+	}
+	return width
+}
+
+// WriteEvents streams the filtered events to the writer without keeping them in memory, at most limit events when
+// limit is set, and returns the number of events written.
+func (o Options) WriteEvents(ctx context.Context, filters filter.AuditFilters, w EventWriter, limit int64) (int64, error) {
+	var writeErr error
+	written := int64(0)
+	if err := o.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			if writeErr != nil || (limit > 0 && written >= limit) {
+				return
+			}
+			writeErr = w.WriteEvent(e)
+			written++
+		}
+	}); err != nil {
+		return written, err
+	}
+	if writeErr != nil {
+		return written, writeErr
+	}
+	return written, w.Flush()
+}
+
+// printJQ prints the outputs of the jq expression for the events as compact JSON, one per line like jq -c.
+func (o Options) printJQ(events []*auditv1.Event) error {
+	program, err := jq.Compile(o.jqExpression)
+	if err != nil {
+		return err
+	}
+	for i, e := range events {
+		if o.limit > 0 && i >= int(o.limit) {
+			break
+		}
+		outputs, err := program.Run(e)
+		if err != nil {
+			return err
+		}
+		for _, output := range outputs {
+			data, err := json.Marshal(output)
+			if err != nil {
+				return err
 			}
-			pterm.Println(printEvent(e))
+			fmt.Fprintln(o.Out, string(data))
 		}
 	}
 	return nil
@@ -0,0 +1,198 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/filter"
+)
+
+type ConflictsOptions struct {
+	by    string
+	limit int
+
+	// queryOptions selects and filters the events to analyze
+	queryOptions Options
+
+	genericclioptions.IOStreams
+}
+
+func NewConflictsCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &ConflictsOptions{IOStreams: streams, queryOptions: Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "conflicts --dir DIR",
+		Short: "Report the objects with the most 409 Conflict responses and the clients fighting over them",
+		Long: "Report the objects with the most 409 Conflict responses to updates and patches and the clients fighting over\n" +
+			"them. Every conflict is attributed to the client of the last successful write of the object that completed\n" +
+			"before it, the write that made the resource version of the losing client stale. Creates are ignored, their\n" +
+			"409 means the object already exists.",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	cmd.Flags().StringVar(&options.queryOptions.from, "from", "", "Only analyze events starting at this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().StringVar(&options.queryOptions.to, "to", "", "Only analyze events before this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().IntVar(&options.queryOptions.maxEventSize, "max-event-size", defaultMaxEventSize, "Maximum size in bytes of a single audit event.")
+	cmd.Flags().StringVar(&options.by, "by", "useragent", "Identify the competing clients by this field ("+strings.Join(topDimensions(), ", ")+").")
+	cmd.Flags().IntVar(&options.limit, "limit", 20, "Number of objects and client pairs with the most conflicts to display.")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *ConflictsOptions) Validate() error {
+	if len(o.queryOptions.targetDirectories) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	return validateTopBy(o.by)
+}
+
+// conflictWrite is a completed update or patch of an object, successful or rejected with a conflict.
+type conflictWrite struct {
+	completed time.Time
+	client    string
+	conflict  bool
+}
+
+type conflictObject struct {
+	resource  string
+	namespace string
+	name      string
+	writes    []conflictWrite
+	conflicts int
+}
+
+// conflictPair counts the conflicts of a losing client caused by the writes of a winning client.
+type conflictPair struct {
+	loser     string
+	winner    string
+	conflicts int
+	objects   map[string]struct{}
+}
+
+func (o *ConflictsOptions) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+
+	clientFunc := topKeyFuncs[o.by]
+	objects := map[string]*conflictObject{}
+	if err := o.queryOptions.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			if e.Stage != auditv1.StageResponseComplete || e.ResponseStatus == nil || e.ObjectRef == nil || len(e.ObjectRef.Name) == 0 {
+				continue
+			}
+			if e.Verb != "update" && e.Verb != "patch" {
+				continue
+			}
+			code := e.ResponseStatus.Code
+			if code != 409 && (code < 200 || code >= 300) {
+				continue
+			}
+			_, gvr, _, _ := filter.URIToParts(e.RequestURI)
+			resource := gvr.GroupResource().String()
+			key := strings.Join([]string{resource, e.ObjectRef.Namespace, e.ObjectRef.Name}, "\x00")
+			object, ok := objects[key]
+			if !ok {
+				object = &conflictObject{resource: resource, namespace: e.ObjectRef.Namespace, name: e.ObjectRef.Name}
+				objects[key] = object
+			}
+			object.writes = append(object.writes, conflictWrite{completed: e.StageTimestamp.Time, client: clientFunc(e), conflict: code == 409})
+			if code == 409 {
+				object.conflicts++
+			}
+		}
+	}); err != nil {
+		return err
+	}
+
+	conflicted := []*conflictObject{}
+	pairs := map[string]*conflictPair{}
+	for key, object := range objects {
+		if object.conflicts == 0 {
+			continue
+		}
+		conflicted = append(conflicted, object)
+		sort.SliceStable(object.writes, func(i, j int) bool {
+			return object.writes[i].completed.Before(object.writes[j].completed)
+		})
+		winner := ""
+		for _, write := range object.writes {
+			if !write.conflict {
+				winner = write.client
+				continue
+			}
+			pairKey := write.client + "\x00" + winner
+			pair, ok := pairs[pairKey]
+			if !ok {
+				pair = &conflictPair{loser: write.client, winner: winner, objects: map[string]struct{}{}}
+				pairs[pairKey] = pair
+			}
+			pair.conflicts++
+			pair.objects[key] = struct{}{}
+		}
+	}
+	if len(conflicted) == 0 {
+		fmt.Fprintln(o.Out, "No conflicts found.")
+		return nil
+	}
+
+	sort.Slice(conflicted, func(i, j int) bool {
+		if conflicted[i].conflicts != conflicted[j].conflicts {
+			return conflicted[i].conflicts > conflicted[j].conflicts
+		}
+		return conflicted[i].name < conflicted[j].name
+	})
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "CONFLICTS\tWRITES\t%sS\tRESOURCE\tNAMESPACE\tNAME\n", strings.ToUpper(o.by))
+	for i, object := range conflicted {
+		if o.limit > 0 && i >= o.limit {
+			break
+		}
+		clients := map[string]struct{}{}
+		for _, write := range object.writes {
+			clients[write.client] = struct{}{}
+		}
+		fmt.Fprintf(w, "%d\t%d\t%d\t%s\t%s\t%s\n", object.conflicts, len(object.writes)-object.conflicts, len(clients),
+			MatrixKey(object.resource), MatrixKey(object.namespace), object.name)
+	}
+	w.Flush()
+
+	sortedPairs := make([]*conflictPair, 0, len(pairs))
+	for _, pair := range pairs {
+		sortedPairs = append(sortedPairs, pair)
+	}
+	sort.Slice(sortedPairs, func(i, j int) bool {
+		if sortedPairs[i].conflicts != sortedPairs[j].conflicts {
+			return sortedPairs[i].conflicts > sortedPairs[j].conflicts
+		}
+		return sortedPairs[i].loser < sortedPairs[j].loser
+	})
+	fmt.Fprintln(o.Out)
+	pw := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	defer pw.Flush()
+	fmt.Fprintln(pw, "CONFLICTS\tOBJECTS\tLOSER\tWINNER")
+	for i, pair := range sortedPairs {
+		if o.limit > 0 && i >= o.limit {
+			break
+		}
+		// conflicts before the first successful write in the time range have no known winner
+		fmt.Fprintf(pw, "%d\t%d\t%s\t%s\n", pair.conflicts, len(pair.objects), MatrixKey(pair.loser), MatrixKey(pair.winner))
+	}
+	return nil
+}
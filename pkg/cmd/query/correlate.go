@@ -0,0 +1,172 @@
+package query
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+)
+
+func NewCorrelateCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "correlate",
+		Short: "Correlate audit events with other logs collected from the cluster",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+
+	cmd.AddCommand(NewCorrelateAPIServerLogsCommand(ctx, f, streams))
+	cmd.AddCommand(NewCorrelateEtcdCommand(ctx, f, streams))
+	return cmd
+}
+
+type CorrelateAPIServerLogsOptions struct {
+	logFiles []string
+	limit    int
+
+	// queryOptions selects and filters the events to correlate
+	queryOptions Options
+
+	genericclioptions.IOStreams
+}
+
+func NewCorrelateAPIServerLogsCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &CorrelateAPIServerLogsOptions{IOStreams: streams, queryOptions: Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "apiserver-logs --dir DIR --logs FILE",
+		Short: "Print the API server log lines (traces, http logs) logged for the selected audit events",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	cmd.Flags().StringVar(&options.queryOptions.from, "from", "", "Only correlate events starting at this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().StringVar(&options.queryOptions.to, "to", "", "Only correlate events before this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().IntVar(&options.queryOptions.maxEventSize, "max-event-size", defaultMaxEventSize, "Maximum size in bytes of a single audit event.")
+	cmd.Flags().StringSliceVar(&options.logFiles, "logs", options.logFiles, "API server container log files or directories with them (eg. from must-gather), plain or gzipped.")
+	cmd.Flags().IntVar(&options.limit, "limit", 0, "Limit the amount of correlated events to display.")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *CorrelateAPIServerLogsOptions) Validate() error {
+	if len(o.queryOptions.targetDirectories) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	if len(o.logFiles) == 0 {
+		return fmt.Errorf("API server log files must be specified (--logs)")
+	}
+	return nil
+}
+
+func (o *CorrelateAPIServerLogsOptions) Run(ctx context.Context) error {
+	logLines := map[string][]string{}
+	for _, logFile := range o.logFiles {
+		if err := filepath.Walk(logFile, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			return readAPIServerLog(path, logLines)
+		}); err != nil {
+			return err
+		}
+	}
+
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+	events, err := o.queryOptions.multiNodeEventDecoder(ctx, filters)
+	if err != nil {
+		return err
+	}
+
+	correlated := 0
+	for _, e := range events {
+		lines, ok := logLines[string(e.AuditID)]
+		if !ok {
+			continue
+		}
+		if o.limit > 0 && correlated >= o.limit {
+			break
+		}
+		correlated++
+		fmt.Fprintln(o.Out, printEvent(e, o.queryOptions.marks))
+		for _, line := range lines {
+			fmt.Fprintf(o.Out, "    %s\n", pterm.NewStyle(pterm.FgGray).Sprint(line))
+		}
+	}
+	if correlated == 0 {
+		fmt.Fprintln(o.ErrOut, "No API server log lines found for the selected audit events")
+	}
+	return nil
+}
+
+var (
+	// auditIDRegexp matches the audit ID logged by httplog (audit-ID="...") and by traces (audit-id:...)
+	auditIDRegexp = regexp.MustCompile(`audit-[iI][dD][=:]"?([0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12})`)
+	// traceRegexp matches the trace ID shared by all lines of a single multi-line trace
+	traceRegexp = regexp.MustCompile(`Trace\[(\d+)\]`)
+)
+
+// readAPIServerLog collects the log lines that reference an audit ID. Follow-up lines of a trace don't repeat the
+// audit ID, they are attributed to it by the trace ID.
+func readAPIServerLog(path string, logLines map[string][]string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gzipReader, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("unable to read %q: %v", path, err)
+		}
+		defer gzipReader.Close()
+		r = gzipReader
+	}
+
+	traces := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, InitialScanBufferSize), defaultMaxEventSize)
+	for scanner.Scan() {
+		line := scanner.Text()
+		traceID := ""
+		if match := traceRegexp.FindStringSubmatch(line); match != nil {
+			traceID = match[1]
+		}
+		if match := auditIDRegexp.FindStringSubmatch(line); match != nil {
+			auditID := strings.ToLower(match[1])
+			logLines[auditID] = append(logLines[auditID], line)
+			if len(traceID) > 0 {
+				traces[traceID] = auditID
+			}
+			continue
+		}
+		if auditID, ok := traces[traceID]; ok && len(traceID) > 0 {
+			logLines[auditID] = append(logLines[auditID], line)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,281 @@
+package query
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/sets"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/filter"
+)
+
+var mutatingVerbs = sets.NewString("create", "update", "patch", "delete", "deletecollection")
+
+type CorrelateEtcdOptions struct {
+	logFiles []string
+	slack    time.Duration
+	details  bool
+
+	// queryOptions selects and filters the events to correlate
+	queryOptions Options
+
+	genericclioptions.IOStreams
+}
+
+func NewCorrelateEtcdCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &CorrelateEtcdOptions{IOStreams: streams, queryOptions: Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "etcd --dir DIR --logs FILE",
+		Short: "Attribute etcd slow requests to the API clients whose mutating requests caused them",
+		Long: "Attribute etcd slow requests to the API clients whose mutating requests caused them. Every slow request entry\n" +
+			"from the etcd logs is paired with the mutating audit events in flight at that time that touched the same key.",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	cmd.Flags().StringVar(&options.queryOptions.from, "from", "", "Only correlate events starting at this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().StringVar(&options.queryOptions.to, "to", "", "Only correlate events before this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().IntVar(&options.queryOptions.maxEventSize, "max-event-size", defaultMaxEventSize, "Maximum size in bytes of a single audit event.")
+	cmd.Flags().StringSliceVar(&options.logFiles, "logs", options.logFiles, "etcd log files or directories with them (eg. from must-gather), plain or gzipped.")
+	cmd.Flags().DurationVar(&options.slack, "slack", time.Second, "Tolerance for clock skew between the API server and etcd when matching timestamps.")
+	cmd.Flags().BoolVar(&options.details, "details", false, "Print every matched pair of audit event and etcd slow request.")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *CorrelateEtcdOptions) Validate() error {
+	if len(o.queryOptions.targetDirectories) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	if len(o.logFiles) == 0 {
+		return fmt.Errorf("etcd log files must be specified (--logs)")
+	}
+	return nil
+}
+
+// etcdSlowRequest is a single "took too long" entry from the etcd logs.
+type etcdSlowRequest struct {
+	timestamp time.Time
+	took      time.Duration
+	key       string
+	line      string
+}
+
+type etcdLogEntry struct {
+	Timestamp string `json:"ts"`
+	Message   string `json:"msg"`
+	Took      string `json:"took"`
+	Request   string `json:"request"`
+}
+
+var etcdKeyRegexp = regexp.MustCompile(`key:"([^"]+)"`)
+
+func readEtcdLog(path string) ([]etcdSlowRequest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gzipReader, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %q: %v", path, err)
+		}
+		defer gzipReader.Close()
+		r = gzipReader
+	}
+
+	requests := []etcdSlowRequest{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, InitialScanBufferSize), defaultMaxEventSize)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		// container logs might be prefixed with the timestamp of the container runtime
+		start := bytes.IndexByte(line, '{')
+		if start == -1 {
+			continue
+		}
+		entry := etcdLogEntry{}
+		if err := json.Unmarshal(line[start:], &entry); err != nil || len(entry.Took) == 0 {
+			continue
+		}
+		took, err := time.ParseDuration(entry.Took)
+		if err != nil {
+			continue
+		}
+		timestamp, err := time.Parse(time.RFC3339Nano, entry.Timestamp)
+		if err != nil {
+			continue
+		}
+		key := ""
+		// some etcd versions log the request with the quotes escaped twice
+		if match := etcdKeyRegexp.FindStringSubmatch(strings.ReplaceAll(entry.Request, `\"`, `"`)); match != nil {
+			key = match[1]
+		}
+		if len(key) == 0 {
+			continue
+		}
+		requests = append(requests, etcdSlowRequest{timestamp: timestamp, took: took, key: key, line: string(line[start:])})
+	}
+	return requests, scanner.Err()
+}
+
+// etcdKeyPath returns the path the storage key of the object the event touched ends with, the storage prefix
+// (/kubernetes.io, /registry, ...) and the API group are not part of it.
+func etcdKeyPath(e *auditv1.Event) string {
+	ns, gvr, name, _ := filter.URIToParts(e.RequestURI)
+	if e.ObjectRef != nil {
+		if len(e.ObjectRef.Resource) > 0 {
+			gvr.Resource = e.ObjectRef.Resource
+		}
+		if len(e.ObjectRef.Namespace) > 0 {
+			ns = e.ObjectRef.Namespace
+		}
+		if len(e.ObjectRef.Name) > 0 {
+			name = e.ObjectRef.Name
+		}
+	}
+	if len(gvr.Resource) == 0 {
+		return ""
+	}
+	parts := []string{gvr.Resource}
+	if len(ns) > 0 && gvr.Resource != "namespaces" {
+		parts = append(parts, ns)
+	}
+	if len(name) > 0 {
+		parts = append(parts, name)
+	}
+	return "/" + strings.Join(parts, "/")
+}
+
+func matchesEtcdKey(keyPath, etcdKey string, collection bool) bool {
+	if collection {
+		return strings.Contains(etcdKey, keyPath+"/")
+	}
+	return strings.HasSuffix(etcdKey, keyPath)
+}
+
+type etcdAttribution struct {
+	user      string
+	requests  int
+	totalTook time.Duration
+	maxTook   time.Duration
+}
+
+func (o *CorrelateEtcdOptions) Run(ctx context.Context) error {
+	slowRequests := []etcdSlowRequest{}
+	for _, logFile := range o.logFiles {
+		if err := filepath.Walk(logFile, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			requests, err := readEtcdLog(path)
+			if err != nil {
+				return err
+			}
+			slowRequests = append(slowRequests, requests...)
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	sort.Slice(slowRequests, func(i, j int) bool {
+		return slowRequests[i].timestamp.Before(slowRequests[j].timestamp)
+	})
+
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+	events, err := o.queryOptions.multiNodeEventDecoder(ctx, filters)
+	if err != nil {
+		return err
+	}
+
+	attributions := map[string]*etcdAttribution{}
+	matched := sets.NewInt()
+	for _, e := range events {
+		if !mutatingVerbs.Has(e.Verb) {
+			continue
+		}
+		keyPath := etcdKeyPath(e)
+		if len(keyPath) == 0 {
+			continue
+		}
+		collection := e.Verb == "deletecollection"
+
+		start := e.RequestReceivedTimestamp.Add(-o.slack)
+		end := e.StageTimestamp.Add(o.slack)
+		first := sort.Search(len(slowRequests), func(i int) bool {
+			return !slowRequests[i].timestamp.Before(start)
+		})
+		for i := first; i < len(slowRequests) && !slowRequests[i].timestamp.After(end); i++ {
+			slow := slowRequests[i]
+			if !matchesEtcdKey(keyPath, slow.key, collection) {
+				continue
+			}
+			matched.Insert(i)
+			attribution, ok := attributions[e.User.Username]
+			if !ok {
+				attribution = &etcdAttribution{user: e.User.Username}
+				attributions[e.User.Username] = attribution
+			}
+			attribution.requests++
+			attribution.totalTook += slow.took
+			if slow.took > attribution.maxTook {
+				attribution.maxTook = slow.took
+			}
+			if o.details {
+				fmt.Fprintln(o.Out, printEvent(e, o.queryOptions.marks))
+				fmt.Fprintf(o.Out, "    etcd took %s: %s\n", slow.took, slow.line)
+			}
+		}
+	}
+
+	result := []*etcdAttribution{}
+	for _, attribution := range attributions {
+		result = append(result, attribution)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].totalTook > result[j].totalTook
+	})
+
+	if o.details && matched.Len() > 0 {
+		fmt.Fprintln(o.Out)
+	}
+	fmt.Fprintf(o.Out, "Matched %d of %d etcd slow requests to mutating audit events\n\n", matched.Len(), len(slowRequests))
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "USER\tSLOW REQUESTS\tTOTAL ETCD TIME\tMAX ETCD TIME")
+	for _, attribution := range result {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", attribution.user, attribution.requests, attribution.totalTook, attribution.maxTook)
+	}
+	return nil
+}
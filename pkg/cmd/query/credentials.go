@@ -0,0 +1,219 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/filter"
+)
+
+type CredentialsOptions struct {
+	// queryOptions selects and filters the events to report
+	queryOptions Options
+
+	genericclioptions.IOStreams
+}
+
+func NewCredentialsCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &CredentialsOptions{IOStreams: streams, queryOptions: Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "credentials --dir DIR",
+		Short: "Report who minted service account tokens, approved certificate signing requests and created OAuth access tokens for whom",
+		Long: "Report who minted service account tokens, approved certificate signing requests and created OAuth access tokens\n" +
+			"for whom. The subject and details are read from the request object when the events are logged at the Request\n" +
+			"level or above, otherwise the object name is reported.",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	cmd.Flags().StringVar(&options.queryOptions.from, "from", "", "Only report events starting at this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().StringVar(&options.queryOptions.to, "to", "", "Only report events before this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().IntVar(&options.queryOptions.maxEventSize, "max-event-size", defaultMaxEventSize, "Maximum size in bytes of a single audit event.")
+	cmd.Flags().Int64Var(&options.queryOptions.limit, "limit", 0, "Limit the amount of issued credentials to display.")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *CredentialsOptions) Validate() error {
+	if len(o.queryOptions.targetDirectories) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	return nil
+}
+
+const (
+	credentialServiceAccountToken = "serviceaccount-token"
+	credentialCSRApproval         = "csr-approval"
+	credentialOAuthAccessToken    = "oauth-access-token"
+)
+
+// credentialIssuance is a single request minting or approving a credential, logged once per audit stage.
+type credentialIssuance struct {
+	received time.Time
+	kind     string
+	issuer   string
+	subject  string
+	details  string
+	code     int32
+}
+
+// credentialKind returns the kind of the credential the event issued, empty string when the event doesn't issue one.
+func credentialKind(e *auditv1.Event) string {
+	_, gvr, _, subresource := filter.URIToParts(e.RequestURI)
+	switch {
+	case gvr.Group == "" && gvr.Resource == "serviceaccounts" && subresource == "token" && e.Verb == "create":
+		return credentialServiceAccountToken
+	case gvr.Group == "certificates.k8s.io" && gvr.Resource == "certificatesigningrequests" && subresource == "approval" && (e.Verb == "update" || e.Verb == "patch"):
+		return credentialCSRApproval
+	case gvr.Group == "oauth.openshift.io" && gvr.Resource == "oauthaccesstokens" && e.Verb == "create":
+		return credentialOAuthAccessToken
+	}
+	return ""
+}
+
+// credentialRequest holds the fields of the TokenRequest, CertificateSigningRequest and OAuthAccessToken objects
+// that tell whom the credential is for.
+type credentialRequest struct {
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Spec struct {
+		Audiences         []string `json:"audiences"`
+		ExpirationSeconds *int64   `json:"expirationSeconds"`
+		Username          string   `json:"username"`
+		SignerName        string   `json:"signerName"`
+		BoundObjectRef    *struct {
+			Kind string `json:"kind"`
+			Name string `json:"name"`
+		} `json:"boundObjectRef"`
+	} `json:"spec"`
+	UserName   string `json:"userName"`
+	ClientName string `json:"clientName"`
+	ExpiresIn  *int64 `json:"expiresIn"`
+}
+
+func newCredentialIssuance(e *auditv1.Event, kind string) *credentialIssuance {
+	ns, _, name, _ := filter.URIToParts(e.RequestURI)
+	if e.ObjectRef != nil && len(e.ObjectRef.Name) > 0 {
+		name = e.ObjectRef.Name
+	}
+	issuance := &credentialIssuance{
+		received: e.RequestReceivedTimestamp.Time,
+		kind:     kind,
+		issuer:   e.User.Username,
+		subject:  name,
+	}
+	if kind == credentialServiceAccountToken {
+		issuance.subject = fmt.Sprintf("system:serviceaccount:%s:%s", ns, name)
+	}
+
+	request := credentialRequest{}
+	if e.RequestObject == nil || json.Unmarshal(e.RequestObject.Raw, &request) != nil {
+		return issuance
+	}
+	details := []string{}
+	switch kind {
+	case credentialServiceAccountToken:
+		if len(request.Spec.Audiences) > 0 {
+			details = append(details, "audiences="+strings.Join(request.Spec.Audiences, ","))
+		}
+		if request.Spec.ExpirationSeconds != nil {
+			details = append(details, "expiration="+(time.Duration(*request.Spec.ExpirationSeconds)*time.Second).String())
+		}
+		if request.Spec.BoundObjectRef != nil {
+			details = append(details, "bound="+request.Spec.BoundObjectRef.Kind+"/"+request.Spec.BoundObjectRef.Name)
+		}
+	case credentialCSRApproval:
+		if len(request.Spec.Username) > 0 {
+			issuance.subject = request.Spec.Username
+			details = append(details, "csr="+name)
+		}
+		if len(request.Spec.SignerName) > 0 {
+			details = append(details, "signer="+request.Spec.SignerName)
+		}
+	case credentialOAuthAccessToken:
+		if len(request.UserName) > 0 {
+			issuance.subject = request.UserName
+		}
+		if len(request.ClientName) > 0 {
+			details = append(details, "client="+request.ClientName)
+		}
+		if request.ExpiresIn != nil {
+			details = append(details, "expiration="+(time.Duration(*request.ExpiresIn)*time.Second).String())
+		}
+	}
+	issuance.details = strings.Join(details, " ")
+	return issuance
+}
+
+func (o *CredentialsOptions) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+
+	issuances := map[string]*credentialIssuance{}
+	if err := o.queryOptions.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			kind := credentialKind(e)
+			if len(kind) == 0 {
+				continue
+			}
+			issuance, ok := issuances[string(e.AuditID)]
+			if !ok || (len(issuance.details) == 0 && e.RequestObject != nil) {
+				code := int32(0)
+				if ok {
+					code = issuance.code
+				}
+				issuance = newCredentialIssuance(e, kind)
+				issuance.code = code
+				issuances[string(e.AuditID)] = issuance
+			}
+			if e.ResponseStatus != nil {
+				issuance.code = e.ResponseStatus.Code
+			}
+		}
+	}); err != nil {
+		return err
+	}
+
+	result := []*credentialIssuance{}
+	for _, issuance := range issuances {
+		result = append(result, issuance)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].received.After(result[j].received)
+	})
+
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "TIME\tKIND\tISSUER\tSUBJECT\tCODE\tDETAILS")
+	for i, issuance := range result {
+		if o.queryOptions.limit > 0 && int64(i) >= o.queryOptions.limit {
+			break
+		}
+		code := "-"
+		if issuance.code != 0 {
+			code = strconv.Itoa(int(issuance.code))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", issuance.received.Format(TimeDefaultFormat), issuance.kind, issuance.issuer, issuance.subject, code, issuance.details)
+	}
+	return nil
+}
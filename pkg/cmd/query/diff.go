@@ -0,0 +1,265 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/filter"
+)
+
+type DiffOptions struct {
+	dirs      []string
+	froms     []string
+	tos       []string
+	limit     int
+	threshold float64
+
+	// filterOptions holds the filter flags shared by both sides of the diff
+	filterOptions Options
+
+	genericclioptions.IOStreams
+}
+
+func NewDiffCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &DiffOptions{IOStreams: streams, filterOptions: Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "diff --dir A --dir B | --dir A --from T1 --to T2 --from T3 --to T4",
+		Short: "Compare request rates and error rates of two audit dumps or two time windows",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.filterOptions.CheckUsage(options.Validate())
+			options.filterOptions.Exit(options.Run(ctx, f))
+		},
+	}
+
+	cmd.Flags().StringArrayVarP(&options.dirs, "dir", "d", options.dirs, "Directory to read the audit files from. Specify twice to compare two dumps.")
+	cmd.Flags().StringArrayVar(&options.froms, "from", options.froms, "Start of the time window (eg: '2006-01-02 15:03:04'). Specify twice to compare two time windows.")
+	cmd.Flags().StringArrayVar(&options.tos, "to", options.tos, "End of the time window (eg: '2006-01-02 15:03:04'). Specify twice to compare two time windows.")
+	cmd.Flags().IntVar(&options.limit, "limit", 20, "Number of biggest changes to display for every dimension.")
+	cmd.Flags().Float64Var(&options.threshold, "threshold", 50, "Highlight rate changes bigger than this percentage.")
+	options.filterOptions.AddFilterFlags(cmd.Flags())
+	options.filterOptions.AddErrorFormatFlag(cmd)
+
+	return cmd
+}
+
+func (o *DiffOptions) Validate() error {
+	if len(o.dirs) == 0 || len(o.dirs) > 2 {
+		return fmt.Errorf("one or two directories with audit files must be specified (--dir/-d)")
+	}
+	if len(o.froms) > 2 || len(o.tos) > 2 {
+		return fmt.Errorf("at most two --from/--to time windows can be specified")
+	}
+	if len(o.dirs) == 1 && len(o.froms) < 2 && len(o.tos) < 2 {
+		return fmt.Errorf("two directories or two time windows (--from/--to pairs) must be specified")
+	}
+	return nil
+}
+
+// side returns the query options for the baseline (0) or the compared (1) side of the diff.
+func (o *DiffOptions) side(i int) Options {
+	side := o.filterOptions
+	side.IOStreams = o.IOStreams
+	side.maxEventSize = defaultMaxEventSize
+	side.targetDirectories = []string{o.dirs[0]}
+	if len(o.dirs) > i {
+		side.targetDirectories = []string{o.dirs[i]}
+	}
+	side.from, side.to = "", ""
+	if len(o.froms) > i {
+		side.from = o.froms[i]
+	} else if len(o.froms) == 1 {
+		side.from = o.froms[0]
+	}
+	if len(o.tos) > i {
+		side.to = o.tos[i]
+	} else if len(o.tos) == 1 {
+		side.to = o.tos[0]
+	}
+	return side
+}
+
+func (o *DiffOptions) Run(ctx context.Context, f cmdutil.Factory) error {
+	profiles := []*auditProfile{}
+	for i := 0; i < 2; i++ {
+		side := o.side(i)
+		if err := side.Complete(ctx, f); err != nil {
+			return err
+		}
+		filters, err := side.SetupFilters()
+		if err != nil {
+			return err
+		}
+		events, err := side.multiNodeEventDecoder(ctx, filters)
+		if err != nil {
+			return err
+		}
+		profiles = append(profiles, newAuditProfile(events))
+	}
+
+	printProfileDiff(o.Out, profiles[0], profiles[1], o.limit, o.threshold)
+	return nil
+}
+
+var profileDimensions = []string{"user", "resource", "verb"}
+
+type profileCounter struct {
+	count  int
+	errors int
+}
+
+// auditProfile holds aggregated request counts of a set of events per dimension (user, resource, verb).
+type auditProfile struct {
+	total    int
+	errors   int
+	duration time.Duration
+	counters map[string]map[string]*profileCounter
+}
+
+func newAuditProfile(events []*auditv1.Event) *auditProfile {
+	p := &auditProfile{counters: map[string]map[string]*profileCounter{}}
+	for _, dimension := range profileDimensions {
+		p.counters[dimension] = map[string]*profileCounter{}
+	}
+
+	var first, last time.Time
+	for _, e := range events {
+		received := e.RequestReceivedTimestamp.Time
+		if first.IsZero() || received.Before(first) {
+			first = received
+		}
+		if received.After(last) {
+			last = received
+		}
+
+		failed := e.ResponseStatus != nil && e.ResponseStatus.Code >= 400
+		p.total++
+		if failed {
+			p.errors++
+		}
+
+		_, gvr, _, _ := filter.URIToParts(e.RequestURI)
+		resource := gvr.GroupResource().String()
+		if len(resource) == 0 {
+			resource = "<non-resource>"
+		}
+		keys := map[string]string{
+			"user":     e.User.Username,
+			"resource": resource,
+			"verb":     e.Verb,
+		}
+		for dimension, key := range keys {
+			counter, ok := p.counters[dimension][key]
+			if !ok {
+				counter = &profileCounter{}
+				p.counters[dimension][key] = counter
+			}
+			counter.count++
+			if failed {
+				counter.errors++
+			}
+		}
+	}
+	p.duration = last.Sub(first)
+	return p
+}
+
+// ratePerMinute returns the request rate normalized to the profile duration, so windows of different length compare.
+func (p *auditProfile) ratePerMinute(count int) float64 {
+	minutes := p.duration.Minutes()
+	if minutes < 1 {
+		minutes = 1
+	}
+	return float64(count) / minutes
+}
+
+func errorRate(errors, count int) float64 {
+	if count == 0 {
+		return 0
+	}
+	return 100 * float64(errors) / float64(count)
+}
+
+type profileChange struct {
+	key            string
+	rateA, rateB   float64
+	errorA, errorB float64
+	change         string
+	delta          float64
+	highlight      bool
+}
+
+func printProfileDiff(writer io.Writer, a, b *auditProfile, limit int, threshold float64) {
+	w := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintf(w, "Total:\t%d events (%.1f/min, %.2f%% errors)\t->\t%d events (%.1f/min, %.2f%% errors)\n",
+		a.total, a.ratePerMinute(a.total), errorRate(a.errors, a.total),
+		b.total, b.ratePerMinute(b.total), errorRate(b.errors, b.total))
+
+	for _, dimension := range profileDimensions {
+		keys := map[string]bool{}
+		for key := range a.counters[dimension] {
+			keys[key] = true
+		}
+		for key := range b.counters[dimension] {
+			keys[key] = true
+		}
+
+		changes := []profileChange{}
+		for key := range keys {
+			c := profileChange{key: key}
+			counterA, inA := a.counters[dimension][key]
+			counterB, inB := b.counters[dimension][key]
+			if inA {
+				c.rateA = a.ratePerMinute(counterA.count)
+				c.errorA = errorRate(counterA.errors, counterA.count)
+			}
+			if inB {
+				c.rateB = b.ratePerMinute(counterB.count)
+				c.errorB = errorRate(counterB.errors, counterB.count)
+			}
+			c.delta = c.rateB - c.rateA
+			switch {
+			case !inA:
+				c.change, c.highlight = "NEW", true
+			case !inB:
+				c.change, c.highlight = "GONE", true
+			default:
+				percent := 100 * c.delta / c.rateA
+				c.change = fmt.Sprintf("%+.1f%%", percent)
+				c.highlight = math.Abs(percent) >= threshold
+			}
+			changes = append(changes, c)
+		}
+		sort.Slice(changes, func(i, j int) bool {
+			if math.Abs(changes[i].delta) == math.Abs(changes[j].delta) {
+				return changes[i].key < changes[j].key
+			}
+			return math.Abs(changes[i].delta) > math.Abs(changes[j].delta)
+		})
+		if limit > 0 && len(changes) > limit {
+			changes = changes[:limit]
+		}
+
+		fmt.Fprintf(w, "\nBy %s:\n", dimension)
+		fmt.Fprintf(w, "  %s\tRATE A/min\tRATE B/min\tCHANGE\tERRORS A\tERRORS B\t\n", strings.ToUpper(dimension))
+		for _, c := range changes {
+			marker := " "
+			if c.highlight {
+				marker = "!"
+			}
+			fmt.Fprintf(w, "%s %s\t%.2f\t%.2f\t%s\t%.2f%%\t%.2f%%\t\n", marker, c.key, c.rateA, c.rateB, c.change, c.errorA, c.errorB)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/filter"
+)
+
+type DiscoveryOptions struct {
+	by    string
+	burst int64
+	limit int
+
+	// queryOptions selects and filters the events to analyze
+	queryOptions Options
+
+	genericclioptions.IOStreams
+}
+
+func NewDiscoveryCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &DiscoveryOptions{IOStreams: streams, queryOptions: Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "discovery --dir DIR",
+		Short: "Report the discovery and OpenAPI traffic per client to spot clients with broken discovery caching",
+		Long: "Report the discovery (/api, /apis and their group and version documents) and OpenAPI (/openapi/v2,\n" +
+			"/openapi/v3) traffic per client. Clients with a working discovery cache only refresh it occasionally, clients\n" +
+			"exceeding --burst requests in a minute (eg. old kubectl versions or clients creating a new discovery client per\n" +
+			"request) are flagged.",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	cmd.Flags().StringVar(&options.queryOptions.from, "from", "", "Only analyze events starting at this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().StringVar(&options.queryOptions.to, "to", "", "Only analyze events before this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().IntVar(&options.queryOptions.maxEventSize, "max-event-size", defaultMaxEventSize, "Maximum size in bytes of a single audit event.")
+	cmd.Flags().StringVar(&options.by, "by", "useragent", "Identify the clients by this field ("+strings.Join(topDimensions(), ", ")+").")
+	cmd.Flags().Int64Var(&options.burst, "burst", 100, "Flag clients exceeding this number of discovery and OpenAPI requests within a minute.")
+	cmd.Flags().IntVar(&options.limit, "limit", 20, "Number of clients with the highest peak rate to display.")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *DiscoveryOptions) Validate() error {
+	if len(o.queryOptions.targetDirectories) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	if o.queryOptions.excludeNoise {
+		return fmt.Errorf("--exclude-system-noise filters out the discovery requests to report")
+	}
+	return validateTopBy(o.by)
+}
+
+// discoveryClient counts the discovery and OpenAPI requests of a client, per minute for the peak rate.
+type discoveryClient struct {
+	client    string
+	discovery int64
+	openAPI   int64
+	first     time.Time
+	last      time.Time
+	perMinute map[int64]int64
+}
+
+func (c *discoveryClient) peak() int64 {
+	peak := int64(0)
+	for _, count := range c.perMinute {
+		if count > peak {
+			peak = count
+		}
+	}
+	return peak
+}
+
+func (o *DiscoveryOptions) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+
+	clientFunc := topKeyFuncs[o.by]
+	clients := map[string]*discoveryClient{}
+	if err := o.queryOptions.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			if e.Stage != auditv1.StageResponseComplete || e.ObjectRef != nil {
+				continue
+			}
+			discovery, openAPI := filter.IsDiscovery(e.RequestURI), filter.IsOpenAPI(e.RequestURI)
+			if !discovery && !openAPI {
+				continue
+			}
+			key := clientFunc(e)
+			c, ok := clients[key]
+			received := e.RequestReceivedTimestamp.Time
+			if !ok {
+				c = &discoveryClient{client: key, first: received, last: received, perMinute: map[int64]int64{}}
+				clients[key] = c
+			}
+			if discovery {
+				c.discovery++
+			} else {
+				c.openAPI++
+			}
+			if received.Before(c.first) {
+				c.first = received
+			}
+			if received.After(c.last) {
+				c.last = received
+			}
+			c.perMinute[received.Unix()/60]++
+		}
+	}); err != nil {
+		return err
+	}
+	if len(clients) == 0 {
+		fmt.Fprintln(o.Out, "No discovery requests found.")
+		return nil
+	}
+
+	type clientStats struct {
+		*discoveryClient
+		peak int64
+	}
+	result := make([]clientStats, 0, len(clients))
+	for _, c := range clients {
+		result = append(result, clientStats{discoveryClient: c, peak: c.peak()})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].peak != result[j].peak {
+			return result[i].peak > result[j].peak
+		}
+		return result[i].client < result[j].client
+	})
+
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "PEAK PER MINUTE\tAVG PER MINUTE\tDISCOVERY\tOPENAPI\tFIRST\tLAST\tBURST\t%s\n", strings.ToUpper(o.by))
+	for i, c := range result {
+		if o.limit > 0 && i >= o.limit {
+			break
+		}
+		total := c.discovery + c.openAPI
+		average := float64(total)
+		if minutes := c.last.Sub(c.first).Minutes(); minutes > 1 {
+			average /= minutes
+		}
+		burst := ""
+		if c.peak > o.burst {
+			burst = "yes"
+		}
+		fmt.Fprintf(w, "%d\t%.1f\t%d\t%d\t%s\t%s\t%s\t%s\n", c.peak, average, c.discovery, c.openAPI,
+			c.first.Format(TimeDefaultFormat), c.last.Format(TimeDefaultFormat), burst, MatrixKey(c.client))
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+)
+
+type DistinctOptions struct {
+	field string
+	limit int
+
+	// queryOptions selects and filters the events to report
+	queryOptions Options
+
+	genericclioptions.IOStreams
+}
+
+func NewDistinctCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &DistinctOptions{IOStreams: streams, queryOptions: Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "distinct --dir DIR --field FIELD",
+		Short: "List the distinct values of a field in the filtered events with their counts",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	cmd.Flags().StringVar(&options.queryOptions.from, "from", "", "Only report events starting at this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().StringVar(&options.queryOptions.to, "to", "", "Only report events before this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().IntVar(&options.queryOptions.maxEventSize, "max-event-size", defaultMaxEventSize, "Maximum size in bytes of a single audit event.")
+	cmd.Flags().StringVar(&options.field, "field", "user", "Field to list the distinct values of ("+strings.Join(topDimensions(), ", ")+").")
+	cmd.Flags().IntVar(&options.limit, "limit", 0, "Limit the amount of values to display.")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *DistinctOptions) Validate() error {
+	if len(o.queryOptions.targetDirectories) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	if _, ok := topKeyFuncs[o.field]; !ok {
+		return fmt.Errorf("invalid --field value %q, must be one of %s", o.field, strings.Join(topDimensions(), ", "))
+	}
+	return nil
+}
+
+// Run counts the events per value of the field in a single pass, the most frequent values are printed first.
+func (o *DistinctOptions) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+
+	keyFunc := topKeyFuncs[o.field]
+	counts := map[string]int64{}
+	if err := o.queryOptions.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			counts[keyFunc(e)]++
+		}
+	}); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "COUNT\t%s\n", strings.ToUpper(o.field))
+	for i, value := range sortedByTotal(counts) {
+		if o.limit > 0 && i >= o.limit {
+			break
+		}
+		fmt.Fprintf(w, "%d\t%s\n", counts[value], MatrixKey(value))
+	}
+	fmt.Fprintf(w, "\n%d distinct values\n", len(counts))
+	return nil
+}
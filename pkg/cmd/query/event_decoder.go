@@ -0,0 +1,186 @@
+package query
+
+import (
+	"bytes"
+	"time"
+	"unsafe"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/modern-go/reflect2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var (
+	// auditJSON decodes the audit events. The timestamps are parsed directly, their UnmarshalJSON decodes every one of
+	// them with encoding/json again.
+	auditJSON = func() jsoniter.API {
+		api := jsoniter.Config{EscapeHTML: true}.Froze()
+		api.RegisterExtension(jsoniter.DecoderExtension{
+			reflect2.TypeOf(metav1.MicroTime{}): microTimeDecoder{},
+		})
+		return api
+	}()
+
+	requestObjectKey  = []byte(`"requestObject"`)
+	responseObjectKey = []byte(`"responseObject"`)
+	jsonNull          = []byte("null")
+)
+
+// microTimeDecoder decodes the timestamps of the audit events like metav1.MicroTime.UnmarshalJSON.
+type microTimeDecoder struct{}
+
+func (microTimeDecoder) Decode(ptr unsafe.Pointer, iter *jsoniter.Iterator) {
+	t := (*metav1.MicroTime)(ptr)
+	if iter.ReadNil() {
+		*t = metav1.MicroTime{}
+		return
+	}
+	// timestamps have no escaped characters
+	value := iter.ReadStringAsSlice()
+	if iter.Error != nil {
+		return
+	}
+	if len(value) == 0 {
+		*t = metav1.MicroTime{}
+		return
+	}
+	parsed, err := time.Parse(metav1.RFC3339Micro, string(value))
+	if err != nil {
+		iter.ReportError("decode timestamp", err.Error())
+		return
+	}
+	*t = metav1.NewMicroTime(parsed.Local())
+}
+
+// cutRawObjects returns the audit event of the line without its requestObject and responseObject members, appended to
+// rest, and the values of the members. The objects are most of the bytes of RequestResponse events and the decoder
+// would parse all of their fields only to copy them. The values aren't validated, false is returned when the line
+// isn't a JSON object at all and it is decoded as is then.
+func cutRawObjects(line, rest []byte) ([]byte, []byte, []byte, bool) {
+	i := skipJSONSpace(line, 0)
+	if i >= len(line) || line[i] != '{' {
+		return nil, nil, nil, false
+	}
+	rest = append(rest[:0], '{')
+	i++
+	var request, response []byte
+	members := 0
+	for first := true; ; first = false {
+		i = skipJSONSpace(line, i)
+		if i >= len(line) {
+			return nil, nil, nil, false
+		}
+		if line[i] == '}' {
+			break
+		}
+		if !first {
+			if line[i] != ',' {
+				return nil, nil, nil, false
+			}
+			i = skipJSONSpace(line, i+1)
+		}
+		memberStart := i
+		if i >= len(line) || line[i] != '"' {
+			return nil, nil, nil, false
+		}
+		if i = skipJSONString(line, i); i < 0 {
+			return nil, nil, nil, false
+		}
+		key := line[memberStart:i]
+		i = skipJSONSpace(line, i)
+		if i >= len(line) || line[i] != ':' {
+			return nil, nil, nil, false
+		}
+		valueStart := skipJSONSpace(line, i+1)
+		if i = skipJSONValue(line, valueStart); i < 0 {
+			return nil, nil, nil, false
+		}
+		switch {
+		case bytes.Equal(key, requestObjectKey):
+			request = line[valueStart:i]
+		case bytes.Equal(key, responseObjectKey):
+			response = line[valueStart:i]
+		default:
+			if members > 0 {
+				rest = append(rest, ',')
+			}
+			rest = append(rest, line[memberStart:i]...)
+			members++
+		}
+	}
+	return append(rest, '}'), request, response, true
+}
+
+// rawObject returns the object of a requestObject or responseObject value, nil when it is missing or null.
+func rawObject(value []byte) *runtime.Unknown {
+	if len(value) == 0 || bytes.Equal(value, jsonNull) {
+		return nil
+	}
+	return &runtime.Unknown{Raw: append([]byte(nil), value...), ContentType: runtime.ContentTypeJSON}
+}
+
+func skipJSONSpace(data []byte, i int) int {
+	for i < len(data) && (data[i] == ' ' || data[i] == '\t' || data[i] == '\r' || data[i] == '\n') {
+		i++
+	}
+	return i
+}
+
+// skipJSONString returns the index after the string starting at i, -1 when it isn't terminated.
+func skipJSONString(data []byte, i int) int {
+	for i++; i < len(data); {
+		end := bytes.IndexByte(data[i:], '"')
+		if end < 0 {
+			return -1
+		}
+		end += i
+		// the quote is escaped when an odd number of backslashes precedes it
+		escapes := 0
+		for j := end - 1; j >= i && data[j] == '\\'; j-- {
+			escapes++
+		}
+		if escapes%2 == 0 {
+			return end + 1
+		}
+		i = end + 1
+	}
+	return -1
+}
+
+// skipJSONValue returns the index after the value starting at i, -1 when it isn't terminated.
+func skipJSONValue(data []byte, i int) int {
+	if i >= len(data) {
+		return -1
+	}
+	switch data[i] {
+	case '"':
+		return skipJSONString(data, i)
+	case '{', '[':
+		depth := 0
+		for i < len(data) {
+			switch data[i] {
+			case '"':
+				if i = skipJSONString(data, i); i < 0 {
+					return -1
+				}
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					return i + 1
+				}
+			}
+			i++
+		}
+		return -1
+	default:
+		// numbers, true, false and null
+		for i < len(data) && data[i] != ',' && data[i] != '}' && data[i] != ']' && data[i] != ' ' && data[i] != '\t' && data[i] != '\r' && data[i] != '\n' {
+			i++
+		}
+		return i
+	}
+}
@@ -2,25 +2,38 @@ package query
 
 import (
 	"fmt"
-	"io"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/pterm/pterm"
 
 	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+
+	"github.com/natamm4/audit-tool/pkg/audit/enrich"
+	"github.com/natamm4/audit-tool/pkg/audit/provenance"
+	"github.com/natamm4/audit-tool/pkg/workspace"
 )
 
-func printResponseCode(code int32) string {
+// pendingValue is printed in place of the response of an event that has none yet, eg. at the RequestReceived stage.
+const pendingValue = "-"
+
+// printResponseCode prints the response code padded to the width of the codes, the padding of the line format
+// doesn't see through the colors.
+func printResponseCode(e *auditv1.Event) string {
+	if e.ResponseStatus == nil {
+		return pterm.NewStyle(pterm.FgGray).Sprintf("%3s", pendingValue)
+	}
+	code := e.ResponseStatus.Code
 	switch {
 	case code >= 200 && code < 400:
-		return pterm.NewStyle(pterm.FgGreen).Sprintf("%d", code)
+		return pterm.NewStyle(pterm.FgGreen).Sprintf("%3d", code)
 	case code >= 400 && code < 500:
-		return pterm.NewStyle(pterm.FgLightRed).Sprintf("%d", code)
+		return pterm.NewStyle(pterm.FgLightRed).Sprintf("%3d", code)
 	case code > 500:
-		return pterm.NewStyle(pterm.FgRed).Sprintf("%d", code)
+		return pterm.NewStyle(pterm.FgRed).Sprintf("%3d", code)
 	default:
-		return pterm.Sprintf("%d", code)
+		return pterm.Sprintf("%3d", code)
 	}
 }
 
@@ -32,58 +45,184 @@ func printRequestURI(u string) string {
 	return u
 }
 
-func printUser(e *auditv1.Event) string {
+// userName returns the user printed for the event, the user agent of anonymous requests.
+func userName(e *auditv1.Event) string {
 	if len(e.User.Username) > 0 {
-		return pterm.NewStyle(pterm.FgGray).Sprintf("%s", strings.ReplaceAll(e.User.Username, "system:serviceaccount:", "sa:"))
+		return strings.ReplaceAll(e.User.Username, "system:serviceaccount:", "sa:")
 	}
 	return e.UserAgent
 }
 
+func printUser(e *auditv1.Event, width int) string {
+	if len(e.User.Username) > 0 {
+		return pterm.NewStyle(pterm.FgGray).Sprintf("%s", truncateMiddle(userName(e), width))
+	}
+	return truncateMiddle(userName(e), width)
+}
+
 func printTime(t time.Time) string {
-	return pterm.NewStyle(pterm.FgGray).Sprintf("%s", t.Format(timeDefaultFormat))
+	return pterm.NewStyle(pterm.FgGray).Sprintf("%s", t.Format(TimeDefaultFormat))
 }
 
 func printElapsedTime(e *auditv1.Event) string {
+	// the request is still being processed when the event was written
+	if e.Stage == auditv1.StageRequestReceived {
+		return pterm.NewStyle(pterm.FgWhite).Sprintf("[%s]", pendingValue)
+	}
 	return pterm.NewStyle(pterm.FgWhite).Sprintf("[%s]", e.StageTimestamp.Sub(e.RequestReceivedTimestamp.Time))
 }
 
-func printEvent(e *auditv1.Event) string {
-	return pterm.Sprintf("[ %s ][ %s ][ %3s ] %s [%s]%s", printTime(e.RequestReceivedTimestamp.Time), pterm.NewStyle(pterm.FgLightWhite).Sprintf("%6s", strings.ToUpper(e.Verb)), printResponseCode(e.ResponseStatus.Code), printRequestURI(e.RequestURI), printUser(e), printElapsedTime(e))
+func printMark(e *auditv1.Event, marks workspace.Marks) string {
+	mark, ok := marks[string(e.AuditID)]
+	if !ok {
+		return ""
+	}
+	if len(mark.Note) == 0 {
+		return pterm.NewStyle(pterm.FgYellow).Sprintf(" ★")
+	}
+	return pterm.NewStyle(pterm.FgYellow).Sprintf(" ★ %s", mark.Note)
 }
 
-func printOpenMetricsCounts(events []*auditv1.Event, w io.Writer) error {
-	counts := map[string]int{}
+func printProvenance(e *auditv1.Event) string {
+	p, ok := provenance.Get(e)
+	if !ok {
+		return ""
+	}
+	return pterm.NewStyle(pterm.FgGray).Sprintf(" (%s %s)", p.Component, p)
+}
 
-	for _, e := range events {
-		user := e.User.Username
-		verb := e.Verb
-		code := e.ResponseStatus.Code
+func printEnrichment(e *auditv1.Event) string {
+	values := enrich.Values(e)
+	if len(values) == 0 {
+		return ""
+	}
+	return pterm.NewStyle(pterm.FgCyan).Sprintf(" {%s}", strings.Join(values, " "))
+}
 
-		key := fmt.Sprintf("%s|%s|%d", user, verb, code)
-		counts[key]++
+// printCluster prints the cluster of the event, only set when several clusters are queried.
+func printCluster(e *auditv1.Event) string {
+	cluster := provenance.Cluster(e)
+	if len(cluster) == 0 {
+		return ""
 	}
+	return pterm.NewStyle(pterm.FgLightBlue).Sprintf("[ %s ]", cluster)
+}
 
-	fmt.Fprintf(w, "# TYPE audit_event_total counter\n")
-	for key, count := range counts {
-		parts := strings.Split(key, "|")
-		user, verb, code := parts[0], parts[1], parts[2]
+// printObjectRef prints the object of the request as RESOURCE[.GROUP][/SUBRESOURCE] [NAMESPACE/]NAME, like the
+// --resource, --namespace and --name filters take it.
+func printObjectRef(e *auditv1.Event) string {
+	if e.ObjectRef == nil {
+		return pendingValue
+	}
+	ref := e.ObjectRef
+	resource := ref.Resource
+	if len(ref.APIGroup) > 0 {
+		resource += "." + ref.APIGroup
+	}
+	if len(ref.Subresource) > 0 {
+		resource += "/" + ref.Subresource
+	}
+	name := ref.Name
+	if len(ref.Namespace) > 0 {
+		name = ref.Namespace + "/" + name
+	}
+	if len(name) == 0 {
+		return resource
+	}
+	return resource + " " + name
+}
+
+func printSourceIPs(e *auditv1.Event) string {
+	if len(e.SourceIPs) == 0 {
+		return pendingValue
+	}
+	return strings.Join(e.SourceIPs, ",")
+}
 
-		fmt.Fprintf(w, "audit_event_total{user=\"%s\",verb=\"%s\",code=\"%s\"} %d\n", user, verb, code, count)
+func printEvent(e *auditv1.Event, marks workspace.Marks) string {
+	return printEventLine(e, "", marks, lineLayout{})
+}
+
+// wideDetails returns the stage, the audit ID, the object and the source IPs of the event -o wide adds to the line of
+// printEvent, the fields follow-up queries select the request or the object by.
+func wideDetails(e *auditv1.Event) string {
+	return pterm.Sprintf(" %s %s [%s] %s", pterm.NewStyle(pterm.FgCyan).Sprintf("%s", e.Stage), pterm.NewStyle(pterm.FgGray).Sprintf("%s", e.AuditID), printObjectRef(e), printSourceIPs(e))
+}
+
+func printEventLine(e *auditv1.Event, details string, marks workspace.Marks, layout lineLayout) string {
+	uri := printRequestURI(e.RequestURI)
+	if layout.uriWidth > 0 {
+		uri = fmt.Sprintf("%-*s", layout.uriWidth, truncateMiddle(uri, layout.uriWidth))
 	}
-	return nil
+	return pterm.Sprintf("%s[ %s ][ %s ][ %3s ] %s [%s]%s%s%s", printCluster(e), printTime(e.RequestReceivedTimestamp.Time), pterm.NewStyle(pterm.FgLightWhite).Sprintf("%6s", strings.ToUpper(e.Verb)), printResponseCode(e), uri, printUser(e, layout.userWidth), printElapsedTime(e), details, printMark(e, marks))
 }
 
-func printOpenMetricsTimestamps(events []*auditv1.Event, w io.Writer) error {
-	fmt.Fprintln(w, "# TYPE audit_event_timestamp gauge")
+const (
+	// maxUserWidth is the width long usernames are truncated to when the lines are fitted to a width
+	maxUserWidth = 32
+	// minUserWidth is the width usernames are never truncated below
+	minUserWidth = 12
+	// minURIWidth is the width the request URIs are never truncated below, the lines get longer than the width instead
+	minURIWidth = 24
+)
 
+// lineLayout fits the event lines to a width: the request URIs are truncated to the same width, and padded to it so
+// that the columns after them are aligned, and long usernames are truncated. The zero value prints them as is.
+type lineLayout struct {
+	uriWidth  int
+	userWidth int
+}
+
+// newLineLayout returns the layout fitting the lines to the width, line returns the line of an event as printed
+// without a layout.
+func newLineLayout(events []*auditv1.Event, width int, line func(e *auditv1.Event) string) lineLayout {
+	longestURI, longestUser, others := 0, 0, 0
 	for _, e := range events {
-		user := e.User.Username
-		verb := e.Verb
-		code := e.ResponseStatus.Code
-		timeStamp := e.RequestReceivedTimestamp.Time.UnixMilli()
+		uri := utf8.RuneCountInString(printRequestURI(e.RequestURI))
+		user := utf8.RuneCountInString(userName(e))
+		if uri > longestURI {
+			longestURI = uri
+		}
+		if user > longestUser {
+			longestUser = user
+		}
+		if rest := utf8.RuneCountInString(pterm.RemoveColorFromString(line(e))) - uri - user; rest > others {
+			others = rest
+		}
+	}
+	available := width - others
+	layout := lineLayout{userWidth: longestUser}
+	if layout.userWidth > maxUserWidth {
+		layout.userWidth = maxUserWidth
+	}
+	// on narrow terminals the usernames are shortened further before the request URIs
+	if layout.userWidth > minUserWidth && available-layout.userWidth < minURIWidth {
+		layout.userWidth = available - minURIWidth
+		if layout.userWidth < minUserWidth {
+			layout.userWidth = minUserWidth
+		}
+	}
+	layout.uriWidth = available - layout.userWidth
+	if layout.uriWidth < minURIWidth {
+		layout.uriWidth = minURIWidth
+	}
+	if layout.uriWidth > longestURI {
+		layout.uriWidth = longestURI
+	}
+	return layout
+}
 
-		fmt.Fprintf(w, "audit_event_timestamp{user=\"%s\",verb=\"%s\",code=\"%d\"} 1 %d\n", user, verb, code, timeStamp)
+// truncateMiddle shortens s to width characters with an ellipsis in the middle, the start and the end of request
+// URIs and usernames tell them apart best. A width of 0 doesn't truncate.
+func truncateMiddle(s string, width int) string {
+	runes := []rune(s)
+	if width <= 0 || len(runes) <= width {
+		return s
+	}
+	if width == 1 {
+		return "…"
 	}
-	fmt.Fprintln(w, "# EOF")
-	return nil
+	head := (width - 1) / 2
+	tail := width - 1 - head
+	return string(runes[:head]) + "…" + string(runes[len(runes)-tail:])
 }
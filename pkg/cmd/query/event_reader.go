@@ -2,49 +2,252 @@ package query
 
 import (
 	"bufio"
-	"compress/gzip"
+	"bytes"
+	"fmt"
+	"io"
 	"log"
-	"os"
+	"math/rand"
 	"sort"
+	"sync"
+	"time"
 
+	"github.com/natamm4/audit-tool/pkg/audit/decompress"
 	"github.com/natamm4/audit-tool/pkg/audit/filter"
+	"github.com/natamm4/audit-tool/pkg/audit/provenance"
+	"github.com/natamm4/audit-tool/pkg/audit/runstats"
 
-	jsoniter "github.com/json-iterator/go"
 	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
 )
 
-func decodeAuditEvents(name string, filters ...filter.AuditFilters) ([]*auditv1.Event, error) {
-	f, err := os.Open(name)
-	if err != nil {
+const (
+	// defaultMaxEventSize is the maximum size of a single audit log line. RequestResponse level events carry
+	// the full request and response objects and easily exceed the 64KB bufio.Scanner default.
+	defaultMaxEventSize = 16 * 1024 * 1024
+
+	// InitialScanBufferSize is the size of the scanner buffer we start with, it grows up to maxEventSize on demand.
+	InitialScanBufferSize = 256 * 1024
+
+	// decodeBatchSize is the number of events decoded before the filters are applied. Events rejected by the
+	// filters are returned to the pool and reused for the next batch.
+	decodeBatchSize = 512
+)
+
+var (
+	scanBufferPool = sync.Pool{
+		New: func() interface{} {
+			b := make([]byte, InitialScanBufferSize)
+			return &b
+		},
+	}
+	eventPool = sync.Pool{
+		New: func() interface{} {
+			return &auditv1.Event{}
+		},
+	}
+)
+
+// sampler decides which audit log lines are decoded at all, so exploratory queries over large archives don't have to
+// parse every event. A nil sampler keeps every line.
+type sampler struct {
+	rate     float64
+	everyNth int
+	// lines are the ascending line numbers to keep when replaying cached query results, all other lines are skipped
+	lines []int
+
+	seen   int
+	random *rand.Rand
+}
+
+// newSampler returns a sampler keeping every nth line and of those a random rate fraction, nil when neither is set.
+func newSampler(rate float64, everyNth int) (*sampler, error) {
+	if rate < 0 || rate > 1 {
+		return nil, fmt.Errorf("--sample-rate must be between 0 and 1, got %v", rate)
+	}
+	if everyNth < 0 {
+		return nil, fmt.Errorf("--every-nth must not be negative, got %d", everyNth)
+	}
+	if (rate == 0 || rate == 1) && everyNth <= 1 {
+		return nil, nil
+	}
+	return &sampler{rate: rate, everyNth: everyNth, random: rand.New(rand.NewSource(time.Now().UnixNano()))}, nil
+}
+
+// newLineSampler returns a sampler keeping only the lines, which must be in ascending order.
+func newLineSampler(lines []int) *sampler {
+	return &sampler{lines: lines}
+}
+
+// skip counts the lines before the line a reader starts at, the line numbers are those of the whole file.
+func (s *sampler) skip(lines int) {
+	if s != nil {
+		s.seen += lines
+	}
+}
+
+func (s *sampler) keep() bool {
+	if s == nil {
+		return true
+	}
+	s.seen++
+	if s.lines != nil {
+		for len(s.lines) > 0 && s.lines[0] < s.seen {
+			s.lines = s.lines[1:]
+		}
+		return len(s.lines) > 0 && s.lines[0] == s.seen
+	}
+	if s.everyNth > 1 && s.seen%s.everyNth != 0 {
+		return false
+	}
+	if s.rate > 0 && s.rate < 1 {
+		return s.random.Float64() < s.rate
+	}
+	return true
+}
+
+func decodeAuditEvents(r io.Reader, maxEventSize int, origin provenance.Provenance, sample *sampler, filters ...filter.AuditFilters) ([]*auditv1.Event, error) {
+	events := []*auditv1.Event{}
+	if err := scanAuditEvents(r, maxEventSize, origin, sample, filters, false, func(batch []*auditv1.Event) {
+		events = append(events, batch...)
+	}); err != nil {
 		return nil, err
 	}
-	defer f.Close()
 
-	gzipReader, err := gzip.NewReader(f)
+	sortNewestFirst(events)
+	return events, nil
+}
+
+// sortNewestFirst sorts the events by the time the requests were received, newest first.
+func sortNewestFirst(events []*auditv1.Event) {
+	defer activeProfile.since(phaseSort, activeProfile.now())
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].RequestReceivedTimestamp.After(events[j].RequestReceivedTimestamp.Time)
+	})
+}
+
+// scanAuditEvents decodes the audit events, compressed or not, and calls visit for every batch of events accepted by the
+// filters. When recycle is set the events are returned to the pool once visit returns, so visit must not retain
+// them. This keeps memory bounded when the events are only aggregated. Every event gets the origin provenance
+// with its line number recorded, the line of the origin is the number of lines before r when it starts in the middle of
+// the audit file. Lines not kept by the sampler are skipped before they are decoded.
+func scanAuditEvents(r io.Reader, maxEventSize int, origin provenance.Provenance, sample *sampler, filters []filter.AuditFilters, recycle bool, visit func([]*auditv1.Event)) error {
+	auditReader, err := decompress.NewReader(r)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer gzipReader.Close()
+	defer auditReader.Close()
 
-	fileScanner := bufio.NewScanner(gzipReader)
+	if maxEventSize <= 0 {
+		maxEventSize = defaultMaxEventSize
+	}
+	buf := scanBufferPool.Get().(*[]byte)
+	defer scanBufferPool.Put(buf)
+
+	fileScanner := bufio.NewScanner(auditReader)
+	fileScanner.Buffer(*buf, maxEventSize)
 	fileScanner.Split(bufio.ScanLines)
-	events := []*auditv1.Event{}
 
+	// the time between the batches is spent decoding, the flushes filter and visit them
+	profile := activeProfile
+	mark := profile.now()
+	batch := make([]*auditv1.Event, 0, decodeBatchSize)
+	read := 0
+	flush := func() {
+		mark = profile.since(phaseDecode, mark)
+		var accepted []*auditv1.Event
+		if recycle {
+			accepted = applyFilters(batch, filters)
+		} else {
+			accepted = filterBatch(batch, filters)
+		}
+		mark = profile.since(phaseFilter, mark)
+		profile.batch(read, len(batch), len(accepted))
+		runstats.AddDecoded(len(batch))
+		read = 0
+		visit(accepted)
+		if recycle {
+			for _, event := range batch {
+				releaseEvent(event)
+			}
+		}
+		mark = profile.since(phaseOutput, mark)
+		batch = batch[:0]
+	}
+	// rest holds the lines of RequestResponse events without their objects
+	var rest []byte
+	line := origin.Line
+	sample.skip(line)
 	for fileScanner.Scan() {
-		event := auditv1.Event{}
+		read++
+		line++
+		if !sample.keep() {
+			continue
+		}
 		eventBytes := fileScanner.Bytes()
-		if err := jsoniter.Unmarshal(eventBytes, &event); err != nil {
+		decoded := eventBytes
+		var request, response []byte
+		if bytes.Contains(eventBytes, requestObjectKey) || bytes.Contains(eventBytes, responseObjectKey) {
+			if cut, requestValue, responseValue, ok := cutRawObjects(eventBytes, rest); ok {
+				rest = cut
+				decoded, request, response = cut, requestValue, responseValue
+			}
+		}
+		event := eventPool.Get().(*auditv1.Event)
+		iter := auditJSON.BorrowIterator(decoded)
+		iter.ReadVal(event)
+		err := iter.Error
+		auditJSON.ReturnIterator(iter)
+		if err != nil {
 			log.Printf("failed to unmarshal audit event: %q: %v", string(eventBytes), err)
+			runstats.AddDecodeError()
+			releaseEvent(event)
+			continue
+		}
+		if request != nil || response != nil {
+			event.RequestObject, event.ResponseObject = rawObject(request), rawObject(response)
+		}
+		origin.Line = line
+		provenance.Set(event, origin)
+		batch = append(batch, event)
+		if len(batch) == decodeBatchSize {
+			flush()
 		}
-		events = append(events, &event)
 	}
+	if err := fileScanner.Err(); err != nil {
+		return err
+	}
+	flush()
+	return nil
+}
 
+func applyFilters(batch []*auditv1.Event, filters []filter.AuditFilters) []*auditv1.Event {
+	accepted := batch
 	for _, f := range filters {
-		events = f.FilterEvents(events...)
+		accepted = f.FilterEvents(accepted...)
 	}
-	sort.Slice(events, func(i, j int) bool {
-		return events[i].RequestReceivedTimestamp.After(events[i].RequestReceivedTimestamp.Time)
-	})
+	return accepted
+}
 
-	return events, nil
+// filterBatch applies the filters to the batch and returns the events that were rejected back to the pool.
+func filterBatch(batch []*auditv1.Event, filters []filter.AuditFilters) []*auditv1.Event {
+	accepted := applyFilters(batch, filters)
+	if len(accepted) == len(batch) {
+		return append([]*auditv1.Event{}, batch...)
+	}
+
+	kept := make(map[*auditv1.Event]struct{}, len(accepted))
+	for _, event := range accepted {
+		kept[event] = struct{}{}
+	}
+	for _, event := range batch {
+		if _, ok := kept[event]; !ok {
+			releaseEvent(event)
+		}
+	}
+	return accepted
+}
+
+func releaseEvent(event *auditv1.Event) {
+	provenance.Forget(event)
+	*event = auditv1.Event{}
+	eventPool.Put(event)
 }
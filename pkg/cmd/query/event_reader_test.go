@@ -0,0 +1,163 @@
+package query
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+
+	"github.com/natamm4/audit-tool/pkg/audit/provenance"
+)
+
+// benchmarkEvents is the number of events of the benchmark fixtures.
+const benchmarkEvents = 2000
+
+// auditLog returns an audit log of benchmarkEvents events, with the request and response objects of pods at the
+// RequestResponse level.
+func auditLog(tb testing.TB, level auditv1.Level) []byte {
+	received := metav1.NewMicroTime(time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC))
+	pod := []byte(fmt.Sprintf(`{"kind":"Pod","apiVersion":"v1","metadata":{"name":"web-0","namespace":"prod","labels":{"app":"web"},"annotations":{"note":%q}},"spec":{"containers":[{"name":"web","image":"registry.example.com/web:1.0","env":[%s]}]}}`,
+		strings.Repeat("x", 2048), strings.TrimSuffix(strings.Repeat(`{"name":"SETTING","value":"some configuration value"},`, 100), ",")))
+
+	buf := &bytes.Buffer{}
+	for i := 0; i < benchmarkEvents; i++ {
+		e := auditv1.Event{
+			TypeMeta:                 metav1.TypeMeta{Kind: "Event", APIVersion: "audit.k8s.io/v1"},
+			Level:                    level,
+			AuditID:                  types.UID(fmt.Sprintf("0b6b2d2e-3c8e-4a55-9f0e-%012d", i)),
+			Stage:                    auditv1.StageResponseComplete,
+			RequestURI:               "/api/v1/namespaces/prod/pods/web-0",
+			Verb:                     "update",
+			SourceIPs:                []string{"10.0.0.1"},
+			UserAgent:                "kube-controller-manager/v1.21.0 (linux/amd64) kubernetes/5e58841/system:serviceaccount:kube-system:statefulset-controller",
+			ObjectRef:                &auditv1.ObjectReference{Resource: "pods", Namespace: "prod", Name: "web-0", APIVersion: "v1"},
+			ResponseStatus:           &metav1.Status{Code: 200},
+			RequestReceivedTimestamp: received,
+			StageTimestamp:           received,
+			Annotations:              map[string]string{"authorization.k8s.io/decision": "allow", "authorization.k8s.io/reason": ""},
+		}
+		e.User.Username = "system:serviceaccount:kube-system:statefulset-controller"
+		e.User.Groups = []string{"system:serviceaccounts", "system:serviceaccounts:kube-system", "system:authenticated"}
+		if level == auditv1.LevelRequestResponse {
+			e.RequestObject = &runtime.Unknown{Raw: pod, ContentType: runtime.ContentTypeJSON}
+			e.ResponseObject = &runtime.Unknown{Raw: pod, ContentType: runtime.ContentTypeJSON}
+		}
+		line, err := json.Marshal(e)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+func TestScanAuditEvents(t *testing.T) {
+	log := auditLog(t, auditv1.LevelRequestResponse)
+	origin := provenance.Provenance{Node: "master-0", File: "master-0-audit.log", Line: 10}
+	lines := []int{}
+	if err := scanAuditEvents(bytes.NewReader(log), 0, origin, nil, nil, false, func(events []*auditv1.Event) {
+		for _, e := range events {
+			if e.RequestObject == nil || len(e.RequestObject.Raw) == 0 {
+				t.Errorf("event %s has no request object", e.AuditID)
+			}
+			p, ok := provenance.Get(e)
+			if !ok {
+				t.Fatalf("event %s has no provenance", e.AuditID)
+			}
+			lines = append(lines, p.Line)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != benchmarkEvents {
+		t.Fatalf("expected %d events, got %d", benchmarkEvents, len(lines))
+	}
+	// the line numbers continue after the lines of the origin
+	if lines[0] != 11 || lines[len(lines)-1] != 10+benchmarkEvents {
+		t.Errorf("expected the lines 11 to %d, got %d to %d", 10+benchmarkEvents, lines[0], lines[len(lines)-1])
+	}
+}
+
+// BenchmarkScanAuditEvents decodes audit logs the way the aggregations do, recycling the events.
+func BenchmarkScanAuditEvents(b *testing.B) {
+	for _, level := range []auditv1.Level{auditv1.LevelMetadata, auditv1.LevelRequestResponse} {
+		b.Run(string(level), func(b *testing.B) {
+			log := auditLog(b, level)
+			b.SetBytes(int64(len(log)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := scanAuditEvents(bytes.NewReader(log), 0, provenance.Provenance{}, nil, nil, true, func([]*auditv1.Event) {}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestScanAuditEventsDecodesLikeEncodingJSON(t *testing.T) {
+	lines := []string{
+		`{"kind":"Event","apiVersion":"audit.k8s.io/v1","level":"Metadata","auditID":"a1","stage":"ResponseComplete","requestURI":"/api/v1/pods","verb":"list","requestReceivedTimestamp":"2026-10-01T10:00:01.000001Z","stageTimestamp":"2026-10-01T10:00:01.500000Z"}`,
+		`{"kind":"Event","level":"RequestResponse","auditID":"a2","requestObject":{"kind":"Pod","metadata":{"name":"a\"}b","annotations":{"requestObject":"{\\\"x\\\":[1,2]}"}}},"responseObject":null,"requestReceivedTimestamp":"2026-10-01T10:00:02.000000Z"}`,
+		`{ "kind" : "Event" , "auditID" : "a3" , "responseObject" : { "kind" : "Status", "code" : 404, "details" : [ true, false, null, 1.5e3 ] } , "annotations" : { "note" : "requestObject" } }`,
+		`{"auditID":"a4","requestObject":{"kind":"Secret","data":{"key":"c2VjcmV0\\\\"}},"stage":"RequestReceived"}`,
+		`{"auditID":"a5","requestObject":{},"responseObject":[]}`,
+	}
+	got := []*auditv1.Event{}
+	if err := scanAuditEvents(strings.NewReader(strings.Join(lines, "\n")), 0, provenance.Provenance{}, nil, nil, false, func(events []*auditv1.Event) {
+		got = append(got, events...)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(lines) {
+		t.Fatalf("expected %d events, got %d", len(lines), len(got))
+	}
+	for i, line := range lines {
+		expected := &auditv1.Event{}
+		if err := json.Unmarshal([]byte(line), expected); err != nil {
+			t.Fatalf("line %d: %v", i+1, err)
+		}
+		if !reflect.DeepEqual(expected, got[i]) {
+			t.Errorf("line %d: expected\n%#v\ngot\n%#v", i+1, expected, got[i])
+		}
+	}
+}
+
+func TestCutRawObjects(t *testing.T) {
+	tests := []struct {
+		line              string
+		rest              string
+		request, response string
+		ok                bool
+	}{
+		{line: `{"a":1,"requestObject":{"b":"}"},"c":[1,{"d":2}]}`, rest: `{"a":1,"c":[1,{"d":2}]}`, request: `{"b":"}"}`, ok: true},
+		{line: `{"requestObject":null,"responseObject":{"e":"\\"}}`, rest: `{}`, request: `null`, response: `{"e":"\\"}`, ok: true},
+		{line: ` { "responseObject" : {"f":"\"requestObject\":"} , "g" : true } `, rest: `{"g" : true}`, response: `{"f":"\"requestObject\":"}`, ok: true},
+		{line: `{}`, rest: `{}`, ok: true},
+		{line: `[1,2]`},
+		{line: `{"requestObject":{"unterminated":"}`},
+		{line: `{"a":1 "b":2}`},
+	}
+	for _, test := range tests {
+		rest, request, response, ok := cutRawObjects([]byte(test.line), nil)
+		if ok != test.ok {
+			t.Errorf("%s: expected ok %v, got %v", test.line, test.ok, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if string(rest) != test.rest || string(request) != test.request || string(response) != test.response {
+			t.Errorf("%s: expected %s, %s and %s, got %s, %s and %s", test.line, test.rest, test.request, test.response, rest, request, response)
+		}
+	}
+}
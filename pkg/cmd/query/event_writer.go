@@ -0,0 +1,272 @@
+package query
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+
+	"github.com/natamm4/audit-tool/pkg/audit/provenance"
+)
+
+const (
+	outputCSV              = "csv"
+	outputJSON             = "json"
+	outputAuditLog         = "auditlog"
+	outputNDJSON           = "ndjson"
+	outputOpenMetricsCount = "openmetricsCount"
+	outputOpenMetricsTime  = "openmetricsTime"
+)
+
+// EventWriter writes the events of an output format one at a time, as they are decoded, so that an output doesn't need
+// all events in memory. The writer must not keep the events, the decoder reuses them. Flush writes what the format
+// needs after the last event and flushes the buffered output, it must be called once all events are written.
+type EventWriter interface {
+	WriteEvent(e *auditv1.Event) error
+	Flush() error
+}
+
+// auditLogWriter writes the events as the API server does, one JSON event per line.
+type auditLogWriter struct {
+	out *bufio.Writer
+}
+
+func NewAuditLogWriter(w io.Writer) EventWriter {
+	return &auditLogWriter{out: bufio.NewWriter(w)}
+}
+
+func (w *auditLogWriter) WriteEvent(e *auditv1.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	// bufio.Writer keeps the first error, it is returned by Flush
+	w.out.Write(data)
+	w.out.WriteByte('\n')
+	return nil
+}
+
+func (w *auditLogWriter) Flush() error {
+	return w.out.Flush()
+}
+
+// ndjsonEvent is an event with the audit file it was read from, written by -o ndjson with the envelope.
+type ndjsonEvent struct {
+	Cluster   string         `json:"cluster,omitempty"`
+	Node      string         `json:"node"`
+	File      string         `json:"file"`
+	Line      int            `json:"line"`
+	Component string         `json:"component"`
+	Event     *auditv1.Event `json:"event"`
+}
+
+// ndjsonWriter writes one JSON event per line without buffering, every event is written as soon as it is matched. The
+// writes block while the reader of a pipe doesn't keep up, so a slow consumer slows the query down instead of the
+// events piling up in memory.
+type ndjsonWriter struct {
+	out      io.Writer
+	envelope bool
+}
+
+func newNDJSONWriter(w io.Writer, envelope bool) EventWriter {
+	return &ndjsonWriter{out: w, envelope: envelope}
+}
+
+func (w *ndjsonWriter) WriteEvent(e *auditv1.Event) error {
+	var value interface{} = e
+	if w.envelope {
+		origin, _ := provenance.Get(e)
+		value = &ndjsonEvent{Cluster: origin.Cluster, Node: origin.Node, File: origin.File, Line: origin.Line, Component: origin.Component, Event: e}
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = w.out.Write(append(data, '\n'))
+	return err
+}
+
+func (w *ndjsonWriter) Flush() error {
+	return nil
+}
+
+// eventListWriter writes the events as the items of an EventList.
+type eventListWriter struct {
+	out    *bufio.Writer
+	events int
+}
+
+func NewEventListWriter(w io.Writer) EventWriter {
+	out := bufio.NewWriter(w)
+	out.WriteString(`{"kind":"EventList","apiVersion":"audit.k8s.io/v1","metadata":{},"items":[`)
+	return &eventListWriter{out: out}
+}
+
+func (w *eventListWriter) WriteEvent(e *auditv1.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if w.events > 0 {
+		w.out.WriteByte(',')
+	}
+	w.out.WriteByte('\n')
+	w.out.Write(data)
+	w.events++
+	return nil
+}
+
+func (w *eventListWriter) Flush() error {
+	w.out.WriteString("\n]}\n")
+	return w.out.Flush()
+}
+
+// csvColumns are the columns of the csv output, in order.
+var csvColumns = []struct {
+	name  string
+	value func(e *auditv1.Event) string
+}{
+	{"requestReceivedTimestamp", func(e *auditv1.Event) string { return e.RequestReceivedTimestamp.UTC().Format(time.RFC3339Nano) }},
+	{"stageTimestamp", func(e *auditv1.Event) string { return e.StageTimestamp.UTC().Format(time.RFC3339Nano) }},
+	{"latency", func(e *auditv1.Event) string { return e.StageTimestamp.Sub(e.RequestReceivedTimestamp.Time).String() }},
+	{"auditID", func(e *auditv1.Event) string { return string(e.AuditID) }},
+	{"cluster", provenance.Cluster},
+	{"stage", func(e *auditv1.Event) string { return string(e.Stage) }},
+	{"verb", func(e *auditv1.Event) string { return e.Verb }},
+	{"code", func(e *auditv1.Event) string {
+		if e.ResponseStatus == nil {
+			return ""
+		}
+		return strconv.Itoa(int(e.ResponseStatus.Code))
+	}},
+	{"user", func(e *auditv1.Event) string { return e.User.Username }},
+	{"userAgent", func(e *auditv1.Event) string { return e.UserAgent }},
+	{"sourceIPs", func(e *auditv1.Event) string { return strings.Join(e.SourceIPs, " ") }},
+	{"namespace", func(e *auditv1.Event) string {
+		if e.ObjectRef == nil {
+			return ""
+		}
+		return e.ObjectRef.Namespace
+	}},
+	{"resource", func(e *auditv1.Event) string {
+		if e.ObjectRef == nil {
+			return ""
+		}
+		if len(e.ObjectRef.APIGroup) > 0 {
+			return e.ObjectRef.Resource + "." + e.ObjectRef.APIGroup
+		}
+		return e.ObjectRef.Resource
+	}},
+	{"name", func(e *auditv1.Event) string {
+		if e.ObjectRef == nil {
+			return ""
+		}
+		return e.ObjectRef.Name
+	}},
+	{"requestURI", func(e *auditv1.Event) string { return e.RequestURI }},
+}
+
+// csvEventWriter writes a row per event below a header row.
+type csvEventWriter struct {
+	out *csv.Writer
+	row []string
+}
+
+// newCSVWriter returns the csv writer, without the header row unless header is set.
+func newCSVWriter(w io.Writer, header bool) EventWriter {
+	writer := &csvEventWriter{out: csv.NewWriter(w), row: make([]string, len(csvColumns))}
+	if header {
+		for i, column := range csvColumns {
+			writer.row[i] = column.name
+		}
+		writer.out.Write(writer.row)
+	}
+	return writer
+}
+
+func (w *csvEventWriter) WriteEvent(e *auditv1.Event) error {
+	for i, column := range csvColumns {
+		w.row[i] = column.value(e)
+	}
+	// csv.Writer keeps the first error, it is returned by Flush
+	w.out.Write(w.row)
+	return nil
+}
+
+func (w *csvEventWriter) Flush() error {
+	w.out.Flush()
+	return w.out.Error()
+}
+
+type openMetricsCountKey struct {
+	user string
+	verb string
+	code string
+}
+
+// openMetricsCountWriter counts the events by user, verb and code and writes the counters on Flush. Its memory grows
+// with the number of distinct series, not with the number of events.
+type openMetricsCountWriter struct {
+	out    io.Writer
+	counts map[openMetricsCountKey]int
+}
+
+func NewOpenMetricsCountWriter(w io.Writer) EventWriter {
+	return &openMetricsCountWriter{out: w, counts: map[openMetricsCountKey]int{}}
+}
+
+func (w *openMetricsCountWriter) WriteEvent(e *auditv1.Event) error {
+	w.counts[openMetricsCountKey{user: e.User.Username, verb: e.Verb, code: responseCode(e)}]++
+	return nil
+}
+
+func (w *openMetricsCountWriter) Flush() error {
+	keys := make([]openMetricsCountKey, 0, len(w.counts))
+	for key := range w.counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].user != keys[j].user {
+			return keys[i].user < keys[j].user
+		}
+		if keys[i].verb != keys[j].verb {
+			return keys[i].verb < keys[j].verb
+		}
+		return keys[i].code < keys[j].code
+	})
+
+	out := bufio.NewWriter(w.out)
+	fmt.Fprintf(out, "# TYPE audit_event_total counter\n")
+	for _, key := range keys {
+		fmt.Fprintf(out, "audit_event_total{user=\"%s\",verb=\"%s\",code=\"%s\"} %d\n", key.user, key.verb, key.code, w.counts[key])
+	}
+	return out.Flush()
+}
+
+// openMetricsTimeWriter writes a sample per event at the time the request was received.
+type openMetricsTimeWriter struct {
+	out *bufio.Writer
+}
+
+func newOpenMetricsTimeWriter(w io.Writer) EventWriter {
+	out := bufio.NewWriter(w)
+	fmt.Fprintln(out, "# TYPE audit_event_timestamp gauge")
+	return &openMetricsTimeWriter{out: out}
+}
+
+func (w *openMetricsTimeWriter) WriteEvent(e *auditv1.Event) error {
+	fmt.Fprintf(w.out, "audit_event_timestamp{user=\"%s\",verb=\"%s\",code=\"%s\"} 1 %d\n", e.User.Username, e.Verb, responseCode(e), e.RequestReceivedTimestamp.Time.UnixMilli())
+	return nil
+}
+
+func (w *openMetricsTimeWriter) Flush() error {
+	fmt.Fprintln(w.out, "# EOF")
+	return w.out.Flush()
+}
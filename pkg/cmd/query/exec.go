@@ -0,0 +1,139 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/sets"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/filter"
+)
+
+var ExecSubresources = sets.NewString("exec", "attach", "portforward")
+
+type ExecOptions struct {
+	// queryOptions selects and filters the events to report
+	queryOptions Options
+
+	genericclioptions.IOStreams
+}
+
+func NewExecCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &ExecOptions{IOStreams: streams, queryOptions: Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "exec --dir DIR",
+		Short: "Report who exec'd, attached or port-forwarded into which pod and container, from where and when",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	cmd.Flags().StringVar(&options.queryOptions.from, "from", "", "Only report events starting at this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().StringVar(&options.queryOptions.to, "to", "", "Only report events before this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().IntVar(&options.queryOptions.maxEventSize, "max-event-size", defaultMaxEventSize, "Maximum size in bytes of a single audit event.")
+	cmd.Flags().Int64Var(&options.queryOptions.limit, "limit", 0, "Limit the amount of sessions to display.")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *ExecOptions) Validate() error {
+	if len(o.queryOptions.targetDirectories) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	return nil
+}
+
+// execSession is a single exec, attach or portforward request, it is logged once per audit stage.
+type execSession struct {
+	received  time.Time
+	kind      string
+	user      string
+	sourceIP  string
+	namespace string
+	pod       string
+	container string
+	tty       bool
+	command   string
+	code      int32
+}
+
+func (o *ExecOptions) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+
+	sessions := map[string]*execSession{}
+	if err := o.queryOptions.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			ns, _, name, subresource := filter.URIToParts(e.RequestURI)
+			if !ExecSubresources.Has(subresource) {
+				continue
+			}
+			session, ok := sessions[string(e.AuditID)]
+			if !ok {
+				query := filter.ParseURIQuery(e.RequestURI)
+				session = &execSession{
+					received:  e.RequestReceivedTimestamp.Time,
+					kind:      subresource,
+					user:      e.User.Username,
+					namespace: ns,
+					pod:       name,
+					container: query.Values.Get("container"),
+					command:   strings.Join(query.Values["command"], " "),
+				}
+				session.tty, _ = strconv.ParseBool(query.Values.Get("tty"))
+				if subresource == "portforward" {
+					session.command = strings.Join(query.Values["ports"], ",")
+				}
+				if len(e.SourceIPs) > 0 {
+					session.sourceIP = e.SourceIPs[0]
+				}
+				sessions[string(e.AuditID)] = session
+			}
+			if e.ResponseStatus != nil {
+				session.code = e.ResponseStatus.Code
+			}
+		}
+	}); err != nil {
+		return err
+	}
+
+	result := []*execSession{}
+	for _, session := range sessions {
+		result = append(result, session)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].received.After(result[j].received)
+	})
+
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "TIME\tTYPE\tUSER\tSOURCE IP\tNAMESPACE\tPOD\tCONTAINER\tTTY\tCOMMAND/PORTS\tCODE")
+	for i, session := range result {
+		if o.queryOptions.limit > 0 && int64(i) >= o.queryOptions.limit {
+			break
+		}
+		code := "-"
+		if session.code != 0 {
+			code = strconv.Itoa(int(session.code))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%t\t%s\t%s\n", session.received.Format(TimeDefaultFormat), session.kind, session.user,
+			session.sourceIP, session.namespace, session.pod, MatrixKey(session.container), session.tty, session.command, code)
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+
+	"github.com/natamm4/audit-tool/pkg/audit/provenance"
+	"github.com/natamm4/audit-tool/pkg/audit/runstats"
+)
+
+// Exit codes of the query command, wrappers tell a query without results or with partial results from a failure by
+// them instead of parsing the messages.
+const (
+	// ExitMatched is returned when the query succeeded and events matched it
+	ExitMatched = 0
+	// ExitError is returned when the query failed, eg. an audit file couldn't be read
+	ExitError = 1
+	// ExitUsage is returned when the flags of the query are invalid
+	ExitUsage = 2
+	// ExitNoMatches is returned when the query succeeded but no event matched it
+	ExitNoMatches = 3
+	// ExitPartialData is returned when the query succeeded but lines of the audit files couldn't be decoded, the
+	// results miss their events
+	ExitPartialData = 4
+
+	errorFormatText = "text"
+	errorFormatJSON = "json"
+)
+
+var exitReasons = map[int]string{
+	ExitMatched:     "Matched",
+	ExitError:       "Error",
+	ExitUsage:       "Usage",
+	ExitNoMatches:   "NoMatches",
+	ExitPartialData: "PartialData",
+}
+
+// queryStatus is the outcome of a query, shared by the copies of its Options.
+type queryStatus struct {
+	// visited is set once the events were read, queries like --stats don't match events
+	visited bool
+	matched int64
+
+	lock sync.Mutex
+	// nodes counts the matching events by the node they were read from
+	nodes map[string]int64
+}
+
+// count wraps visit to count the events matching the query.
+func (s *queryStatus) count(visit func([]*auditv1.Event)) func([]*auditv1.Event) {
+	if s == nil {
+		return visit
+	}
+	s.visited = true
+	return func(events []*auditv1.Event) {
+		s.countNodes(events)
+		visit(events)
+	}
+}
+
+// add counts the events matching the query that were decoded at once.
+func (s *queryStatus) add(events []*auditv1.Event) {
+	if s == nil {
+		return
+	}
+	s.visited = true
+	s.countNodes(events)
+}
+
+func (s *queryStatus) countNodes(events []*auditv1.Event) {
+	atomic.AddInt64(&s.matched, int64(len(events)))
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.nodes == nil {
+		s.nodes = map[string]int64{}
+	}
+	for _, e := range events {
+		s.nodes[provenance.Node(e)]++
+	}
+}
+
+// nodeCounts returns the matching events by node.
+func (s *queryStatus) nodeCounts() map[string]int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	counts := make(map[string]int64, len(s.nodes))
+	for node, count := range s.nodes {
+		counts[node] = count
+	}
+	return counts
+}
+
+// errorReport is written to stderr with --error-format json.
+type errorReport struct {
+	Code   int    `json:"code"`
+	Reason string `json:"reason"`
+	Error  string `json:"error,omitempty"`
+	// CorruptLines are the lines skipped because they couldn't be decoded
+	CorruptLines int64 `json:"corruptLines,omitempty"`
+}
+
+func validateErrorFormat(format string) error {
+	switch format {
+	case errorFormatText, errorFormatJSON:
+		return nil
+	}
+	return fmt.Errorf("invalid --error-format %q, must be %s or %s", format, errorFormatText, errorFormatJSON)
+}
+
+// AddErrorFormatFlag adds the --error-format flag of the query command and of the commands reading audit files like it,
+// all of them exit with the same codes. Invalid flags exit with ExitUsage too.
+func (o *Options) AddErrorFormatFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&o.errorFormat, "error-format", errorFormatText, "Format of the errors written to stderr (text, json). The exit code is 0 when events matched the query, 1 when it failed, 2 when its flags are invalid, 3 when no event matched and 4 when lines of the audit files could not be decoded and the results are partial.")
+	cmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		report := *o
+		if !cmd.Flags().Changed("error-format") {
+			report.errorFormat = errorFormatFromArgs(os.Args[1:])
+		}
+		if validateErrorFormat(report.errorFormat) != nil {
+			report.errorFormat = errorFormatText
+		}
+		report.exitWith(ExitUsage, fmt.Errorf("%v\nSee '%s -h' for help and examples", err, cmd.CommandPath()))
+		return err
+	})
+}
+
+// errorFormatFromArgs returns the --error-format of the command line, the parsing stops at the invalid flag before
+// reaching it.
+func errorFormatFromArgs(args []string) string {
+	flags := pflag.NewFlagSet("error-format", pflag.ContinueOnError)
+	flags.ParseErrorsWhitelist.UnknownFlags = true
+	flags.SetOutput(io.Discard)
+	format := flags.String("error-format", errorFormatText, "")
+	_ = flags.Parse(args)
+	return *format
+}
+
+// CheckUsage exits with ExitUsage when err is set, or when --error-format, --from or --to is invalid.
+func (o Options) CheckUsage(err error) {
+	if err == nil {
+		err = validateErrorFormat(o.errorFormat)
+	}
+	if err == nil {
+		err = validateTimeRange(o.from, o.to)
+	}
+	if err != nil {
+		o.exitWith(ExitUsage, err)
+	}
+}
+
+// CheckErr exits with ExitError when err is set.
+func (o Options) CheckErr(err error) {
+	if err != nil {
+		o.exitWith(ExitError, err)
+	}
+}
+
+// exit exits with the exit code of the outcome of the query: ExitError when err is set, ExitPartialData when lines of
+// the audit files were skipped, ExitNoMatches when no event matched and ExitMatched otherwise.
+func (o Options) Exit(err error) {
+	if err != nil {
+		o.exitWith(ExitError, err)
+	}
+	if skipped := runstats.Current().DecodeErrors; skipped > 0 {
+		o.report(errorReport{
+			Code:         ExitPartialData,
+			Error:        fmt.Sprintf("%d lines of the audit files could not be decoded, the results are partial", skipped),
+			CorruptLines: skipped,
+		})
+		terminate(ExitPartialData)
+	}
+	if o.status != nil && o.status.visited && atomic.LoadInt64(&o.status.matched) == 0 {
+		if o.errorFormat == errorFormatJSON {
+			o.report(errorReport{Code: ExitNoMatches, Error: "no events matched the query"})
+		}
+		terminate(ExitNoMatches)
+	}
+}
+
+func (o Options) exitWith(code int, err error) {
+	o.report(errorReport{Code: code, Error: err.Error()})
+	terminate(code)
+}
+
+// terminate exits with the code once the counters of the run are written for the daemon.
+func terminate(code int) {
+	if err := runstats.Write(); err != nil {
+		log.Printf("unable to write the counters of the run: %v", err)
+	}
+	os.Exit(code)
+}
+
+// report writes the report to stderr, as a JSON object with --error-format json. The text format matches the errors of
+// the other commands, prefixed with error: or warning: for partial results.
+func (o Options) report(r errorReport) {
+	r.Reason = exitReasons[r.Code]
+	if o.errorFormat == errorFormatJSON {
+		if b, err := json.Marshal(r); err == nil {
+			fmt.Fprintln(o.ErrOut, string(b))
+			return
+		}
+	}
+	prefix := "error: "
+	if r.Code == ExitPartialData {
+		prefix = "warning: "
+	}
+	if strings.HasPrefix(r.Error, prefix) {
+		prefix = ""
+	}
+	fmt.Fprintln(o.ErrOut, prefix+r.Error)
+}
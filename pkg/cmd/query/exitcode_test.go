@@ -0,0 +1,138 @@
+package query
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/natamm4/audit-tool/pkg/audit/runstats"
+)
+
+// exitTestEnv names the case TestExitCodes runs in a child process, the exit functions end the process.
+const exitTestEnv = "AUDIT_TOOL_EXIT_TEST"
+
+func TestExitCodes(t *testing.T) {
+	matched := func() *queryStatus { return &queryStatus{visited: true, matched: 1} }
+	tests := []struct {
+		name        string
+		errorFormat string
+		run         func(o Options)
+		code        int
+		stderr      string
+	}{
+		{name: "matched", run: func(o Options) {
+			o.status = matched()
+			o.Exit(nil)
+		}, code: ExitMatched},
+		// queries like --stats don't visit events
+		{name: "not visited", run: func(o Options) {
+			o.status = &queryStatus{}
+			o.Exit(nil)
+		}, code: ExitMatched},
+		{name: "error", run: func(o Options) { o.Exit(fmt.Errorf("unable to read audit.log")) }, code: ExitError, stderr: "error: unable to read audit.log\n"},
+		{name: "error json", errorFormat: errorFormatJSON, run: func(o Options) { o.CheckErr(fmt.Errorf("unable to read audit.log")) }, code: ExitError,
+			stderr: `{"code":1,"reason":"Error","error":"unable to read audit.log"}` + "\n"},
+		{name: "usage", run: func(o Options) { o.CheckUsage(fmt.Errorf("invalid --by")) }, code: ExitUsage, stderr: "error: invalid --by\n"},
+		{name: "usage json", errorFormat: errorFormatJSON, run: func(o Options) { o.CheckUsage(fmt.Errorf("invalid --by")) }, code: ExitUsage,
+			stderr: `{"code":2,"reason":"Usage","error":"invalid --by"}` + "\n"},
+		{name: "valid usage", run: func(o Options) { o.CheckUsage(nil) }, code: ExitMatched},
+		{name: "invalid error format", errorFormat: "yaml", run: func(o Options) { o.CheckUsage(nil) }, code: ExitUsage,
+			stderr: `error: invalid --error-format "yaml", must be text or json` + "\n"},
+		{name: "invalid from", run: func(o Options) {
+			o.from = "yesterday"
+			o.CheckUsage(nil)
+		}, code: ExitUsage, stderr: `error: invalid --from "yesterday", use "2006-01-02 15:04:05"` + "\n"},
+		{name: "from after to", run: func(o Options) {
+			o.from, o.to = "2026-10-02 00:00:00", "2026-10-01 00:00:00"
+			o.CheckUsage(nil)
+		}, code: ExitUsage, stderr: `error: --from "2026-10-02 00:00:00" must not be after --to "2026-10-01 00:00:00"` + "\n"},
+		{name: "invalid flag", run: func(o Options) {
+			cmd := &cobra.Command{Use: "query", Run: func(*cobra.Command, []string) {}}
+			o.AddErrorFormatFlag(cmd)
+			cmd.SetArgs([]string{"--bogus"})
+			_ = cmd.Execute()
+		}, code: ExitUsage, stderr: "error: unknown flag: --bogus\nSee 'query -h' for help and examples\n"},
+		{name: "no matches", run: func(o Options) {
+			o.status = &queryStatus{visited: true}
+			o.Exit(nil)
+		}, code: ExitNoMatches},
+		{name: "no matches json", errorFormat: errorFormatJSON, run: func(o Options) {
+			o.status = &queryStatus{visited: true}
+			o.Exit(nil)
+		}, code: ExitNoMatches, stderr: `{"code":3,"reason":"NoMatches","error":"no events matched the query"}` + "\n"},
+		{name: "partial data", run: func(o Options) {
+			runstats.AddDecodeError()
+			o.status = matched()
+			o.Exit(nil)
+		}, code: ExitPartialData, stderr: "warning: 1 lines of the audit files could not be decoded, the results are partial\n"},
+		{name: "partial data json", errorFormat: errorFormatJSON, run: func(o Options) {
+			runstats.AddDecodeError()
+			o.status = matched()
+			o.Exit(nil)
+		}, code: ExitPartialData, stderr: `{"code":4,"reason":"PartialData","error":"1 lines of the audit files could not be decoded, the results are partial","corruptLines":1}` + "\n"},
+		// a failure is reported over partial data
+		{name: "error with partial data", run: func(o Options) {
+			runstats.AddDecodeError()
+			o.Exit(fmt.Errorf("unable to read audit.log"))
+		}, code: ExitError, stderr: "error: unable to read audit.log\n"},
+	}
+
+	if name := os.Getenv(exitTestEnv); len(name) > 0 {
+		for _, test := range tests {
+			if test.name == name {
+				o := Options{IOStreams: genericclioptions.IOStreams{In: os.Stdin, Out: os.Stdout, ErrOut: os.Stderr}, errorFormat: errorFormatText}
+				if len(test.errorFormat) > 0 {
+					o.errorFormat = test.errorFormat
+				}
+				test.run(o)
+			}
+		}
+		return
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], "-test.run=^TestExitCodes$")
+			cmd.Env = append(os.Environ(), exitTestEnv+"="+test.name)
+			stderr := &bytes.Buffer{}
+			cmd.Stderr = stderr
+			err := cmd.Run()
+			code := 0
+			if exitErr := (&exec.ExitError{}); errors.As(err, &exitErr) {
+				code = exitErr.ExitCode()
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if code != test.code {
+				t.Errorf("expected the exit code %d, got %d: %s", test.code, code, stderr)
+			}
+			if stderr.String() != test.stderr {
+				t.Errorf("expected the errors %q, got %q", test.stderr, stderr)
+			}
+		})
+	}
+}
+
+func TestErrorFormatFromArgs(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{args: []string{"query", "--dir", "dump"}, expected: errorFormatText},
+		{args: []string{"query", "--error-format", "json", "--bogus"}, expected: errorFormatJSON},
+		{args: []string{"query", "--error-format=json", "-o", "top"}, expected: errorFormatJSON},
+		// unknown flags are skipped, the parsing stops at the invalid value
+		{args: []string{"query", "--unknown", "--error-format", "json"}, expected: errorFormatJSON},
+		{args: []string{"query", "--error-format"}, expected: errorFormatText},
+	}
+	for _, test := range tests {
+		if actual := errorFormatFromArgs(test.args); actual != test.expected {
+			t.Errorf("%v: expected %q, got %q", test.args, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+
+	"github.com/natamm4/audit-tool/pkg/audit/filter"
+	"github.com/natamm4/audit-tool/pkg/audit/topk"
+)
+
+// validateFirstLastBy checks the comma separated --by dimensions of -o firstlast (eg. user,verb,resource), or the
+// --group-by dimensions replacing them.
+func validateFirstLastBy(by string, groupBy []string) error {
+	if len(groupBy) > 0 {
+		return validateGroupBy(groupBy, by)
+	}
+	if len(by) == 0 {
+		return fmt.Errorf("-o firstlast requires --group-by with one or more of %s (eg. user,verb,resource)", strings.Join(topDimensions(), ", "))
+	}
+	for _, dimension := range strings.Split(by, ",") {
+		if _, ok := topKeyFuncs[dimension]; !ok {
+			return fmt.Errorf("invalid --by value %q, must be one of %s", dimension, strings.Join(topDimensions(), ", "))
+		}
+	}
+	return nil
+}
+
+// occurrence is the first and last time events with the same key were received and how many there were.
+type occurrence struct {
+	key   []string
+	first time.Time
+	last  time.Time
+	count int64
+}
+
+// occurrences tracks the first and last occurrence per key, keyed by the joined key values.
+type occurrences map[string]*occurrence
+
+func (o occurrences) add(key []string, received time.Time) {
+	id := strings.Join(key, "\x00")
+	seen, ok := o[id]
+	if !ok {
+		o[id] = &occurrence{key: key, first: received, last: received, count: 1}
+		return
+	}
+	if received.Before(seen.first) {
+		seen.first = received
+	}
+	if received.After(seen.last) {
+		seen.last = received
+	}
+	seen.count++
+}
+
+// sorted returns the occurrences ordered by their first occurrence, the earliest first.
+func (o occurrences) sorted() []*occurrence {
+	result := make([]*occurrence, 0, len(o))
+	for _, seen := range o {
+		result = append(result, seen)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].first.Equal(result[j].first) {
+			return result[i].first.Before(result[j].first)
+		}
+		return strings.Join(result[i].key, ",") < strings.Join(result[j].key, ",")
+	})
+	return result
+}
+
+// runFirstLast reports when the events of every key were received first and last in a single pass without keeping
+// the events in memory, answering when a client started or stopped doing something.
+func (o Options) runFirstLast(ctx context.Context, filters filter.AuditFilters) error {
+	dimensions := o.groupingDimensions()
+	keyFunc := groupKeyFunc(dimensions)
+
+	seen := occurrences{}
+	var rates *aggregateRates
+	if o.showRates {
+		// every key is kept anyway, so are their failed requests
+		rates = newAggregateRates(topk.NewExact(), topk.NewExact())
+	}
+	var series *sparklineSeries
+	if o.sparkline {
+		series = newSparklineSeries(o.sparklineBucket, o.partialBuckets)
+	}
+	if err := o.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			key := keyFunc(e)
+			seen.add(key, e.RequestReceivedTimestamp.Time)
+			if rates != nil {
+				rates.add(e, strings.Join(key, "\x00"))
+			}
+			if series != nil {
+				series.add(e, strings.Join(key, "\x00"))
+			}
+		}
+	}); err != nil {
+		return err
+	}
+	if rates != nil {
+		rates.complete(o.from, o.to)
+	}
+	if series != nil {
+		if err := series.complete(o.from, o.to); err != nil {
+			return err
+		}
+	}
+
+	printFirstLast(o.Out, seen.sorted(), dimensions, int(o.limit), rates, series)
+	return nil
+}
+
+// printFirstLast prints a row per key, limit caps the number of rows when positive. The rate columns are added when
+// rates is set and the sparkline when series is.
+func printFirstLast(writer io.Writer, seen []*occurrence, dimensions []string, limit int, rates *aggregateRates, series *sparklineSeries) {
+	w := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	header := []string{"FIRST", "LAST", "COUNT"}
+	for _, dimension := range dimensions {
+		header = append(header, strings.ToUpper(dimension))
+	}
+	if rates != nil {
+		header = append(header, aggregateRateHeader()...)
+	}
+	if series != nil {
+		header = append(header, series.header())
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+
+	for i, s := range seen {
+		if limit > 0 && i >= limit {
+			break
+		}
+		line := []string{s.first.Format(TimeDefaultFormat), s.last.Format(TimeDefaultFormat), fmt.Sprintf("%d", s.count)}
+		for _, key := range s.key {
+			line = append(line, MatrixKey(key))
+		}
+		if rates != nil {
+			line = append(line, rates.columns(rates.counts(strings.Join(s.key, "\x00")))...)
+		}
+		if series != nil {
+			line = append(line, sparkline(series.columns(strings.Join(s.key, "\x00"))))
+		}
+		fmt.Fprintln(w, strings.Join(line, "\t"))
+	}
+}
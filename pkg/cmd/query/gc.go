@@ -0,0 +1,204 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/filter"
+)
+
+type GCOptions struct {
+	limit int
+
+	// queryOptions selects and filters the events to analyze
+	queryOptions Options
+
+	genericclioptions.IOStreams
+}
+
+func NewGCCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &GCOptions{IOStreams: streams, queryOptions: Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "gc --dir DIR",
+		Short: "Report the garbage collection and finalizer activity per namespace and client",
+		Long: "Report the garbage collection and finalizer activity per namespace and client: patches that only modify the\n" +
+			"finalizers or the ownerReferences of an object, deletecollection requests, and the deletes of the garbage\n" +
+			"collector (" + filter.GarbageCollectorUser + ").\n\n" +
+			"Patches are classified by their request object, which is only recorded at the Request level and above, the\n" +
+			"patches without it are counted as unclassified. Updates send the whole object, so the updates only\n" +
+			"modifying finalizers can't be told apart from other updates.",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	cmd.Flags().StringVar(&options.queryOptions.from, "from", "", "Only analyze events starting at this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().StringVar(&options.queryOptions.to, "to", "", "Only analyze events before this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().IntVar(&options.queryOptions.maxEventSize, "max-event-size", defaultMaxEventSize, "Maximum size in bytes of a single audit event.")
+	cmd.Flags().IntVar(&options.limit, "limit", 20, "Number of namespaces and clients with the most activity to display.")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *GCOptions) Validate() error {
+	if len(o.queryOptions.targetDirectories) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	return nil
+}
+
+// gcStats counts the garbage collection activity of a namespace or a client.
+type gcStats struct {
+	key               string
+	finalizerPatches  int64
+	ownerPatches      int64
+	deleteCollections int64
+	gcDeletes         int64
+	// requests counts every request once, also the patches modifying both lists
+	requests int64
+	// clients are the users sending the requests counted, only tracked per namespace
+	clients map[string]int64
+}
+
+// topClient returns the client sending the most requests and its share of the requests.
+func (s *gcStats) topClient() string {
+	top, count := "", int64(0)
+	for client, n := range s.clients {
+		if n > count || n == count && client < top {
+			top, count = client, n
+		}
+	}
+	if count == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s (%d%%)", top, count*100/s.requests)
+}
+
+func (o *GCOptions) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+
+	namespaces := map[string]*gcStats{}
+	clients := map[string]*gcStats{}
+	var unclassified int64
+	if err := o.queryOptions.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			if e.Stage != auditv1.StageResponseComplete {
+				continue
+			}
+			var finalizers, owners, deleteCollection, gcDelete bool
+			switch e.Verb {
+			case "patch":
+				if e.RequestObject == nil {
+					unclassified++
+					continue
+				}
+				finalizers, owners = filter.FinalizerOwnerPatch(e)
+			case "deletecollection":
+				deleteCollection = true
+			case "delete":
+				gcDelete = e.User.Username == filter.GarbageCollectorUser
+			}
+			if !finalizers && !owners && !deleteCollection && !gcDelete {
+				continue
+			}
+
+			namespace := ""
+			if e.ObjectRef != nil {
+				namespace = e.ObjectRef.Namespace
+			}
+			n, ok := namespaces[namespace]
+			if !ok {
+				n = &gcStats{key: namespace, clients: map[string]int64{}}
+				namespaces[namespace] = n
+			}
+			c, ok := clients[e.User.Username]
+			if !ok {
+				c = &gcStats{key: e.User.Username}
+				clients[e.User.Username] = c
+			}
+			n.clients[e.User.Username]++
+			for _, s := range []*gcStats{n, c} {
+				s.requests++
+				// a patch modifying both lists counts as both
+				if finalizers {
+					s.finalizerPatches++
+				}
+				if owners {
+					s.ownerPatches++
+				}
+				if deleteCollection {
+					s.deleteCollections++
+				}
+				if gcDelete {
+					s.gcDeletes++
+				}
+			}
+		}
+	}); err != nil {
+		return err
+	}
+
+	if len(namespaces) == 0 {
+		fmt.Fprintln(o.Out, "No garbage collection or finalizer activity found.")
+	} else {
+		w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAMESPACE\tFINALIZER PATCHES\tOWNERREF PATCHES\tDELETECOLLECTIONS\tGC DELETES\tTOP CLIENT")
+		for i, s := range sortedGCStats(namespaces) {
+			if o.limit > 0 && i >= o.limit {
+				break
+			}
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\n", MatrixKey(s.key), s.finalizerPatches, s.ownerPatches, s.deleteCollections, s.gcDeletes, s.topClient())
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		fmt.Fprintln(o.Out)
+		fmt.Fprintln(o.Out, "Clients:")
+		w = tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "USER\tFINALIZER PATCHES\tOWNERREF PATCHES\tDELETECOLLECTIONS\tGC DELETES")
+		for i, s := range sortedGCStats(clients) {
+			if o.limit > 0 && i >= o.limit {
+				break
+			}
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\n", s.key, s.finalizerPatches, s.ownerPatches, s.deleteCollections, s.gcDeletes)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if unclassified > 0 {
+		fmt.Fprintf(o.ErrOut, "%d patches were logged without their request object and couldn't be classified\n", unclassified)
+	}
+	return nil
+}
+
+func sortedGCStats(stats map[string]*gcStats) []*gcStats {
+	result := make([]*gcStats, 0, len(stats))
+	for _, s := range stats {
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].requests != result[j].requests {
+			return result[i].requests > result[j].requests
+		}
+		return result[i].key < result[j].key
+	})
+	return result
+}
@@ -0,0 +1,321 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+
+	"github.com/natamm4/audit-tool/pkg/audit/filter"
+	"github.com/natamm4/audit-tool/pkg/audit/topk"
+)
+
+const (
+	groupByLayoutFlat   = "flat"
+	groupByLayoutNested = "nested"
+)
+
+// validateGroupBy checks the --group-by dimensions, they replace the single --by dimension of -o top and -o firstlast.
+func validateGroupBy(groupBy []string, by string) error {
+	if len(groupBy) == 0 {
+		return nil
+	}
+	if len(by) > 0 {
+		return fmt.Errorf("--by and --group-by are mutually exclusive")
+	}
+	seen := sets.NewString()
+	for _, dimension := range groupBy {
+		if _, ok := topKeyFuncs[dimension]; !ok {
+			return fmt.Errorf("invalid --group-by value %q, must be one of %s", dimension, strings.Join(topDimensions(), ", "))
+		}
+		if seen.Has(dimension) {
+			return fmt.Errorf("--group-by dimension %q is given twice", dimension)
+		}
+		seen.Insert(dimension)
+	}
+	return nil
+}
+
+// groupByLayout returns the layout of the -o top table from --output-flags layout=flat|nested.
+func groupByLayout(options PrinterOptions) (string, error) {
+	layout, ok := options.Flags["layout"]
+	if !ok {
+		return groupByLayoutFlat, nil
+	}
+	if len(options.query.groupBy) == 0 {
+		return "", fmt.Errorf("--output-flags layout requires --group-by")
+	}
+	switch layout {
+	case groupByLayoutFlat, groupByLayoutNested:
+		return layout, nil
+	}
+	return "", fmt.Errorf("invalid --output-flags layout=%s, must be %s or %s", layout, groupByLayoutFlat, groupByLayoutNested)
+}
+
+// groupingDimensions returns the dimensions of the key events are grouped under, from --group-by or the comma
+// separated --by.
+func (o Options) groupingDimensions() []string {
+	if len(o.groupBy) > 0 {
+		return o.groupBy
+	}
+	return strings.Split(o.topBy, ",")
+}
+
+// groupKeyFunc returns the function joining the values of the dimensions of an event into its key.
+func groupKeyFunc(dimensions []string) func(e *auditv1.Event) []string {
+	keyFuncs := make([]func(e *auditv1.Event) string, 0, len(dimensions))
+	for _, dimension := range dimensions {
+		keyFuncs = append(keyFuncs, topKeyFuncs[dimension])
+	}
+	return func(e *auditv1.Event) []string {
+		key := make([]string, 0, len(keyFuncs))
+		for _, keyFunc := range keyFuncs {
+			key = append(key, keyFunc(e))
+		}
+		return key
+	}
+}
+
+// groupLevel counts the events per key like runTop does, with the rates and sparklines asked for.
+type groupLevel struct {
+	counter topk.Counter
+	rates   *aggregateRates
+	series  *sparklineSeries
+}
+
+func (o Options) newGroupLevel() *groupLevel {
+	newCounter := func() topk.Counter {
+		if o.topExact {
+			return topk.NewExact()
+		}
+		return topk.NewSpaceSaving(o.topCapacity)
+	}
+	l := &groupLevel{counter: newCounter()}
+	if o.showRates {
+		l.rates = newAggregateRates(newCounter(), newCounter())
+	}
+	if o.sparkline {
+		l.series = newSparklineSeries(o.sparklineBucket, o.partialBuckets)
+	}
+	return l
+}
+
+func (l *groupLevel) add(e *auditv1.Event, key string) {
+	l.counter.Add(key)
+	if l.rates != nil {
+		l.rates.add(e, key)
+	}
+	if l.series != nil {
+		l.series.add(e, key)
+	}
+}
+
+// prune drops the sparklines of the keys the estimation no longer tracks, it keeps the heavy hitters only.
+func (l *groupLevel) prune(capacity int) {
+	if l.series != nil && len(l.series.counts) > 2*capacity {
+		l.series.prune(func(key string) bool {
+			_, ok := l.counter.Get(key)
+			return ok
+		})
+	}
+}
+
+func (l *groupLevel) complete(from, to string) error {
+	if l.rates != nil {
+		l.rates.complete(from, to)
+	}
+	if l.series != nil {
+		return l.series.complete(from, to)
+	}
+	return nil
+}
+
+// runGroupBy counts the events per combination of the --group-by dimensions like runTop counts them per key of a
+// single dimension. The nested layout also counts the values of the first dimensions on their own, levels[i] counts
+// the events per value of the first i+1 dimensions and the last level per combination.
+func (o Options) runGroupBy(ctx context.Context, filters filter.AuditFilters, layout string) error {
+	dimensions := o.groupingDimensions()
+	keyFunc := groupKeyFunc(dimensions)
+
+	levels := []*groupLevel{o.newGroupLevel()}
+	if layout == groupByLayoutNested {
+		for range dimensions[1:] {
+			levels = append(levels, o.newGroupLevel())
+		}
+	}
+	if err := o.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			values := keyFunc(e)
+			for i, level := range levels {
+				level.add(e, strings.Join(values[:len(values)-len(levels)+i+1], "\x00"))
+			}
+		}
+		for _, level := range levels {
+			level.prune(o.topCapacity)
+		}
+	}); err != nil {
+		return err
+	}
+	for _, level := range levels {
+		if err := level.complete(o.from, o.to); err != nil {
+			return err
+		}
+	}
+
+	count := int(o.limit)
+	if count <= 0 {
+		count = defaultTopCount
+	}
+	combinations := levels[len(levels)-1]
+	if layout == groupByLayoutNested {
+		printGroupByNested(o.Out, combinations.counter.Top(count), combinations.counter.Total(), dimensions, levels)
+	} else {
+		printGroupByFlat(o.Out, combinations.counter.Top(count), combinations.counter.Total(), dimensions, combinations.rates, combinations.series)
+	}
+	return nil
+}
+
+func countWithError(count, err int64) string {
+	if err > 0 {
+		return fmt.Sprintf("%d (±%d)", count, err)
+	}
+	return fmt.Sprintf("%d", count)
+}
+
+// printGroupByFlat prints a row per combination with a column per dimension, followed by the rate columns when
+// rates is set and the sparkline when series is.
+func printGroupByFlat(writer io.Writer, items []topk.Item, total int64, dimensions []string, rates *aggregateRates, series *sparklineSeries) {
+	w := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	header := []string{"COUNT"}
+	for _, dimension := range dimensions {
+		header = append(header, strings.ToUpper(dimension))
+	}
+	if rates != nil {
+		header = append(header, aggregateRateHeader()...)
+	}
+	if series != nil {
+		header = append(header, series.header())
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, item := range items {
+		line := []string{countWithError(item.Count, item.Error)}
+		for _, key := range strings.Split(item.Key, "\x00") {
+			line = append(line, MatrixKey(key))
+		}
+		if rates != nil {
+			line = append(line, rates.columns(rates.counts(item.Key))...)
+		}
+		if series != nil {
+			line = append(line, sparkline(series.columns(item.Key)))
+		}
+		fmt.Fprintln(w, strings.Join(line, "\t"))
+	}
+	fmt.Fprintf(w, "\nTotal: %d events\n", total)
+}
+
+// groupNode is a value of a dimension in the nested layout, with the counts of its level.
+type groupNode struct {
+	key      string
+	count    int64
+	err      int64
+	children []*groupNode
+
+	// requests and errors are the requests and failed requests of the value, unknown when the estimation dropped it
+	requests     int64
+	errors       int64
+	ratesUnknown bool
+	timeline     []float64
+}
+
+func (n *groupNode) child(key string) (*groupNode, bool) {
+	for _, c := range n.children {
+		if c.key == key {
+			return c, false
+		}
+	}
+	c := &groupNode{key: key}
+	n.children = append(n.children, c)
+	return c, true
+}
+
+func (n *groupNode) sort() {
+	sort.SliceStable(n.children, func(i, j int) bool {
+		return n.children[i].count > n.children[j].count
+	})
+	for _, c := range n.children {
+		c.sort()
+	}
+}
+
+// printGroupByNested prints the combinations as a tree, the first dimension at the top. The rows of the inner levels
+// print the counts of their level, so a value counts all its events, not only the combinations printed below it.
+func printGroupByNested(writer io.Writer, items []topk.Item, total int64, dimensions []string, levels []*groupLevel) {
+	root := &groupNode{}
+	for _, item := range items {
+		keys := strings.Split(item.Key, "\x00")
+		node := root
+		for i, key := range keys {
+			var created bool
+			node, created = node.child(key)
+			if !created {
+				continue
+			}
+			level, prefix := levels[i], strings.Join(keys[:i+1], "\x00")
+			if counted, ok := level.counter.Get(prefix); ok {
+				node.count, node.err = counted.Count, counted.Error
+			} else {
+				// the estimation dropped the value, its combinations printed below are all that is known
+				node.count, node.err = item.Count, item.Error
+			}
+			if level.rates != nil {
+				var known bool
+				node.requests, node.errors, known = level.rates.counts(prefix)
+				node.ratesUnknown = !known
+			}
+			if level.series != nil {
+				node.timeline = level.series.columns(prefix)
+			}
+		}
+	}
+	root.sort()
+
+	w := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	combinations := levels[len(levels)-1]
+	header := make([]string, 0, len(dimensions))
+	for _, dimension := range dimensions {
+		header = append(header, strings.ToUpper(dimension))
+	}
+	extraHeader := ""
+	if combinations.rates != nil {
+		extraHeader = "\t" + strings.Join(aggregateRateHeader(), "\t")
+	}
+	if combinations.series != nil {
+		extraHeader += "\t" + combinations.series.header()
+	}
+	fmt.Fprintf(w, "COUNT\t%s%s\n", strings.Join(header, " / "), extraHeader)
+	var print func(n *groupNode, depth int)
+	print = func(n *groupNode, depth int) {
+		for _, c := range n.children {
+			columns := ""
+			if combinations.rates != nil {
+				columns = "\t" + strings.Join(combinations.rates.columns(c.requests, c.errors, !c.ratesUnknown), "\t")
+			}
+			if combinations.series != nil {
+				columns += "\t" + sparkline(c.timeline)
+			}
+			fmt.Fprintf(w, "%s\t%s%s%s\n", countWithError(c.count, c.err), strings.Repeat("  ", depth), MatrixKey(c.key), columns)
+			print(c, depth+1)
+		}
+	}
+	print(root, 0)
+	fmt.Fprintf(w, "\nTotal: %d events\n", total)
+}
@@ -0,0 +1,132 @@
+package query
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+func TestValidateGroupBy(t *testing.T) {
+	tests := []struct {
+		groupBy []string
+		by      string
+		err     string
+	}{
+		{groupBy: nil, by: "user"},
+		{groupBy: []string{"user", "verb"}},
+		{groupBy: []string{"user"}, by: "verb", err: "mutually exclusive"},
+		{groupBy: []string{"user", "color"}, err: `invalid --group-by value "color"`},
+		{groupBy: []string{"user", "verb", "user"}, err: `"user" is given twice`},
+	}
+	for _, test := range tests {
+		err := validateGroupBy(test.groupBy, test.by)
+		if len(test.err) == 0 && err != nil {
+			t.Errorf("%v: %v", test.groupBy, err)
+		}
+		if len(test.err) > 0 && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%v: expected an error containing %q, got %v", test.groupBy, test.err, err)
+		}
+	}
+}
+
+// groupByEvents returns events of the users and verbs, in order, repeated by their count.
+func groupByEvents(counts ...interface{}) []*auditv1.Event {
+	events := []*auditv1.Event{}
+	for i := 0; i < len(counts); i += 3 {
+		for j := 0; j < counts[i+2].(int); j++ {
+			e := &auditv1.Event{Verb: counts[i+1].(string)}
+			e.User.Username = counts[i].(string)
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+func TestGroupByNested(t *testing.T) {
+	events := groupByEvents(
+		"alice", "get", 5,
+		"bob", "get", 4,
+		"alice", "list", 3,
+		"carol", "watch", 1,
+		"bob", "delete", 1,
+		"carol", "get", 1,
+	)
+	tests := []struct {
+		name     string
+		options  Options
+		count    int
+		expected string
+	}{
+		{
+			name:    "exact",
+			options: Options{topExact: true},
+			count:   10,
+			expected: `COUNT  USER / VERB
+8      alice
+5        get
+3        list
+5      bob
+4        get
+1        delete
+2      carol
+1        get
+1        watch
+
+Total: 15 events
+`,
+		},
+		{
+			// the users count all their events, not only the combinations printed below them
+			name:    "top combinations",
+			options: Options{topExact: true},
+			count:   2,
+			expected: `COUNT  USER / VERB
+8      alice
+5        get
+5      bob
+4        get
+
+Total: 15 events
+`,
+		},
+		{
+			// carol/watch, bob/delete and carol/get replace the least frequent combination in turn, the users are
+			// within the capacity and still counted exactly
+			name:    "space-saving",
+			options: Options{topCapacity: 3},
+			count:   10,
+			expected: `COUNT   USER / VERB
+8       alice
+5         get
+5       bob
+5 (±4)    delete
+2       carol
+5 (±4)    get
+
+Total: 15 events
+`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dimensions := []string{"user", "verb"}
+			keyFunc := groupKeyFunc(dimensions)
+			levels := []*groupLevel{test.options.newGroupLevel(), test.options.newGroupLevel()}
+			for _, e := range events {
+				values := keyFunc(e)
+				for i, level := range levels {
+					level.add(e, strings.Join(values[:i+1], "\x00"))
+				}
+			}
+
+			combinations := levels[len(levels)-1].counter
+			out := &bytes.Buffer{}
+			printGroupByNested(out, combinations.Top(test.count), combinations.Total(), dimensions, levels)
+			if out.String() != test.expected {
+				t.Errorf("expected\n%s\ngot\n%s", test.expected, out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package query
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+
+	"github.com/natamm4/audit-tool/pkg/audit/index"
+	"github.com/natamm4/audit-tool/pkg/audit/provenance"
+)
+
+// LocalAuditFile is an audit file of a local directory, indexed by the index command.
+type LocalAuditFile struct {
+	Name string
+	// Path is relative to the directory, like the paths of its index
+	Path    string
+	Size    int64
+	ModTime time.Time
+
+	file auditFile
+}
+
+// LocalAuditFiles returns the audit files of the queried nodes by local directory.
+func (o Options) LocalAuditFiles() (map[string][]LocalAuditFile, error) {
+	files := map[string][]LocalAuditFile{}
+	for cluster, dir := range o.localDirectories {
+		files[dir] = []LocalAuditFile{}
+		for _, node := range o.nodeNames.List() {
+			for _, f := range o.auditFiles.files[node] {
+				if f.cluster != cluster {
+					continue
+				}
+				path, err := filepath.Rel(dir, f.file.Path)
+				if err != nil {
+					return nil, err
+				}
+				files[dir] = append(files[dir], LocalAuditFile{Name: f.name, Path: path, Size: f.file.Size, ModTime: f.file.ModTime, file: f})
+			}
+		}
+	}
+	return files, nil
+}
+
+// IndexAuditFile reads all events of an audit file and returns its index entry and time range.
+func (o Options) IndexAuditFile(ctx context.Context, f LocalAuditFile) (*index.File, *index.TimeRange, error) {
+	r, err := o.auditFiles.Open(ctx, f.file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Close()
+	builder := index.NewFileBuilder()
+	if err := scanAuditEvents(r, o.maxEventSize, provenance.Provenance{}, nil, nil, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			builder.Add(e)
+		}
+	}); err != nil {
+		return nil, nil, err
+	}
+	timeRange := builder.Range(f.Size, f.ModTime)
+	if timeRange.Checkpoints, err = readCheckpoints(f.file.file.Path); err != nil {
+		return nil, nil, err
+	}
+	return builder.Build(f.Size, f.ModTime), timeRange, nil
+}
+
+// auditIndex skips the audit files whose index rules out events matching the exact values of the filter flags.
+type auditIndex struct {
+	// dirs are the local directories by cluster, indexes by cluster
+	dirs    map[string]string
+	indexes map[string]*index.Index
+
+	auditIDs   []string
+	users      []string
+	namespaces []string
+	resources  []string
+}
+
+// exactValues returns the values of a filter flag when they all match exact values, nil when any of them is negated
+// or a wildcard (see filter.AcceptString), as the index can't rule these out.
+func exactValues(values []string) []string {
+	for _, value := range values {
+		if strings.HasPrefix(value, "-") || strings.HasSuffix(value, "*") {
+			return nil
+		}
+	}
+	return values
+}
+
+// loadIndex returns the index of the local directories, nil when none is indexed or the filter flags select no exact
+// values the index can rule out.
+func (o Options) loadIndex() (*auditIndex, error) {
+	idx := &auditIndex{
+		dirs:       o.localDirectories,
+		indexes:    map[string]*index.Index{},
+		auditIDs:   exactValues(o.uids),
+		users:      exactValues(o.users),
+		namespaces: exactValues(o.namespaces),
+	}
+	for _, resource := range exactValues(o.resources) {
+		parts := strings.SplitN(resource, ".", 2)
+		switch {
+		case len(parts) == 1:
+			idx.resources = append(idx.resources, index.ResourceKey(schema.GroupResource{Resource: parts[0]}))
+		case parts[1] == "*":
+			idx.resources = append(idx.resources, index.AnyGroupResourceKey(parts[0]))
+		default:
+			idx.resources = append(idx.resources, index.ResourceKey(schema.GroupResource{Resource: parts[0], Group: parts[1]}))
+		}
+	}
+	if len(idx.auditIDs)+len(idx.users)+len(idx.namespaces)+len(idx.resources) == 0 {
+		return nil, nil
+	}
+
+	for cluster, dir := range o.localDirectories {
+		clusterIndex, err := index.Read(dir)
+		if err != nil {
+			return nil, err
+		}
+		if clusterIndex != nil {
+			idx.indexes[cluster] = clusterIndex
+		}
+	}
+	if len(idx.indexes) == 0 {
+		return nil, nil
+	}
+	return idx, nil
+}
+
+func mayContainAny(bloom *index.Bloom, values []string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, value := range values {
+		if bloom.MayContain(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// skip is true when the audit file certainly contains no event matching the filter flags.
+func (idx *auditIndex) skip(f auditFile) bool {
+	if idx == nil || idx.indexes[f.cluster] == nil {
+		return false
+	}
+	path, err := filepath.Rel(idx.dirs[f.cluster], f.file.Path)
+	if err != nil {
+		return false
+	}
+	entry, ok := idx.indexes[f.cluster].Files[path]
+	if !ok || !entry.Current(f.file.Size, f.file.ModTime) {
+		return false
+	}
+	return !mayContainAny(entry.AuditIDs, idx.auditIDs) ||
+		!mayContainAny(entry.Users, idx.users) ||
+		!mayContainAny(entry.Namespaces, idx.namespaces) ||
+		!mayContainAny(entry.Resources, idx.resources)
+}
@@ -0,0 +1,231 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/filter"
+)
+
+// nodeLeaseDuration is the default lease duration of the node heartbeat, a node not renewing within it is not ready.
+const nodeLeaseDuration = 40 * time.Second
+
+type KubeletsOptions struct {
+	limit int
+
+	// queryOptions selects and filters the events to analyze
+	queryOptions Options
+
+	genericclioptions.IOStreams
+}
+
+func NewKubeletsCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &KubeletsOptions{IOStreams: streams, queryOptions: Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "kubelets --dir DIR",
+		Short: "Report the requests of the kubelets per node to diagnose noisy or broken kubelets",
+		Long: "Report the requests of the kubelets (system:node:* users) per node to diagnose noisy or broken kubelets: the\n" +
+			"rate of node status updates, the node lease renewals and the longest gap between them, and the unusual\n" +
+			"requests. Kubelets renew their lease every 10s by default, a gap longer than the 40s lease duration marks\n" +
+			"the node not ready. Unusual are the requests forbidden to the kubelet and the writes kubelets don't send,\n" +
+			"they are listed below the nodes.",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	cmd.Flags().StringVar(&options.queryOptions.from, "from", "", "Only analyze events starting at this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().StringVar(&options.queryOptions.to, "to", "", "Only analyze events before this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().IntVar(&options.queryOptions.maxEventSize, "max-event-size", defaultMaxEventSize, "Maximum size in bytes of a single audit event.")
+	cmd.Flags().IntVar(&options.limit, "limit", 20, "Number of nodes and unusual requests to display.")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *KubeletsOptions) Validate() error {
+	if len(o.queryOptions.targetDirectories) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	return nil
+}
+
+type kubeletStats struct {
+	node           string
+	requests       int64
+	statusUpdates  int64
+	unusual        int64
+	first, last    time.Time
+	leaseRenewals  []int64
+	medianInterval time.Duration
+	maxGap         time.Duration
+}
+
+// unusualKubeletRequest counts the unusual requests of a node by verb, resource and response code.
+type unusualKubeletRequest struct {
+	node     string
+	verb     string
+	resource string
+	code     int32
+	count    int64
+}
+
+func (o *KubeletsOptions) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+
+	nodes := map[string]*kubeletStats{}
+	unusual := map[string]*unusualKubeletRequest{}
+	if err := o.queryOptions.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			if e.Stage != auditv1.StageResponseComplete {
+				continue
+			}
+			node := filter.KubeletNode(e)
+			if len(node) == 0 {
+				continue
+			}
+			s, ok := nodes[node]
+			if !ok {
+				s = &kubeletStats{node: node, first: e.RequestReceivedTimestamp.Time}
+				nodes[node] = s
+			}
+			s.requests++
+			if e.RequestReceivedTimestamp.Time.Before(s.first) {
+				s.first = e.RequestReceivedTimestamp.Time
+			}
+			if e.RequestReceivedTimestamp.Time.After(s.last) {
+				s.last = e.RequestReceivedTimestamp.Time
+			}
+			succeeded := e.ResponseStatus != nil && e.ResponseStatus.Code < 300
+			switch {
+			case filter.IsNodeStatusUpdate(e):
+				s.statusUpdates++
+			case filter.IsNodeLeaseRenewal(e) && succeeded:
+				s.leaseRenewals = append(s.leaseRenewals, e.RequestReceivedTimestamp.UnixNano())
+			}
+
+			if !filter.IsUnusualKubeletRequest(e) {
+				continue
+			}
+			s.unusual++
+			resource := "<non-resource>"
+			if e.ObjectRef != nil {
+				_, gvr, _, subresource := filter.URIToParts(e.RequestURI)
+				resource = gvr.GroupResource().String()
+				if len(subresource) > 0 {
+					resource += "/" + subresource
+				}
+			}
+			var code int32
+			if e.ResponseStatus != nil {
+				code = e.ResponseStatus.Code
+			}
+			key := fmt.Sprintf("%s\x00%s\x00%s\x00%d", node, e.Verb, resource, code)
+			u, ok := unusual[key]
+			if !ok {
+				u = &unusualKubeletRequest{node: node, verb: e.Verb, resource: resource, code: code}
+				unusual[key] = u
+			}
+			u.count++
+		}
+	}); err != nil {
+		return err
+	}
+	if len(nodes) == 0 {
+		fmt.Fprintln(o.Out, "No kubelet requests found.")
+		return nil
+	}
+
+	result := make([]*kubeletStats, 0, len(nodes))
+	for _, s := range nodes {
+		renewals := s.leaseRenewals
+		sort.Slice(renewals, func(i, j int) bool { return renewals[i] < renewals[j] })
+		if len(renewals) > 1 {
+			intervals := make([]int64, len(renewals)-1)
+			for i := 1; i < len(renewals); i++ {
+				intervals[i-1] = renewals[i] - renewals[i-1]
+			}
+			sort.Slice(intervals, func(i, j int) bool { return intervals[i] < intervals[j] })
+			s.medianInterval = time.Duration(intervals[len(intervals)/2])
+			s.maxGap = time.Duration(intervals[len(intervals)-1])
+		}
+		result = append(result, s)
+	}
+	// the broken kubelets first, then the noisy ones
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].unusual != result[j].unusual {
+			return result[i].unusual > result[j].unusual
+		}
+		if result[i].maxGap != result[j].maxGap {
+			return result[i].maxGap > result[j].maxGap
+		}
+		if result[i].requests != result[j].requests {
+			return result[i].requests > result[j].requests
+		}
+		return result[i].node < result[j].node
+	})
+
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tREQUESTS\tSTATUS UPDATES\tSTATUS/MIN\tLEASE RENEWALS\tMEDIAN RENEW INTERVAL\tMAX RENEW GAP\tUNUSUAL")
+	for i, s := range result {
+		if o.limit > 0 && i >= o.limit {
+			break
+		}
+		statusRate := "-"
+		if active := s.last.Sub(s.first); active >= time.Minute {
+			statusRate = fmt.Sprintf("%.2f", float64(s.statusUpdates)/active.Minutes())
+		}
+		interval, gap := "-", "-"
+		if len(s.leaseRenewals) > 1 {
+			interval = s.medianInterval.Round(100 * time.Millisecond).String()
+			gap = s.maxGap.Round(100 * time.Millisecond).String()
+			if s.maxGap > nodeLeaseDuration {
+				gap += " (expired)"
+			}
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t%s\t%s\t%d\n", s.node, s.requests, s.statusUpdates, statusRate, len(s.leaseRenewals), interval, gap, s.unusual)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if len(unusual) == 0 {
+		return nil
+	}
+
+	unusualResult := make([]*unusualKubeletRequest, 0, len(unusual))
+	for _, u := range unusual {
+		unusualResult = append(unusualResult, u)
+	}
+	sort.Slice(unusualResult, func(i, j int) bool {
+		if unusualResult[i].count != unusualResult[j].count {
+			return unusualResult[i].count > unusualResult[j].count
+		}
+		return unusualResult[i].node < unusualResult[j].node
+	})
+	fmt.Fprintln(o.Out)
+	fmt.Fprintln(o.Out, "Unusual requests:")
+	w = tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tVERB\tRESOURCE\tCODE\tCOUNT")
+	for i, u := range unusualResult {
+		if o.limit > 0 && i >= o.limit {
+			break
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\n", u.node, u.verb, u.resource, u.code, u.count)
+	}
+	return w.Flush()
+}
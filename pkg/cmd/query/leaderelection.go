@@ -0,0 +1,160 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/filter"
+)
+
+type LeaderElectionOptions struct {
+	limit int
+
+	// queryOptions selects and filters the events to analyze
+	queryOptions Options
+
+	genericclioptions.IOStreams
+}
+
+func NewLeaderElectionCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &LeaderElectionOptions{IOStreams: streams, queryOptions: Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "leader-election --dir DIR",
+		Short: "Report the leader election traffic and churn per lock",
+		Long: "Report the leader election traffic and churn per lock, which usually names the component electing a leader.\n" +
+			"Holders and transitions are read from the request objects of the lock updates, they are only known when the\n" +
+			"audit policy records leases and configmaps at the Request level or above.",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	cmd.Flags().StringVar(&options.queryOptions.from, "from", "", "Only analyze events starting at this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().StringVar(&options.queryOptions.to, "to", "", "Only analyze events before this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().IntVar(&options.queryOptions.maxEventSize, "max-event-size", defaultMaxEventSize, "Maximum size in bytes of a single audit event.")
+	cmd.Flags().IntVar(&options.limit, "limit", 0, "Number of locks with the most leader transitions to display.")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *LeaderElectionOptions) Validate() error {
+	if len(o.queryOptions.targetDirectories) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	return nil
+}
+
+// leaderRenewal is a successful write of a lock recording its holder.
+type leaderRenewal struct {
+	completed time.Time
+	holder    string
+}
+
+type leaderLock struct {
+	lock     string
+	resource string
+	requests int64
+	failed   int64
+	users    map[string]struct{}
+	renewals []leaderRenewal
+}
+
+// transitions counts how often the holder changed, in the order the writes completed.
+func (l *leaderLock) transitions() (int, int) {
+	sort.SliceStable(l.renewals, func(i, j int) bool {
+		return l.renewals[i].completed.Before(l.renewals[j].completed)
+	})
+	holders := map[string]struct{}{}
+	transitions := 0
+	for i, renewal := range l.renewals {
+		holders[renewal.holder] = struct{}{}
+		if i > 0 && renewal.holder != l.renewals[i-1].holder {
+			transitions++
+		}
+	}
+	return len(holders), transitions
+}
+
+func (o *LeaderElectionOptions) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+
+	locks := map[string]*leaderLock{}
+	if err := o.queryOptions.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			if e.Stage != auditv1.StageResponseComplete || !filter.IsLeaderElection(e) {
+				continue
+			}
+			name := filter.LeaderElectionLock(e)
+			lock, ok := locks[name]
+			if !ok {
+				lock = &leaderLock{lock: name, resource: e.ObjectRef.Resource, users: map[string]struct{}{}}
+				locks[name] = lock
+			}
+			lock.requests++
+			lock.users[e.User.Username] = struct{}{}
+			if e.ResponseStatus == nil || e.ResponseStatus.Code >= 300 {
+				lock.failed++
+				continue
+			}
+			if e.Verb != "create" && e.Verb != "update" && e.Verb != "patch" {
+				continue
+			}
+			if holder := filter.LeaderElectionHolder(e); len(holder) > 0 {
+				lock.renewals = append(lock.renewals, leaderRenewal{completed: e.StageTimestamp.Time, holder: holder})
+			}
+		}
+	}); err != nil {
+		return err
+	}
+	if len(locks) == 0 {
+		fmt.Fprintln(o.Out, "No leader election requests found.")
+		return nil
+	}
+
+	type lockStats struct {
+		*leaderLock
+		holders     int
+		transitions int
+	}
+	result := make([]lockStats, 0, len(locks))
+	for _, lock := range locks {
+		holders, transitions := lock.transitions()
+		result = append(result, lockStats{leaderLock: lock, holders: holders, transitions: transitions})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].transitions != result[j].transitions {
+			return result[i].transitions > result[j].transitions
+		}
+		if result[i].requests != result[j].requests {
+			return result[i].requests > result[j].requests
+		}
+		return result[i].lock < result[j].lock
+	})
+
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "TRANSITIONS\tHOLDERS\tREQUESTS\tFAILED\tUSERS\tRESOURCE\tLOCK")
+	for i, s := range result {
+		if o.limit > 0 && i >= o.limit {
+			break
+		}
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%d\t%s\t%s\n", s.transitions, s.holders, s.requests, s.failed, len(s.users), s.resource, s.lock)
+	}
+	return nil
+}
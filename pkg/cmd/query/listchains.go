@@ -0,0 +1,286 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/filter"
+	"github.com/natamm4/audit-tool/pkg/audit/provenance"
+)
+
+type ListChainsOptions struct {
+	minPages int
+	limit    int
+
+	// queryOptions selects and filters the events to analyze
+	queryOptions Options
+
+	genericclioptions.IOStreams
+}
+
+func NewListChainsCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &ListChainsOptions{IOStreams: streams, queryOptions: Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "list-chains --dir DIR",
+		Short: "Reconstruct paginated LISTs from their continue tokens to find clients paging through huge collections",
+		Long: "Reconstruct paginated LISTs from their continue tokens and report the pages, duration and outcome of each.\n\n" +
+			"The pages of a list are linked by the resource version encoded in the continue token. The first page carries no\n" +
+			"token, it is linked to the following pages through its response when recorded (RequestResponse level) and\n" +
+			"otherwise to the latest first page of the same client and collection. Whether a list completed is only known\n" +
+			"from the response of its last page; 'expired' lists were answered 410 Gone because the client paged slower\n" +
+			"than etcd compacts. Lists started before the analyzed events are marked 'partial'.",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	cmd.Flags().StringVar(&options.queryOptions.from, "from", "", "Only analyze events starting at this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().StringVar(&options.queryOptions.to, "to", "", "Only analyze events before this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().IntVar(&options.queryOptions.maxEventSize, "max-event-size", defaultMaxEventSize, "Maximum size in bytes of a single audit event.")
+	cmd.Flags().IntVar(&options.minPages, "min-pages", 2, "Only display lists with at least this number of pages.")
+	cmd.Flags().IntVar(&options.limit, "limit", 20, "Number of lists with the most pages to display.")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *ListChainsOptions) Validate() error {
+	if len(o.queryOptions.targetDirectories) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	return nil
+}
+
+// listPage is a single paginated LIST request.
+type listPage struct {
+	// collection identifies the client and the listed collection, the pages of a list share it
+	collection string
+	user       string
+	resource   string
+	received   time.Time
+	completed  time.Time
+	code       int32
+	// continued is set for all pages but the first, rv is then the resource version of the list
+	continued bool
+	rv        int64
+	// recorded is set when the response object is recorded, next is then its continue token
+	recorded bool
+	next     string
+	items    int64
+}
+
+// listChain is a paginated LIST reconstructed from its pages.
+type listChain struct {
+	pages   []*listPage
+	partial bool
+	rv      int64
+	// expected is the continue token of the last page when its response is recorded
+	expected string
+}
+
+func (c *listChain) last() *listPage {
+	return c.pages[len(c.pages)-1]
+}
+
+func (c *listChain) status() string {
+	last := c.last()
+	status := ""
+	switch {
+	case last.code == http.StatusGone:
+		status = "expired"
+	case last.code >= 300:
+		status = "failed"
+	case !last.recorded:
+		status = "unknown"
+	case len(last.next) == 0:
+		status = "complete"
+	default:
+		status = "incomplete"
+	}
+	if c.partial {
+		status += " (partial)"
+	}
+	return status
+}
+
+// items returns the number of items listed, -1 when a page size is unknown.
+func (c *listChain) items() int64 {
+	total := int64(0)
+	for _, page := range c.pages {
+		if page.items < 0 {
+			return -1
+		}
+		total += page.items
+	}
+	return total
+}
+
+// newListPage returns the page for a paginated LIST, nil for other requests.
+func newListPage(e *auditv1.Event) *listPage {
+	if e.Verb != "list" {
+		return nil
+	}
+	query := filter.ParseURIQuery(e.RequestURI)
+	if query.Watch || (query.Limit <= 0 && len(query.Continue) == 0) {
+		return nil
+	}
+	_, gvr, _, _ := filter.URIToParts(e.RequestURI)
+	path := e.RequestURI
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	page := &listPage{
+		collection: strings.Join([]string{provenance.Cluster(e), e.User.Username, e.UserAgent, path, query.LabelSelector, query.FieldSelector}, "\x00"),
+		user:       e.User.Username,
+		resource:   gvr.GroupResource().String(),
+		received:   e.RequestReceivedTimestamp.Time,
+		completed:  e.StageTimestamp.Time,
+		code:       -1,
+		continued:  len(query.Continue) > 0,
+		items:      -1,
+	}
+	if e.ResponseStatus != nil {
+		page.code = e.ResponseStatus.Code
+	}
+	if page.continued {
+		if token, err := query.ContinueToken(); err == nil {
+			page.rv = token.ResourceVersion
+		}
+	}
+	if e.ResponseObject != nil && len(e.ResponseObject.Raw) > 0 {
+		list := struct {
+			Metadata struct {
+				ResourceVersion string `json:"resourceVersion"`
+				Continue        string `json:"continue"`
+			} `json:"metadata"`
+		}{}
+		if err := json.Unmarshal(e.ResponseObject.Raw, &list); err == nil {
+			page.recorded = true
+			page.next = list.Metadata.Continue
+			if !page.continued {
+				page.rv, _ = strconv.ParseInt(list.Metadata.ResourceVersion, 10, 64)
+			}
+		}
+		page.items, _ = listResponseSize(e)
+	}
+	return page
+}
+
+// chainPages links the pages, in the order they were received, into lists.
+func chainPages(pages []*listPage) []*listChain {
+	sort.SliceStable(pages, func(i, j int) bool {
+		return pages[i].received.Before(pages[j].received)
+	})
+
+	chains := []*listChain{}
+	// open holds the lists waiting for their next page by collection and resource version, unlinked the lists whose
+	// first page response isn't recorded by collection
+	open := map[string]*listChain{}
+	unlinked := map[string]*listChain{}
+	for _, page := range pages {
+		var chain *listChain
+		if page.continued {
+			key := page.collection + "\x00" + strconv.FormatInt(page.rv, 10)
+			chain = open[key]
+			if chain == nil && unlinked[page.collection] != nil {
+				chain = unlinked[page.collection]
+				chain.rv = page.rv
+				delete(unlinked, page.collection)
+			}
+			if chain == nil {
+				chain = &listChain{partial: true, rv: page.rv}
+				chains = append(chains, chain)
+			}
+			delete(open, key)
+			chain.pages = append(chain.pages, page)
+		} else {
+			chain = &listChain{pages: []*listPage{page}, rv: page.rv}
+			chains = append(chains, chain)
+			if !page.recorded {
+				unlinked[page.collection] = chain
+			}
+		}
+
+		// a list ends with a failed page or a recorded response without a continue token
+		if page.code >= 300 || (page.recorded && len(page.next) == 0) {
+			continue
+		}
+		if !page.continued && !page.recorded {
+			continue
+		}
+		open[page.collection+"\x00"+strconv.FormatInt(chain.rv, 10)] = chain
+	}
+	return chains
+}
+
+func (o *ListChainsOptions) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+
+	pages := []*listPage{}
+	if err := o.queryOptions.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			if e.Stage != auditv1.StageResponseComplete {
+				continue
+			}
+			if page := newListPage(e); page != nil {
+				pages = append(pages, page)
+			}
+		}
+	}); err != nil {
+		return err
+	}
+
+	chains := []*listChain{}
+	for _, chain := range chainPages(pages) {
+		if len(chain.pages) >= o.minPages {
+			chains = append(chains, chain)
+		}
+	}
+	if len(chains) == 0 {
+		fmt.Fprintln(o.Out, "No paginated lists found.")
+		return nil
+	}
+	sort.SliceStable(chains, func(i, j int) bool {
+		if len(chains[i].pages) != len(chains[j].pages) {
+			return len(chains[i].pages) > len(chains[j].pages)
+		}
+		return chains[i].pages[0].received.Before(chains[j].pages[0].received)
+	})
+
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "PAGES\tITEMS\tDURATION\tSTATUS\tSTARTED\tUSER\tRESOURCE")
+	for i, chain := range chains {
+		if o.limit > 0 && i >= o.limit {
+			break
+		}
+		first := chain.pages[0]
+		items := "?"
+		if count := chain.items(); count >= 0 {
+			items = strconv.FormatInt(count, 10)
+		}
+		duration := chain.last().completed.Sub(first.received).Round(time.Millisecond)
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", len(chain.pages), items, duration, chain.status(),
+			first.received.Format(TimeDefaultFormat), first.user, MatrixKey(first.resource))
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package query
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/natamm4/audit-tool/pkg/audit/manifest"
+)
+
+// sortedClusters returns the clusters of the local directories, the unlabeled single cluster is empty.
+func (o Options) sortedClusters() []string {
+	clusters := []string{}
+	for cluster := range o.localDirectories {
+		clusters = append(clusters, cluster)
+	}
+	sort.Strings(clusters)
+	return clusters
+}
+
+// printManifests prints where and when the local directories were collected, if get wrote a manifest for them.
+func (o Options) printManifests() error {
+	for _, cluster := range o.sortedClusters() {
+		dir := o.localDirectories[cluster]
+		m, err := manifest.Read(dir)
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			continue
+		}
+		fmt.Fprintln(o.Out)
+		if len(cluster) > 0 {
+			fmt.Fprintf(o.Out, "Cluster %s (%s):\n", cluster, dir)
+		}
+		if len(m.ClusterID) > 0 {
+			fmt.Fprintf(o.Out, "  cluster ID: %s\n", m.ClusterID)
+		}
+		fmt.Fprintf(o.Out, "  collected: %s\n", m.CollectedAt.Format(TimeDefaultFormat))
+		if len(m.ServerVersion) > 0 {
+			fmt.Fprintf(o.Out, "  server version: %s\n", m.ServerVersion)
+		}
+		fmt.Fprintf(o.Out, "  nodes: %s\n", strings.Join(m.Nodes, ", "))
+		for _, apiServer := range m.APIServers {
+			fmt.Fprintf(o.Out, "  %s on %s: %s\n", apiServer.Pod, apiServer.Node, apiServer.Image)
+		}
+		var size int64
+		for _, f := range m.Files {
+			size += f.Size
+		}
+		fmt.Fprintf(o.Out, "  files: %d (%d bytes)\n", len(m.Files), size)
+	}
+	return nil
+}
+
+// verifyManifests fails when a file of a local directory differs from its manifest. Directories without a manifest
+// can't be verified and fail too.
+func (o Options) verifyManifests() error {
+	if len(o.localDirectories) == 0 {
+		return fmt.Errorf("--verify requires local directories")
+	}
+	problems := []string{}
+	for _, cluster := range o.sortedClusters() {
+		dir := o.localDirectories[cluster]
+		m, err := manifest.Read(dir)
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			problems = append(problems, fmt.Sprintf("%s has no %s", dir, manifest.FileName))
+			continue
+		}
+		differences, err := m.Verify(dir)
+		if err != nil {
+			return err
+		}
+		for _, difference := range differences {
+			problems = append(problems, fmt.Sprintf("%s: %s", dir, difference))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("verification failed:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
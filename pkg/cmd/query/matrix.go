@@ -0,0 +1,129 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+
+	"github.com/natamm4/audit-tool/pkg/audit/filter"
+)
+
+func validateMatrixDimensions(rows, cols string) error {
+	for flag, dimension := range map[string]string{"--rows": rows, "--cols": cols} {
+		if _, ok := topKeyFuncs[dimension]; !ok {
+			return fmt.Errorf("invalid %s value %q, must be one of %s", flag, dimension, strings.Join(topDimensions(), ", "))
+		}
+	}
+	if rows == cols {
+		return fmt.Errorf("--rows and --cols must be different dimensions")
+	}
+	return nil
+}
+
+// matrix counts the events per pair of keys of two dimensions (eg. users × verbs).
+type matrix struct {
+	counts    map[string]map[string]int64
+	rowTotals map[string]int64
+	colTotals map[string]int64
+	total     int64
+}
+
+func newMatrix() *matrix {
+	return &matrix{
+		counts:    map[string]map[string]int64{},
+		rowTotals: map[string]int64{},
+		colTotals: map[string]int64{},
+	}
+}
+
+func (m *matrix) add(row, col string) {
+	if _, ok := m.counts[row]; !ok {
+		m.counts[row] = map[string]int64{}
+	}
+	m.counts[row][col]++
+	m.rowTotals[row]++
+	m.colTotals[col]++
+	m.total++
+}
+
+// sortedByTotal returns the keys ordered by their total count, the busiest first.
+func sortedByTotal(totals map[string]int64) []string {
+	keys := make([]string, 0, len(totals))
+	for key := range totals {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if totals[keys[i]] != totals[keys[j]] {
+			return totals[keys[i]] > totals[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// runMatrix counts the events in a single pass without keeping them in memory.
+func (o Options) runMatrix(ctx context.Context, filters filter.AuditFilters) error {
+	rowFunc, colFunc := topKeyFuncs[o.matrixRows], topKeyFuncs[o.matrixCols]
+
+	m := newMatrix()
+	if err := o.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			m.add(rowFunc(e), colFunc(e))
+		}
+	}); err != nil {
+		return err
+	}
+
+	printMatrix(o.Out, m, o.matrixRows, o.matrixCols, int(o.limit))
+	return nil
+}
+
+// printMatrix prints the rows with the most events first, limit caps the number of rows when positive.
+func printMatrix(writer io.Writer, m *matrix, rowsBy, colsBy string, limit int) {
+	w := tabwriter.NewWriter(writer, 0, 0, 2, ' ', tabwriter.AlignRight)
+	defer w.Flush()
+
+	cols := sortedByTotal(m.colTotals)
+	header := []string{strings.ToUpper(rowsBy) + " \\ " + strings.ToUpper(colsBy)}
+	for _, col := range cols {
+		header = append(header, MatrixKey(col))
+	}
+	header = append(header, "TOTAL")
+	fmt.Fprintln(w, strings.Join(header, "\t")+"\t")
+
+	for i, row := range sortedByTotal(m.rowTotals) {
+		if limit > 0 && i >= limit {
+			break
+		}
+		line := []string{MatrixKey(row)}
+		for _, col := range cols {
+			count := m.counts[row][col]
+			if count == 0 {
+				line = append(line, ".")
+				continue
+			}
+			line = append(line, fmt.Sprintf("%d", count))
+		}
+		line = append(line, fmt.Sprintf("%d", m.rowTotals[row]))
+		fmt.Fprintln(w, strings.Join(line, "\t")+"\t")
+	}
+
+	footer := []string{"TOTAL"}
+	for _, col := range cols {
+		footer = append(footer, fmt.Sprintf("%d", m.colTotals[col]))
+	}
+	footer = append(footer, fmt.Sprintf("%d", m.total))
+	fmt.Fprintln(w, strings.Join(footer, "\t")+"\t")
+}
+
+func MatrixKey(key string) string {
+	if len(strings.TrimSpace(key)) == 0 {
+		return "<none>"
+	}
+	return key
+}
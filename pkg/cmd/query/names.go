@@ -0,0 +1,206 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/filter"
+)
+
+type NamesOptions struct {
+	limit    int
+	minNames int
+
+	// queryOptions selects and filters the events to analyze
+	queryOptions Options
+
+	genericclioptions.IOStreams
+}
+
+func NewNamesCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &NamesOptions{IOStreams: streams, queryOptions: Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "names --dir DIR",
+		Short: "Group object names by pattern and report the name families receiving the most requests",
+		Long: "Group object names by pattern and report the name families receiving the most requests.\n\n" +
+			"The generated parts of names are replaced by placeholders: <random> for generateName suffixes, <hash> for\n" +
+			"pod template hashes and hex digests, <uuid> and <n> for numbers. Many names of a family with as many creates\n" +
+			"and deletes usually mean a controller recreating objects in a tight loop.",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	cmd.Flags().StringVar(&options.queryOptions.from, "from", "", "Only analyze events starting at this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().StringVar(&options.queryOptions.to, "to", "", "Only analyze events before this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().IntVar(&options.queryOptions.maxEventSize, "max-event-size", defaultMaxEventSize, "Maximum size in bytes of a single audit event.")
+	cmd.Flags().IntVar(&options.limit, "limit", 20, "Number of name families with the most requests to display.")
+	cmd.Flags().IntVar(&options.minNames, "min-names", 2, "Only report name families with at least this number of distinct names.")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *NamesOptions) Validate() error {
+	if len(o.queryOptions.targetDirectories) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	if o.minNames < 1 {
+		return fmt.Errorf("--min-names must be at least 1")
+	}
+	return nil
+}
+
+var (
+	uuidRegexp = regexp.MustCompile(`[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+	// generatedRegexp matches the alphabet of the random suffixes and hashes Kubernetes generates, without vowels and
+	// look-alike characters, so that they don't form words.
+	generatedRegexp = regexp.MustCompile(`^[bcdfghjklmnpqrstvwxz2456789]+$`)
+	hexRegexp       = regexp.MustCompile(`^[0-9a-f]{8,}$`)
+	numberRegexp    = regexp.MustCompile(`^[0-9]+$`)
+)
+
+// namePattern replaces the generated parts of an object name by placeholders, eg. web-5d8f9c7b6-x2k4p becomes
+// web-<hash>-<random>.
+func namePattern(name string) string {
+	name = uuidRegexp.ReplaceAllString(name, "<uuid>")
+	segments := strings.Split(name, "-")
+	for i, segment := range segments {
+		switch {
+		case numberRegexp.MatchString(segment):
+			segments[i] = "<n>"
+		case len(segment) == 5 && i == len(segments)-1 && i > 0 && generatedRegexp.MatchString(segment):
+			segments[i] = "<random>"
+		case len(segment) >= 6 && len(segment) <= 10 && generatedRegexp.MatchString(segment) && strings.ContainsAny(segment, "2456789"):
+			segments[i] = "<hash>"
+		case hexRegexp.MatchString(segment) && strings.ContainsAny(segment, "0123456789"):
+			segments[i] = "<hash>"
+		}
+	}
+	return strings.Join(segments, "-")
+}
+
+// nameFamily counts the requests for the objects whose names share a pattern.
+type nameFamily struct {
+	resource   string
+	pattern    string
+	requests   int64
+	creates    int64
+	deletes    int64
+	names      map[string]struct{}
+	namespaces map[string]struct{}
+	users      map[string]int64
+}
+
+func (f *nameFamily) topUser() string {
+	top := ""
+	for user, count := range f.users {
+		if count > f.users[top] || (count == f.users[top] && user < top) {
+			top = user
+		}
+	}
+	return top
+}
+
+// objectName returns the name of the object of the request. Objects created with generateName only have it in the
+// response object, when the audit policy records it.
+func objectName(e *auditv1.Event) string {
+	if len(e.ObjectRef.Name) > 0 || e.ResponseObject == nil {
+		return e.ObjectRef.Name
+	}
+	var object struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(e.ResponseObject.Raw, &object); err != nil {
+		return ""
+	}
+	return object.Metadata.Name
+}
+
+func (o *NamesOptions) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+
+	families := map[string]*nameFamily{}
+	if err := o.queryOptions.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			if e.ObjectRef == nil {
+				continue
+			}
+			name := objectName(e)
+			if len(name) == 0 {
+				continue
+			}
+			_, gvr, _, _ := filter.URIToParts(e.RequestURI)
+			resource := gvr.GroupResource().String()
+			pattern := namePattern(name)
+			key := resource + "\x00" + pattern
+			family, ok := families[key]
+			if !ok {
+				family = &nameFamily{
+					resource:   resource,
+					pattern:    pattern,
+					names:      map[string]struct{}{},
+					namespaces: map[string]struct{}{},
+					users:      map[string]int64{},
+				}
+				families[key] = family
+			}
+			family.requests++
+			switch e.Verb {
+			case "create":
+				family.creates++
+			case "delete":
+				family.deletes++
+			}
+			family.names[name] = struct{}{}
+			family.namespaces[e.ObjectRef.Namespace] = struct{}{}
+			family.users[e.User.Username]++
+		}
+	}); err != nil {
+		return err
+	}
+
+	result := []*nameFamily{}
+	for _, family := range families {
+		if len(family.names) >= o.minNames {
+			result = append(result, family)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].requests != result[j].requests {
+			return result[i].requests > result[j].requests
+		}
+		return result[i].pattern < result[j].pattern
+	})
+
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "REQUESTS\tNAMES\tCREATES\tDELETES\tNAMESPACES\tRESOURCE\tPATTERN\tTOP USER")
+	for i, family := range result {
+		if o.limit > 0 && i >= o.limit {
+			break
+		}
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%d\t%s\t%s\t%s\n", family.requests, len(family.names), family.creates, family.deletes,
+			len(family.namespaces), MatrixKey(family.resource), family.pattern, family.topUser())
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/filter"
+)
+
+type NamespaceLifecycleOptions struct {
+	namespace string
+
+	// queryOptions selects and filters the events to analyze
+	queryOptions Options
+
+	genericclioptions.IOStreams
+}
+
+func NewNamespaceLifecycleCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &NamespaceLifecycleOptions{IOStreams: streams, queryOptions: Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "namespace-lifecycle --dir DIR -n NAMESPACE",
+		Short: "Reconstruct the create and terminate timeline of a namespace and what was deleted during its termination",
+		Long: "Reconstruct the create and terminate timeline of a namespace from the writes of the namespace object,\n" +
+			"including the calls of its finalize subresource, and report which resources were deleted by whom while\n" +
+			"the namespace was terminating. The termination starts with the first successful delete of the namespace\n" +
+			"after it was last created and ends with its removal, the deletes of a namespace still terminating at the\n" +
+			"end of the audit logs are reported up to the end.",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	cmd.Flags().StringVar(&options.queryOptions.from, "from", "", "Only analyze events starting at this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().StringVar(&options.queryOptions.to, "to", "", "Only analyze events before this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().IntVar(&options.queryOptions.maxEventSize, "max-event-size", defaultMaxEventSize, "Maximum size in bytes of a single audit event.")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *NamespaceLifecycleOptions) Validate() error {
+	if len(o.queryOptions.targetDirectories) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	if len(o.queryOptions.namespaces) != 1 || strings.HasPrefix(o.queryOptions.namespaces[0], "-") || strings.Contains(o.queryOptions.namespaces[0], "*") {
+		return fmt.Errorf("a single namespace must be specified (--namespace/-n)")
+	}
+	// the namespace filter would drop the creates of the namespace, which are cluster scoped requests
+	o.namespace = o.queryOptions.namespaces[0]
+	o.queryOptions.namespaces = nil
+	return nil
+}
+
+// namespaceWrite is a completed write of the namespace object or a delete within the namespace.
+type namespaceWrite struct {
+	received    time.Time
+	user        string
+	verb        string
+	resource    string
+	subresource string
+	code        int32
+}
+
+func (w namespaceWrite) succeeded() bool {
+	return w.code >= 200 && w.code < 300
+}
+
+// namespaceDeletes counts the deletes of a resource by a user during the termination.
+type namespaceDeletes struct {
+	resource          string
+	user              string
+	deletes           int64
+	deleteCollections int64
+	failed            int64
+	first, last       time.Time
+}
+
+func (o *NamespaceLifecycleOptions) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+
+	timeline := []namespaceWrite{}
+	deletes := []namespaceWrite{}
+	if err := o.queryOptions.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			if e.Stage != auditv1.StageResponseComplete || e.ObjectRef == nil {
+				continue
+			}
+			write := namespaceWrite{received: e.RequestReceivedTimestamp.Time, user: e.User.Username, verb: e.Verb, subresource: e.ObjectRef.Subresource}
+			if e.ResponseStatus != nil {
+				write.code = e.ResponseStatus.Code
+			}
+			switch {
+			case e.ObjectRef.Resource == "namespaces" && len(e.ObjectRef.APIGroup) == 0 && e.ObjectRef.Name == o.namespace:
+				if e.Verb == "create" || e.Verb == "update" || e.Verb == "patch" || e.Verb == "delete" {
+					timeline = append(timeline, write)
+				}
+			case e.ObjectRef.Namespace == o.namespace && (e.Verb == "delete" || e.Verb == "deletecollection"):
+				_, gvr, _, _ := filter.URIToParts(e.RequestURI)
+				write.resource = gvr.GroupResource().String()
+				deletes = append(deletes, write)
+			}
+		}
+	}); err != nil {
+		return err
+	}
+	if len(timeline) == 0 && len(deletes) == 0 {
+		fmt.Fprintf(o.Out, "No writes of namespace %s found.\n", o.namespace)
+		return nil
+	}
+	sort.SliceStable(timeline, func(i, j int) bool { return timeline[i].received.Before(timeline[j].received) })
+	sort.SliceStable(deletes, func(i, j int) bool { return deletes[i].received.Before(deletes[j].received) })
+
+	// the termination starts with the first delete after the namespace was last created, the namespace controller
+	// deletes the namespace once more after the finalize call removed the last finalizer
+	var created, terminating, removed *namespaceWrite
+	for i := range timeline {
+		write := &timeline[i]
+		if !write.succeeded() {
+			continue
+		}
+		switch {
+		case write.verb == "create":
+			created, terminating, removed = write, nil, nil
+		case write.verb == "delete" && terminating == nil:
+			terminating = write
+		case terminating != nil && write.subresource == "finalize":
+			removed = write
+		case removed != nil && write.verb == "delete":
+			// repeated deletes before the finalize call only return the terminating namespace
+			removed = write
+		}
+	}
+
+	fmt.Fprintf(o.Out, "Namespace %s:\n", o.namespace)
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RECEIVED\tVERB\tSUBRESOURCE\tCODE\tUSER")
+	for _, write := range timeline {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", write.received.UTC().Format(TimeDefaultFormat), write.verb, write.subresource, write.code, write.user)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(o.Out)
+	if created != nil {
+		fmt.Fprintf(o.Out, "Created:     %s by %s\n", created.received.UTC().Format(TimeDefaultFormat), created.user)
+	}
+	if terminating == nil {
+		fmt.Fprintln(o.Out, "The namespace wasn't deleted within the audit logs.")
+		return nil
+	}
+	fmt.Fprintf(o.Out, "Terminating: %s by %s\n", terminating.received.UTC().Format(TimeDefaultFormat), terminating.user)
+	end := time.Time{}
+	if removed != nil {
+		end = removed.received
+		fmt.Fprintf(o.Out, "Removed:     %s by %s after %s\n", removed.received.UTC().Format(TimeDefaultFormat), removed.user,
+			removed.received.Sub(terminating.received).Round(time.Second))
+	} else {
+		fmt.Fprintln(o.Out, "Removed:     not within the audit logs, the namespace may still be terminating")
+	}
+
+	stats := map[string]*namespaceDeletes{}
+	for _, write := range deletes {
+		if write.received.Before(terminating.received) || !end.IsZero() && write.received.After(end) {
+			continue
+		}
+		key := write.resource + "\x00" + write.user
+		s, ok := stats[key]
+		if !ok {
+			s = &namespaceDeletes{resource: write.resource, user: write.user, first: write.received}
+			stats[key] = s
+		}
+		if write.verb == "deletecollection" {
+			s.deleteCollections++
+		} else {
+			s.deletes++
+		}
+		// not found means the object was already gone, which is expected while terminating
+		if !write.succeeded() && write.code != 404 {
+			s.failed++
+		}
+		s.last = write.received
+	}
+	if len(stats) == 0 {
+		fmt.Fprintln(o.Out, "No resources were deleted during the termination.")
+		return nil
+	}
+	result := make([]*namespaceDeletes, 0, len(stats))
+	for _, s := range stats {
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].first.Equal(result[j].first) {
+			return result[i].first.Before(result[j].first)
+		}
+		return result[i].resource+result[i].user < result[j].resource+result[j].user
+	})
+
+	fmt.Fprintln(o.Out)
+	fmt.Fprintln(o.Out, "Deleted during the termination:")
+	w = tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RESOURCE\tUSER\tDELETECOLLECTIONS\tDELETES\tFAILED\tFIRST\tLAST")
+	for _, s := range result {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%s\t%s\n", MatrixKey(s.resource), s.user, s.deleteCollections, s.deletes, s.failed,
+			s.first.UTC().Format(TimeDefaultFormat), s.last.UTC().Format(TimeDefaultFormat))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,116 @@
+package query
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/natamm4/audit-tool/pkg/audit/source"
+)
+
+// nodeMapping assigns the audit files matching the glob pattern to the node.
+type nodeMapping struct {
+	pattern string
+	node    string
+}
+
+// nodeNamer derives the node that wrote an audit file with the --node-map and --node-pattern rules. Files no rule
+// matches are named by the source or by the part of their name before -audit, like without rules.
+type nodeNamer struct {
+	mappings []nodeMapping
+	pattern  *regexp.Regexp
+	// group is the index of the capture group of the pattern holding the node name
+	group int
+}
+
+// newNodeNamer returns the rules of the flags, nil when neither is set.
+func newNodeNamer(pattern, mapFile string) (*nodeNamer, error) {
+	if len(pattern) == 0 && len(mapFile) == 0 {
+		return nil, nil
+	}
+	namer := &nodeNamer{}
+	if len(pattern) > 0 {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --node-pattern %q: %v", pattern, err)
+		}
+		if re.NumSubexp() == 0 {
+			return nil, fmt.Errorf("invalid --node-pattern %q: a capture group must match the node name", pattern)
+		}
+		namer.pattern, namer.group = re, 1
+		if i := re.SubexpIndex("node"); i > 0 {
+			namer.group = i
+		}
+	}
+	if len(mapFile) > 0 {
+		mappings, err := readNodeMap(mapFile)
+		if err != nil {
+			return nil, err
+		}
+		namer.mappings = mappings
+	}
+	return namer, nil
+}
+
+// readNodeMap reads a --node-map file: a line per glob pattern of audit files and the node they belong to, separated
+// by whitespace, eg. 'ip-10-0-1-23*.log master-0'. Empty lines and lines starting with # are skipped.
+func readNodeMap(path string) ([]nodeMapping, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read --node-map: %v", err)
+	}
+	defer f.Close()
+
+	mappings := []nodeMapping{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid --node-map %s:%d: must be a file pattern and a node name", path, line)
+		}
+		if _, err := filepath.Match(fields[0], ""); err != nil {
+			return nil, fmt.Errorf("invalid --node-map %s:%d: %v", path, line, err)
+		}
+		mappings = append(mappings, nodeMapping{pattern: fields[0], node: fields[1]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read --node-map: %v", err)
+	}
+	return mappings, nil
+}
+
+// node returns the node that wrote the file and false when it isn't an audit file. The mappings are matched against
+// the name and the path of the file in the order of the map file and take precedence over the pattern, which is matched
+// against the path.
+func (n *nodeNamer) node(f source.File) (string, bool) {
+	if n != nil {
+		for _, mapping := range n.mappings {
+			if ok, _ := filepath.Match(mapping.pattern, f.Name); ok {
+				return mapping.node, true
+			}
+			if ok, _ := filepath.Match(mapping.pattern, f.Path); ok {
+				return mapping.node, true
+			}
+		}
+		if n.pattern != nil {
+			if m := n.pattern.FindStringSubmatch(f.Path); m != nil && len(m[n.group]) > 0 {
+				return m[n.group], true
+			}
+		}
+	}
+	if len(f.Node) > 0 {
+		return f.Node, true
+	}
+	// audit files only
+	if !strings.Contains(f.Name, "-audit") {
+		return "", false
+	}
+	return strings.Split(f.Name, "-audit")[0], true
+}
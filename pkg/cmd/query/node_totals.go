@@ -0,0 +1,59 @@
+package query
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// queriedNodes returns the sorted nodes the query read the audit files of, all nodes without --nodes.
+func (o Options) queriedNodes() []string {
+	if len(o.nodes) == 0 {
+		return o.nodeNames.List()
+	}
+	return sets.NewString(o.nodes...).List()
+}
+
+// printNodeTotals writes the events matching the query by the node they were read from, when several nodes were
+// queried. Every API server usually serves a similar share of the requests, nodes with less than half of the even share
+// are marked, they may be missing audit files or have been out of rotation.
+func (o Options) printNodeTotals(writer io.Writer) {
+	nodes := o.queriedNodes()
+	if o.status == nil || len(nodes) < 2 {
+		return
+	}
+	counts := o.status.nodeCounts()
+	var total int64
+	for _, count := range counts {
+		total += count
+	}
+	if total == 0 {
+		return
+	}
+
+	w := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "\nNODE\tEVENTS\tSHARE\t")
+	even := float64(total) / float64(len(nodes))
+	for _, node := range nodes {
+		count := counts[node]
+		note := ""
+		switch {
+		case count == 0:
+			note = "no events"
+		case float64(count) < even/2:
+			note = "below half of the even share"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%.1f%%\t%s\n", node, count, float64(count)*100/float64(total), note)
+	}
+}
+
+// withNodeTotals prints the events by node after the output of an aggregation, unless it failed.
+func (o Options) withNodeTotals(err error) error {
+	if err == nil {
+		o.printNodeTotals(o.Out)
+	}
+	return err
+}
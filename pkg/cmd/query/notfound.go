@@ -0,0 +1,113 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+)
+
+type NotFoundOptions struct {
+	by       string
+	minCount int64
+	limit    int
+
+	// queryOptions selects and filters the events to analyze
+	queryOptions Options
+
+	genericclioptions.IOStreams
+}
+
+func NewNotFoundCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &NotFoundOptions{IOStreams: streams, queryOptions: Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "notfound --dir DIR",
+		Short: "Report clients repeatedly getting the same non-existent object",
+		Long: "Report clients repeatedly getting the same non-existent object, the get requests answered with 404 grouped by\n" +
+			"client and URI. A high rate usually indicates a broken informer or a misconfigured operator polling for an\n" +
+			"object that is never going to exist.",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	cmd.Flags().StringVar(&options.queryOptions.from, "from", "", "Only analyze events starting at this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().StringVar(&options.queryOptions.to, "to", "", "Only analyze events before this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().IntVar(&options.queryOptions.maxEventSize, "max-event-size", defaultMaxEventSize, "Maximum size in bytes of a single audit event.")
+	cmd.Flags().StringVar(&options.by, "by", "useragent", "Identify the clients by this field ("+strings.Join(topDimensions(), ", ")+").")
+	cmd.Flags().Int64Var(&options.minCount, "min-count", 10, "Only report clients getting the same non-existent object at least this many times.")
+	cmd.Flags().IntVar(&options.limit, "limit", 20, "Number of client and URI pairs with the most requests to display.")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *NotFoundOptions) Validate() error {
+	if len(o.queryOptions.targetDirectories) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	return validateTopBy(o.by)
+}
+
+func (o *NotFoundOptions) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+
+	clientFunc := topKeyFuncs[o.by]
+	seen := occurrences{}
+	if err := o.queryOptions.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			if e.Verb != "get" || e.Stage != auditv1.StageResponseComplete || e.ResponseStatus == nil || e.ResponseStatus.Code != 404 {
+				continue
+			}
+			seen.add([]string{clientFunc(e), e.RequestURI}, e.RequestReceivedTimestamp.Time)
+		}
+	}); err != nil {
+		return err
+	}
+
+	loops := []*occurrence{}
+	for _, s := range seen {
+		if s.count >= o.minCount {
+			loops = append(loops, s)
+		}
+	}
+	if len(loops) == 0 {
+		fmt.Fprintf(o.Out, "No client got the same non-existent object %d times or more.\n", o.minCount)
+		return nil
+	}
+	sort.Slice(loops, func(i, j int) bool {
+		if loops[i].count != loops[j].count {
+			return loops[i].count > loops[j].count
+		}
+		return strings.Join(loops[i].key, ",") < strings.Join(loops[j].key, ",")
+	})
+
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "REQUESTS\tPER MINUTE\tFIRST\tLAST\t%s\tURI\n", strings.ToUpper(o.by))
+	for i, s := range loops {
+		if o.limit > 0 && i >= o.limit {
+			break
+		}
+		perMinute := float64(s.count)
+		if minutes := s.last.Sub(s.first).Minutes(); minutes > 1 {
+			perMinute /= minutes
+		}
+		fmt.Fprintf(w, "%d\t%.1f\t%s\t%s\t%s\t%s\n", s.count, perMinute, s.first.Format(TimeDefaultFormat), s.last.Format(TimeDefaultFormat),
+			MatrixKey(s.key[0]), s.key[1])
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/resource"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/filter"
+)
+
+type PaginationOptions struct {
+	limit    int
+	minItems int64
+
+	// queryOptions selects and filters the events to analyze
+	queryOptions Options
+
+	genericclioptions.IOStreams
+}
+
+func NewPaginationCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &PaginationOptions{IOStreams: streams, queryOptions: Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "pagination --dir DIR",
+		Short: "Rank the clients issuing unpaginated LISTs of large collections",
+		Long: "Rank the clients issuing unpaginated LISTs of large collections. A LIST without the limit parameter and with\n" +
+			"an empty resourceVersion is served from etcd in a single response. The response size is taken from the\n" +
+			"response object (RequestResponse level) or from an annotation ending with response-size/items when available.",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	cmd.Flags().StringVar(&options.queryOptions.from, "from", "", "Only analyze events starting at this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().StringVar(&options.queryOptions.to, "to", "", "Only analyze events before this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().IntVar(&options.queryOptions.maxEventSize, "max-event-size", defaultMaxEventSize, "Maximum size in bytes of a single audit event.")
+	cmd.Flags().IntVar(&options.limit, "limit", 20, "Number of worst offenders to display.")
+	cmd.Flags().Int64Var(&options.minItems, "min-items", 0, "Ignore LISTs known to return less items than this. LISTs of unknown size are always included.")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *PaginationOptions) Validate() error {
+	if len(o.queryOptions.targetDirectories) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	return nil
+}
+
+// unpaginatedList reports whether the event is a LIST served from etcd in a single response.
+func unpaginatedList(e *auditv1.Event) bool {
+	if e.Verb != "list" {
+		return false
+	}
+	query := filter.ParseURIQuery(e.RequestURI)
+	if query.Watch {
+		return false
+	}
+	return query.Limit <= 0 && len(query.ResourceVersion) == 0
+}
+
+// listResponseSize returns the number of items and bytes of the response, -1 when unknown.
+func listResponseSize(e *auditv1.Event) (int64, int64) {
+	items, size := int64(-1), int64(-1)
+	for key, value := range e.Annotations {
+		key = strings.ToLower(key)
+		switch {
+		case strings.HasSuffix(key, "response-size"):
+			if quantity, err := resource.ParseQuantity(value); err == nil {
+				size = quantity.Value()
+			}
+		case strings.HasSuffix(key, "items"):
+			if count, err := strconv.ParseInt(value, 10, 64); err == nil {
+				items = count
+			}
+		}
+	}
+	if e.ResponseObject == nil || len(e.ResponseObject.Raw) == 0 {
+		return items, size
+	}
+	if size < 0 {
+		size = int64(len(e.ResponseObject.Raw))
+	}
+	if items < 0 {
+		list := struct {
+			Items []json.RawMessage `json:"items"`
+		}{}
+		if err := json.Unmarshal(e.ResponseObject.Raw, &list); err == nil {
+			items = int64(len(list.Items))
+		}
+	}
+	return items, size
+}
+
+type paginationStats struct {
+	user        string
+	resource    string
+	lists       int64
+	clusterWide int64
+	sized       int64
+	totalItems  int64
+	maxItems    int64
+	totalSize   int64
+}
+
+func (o *PaginationOptions) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+
+	stats := map[string]*paginationStats{}
+	if err := o.queryOptions.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			if e.Stage != auditv1.StageResponseComplete || !unpaginatedList(e) {
+				continue
+			}
+			items, size := listResponseSize(e)
+			if items >= 0 && items < o.minItems {
+				continue
+			}
+			ns, gvr, _, _ := filter.URIToParts(e.RequestURI)
+			if len(gvr.Resource) == 0 {
+				continue
+			}
+			resource := gvr.GroupResource().String()
+			key := e.User.Username + "\x00" + resource
+			s, ok := stats[key]
+			if !ok {
+				s = &paginationStats{user: e.User.Username, resource: resource}
+				stats[key] = s
+			}
+			s.lists++
+			if len(ns) == 0 {
+				s.clusterWide++
+			}
+			if items >= 0 {
+				s.sized++
+				s.totalItems += items
+				if items > s.maxItems {
+					s.maxItems = items
+				}
+			}
+			if size > 0 {
+				s.totalSize += size
+			}
+		}
+	}); err != nil {
+		return err
+	}
+
+	result := []*paginationStats{}
+	for _, s := range stats {
+		result = append(result, s)
+	}
+	// the items served are the best measure of the cost, the number of lists is used when the sizes are unknown
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].totalItems != result[j].totalItems {
+			return result[i].totalItems > result[j].totalItems
+		}
+		if result[i].totalSize != result[j].totalSize {
+			return result[i].totalSize > result[j].totalSize
+		}
+		return result[i].lists > result[j].lists
+	})
+
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "USER\tRESOURCE\tUNPAGINATED LISTS\tCLUSTER-WIDE\tAVG ITEMS\tMAX ITEMS\tTOTAL SIZE")
+	for i, s := range result {
+		if o.limit > 0 && i >= o.limit {
+			break
+		}
+		avgItems, maxItems, totalSize := "?", "?", "?"
+		if s.sized > 0 {
+			avgItems = strconv.FormatInt(s.totalItems/s.sized, 10)
+			maxItems = strconv.FormatInt(s.maxItems, 10)
+		}
+		if s.totalSize > 0 {
+			totalSize = resource.NewQuantity(s.totalSize, resource.BinarySI).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\t%s\n", s.user, MatrixKey(s.resource), s.lists, s.clusterWide, avgItems, maxItems, totalSize)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/filter"
+)
+
+// patchTypeColumns are the patch types in the order of the report columns.
+var patchTypeColumns = []string{filter.PatchTypeJSON, filter.PatchTypeMerge, filter.PatchTypeStrategicMerge, filter.PatchTypeApply, filter.PatchTypeUnknown}
+
+type PatchesOptions struct {
+	limit int
+
+	// queryOptions selects and filters the events to analyze
+	queryOptions Options
+
+	genericclioptions.IOStreams
+}
+
+func NewPatchesCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &PatchesOptions{IOStreams: streams, queryOptions: Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "patches --dir DIR",
+		Short: "Break down PATCH requests per user and resource by patch type (JSON, merge, strategic merge, apply)",
+		Long: "Break down PATCH requests per user and resource by patch type (JSON, merge, strategic merge, apply). The patch\n" +
+			"type is inferred from the request object, patch requests logged below the Request level are counted as unknown.",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	cmd.Flags().StringVar(&options.queryOptions.from, "from", "", "Only analyze events starting at this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().StringVar(&options.queryOptions.to, "to", "", "Only analyze events before this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().IntVar(&options.queryOptions.maxEventSize, "max-event-size", defaultMaxEventSize, "Maximum size in bytes of a single audit event.")
+	cmd.Flags().IntVar(&options.limit, "limit", 20, "Number of user and resource pairs with the most patches to display.")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *PatchesOptions) Validate() error {
+	if len(o.queryOptions.targetDirectories) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	return nil
+}
+
+type patchStats struct {
+	user     string
+	resource string
+	total    int64
+	byType   map[string]int64
+}
+
+func (o *PatchesOptions) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+
+	stats := map[string]*patchStats{}
+	totals := map[string]int64{}
+	var total int64
+	if err := o.queryOptions.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			// the RequestReceived event of the same request would count it twice
+			if e.Stage == auditv1.StageRequestReceived {
+				continue
+			}
+			patchType := filter.PatchType(e)
+			if len(patchType) == 0 {
+				continue
+			}
+			_, gvr, _, _ := filter.URIToParts(e.RequestURI)
+			resource := gvr.GroupResource().String()
+			key := e.User.Username + "\x00" + resource
+			s, ok := stats[key]
+			if !ok {
+				s = &patchStats{user: e.User.Username, resource: resource, byType: map[string]int64{}}
+				stats[key] = s
+			}
+			s.total++
+			s.byType[patchType]++
+			totals[patchType]++
+			total++
+		}
+	}); err != nil {
+		return err
+	}
+
+	result := []*patchStats{}
+	for _, s := range stats {
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].total != result[j].total {
+			return result[i].total > result[j].total
+		}
+		return result[i].user+result[i].resource < result[j].user+result[j].resource
+	})
+
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "USER\tRESOURCE\tPATCHES\tJSON\tMERGE\tSTRATEGIC-MERGE\tAPPLY\tUNKNOWN")
+	for i, s := range result {
+		if o.limit > 0 && i >= o.limit {
+			break
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d", s.user, MatrixKey(s.resource), s.total)
+		for _, patchType := range patchTypeColumns {
+			fmt.Fprintf(w, "\t%d", s.byType[patchType])
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "TOTAL\t\t%d", total)
+	for _, patchType := range patchTypeColumns {
+		fmt.Fprintf(w, "\t%d", totals[patchType])
+	}
+	fmt.Fprintln(w)
+	return nil
+}
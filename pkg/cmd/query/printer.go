@@ -0,0 +1,241 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+
+	"github.com/natamm4/audit-tool/pkg/audit/filter"
+)
+
+const (
+	// defaultOutput is the output format used when -o isn't given.
+	defaultOutput = "default"
+	// wideOutput is the default output with the stage, audit ID, object and source IPs of every event.
+	wideOutput = "wide"
+)
+
+// Printer prints the events selected by a query in an output format.
+type Printer interface {
+	Print(ctx context.Context, events *Events) error
+}
+
+// PrinterFunc adapts a function to the Printer interface.
+type PrinterFunc func(ctx context.Context, events *Events) error
+
+func (f PrinterFunc) Print(ctx context.Context, events *Events) error {
+	return f(ctx, events)
+}
+
+// PrinterOptions are the options of the query a printer is created with.
+type PrinterOptions struct {
+	genericclioptions.IOStreams
+
+	// Limit is the maximum number of events to print, 0 when not limited
+	Limit int64
+	// Flags are the printer specific options given with --output-flags
+	Flags map[string]string
+
+	query Options
+}
+
+// CheckFlags returns an error when --output-flags sets an option not in allowed.
+func (o PrinterOptions) CheckFlags(allowed ...string) error {
+	unknown := sets.StringKeySet(o.Flags).Difference(sets.NewString(allowed...))
+	if unknown.Len() == 0 {
+		return nil
+	}
+	if len(allowed) == 0 {
+		return fmt.Errorf("the output format has no --output-flags, got %s", strings.Join(unknown.List(), ", "))
+	}
+	return fmt.Errorf("unknown --output-flags %s, must be one of %s", strings.Join(unknown.List(), ", "), strings.Join(allowed, ", "))
+}
+
+// BoolFlag returns the boolean value of the --output-flags option, or defaultValue when it isn't set.
+func (o PrinterOptions) BoolFlag(name string, defaultValue bool) (bool, error) {
+	value, ok := o.Flags[name]
+	if !ok {
+		return defaultValue, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid --output-flags %s=%s: %v", name, value, err)
+	}
+	return b, nil
+}
+
+// PrinterFactory creates the printer of an output format, it returns an error for invalid options.
+type PrinterFactory func(options PrinterOptions) (Printer, error)
+
+var printers = map[string]PrinterFactory{}
+
+// RegisterPrinter makes the printer available to -o under the name. It is meant to be called from init functions
+// and panics when the name is registered twice.
+func RegisterPrinter(name string, factory PrinterFactory) {
+	if _, ok := printers[name]; ok {
+		panic(fmt.Sprintf("printer %q is already registered", name))
+	}
+	printers[name] = factory
+}
+
+// PrinterNames returns the sorted names of the registered printers.
+func PrinterNames() []string {
+	return sets.StringKeySet(printers).List()
+}
+
+// Events are the events selected by the query, they are only read when the printer visits or lists them.
+type Events struct {
+	query   Options
+	filters filter.AuditFilters
+}
+
+// Visit calls visit for the events one batch at a time. The events are reused once visit returns, they must be
+// copied to be kept.
+func (e *Events) Visit(ctx context.Context, visit func([]*auditv1.Event)) error {
+	return e.query.MultiNodeEventVisitor(ctx, e.filters, true, visit)
+}
+
+// List returns all events, it needs them all in memory.
+func (e *Events) List(ctx context.Context) ([]*auditv1.Event, error) {
+	return e.query.multiNodeEventDecoder(ctx, e.filters)
+}
+
+// newPrinter creates the printer of the -o output format.
+func (o Options) newPrinter() (Printer, error) {
+	name := o.output
+	if len(name) == 0 {
+		name = defaultOutput
+	}
+	factory, ok := printers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown output format %q, must be one of %s", o.output, strings.Join(PrinterNames(), ", "))
+	}
+	printer, err := factory(PrinterOptions{IOStreams: o.IOStreams, Limit: o.limit, Flags: o.outputFlags, query: o})
+	if err != nil {
+		return nil, fmt.Errorf("invalid output format %q: %v", name, err)
+	}
+	return printer, nil
+}
+
+// eventWriterPrinter returns the factory of a printer streaming the events to the writer. Aggregating writers get all
+// events regardless of --limit.
+func eventWriterPrinter(newWriter func(w io.Writer) EventWriter, aggregated bool) PrinterFactory {
+	return func(options PrinterOptions) (Printer, error) {
+		if err := options.CheckFlags(); err != nil {
+			return nil, err
+		}
+		return streamPrinter(options, newWriter, aggregated), nil
+	}
+}
+
+func streamPrinter(options PrinterOptions, newWriter func(w io.Writer) EventWriter, aggregated bool) Printer {
+	limit := options.Limit
+	if aggregated {
+		limit = 0
+	}
+	return PrinterFunc(func(ctx context.Context, events *Events) error {
+		_, err := events.query.WriteEvents(ctx, events.filters, newWriter(options.Out), limit)
+		return err
+	})
+}
+
+func init() {
+	RegisterPrinter(defaultOutput, func(options PrinterOptions) (Printer, error) {
+		if err := options.CheckFlags(); err != nil {
+			return nil, err
+		}
+		return PrinterFunc(func(ctx context.Context, events *Events) error {
+			return events.query.printEvents(ctx, events.filters, nil)
+		}), nil
+	})
+	RegisterPrinter(wideOutput, func(options PrinterOptions) (Printer, error) {
+		if err := options.CheckFlags(); err != nil {
+			return nil, err
+		}
+		return PrinterFunc(func(ctx context.Context, events *Events) error {
+			return events.query.printEvents(ctx, events.filters, wideDetails)
+		}), nil
+	})
+	RegisterPrinter("top", func(options PrinterOptions) (Printer, error) {
+		if err := options.CheckFlags("layout"); err != nil {
+			return nil, err
+		}
+		if len(options.query.groupBy) > 0 {
+			if err := validateGroupBy(options.query.groupBy, options.query.topBy); err != nil {
+				return nil, err
+			}
+		} else if err := validateTopBy(options.query.topBy); err != nil {
+			return nil, err
+		}
+		layout, err := groupByLayout(options)
+		if err != nil {
+			return nil, err
+		}
+		if err := validateSparkline(options.query); err != nil {
+			return nil, err
+		}
+		return PrinterFunc(func(ctx context.Context, events *Events) error {
+			return events.query.withNodeTotals(events.query.runTop(ctx, events.filters, layout))
+		}), nil
+	})
+	RegisterPrinter("matrix", func(options PrinterOptions) (Printer, error) {
+		if err := options.CheckFlags(); err != nil {
+			return nil, err
+		}
+		if err := validateMatrixDimensions(options.query.matrixRows, options.query.matrixCols); err != nil {
+			return nil, err
+		}
+		return PrinterFunc(func(ctx context.Context, events *Events) error {
+			return events.query.withNodeTotals(events.query.runMatrix(ctx, events.filters))
+		}), nil
+	})
+	RegisterPrinter("firstlast", func(options PrinterOptions) (Printer, error) {
+		if err := options.CheckFlags(); err != nil {
+			return nil, err
+		}
+		if err := validateFirstLastBy(options.query.topBy, options.query.groupBy); err != nil {
+			return nil, err
+		}
+		if err := validateSparkline(options.query); err != nil {
+			return nil, err
+		}
+		return PrinterFunc(func(ctx context.Context, events *Events) error {
+			return events.query.withNodeTotals(events.query.runFirstLast(ctx, events.filters))
+		}), nil
+	})
+
+	RegisterPrinter(outputCSV, func(options PrinterOptions) (Printer, error) {
+		if err := options.CheckFlags("header"); err != nil {
+			return nil, err
+		}
+		header, err := options.BoolFlag("header", true)
+		if err != nil {
+			return nil, err
+		}
+		return streamPrinter(options, func(w io.Writer) EventWriter {
+			return newCSVWriter(w, header)
+		}, false), nil
+	})
+	RegisterPrinter(outputJSON, eventWriterPrinter(NewEventListWriter, false))
+	RegisterPrinter(outputAuditLog, eventWriterPrinter(NewAuditLogWriter, false))
+	RegisterPrinter(outputNDJSON, func(options PrinterOptions) (Printer, error) {
+		if err := options.CheckFlags("envelope"); err != nil {
+			return nil, err
+		}
+		envelope, err := options.BoolFlag("envelope", false)
+		if err != nil {
+			return nil, err
+		}
+		return streamPrinter(options, func(w io.Writer) EventWriter {
+			return newNDJSONWriter(w, envelope)
+		}, false), nil
+	})
+	RegisterPrinter(outputOpenMetricsCount, eventWriterPrinter(NewOpenMetricsCountWriter, true))
+	RegisterPrinter(outputOpenMetricsTime, eventWriterPrinter(newOpenMetricsTimeWriter, true))
+}
@@ -0,0 +1,164 @@
+package query
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	phaseOpen   = "open"
+	phaseDecode = "decode"
+	phaseFilter = "filter"
+	phaseOutput = "output"
+	phaseSort   = "sort"
+)
+
+// profilePhases are the phases of a query in the order they are printed. open covers downloading and the decompression
+// headers, decode reading and unmarshalling the lines, output the aggregation or printing of the matching events.
+var profilePhases = []string{phaseOpen, phaseDecode, phaseFilter, phaseOutput, phaseSort}
+
+// activeProfile collects the diagnostics of the query with --profile-query, nil otherwise. The scanning of the audit
+// files isn't tied to the options of a query, so it is shared like the count of the corrupt lines.
+var activeProfile *queryProfile
+
+// queryProfile counts the audit files a query read or skipped and the time spent in every phase, to tell why a query
+// is slow: the time range or the index not narrowing the files down, the decoding or an expensive filter.
+type queryProfile struct {
+	lock sync.Mutex
+
+	start time.Time
+
+	files          int
+	skippedByTime  int
+	skippedByIndex int
+	skippedByCache int
+	decodedFiles   int
+	lines          int64
+	decodedEvents  int64
+	matchedEvents  int64
+	phases         map[string]time.Duration
+	peakHeap       uint64
+}
+
+func newQueryProfile() *queryProfile {
+	return &queryProfile{start: time.Now(), phases: map[string]time.Duration{}}
+}
+
+// now returns the start of a phase, the zero time when the query isn't profiled.
+func (p *queryProfile) now() time.Time {
+	if p == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// since adds the time since start to the phase and returns the end of it, the start of the next phase.
+func (p *queryProfile) since(phase string, start time.Time) time.Time {
+	if p == nil {
+		return start
+	}
+	now := time.Now()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.phases[phase] += now.Sub(start)
+	return now
+}
+
+// file records an audit file of the requested nodes, skipped by the time range or the index or decoded.
+func (p *queryProfile) file(skippedByTime, skippedByIndex bool) {
+	if p == nil {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.files++
+	switch {
+	case skippedByTime:
+		p.skippedByTime++
+	case skippedByIndex:
+		p.skippedByIndex++
+	default:
+		p.decodedFiles++
+	}
+}
+
+// cached records an audit file not decoded because a previous run of the query found no matching event in it.
+func (p *queryProfile) cached() {
+	if p == nil {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.skippedByCache++
+	p.decodedFiles--
+}
+
+func (p *queryProfile) batch(lines int, decoded, matched int) {
+	if p == nil {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.lines += int64(lines)
+	p.decodedEvents += int64(decoded)
+	p.matchedEvents += int64(matched)
+}
+
+// sampleMemory records the heap in use when it is the largest so far. It is sampled once per audit file, reading the
+// memory statistics stops the world.
+func (p *queryProfile) sampleMemory() {
+	if p == nil {
+		return
+	}
+	stats := runtime.MemStats{}
+	runtime.ReadMemStats(&stats)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if stats.HeapAlloc > p.peakHeap {
+		p.peakHeap = stats.HeapAlloc
+	}
+}
+
+func (p *queryProfile) print(w io.Writer) {
+	p.sampleMemory()
+	stats := runtime.MemStats{}
+	runtime.ReadMemStats(&stats)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	defer tw.Flush()
+	fmt.Fprintln(tw, "QUERY PROFILE")
+	fmt.Fprintf(tw, "files considered:\t%d\n", p.files)
+	fmt.Fprintf(tw, "  skipped by time range:\t%d\n", p.skippedByTime)
+	fmt.Fprintf(tw, "  skipped by index:\t%d\n", p.skippedByIndex)
+	fmt.Fprintf(tw, "  skipped by cache:\t%d\n", p.skippedByCache)
+	fmt.Fprintf(tw, "  decoded:\t%d\n", p.decodedFiles)
+	fmt.Fprintf(tw, "lines read:\t%d\n", p.lines)
+	fmt.Fprintf(tw, "events decoded:\t%d\n", p.decodedEvents)
+	fmt.Fprintf(tw, "events matched:\t%d\n", p.matchedEvents)
+	for _, phase := range profilePhases {
+		fmt.Fprintf(tw, "%s time:\t%s\n", phase, p.phases[phase].Round(time.Microsecond))
+	}
+	fmt.Fprintf(tw, "total time:\t%s\n", time.Since(p.start).Round(time.Microsecond))
+	fmt.Fprintf(tw, "peak heap:\t%s\n", formatBytes(p.peakHeap))
+	fmt.Fprintf(tw, "memory from the OS:\t%s\n", formatBytes(stats.Sys))
+	fmt.Fprintf(tw, "garbage collections:\t%d\n", stats.NumGC)
+}
+
+func formatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
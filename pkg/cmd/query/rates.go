@@ -0,0 +1,103 @@
+package query
+
+import (
+	"fmt"
+	"time"
+
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+
+	"github.com/natamm4/audit-tool/pkg/audit/topk"
+)
+
+// aggregateRates computes the --show-rates columns of the aggregation tables: the share of all requests, the requests
+// per second over the covered window and the share of failed requests (4xx and 5xx) of a row. Requests are counted by
+// their last event only, the events of their other stages would count them several times.
+type aggregateRates struct {
+	total       int64
+	first, last time.Time
+	// requests and errors count the requests and the failed requests per key, with the same estimation as the counts
+	// of the rows
+	requests topk.Counter
+	errors   topk.Counter
+}
+
+func newAggregateRates(requests, errors topk.Counter) *aggregateRates {
+	return &aggregateRates{requests: requests, errors: errors}
+}
+
+// completedRequest returns whether the event is the last one of its request, the ResponseComplete event or the Panic
+// event when the API server panicked while serving it.
+func completedRequest(e *auditv1.Event) bool {
+	return e.Stage == auditv1.StageResponseComplete || e.Stage == auditv1.StagePanic
+}
+
+func (r *aggregateRates) add(e *auditv1.Event, key string) {
+	if !completedRequest(e) {
+		return
+	}
+	r.total++
+	r.requests.Add(key)
+	received := e.RequestReceivedTimestamp.Time
+	if r.first.IsZero() || received.Before(r.first) {
+		r.first = received
+	}
+	if received.After(r.last) {
+		r.last = received
+	}
+	if e.Stage == auditv1.StagePanic || (e.ResponseStatus != nil && e.ResponseStatus.Code >= 400) {
+		r.errors.Add(key)
+	}
+}
+
+// complete sets the covered window to --from and --to when they are given, the requests per second of a query
+// selecting a day are over the whole day, not between its first and last event.
+func (r *aggregateRates) complete(from, to string) {
+	if len(from) > 0 {
+		r.first = ParseTime(from)
+	}
+	if len(to) > 0 {
+		r.last = ParseTime(to)
+	}
+}
+
+// counts returns the requests and the failed requests of the key, false when they are unknown because the estimation
+// dropped it.
+func (r *aggregateRates) counts(key string) (int64, int64, bool) {
+	requests, requestsKnown := estimatedCount(r.requests, key)
+	errors, errorsKnown := estimatedCount(r.errors, key)
+	return requests, errors, requestsKnown && errorsKnown
+}
+
+// estimatedCount returns the count of the key, false when it is unknown because the estimation dropped it.
+func estimatedCount(counter topk.Counter, key string) (int64, bool) {
+	item, ok := counter.Get(key)
+	if ok {
+		return item.Count, true
+	}
+	if spaceSaving, estimated := counter.(*topk.SpaceSaving); estimated && spaceSaving.Saturated() > 0 {
+		return 0, false
+	}
+	return 0, true
+}
+
+func aggregateRateHeader() []string {
+	return []string{"SHARE", "REQ/S", "ERRORS"}
+}
+
+// columns returns the rate columns of a row with requests requests, errors of them failed.
+func (r *aggregateRates) columns(requests, errors int64, known bool) []string {
+	share, rate, errorRate := "-", "-", "-"
+	if !known {
+		return []string{share, rate, errorRate}
+	}
+	if r.total > 0 {
+		share = fmt.Sprintf("%.1f%%", float64(requests)*100/float64(r.total))
+	}
+	if window := r.last.Sub(r.first).Seconds(); window > 0 {
+		rate = fmt.Sprintf("%.3f", float64(requests)/window)
+	}
+	if requests > 0 {
+		errorRate = fmt.Sprintf("%.1f%%", float64(errors)*100/float64(requests))
+	}
+	return []string{share, rate, errorRate}
+}
@@ -0,0 +1,229 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/filter"
+)
+
+type ReconcileLoopsOptions struct {
+	by          string
+	minUpdates  int
+	hotInterval time.Duration
+	limit       int
+
+	// queryOptions selects and filters the events to analyze
+	queryOptions Options
+
+	genericclioptions.IOStreams
+}
+
+func NewReconcileLoopsCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &ReconcileLoopsOptions{IOStreams: streams, queryOptions: Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "reconcile-loops --dir DIR",
+		Short: "Report how often clients update the same objects, flagging hot reconcile loops",
+		Long: "Report how often clients update the same objects, flagging hot reconcile loops: objects a client updates\n" +
+			"with a median interval of --hot-interval or less. When the request objects are recorded (Request level and\n" +
+			"above) the writes that change nothing are counted as NO-OP: updates writing the same spec, data or status as\n" +
+			"the previous update of the client, and patches only modifying the metadata. A hot loop of no-op writes\n" +
+			"usually is a controller fighting another one or reconciling on its own writes.",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	cmd.Flags().StringVar(&options.queryOptions.from, "from", "", "Only analyze events starting at this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().StringVar(&options.queryOptions.to, "to", "", "Only analyze events before this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().IntVar(&options.queryOptions.maxEventSize, "max-event-size", defaultMaxEventSize, "Maximum size in bytes of a single audit event.")
+	cmd.Flags().StringVar(&options.by, "by", "useragent", "Client updating the objects, one of "+fmt.Sprint(topDimensions())+".")
+	cmd.Flags().IntVar(&options.minUpdates, "min-updates", 5, "Only report the objects a client updated at least this many times.")
+	cmd.Flags().DurationVar(&options.hotInterval, "hot-interval", 10*time.Second, "Flag the objects updated with a median interval of this or less as hot.")
+	cmd.Flags().IntVar(&options.limit, "limit", 20, "Number of objects to display.")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *ReconcileLoopsOptions) Validate() error {
+	if len(o.queryOptions.targetDirectories) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	if o.minUpdates < 2 {
+		return fmt.Errorf("--min-updates must be at least 2, the interval needs two updates")
+	}
+	return validateTopBy(o.by)
+}
+
+// writeChange tells what a write is known to change.
+type writeChange uint8
+
+const (
+	// writeUnknown is a write without its request object
+	writeUnknown writeChange = iota
+	// writeDigest is an update, which changes the content when its digest differs from the previous update
+	writeDigest
+	// writeNoop and writeContent are patches only modifying the metadata, or the content too
+	writeNoop
+	writeContent
+)
+
+type reconcileWrite struct {
+	received int64
+	change   writeChange
+	digest   uint64
+}
+
+// reconciledObject holds the successful writes of a client to an object.
+type reconciledObject struct {
+	client      string
+	resource    string
+	namespace   string
+	name        string
+	subresource string
+	writes      []reconcileWrite
+
+	medianInterval time.Duration
+	// known counts the writes whose change is known, noop the ones changing nothing
+	known int64
+	noop  int64
+}
+
+// analyze sorts the writes and computes the median interval between them and the writes changing nothing.
+func (r *reconciledObject) analyze() {
+	writes := r.writes
+	sort.Slice(writes, func(i, j int) bool { return writes[i].received < writes[j].received })
+	intervals := make([]int64, 0, len(writes)-1)
+	for i := range writes {
+		if i > 0 {
+			intervals = append(intervals, writes[i].received-writes[i-1].received)
+		}
+		switch writes[i].change {
+		case writeNoop:
+			r.known++
+			r.noop++
+		case writeContent:
+			r.known++
+		case writeDigest:
+			// an update can only be compared to a previous update, a patch in between may have changed the content
+			if i > 0 && writes[i-1].change == writeDigest {
+				r.known++
+				if writes[i].digest == writes[i-1].digest {
+					r.noop++
+				}
+			}
+		}
+	}
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i] < intervals[j] })
+	r.medianInterval = time.Duration(intervals[len(intervals)/2])
+}
+
+func (o *ReconcileLoopsOptions) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+
+	clientFunc := topKeyFuncs[o.by]
+	objects := map[string]*reconciledObject{}
+	if err := o.queryOptions.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			if e.Stage != auditv1.StageResponseComplete || e.ObjectRef == nil || len(e.ObjectRef.Name) == 0 {
+				continue
+			}
+			if e.Verb != "update" && e.Verb != "patch" {
+				continue
+			}
+			if e.ResponseStatus == nil || e.ResponseStatus.Code < 200 || e.ResponseStatus.Code >= 300 {
+				continue
+			}
+			client := clientFunc(e)
+			_, gvr, _, _ := filter.URIToParts(e.RequestURI)
+			resource := gvr.GroupResource().String()
+			key := strings.Join([]string{client, resource, e.ObjectRef.Namespace, e.ObjectRef.Name, e.ObjectRef.Subresource}, "\x00")
+			object, ok := objects[key]
+			if !ok {
+				object = &reconciledObject{client: client, resource: resource, namespace: e.ObjectRef.Namespace, name: e.ObjectRef.Name, subresource: e.ObjectRef.Subresource}
+				objects[key] = object
+			}
+			write := reconcileWrite{received: e.RequestReceivedTimestamp.UnixNano()}
+			if e.Verb == "patch" {
+				if changes, ok := filter.PatchChangesContent(e); ok && changes {
+					write.change = writeContent
+				} else if ok {
+					write.change = writeNoop
+				}
+			} else if digest, ok := filter.ContentDigest(e); ok {
+				write.change, write.digest = writeDigest, digest
+			}
+			object.writes = append(object.writes, write)
+		}
+	}); err != nil {
+		return err
+	}
+
+	result := []*reconciledObject{}
+	for _, object := range objects {
+		if len(object.writes) < o.minUpdates {
+			continue
+		}
+		object.analyze()
+		result = append(result, object)
+	}
+	if len(result) == 0 {
+		fmt.Fprintf(o.Out, "No objects updated %d times or more by the same %s found.\n", o.minUpdates, o.by)
+		return nil
+	}
+	sort.Slice(result, func(i, j int) bool {
+		iHot, jHot := result[i].medianInterval <= o.hotInterval, result[j].medianInterval <= o.hotInterval
+		if iHot != jHot {
+			return iHot
+		}
+		if len(result[i].writes) != len(result[j].writes) {
+			return len(result[i].writes) > len(result[j].writes)
+		}
+		return result[i].medianInterval < result[j].medianInterval
+	})
+
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "UPDATES\tPER MINUTE\tMEDIAN INTERVAL\tNO-OP\tHOT\t%s\tRESOURCE\tNAMESPACE\tNAME\n", strings.ToUpper(o.by))
+	for i, object := range result {
+		if o.limit > 0 && i >= o.limit {
+			break
+		}
+		perMinute := "-"
+		if active := time.Duration(object.writes[len(object.writes)-1].received - object.writes[0].received); active >= time.Minute {
+			perMinute = fmt.Sprintf("%.1f", float64(len(object.writes))/active.Minutes())
+		}
+		noop := "-"
+		if object.known > 0 {
+			noop = fmt.Sprintf("%d/%d", object.noop, object.known)
+		}
+		hot := ""
+		if object.medianInterval <= o.hotInterval {
+			hot = "yes"
+		}
+		resource := object.resource
+		if len(object.subresource) > 0 {
+			resource += "/" + object.subresource
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", len(object.writes), perMinute, object.medianInterval.Round(time.Millisecond), noop, hot,
+			MatrixKey(object.client), resource, MatrixKey(object.namespace), object.name)
+	}
+	return nil
+}
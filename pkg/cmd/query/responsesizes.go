@@ -0,0 +1,191 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/filter"
+	"github.com/natamm4/audit-tool/pkg/cmd/get"
+)
+
+type ResponseSizesOptions struct {
+	limit   int
+	largest int
+
+	// queryOptions selects and filters the events to analyze
+	queryOptions Options
+
+	genericclioptions.IOStreams
+}
+
+func NewResponseSizesCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &ResponseSizesOptions{IOStreams: streams, queryOptions: Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "response-sizes --dir DIR",
+		Short: "Report the bytes returned per user, verb and resource and the largest individual responses",
+		Long: "Report the bytes returned per user, verb and resource and the largest individual responses, to identify the\n" +
+			"clients pulling huge LISTs repeatedly. The response size is taken from an annotation ending with\n" +
+			"response-size when available, otherwise from the size of the response object, which is only recorded at the\n" +
+			"RequestResponse audit level. Responses of unknown size are counted as requests but not in the bytes.",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	cmd.Flags().StringVar(&options.queryOptions.from, "from", "", "Only analyze events starting at this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().StringVar(&options.queryOptions.to, "to", "", "Only analyze events before this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().IntVar(&options.queryOptions.maxEventSize, "max-event-size", defaultMaxEventSize, "Maximum size in bytes of a single audit event.")
+	cmd.Flags().IntVar(&options.limit, "limit", 20, "Number of users, verbs and resources with the most bytes returned to display.")
+	cmd.Flags().IntVar(&options.largest, "largest", 10, "Number of largest individual responses to display.")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *ResponseSizesOptions) Validate() error {
+	if len(o.queryOptions.targetDirectories) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	return nil
+}
+
+type responseSizeStats struct {
+	user      string
+	verb      string
+	resource  string
+	requests  int64
+	sized     int64
+	totalSize int64
+	maxSize   int64
+}
+
+// largeResponse is an individual response, the events are recycled so only the printed fields are kept.
+type largeResponse struct {
+	received string
+	user     string
+	verb     string
+	uri      string
+	size     int64
+	items    int64
+}
+
+func (o *ResponseSizesOptions) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+
+	stats := map[string]*responseSizeStats{}
+	largest := []largeResponse{}
+	if err := o.queryOptions.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			if e.Stage != auditv1.StageResponseComplete {
+				continue
+			}
+			_, gvr, _, _ := filter.URIToParts(e.RequestURI)
+			resource := gvr.GroupResource().String()
+			if len(gvr.Resource) == 0 {
+				resource = "<non-resource>"
+			}
+			key := e.User.Username + "\x00" + e.Verb + "\x00" + resource
+			s, ok := stats[key]
+			if !ok {
+				s = &responseSizeStats{user: e.User.Username, verb: e.Verb, resource: resource}
+				stats[key] = s
+			}
+			s.requests++
+
+			items, size := listResponseSize(e)
+			if size < 0 {
+				continue
+			}
+			s.sized++
+			s.totalSize += size
+			if size > s.maxSize {
+				s.maxSize = size
+			}
+
+			// keep the largest responses sorted, the slice never grows beyond --largest
+			if o.largest <= 0 || len(largest) == o.largest && size <= largest[len(largest)-1].size {
+				continue
+			}
+			if e.Verb != "list" {
+				items = -1
+			}
+			response := largeResponse{
+				received: e.RequestReceivedTimestamp.UTC().Format(TimeDefaultFormat),
+				user:     e.User.Username,
+				verb:     e.Verb,
+				uri:      e.RequestURI,
+				size:     size,
+				items:    items,
+			}
+			i := sort.Search(len(largest), func(i int) bool { return largest[i].size < size })
+			largest = append(largest, largeResponse{})
+			copy(largest[i+1:], largest[i:])
+			largest[i] = response
+			if len(largest) > o.largest {
+				largest = largest[:o.largest]
+			}
+		}
+	}); err != nil {
+		return err
+	}
+
+	result := []*responseSizeStats{}
+	for _, s := range stats {
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].totalSize != result[j].totalSize {
+			return result[i].totalSize > result[j].totalSize
+		}
+		return result[i].requests > result[j].requests
+	})
+
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "USER\tVERB\tRESOURCE\tREQUESTS\tSIZED\tTOTAL SIZE\tAVG SIZE\tMAX SIZE")
+	for i, s := range result {
+		if o.limit > 0 && i >= o.limit {
+			break
+		}
+		totalSize, avgSize, maxSize := "?", "?", "?"
+		if s.sized > 0 {
+			totalSize = get.FormatSize(s.totalSize)
+			avgSize = get.FormatSize(s.totalSize / s.sized)
+			maxSize = get.FormatSize(s.maxSize)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\t%s\t%s\n", s.user, s.verb, MatrixKey(s.resource), s.requests, s.sized, totalSize, avgSize, maxSize)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(largest) == 0 {
+		return nil
+	}
+	fmt.Fprintln(o.Out)
+	fmt.Fprintln(o.Out, "Largest responses:")
+	w = tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RECEIVED\tUSER\tVERB\tSIZE\tITEMS\tURI")
+	for _, r := range largest {
+		items := ""
+		if r.items >= 0 {
+			items = strconv.FormatInt(r.items, 10)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", r.received, r.user, r.verb, get.FormatSize(r.size), items, r.uri)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,166 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/sets"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/filter"
+)
+
+var (
+	SecretReadVerbs  = sets.NewString("get", "list", "watch")
+	SecretWriteVerbs = sets.NewString("create", "update", "patch", "delete", "deletecollection")
+
+	// WellKnownSystemUsers are the control plane components reading secrets as part of their normal operation.
+	WellKnownSystemUsers = sets.NewString(
+		"system:apiserver",
+		"system:kube-controller-manager",
+		"system:kube-scheduler",
+		"system:node:*",
+		"system:serviceaccount:kube-system:*",
+		"system:serviceaccount:openshift-*",
+	)
+)
+
+type SecretsAccessOptions struct {
+	excludeSystem bool
+	limit         int
+
+	// queryOptions selects and filters the events to report
+	queryOptions Options
+
+	genericclioptions.IOStreams
+}
+
+func NewSecretsAccessCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &SecretsAccessOptions{IOStreams: streams, queryOptions: Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "secrets-access --dir DIR",
+		Short: "List the reads and writes of secrets grouped by user and namespace",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	cmd.Flags().StringVar(&options.queryOptions.from, "from", "", "Only report events starting at this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().StringVar(&options.queryOptions.to, "to", "", "Only report events before this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().IntVar(&options.queryOptions.maxEventSize, "max-event-size", defaultMaxEventSize, "Maximum size in bytes of a single audit event.")
+	cmd.Flags().BoolVar(&options.excludeSystem, "exclude-system", false, "Exclude well-known control plane components ("+strings.Join(WellKnownSystemUsers.List(), ", ")+").")
+	cmd.Flags().IntVar(&options.limit, "limit", 0, "Limit the amount of user and namespace pairs to display.")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *SecretsAccessOptions) Validate() error {
+	if len(o.queryOptions.targetDirectories) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	return nil
+}
+
+// secretsAccess holds the requests of a single user to the secrets of a single namespace, counted by audit ID so
+// every stage of a request counts once.
+type secretsAccess struct {
+	user      string
+	namespace string
+	reads     sets.String
+	writes    sets.String
+	names     sets.String
+	first     time.Time
+	last      time.Time
+}
+
+func (o *SecretsAccessOptions) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+	if o.excludeSystem {
+		excluded := sets.NewString()
+		for _, user := range WellKnownSystemUsers.UnsortedList() {
+			excluded.Insert("-" + user)
+		}
+		filters = append(filters, &filter.FilterByUser{Users: excluded})
+	}
+
+	accesses := map[string]*secretsAccess{}
+	if err := o.queryOptions.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			ns, gvr, name, subresource := filter.URIToParts(e.RequestURI)
+			if gvr.Group != "" || gvr.Resource != "secrets" || len(subresource) > 0 {
+				continue
+			}
+			if !SecretReadVerbs.Has(e.Verb) && !SecretWriteVerbs.Has(e.Verb) {
+				continue
+			}
+			if e.ObjectRef != nil && len(e.ObjectRef.Name) > 0 {
+				name = e.ObjectRef.Name
+			}
+			key := e.User.Username + "\x00" + ns
+			access, ok := accesses[key]
+			if !ok {
+				access = &secretsAccess{user: e.User.Username, namespace: ns, reads: sets.NewString(), writes: sets.NewString(), names: sets.NewString()}
+				accesses[key] = access
+			}
+			if SecretReadVerbs.Has(e.Verb) {
+				access.reads.Insert(string(e.AuditID))
+			} else {
+				access.writes.Insert(string(e.AuditID))
+			}
+			if len(name) > 0 {
+				access.names.Insert(name)
+			}
+			received := e.RequestReceivedTimestamp.Time
+			if access.first.IsZero() || received.Before(access.first) {
+				access.first = received
+			}
+			if received.After(access.last) {
+				access.last = received
+			}
+		}
+	}); err != nil {
+		return err
+	}
+
+	result := []*secretsAccess{}
+	for _, access := range accesses {
+		result = append(result, access)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].user != result[j].user {
+			return result[i].user < result[j].user
+		}
+		return result[i].namespace < result[j].namespace
+	})
+
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "USER\tNAMESPACE\tREADS\tWRITES\tSECRETS\tFIRST\tLAST")
+	for i, access := range result {
+		if o.limit > 0 && i >= o.limit {
+			break
+		}
+		names := "*"
+		if access.names.Len() > 0 {
+			names = strings.Join(access.names.List(), ",")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\t%s\n", access.user, MatrixKey(access.namespace), access.reads.Len(), access.writes.Len(), names,
+			access.first.Format(TimeDefaultFormat), access.last.Format(TimeDefaultFormat))
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/filter"
+)
+
+type SelectorsOptions struct {
+	limit int
+
+	// queryOptions selects and filters the events to analyze
+	queryOptions Options
+
+	genericclioptions.IOStreams
+}
+
+func NewSelectorsCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &SelectorsOptions{IOStreams: streams, queryOptions: Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "selectors --dir DIR",
+		Short: "Report the most common label and field selectors per resource with their latency",
+		Long: "Report the most common label and field selectors of lists and watches per resource with their latency.\n\n" +
+			"The notes highlight selectors that can't be served efficiently: 'etcd' when lists are consistent reads from\n" +
+			"etcd instead of the watch cache, which evaluates the selector only after reading the whole collection, and\n" +
+			"'unindexed' when the field selector matches on a field the watch cache has no index for (only metadata.name,\n" +
+			"metadata.namespace and spec.nodeName of pods are), so every watch event is matched against it.",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	cmd.Flags().StringVar(&options.queryOptions.from, "from", "", "Only analyze events starting at this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().StringVar(&options.queryOptions.to, "to", "", "Only analyze events before this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().IntVar(&options.queryOptions.maxEventSize, "max-event-size", defaultMaxEventSize, "Maximum size in bytes of a single audit event.")
+	cmd.Flags().IntVar(&options.limit, "limit", 20, "Number of selectors with the most requests to display.")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *SelectorsOptions) Validate() error {
+	if len(o.queryOptions.targetDirectories) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	return nil
+}
+
+// indexedFields are the fields the watch cache of the resource has an index for, besides the name and namespace.
+var indexedFields = map[string][]string{
+	"pods": {"spec.nodeName"},
+}
+
+// unindexedFields returns the fields of the field selector that can't be looked up in an index of the watch cache.
+func unindexedFields(resource string, query filter.URIQuery) []string {
+	unindexed := []string{}
+	for _, field := range query.FieldSelectorFields() {
+		if field == "metadata.name" || field == "metadata.namespace" {
+			continue
+		}
+		indexed := false
+		for _, index := range indexedFields[resource] {
+			if field == index {
+				indexed = true
+			}
+		}
+		if !indexed {
+			unindexed = append(unindexed, field)
+		}
+	}
+	return unindexed
+}
+
+// selectorStats aggregates the lists and watches of a resource with the same selectors.
+type selectorStats struct {
+	resource      string
+	labelSelector string
+	fieldSelector string
+	lists         int64
+	watches       int64
+	fromEtcd      int64
+	unindexed     []string
+	latencies     []time.Duration
+}
+
+func (s *selectorStats) notes() string {
+	notes := []string{}
+	if s.fromEtcd > 0 {
+		notes = append(notes, fmt.Sprintf("etcd (%d lists)", s.fromEtcd))
+	}
+	if len(s.unindexed) > 0 {
+		notes = append(notes, "unindexed "+strings.Join(s.unindexed, ","))
+	}
+	return strings.Join(notes, "; ")
+}
+
+func (o *SelectorsOptions) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+
+	stats := map[string]*selectorStats{}
+	if err := o.queryOptions.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			if e.Stage != auditv1.StageResponseComplete || e.ObjectRef == nil || (e.Verb != "list" && e.Verb != "watch") {
+				continue
+			}
+			query := filter.ParseURIQuery(e.RequestURI)
+			if len(query.LabelSelector) == 0 && len(query.FieldSelector) == 0 {
+				continue
+			}
+			resource := e.ObjectRef.Resource
+			if len(e.ObjectRef.APIGroup) > 0 {
+				resource += "." + e.ObjectRef.APIGroup
+			}
+			key := strings.Join([]string{resource, query.LabelSelector, query.FieldSelector}, "\x00")
+			s, ok := stats[key]
+			if !ok {
+				s = &selectorStats{
+					resource:      resource,
+					labelSelector: query.LabelSelector,
+					fieldSelector: query.FieldSelector,
+					unindexed:     unindexedFields(e.ObjectRef.Resource, query),
+				}
+				stats[key] = s
+			}
+			// watches are long running, their duration says nothing about the cost of the selector
+			if e.Verb == "watch" || query.Watch {
+				s.watches++
+				continue
+			}
+			s.lists++
+			s.latencies = append(s.latencies, e.StageTimestamp.Sub(e.RequestReceivedTimestamp.Time))
+			if !query.ServedFromWatchCache() {
+				s.fromEtcd++
+			}
+		}
+	}); err != nil {
+		return err
+	}
+	if len(stats) == 0 {
+		fmt.Fprintln(o.Out, "No lists or watches with selectors found.")
+		return nil
+	}
+
+	result := make([]*selectorStats, 0, len(stats))
+	for _, s := range stats {
+		sort.Slice(s.latencies, func(i, j int) bool {
+			return s.latencies[i] < s.latencies[j]
+		})
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].lists+result[i].watches != result[j].lists+result[j].watches {
+			return result[i].lists+result[i].watches > result[j].lists+result[j].watches
+		}
+		return result[i].resource < result[j].resource
+	})
+
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "LISTS\tWATCHES\tLIST P50\tLIST P99\tRESOURCE\tLABEL SELECTOR\tFIELD SELECTOR\tNOTES")
+	for i, s := range result {
+		if o.limit > 0 && i >= o.limit {
+			break
+		}
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\t%s\t%s\t%s\n", s.lists, s.watches, percentile(s.latencies, 50), percentile(s.latencies, 99),
+			s.resource, MatrixKey(s.labelSelector), MatrixKey(s.fieldSelector), s.notes())
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/runtime"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+	"sigs.k8s.io/yaml"
+
+	"github.com/natamm4/audit-tool/pkg/audit/objects"
+)
+
+const (
+	showFormatYAML = "yaml"
+	showFormatJSON = "json"
+)
+
+// stageOrder orders the events of a request written at the same time.
+var stageOrder = map[auditv1.Stage]int{
+	auditv1.StageRequestReceived:  0,
+	auditv1.StageResponseStarted:  1,
+	auditv1.StageResponseComplete: 2,
+	auditv1.StagePanic:            3,
+}
+
+type ShowOptions struct {
+	format string
+
+	// queryOptions selects the events to show
+	queryOptions Options
+
+	genericclioptions.IOStreams
+}
+
+func NewShowCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &ShowOptions{IOStreams: streams, queryOptions: Options{IOStreams: streams}, format: showFormatYAML}
+	cmd := &cobra.Command{
+		Use:   "show --dir DIR --uid AUDIT-ID",
+		Short: "Print the complete events of a request with their request and response objects",
+		Long: "Print the complete events of the requests with the --uid audit IDs, every stage of a request in the order\n" +
+			"it was written in. The request and response objects recorded by the Request and RequestResponse levels are\n" +
+			"printed as part of the event, as YAML like the rest of it, instead of the raw JSON they are stored as.",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	cmd.Flags().StringVar(&options.queryOptions.from, "from", "", "Only search events starting at this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().StringVar(&options.queryOptions.to, "to", "", "Only search events before this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().IntVar(&options.queryOptions.maxEventSize, "max-event-size", defaultMaxEventSize, "Maximum size in bytes of a single audit event.")
+	cmd.Flags().StringVarP(&options.format, "output", "o", options.format, "Format of the events: yaml (highlighted) or json.")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *ShowOptions) Validate() error {
+	if len(o.queryOptions.targetDirectories) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	if len(o.queryOptions.uids) == 0 {
+		return fmt.Errorf("the audit ID of the request must be specified (--uid)")
+	}
+	if o.format != showFormatYAML && o.format != showFormatJSON {
+		return fmt.Errorf("invalid --output %q, must be %s or %s", o.format, showFormatYAML, showFormatJSON)
+	}
+	return nil
+}
+
+func (o *ShowOptions) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+
+	events := []*auditv1.Event{}
+	if err := o.queryOptions.MultiNodeEventVisitor(ctx, filters, false, func(batch []*auditv1.Event) {
+		events = append(events, batch...)
+	}); err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		return fmt.Errorf("no events with audit ID %s found", strings.Join(o.queryOptions.uids, ", "))
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].AuditID != events[j].AuditID {
+			if !events[i].RequestReceivedTimestamp.Equal(&events[j].RequestReceivedTimestamp) {
+				return events[i].RequestReceivedTimestamp.Before(&events[j].RequestReceivedTimestamp)
+			}
+			return events[i].AuditID < events[j].AuditID
+		}
+		if !events[i].StageTimestamp.Equal(&events[j].StageTimestamp) {
+			return events[i].StageTimestamp.Before(&events[j].StageTimestamp)
+		}
+		return stageOrder[events[i].Stage] < stageOrder[events[j].Stage]
+	})
+
+	for i, e := range events {
+		object, err := eventObject(e)
+		if err != nil {
+			return err
+		}
+		if o.format == showFormatJSON {
+			data, err := json.MarshalIndent(object, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(o.Out, string(data))
+			continue
+		}
+		data, err := yaml.Marshal(object)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Fprintln(o.Out, "---")
+		}
+		highlightYAML(o.Out, string(data))
+	}
+	return nil
+}
+
+// eventObject returns the event as a generic object with the request and response objects decoded, protobuf objects of
+// kinds unknown to the scheme are replaced by a description of their content.
+func eventObject(e *auditv1.Event) (map[string]interface{}, error) {
+	event := e.DeepCopy()
+	event.RequestObject, event.ResponseObject = nil, nil
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	object := map[string]interface{}{}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, err
+	}
+	if e.RequestObject != nil {
+		object["requestObject"] = decodeUnknown(e.RequestObject)
+	}
+	if e.ResponseObject != nil {
+		object["responseObject"] = decodeUnknown(e.ResponseObject)
+	}
+	return object, nil
+}
+
+func decodeUnknown(u *runtime.Unknown) interface{} {
+	var object interface{}
+	if err := json.Unmarshal(u.Raw, &object); err == nil {
+		return object
+	}
+	if decoded, err := objects.Decode(u); err == nil {
+		return decoded.Unstructured.Object
+	}
+	contentType := u.ContentType
+	if len(contentType) == 0 {
+		contentType = "unknown content type"
+	}
+	return fmt.Sprintf("<%d bytes of %s>", len(u.Raw), contentType)
+}
+
+// yamlLine splits a line of YAML into its indentation (with the dash of a list item), key and value.
+var yamlLine = regexp.MustCompile(`^(\s*(?:- )*)(?:([^\s:'"][^:]*|"[^"]*"|'[^']*'):(?: |$))?(.*)$`)
+
+// highlightYAML writes the YAML with the keys, strings, numbers and booleans in different colors.
+func highlightYAML(w io.Writer, data string) {
+	keyStyle := pterm.NewStyle(pterm.FgCyan)
+	stringStyle := pterm.NewStyle(pterm.FgGreen)
+	// blockIndent is the indentation of the key of the block scalar the lines are part of, -1 outside of one
+	blockIndent := -1
+	for _, line := range strings.Split(strings.TrimSuffix(data, "\n"), "\n") {
+		indentation := len(line) - len(strings.TrimLeft(line, " "))
+		if blockIndent >= 0 && (indentation > blockIndent || len(strings.TrimSpace(line)) == 0) {
+			fmt.Fprintln(w, stringStyle.Sprint(line))
+			continue
+		}
+		blockIndent = -1
+		m := yamlLine.FindStringSubmatch(line)
+		if m == nil {
+			fmt.Fprintln(w, line)
+			continue
+		}
+		indent, key, value := m[1], m[2], m[3]
+		if strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") {
+			blockIndent = indentation
+		}
+		if len(key) > 0 {
+			key = keyStyle.Sprint(key) + ":"
+			if len(value) > 0 {
+				key += " "
+			}
+		}
+		fmt.Fprintln(w, indent+key+highlightYAMLValue(value))
+	}
+}
+
+func highlightYAMLValue(value string) string {
+	switch {
+	case len(value) == 0, strings.HasPrefix(value, "|"), strings.HasPrefix(value, ">"), value == "{}", value == "[]":
+		return value
+	case value == "null":
+		return pterm.NewStyle(pterm.FgGray).Sprint(value)
+	case value == "true" || value == "false":
+		return pterm.NewStyle(pterm.FgMagenta).Sprint(value)
+	case yamlNumber.MatchString(value):
+		return pterm.NewStyle(pterm.FgYellow).Sprint(value)
+	default:
+		return pterm.NewStyle(pterm.FgGreen).Sprint(value)
+	}
+}
+
+var yamlNumber = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
@@ -0,0 +1,283 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+const (
+	// sparklineWidth is the maximum number of characters of a sparkline when the bucket size is chosen automatically
+	sparklineWidth = 24
+	// maxSparklineBuckets bounds the buckets tracked per key, they are coarsened when the events span more
+	maxSparklineBuckets = 1024
+
+	partialBucketsScale = "scale"
+	partialBucketsKeep  = "keep"
+	partialBucketsDrop  = "drop"
+)
+
+var sparklineLevels = []rune("▁▂▃▄▅▆▇█")
+
+// sparklineResolutions are the bucket durations the series coarsen through and the automatic bucket sizes are chosen
+// from. Each is a multiple of the previous one, so the buckets stay aligned to the wall-clock boundaries and merge
+// exactly.
+var sparklineResolutions = []time.Duration{
+	time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+	time.Minute, 5 * time.Minute, 10 * time.Minute, 30 * time.Minute,
+	time.Hour, 2 * time.Hour, 6 * time.Hour, 12 * time.Hour, 24 * time.Hour, 7 * 24 * time.Hour,
+}
+
+// validateSparkline checks the --bucket and --partial-buckets flags of the sparklines.
+func validateSparkline(o Options) error {
+	if o.sparklineBucket < 0 {
+		return fmt.Errorf("--bucket must not be negative")
+	}
+	switch o.partialBuckets {
+	case partialBucketsScale, partialBucketsKeep, partialBucketsDrop:
+	default:
+		return fmt.Errorf("invalid --partial-buckets %q, must be %s, %s or %s", o.partialBuckets, partialBucketsScale, partialBucketsKeep, partialBucketsDrop)
+	}
+	if !o.sparkline && (o.sparklineBucket > 0 || o.partialBuckets != partialBucketsScale) {
+		return fmt.Errorf("--bucket and --partial-buckets require --sparkline")
+	}
+	return nil
+}
+
+// floorDiv divides rounding towards negative infinity, the buckets before the Unix epoch have negative indexes.
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// sparklineSeries counts the events of every key over time in a single pass with bounded memory. The buckets are
+// aligned to multiples of their duration since the Unix epoch, ie. to the minute, hour and day boundaries in UTC, and
+// are coarsened when the events span more than maxSparklineBuckets of them.
+type sparklineSeries struct {
+	// bucket is the requested size of the sparkline buckets, zero to choose it from the window
+	bucket  time.Duration
+	partial string
+
+	resolution time.Duration
+	// minIndex and maxIndex are the first and last bucket with events, set once empty is false
+	minIndex, maxIndex int64
+	empty              bool
+	counts             map[string]map[int64]int64
+	first, last        time.Time
+
+	// size, start and end are the sparkline buckets and the window they cover, set by complete
+	size       time.Duration
+	start, end time.Time
+}
+
+func newSparklineSeries(bucket time.Duration, partial string) *sparklineSeries {
+	resolution := sparklineResolutions[0]
+	if bucket > 0 {
+		resolution = bucket
+	}
+	return &sparklineSeries{bucket: bucket, partial: partial, resolution: resolution, empty: true, counts: map[string]map[int64]int64{}}
+}
+
+func (s *sparklineSeries) index(t time.Time) int64 {
+	return floorDiv(t.UnixNano(), int64(s.resolution))
+}
+
+func (s *sparklineSeries) add(e *auditv1.Event, key string) {
+	received := e.RequestReceivedTimestamp.Time
+	index := s.index(received)
+	if s.empty {
+		s.minIndex, s.maxIndex, s.empty = index, index, false
+		s.first, s.last = received, received
+	}
+	if received.Before(s.first) {
+		s.first = received
+	}
+	if received.After(s.last) {
+		s.last = received
+	}
+
+	for index < s.minIndex && s.maxIndex-index >= maxSparklineBuckets || index > s.maxIndex && index-s.minIndex >= maxSparklineBuckets {
+		s.coarsen()
+		index = s.index(received)
+	}
+	if index < s.minIndex {
+		s.minIndex = index
+	}
+	if index > s.maxIndex {
+		s.maxIndex = index
+	}
+	buckets, ok := s.counts[key]
+	if !ok {
+		buckets = map[int64]int64{}
+		s.counts[key] = buckets
+	}
+	buckets[index]++
+}
+
+// nextResolution returns the next bucket duration of sparklineResolutions that is a multiple of d.
+func nextResolution(d time.Duration) time.Duration {
+	for _, resolution := range sparklineResolutions {
+		if resolution > d && resolution%d == 0 {
+			return resolution
+		}
+	}
+	return 2 * d
+}
+
+// coarsen merges the buckets into the buckets of the next resolution.
+func (s *sparklineSeries) coarsen() {
+	next := nextResolution(s.resolution)
+	rebucket := func(index int64) int64 {
+		return floorDiv(index*int64(s.resolution), int64(next))
+	}
+	for key, buckets := range s.counts {
+		merged := make(map[int64]int64, len(buckets))
+		for index, count := range buckets {
+			merged[rebucket(index)] += count
+		}
+		s.counts[key] = merged
+	}
+	s.minIndex, s.maxIndex = rebucket(s.minIndex), rebucket(s.maxIndex)
+	s.resolution = next
+}
+
+// prune drops the keys keep returns false for, eg. the keys the heavy hitters estimation stopped tracking.
+func (s *sparklineSeries) prune(keep func(key string) bool) {
+	for key := range s.counts {
+		if !keep(key) {
+			delete(s.counts, key)
+		}
+	}
+}
+
+// complete chooses the sparkline buckets of the window: --from to --to when they are given, otherwise the first to
+// the last event. Without --bucket their size is the smallest one of sparklineResolutions needing at most
+// sparklineWidth buckets, with --bucket the window must span at most maxSparklineBuckets of them.
+func (s *sparklineSeries) complete(from, to string) error {
+	s.start, s.end = s.first, s.last.Add(time.Nanosecond)
+	if len(from) > 0 {
+		s.start = ParseTime(from)
+	}
+	if len(to) > 0 {
+		s.end = ParseTime(to)
+	}
+	if !s.end.After(s.start) {
+		s.end = s.start.Add(s.resolution)
+	}
+
+	if s.bucket > 0 {
+		if s.resolution != s.bucket {
+			return fmt.Errorf("the events span more than %d buckets of %s, use a larger --bucket", maxSparklineBuckets, s.bucket)
+		}
+		s.size = s.bucket
+		// --from and --to may span far more buckets than the events, every one of them is a character of the sparklines
+		if buckets := s.buckets(); buckets > maxSparklineBuckets {
+			return fmt.Errorf("the queried window spans %d buckets of %s, more than %d, use a larger --bucket", buckets, s.bucket, maxSparklineBuckets)
+		}
+		return nil
+	}
+	s.size = s.resolution
+	for s.buckets() > sparklineWidth {
+		s.size = nextResolution(s.size)
+	}
+	return nil
+}
+
+func (s *sparklineSeries) bucketIndex(t time.Time) int64 {
+	return floorDiv(t.UnixNano(), int64(s.size))
+}
+
+// buckets returns the number of sparkline buckets of the window, the first and last may be covered partially.
+func (s *sparklineSeries) buckets() int {
+	return int(s.bucketIndex(s.end.Add(-1)) - s.bucketIndex(s.start) + 1)
+}
+
+// header returns the header of the sparkline column with the size of its buckets, eg. TIMELINE (5m).
+func (s *sparklineSeries) header() string {
+	size := s.size.String()
+	if strings.HasSuffix(size, "m0s") {
+		size = strings.TrimSuffix(size, "0s")
+	}
+	if strings.HasSuffix(size, "h0m") {
+		size = strings.TrimSuffix(size, "0m")
+	}
+	return fmt.Sprintf("TIMELINE (%s)", size)
+}
+
+// columns returns the requests of the key in every sparkline bucket. The buckets at the edges of the window that it
+// only covers partially are extrapolated to their whole duration, kept as is or dropped depending on --partial-buckets,
+// so that a window not aligned to the buckets doesn't look like a drop of the requests. Buckets covered less than a
+// tenth are not extrapolated but left blank, a few requests would look like a spike.
+func (s *sparklineSeries) columns(key string) []float64 {
+	first := s.bucketIndex(s.start)
+	columns := make([]float64, s.buckets())
+	for index, count := range s.counts[key] {
+		column := floorDiv(index*int64(s.resolution), int64(s.size)) - first
+		if column < 0 || column >= int64(len(columns)) {
+			continue
+		}
+		columns[column] += float64(count)
+	}
+	if s.partial == partialBucketsKeep {
+		return columns
+	}
+	for i := range columns {
+		bucketStart := time.Unix(0, (first+int64(i))*int64(s.size))
+		bucketEnd := bucketStart.Add(s.size)
+		if bucketStart.Before(s.start) {
+			bucketStart = s.start
+		}
+		if bucketEnd.After(s.end) {
+			bucketEnd = s.end
+		}
+		covered := bucketEnd.Sub(bucketStart)
+		if covered >= s.size {
+			continue
+		}
+		if s.partial == partialBucketsDrop || covered < s.size/10 {
+			columns[i] = 0
+			continue
+		}
+		columns[i] = columns[i] * float64(s.size) / float64(covered)
+	}
+	return columns
+}
+
+// sparkline renders the columns relative to the busiest one, a bucket without requests is blank.
+func sparkline(columns []float64) string {
+	var max float64
+	for _, c := range columns {
+		if c > max {
+			max = c
+		}
+	}
+	b := strings.Builder{}
+	for _, c := range columns {
+		if c <= 0 {
+			b.WriteRune(' ')
+			continue
+		}
+		level := int(c * float64(len(sparklineLevels)) / max)
+		if level >= len(sparklineLevels) {
+			level = len(sparklineLevels) - 1
+		}
+		b.WriteRune(sparklineLevels[level])
+	}
+	return b.String()
+}
+
+// addColumns adds the columns of b to a, a is allocated when nil.
+func addColumns(a, b []float64) []float64 {
+	if a == nil {
+		a = make([]float64, len(b))
+	}
+	for i := range b {
+		a[i] += b[i]
+	}
+	return a
+}
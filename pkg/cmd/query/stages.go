@@ -0,0 +1,203 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/filter"
+)
+
+type StagesOptions struct {
+	limit    int
+	requests int
+
+	// queryOptions selects and filters the events to analyze
+	queryOptions Options
+
+	genericclioptions.IOStreams
+}
+
+func NewStagesCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &StagesOptions{IOStreams: streams, queryOptions: Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "stages --dir DIR",
+		Short: "Break down the request latency into processing and response streaming time",
+		Long: "Break down the request latency into processing and response streaming time. Processing is the time from\n" +
+			"RequestReceived until ResponseStarted (or ResponseComplete for requests without a ResponseStarted stage),\n" +
+			"streaming the time from ResponseStarted until ResponseComplete. Long streaming phases of watches and large\n" +
+			"lists can this way be told apart from slow processing.",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	cmd.Flags().StringVar(&options.queryOptions.from, "from", "", "Only analyze events starting at this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().StringVar(&options.queryOptions.to, "to", "", "Only analyze events before this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().IntVar(&options.queryOptions.maxEventSize, "max-event-size", defaultMaxEventSize, "Maximum size in bytes of a single audit event.")
+	cmd.Flags().IntVar(&options.limit, "limit", 20, "Number of verb and resource pairs with the most time spent to display.")
+	cmd.Flags().IntVar(&options.requests, "requests", 0, "Also display this number of individual requests that took the longest.")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *StagesOptions) Validate() error {
+	if len(o.queryOptions.targetDirectories) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	if o.requests < 0 {
+		return fmt.Errorf("--requests must not be negative")
+	}
+	return nil
+}
+
+// requestStages holds the stage timestamps of a single request, collected from its events by audit ID.
+type requestStages struct {
+	auditID   string
+	verb      string
+	resource  string
+	user      string
+	uri       string
+	received  time.Time
+	started   time.Time
+	completed time.Time
+}
+
+// processing is the time until the response started, or until it completed for requests that didn't stream.
+func (r *requestStages) processing() time.Duration {
+	if !r.started.IsZero() {
+		return r.started.Sub(r.received)
+	}
+	return r.completed.Sub(r.received)
+}
+
+func (r *requestStages) streaming() time.Duration {
+	if r.started.IsZero() {
+		return 0
+	}
+	return r.completed.Sub(r.started)
+}
+
+// stageStats aggregates the completed requests of a single verb on a single resource.
+type stageStats struct {
+	verb       string
+	resource   string
+	processing []time.Duration
+	streaming  []time.Duration
+	total      time.Duration
+}
+
+func percentile(durations []time.Duration, p float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	return durations[int(math.Ceil(p/100*float64(len(durations))))-1]
+}
+
+func (o *StagesOptions) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+
+	requests := map[string]*requestStages{}
+	if err := o.queryOptions.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			r, ok := requests[string(e.AuditID)]
+			if !ok {
+				_, gvr, _, _ := filter.URIToParts(e.RequestURI)
+				r = &requestStages{
+					auditID:  string(e.AuditID),
+					verb:     e.Verb,
+					resource: gvr.GroupResource().String(),
+					user:     e.User.Username,
+					uri:      e.RequestURI,
+					received: e.RequestReceivedTimestamp.Time,
+				}
+				requests[string(e.AuditID)] = r
+			}
+			switch e.Stage {
+			case auditv1.StageResponseStarted:
+				r.started = e.StageTimestamp.Time
+			case auditv1.StageResponseComplete, auditv1.StagePanic:
+				r.completed = e.StageTimestamp.Time
+			}
+		}
+	}); err != nil {
+		return err
+	}
+
+	stats := map[string]*stageStats{}
+	completed := []*requestStages{}
+	for _, r := range requests {
+		if r.completed.IsZero() {
+			continue
+		}
+		completed = append(completed, r)
+		key := r.verb + "\x00" + r.resource
+		s, ok := stats[key]
+		if !ok {
+			s = &stageStats{verb: r.verb, resource: r.resource}
+			stats[key] = s
+		}
+		s.processing = append(s.processing, r.processing())
+		s.streaming = append(s.streaming, r.streaming())
+		s.total += r.completed.Sub(r.received)
+	}
+
+	result := []*stageStats{}
+	for _, s := range stats {
+		sort.Slice(s.processing, func(i, j int) bool {
+			return s.processing[i] < s.processing[j]
+		})
+		sort.Slice(s.streaming, func(i, j int) bool {
+			return s.streaming[i] < s.streaming[j]
+		})
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].total > result[j].total
+	})
+
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERB\tRESOURCE\tREQUESTS\tPROCESSING P50\tPROCESSING P99\tSTREAMING P50\tSTREAMING P99\tTOTAL")
+	for i, s := range result {
+		if o.limit > 0 && i >= o.limit {
+			break
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n", s.verb, MatrixKey(s.resource), len(s.processing),
+			percentile(s.processing, 50), percentile(s.processing, 99), percentile(s.streaming, 50), percentile(s.streaming, 99), s.total)
+	}
+	w.Flush()
+
+	if o.requests == 0 {
+		return nil
+	}
+	sort.Slice(completed, func(i, j int) bool {
+		return completed[i].completed.Sub(completed[i].received) > completed[j].completed.Sub(completed[j].received)
+	})
+	fmt.Fprintln(o.Out)
+	rw := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	defer rw.Flush()
+	fmt.Fprintln(rw, "AUDIT ID\tVERB\tUSER\tPROCESSING\tSTREAMING\tTOTAL\tURI")
+	for i, r := range completed {
+		if i >= o.requests {
+			break
+		}
+		fmt.Fprintf(rw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.auditID, r.verb, r.user, r.processing(), r.streaming(), r.completed.Sub(r.received), r.uri)
+	}
+	return nil
+}
@@ -0,0 +1,292 @@
+package query
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/natamm4/audit-tool/pkg/audit/decompress"
+	"github.com/natamm4/audit-tool/pkg/audit/index"
+)
+
+const (
+	// longRequestSlack moves the start of the time ranges read from the first line of an audit file back. Events are
+	// written when a request reaches a stage, long requests like watches are received before the events written before
+	// them.
+	longRequestSlack = time.Hour
+
+	// checkpointInterval is the number of bytes between the checkpoints of uncompressed audit files
+	checkpointInterval = 8 << 20
+)
+
+var (
+	receivedTimestampKey = []byte(`"requestReceivedTimestamp"`)
+	stageTimestampKey    = []byte(`"stageTimestamp"`)
+)
+
+// fileTimeRanges are the time ranges of the events of the local audit files, read from the index of their directory
+// or from the audit files once and recorded in the index. The time ranges skip the audit files outside of --from and
+// --to more reliably than the rotation timestamps in the file names, which the live audit file doesn't have and which
+// are when the file was rotated rather than the time of its events.
+type fileTimeRanges struct {
+	lock sync.Mutex
+	// dirs are the local directories by cluster, indexes by cluster
+	dirs     map[string]string
+	indexes  map[string]*index.Index
+	changed  map[string]bool
+	from, to time.Time
+}
+
+// loadTimeRanges returns the time ranges of the audit files of the local directories, nil without --from and --to.
+func (o Options) loadTimeRanges() (*fileTimeRanges, error) {
+	if (len(o.from) == 0 && len(o.to) == 0) || len(o.localDirectories) == 0 {
+		return nil, nil
+	}
+	ranges := &fileTimeRanges{dirs: o.localDirectories, indexes: map[string]*index.Index{}, changed: map[string]bool{}}
+	if len(o.from) > 0 {
+		ranges.from = ParseTime(o.from)
+	}
+	if len(o.to) > 0 {
+		ranges.to = ParseTime(o.to)
+	}
+	for cluster, dir := range o.localDirectories {
+		idx, err := index.Read(dir)
+		if err != nil {
+			return nil, err
+		}
+		if idx == nil {
+			idx = index.New()
+		}
+		ranges.indexes[cluster] = idx
+	}
+	return ranges, nil
+}
+
+// inTimeRange returns whether the audit file may contain events between --from and --to, false when it certainly
+// doesn't. The second value is false when the time range of the audit file isn't known, eg. for remote sources.
+func (r *fileTimeRanges) inTimeRange(f auditFile) (bool, bool) {
+	if r == nil {
+		return false, false
+	}
+	dir, ok := r.dirs[f.cluster]
+	if !ok {
+		return false, false
+	}
+	path, err := filepath.Rel(dir, f.file.Path)
+	if err != nil {
+		return false, false
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	idx := r.indexes[f.cluster]
+	timeRange, ok := idx.Ranges[path]
+	if !ok || !timeRange.Current(f.file.Size, f.file.ModTime) {
+		timeRange, err = readTimeRange(f.file.Path)
+		if err != nil {
+			log.Printf("unable to read the time range of %q: %v", f.file.Path, err)
+			return false, false
+		}
+		timeRange.Size, timeRange.ModTime = f.file.Size, f.file.ModTime
+		idx.Ranges[path] = timeRange
+		r.changed[f.cluster] = true
+	}
+	return timeRange.Overlaps(r.from, r.to), true
+}
+
+// start returns the checkpoint of the audit file to start reading it at for --from, false when it has to be read from
+// the beginning, eg. without --from or when the audit file changed since it was indexed.
+func (r *fileTimeRanges) start(f auditFile) (index.Checkpoint, bool) {
+	if r == nil || r.from.IsZero() {
+		return index.Checkpoint{}, false
+	}
+	dir, ok := r.dirs[f.cluster]
+	if !ok {
+		return index.Checkpoint{}, false
+	}
+	path, err := filepath.Rel(dir, f.file.Path)
+	if err != nil {
+		return index.Checkpoint{}, false
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	timeRange, ok := r.indexes[f.cluster].Ranges[path]
+	if !ok || !timeRange.Current(f.file.Size, f.file.ModTime) {
+		return index.Checkpoint{}, false
+	}
+	return timeRange.Start(r.from)
+}
+
+// save writes the indexes with the time ranges read from the audit files. The directories may be read-only, eg. a
+// mounted dump, the time ranges are read again then.
+func (r *fileTimeRanges) save() {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for cluster := range r.changed {
+		if err := index.Write(r.dirs[cluster], r.indexes[cluster]); err != nil && !os.IsPermission(err) {
+			log.Printf("unable to record the time ranges of the audit files in %s: %v", r.dirs[cluster], err)
+		}
+	}
+	r.changed = map[string]bool{}
+}
+
+// readTimeRange reads the time range of the events of an audit file. Of uncompressed audit logs only the first and the
+// last line are read, which relies on the lines being in the order the API server wrote them. Compressed files and the
+// formats converted by package decompress are scanned once, taking the timestamps from the lines without decoding the
+// events.
+func readTimeRange(path string) (*index.TimeRange, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	first, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(first)) == 0 {
+		return &index.TimeRange{}, nil
+	}
+	if received, ok := jsonTimestamp(first, receivedTimestampKey); ok {
+		last, err := lastLine(f)
+		if err != nil {
+			return nil, err
+		}
+		stage, ok := jsonTimestamp(last, stageTimestampKey)
+		if !ok {
+			if stage, ok = jsonTimestamp(last, receivedTimestampKey); !ok {
+				return nil, fmt.Errorf("no timestamp in the last line")
+			}
+		}
+		return &index.TimeRange{First: received.Add(-longRequestSlack), Last: stage}, nil
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	r, err := decompress.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	timeRange := &index.TimeRange{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), defaultMaxEventSize)
+	for scanner.Scan() {
+		received, ok := jsonTimestamp(scanner.Bytes(), receivedTimestampKey)
+		if !ok {
+			continue
+		}
+		if timeRange.First.IsZero() || received.Before(timeRange.First) {
+			timeRange.First = received
+		}
+		if received.After(timeRange.Last) {
+			timeRange.Last = received
+		}
+	}
+	return timeRange, scanner.Err()
+}
+
+// readCheckpoints returns a checkpoint about every checkpointInterval bytes of an uncompressed audit log, at the first
+// line after the interval with a stage timestamp. Compressed files and the formats converted by package decompress have
+// none, their offsets aren't the offsets of the lines.
+func readCheckpoints(path string) ([]index.Checkpoint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	checkpoints := []index.Checkpoint{}
+	r := bufio.NewReaderSize(f, 64*1024)
+	var offset, next int64 = 0, checkpointInterval
+	for line := 0; ; line++ {
+		text, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if line == 0 {
+			if _, ok := jsonTimestamp(text, receivedTimestampKey); !ok {
+				return nil, nil
+			}
+		}
+		if offset >= next {
+			if stage, ok := jsonTimestamp(text, stageTimestampKey); ok {
+				checkpoints = append(checkpoints, index.Checkpoint{Time: stage, Offset: offset, Line: line})
+				next = offset + checkpointInterval
+			}
+		}
+		offset += int64(len(text))
+		if err == io.EOF {
+			return checkpoints, nil
+		}
+	}
+}
+
+// lastLine returns the last non-empty line of the file, read backwards from its end.
+func lastLine(f *os.File) ([]byte, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	const chunk = 64 * 1024
+	tail := []byte{}
+	for end := info.Size(); end > 0; {
+		start := end - chunk
+		if start < 0 {
+			start = 0
+		}
+		buf := make([]byte, end-start)
+		if _, err := f.ReadAt(buf, start); err != nil && err != io.EOF {
+			return nil, err
+		}
+		tail = append(buf, tail...)
+		trimmed := bytes.TrimRight(tail, "\r\n")
+		if i := bytes.LastIndexByte(trimmed, '\n'); i >= 0 {
+			return trimmed[i+1:], nil
+		}
+		if len(tail) > defaultMaxEventSize {
+			return nil, fmt.Errorf("the last line is longer than %d bytes", defaultMaxEventSize)
+		}
+		end = start
+	}
+	return bytes.TrimRight(tail, "\r\n"), nil
+}
+
+// jsonTimestamp returns the timestamp of the top level key in the JSON line without decoding it. The keys of the
+// timestamps aren't used by the nested objects of audit events.
+func jsonTimestamp(line, key []byte) (time.Time, bool) {
+	i := bytes.Index(line, key)
+	if i < 0 {
+		return time.Time{}, false
+	}
+	value := bytes.TrimLeft(line[i+len(key):], " \t")
+	if len(value) == 0 || value[0] != ':' {
+		return time.Time{}, false
+	}
+	value = bytes.TrimLeft(value[1:], " \t")
+	if len(value) == 0 || value[0] != '"' {
+		return time.Time{}, false
+	}
+	value = value[1:]
+	end := bytes.IndexByte(value, '"')
+	if end < 0 {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, string(value[:end]))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
@@ -0,0 +1,120 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+
+	"github.com/natamm4/audit-tool/pkg/audit/filter"
+	"github.com/natamm4/audit-tool/pkg/audit/provenance"
+	"github.com/natamm4/audit-tool/pkg/audit/topk"
+)
+
+const (
+	defaultTopCount    = 10
+	defaultTopCapacity = 10000
+)
+
+// topKeyFuncs maps the --by values to the function that returns the key an event is counted under.
+var topKeyFuncs = map[string]func(e *auditv1.Event) string{
+	"verb": func(e *auditv1.Event) string {
+		return e.Verb
+	},
+	"user": func(e *auditv1.Event) string {
+		return e.User.Username
+	},
+	"resource": func(e *auditv1.Event) string {
+		_, gvr, _, _ := filter.URIToParts(e.RequestURI)
+		return gvr.GroupResource().String()
+	},
+	"httpstatus": responseCode,
+	"code":       responseCode,
+	"namespace": func(e *auditv1.Event) string {
+		ns, _, _, _ := filter.URIToParts(e.RequestURI)
+		return ns
+	},
+	"cluster": provenance.Cluster,
+	"useragent": func(e *auditv1.Event) string {
+		return e.UserAgent
+	},
+	"patchtype":    filter.PatchType,
+	"fieldmanager": filter.FieldManager,
+	"node":         provenance.Node,
+	"hour": func(e *auditv1.Event) string {
+		return e.RequestReceivedTimestamp.Truncate(time.Hour).Format("2006-01-02 15:00")
+	},
+}
+
+func responseCode(e *auditv1.Event) string {
+	if e.ResponseStatus == nil {
+		return pendingValue
+	}
+	return fmt.Sprintf("%d", e.ResponseStatus.Code)
+}
+
+// topDimensions returns the sorted --by values.
+func topDimensions() []string {
+	return sets.StringKeySet(topKeyFuncs).List()
+}
+
+func validateTopBy(by string) error {
+	if _, ok := topKeyFuncs[by]; !ok {
+		return fmt.Errorf("invalid --by value %q, must be one of %s", by, strings.Join(topDimensions(), ", "))
+	}
+	return nil
+}
+
+// runTop counts the events in a single pass without keeping them in memory. Unless exact counting is requested the
+// heavy hitters are estimated with bounded memory, which allows to process archives that don't fit into memory.
+func (o Options) runTop(ctx context.Context, filters filter.AuditFilters, layout string) error {
+	if len(o.groupBy) > 0 || o.showRates || o.sparkline {
+		return o.runGroupBy(ctx, filters, layout)
+	}
+	keyFunc := topKeyFuncs[o.topBy]
+
+	var counter topk.Counter
+	if o.topExact {
+		counter = topk.NewExact()
+	} else {
+		counter = topk.NewSpaceSaving(o.topCapacity)
+	}
+
+	if err := o.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			counter.Add(keyFunc(e))
+		}
+	}); err != nil {
+		return err
+	}
+
+	count := int(o.limit)
+	if count <= 0 {
+		count = defaultTopCount
+	}
+	printTop(o.Out, counter.Top(count), counter.Total())
+	return nil
+}
+
+func printTop(writer io.Writer, items []topk.Item, total int64) {
+	w := tabwriter.NewWriter(writer, 20, 0, 0, ' ', tabwriter.DiscardEmptyColumns)
+	defer w.Flush()
+
+	for _, item := range items {
+		estimate := ""
+		if item.Error > 0 {
+			estimate = fmt.Sprintf(" (±%d)", item.Error)
+		}
+		key := item.Key
+		if len(strings.TrimSpace(key)) == 0 {
+			key = "<none>"
+		}
+		fmt.Fprintf(w, "%dx%s\t %s\n", item.Count, estimate, key)
+	}
+	fmt.Fprintf(w, "\nTotal: %d events\n", total)
+}
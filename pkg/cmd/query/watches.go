@@ -0,0 +1,195 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/sets"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/filter"
+)
+
+type WatchesOptions struct {
+	limit        int
+	shortWatch   time.Duration
+	maxWatchRate float64
+	maxListRate  float64
+
+	// queryOptions selects and filters the events to analyze
+	queryOptions Options
+
+	genericclioptions.IOStreams
+}
+
+func NewWatchesCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &WatchesOptions{IOStreams: streams, queryOptions: Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "watches --dir DIR",
+		Short: "Report watch establishment rates, watch durations and re-list rates per user and resource",
+		Long: "Report watch establishment rates, watch durations and re-list rates per user and resource. Clients establishing\n" +
+			"many short-lived watches (watch storms) or re-listing excessively are flagged, both are a frequent cause of\n" +
+			"API server overload.",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	cmd.Flags().StringVar(&options.queryOptions.from, "from", "", "Only analyze events starting at this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().StringVar(&options.queryOptions.to, "to", "", "Only analyze events before this time (eg: '2006-01-02 15:03:04').")
+	cmd.Flags().IntVar(&options.queryOptions.maxEventSize, "max-event-size", defaultMaxEventSize, "Maximum size in bytes of a single audit event.")
+	cmd.Flags().IntVar(&options.limit, "limit", 20, "Number of user and resource pairs with the most watches to display.")
+	cmd.Flags().DurationVar(&options.shortWatch, "short-watch", time.Minute, "Watches closed within this duration are counted as short-lived.")
+	cmd.Flags().Float64Var(&options.maxWatchRate, "max-watch-rate", 10, "Flag user and resource pairs establishing more short-lived watches per minute.")
+	cmd.Flags().Float64Var(&options.maxListRate, "max-list-rate", 2, "Flag user and resource pairs listing more times per minute.")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *WatchesOptions) Validate() error {
+	if len(o.queryOptions.targetDirectories) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	if o.shortWatch <= 0 {
+		return fmt.Errorf("--short-watch must be a positive duration")
+	}
+	return nil
+}
+
+// watchDurationBuckets are the upper bounds of the watch duration distribution.
+var watchDurationBuckets = []time.Duration{time.Second, 10 * time.Second, time.Minute, 5 * time.Minute, 10 * time.Minute}
+
+// watchStats holds the watch and list requests of a single user on a single resource. Watches and lists are counted
+// by audit ID, so the RequestReceived, ResponseStarted and ResponseComplete events of one request count once.
+type watchStats struct {
+	user      string
+	resource  string
+	watches   sets.String
+	lists     sets.String
+	durations []time.Duration
+	short     int
+}
+
+func (s *watchStats) percentile(p float64) time.Duration {
+	return percentile(s.durations, p)
+}
+
+func (o *WatchesOptions) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+
+	stats := map[string]*watchStats{}
+	buckets := make([]int, len(watchDurationBuckets)+1)
+	var first, last time.Time
+	if err := o.queryOptions.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			if e.Verb != "watch" && e.Verb != "list" {
+				continue
+			}
+			received := e.RequestReceivedTimestamp.Time
+			if first.IsZero() || received.Before(first) {
+				first = received
+			}
+			if received.After(last) {
+				last = received
+			}
+
+			_, gvr, _, _ := filter.URIToParts(e.RequestURI)
+			resource := gvr.GroupResource().String()
+			key := e.User.Username + "\x00" + resource
+			s, ok := stats[key]
+			if !ok {
+				s = &watchStats{user: e.User.Username, resource: resource, watches: sets.NewString(), lists: sets.NewString()}
+				stats[key] = s
+			}
+			if e.Verb == "list" {
+				s.lists.Insert(string(e.AuditID))
+				continue
+			}
+			s.watches.Insert(string(e.AuditID))
+			if e.Stage != auditv1.StageResponseComplete && e.Stage != auditv1.StagePanic {
+				continue
+			}
+			duration := e.StageTimestamp.Sub(received)
+			s.durations = append(s.durations, duration)
+			if duration < o.shortWatch {
+				s.short++
+			}
+			bucket := sort.Search(len(watchDurationBuckets), func(i int) bool {
+				return duration < watchDurationBuckets[i]
+			})
+			buckets[bucket]++
+		}
+	}); err != nil {
+		return err
+	}
+
+	// rates are per minute of the analyzed time window, windows shorter than a minute would inflate them
+	minutes := math.Max(last.Sub(first).Minutes(), 1)
+
+	result := []*watchStats{}
+	for _, s := range stats {
+		sort.Slice(s.durations, func(i, j int) bool {
+			return s.durations[i] < s.durations[j]
+		})
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].watches.Len() != result[j].watches.Len() {
+			return result[i].watches.Len() > result[j].watches.Len()
+		}
+		return result[i].lists.Len() > result[j].lists.Len()
+	})
+
+	fmt.Fprintf(o.Out, "Watch durations over %s:\n", last.Sub(first).Round(time.Second))
+	hw := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	lower := "0s"
+	for i, upper := range watchDurationBuckets {
+		fmt.Fprintf(hw, "  %s - %s\t%d\n", lower, upper, buckets[i])
+		lower = upper.String()
+	}
+	fmt.Fprintf(hw, "  >= %s\t%d\n", lower, buckets[len(watchDurationBuckets)])
+	hw.Flush()
+	fmt.Fprintln(o.Out)
+
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "USER\tRESOURCE\tWATCHES\tWATCHES/MIN\tSHORT-LIVED\tP50\tP99\tLISTS\tLISTS/MIN\tFLAGS")
+	for i, s := range result {
+		if o.limit > 0 && i >= o.limit {
+			break
+		}
+		watchRate := float64(s.watches.Len()) / minutes
+		listRate := float64(s.lists.Len()) / minutes
+		flags := []string{}
+		if float64(s.short)/minutes > o.maxWatchRate {
+			flags = append(flags, "watch-storm")
+		}
+		if listRate > o.maxListRate {
+			flags = append(flags, "excessive-relist")
+		}
+		flagged := strings.Join(flags, ",")
+		if len(flagged) > 0 {
+			flagged = pterm.NewStyle(pterm.FgRed).Sprint(flagged)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%.2f\t%d\t%s\t%s\t%d\t%.2f\t%s\n",
+			s.user, MatrixKey(s.resource), s.watches.Len(), watchRate, s.short, s.percentile(50), s.percentile(99), s.lists.Len(), listRate, flagged)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package release
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// PluginName is the name kubectl runs the binary as, "kubectl audit" runs kubectl-audit from the PATH.
+	PluginName = "kubectl-audit"
+
+	// binaryName is the name of the binary in the release archives
+	binaryName = "audit-tool"
+
+	defaultURLTemplate = "https://github.com/natamm4/audit-tool/releases/download/{{.Version}}/{{.File}}"
+)
+
+func NewCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "release",
+		Short: "Generate the files needed to publish a release",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+
+	cmd.AddCommand(NewKrewManifestCommand(streams))
+	return cmd
+}
+
+type KrewManifestOptions struct {
+	version      string
+	artifactsDir string
+	urlTemplate  string
+	outputFile   string
+
+	genericclioptions.IOStreams
+}
+
+func NewKrewManifestCommand(streams genericclioptions.IOStreams) *cobra.Command {
+	options := &KrewManifestOptions{IOStreams: streams}
+	cmd := &cobra.Command{
+		Use:   "krew-manifest --version VERSION --artifacts-dir DIR",
+		Short: "Generate the krew plugin manifest installing the release archives as kubectl audit",
+		Long: "Generate the krew plugin manifest of a release, so that it can be installed by 'kubectl krew install audit'\n" +
+			"and run as 'kubectl audit get' or 'kubectl audit query'.\n\n" +
+			"The release archives (.tar.gz or .zip) in --artifacts-dir must name their platform like\n" +
+			"audit-tool_linux_amd64.tar.gz and contain the " + binaryName + " binary, which krew installs as " + PluginName + ".\n" +
+			"The download URLs are given by --url-template, a Go template executed with .Version and .File.",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmdutil.CheckErr(options.Validate())
+			cmdutil.CheckErr(options.Run())
+		},
+	}
+
+	cmd.Flags().StringVar(&options.version, "version", "", "Version of the release, eg. v0.2.0.")
+	cmd.Flags().StringVar(&options.artifactsDir, "artifacts-dir", "", "Directory with the release archives of all platforms.")
+	cmd.Flags().StringVar(&options.urlTemplate, "url-template", defaultURLTemplate, "Go template of the download URL of a release archive.")
+	cmd.Flags().StringVar(&options.outputFile, "output-file", "", "File to write the manifest to, it is written to stdout when not set.")
+
+	return cmd
+}
+
+func (o *KrewManifestOptions) Validate() error {
+	if !regexp.MustCompile(`^v\d+\.\d+\.\d+`).MatchString(o.version) {
+		return fmt.Errorf("--version must be a semantic version starting with v (eg. v0.2.0), got %q", o.version)
+	}
+	if len(o.artifactsDir) == 0 {
+		return fmt.Errorf("directory with the release archives must be specified (--artifacts-dir)")
+	}
+	if _, err := template.New("url").Parse(o.urlTemplate); err != nil {
+		return fmt.Errorf("invalid --url-template: %v", err)
+	}
+	return nil
+}
+
+// platformRegexp matches the operating system and architecture in the name of a release archive.
+var platformRegexp = regexp.MustCompile(`(linux|darwin|windows)[_-](amd64|arm64|arm|386|ppc64le|s390x)\.(tar\.gz|zip)$`)
+
+type krewPlatform struct {
+	Selector krewSelector `json:"selector"`
+	URI      string       `json:"uri"`
+	Sha256   string       `json:"sha256"`
+	Files    []krewFile   `json:"files"`
+	Bin      string       `json:"bin"`
+}
+
+type krewSelector struct {
+	MatchLabels map[string]string `json:"matchLabels"`
+}
+
+type krewFile struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func (o *KrewManifestOptions) Run() error {
+	urlTemplate, err := template.New("url").Option("missingkey=error").Parse(o.urlTemplate)
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(o.artifactsDir)
+	if err != nil {
+		return err
+	}
+
+	platforms := []krewPlatform{}
+	for _, entry := range entries {
+		match := platformRegexp.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		goos, arch := match[1], match[2]
+		sum, err := sha256File(filepath.Join(o.artifactsDir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		uri := &bytes.Buffer{}
+		if err := urlTemplate.Execute(uri, map[string]string{"Version": o.version, "File": entry.Name()}); err != nil {
+			return fmt.Errorf("invalid --url-template: %v", err)
+		}
+
+		binary, bin := binaryName, PluginName
+		if goos == "windows" {
+			binary, bin = binary+".exe", bin+".exe"
+		}
+		platforms = append(platforms, krewPlatform{
+			Selector: krewSelector{MatchLabels: map[string]string{"os": goos, "arch": arch}},
+			URI:      uri.String(),
+			Sha256:   sum,
+			Files:    []krewFile{{From: binary, To: bin}},
+			Bin:      bin,
+		})
+	}
+	if len(platforms) == 0 {
+		return fmt.Errorf("no release archives named like %s_linux_amd64.tar.gz found in %s", binaryName, o.artifactsDir)
+	}
+	sort.Slice(platforms, func(i, j int) bool {
+		return platforms[i].URI < platforms[j].URI
+	})
+
+	manifest := map[string]interface{}{
+		"apiVersion": "krew.googlecontainertools.github.com/v1alpha2",
+		"kind":       "Plugin",
+		"metadata":   map[string]string{"name": strings.TrimPrefix(PluginName, "kubectl-")},
+		"spec": map[string]interface{}{
+			"version":          o.version,
+			"homepage":         "https://github.com/natamm4/audit-tool",
+			"shortDescription": "Collect and query Kubernetes API server audit logs",
+			"description": "Collects the audit logs of the API servers of a cluster and queries them: filter events by user,\n" +
+				"verb, resource and many more, aggregate them into top lists and latency reports, and export them.\n" +
+				"Run 'kubectl audit get' to collect the logs and 'kubectl audit query' to query them.\n",
+			"platforms": platforms,
+		},
+	}
+	data, err := yaml.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if len(o.outputFile) == 0 {
+		_, err = o.Out.Write(data)
+		return err
+	}
+	if err := os.WriteFile(o.outputFile, data, 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(o.ErrOut, "Wrote the krew manifest of %d platforms to %s\n", len(platforms), o.outputFile)
+	return nil
+}
+
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,202 @@
+package report
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/rbac"
+	"github.com/natamm4/audit-tool/pkg/cmd/query"
+)
+
+const (
+	accessReviewFormatTable = "table"
+	accessReviewFormatCSV   = "csv"
+	accessReviewFormatJSON  = "json"
+)
+
+type AccessReviewOptions struct {
+	format     string
+	outputFile string
+
+	// queryOptions selects and filters the events to review
+	queryOptions query.Options
+
+	genericclioptions.IOStreams
+}
+
+func NewAccessReviewCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &AccessReviewOptions{IOStreams: streams, queryOptions: query.Options{IOStreams: streams}, format: accessReviewFormatTable}
+	cmd := &cobra.Command{
+		Use:   "access-review --dir DIR",
+		Short: "List the distinct permissions every user exercised, for RBAC right-sizing and access reviews",
+		Long: "List the distinct verb, resource and namespace combinations every user exercised over the period of the\n" +
+			"audit files, the input of RBAC right-sizing and of quarterly access reviews. Only people are reviewed: service\n" +
+			"accounts, nodes and the other system: users are left out, and so are the requests that were denied.",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	options.queryOptions.AddTimeRangeFlags(cmd.Flags(), "review events")
+	options.queryOptions.AddMaxEventSizeFlag(cmd.Flags())
+	cmd.Flags().StringVarP(&options.format, "output", "o", options.format, "Format of the review: table, csv or json.")
+	cmd.Flags().StringVar(&options.outputFile, "output-file", "", "File to write the review to, it is written to stdout when not set.")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *AccessReviewOptions) Validate() error {
+	if len(o.queryOptions.TargetDirectories()) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	switch o.format {
+	case accessReviewFormatTable, accessReviewFormatCSV, accessReviewFormatJSON:
+	default:
+		return fmt.Errorf("invalid --output %q, must be %s, %s or %s", o.format, accessReviewFormatTable, accessReviewFormatCSV, accessReviewFormatJSON)
+	}
+	return nil
+}
+
+// accessReviewEntry is a permission a user exercised, it is written as is with -o json.
+type accessReviewEntry struct {
+	User      string    `json:"user"`
+	Verb      string    `json:"verb"`
+	APIGroup  string    `json:"apiGroup,omitempty"`
+	Resource  string    `json:"resource,omitempty"`
+	Namespace string    `json:"namespace,omitempty"`
+	URL       string    `json:"nonResourceURL,omitempty"`
+	Requests  int       `json:"requests"`
+	First     time.Time `json:"first"`
+	Last      time.Time `json:"last"`
+}
+
+func (o *AccessReviewOptions) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+
+	users := map[string]rbac.Usages{}
+	if err := o.queryOptions.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			if !rbac.IsHumanUser(e.User.Username) {
+				continue
+			}
+			p, ok := rbac.Exercised(e)
+			if !ok {
+				continue
+			}
+			usages, ok := users[e.User.Username]
+			if !ok {
+				usages = rbac.Usages{}
+				users[e.User.Username] = usages
+			}
+			usages.Add(p, e)
+		}
+	}); err != nil {
+		return err
+	}
+	if len(users) == 0 {
+		return fmt.Errorf("no permissions exercised by users in %v", o.queryOptions.TargetDirectories())
+	}
+
+	entries := []accessReviewEntry{}
+	for user, usages := range users {
+		for p, usage := range usages {
+			entries = append(entries, accessReviewEntry{
+				User:      user,
+				Verb:      p.Verb,
+				APIGroup:  p.APIGroup,
+				Resource:  p.Resource,
+				Namespace: p.Namespace,
+				URL:       p.NonResourceURL,
+				Requests:  usage.Requests.Len(),
+				First:     usage.First,
+				Last:      usage.Last,
+			})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		switch {
+		case a.User != b.User:
+			return a.User < b.User
+		case a.Namespace != b.Namespace:
+			return a.Namespace < b.Namespace
+		case a.APIGroup != b.APIGroup:
+			return a.APIGroup < b.APIGroup
+		case a.Resource != b.Resource:
+			return a.Resource < b.Resource
+		case a.URL != b.URL:
+			return a.URL < b.URL
+		}
+		return a.Verb < b.Verb
+	})
+
+	out := o.Out
+	if len(o.outputFile) > 0 {
+		f, err := os.Create(o.outputFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	switch o.format {
+	case accessReviewFormatJSON:
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(append(data, '\n'))
+		return err
+	case accessReviewFormatCSV:
+		return writeAccessReviewCSV(out, entries)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "USER\tVERB\tRESOURCE\tNAMESPACE\tREQUESTS\tFIRST\tLAST")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", e.User, e.Verb, e.permission().ResourceName(), query.MatrixKey(e.Namespace), e.Requests,
+			e.First.Local().Format(query.TimeDefaultFormat), e.Last.Local().Format(query.TimeDefaultFormat))
+	}
+	return w.Flush()
+}
+
+func (e accessReviewEntry) permission() rbac.Permission {
+	return rbac.Permission{Verb: e.Verb, APIGroup: e.APIGroup, Resource: e.Resource, Namespace: e.Namespace, NonResourceURL: e.URL}
+}
+
+// writeAccessReviewCSV writes the entries with RFC 3339 times, the spreadsheets reviewers sign off are built from it.
+func writeAccessReviewCSV(out io.Writer, entries []accessReviewEntry) error {
+	w := csv.NewWriter(out)
+	if err := w.Write([]string{"user", "verb", "apiGroup", "resource", "namespace", "nonResourceURL", "requests", "first", "last"}); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := w.Write([]string{e.User, e.Verb, e.APIGroup, e.Resource, e.Namespace, e.URL, strconv.Itoa(e.Requests),
+			e.First.UTC().Format(time.RFC3339), e.Last.UTC().Format(time.RFC3339)}); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,26 @@
+package report
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+)
+
+func NewCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "report",
+		Short: "Write reports of the audit events for privacy and compliance requests",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+
+	cmd.AddCommand(NewSubjectCommand(ctx, f, streams))
+	cmd.AddCommand(NewComplianceCommand(ctx, f, streams))
+	cmd.AddCommand(NewAccessReviewCommand(ctx, f, streams))
+	cmd.AddCommand(NewRBACCommand(ctx, f, streams))
+	cmd.AddCommand(NewUnusedPermissionsCommand(ctx, f, streams))
+	return cmd
+}
@@ -0,0 +1,455 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/sets"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/filter"
+	"github.com/natamm4/audit-tool/pkg/cmd/query"
+)
+
+const (
+	complianceAdminAccess    = "admin-access"
+	compliancePrivilegedExec = "privileged-exec"
+	complianceRBACChanges    = "rbac-changes"
+	complianceSecretAccess   = "secret-access"
+	complianceAuthFailures   = "authentication-failures"
+
+	complianceFormatMarkdown = "markdown"
+	complianceFormatHTML     = "html"
+	complianceFormatJSON     = "json"
+)
+
+// complianceSections are the sections of every compliance report, in the order they are rendered.
+var complianceSections = []struct {
+	id, title, description string
+}{
+	{complianceAdminAccess, "Administrative access", "Users acting with cluster-admin permissions, as members of system:masters or through a binding to the cluster-admin role."},
+	{compliancePrivilegedExec, "Privileged command execution", "Interactive access to running containers: exec, attach and port-forward into pods."},
+	{complianceRBACChanges, "Access control changes", "Creations, modifications and deletions of roles, cluster roles and their bindings."},
+	{complianceSecretAccess, "Access to secrets", "Reads and writes of secrets by user and namespace, excluding the control plane components unless --include-system."},
+	{complianceAuthFailures, "Authentication failures", "Requests rejected with 401 Unauthorized, by source IP and user agent."},
+}
+
+// complianceTemplate maps the sections of the report to the controls of a compliance framework.
+type complianceTemplate struct {
+	title    string
+	controls map[string]string
+}
+
+var complianceTemplates = map[string]complianceTemplate{
+	"soc2": {
+		title: "SOC 2 Kubernetes API access report",
+		controls: map[string]string{
+			complianceAdminAccess:    "CC6.1, CC6.3 - logical access to privileged functions",
+			compliancePrivilegedExec: "CC6.1, CC6.8 - privileged access to production workloads",
+			complianceRBACChanges:    "CC6.2, CC6.3, CC8.1 - provisioning and changes of access",
+			complianceSecretAccess:   "CC6.1, CC6.7 - access to credentials and confidential data",
+			complianceAuthFailures:   "CC7.2 - monitoring for anomalous access attempts",
+		},
+	},
+	"pci": {
+		title: "PCI DSS Kubernetes API audit trail report",
+		controls: map[string]string{
+			complianceAdminAccess:    "10.2.1.2 - actions taken by individuals with administrative access",
+			compliancePrivilegedExec: "10.2.1.2, 7.2.2 - privileged access to system components",
+			complianceRBACChanges:    "10.2.1.5 - changes to identification and authentication mechanisms and privileges",
+			complianceSecretAccess:   "10.2.1.1, 3.6 - access to sensitive data and cryptographic keys",
+			complianceAuthFailures:   "10.2.1.4 - invalid logical access attempts",
+		},
+	},
+}
+
+var rbacResources = sets.NewString("roles", "rolebindings", "clusterroles", "clusterrolebindings")
+
+func complianceTemplateNames() []string {
+	names := []string{}
+	for name := range complianceTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type ComplianceOptions struct {
+	template      string
+	format        string
+	outputFile    string
+	includeSystem bool
+
+	// queryOptions selects and filters the events to report
+	queryOptions query.Options
+
+	genericclioptions.IOStreams
+}
+
+func NewComplianceCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &ComplianceOptions{IOStreams: streams, queryOptions: query.Options{IOStreams: streams}, template: "soc2", format: complianceFormatMarkdown}
+	cmd := &cobra.Command{
+		Use:   "compliance --dir DIR --template soc2",
+		Short: "Write a compliance report of administrative access, privileged exec, RBAC changes, secret access and authentication failures",
+		Long: "Write a compliance report of administrative access, privileged exec, RBAC changes, secret access and\n" +
+			"authentication failures over the period of the audit files, the evidence auditors commonly ask for. The\n" +
+			"template (" + strings.Join(complianceTemplateNames(), ", ") + ") maps every section to the controls of its framework.\n" +
+			"The report is rendered as markdown, HTML or JSON.",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	options.queryOptions.AddTimeRangeFlags(cmd.Flags(), "report events")
+	options.queryOptions.AddMaxEventSizeFlag(cmd.Flags())
+	cmd.Flags().StringVar(&options.template, "template", options.template, "Compliance framework of the report: "+strings.Join(complianceTemplateNames(), ", ")+".")
+	cmd.Flags().StringVarP(&options.format, "output", "o", options.format, "Format of the report: markdown, html or json.")
+	cmd.Flags().StringVar(&options.outputFile, "output-file", "", "File to write the report to, it is written to stdout when not set.")
+	cmd.Flags().BoolVar(&options.includeSystem, "include-system", false, "Include the secret access of well-known control plane components ("+strings.Join(query.WellKnownSystemUsers.List(), ", ")+").")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *ComplianceOptions) Validate() error {
+	if len(o.queryOptions.TargetDirectories()) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	if _, ok := complianceTemplates[o.template]; !ok {
+		return fmt.Errorf("invalid --template %q, must be one of %s", o.template, strings.Join(complianceTemplateNames(), ", "))
+	}
+	switch o.format {
+	case complianceFormatMarkdown, complianceFormatHTML, complianceFormatJSON:
+	default:
+		return fmt.Errorf("invalid --output %q, must be %s, %s or %s", o.format, complianceFormatMarkdown, complianceFormatHTML, complianceFormatJSON)
+	}
+	return nil
+}
+
+// complianceReport is the rendered report, it is written as is with -o json.
+type complianceReport struct {
+	Title       string              `json:"title"`
+	Template    string              `json:"template"`
+	GeneratedAt time.Time           `json:"generatedAt"`
+	From        time.Time           `json:"from"`
+	To          time.Time           `json:"to"`
+	Events      int                 `json:"events"`
+	Sections    []complianceSection `json:"sections"`
+}
+
+type complianceSection struct {
+	ID          string     `json:"id"`
+	Title       string     `json:"title"`
+	Control     string     `json:"control"`
+	Description string     `json:"description"`
+	Columns     []string   `json:"columns"`
+	Rows        [][]string `json:"rows"`
+}
+
+// complianceRequest is a request of a section, its stages are merged by audit ID.
+type complianceRequest struct {
+	received time.Time
+	user     string
+	verb     string
+	resource string
+	ns, name string
+	sourceIP string
+	code     int32
+}
+
+// complianceActivity counts the requests of a user or client.
+type complianceActivity struct {
+	keys        []string
+	requests    sets.String
+	writes      sets.String
+	first, last time.Time
+}
+
+func (a *complianceActivity) add(e *auditv1.Event, write bool) {
+	a.requests.Insert(string(e.AuditID))
+	if write {
+		a.writes.Insert(string(e.AuditID))
+	}
+	received := e.RequestReceivedTimestamp.Time
+	if a.first.IsZero() || received.Before(a.first) {
+		a.first = received
+	}
+	if received.After(a.last) {
+		a.last = received
+	}
+}
+
+func (o *ComplianceOptions) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+	excludedUsers := sets.NewString()
+	for _, user := range query.WellKnownSystemUsers.UnsortedList() {
+		excludedUsers.Insert("-" + user)
+	}
+
+	report := &complianceReport{Template: o.template, Title: complianceTemplates[o.template].title, GeneratedAt: time.Now().UTC()}
+	admins := map[string]*complianceActivity{}
+	secrets := map[string]*complianceActivity{}
+	failures := map[string]*complianceActivity{}
+	execs := map[string]*complianceRequest{}
+	rbacChanges := map[string]*complianceRequest{}
+	activity := func(activities map[string]*complianceActivity, keys ...string) *complianceActivity {
+		key := strings.Join(keys, "\x00")
+		a, ok := activities[key]
+		if !ok {
+			a = &complianceActivity{keys: keys, requests: sets.NewString(), writes: sets.NewString()}
+			activities[key] = a
+		}
+		return a
+	}
+	if err := o.queryOptions.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			report.Events++
+			received := e.RequestReceivedTimestamp.Time
+			if report.From.IsZero() || received.Before(report.From) {
+				report.From = received
+			}
+			if received.After(report.To) {
+				report.To = received
+			}
+			write := !query.SecretReadVerbs.Has(e.Verb)
+			if e.ResponseStatus != nil && e.ResponseStatus.Code == 401 {
+				sourceIP := ""
+				if len(e.SourceIPs) > 0 {
+					sourceIP = e.SourceIPs[0]
+				}
+				activity(failures, sourceIP, e.UserAgent).add(e, false)
+				continue
+			}
+			if isClusterAdmin(e) {
+				activity(admins, e.User.Username).add(e, write)
+			}
+			if e.ObjectRef == nil {
+				continue
+			}
+			switch {
+			case e.ObjectRef.Resource == "pods" && query.ExecSubresources.Has(e.ObjectRef.Subresource):
+				mergeComplianceRequest(execs, e, e.ObjectRef.Subresource)
+			case e.ObjectRef.APIGroup == "rbac.authorization.k8s.io" && rbacResources.Has(e.ObjectRef.Resource) && query.SecretWriteVerbs.Has(e.Verb):
+				mergeComplianceRequest(rbacChanges, e, e.ObjectRef.Resource)
+			case e.ObjectRef.APIGroup == "" && e.ObjectRef.Resource == "secrets" && len(e.ObjectRef.Subresource) == 0:
+				if !o.includeSystem && !filter.AcceptString(excludedUsers, e.User.Username) {
+					continue
+				}
+				activity(secrets, e.User.Username, e.ObjectRef.Namespace).add(e, write)
+			}
+		}
+	}); err != nil {
+		return err
+	}
+	from, to := o.queryOptions.TimeRange()
+	if len(from) > 0 {
+		report.From = query.ParseTime(from)
+	}
+	if len(to) > 0 {
+		report.To = query.ParseTime(to)
+	}
+
+	controls := complianceTemplates[o.template].controls
+	for _, s := range complianceSections {
+		section := complianceSection{ID: s.id, Title: s.title, Control: controls[s.id], Description: s.description, Rows: [][]string{}}
+		switch s.id {
+		case complianceAdminAccess:
+			section.Columns = []string{"User", "Requests", "Writes", "First", "Last"}
+			section.Rows = activityRows(admins, true)
+		case compliancePrivilegedExec:
+			section.Columns = []string{"Time", "User", "Type", "Namespace", "Pod", "Source IP", "Code"}
+			section.Rows = requestRows(execs, false)
+		case complianceRBACChanges:
+			section.Columns = []string{"Time", "User", "Verb", "Resource", "Namespace", "Name", "Code"}
+			section.Rows = requestRows(rbacChanges, true)
+		case complianceSecretAccess:
+			section.Columns = []string{"User", "Namespace", "Requests", "Writes", "First", "Last"}
+			section.Rows = activityRows(secrets, true)
+		case complianceAuthFailures:
+			section.Columns = []string{"Source IP", "User agent", "Requests", "First", "Last"}
+			// only failed requests are counted, there are no writes
+			section.Rows = activityRows(failures, false)
+		}
+		report.Sections = append(report.Sections, section)
+	}
+
+	out := o.Out
+	if len(o.outputFile) > 0 {
+		f, err := os.Create(o.outputFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	switch o.format {
+	case complianceFormatJSON:
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(append(data, '\n'))
+		return err
+	case complianceFormatHTML:
+		return complianceHTMLTemplate.Execute(out, report)
+	default:
+		return writeComplianceMarkdown(out, report)
+	}
+}
+
+// isClusterAdmin returns whether the request was authorized by cluster-admin permissions.
+func isClusterAdmin(e *auditv1.Event) bool {
+	for _, group := range e.User.Groups {
+		if group == "system:masters" {
+			return true
+		}
+	}
+	return strings.Contains(e.Annotations["authorization.k8s.io/reason"], `"cluster-admin"`)
+}
+
+// mergeComplianceRequest records the request of the event, the response code is taken from the stage that has one.
+func mergeComplianceRequest(requests map[string]*complianceRequest, e *auditv1.Event, resource string) {
+	r, ok := requests[string(e.AuditID)]
+	if !ok {
+		r = &complianceRequest{received: e.RequestReceivedTimestamp.Time, user: e.User.Username, verb: e.Verb, resource: resource, ns: e.ObjectRef.Namespace, name: e.ObjectRef.Name}
+		if len(e.SourceIPs) > 0 {
+			r.sourceIP = e.SourceIPs[0]
+		}
+		requests[string(e.AuditID)] = r
+	}
+	if e.ResponseStatus != nil {
+		r.code = e.ResponseStatus.Code
+	}
+}
+
+func requestRows(requests map[string]*complianceRequest, withVerb bool) [][]string {
+	sorted := make([]*complianceRequest, 0, len(requests))
+	for _, r := range requests {
+		sorted = append(sorted, r)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].received.Before(sorted[j].received)
+	})
+	rows := [][]string{}
+	for _, r := range sorted {
+		code := ""
+		if r.code != 0 {
+			code = strconv.Itoa(int(r.code))
+		}
+		row := []string{r.received.UTC().Format(query.TimeDefaultFormat), r.user}
+		if withVerb {
+			row = append(row, r.verb, r.resource, r.ns, r.name, code)
+		} else {
+			row = append(row, r.resource, r.ns, r.name, r.sourceIP, code)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// activityRows returns the keys, requests, writes, first and last request of the activities, most requests first.
+func activityRows(activities map[string]*complianceActivity, withWrites bool) [][]string {
+	sorted := make([]*complianceActivity, 0, len(activities))
+	for _, a := range activities {
+		sorted = append(sorted, a)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].requests.Len() != sorted[j].requests.Len() {
+			return sorted[i].requests.Len() > sorted[j].requests.Len()
+		}
+		return strings.Join(sorted[i].keys, "\x00") < strings.Join(sorted[j].keys, "\x00")
+	})
+	rows := [][]string{}
+	for _, a := range sorted {
+		row := append([]string{}, a.keys...)
+		row = append(row, strconv.Itoa(a.requests.Len()))
+		if withWrites {
+			row = append(row, strconv.Itoa(a.writes.Len()))
+		}
+		row = append(row, a.first.UTC().Format(query.TimeDefaultFormat), a.last.UTC().Format(query.TimeDefaultFormat))
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func writeComplianceMarkdown(w io.Writer, report *complianceReport) error {
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "# %s\n\n", report.Title)
+	fmt.Fprintf(b, "- Period: %s to %s (UTC)\n", report.From.UTC().Format(query.TimeDefaultFormat), report.To.UTC().Format(query.TimeDefaultFormat))
+	fmt.Fprintf(b, "- Audit events: %d\n", report.Events)
+	fmt.Fprintf(b, "- Generated: %s\n", report.GeneratedAt.Format(time.RFC3339))
+	for _, section := range report.Sections {
+		fmt.Fprintf(b, "\n## %s\n\n", section.Title)
+		fmt.Fprintf(b, "**Control:** %s\n\n%s\n\n", section.Control, section.Description)
+		if len(section.Rows) == 0 {
+			b.WriteString("No events.\n")
+			continue
+		}
+		b.WriteString("| " + strings.Join(section.Columns, " | ") + " |\n")
+		b.WriteString("|" + strings.Repeat(" --- |", len(section.Columns)) + "\n")
+		for _, row := range section.Rows {
+			cells := make([]string, len(row))
+			for i, cell := range row {
+				cells[i] = strings.ReplaceAll(query.MatrixKey(cell), "|", `\|`)
+			}
+			b.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+		}
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+var complianceHTMLTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"format": func(t time.Time) string { return t.UTC().Format(query.TimeDefaultFormat) },
+	"cell":   query.MatrixKey,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+th { background: #eee; }
+.control { color: #555; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<ul>
+<li>Period: {{format .From}} to {{format .To}} (UTC)</li>
+<li>Audit events: {{.Events}}</li>
+<li>Generated: {{.GeneratedAt.Format "2006-01-02T15:04:05Z07:00"}}</li>
+</ul>
+{{range .Sections}}
+<h2>{{.Title}}</h2>
+<p class="control"><strong>Control:</strong> {{.Control}}</p>
+<p>{{.Description}}</p>
+{{if .Rows}}<table>
+<tr>{{range .Columns}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr>{{range .}}<td>{{cell .}}</td>{{end}}</tr>
+{{end}}</table>{{else}}<p>No events.</p>{{end}}
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,317 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+	"sigs.k8s.io/yaml"
+
+	"github.com/natamm4/audit-tool/pkg/audit/rbac"
+	"github.com/natamm4/audit-tool/pkg/cmd/query"
+)
+
+type RBACOptions struct {
+	serviceAccount string
+	name           string
+	diff           bool
+	outputFile     string
+
+	namespace, serviceAccountName string
+	client                        kubernetes.Interface
+
+	// queryOptions selects and filters the events the permissions are derived from
+	queryOptions query.Options
+
+	genericclioptions.IOStreams
+}
+
+func NewRBACCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &RBACOptions{IOStreams: streams, queryOptions: query.Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "rbac --dir DIR --service-account NAMESPACE:NAME",
+		Short: "Generate the least-privilege roles of a service account from the requests it made",
+		Long: "Generate the least-privilege roles of a service account from the requests it made over the period of the\n" +
+			"audit files: a Role and RoleBinding per namespace it accessed, and a ClusterRole and ClusterRoleBinding for the\n" +
+			"cluster-scoped resources, the requests across all namespaces and the non-resource URLs. Denied requests are left\n" +
+			"out. With --diff the rules currently bound to the service account are read from the cluster of the kubeconfig\n" +
+			"instead, the verbs it never exercised are marked with - and the permissions it exercised without a binding\n" +
+			"granting them (eg. through another authorizer) with +.",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	options.queryOptions.AddTimeRangeFlags(cmd.Flags(), "use events")
+	options.queryOptions.AddMaxEventSizeFlag(cmd.Flags())
+	cmd.Flags().StringVar(&options.serviceAccount, "service-account", "", "Service account to generate the roles of, as NAMESPACE:NAME or system:serviceaccount:NAMESPACE:NAME.")
+	cmd.Flags().StringVar(&options.name, "role-name", "", "Name of the generated roles and bindings. Defaults to NAME-least-privilege.")
+	cmd.Flags().BoolVar(&options.diff, "diff", false, "Compare the permissions exercised with the rules currently bound to the service account in the cluster.")
+	cmd.Flags().StringVar(&options.outputFile, "output-file", "", "File to write the roles or the diff to, they are written to stdout when not set.")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *RBACOptions) Validate() error {
+	if len(o.queryOptions.TargetDirectories()) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	parts := strings.Split(strings.TrimPrefix(o.serviceAccount, "system:serviceaccount:"), ":")
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return fmt.Errorf("service account must be specified as NAMESPACE:NAME (--service-account)")
+	}
+	o.namespace, o.serviceAccountName = parts[0], parts[1]
+	return nil
+}
+
+func (o *RBACOptions) Complete(ctx context.Context, f cmdutil.Factory) error {
+	if len(o.name) == 0 {
+		o.name = o.serviceAccountName + "-least-privilege"
+	}
+	if o.diff {
+		client, err := f.KubernetesClientSet()
+		if err != nil {
+			return err
+		}
+		o.client = client
+	}
+	return o.queryOptions.Complete(ctx, f)
+}
+
+func (o *RBACOptions) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+
+	username := rbac.ServiceAccountUsername(o.namespace, o.serviceAccountName)
+	usages := rbac.Usages{}
+	requests := sets.NewString()
+	var first, last time.Time
+	if err := o.queryOptions.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			if e.User.Username != username {
+				continue
+			}
+			p, ok := rbac.Exercised(e)
+			if !ok {
+				continue
+			}
+			usages.Add(p, e)
+			requests.Insert(string(e.AuditID))
+			received := e.RequestReceivedTimestamp.Time
+			if first.IsZero() || received.Before(first) {
+				first = received
+			}
+			if received.After(last) {
+				last = received
+			}
+		}
+	}); err != nil {
+		return err
+	}
+	if len(usages) == 0 {
+		return fmt.Errorf("no requests of %s in %v", username, o.queryOptions.TargetDirectories())
+	}
+
+	out := &bytes.Buffer{}
+	if o.diff {
+		rules, err := rbac.FetchBoundRules(ctx, o.client)
+		if err != nil {
+			return err
+		}
+		if err := writeRBACDiff(out, usages, rules, username, rbac.ServiceAccountGroups(o.namespace)); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintf(out, "# Permissions %s exercised in %d requests between %s and %s.\n", username, requests.Len(), first.UTC().Format(time.RFC3339), last.UTC().Format(time.RFC3339))
+		for _, object := range o.roles(usages) {
+			data, err := yaml.Marshal(object)
+			if err != nil {
+				return err
+			}
+			out.WriteString("---\n")
+			out.Write(data)
+		}
+	}
+	if len(o.outputFile) > 0 {
+		return os.WriteFile(o.outputFile, out.Bytes(), 0644)
+	}
+	_, err = io.Copy(o.Out, out)
+	return err
+}
+
+// roles returns the ClusterRole of the cluster-wide permissions and the Role of every namespace, each with its binding.
+func (o *RBACOptions) roles(usages rbac.Usages) []interface{} {
+	scopes := map[string][]rbac.Permission{}
+	for p := range usages {
+		scopes[p.Namespace] = append(scopes[p.Namespace], p)
+	}
+	subjects := []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: o.serviceAccountName, Namespace: o.namespace}}
+
+	objects := []interface{}{}
+	if permissions, ok := scopes[""]; ok {
+		objects = append(objects,
+			&rbacv1.ClusterRole{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+				ObjectMeta: metav1.ObjectMeta{Name: o.name},
+				Rules:      policyRules(permissions),
+			},
+			&rbacv1.ClusterRoleBinding{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRoleBinding"},
+				ObjectMeta: metav1.ObjectMeta{Name: o.name},
+				RoleRef:    rbacv1.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "ClusterRole", Name: o.name},
+				Subjects:   subjects,
+			},
+		)
+	}
+	namespaces := []string{}
+	for namespace := range scopes {
+		if len(namespace) > 0 {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	sort.Strings(namespaces)
+	for _, namespace := range namespaces {
+		objects = append(objects,
+			&rbacv1.Role{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+				ObjectMeta: metav1.ObjectMeta{Name: o.name, Namespace: namespace},
+				Rules:      policyRules(scopes[namespace]),
+			},
+			&rbacv1.RoleBinding{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+				ObjectMeta: metav1.ObjectMeta{Name: o.name, Namespace: namespace},
+				RoleRef:    rbacv1.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "Role", Name: o.name},
+				Subjects:   subjects,
+			},
+		)
+	}
+	return objects
+}
+
+// policyRules returns the rules granting the permissions, the resources of an API group exercised with the same verbs
+// share a rule.
+func policyRules(permissions []rbac.Permission) []rbacv1.PolicyRule {
+	type target struct{ apiGroup, resource, url string }
+	verbs := map[target]sets.String{}
+	for _, p := range permissions {
+		t := target{apiGroup: p.APIGroup, resource: p.Resource, url: p.NonResourceURL}
+		if _, ok := verbs[t]; !ok {
+			verbs[t] = sets.NewString()
+		}
+		verbs[t].Insert(p.Verb)
+	}
+
+	rules := map[string]*rbacv1.PolicyRule{}
+	for t, v := range verbs {
+		key := strings.Join(v.List(), ",")
+		if len(t.url) > 0 {
+			key = "\x00" + key
+		} else {
+			key = t.apiGroup + "\x00" + key
+		}
+		rule, ok := rules[key]
+		if !ok {
+			rule = &rbacv1.PolicyRule{Verbs: v.List()}
+			if len(t.url) == 0 {
+				rule.APIGroups = []string{t.apiGroup}
+			}
+			rules[key] = rule
+		}
+		if len(t.url) > 0 {
+			rule.NonResourceURLs = append(rule.NonResourceURLs, t.url)
+		} else {
+			rule.Resources = append(rule.Resources, t.resource)
+		}
+	}
+
+	result := []rbacv1.PolicyRule{}
+	for _, rule := range rules {
+		sort.Strings(rule.Resources)
+		sort.Strings(rule.NonResourceURLs)
+		result = append(result, *rule)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if len(a.NonResourceURLs) != len(b.NonResourceURLs) {
+			return len(a.NonResourceURLs) < len(b.NonResourceURLs)
+		}
+		if len(a.APIGroups) > 0 && len(b.APIGroups) > 0 && a.APIGroups[0] != b.APIGroups[0] {
+			return a.APIGroups[0] < b.APIGroups[0]
+		}
+		return rbac.DescribeRule(a) < rbac.DescribeRule(b)
+	})
+	return result
+}
+
+// writeRBACDiff writes a line per verb of the rules bound to the user, prefixed by - when the user never exercised it,
+// followed by the permissions exercised that no bound rule grants, prefixed by +.
+func writeRBACDiff(out io.Writer, usages rbac.Usages, rules []rbac.BoundRule, username string, groups []string) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\tSCOPE\tVERB\tRESOURCE\tROLE\tBINDING")
+	covered := map[rbac.Permission]bool{}
+	for _, rule := range rules {
+		if !rule.BoundTo(username, groups) {
+			continue
+		}
+		for _, verb := range rule.Verbs {
+			used := false
+			for p := range usages {
+				if (verb == rbacv1.VerbAll || p.Verb == verb) && rule.Covers(p) {
+					covered[p] = true
+					used = true
+				}
+			}
+			prefix := " "
+			if !used {
+				prefix = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", prefix, rule.Scope(), verb, rbac.DescribeRule(rule.PolicyRule), rule.Role, rule.Binding)
+		}
+	}
+
+	ungranted := []rbac.Permission{}
+	for p := range usages {
+		if !covered[p] {
+			ungranted = append(ungranted, p)
+		}
+	}
+	sort.Slice(ungranted, func(i, j int) bool {
+		a, b := ungranted[i], ungranted[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.ResourceName() != b.ResourceName() {
+			return a.ResourceName() < b.ResourceName()
+		}
+		return a.Verb < b.Verb
+	})
+	for _, p := range ungranted {
+		scope := p.Namespace
+		if len(scope) == 0 {
+			scope = "<cluster>"
+		}
+		fmt.Fprintf(w, "+\t%s\t%s\t%s\t\t\n", scope, p.Verb, p.ResourceName())
+	}
+	return w.Flush()
+}
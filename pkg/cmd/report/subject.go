@@ -0,0 +1,397 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/manifest"
+	"github.com/natamm4/audit-tool/pkg/cmd/export"
+	"github.com/natamm4/audit-tool/pkg/cmd/query"
+)
+
+const (
+	subjectEventsFile  = "events.json"
+	subjectSummaryFile = "summary.json"
+
+	// the roles of the subject in an event
+	subjectActor        = "actor"
+	subjectImpersonated = "impersonated"
+	subjectObject       = "object"
+	subjectMentioned    = "mentioned"
+)
+
+// identityResources are the resources named after the user they describe.
+var identityResources = sets.NewString("users", "identities", "useridentitymappings")
+
+// userFields are the fields of the request and response objects holding a user name, eg. the spec.username of a
+// CertificateSigningRequest or the userName of an OAuth token.
+var userFields = sets.NewString("user", "username", "userName")
+
+type SubjectOptions struct {
+	subjects        []string
+	outputDirectory string
+	redact          bool
+
+	// queryOptions selects and filters the events to report
+	queryOptions query.Options
+
+	genericclioptions.IOStreams
+}
+
+func NewSubjectCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &SubjectOptions{IOStreams: streams, queryOptions: query.Options{IOStreams: streams}, redact: true}
+	cmd := &cobra.Command{
+		Use:   "subject --dir DIR --subject USER --output-dir DIR",
+		Short: "Extract every event attributable to a user into a bundle for a data subject access request",
+		Long: "Extract every event attributable to a user into a bundle for a data subject access request (GDPR art. 15).\n\n" +
+			"An event is attributable to the subject when the subject made or was impersonated by the request, when the\n" +
+			"request was about the User, Identity or UserIdentityMapping of the subject, or when its request or response\n" +
+			"object mentions the subject, eg. a RoleBinding granting it permissions. The subjects are user names or emails,\n" +
+			"matched case-insensitively, a trailing * matches a prefix.\n\n" +
+			"The bundle holds the events (" + subjectEventsFile + "), a summary of the activity of the subject (" + subjectSummaryFile + ") and\n" +
+			"the manifest of their checksums. The other users of the events are replaced by pseudonyms and their source\n" +
+			"IPs and user agents removed, unless --redact=false.",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	options.queryOptions.AddTimeRangeFlags(cmd.Flags(), "report events")
+	options.queryOptions.AddMaxEventSizeFlag(cmd.Flags())
+	cmd.Flags().StringSliceVar(&options.subjects, "subject", options.subjects, "User names or emails of the data subject, eg. jane@example.com or 'jane*'.")
+	cmd.Flags().StringVar(&options.outputDirectory, "output-dir", "", "Directory to write the bundle to.")
+	cmd.Flags().BoolVar(&options.redact, "redact", options.redact, "Replace the other users of the events by pseudonyms and remove their source IPs and user agents.")
+
+	return cmd
+}
+
+func (o *SubjectOptions) Validate() error {
+	if len(o.queryOptions.TargetDirectories()) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	if len(o.subjects) == 0 {
+		return fmt.Errorf("user name or email of the data subject must be specified (--subject)")
+	}
+	for _, subject := range o.subjects {
+		if len(strings.TrimSuffix(subject, "*")) == 0 {
+			return fmt.Errorf("invalid --subject %q, it must not match every user", subject)
+		}
+	}
+	if len(o.outputDirectory) == 0 {
+		return fmt.Errorf("output directory must be specified (--output-dir)")
+	}
+	return nil
+}
+
+// subjectMatcher matches user names against the subjects, case-insensitively.
+type subjectMatcher []string
+
+func newSubjectMatcher(subjects []string) subjectMatcher {
+	m := subjectMatcher{}
+	for _, subject := range subjects {
+		m = append(m, strings.ToLower(subject))
+	}
+	return m
+}
+
+func (m subjectMatcher) matches(name string) bool {
+	name = strings.ToLower(name)
+	for _, subject := range m {
+		if strings.HasSuffix(subject, "*") {
+			if strings.HasPrefix(name, strings.TrimSuffix(subject, "*")) {
+				return true
+			}
+		} else if name == subject {
+			return true
+		}
+	}
+	return false
+}
+
+// mentionedIn returns whether a string of the JSON object matches a subject.
+func (m subjectMatcher) mentionedIn(object *runtime.Unknown) bool {
+	if object == nil || len(object.Raw) == 0 {
+		return false
+	}
+	raw := bytes.ToLower(object.Raw)
+	candidate := false
+	for _, subject := range m {
+		if bytes.Contains(raw, []byte(strings.TrimSuffix(subject, "*"))) {
+			candidate = true
+			break
+		}
+	}
+	if !candidate {
+		return false
+	}
+	var value interface{}
+	if err := json.Unmarshal(object.Raw, &value); err != nil {
+		return false
+	}
+	return m.matchesString(value)
+}
+
+// matchesString returns whether a string of the decoded JSON value matches a subject.
+func (m subjectMatcher) matchesString(value interface{}) bool {
+	switch v := value.(type) {
+	case string:
+		return m.matches(v)
+	case map[string]interface{}:
+		for _, child := range v {
+			if m.matchesString(child) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			if m.matchesString(child) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// roles returns the roles of the subject in the event, none when the event isn't attributable to the subject.
+func (m subjectMatcher) roles(e *auditv1.Event) []string {
+	roles := []string{}
+	if m.matches(e.User.Username) {
+		roles = append(roles, subjectActor)
+	}
+	if e.ImpersonatedUser != nil && m.matches(e.ImpersonatedUser.Username) {
+		roles = append(roles, subjectImpersonated)
+	}
+	if e.ObjectRef != nil && identityResources.Has(e.ObjectRef.Resource) && m.matches(e.ObjectRef.Name) {
+		roles = append(roles, subjectObject)
+	}
+	if len(roles) == 0 && (m.mentionedIn(e.RequestObject) || m.mentionedIn(e.ResponseObject)) {
+		roles = append(roles, subjectMentioned)
+	}
+	return roles
+}
+
+// subjectSummary is the activity of the data subject written to the bundle.
+type subjectSummary struct {
+	Subjects    []string           `json:"subjects"`
+	GeneratedAt time.Time          `json:"generatedAt"`
+	Events      int                `json:"events"`
+	First       *time.Time         `json:"first,omitempty"`
+	Last        *time.Time         `json:"last,omitempty"`
+	Roles       map[string]int     `json:"roles"`
+	Usernames   []string           `json:"usernames"`
+	SourceIPs   []string           `json:"sourceIPs"`
+	UserAgents  []string           `json:"userAgents"`
+	Activities  []*subjectActivity `json:"activities"`
+	Redacted    bool               `json:"redacted"`
+}
+
+type activityKey struct {
+	verb, resource, namespace string
+}
+
+// subjectActivity counts the requests of the subject of a verb to the resources of a namespace.
+type subjectActivity struct {
+	Verb      string `json:"verb"`
+	Resource  string `json:"resource"`
+	Namespace string `json:"namespace,omitempty"`
+	Requests  int    `json:"requests"`
+
+	// auditIDs count every stage of a request once
+	auditIDs sets.String
+}
+
+func (o *SubjectOptions) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(o.outputDirectory, os.ModePerm); err != nil {
+		return err
+	}
+	events, err := export.NewFile(filepath.Join(o.outputDirectory, subjectEventsFile), export.FormatJSON)
+	if err != nil {
+		return err
+	}
+	defer events.Close()
+
+	matcher := newSubjectMatcher(o.subjects)
+	summary := &subjectSummary{Subjects: o.subjects, GeneratedAt: time.Now().UTC(), Roles: map[string]int{}, Redacted: o.redact}
+	usernames, sourceIPs, userAgents := sets.NewString(), sets.NewString(), sets.NewString()
+	activities := map[activityKey]*subjectActivity{}
+	var writeErr error
+	if err := o.queryOptions.MultiNodeEventVisitor(ctx, filters, true, func(batch []*auditv1.Event) {
+		for _, e := range batch {
+			if writeErr != nil {
+				return
+			}
+			roles := matcher.roles(e)
+			if len(roles) == 0 {
+				continue
+			}
+			summary.Events++
+			for _, role := range roles {
+				summary.Roles[role]++
+			}
+			received := e.RequestReceivedTimestamp.Time.UTC()
+			if summary.First == nil || received.Before(*summary.First) {
+				summary.First = &received
+			}
+			if summary.Last == nil || received.After(*summary.Last) {
+				summary.Last = &received
+			}
+			if roles[0] == subjectActor {
+				usernames.Insert(e.User.Username)
+				sourceIPs.Insert(e.SourceIPs...)
+				userAgents.Insert(e.UserAgent)
+				key := activityKey{verb: e.Verb}
+				if e.ObjectRef != nil {
+					key.resource, key.namespace = e.ObjectRef.Resource, e.ObjectRef.Namespace
+					if len(e.ObjectRef.Subresource) > 0 {
+						key.resource += "/" + e.ObjectRef.Subresource
+					}
+				} else {
+					key.resource = e.RequestURI
+				}
+				activity, ok := activities[key]
+				if !ok {
+					activity = &subjectActivity{Verb: key.verb, Resource: key.resource, Namespace: key.namespace, auditIDs: sets.NewString()}
+					activities[key] = activity
+				}
+				activity.auditIDs.Insert(string(e.AuditID))
+			}
+			if o.redact {
+				e = redactOtherUsers(e.DeepCopy(), matcher)
+			}
+			writeErr = events.Write(e)
+		}
+	}); err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+	if err := events.Close(); err != nil {
+		return err
+	}
+
+	summary.Usernames, summary.SourceIPs, summary.UserAgents = usernames.List(), sourceIPs.List(), userAgents.List()
+	for _, activity := range activities {
+		activity.Requests = activity.auditIDs.Len()
+		summary.Activities = append(summary.Activities, activity)
+	}
+	sort.Slice(summary.Activities, func(i, j int) bool {
+		if summary.Activities[i].Requests != summary.Activities[j].Requests {
+			return summary.Activities[i].Requests > summary.Activities[j].Requests
+		}
+		a, b := summary.Activities[i], summary.Activities[j]
+		return a.Verb+a.Resource+a.Namespace < b.Verb+b.Resource+b.Namespace
+	})
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(o.outputDirectory, subjectSummaryFile), append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	if err := manifest.Write(o.outputDirectory, &manifest.Manifest{CollectedAt: summary.GeneratedAt}); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(o.Out, "Wrote %d events attributable to %s to %s\n", summary.Events, strings.Join(o.subjects, ", "), o.outputDirectory)
+	if len(summary.Activities) == 0 {
+		return nil
+	}
+	fmt.Fprintln(o.Out)
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "VERB\tRESOURCE\tNAMESPACE\tREQUESTS")
+	for _, activity := range summary.Activities {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", activity.Verb, activity.Resource, query.MatrixKey(activity.Namespace), activity.Requests)
+	}
+	return nil
+}
+
+// redactOtherUsers replaces the users of the event that are not the subject by pseudonyms, keeping the requests of a
+// user related, and removes where their requests came from. The system users and service accounts are no persons and
+// are kept.
+func redactOtherUsers(e *auditv1.Event, matcher subjectMatcher) *auditv1.Event {
+	if !matcher.matches(e.User.Username) && !strings.HasPrefix(e.User.Username, "system:") {
+		e.User = redactedUser(e.User)
+		e.SourceIPs = nil
+		e.UserAgent = ""
+	}
+	if e.ImpersonatedUser != nil && !matcher.matches(e.ImpersonatedUser.Username) && !strings.HasPrefix(e.ImpersonatedUser.Username, "system:") {
+		user := redactedUser(*e.ImpersonatedUser)
+		e.ImpersonatedUser = &user
+	}
+	for _, object := range []*runtime.Unknown{e.RequestObject, e.ResponseObject} {
+		if object == nil || len(object.Raw) == 0 {
+			continue
+		}
+		var value interface{}
+		if err := json.Unmarshal(object.Raw, &value); err != nil {
+			continue
+		}
+		redactUserFields(value, matcher)
+		if raw, err := json.Marshal(value); err == nil {
+			object.Raw = raw
+		}
+	}
+	return e
+}
+
+// redactUserFields replaces the user names of the object that are not the subject, the user fields and the users
+// of the subjects of role bindings.
+func redactUserFields(value interface{}, matcher subjectMatcher) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if kind, _ := v["kind"].(string); kind == "User" {
+			if name, ok := v["name"].(string); ok && !matcher.matches(name) && !strings.HasPrefix(name, "system:") {
+				v["name"] = pseudonym(name)
+			}
+		}
+		for key, child := range v {
+			if s, ok := child.(string); ok && userFields.Has(key) && len(s) > 0 && !matcher.matches(s) && !strings.HasPrefix(s, "system:") {
+				v[key] = pseudonym(s)
+				continue
+			}
+			redactUserFields(child, matcher)
+		}
+	case []interface{}:
+		for _, child := range v {
+			redactUserFields(child, matcher)
+		}
+	}
+}
+
+func redactedUser(user authenticationv1.UserInfo) authenticationv1.UserInfo {
+	return authenticationv1.UserInfo{Username: pseudonym(user.Username), Groups: user.Groups}
+}
+
+// pseudonym replaces a user name by the same name every time, so that the requests of the user can still be related.
+func pseudonym(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return "redacted-" + hex.EncodeToString(sum[:6])
+}
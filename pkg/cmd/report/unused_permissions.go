@@ -0,0 +1,234 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/rbac"
+	"github.com/natamm4/audit-tool/pkg/cmd/query"
+)
+
+type UnusedPermissionsOptions struct {
+	subjects      []string
+	includeSystem bool
+	summary       bool
+
+	client kubernetes.Interface
+
+	// queryOptions selects and filters the events the usage is derived from
+	queryOptions query.Options
+
+	genericclioptions.IOStreams
+}
+
+func NewUnusedPermissionsCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &UnusedPermissionsOptions{IOStreams: streams, queryOptions: query.Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "unused-permissions --dir DIR",
+		Short: "List the permissions bound in the cluster that their subjects never exercised",
+		Long: "List the permissions bound in the cluster that their subjects never exercised over the period of the audit\n" +
+			"files, to drive privilege reduction. The roles and bindings are read from the cluster of the kubeconfig, every\n" +
+			"verb of a rule bound to a user, group or service account is unused when no request of the subject (or of a\n" +
+			"member of the group) was allowed by it. The resource names of the rules are not taken into account. The\n" +
+			"system: users and groups, bound to the control plane components by the default roles, are left out unless\n" +
+			"--include-system.",
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate())
+			options.queryOptions.CheckErr(options.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	options.queryOptions.AddTimeRangeFlags(cmd.Flags(), "use events")
+	options.queryOptions.AddMaxEventSizeFlag(cmd.Flags())
+	cmd.Flags().StringSliceVar(&options.subjects, "subject", options.subjects, "Only report these subjects, as User/NAME, Group/NAME or ServiceAccount/NAMESPACE/NAME.")
+	cmd.Flags().BoolVar(&options.includeSystem, "include-system", false, "Include the system: users and groups.")
+	cmd.Flags().BoolVar(&options.summary, "summary", false, "Print the number of unused and bound verbs per subject instead of the unused verbs.")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *UnusedPermissionsOptions) Validate() error {
+	if len(o.queryOptions.TargetDirectories()) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	for _, s := range o.subjects {
+		parts := strings.Split(s, "/")
+		switch {
+		case len(parts) == 2 && (parts[0] == rbacv1.UserKind || parts[0] == rbacv1.GroupKind):
+		case len(parts) == 3 && parts[0] == rbacv1.ServiceAccountKind:
+		default:
+			return fmt.Errorf("invalid --subject %q, must be User/NAME, Group/NAME or ServiceAccount/NAMESPACE/NAME", s)
+		}
+	}
+	return nil
+}
+
+func (o *UnusedPermissionsOptions) Complete(ctx context.Context, f cmdutil.Factory) error {
+	client, err := f.KubernetesClientSet()
+	if err != nil {
+		return err
+	}
+	o.client = client
+	return o.queryOptions.Complete(ctx, f)
+}
+
+// subjectName returns the subject as the --subject flag takes it, eg. ServiceAccount/kube-system/default.
+func subjectName(s rbacv1.Subject) string {
+	if s.Kind == rbacv1.ServiceAccountKind {
+		return s.Kind + "/" + s.Namespace + "/" + s.Name
+	}
+	return s.Kind + "/" + s.Name
+}
+
+// userUsage are the permissions a user exercised and the groups it authenticated with.
+type userUsage struct {
+	groups sets.String
+	rbac.Usages
+}
+
+func (o *UnusedPermissionsOptions) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+
+	users := map[string]*userUsage{}
+	if err := o.queryOptions.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			p, ok := rbac.Exercised(e)
+			if !ok {
+				continue
+			}
+			u, ok := users[e.User.Username]
+			if !ok {
+				u = &userUsage{groups: sets.NewString(), Usages: rbac.Usages{}}
+				users[e.User.Username] = u
+			}
+			u.groups.Insert(e.User.Groups...)
+			u.Add(p, e)
+		}
+	}); err != nil {
+		return err
+	}
+	groupMembers := map[string][]*userUsage{}
+	for _, u := range users {
+		for _, group := range u.groups.UnsortedList() {
+			groupMembers[group] = append(groupMembers[group], u)
+		}
+	}
+
+	rules, err := rbac.FetchBoundRules(ctx, o.client)
+	if err != nil {
+		return err
+	}
+
+	type unusedVerb struct {
+		subject string
+		rule    rbac.BoundRule
+		verb    string
+	}
+	type subjectSummary struct {
+		kind            string
+		requests        sets.String
+		unused, granted int
+	}
+	selected := sets.NewString(o.subjects...)
+	unused := []unusedVerb{}
+	summaries := map[string]*subjectSummary{}
+	for _, rule := range rules {
+		for _, s := range rule.Subjects {
+			name := subjectName(s)
+			if selected.Len() > 0 && !selected.Has(name) {
+				continue
+			}
+			if !o.includeSystem && s.Kind != rbacv1.ServiceAccountKind && strings.HasPrefix(s.Name, "system:") {
+				continue
+			}
+			var members []*userUsage
+			switch s.Kind {
+			case rbacv1.UserKind:
+				if u, ok := users[s.Name]; ok {
+					members = []*userUsage{u}
+				}
+			case rbacv1.ServiceAccountKind:
+				if u, ok := users[rbac.ServiceAccountUsername(s.Namespace, s.Name)]; ok {
+					members = []*userUsage{u}
+				}
+			case rbacv1.GroupKind:
+				members = groupMembers[s.Name]
+			}
+
+			summary, ok := summaries[name]
+			if !ok {
+				summary = &subjectSummary{kind: s.Kind, requests: sets.NewString()}
+				for _, u := range members {
+					for _, usage := range u.Usages {
+						summary.requests.Insert(usage.Requests.UnsortedList()...)
+					}
+				}
+				summaries[name] = summary
+			}
+			for _, verb := range rule.Verbs {
+				summary.granted++
+				if !verbExercised(rule, verb, members) {
+					summary.unused++
+					unused = append(unused, unusedVerb{subject: name, rule: rule, verb: verb})
+				}
+			}
+		}
+	}
+	if len(summaries) == 0 {
+		return fmt.Errorf("no bindings of the subjects found in the cluster")
+	}
+
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	if o.summary {
+		names := []string{}
+		for name := range summaries {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintln(w, "SUBJECT\tREQUESTS\tUNUSED\tBOUND")
+		for _, name := range names {
+			s := summaries[name]
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", name, s.requests.Len(), s.unused, s.granted)
+		}
+		return w.Flush()
+	}
+
+	sort.SliceStable(unused, func(i, j int) bool {
+		return unused[i].subject < unused[j].subject
+	})
+	fmt.Fprintln(w, "SUBJECT\tSCOPE\tVERB\tRESOURCE\tROLE\tBINDING")
+	for _, u := range unused {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", u.subject, u.rule.Scope(), u.verb, rbac.DescribeRule(u.rule.PolicyRule), u.rule.Role, u.rule.Binding)
+	}
+	return w.Flush()
+}
+
+// verbExercised returns whether a request of one of the users was allowed by the verb of the rule.
+func verbExercised(rule rbac.BoundRule, verb string, users []*userUsage) bool {
+	for _, u := range users {
+		for p := range u.Usages {
+			if (verb == rbacv1.VerbAll || p.Verb == verb) && rule.Covers(p) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package sql
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/sql"
+	"github.com/natamm4/audit-tool/pkg/cmd/query"
+)
+
+const (
+	sqlOutputTable = "table"
+	sqlOutputCSV   = "csv"
+)
+
+type Options struct {
+	output string
+	query  *sql.Query
+
+	// queryOptions selects the audit files and pre-filters the events with the filter flags
+	queryOptions query.Options
+
+	genericclioptions.IOStreams
+}
+
+func NewCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &Options{IOStreams: streams, queryOptions: query.Options{IOStreams: streams}}
+	cmd := &cobra.Command{
+		Use:   "sql --dir DIR STATEMENT",
+		Short: "Run a SQL SELECT statement over the audit events",
+		Long: "Run a SQL SELECT statement over the audit events, which are the table events, eg.\n\n" +
+			"  audit-tool sql -d DIR \"SELECT user, count(*) FROM events WHERE code = 403 GROUP BY 1 ORDER BY 2 DESC\"\n\n" +
+			"The columns of the events table are " + strings.Join(sql.Columns(), ", ") + ".\n" +
+			"Timestamps are compared with strings like '2021-09-01 10:00', latency is in milliseconds, the object\n" +
+			"reference columns are NULL for non-resource requests and the code is NULL without a response status.\n\n" +
+			"Supported are SELECT with columns and the aggregate functions " + strings.Join(sql.Functions(), ", ") + ", WHERE with\n" +
+			"comparisons, LIKE, IN and IS NULL combined by AND, OR and NOT, GROUP BY, ORDER BY and LIMIT. Other SQL is rejected.\n" +
+			"The events are streamed through the statement, directories exported with export parquet are read too.\n" +
+			"The filter flags select the events before the statement runs, which is faster than filtering in WHERE.",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			options.queryOptions.CheckUsage(options.Validate(args[0]))
+			options.queryOptions.CheckErr(options.queryOptions.Complete(ctx, f))
+			options.queryOptions.Exit(options.Run(ctx))
+		},
+	}
+
+	options.queryOptions.AddDirectoryFlags(cmd.Flags())
+	options.queryOptions.AddErrorFormatFlag(cmd)
+	options.queryOptions.AddTimeRangeFlags(cmd.Flags(), "query events")
+	options.queryOptions.AddMaxEventSizeFlag(cmd.Flags())
+	cmd.Flags().StringVarP(&options.output, "output", "o", sqlOutputTable, "Format of the result: table or csv.")
+	options.queryOptions.AddFilterFlags(cmd.Flags())
+
+	return cmd
+}
+
+func (o *Options) Validate(statement string) error {
+	if len(o.queryOptions.TargetDirectories()) == 0 {
+		return fmt.Errorf("directory with audit files must be specified (--dir/-d)")
+	}
+	if o.output != sqlOutputTable && o.output != sqlOutputCSV {
+		return fmt.Errorf("invalid --output %q, must be %s or %s", o.output, sqlOutputTable, sqlOutputCSV)
+	}
+	query, err := sql.Compile(statement)
+	if err != nil {
+		return err
+	}
+	o.query = query
+	return nil
+}
+
+func (o *Options) Run(ctx context.Context) error {
+	filters, err := o.queryOptions.SetupFilters()
+	if err != nil {
+		return err
+	}
+
+	var queryErr error
+	if err := o.queryOptions.MultiNodeEventVisitor(ctx, filters, true, func(events []*auditv1.Event) {
+		for _, e := range events {
+			if queryErr != nil {
+				return
+			}
+			queryErr = o.query.Add(e)
+		}
+	}); err != nil {
+		return err
+	}
+	if queryErr != nil {
+		return queryErr
+	}
+	rows, err := o.query.Result()
+	if err != nil {
+		return err
+	}
+
+	record := make([]string, len(o.query.Columns()))
+	if o.output == sqlOutputCSV {
+		w := csv.NewWriter(o.Out)
+		w.Write(o.query.Columns())
+		for _, row := range rows {
+			for i, v := range row {
+				record[i] = sql.FormatValue(v)
+			}
+			w.Write(record)
+		}
+		w.Flush()
+		return w.Error()
+	}
+
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(o.query.Columns(), "\t"))
+	for _, row := range rows {
+		for i, v := range row {
+			// tabs and newlines in values would break the table
+			record[i] = strings.NewReplacer("\t", " ", "\n", " ").Replace(sql.FormatValue(v))
+		}
+		fmt.Fprintln(w, strings.Join(record, "\t"))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if len(rows) == 1 {
+		fmt.Fprintln(o.Out, "(1 row)")
+	} else {
+		fmt.Fprintf(o.Out, "(%d rows)\n", len(rows))
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package verify
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/audit/manifest"
+)
+
+type Options struct {
+	dirs          []string
+	publicKeyFile string
+
+	publicKey ed25519.PublicKey
+
+	genericclioptions.IOStreams
+}
+
+func NewCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	options := &Options{IOStreams: streams}
+	cmd := &cobra.Command{
+		Use:   "verify --dir DIR [--public-key FILE]",
+		Short: "Verify that the audit files were not modified since they were collected",
+		Long: "Verify that the audit files were not modified since they were collected. Every file of the directory is\n" +
+			"compared with the checksum the manifest written by get recorded, a modified, truncated, missing or added file\n" +
+			"fails the verification. When get signed the manifest (--sign-key), --public-key verifies the signature too,\n" +
+			"so that a manifest rewritten to match modified files is detected as well.",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmdutil.CheckErr(options.Validate())
+			cmdutil.CheckErr(options.Run())
+		},
+	}
+
+	cmd.Flags().StringArrayVarP(&options.dirs, "dir", "d", options.dirs, "Directory with the audit files and their manifest. Can be specified multiple times, a glob pattern (eg. 'fleet/*') verifies a directory of clusters.")
+	cmd.Flags().StringVar(&options.publicKeyFile, "public-key", "", "Ed25519 public key (PEM) of the key the manifests were signed with. Signed manifests fail the verification without it.")
+
+	return cmd
+}
+
+func (o *Options) Validate() error {
+	if len(o.dirs) == 0 {
+		return fmt.Errorf("directory to verify must be specified (--dir/-d)")
+	}
+	if len(o.publicKeyFile) > 0 {
+		key, err := manifest.LoadPublicKey(o.publicKeyFile)
+		if err != nil {
+			return err
+		}
+		o.publicKey = key
+	}
+	return nil
+}
+
+func (o *Options) Run() error {
+	dirs := []string{}
+	for _, pattern := range o.dirs {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid --dir %q: %v", pattern, err)
+		}
+		found := false
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.IsDir() {
+				dirs = append(dirs, match)
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("no directory matches --dir %q", pattern)
+		}
+	}
+
+	failed := 0
+	for _, dir := range dirs {
+		problems, summary, err := o.verify(dir)
+		if err != nil {
+			return err
+		}
+		if len(problems) == 0 {
+			fmt.Fprintf(o.Out, "%s: %s\n", dir, summary)
+			continue
+		}
+		failed++
+		fmt.Fprintf(o.Out, "%s: FAILED\n", dir)
+		for _, problem := range problems {
+			fmt.Fprintf(o.Out, "  %s\n", problem)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("verification of %d of %d director(ies) failed", failed, len(dirs))
+	}
+	return nil
+}
+
+// verify returns the differences of the directory with its manifest and the problems of the signature, or a summary
+// of what was verified.
+func (o *Options) verify(dir string) ([]string, string, error) {
+	m, err := manifest.Read(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	if m == nil {
+		return []string{fmt.Sprintf("no %s, the files can't be verified", manifest.FileName)}, "", nil
+	}
+	problems, err := m.Verify(dir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	signed, err := manifest.Signed(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	signature := "not signed"
+	switch {
+	case o.publicKey != nil:
+		if err := manifest.VerifySignature(dir, o.publicKey); err != nil {
+			problems = append(problems, err.Error())
+		}
+		signature = "signature valid"
+	case signed:
+		problems = append(problems, fmt.Sprintf("%s is signed, its signature needs the public key to be verified (--public-key)", manifest.FileName))
+	}
+	return problems, fmt.Sprintf("OK, %d file(s) collected at %s, %s", len(m.Files), m.CollectedAt.Format("2006-01-02 15:04:05 MST"), signature), nil
+}
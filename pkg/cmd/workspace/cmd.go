@@ -0,0 +1,213 @@
+package workspace
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/natamm4/audit-tool/pkg/workspace"
+)
+
+func NewCommand(ctx context.Context, f cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "workspace",
+		Short: "Manage named investigations grouping audit logs, saved queries, notes and bookmarks",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+
+	cmd.AddCommand(newCreateCommand(streams))
+	cmd.AddCommand(newUseCommand(streams))
+	cmd.AddCommand(newListCommand(streams))
+	cmd.AddCommand(newShowCommand(streams))
+	cmd.AddCommand(newDeleteCommand(streams))
+	cmd.AddCommand(newSaveQueryCommand(streams))
+	cmd.AddCommand(newNoteCommand(streams))
+	cmd.AddCommand(newBookmarkCommand(streams))
+
+	return cmd
+}
+
+func newCreateCommand(streams genericclioptions.IOStreams) *cobra.Command {
+	directory := ""
+	cmd := &cobra.Command{
+		Use:   "create NAME --dir DIRECTORY",
+		Short: "Create a new workspace and make it active",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(directory) == 0 {
+				cmdutil.CheckErr(fmt.Errorf("directory with audit files must be specified (--dir/-d)"))
+			}
+			w, err := workspace.Create(args[0], directory)
+			cmdutil.CheckErr(err)
+			cmdutil.CheckErr(workspace.SetActive(w.Name))
+			fmt.Fprintf(streams.Out, "Workspace %q created for %s and set as active\n", w.Name, w.Directory)
+		},
+	}
+	cmd.Flags().StringVarP(&directory, "dir", "d", "", "Directory with the audit files that belong to this workspace.")
+	return cmd
+}
+
+func newUseCommand(streams genericclioptions.IOStreams) *cobra.Command {
+	return &cobra.Command{
+		Use:   "use NAME",
+		Short: "Make the workspace active, use \"-\" to deactivate the current one",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			name := args[0]
+			if name == "-" {
+				name = ""
+			}
+			cmdutil.CheckErr(workspace.SetActive(name))
+			if len(name) == 0 {
+				fmt.Fprintln(streams.Out, "No workspace is active")
+				return
+			}
+			fmt.Fprintf(streams.Out, "Switched to workspace %q\n", name)
+		},
+	}
+}
+
+func newListCommand(streams genericclioptions.IOStreams) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List all workspaces",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			names, err := workspace.List()
+			cmdutil.CheckErr(err)
+			active, err := workspace.ActiveName()
+			cmdutil.CheckErr(err)
+
+			w := tabwriter.NewWriter(streams.Out, 0, 0, 2, ' ', 0)
+			defer w.Flush()
+			fmt.Fprintln(w, "ACTIVE\tNAME\tDIRECTORY")
+			for _, name := range names {
+				ws, err := workspace.Load(name)
+				cmdutil.CheckErr(err)
+				marker := ""
+				if name == active {
+					marker = "*"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\n", marker, ws.Name, ws.Directory)
+			}
+		},
+	}
+}
+
+func newShowCommand(streams genericclioptions.IOStreams) *cobra.Command {
+	return &cobra.Command{
+		Use:   "show [NAME]",
+		Short: "Show the workspace details, defaults to the active workspace",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ws, err := loadWorkspace(args)
+			cmdutil.CheckErr(err)
+
+			out := streams.Out
+			fmt.Fprintf(out, "Name:      %s\n", ws.Name)
+			fmt.Fprintf(out, "Directory: %s\n", ws.Directory)
+			fmt.Fprintf(out, "Created:   %s\n", ws.Created.Format(time.RFC3339))
+			if len(ws.Queries) > 0 {
+				fmt.Fprintln(out, "Queries:")
+				for _, name := range sets.StringKeySet(ws.Queries).List() {
+					fmt.Fprintf(out, "  %s: %s\n", name, strings.Join(ws.Queries[name], " "))
+				}
+			}
+			if len(ws.Notes) > 0 {
+				fmt.Fprintln(out, "Notes:")
+				for _, note := range ws.Notes {
+					fmt.Fprintf(out, "  [%s] %s\n", note.Time.Format(time.RFC3339), note.Text)
+				}
+			}
+			if len(ws.Bookmarks) > 0 {
+				fmt.Fprintln(out, "Bookmarks:")
+				for _, bookmark := range ws.Bookmarks {
+					fmt.Fprintf(out, "  %s %s\n", bookmark.AuditID, bookmark.Note)
+				}
+			}
+		},
+	}
+}
+
+func newDeleteCommand(streams genericclioptions.IOStreams) *cobra.Command {
+	return &cobra.Command{
+		Use:   "delete NAME",
+		Short: "Delete the workspace, the audit files are kept",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cmdutil.CheckErr(workspace.Delete(args[0]))
+			fmt.Fprintf(streams.Out, "Workspace %q deleted\n", args[0])
+		},
+	}
+}
+
+func newSaveQueryCommand(streams genericclioptions.IOStreams) *cobra.Command {
+	return &cobra.Command{
+		Use:   "save-query NAME -- [QUERY FLAGS]",
+		Short: "Save query flags in the active workspace, run them later with 'query --saved NAME'",
+		Args:  cobra.MinimumNArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			ws, err := loadWorkspace(nil)
+			cmdutil.CheckErr(err)
+			if ws.Queries == nil {
+				ws.Queries = map[string][]string{}
+			}
+			ws.Queries[args[0]] = args[1:]
+			cmdutil.CheckErr(ws.Save())
+			fmt.Fprintf(streams.Out, "Query %q saved in workspace %q\n", args[0], ws.Name)
+		},
+	}
+}
+
+func newNoteCommand(streams genericclioptions.IOStreams) *cobra.Command {
+	return &cobra.Command{
+		Use:   "note TEXT",
+		Short: "Add a note to the active workspace",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ws, err := loadWorkspace(nil)
+			cmdutil.CheckErr(err)
+			ws.AddNote(strings.Join(args, " "))
+			cmdutil.CheckErr(ws.Save())
+		},
+	}
+}
+
+func newBookmarkCommand(streams genericclioptions.IOStreams) *cobra.Command {
+	return &cobra.Command{
+		Use:   "bookmark AUDIT_ID...",
+		Short: "Bookmark audit events in the active workspace",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ws, err := loadWorkspace(nil)
+			cmdutil.CheckErr(err)
+			for _, auditID := range args {
+				ws.AddBookmark(auditID, "")
+			}
+			cmdutil.CheckErr(ws.Save())
+		},
+	}
+}
+
+func loadWorkspace(args []string) (*workspace.Workspace, error) {
+	if len(args) > 0 {
+		return workspace.Load(args[0])
+	}
+	ws, err := workspace.Active()
+	if err != nil {
+		return nil, err
+	}
+	if ws == nil {
+		return nil, fmt.Errorf("no workspace is active, create one with 'workspace create' or switch with 'workspace use'")
+	}
+	return ws, nil
+}
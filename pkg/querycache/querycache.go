@@ -0,0 +1,140 @@
+package querycache
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Entry records which lines of the audit files a query accepted, so that running the same query again only decodes
+// these lines instead of filtering all events.
+type Entry struct {
+	Created time.Time `json:"created"`
+	// Files maps the path of every audit file read to the ascending line numbers of the accepted events
+	Files map[string][]int `json:"files"`
+}
+
+// Dir returns the directory the query results are cached in. It can be overridden by AUDIT_TOOL_CACHE_DIR.
+func Dir() (string, error) {
+	if dir := os.Getenv("AUDIT_TOOL_CACHE_DIR"); len(dir) > 0 {
+		return dir, nil
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, "audit-tool", "queries"), nil
+}
+
+// Key returns the cache key of the parts, eg. the hash of the audit files and the fingerprint of the filters.
+func Key(parts ...string) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func entryFile(key string) (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, key+".json.gz"), nil
+}
+
+// Load returns the cached entry of the key, nil when there is none.
+func Load(key string) (*Entry, error) {
+	file, err := entryFile(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		// a corrupt entry is a cache miss, it is overwritten by the next Save
+		return nil, nil
+	}
+	entry := &Entry{}
+	if err := json.NewDecoder(r).Decode(entry); err != nil {
+		return nil, nil
+	}
+	return entry, nil
+}
+
+// Save stores the entry under the key. The entry is written to a temporary file first, so concurrent queries never
+// load a partially written entry.
+func Save(key string, entry *Entry) error {
+	file, err := entryFile(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := gzip.NewWriter(tmp)
+	if err := json.NewEncoder(w).Encode(entry); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// Clean removes all cached entries and returns how many entries and bytes were removed.
+func Clean() (int, int64, error) {
+	dir, err := Dir()
+	if err != nil {
+		return 0, 0, err
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	removed, size := 0, int64(0)
+	for _, e := range entries {
+		if e.IsDir() || !(strings.HasSuffix(e.Name(), ".json.gz") || strings.HasSuffix(e.Name(), ".tmp")) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return removed, size, err
+		}
+		if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+			return removed, size, err
+		}
+		if strings.HasSuffix(e.Name(), ".json.gz") {
+			removed++
+		}
+		size += info.Size()
+	}
+	return removed, size, nil
+}
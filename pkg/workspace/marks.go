@@ -0,0 +1,85 @@
+package workspace
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// MarksFileName is the file in the audit directory holding the marks made outside of a workspace.
+const MarksFileName = ".audit-tool-marks.json"
+
+// Marks maps audit IDs to the bookmarks made for them.
+type Marks map[string]Bookmark
+
+func (m Marks) Has(auditID string) bool {
+	_, ok := m[auditID]
+	return ok
+}
+
+// LoadMarks returns the marks made for the audit directory. These are the marks stored in the directory itself
+// and the bookmarks of the active workspace when it is the workspace for that directory.
+func LoadMarks(dir string) (Marks, error) {
+	marks, err := readMarksFile(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ws, err := Active()
+	if err != nil || ws == nil {
+		return marks, err
+	}
+	if sameDirectory(ws.Directory, dir) {
+		for _, bookmark := range ws.Bookmarks {
+			marks[bookmark.AuditID] = bookmark
+		}
+	}
+	return marks, nil
+}
+
+// AddDirectoryMark stores the mark in the marks file of the audit directory.
+func AddDirectoryMark(dir, auditID, note string) error {
+	marks, err := readMarksFile(dir)
+	if err != nil {
+		return err
+	}
+	bookmark, ok := marks[auditID]
+	if !ok {
+		bookmark = Bookmark{AuditID: auditID, Time: time.Now().UTC()}
+	}
+	if len(note) > 0 {
+		bookmark.Note = note
+	}
+	marks[auditID] = bookmark
+
+	data, err := json.MarshalIndent(marks, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, MarksFileName), data, 0644)
+}
+
+func readMarksFile(dir string) (Marks, error) {
+	marks := Marks{}
+	if len(dir) == 0 {
+		return marks, nil
+	}
+	data, err := os.ReadFile(filepath.Join(dir, MarksFileName))
+	if os.IsNotExist(err) {
+		return marks, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &marks); err != nil {
+		return nil, err
+	}
+	return marks, nil
+}
+
+func sameDirectory(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
@@ -0,0 +1,233 @@
+package workspace
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Workspace groups everything that belongs to a single investigation: the directory with the downloaded
+// audit logs, saved queries, free form notes and bookmarked audit events.
+type Workspace struct {
+	Name      string    `json:"name"`
+	Directory string    `json:"directory"`
+	Created   time.Time `json:"created"`
+
+	// Queries maps the saved query name to the query command line arguments.
+	Queries   map[string][]string `json:"queries,omitempty"`
+	Notes     []Note              `json:"notes,omitempty"`
+	Bookmarks []Bookmark          `json:"bookmarks,omitempty"`
+}
+
+type Note struct {
+	Time time.Time `json:"time"`
+	Text string    `json:"text"`
+}
+
+type Bookmark struct {
+	AuditID string    `json:"auditID"`
+	Time    time.Time `json:"time"`
+	Note    string    `json:"note,omitempty"`
+}
+
+// HomeDir returns the directory where the workspaces are stored. It can be overridden by AUDIT_TOOL_HOME.
+func HomeDir() (string, error) {
+	if dir := os.Getenv("AUDIT_TOOL_HOME"); len(dir) > 0 {
+		return dir, nil
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "audit-tool"), nil
+}
+
+func workspacesDir() (string, error) {
+	home, err := HomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "workspaces"), nil
+}
+
+func workspaceFile(name string) (string, error) {
+	if len(name) == 0 || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid workspace name %q", name)
+	}
+	dir, err := workspacesDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+".json"), nil
+}
+
+func activeFile() (string, error) {
+	home, err := HomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "active-workspace"), nil
+}
+
+// Create stores a new workspace. It fails when the workspace already exists.
+func Create(name, directory string) (*Workspace, error) {
+	file, err := workspaceFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(file); err == nil {
+		return nil, fmt.Errorf("workspace %q already exists", name)
+	}
+	absDirectory, err := filepath.Abs(directory)
+	if err != nil {
+		return nil, err
+	}
+	w := &Workspace{Name: name, Directory: absDirectory, Created: time.Now().UTC()}
+	return w, w.Save()
+}
+
+// Load reads the named workspace.
+func Load(name string) (*Workspace, error) {
+	file, err := workspaceFile(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("workspace %q does not exist", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	w := &Workspace{}
+	if err := json.Unmarshal(data, w); err != nil {
+		return nil, fmt.Errorf("unable to read workspace %q: %v", name, err)
+	}
+	return w, nil
+}
+
+// Save writes the workspace to disk.
+func (w *Workspace) Save() error {
+	file, err := workspaceFile(w.Name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(w, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0600)
+}
+
+// Delete removes the named workspace, the downloaded audit logs are left untouched.
+func Delete(name string) error {
+	file, err := workspaceFile(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(file); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("workspace %q does not exist", name)
+		}
+		return err
+	}
+	if active, err := ActiveName(); err == nil && active == name {
+		return SetActive("")
+	}
+	return nil
+}
+
+// List returns names of all workspaces, sorted.
+func List() ([]string, error) {
+	dir, err := workspacesDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(e.Name(), ".json"))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ActiveName returns the name of the active workspace or empty string when there is none.
+func ActiveName() (string, error) {
+	file, err := activeFile()
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Active returns the active workspace or nil when there is none.
+func Active() (*Workspace, error) {
+	name, err := ActiveName()
+	if err != nil || len(name) == 0 {
+		return nil, err
+	}
+	return Load(name)
+}
+
+// SetActive makes the named workspace active, empty name deactivates the current one.
+func SetActive(name string) error {
+	file, err := activeFile()
+	if err != nil {
+		return err
+	}
+	if len(name) == 0 {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if _, err := Load(name); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(file, []byte(name+"\n"), 0600)
+}
+
+// AddNote appends a note to the workspace.
+func (w *Workspace) AddNote(text string) {
+	w.Notes = append(w.Notes, Note{Time: time.Now().UTC(), Text: text})
+}
+
+// AddBookmark bookmarks the audit ID, bookmarking the same ID again only updates the note.
+func (w *Workspace) AddBookmark(auditID, note string) {
+	for i := range w.Bookmarks {
+		if w.Bookmarks[i].AuditID == auditID {
+			if len(note) > 0 {
+				w.Bookmarks[i].Note = note
+			}
+			return
+		}
+	}
+	w.Bookmarks = append(w.Bookmarks, Bookmark{AuditID: auditID, Time: time.Now().UTC(), Note: note})
+}